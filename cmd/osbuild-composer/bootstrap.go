@@ -0,0 +1,66 @@
+package main
+
+import (
+	"path"
+
+	"github.com/osbuild/osbuild-composer/internal/bootstrap"
+	"github.com/osbuild/osbuild-composer/internal/distro"
+	"github.com/osbuild/osbuild-composer/internal/logging"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+	"github.com/osbuild/osbuild-composer/internal/store"
+)
+
+// The directory to which the repository configuration that the distro ships
+// with is installed. It comes first in the paths composer reads it from.
+const repositoriesConfDir = "/etc/osbuild-composer"
+
+// runBootstrap prepares composer for its first use (see package bootstrap)
+// and writes the readiness report to $STATE_DIRECTORY/bootstrap.json.
+// Problems are logged, but don't keep composer from starting.
+func runBootstrap(s *store.Store, rpm rpmmd.RPMMD, d distro.Distro, arch distro.Arch, repoPaths []string, repos []rpmmd.RepoConfig, stateDir string) {
+	logger := logging.Default()
+
+	src := repositoriesPath(repoPaths, d.Name())
+	dst := path.Join(repositoriesConfDir, "repositories", d.Name()+".json")
+	installed := false
+	if src != "" && src != dst {
+		var err error
+		installed, err = bootstrap.InstallRepositories(src, dst)
+		if err != nil {
+			logger.Warning("cannot install repositories", "source", src, "destination", dst, "error", err)
+		}
+	}
+
+	report := bootstrap.Run(s, rpm, d, arch, repos)
+	report.Repositories = src
+	report.RepositoriesInstalled = installed
+	if installed {
+		report.Repositories = dst
+	}
+
+	for _, check := range report.RepositoryChecks {
+		if check.Error != "" {
+			logger.Warning("repository is not usable", "repository", check.Name, "error", check.Error)
+		}
+	}
+	for _, check := range report.BlueprintChecks {
+		if check.Created {
+			logger.Info("added example blueprint", "blueprint", check.Name)
+		}
+		if check.Error != "" {
+			logger.Warning("cannot resolve example blueprint", "blueprint", check.Name, "image_type", check.ImageType, "error", check.Error)
+		}
+	}
+
+	reportPath := path.Join(stateDir, "bootstrap.json")
+	err := report.WriteFile(reportPath)
+	if err != nil {
+		logger.Warning("cannot write bootstrap report", "path", reportPath, "error", err)
+	}
+
+	if report.Ready {
+		logger.Info("composer is ready", "report", reportPath)
+	} else {
+		logger.Warning("composer is not ready, see the bootstrap report", "report", reportPath)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/dbus"
+	"github.com/osbuild/osbuild-composer/internal/events"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+const (
+	dbusName      = "org.osbuild.Composer1"
+	dbusPath      = dbus.ObjectPath("/org/osbuild/Composer1")
+	dbusInterface = "org.osbuild.Composer1"
+)
+
+// dbusService offers blueprints and composes on a message bus, so that
+// desktop tools (and cockpit, through its D-Bus bridge) can follow composes
+// without polling the Weldr API. It emits ComposeStatusChanged whenever a
+// compose is queued, starts, finishes, fails, or is deleted.
+//
+// Composes are started through the Weldr API, so that they are subject to
+// the same checks as composes started over HTTP.
+type dbusService struct {
+	conn    *dbus.Conn
+	store   *store.Store
+	workers *worker.Server
+	weldr   http.Handler
+}
+
+// newDBusService connects to the "system" or "session" bus and exports the
+// service there.
+func newDBusService(bus string, store *store.Store, workers *worker.Server, weldr http.Handler) (*dbusService, error) {
+	var conn *dbus.Conn
+	var err error
+	switch bus {
+	case "system":
+		conn, err = dbus.SystemBus()
+	case "session":
+		conn, err = dbus.SessionBus()
+	default:
+		return nil, fmt.Errorf("unknown bus: %s", bus)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := &dbusService{conn, store, workers, weldr}
+	conn.Export(dbusPath, dbusInterface, dbus.Interface{
+		Methods: map[string]dbus.Method{
+			"ListBlueprints":   {Out: "as", Call: s.listBlueprints},
+			"GetBlueprint":     {In: "s", Out: "s", Call: s.getBlueprint},
+			"ListComposes":     {Out: "a(sss)", Call: s.listComposes},
+			"GetComposeStatus": {In: "s", Out: "s", Call: s.getComposeStatus},
+			"GetQueueStatus":   {Out: "uu", Call: s.getQueueStatus},
+			"StartCompose":     {In: "ss", Out: "s", Call: s.startCompose},
+		},
+		Signals: map[string]dbus.Signature{
+			"ComposeStatusChanged": "ss",
+		},
+	})
+
+	err = conn.RequestName(dbusName)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *dbusService) listBlueprints(args []interface{}) ([]interface{}, error) {
+	return []interface{}{s.store.ListBlueprints()}, nil
+}
+
+// getBlueprint returns the committed blueprint named by the first argument,
+// in TOML.
+func (s *dbusService) getBlueprint(args []interface{}) ([]interface{}, error) {
+	name := args[0].(string)
+	bp := s.store.GetBlueprintCommitted(name)
+	if bp == nil {
+		return nil, dbusError("UnknownBlueprint", "Unknown blueprint name: %s", name)
+	}
+
+	var b bytes.Buffer
+	err := toml.NewEncoder(&b).Encode(bp)
+	if err != nil {
+		return nil, err
+	}
+
+	return []interface{}{b.String()}, nil
+}
+
+type dbusCompose struct {
+	ID        string
+	Blueprint string
+	Status    string
+}
+
+// listComposes returns the id, blueprint name, and status of all composes,
+// oldest first.
+func (s *dbusService) listComposes(args []interface{}) ([]interface{}, error) {
+	type entry struct {
+		compose dbusCompose
+		queued  int64
+	}
+
+	var entries []entry
+	for id, c := range s.store.GetAllComposes() {
+		_, queued, _, _ := s.workers.ComposeState(c)
+		entries = append(entries, entry{s.composeEntry(id, c), queued.UnixNano()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].queued != entries[j].queued {
+			return entries[i].queued < entries[j].queued
+		}
+		return entries[i].compose.ID < entries[j].compose.ID
+	})
+
+	composes := []dbusCompose{}
+	for _, e := range entries {
+		composes = append(composes, e.compose)
+	}
+
+	return []interface{}{composes}, nil
+}
+
+func (s *dbusService) getComposeStatus(args []interface{}) ([]interface{}, error) {
+	id, err := uuid.Parse(args[0].(string))
+	if err != nil {
+		return nil, dbusError("UnknownUUID", "%s is not a valid build uuid", args[0])
+	}
+
+	c, exists := s.store.GetCompose(id)
+	if !exists {
+		return nil, dbusError("UnknownUUID", "%s is not a valid build uuid", id)
+	}
+
+	state, _, _, _ := s.workers.ComposeState(c)
+	return []interface{}{state.ToString()}, nil
+}
+
+// getQueueStatus returns the number of waiting and running composes.
+func (s *dbusService) getQueueStatus(args []interface{}) ([]interface{}, error) {
+	var waiting, running uint32
+	for _, c := range s.store.GetAllComposes() {
+		state, _, _, _ := s.workers.ComposeState(c)
+		switch state {
+		case common.CWaiting:
+			waiting++
+		case common.CRunning:
+			running++
+		}
+	}
+
+	return []interface{}{waiting, running}, nil
+}
+
+// startCompose starts a compose of the blueprint and image type given as
+// arguments and returns its id.
+func (s *dbusService) startCompose(args []interface{}) ([]interface{}, error) {
+	body, err := json.Marshal(map[string]string{
+		"blueprint_name": args[0].(string),
+		"compose_type":   args[1].(string),
+		"branch":         "master",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest("POST", "/api/v1/compose", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response := newResponseBuffer()
+	s.weldr.ServeHTTP(response, request)
+
+	var reply struct {
+		BuildID uuid.UUID `json:"build_id"`
+		Errors  []struct {
+			ID  string `json:"id"`
+			Msg string `json:"msg"`
+		} `json:"errors"`
+	}
+	err = json.Unmarshal(response.body.Bytes(), &reply)
+	if err != nil {
+		return nil, fmt.Errorf("cannot start compose: %v", err)
+	}
+	if response.status != http.StatusOK {
+		if len(reply.Errors) > 0 {
+			return nil, dbusError(reply.Errors[0].ID, "%s", reply.Errors[0].Msg)
+		}
+		return nil, fmt.Errorf("cannot start compose: got status %d", response.status)
+	}
+
+	return []interface{}{reply.BuildID.String()}, nil
+}
+
+// Emit turns composer's events into ComposeStatusChanged signals.
+func (s *dbusService) Emit(event events.Event) error {
+	var id uuid.UUID
+	var status string
+
+	switch event.Type {
+	case events.ComposeQueued:
+		var err error
+		id, err = uuid.Parse(event.Fields["COMPOSE_ID"])
+		if err != nil {
+			return nil
+		}
+		if _, exists := s.store.GetCompose(id); !exists {
+			// composes of other APIs
+			return nil
+		}
+		status = common.CWaiting.ToString()
+
+	case events.ComposeDeleted:
+		var err error
+		id, err = uuid.Parse(event.Fields["COMPOSE_ID"])
+		if err != nil {
+			return nil
+		}
+		status = "DELETED"
+
+	case events.JobAssigned, events.JobFinished, events.JobFailed:
+		jobID, err := uuid.Parse(event.Fields["JOB_ID"])
+		if err != nil {
+			return nil
+		}
+		var c *dbusCompose
+		id, c = s.composeOfJob(jobID)
+		if c == nil {
+			// jobs of other APIs
+			return nil
+		}
+		status = c.Status
+
+	default:
+		return nil
+	}
+
+	return s.conn.Emit(dbusPath, dbusInterface, "ComposeStatusChanged", id.String(), status)
+}
+
+func (s *dbusService) composeEntry(id uuid.UUID, c compose.Compose) dbusCompose {
+	state, _, _, _ := s.workers.ComposeState(c)
+	entry := dbusCompose{ID: id.String(), Status: state.ToString()}
+	if c.Blueprint != nil {
+		entry.Blueprint = c.Blueprint.Name
+	}
+	return entry
+}
+
+// composeOfJob returns the compose that ran `jobID`, if there is one.
+func (s *dbusService) composeOfJob(jobID uuid.UUID) (uuid.UUID, *dbusCompose) {
+	id, c, exists := s.store.GetComposeOfJob(jobID)
+	if !exists {
+		return uuid.Nil, nil
+	}
+	entry := s.composeEntry(id, c)
+	return id, &entry
+}
+
+// dbusError returns an error named after the Weldr API's error `id`.
+func dbusError(id, format string, args ...interface{}) error {
+	return &dbus.Error{
+		Name:    dbusInterface + ".Error." + id,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// responseBuffer is a http.ResponseWriter that keeps the response in memory.
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{
+		header: make(http.Header),
+		status: http.StatusOK,
+	}
+}
+
+func (r *responseBuffer) Header() http.Header {
+	return r.header
+}
+
+func (r *responseBuffer) Write(data []byte) (int, error) {
+	return r.body.Write(data)
+}
+
+func (r *responseBuffer) WriteHeader(status int) {
+	r.status = status
+}
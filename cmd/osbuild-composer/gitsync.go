@@ -0,0 +1,29 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/osbuild/osbuild-composer/internal/config"
+	"github.com/osbuild/osbuild-composer/internal/weldr"
+)
+
+// newGitSyncTask returns a maintenance task that synchronizes blueprints
+// from the git repository configured by the TOML file at `gitSyncConfigPath`.
+func newGitSyncTask(gitSyncConfigPath string, api *weldr.API, effective *config.Effective) maintenanceTask {
+	gitSyncConfig, err := weldr.LoadGitSyncConfig(gitSyncConfigPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	effective.SetFile("gitsync", gitSyncConfigPath, gitSyncConfig)
+
+	api.SetGitSync(gitSyncConfig)
+
+	return maintenanceTask{
+		name:     "gitsync",
+		interval: time.Minute,
+		run: func() error {
+			return api.RunGitSync(time.Now())
+		},
+	}
+}
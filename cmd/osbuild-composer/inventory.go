@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/osbuild/osbuild-composer/internal/config"
+	"github.com/osbuild/osbuild-composer/internal/inventory"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+// newInventoryTask returns a maintenance task that exports finished composes
+// to the inventory system at `url`. `mappingPath` may be empty, in which case
+// records are posted unchanged.
+func newInventoryTask(url, mappingPath string, tenants *store.Tenants, workers *worker.Server, effective *config.Effective) maintenanceTask {
+	var mapping map[string]string
+	if mappingPath != "" {
+		var err error
+		mapping, err = inventory.LoadMapping(mappingPath)
+		if err != nil {
+			log.Fatalf("cannot load inventory mapping: %v", err)
+		}
+		effective.SetFile("inventory-mapping", mappingPath, mapping)
+	}
+
+	exporter, err := inventory.NewWebhookExporter(url, mapping)
+	if err != nil {
+		log.Fatalf("invalid inventory mapping: %v", err)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("cannot determine hostname: %v", err)
+	}
+
+	return maintenanceTask{
+		name:     "inventory export",
+		interval: time.Minute,
+		run: func() error {
+			var firstErr error
+			for _, s := range tenants.All() {
+				err := inventory.NewPusher(exporter, s, workers, hostname).Push()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+			}
+			return firstErr
+		},
+	}
+}
@@ -1,23 +1,41 @@
 package main
 
 import (
-	"crypto/tls"
-	"crypto/x509"
+	"context"
 	"flag"
-	"io/ioutil"
+	"io"
 	"log"
+	"net"
 	"os"
 	"path"
+	"time"
 
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/cloudapi"
+	"github.com/osbuild/osbuild-composer/internal/config"
 	"github.com/osbuild/osbuild-composer/internal/distro/fedora30"
 	"github.com/osbuild/osbuild-composer/internal/distro/fedora31"
 	"github.com/osbuild/osbuild-composer/internal/distro/fedora32"
 	"github.com/osbuild/osbuild-composer/internal/distro/rhel81"
 	"github.com/osbuild/osbuild-composer/internal/distro/rhel82"
 	"github.com/osbuild/osbuild-composer/internal/distro/rhel83"
+	"github.com/osbuild/osbuild-composer/internal/events"
+	"github.com/osbuild/osbuild-composer/internal/gallery"
 	"github.com/osbuild/osbuild-composer/internal/jobqueue/fsjobqueue"
+	"github.com/osbuild/osbuild-composer/internal/lease"
+	"github.com/osbuild/osbuild-composer/internal/logging"
+	"github.com/osbuild/osbuild-composer/internal/promotion"
+	"github.com/osbuild/osbuild-composer/internal/quota"
 	"github.com/osbuild/osbuild-composer/internal/rcm"
-
+	"github.com/osbuild/osbuild-composer/internal/replication"
+	"github.com/osbuild/osbuild-composer/internal/scan"
+	"github.com/osbuild/osbuild-composer/internal/signing"
+	"github.com/osbuild/osbuild-composer/internal/upload"
+
+	"github.com/osbuild/osbuild-composer/internal/admission"
+	"github.com/osbuild/osbuild-composer/internal/audit"
+	"github.com/osbuild/osbuild-composer/internal/auth"
 	"github.com/osbuild/osbuild-composer/internal/common"
 	"github.com/osbuild/osbuild-composer/internal/distro"
 	"github.com/osbuild/osbuild-composer/internal/rpmmd"
@@ -28,44 +46,113 @@ import (
 	"github.com/coreos/go-systemd/activation"
 )
 
-type connectionConfig struct {
-	CACertFile     string
-	ServerKeyFile  string
-	ServerCertFile string
-}
-
-func createTLSConfig(c *connectionConfig) (*tls.Config, error) {
-	caCertPEM, err := ioutil.ReadFile(c.CACertFile)
-	if err != nil {
-		return nil, err
-	}
-
-	roots := x509.NewCertPool()
-	ok := roots.AppendCertsFromPEM(caCertPEM)
-	if !ok {
-		panic("failed to parse root certificate")
-	}
-
-	cert, err := tls.LoadX509KeyPair(c.ServerCertFile, c.ServerKeyFile)
-	if err != nil {
-		return nil, err
+// repositoriesPath returns the path of the repository configuration which
+// rpmmd.LoadRepositories() reads for `distro`.
+func repositoriesPath(confPaths []string, distro string) string {
+	for _, confPath := range confPaths {
+		p := path.Join(confPath, "repositories", distro+".json")
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
 	}
-	return &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		ClientAuth:   tls.RequireAndVerifyClientCert,
-		ClientCAs:    roots,
-	}, nil
+	return ""
 }
 
 func main() {
 	var verbose bool
-	flag.BoolVar(&verbose, "v", false, "Print access log")
+	var bootstrap bool
+	var queueDir string
+	var leasePath string
+	var standbyURL string
+	var electionPath string
+	var inventoryURL string
+	var inventoryMapping string
+	var workerTLS worker.TLSConfig
+	var workerSigningKeyPath string
+	var emailConfigPath string
+	var scanConfigPath string
+	var signingConfigPath string
+	var admissionConfigPath string
+	var authConfigPath string
+	var quotaConfigPath string
+	var promotionStagesPath string
+	var nightlyConfigPath string
+	var rebuildConfigPath string
+	var gitSyncConfigPath string
+	var socketsConfigPath string
+	var dbusBus string
+	var workspaceTTL time.Duration
+	var workspaceWarning time.Duration
+	var historyDepth int
+	var retention store.RetentionPolicy
+	var diskQuota int64
+	var pullRate int64
+	var localityWait time.Duration
+	var drainTimeout time.Duration
+	var metadataTTL time.Duration
+	var depsolver string
+	var artifactEncoding store.ArtifactEncoding
+	var artifactKeyPath string
+	var logFormat string
+	var logLevel string
+	flag.BoolVar(&verbose, "v", false, "Print access log (implies -log-level debug)")
+	flag.BoolVar(&bootstrap, "bootstrap", false, "Prepare for first use before serving: install the distro's repositories to /etc/osbuild-composer/repositories, add example blueprints, check that they can be depsolved, and write a readiness report to $STATE_DIRECTORY/bootstrap.json (existing repositories and blueprints are kept)")
+	flag.StringVar(&logFormat, "log-format", "text", "Format of log records: text or json")
+	flag.StringVar(&logLevel, "log-level", "info", "Minimum level of log records: debug, info, warning, or error")
+	flag.StringVar(&queueDir, "queue", "", "Directory of the job queue (default: $STATE_DIRECTORY/jobs)")
+	flag.StringVar(&leasePath, "lease", "", "Experimental: path of a lease file shared with standby instances")
+	flag.StringVar(&standbyURL, "standby", "", "Experimental: run as standby, replicating state from the active instance at this https URL, authenticating with -worker-cert (requires -lease)")
+	flag.StringVar(&inventoryURL, "inventory-url", "", "URL of an inventory system to which records of finished composes are posted")
+	flag.StringVar(&inventoryMapping, "inventory-mapping", "", "JSON file mapping the fields posted to the inventory system to fields of compose records")
+	flag.StringVar(&emailConfigPath, "notify-email", "", "TOML file configuring email notifications about finished composes")
+	flag.StringVar(&scanConfigPath, "scan", "", "TOML file configuring a vulnerability scanner, which is run on every image built through the Weldr API")
+	flag.StringVar(&signingConfigPath, "sign", "", "TOML file configuring a gpg or sigstore key, with which every image built through the Weldr API is signed")
+	flag.StringVar(&promotionStagesPath, "promotion-stages", "", "JSON file configuring the stages (sets of upload targets) to which finished composes can be promoted")
+	flag.StringVar(&rebuildConfigPath, "rebuild", "", "TOML file configuring blueprints which are rebuilt automatically when they are committed or their repositories change")
+	flag.StringVar(&gitSyncConfigPath, "gitsync", "", "TOML file configuring a git repository of blueprints, which are committed whenever they change there")
+	flag.StringVar(&nightlyConfigPath, "nightly", "", "TOML file configuring a nightly pipeline, which rebuilds blueprints against a snapshot of the repositories, tests the images, and publishes those that pass")
+	flag.StringVar(&socketsConfigPath, "sockets", "", "TOML file configuring additional unix sockets and TLS addresses, each serving some surfaces of the API (weldr-v0, weldr-v1, admin, metrics) with its own permissions")
+	flag.StringVar(&dbusBus, "dbus", "", "Offer blueprints and composes as org.osbuild.Composer1 on this message bus: system or session (default: none)")
+	flag.DurationVar(&workspaceTTL, "workspace-ttl", 0, "Remove workspace copies of blueprints that haven't been changed for this long (default: keep them forever)")
+	flag.DurationVar(&workspaceWarning, "workspace-warning", 24*time.Hour, "Warn this long before a workspace copy is removed")
+	flag.IntVar(&historyDepth, "blueprint-history-depth", 100, "Keep the changes of each blueprint which are tagged and this many latest ones, archiving older ones in $STATE_DIRECTORY/changes-archive (0: keep all changes)")
+	flag.DurationVar(&retention.MaxAge, "retention-max-age", 0, "Delete finished and failed composes this long after they were done (default: keep them forever)")
+	flag.IntVar(&retention.MaxCount, "retention-max-count", 0, "Keep at most this many finished and failed composes, deleting the oldest ones first")
+	flag.Int64Var(&retention.MaxDiskUsage, "retention-max-disk-usage", 0, "Delete the oldest finished and failed composes while the outputs of all composes take up more than this many bytes")
+	flag.Int64Var(&diskQuota, "disk-quota", 0, "Refuse new composes when the outputs of all composes could take up more than this many bytes (default: no quota)")
+	flag.Int64Var(&pullRate, "image-pull-rate", 0, "Download images that workers offer for pulling at no more than this many bytes per second (default: no limit)")
+	flag.DurationVar(&metadataTTL, "metadata-ttl", 5*time.Minute, "Reuse cached repository metadata and depsolve results for this long without checking whether the repositories changed")
+	flag.StringVar(&depsolver, "depsolver", rpmmd.DepsolverDNF, "Depsolver to read repositories and solve dependencies with: dnf, native (experimental, doesn't need dnf), or auto (native if dnf is not available)")
+	flag.DurationVar(&drainTimeout, "drain-timeout", 5*time.Minute, "On SIGTERM, stop accepting composes and wait this long for running jobs to finish before exiting; jobs still running continue when composer is back")
+	flag.DurationVar(&localityWait, "locality-wait", worker.DefaultLocalityWait, "Reserve jobs for workers in the region of their upload target while one of them asked for a job within this long")
+	flag.StringVar(&artifactEncoding.Compression, "artifact-compression", "", "Compress images and checkpoints stored in outputs/: gzip, xz, or zstd (default: no compression)")
+	flag.StringVar(&artifactKeyPath, "artifact-key", "", "File containing a 32-byte AES-256 key (raw or hex) with which images and checkpoints stored in outputs/ are encrypted")
+	flag.StringVar(&admissionConfigPath, "admission", "", "TOML file configuring rules which composes requested through the Weldr API must satisfy")
+	flag.StringVar(&quotaConfigPath, "quota", "", "TOML file configuring how many composes each tenant and user may run and keep")
+	flag.StringVar(&authConfigPath, "auth", "", "TOML file configuring tokens or an OpenID Connect provider, with which clients of the Weldr API over TCP and of the RCM API must authenticate")
+	flag.StringVar(&workerTLS.CACertFile, "worker-ca", "/etc/osbuild-composer/ca-crt.pem", "CA certificate that signed the certificates of remote workers")
+	flag.StringVar(&workerTLS.CertFile, "worker-cert", "/etc/osbuild-composer/composer-crt.pem", "Certificate presented to remote workers")
+	flag.StringVar(&workerTLS.KeyFile, "worker-key", "/etc/osbuild-composer/composer-key.pem", "Private key of -worker-cert")
+	flag.StringVar(&workerSigningKeyPath, "worker-signing-key", "", "File containing a key shared with workers, with which they must sign all requests (see osbuild-worker -signing-key)")
+	flag.StringVar(&electionPath, "election", "", "Path of a lease file shared by all replicas using the same job queue; only the replica holding it runs maintenance tasks")
 	flag.Parse()
 
+	effective := config.New()
+	effective.AddFlags(flag.CommandLine)
+
+	if standbyURL != "" && leasePath == "" {
+		log.Fatal("-standby requires -lease")
+	}
+
+	journal := events.NewJournalEmitter()
+	broadcaster := events.NewBroadcaster()
+	events.SetEmitter(events.Multi(journal, broadcaster))
+
 	stateDir, ok := os.LookupEnv("STATE_DIRECTORY")
 	if !ok {
 		log.Fatal("STATE_DIRECTORY is not set. Is the service file missing StateDirectory=?")
 	}
+	effective.Set("env.STATE_DIRECTORY", stateDir, config.SourceEnvironment)
 
 	listeners, err := activation.ListenersWithNames()
 	if err != nil {
@@ -89,8 +176,13 @@ func main() {
 	if !ok {
 		log.Fatal("CACHE_DIRECTORY is not set. Is the service file missing CacheDirectory=?")
 	}
+	effective.Set("env.CACHE_DIRECTORY", cacheDirectory, config.SourceEnvironment)
 
-	rpm := rpmmd.NewRPMMD(path.Join(cacheDirectory, "rpmmd"))
+	depsolverRPMMD, err := rpmmd.NewRPMMDFor(depsolver, path.Join(cacheDirectory, "rpmmd"))
+	if err != nil {
+		log.Fatalf("Invalid -depsolver: %v", err)
+	}
+	rpm := rpmmd.NewCached(depsolverRPMMD, metadataTTL)
 
 	distros, err := distro.NewRegistry(fedora30.New(), fedora31.New(), fedora32.New(), rhel81.New(), rhel82.New(), rhel83.New())
 	if err != nil {
@@ -107,19 +199,81 @@ func main() {
 		log.Fatalf("Host distro does not support host architecture: " + err.Error())
 	}
 
-	repoMap, err := rpmmd.LoadRepositories([]string{"/etc/osbuild-composer", "/usr/share/osbuild-composer"}, distribution.Name())
+	repoPaths := []string{"/etc/osbuild-composer", "/usr/share/osbuild-composer"}
+	repoMap, err := rpmmd.LoadRepositories(repoPaths, distribution.Name())
 	if err != nil {
 		log.Fatalf("Could not load repositories for %s: %v", distribution.Name(), err)
 	}
+	effective.SetFile("repositories", repositoriesPath(repoPaths, distribution.Name()), repoMap[common.CurrentArch()])
 
 	var logger *log.Logger
 	if verbose {
 		logger = log.New(os.Stdout, "", 0)
+		logLevel = "debug"
+	}
+
+	format, err := logging.ParseFormat(logFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+	level, err := logging.ParseLevel(logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logging.SetDefault(logging.New(os.Stderr, format, level))
+
+	if artifactKeyPath != "" {
+		artifactEncoding.Key, err = store.ReadArtifactKey(artifactKeyPath)
+		if err != nil {
+			log.Fatalf("cannot read artifact key: %v", err)
+		}
 	}
 
 	store := store.New(&stateDir)
+	err = store.SetArtifactEncoding(artifactEncoding)
+	if err != nil {
+		log.Fatalf("invalid artifact encoding: %v", err)
+	}
+	err = store.SetBlueprintHistoryDepth(historyDepth)
+	if err != nil {
+		log.Fatalf("cannot limit blueprint history: %v", err)
+	}
+
+	// Workers refer to composes and jobs of all tenants
+	tenants := newTenants(store, artifactEncoding, historyDepth)
+
+	// Only one instance may use the job queue at any time. In high
+	// availability mode, that's the one holding the lease. Standby
+	// instances keep their store in sync until they can take over.
+	if leasePath != "" {
+		leaderLease := newLease(leasePath)
+
+		var epoch uint64
+		if standbyURL != "" {
+			log.Printf("Running as standby for %s", standbyURL)
+			tlsConfig, err := workerTLS.ClientConfig()
+			if err != nil {
+				log.Fatalf("TLS configuration for replication cannot be created: %v", err)
+			}
+			epoch = waitForTakeover(leaderLease, replication.NewFollower(standbyURL, tenants, tlsConfig))
+		} else {
+			epoch, err = leaderLease.Acquire()
+			if err != nil {
+				log.Fatalf("cannot acquire lease: %v", err)
+			}
+		}
+		log.Printf("Acquired lease %s (epoch %d)", leasePath, epoch)
 
-	queueDir := path.Join(stateDir, "jobs")
+		go keepLease(leaderLease, epoch)
+	}
+
+	if bootstrap {
+		runBootstrap(store, rpm, distribution, arch, repoPaths, repoMap[common.CurrentArch()], stateDir)
+	}
+
+	if queueDir == "" {
+		queueDir = path.Join(stateDir, "jobs")
+	}
 	err = os.Mkdir(queueDir, 0700)
 	if err != nil && !os.IsExist(err) {
 		log.Fatalf("cannot create queue directory: %v", err)
@@ -136,8 +290,191 @@ func main() {
 		log.Fatalf("cannot create output directory: %v", err)
 	}
 
-	workers := worker.NewServer(logger, jobs, store.AddImageToImageUpload)
+	uploadDir := path.Join(stateDir, "uploads")
+	err = os.Mkdir(uploadDir, 0700)
+	if err != nil && !os.IsExist(err) {
+		log.Fatalf("cannot create upload directory: %v", err)
+	}
+
+	auditLog, err := audit.Open(path.Join(stateDir, "audit.log"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var election *lease.Election
+	if electionPath != "" {
+		election = lease.NewElection(newLease(electionPath))
+		go election.Run(context.Background())
+	}
+
+	workers := worker.NewServer(logging.Default(), jobs, tenants.AddImageToImageUpload, uploadDir)
+	workers.SetDistros(distros.List())
+	workers.SetCheckpointWriter(tenants.AddCheckpointToImageBuild)
+	workers.SetImageSizeLimit(tenants.GetImageBuildSize)
+	workers.SetImageFormatCheck(tenants.GetImageBuildFilename)
+	workers.SetConversionInput(func(composeID uuid.UUID, input string) (io.ReadCloser, int64, error) {
+		// Upload jobs of conversions upload the converted image
+		if input == worker.ConversionInputImage {
+			return tenants.GetImageBuildImage(composeID, 0)
+		}
+		return tenants.GetConversionInput(composeID)
+	})
+	workers.SetPayloadSource(tenants.GetJobImage)
+	workers.SetWorkerRecorders(tenants.SetImageBuildUploader, tenants.SetJobFinisher)
+	workers.SetPullRateLimit(pullRate)
+	workers.SetAuditLog(auditLog)
+	workers.SetLocalityWait(localityWait)
+	if workerSigningKeyPath != "" {
+		key, err := worker.ReadSigningKey(workerSigningKeyPath)
+		if err != nil {
+			log.Fatalf("Could not read the worker signing key: %v", err)
+		}
+		workers.SetSigningKey(key)
+	}
+	workers.SetUploadsRecorder(func(jobID uuid.UUID, results []worker.TargetResult, when time.Time) error {
+		for _, r := range results {
+			err := tenants.UploadFinished(jobID, r.Name, r.Profile, when, r.Duration, r.Error)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	// Scans, signing, and promotions are run by composer itself
+	runners := newJobRunners()
+
+	if scanConfigPath != "" {
+		config, err := scan.LoadConfig(scanConfigPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		effective.SetFile("scan", scanConfigPath, config)
+		scanner, err := scan.NewScanner(*config)
+		if err != nil {
+			log.Fatalf("invalid scanner configuration: %v", err)
+		}
+
+		workers.EnableScans()
+		runner := scan.NewRunner(jobs, tenants, scanner)
+		runner.SetDurationRecorder(workers.RecordPhaseDuration)
+		runners.start("Scanner", runner.Run)
+	}
+
+	if signingConfigPath != "" {
+		config, err := signing.LoadConfig(signingConfigPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		effective.SetFile("sign", signingConfigPath, config)
+		signer, err := signing.NewSigner(*config)
+		if err != nil {
+			log.Fatalf("invalid signing configuration: %v", err)
+		}
+
+		workers.EnableSigning()
+		runner := signing.NewRunner(jobs, tenants, signer)
+		runner.SetDurationRecorder(workers.RecordPhaseDuration)
+		runners.start("Signer", runner.Run)
+	}
+
+	// Tasks that must not run concurrently on several replicas
+	var maintenanceTasks []maintenanceTask
+
+	if inventoryURL != "" {
+		maintenanceTasks = append(maintenanceTasks, newInventoryTask(inventoryURL, inventoryMapping, tenants, workers, effective))
+	}
+
+	if emailConfigPath != "" {
+		maintenanceTasks = append(maintenanceTasks, newNotifyTask(emailConfigPath, tenants, workers, effective))
+	}
+
+	maintenanceTasks = append(maintenanceTasks, newWebhookTask(tenants, workers))
+
+	if !retention.IsZero() {
+		maintenanceTasks = append(maintenanceTasks, newRetentionTask(tenants, workers, retention))
+	}
+
+	if workspaceTTL > 0 {
+		maintenanceTasks = append(maintenanceTasks, newWorkspaceTask(tenants, workspaceTTL, workspaceWarning))
+	}
+
 	weldrAPI := weldr.New(rpm, arch, distribution, repoMap[common.CurrentArch()], logger, store, workers)
+	weldrAPI.SetArchRepositories(repoMap)
+	weldrAPI.SetTenants(tenants)
+
+	var admissionController admission.Controller
+	if admissionConfigPath != "" {
+		config, err := admission.LoadConfig(admissionConfigPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		effective.SetFile("admission", admissionConfigPath, config)
+		admissionController = config.Controller()
+		weldrAPI.SetAdmission(admissionController)
+	}
+
+	if quotaConfigPath != "" {
+		config, err := quota.LoadConfig(quotaConfigPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		effective.SetFile("quota", quotaConfigPath, config)
+		weldrAPI.SetQuotas(config)
+	}
+
+	var authenticator *auth.Authenticator
+	if authConfigPath != "" {
+		config, err := auth.LoadConfig(authConfigPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		effective.SetFile("auth", authConfigPath, config)
+		authenticator = config.Authenticator()
+		weldrAPI.SetAuthenticator(authenticator)
+	}
+
+	weldrAPI.SetMetadataCache(rpm)
+	weldrAPI.SetWorkspaceTTL(workspaceTTL)
+	weldrAPI.SetDiskQuota(diskQuota)
+	weldrAPI.SetEffectiveConfig(effective)
+	weldrAPI.SetEventBroadcaster(broadcaster)
+	weldrAPI.SetAuditLog(auditLog)
+
+	if promotionStagesPath != "" {
+		stages, err := weldr.LoadPromotionStages(promotionStagesPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		effective.SetFile("promotion-stages", promotionStagesPath, stages)
+		weldrAPI.SetPromotionStages(stages)
+
+		runners.start("Promotion runner", promotion.NewRunner(jobs, tenants, upload.Upload).Run)
+	}
+
+	// The nightly pipeline may publish to promotion stages, which must be
+	// set up before
+	if nightlyConfigPath != "" {
+		maintenanceTasks = append(maintenanceTasks, newNightlyTask(nightlyConfigPath, weldrAPI, effective))
+	}
+
+	if gitSyncConfigPath != "" {
+		maintenanceTasks = append(maintenanceTasks, newGitSyncTask(gitSyncConfigPath, weldrAPI, effective))
+	}
+
+	if rebuildConfigPath != "" {
+		maintenanceTasks = append(maintenanceTasks, newRebuildTask(rebuildConfigPath, weldrAPI, effective))
+	}
+
+	runMaintenance(election, maintenanceTasks)
+
+	if dbusBus != "" {
+		service, err := newDBusService(dbusBus, store, workers, weldrAPI)
+		if err != nil {
+			log.Fatalf("cannot offer D-Bus service: %v", err)
+		}
+		events.SetEmitter(events.Multi(journal, broadcaster, service))
+	}
 
 	go func() {
 		err := workers.Serve(jobListener)
@@ -152,6 +489,9 @@ func main() {
 		}
 		rcmListener := rcmApiListeners[0]
 		rcmAPI := rcm.New(logger, workers, rpm, distros)
+		if authenticator != nil {
+			rcmAPI.SetAuthenticator(authenticator)
+		}
 		go func() {
 			err := rcmAPI.Serve(rcmListener)
 			// If the RCM API fails, take down the whole process, not just a single gorutine
@@ -160,29 +500,110 @@ func main() {
 
 	}
 
+	// Optionally serve the cloud API
+	if cloudAPIListeners, exists := listeners["osbuild-cloudapi.socket"]; exists {
+		if len(cloudAPIListeners) != 1 {
+			log.Fatal("The cloud API socket unit is misconfigured. It should contain only one socket.")
+		}
+		cloudAPIListener := cloudAPIListeners[0]
+		cloudAPI := cloudapi.NewServer(logger, workers, rpm, distros, tenants)
+		if authenticator != nil {
+			cloudAPI.SetAuthenticator(authenticator)
+		}
+		if admissionController != nil {
+			cloudAPI.SetAdmission(admissionController)
+		}
+		go func() {
+			err := cloudAPI.Serve(cloudAPIListener)
+			log.Fatal("Cloud API failed: ", err)
+		}()
+	}
+
+	// Optionally serve the gallery of published images
+	if galleryListeners, exists := listeners["osbuild-gallery.socket"]; exists {
+		if len(galleryListeners) != 1 {
+			log.Fatal("The gallery socket unit is misconfigured. It should contain only one socket.")
+		}
+		galleryListener := galleryListeners[0]
+		imageGallery := gallery.New(logger, tenants)
+		go func() {
+			err := imageGallery.Serve(galleryListener)
+			log.Fatal("Gallery failed: ", err)
+		}()
+	}
+
+	// Optionally serve the state to standby instances
+	if replicationListeners, exists := listeners["osbuild-replication.socket"]; exists {
+		if len(replicationListeners) != 1 {
+			log.Fatal("The replication socket unit is misconfigured. It should contain only one socket.")
+		}
+		replicationListener := replicationListeners[0]
+		replicationServer := replication.NewServer(logger, tenants)
+
+		// Snapshots contain credentials, so standbys authenticate like
+		// remote workers
+		tlsConfig, err := workerTLS.ServerConfig()
+		if err != nil {
+			log.Fatalf("TLS configuration for replication cannot be created: %v", err)
+		}
+		go func() {
+			err := replicationServer.ServeTLS(replicationListener, tlsConfig)
+			log.Fatal("Replication server failed: ", err)
+		}()
+	}
+
 	if remoteWorkerListeners, exists := listeners["osbuild-remote-worker.socket"]; exists {
+		tlsConfig, err := workerTLS.ServerConfig()
+		if err != nil {
+			log.Fatalf("TLS configuration cannot be created: " + err.Error())
+		}
+
 		for _, listener := range remoteWorkerListeners {
 			log.Printf("Starting remote listener\n")
 
-			tlsConfig, err := createTLSConfig(&connectionConfig{
-				CACertFile:     "/etc/osbuild-composer/ca-crt.pem",
-				ServerKeyFile:  "/etc/osbuild-composer/composer-key.pem",
-				ServerCertFile: "/etc/osbuild-composer/composer-crt.pem",
-			})
+			go func(listener net.Listener) {
+				err := workers.ServeTLS(listener, tlsConfig)
+				common.PanicOnError(err)
+			}(listener)
+		}
+	}
+
+	if socketsConfigPath != "" {
+		config, err := weldr.LoadSocketsConfig(socketsConfigPath, authenticator != nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		effective.SetFile("sockets", socketsConfigPath, config)
 
+		for _, socket := range config.Sockets {
+			listener, err := socket.Listen()
 			if err != nil {
-				log.Fatalf("TLS configuration cannot be created: " + err.Error())
+				log.Fatalf("cannot create socket %s: %v", socket.Path, err)
 			}
 
-			listener := tls.NewListener(listener, tlsConfig)
-			go func() {
-				err := workers.Serve(listener)
+			go func(listener net.Listener, surfaces []string) {
+				err := weldrAPI.ServeSurfaces(listener, surfaces)
 				common.PanicOnError(err)
-			}()
+			}(listener, socket.Surfaces)
+		}
+
+		for _, socket := range config.TLS {
+			listener, err := socket.Listen()
+			if err != nil {
+				log.Fatalf("cannot listen on %s: %v", socket.Address, err)
+			}
+
+			go func(listener net.Listener, surfaces []string) {
+				err := weldrAPI.ServeSurfaces(listener, surfaces)
+				common.PanicOnError(err)
+			}(listener, socket.Surfaces)
 		}
 	}
 
-	err = weldrAPI.Serve(weldrListener)
-	common.PanicOnError(err)
+	go func() {
+		err := weldrAPI.Serve(weldrListener)
+		common.PanicOnError(err)
+	}()
 
+	waitForShutdown(drainTimeout, weldrAPI, workers, runners)
 }
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/osbuild/osbuild-composer/internal/lease"
+)
+
+// A maintenanceTask is a duty that is run periodically, but only by one of the
+// replicas that share a job queue.
+type maintenanceTask struct {
+	name     string
+	interval time.Duration
+	run      func() error
+}
+
+// runMaintenance runs each of `tasks` in its own goroutine. Replicas that are
+// not the leader of `election` skip their turn. `election` may be nil when
+// there's only one replica.
+func runMaintenance(election *lease.Election, tasks []maintenanceTask) {
+	for _, task := range tasks {
+		go func(task maintenanceTask) {
+			for range time.Tick(task.interval) {
+				if election != nil && !election.IsLeader() {
+					continue
+				}
+
+				err := task.run()
+				if err != nil {
+					log.Printf("maintenance task %s failed: %v", task.name, err)
+				}
+			}
+		}(task)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/osbuild/osbuild-composer/internal/config"
+	"github.com/osbuild/osbuild-composer/internal/weldr"
+)
+
+// newNightlyTask returns a maintenance task that runs the nightly rebuild
+// pipeline configured by the TOML file at `nightlyConfigPath`. Running it as
+// a maintenance task ensures that only one replica starts the nightly runs.
+func newNightlyTask(nightlyConfigPath string, api *weldr.API, effective *config.Effective) maintenanceTask {
+	nightlyConfig, err := weldr.LoadNightlyConfig(nightlyConfigPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	effective.SetFile("nightly", nightlyConfigPath, nightlyConfig)
+
+	err = api.SetNightly(nightlyConfig)
+	if err != nil {
+		log.Fatalf("invalid nightly configuration: %v", err)
+	}
+
+	return maintenanceTask{
+		name:     "nightly",
+		interval: time.Minute,
+		run: func() error {
+			return api.RunNightly(time.Now())
+		},
+	}
+}
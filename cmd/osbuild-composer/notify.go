@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/osbuild/osbuild-composer/internal/config"
+	"github.com/osbuild/osbuild-composer/internal/notify"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+// newNotifyTask returns a maintenance task that sends emails about finished
+// composes, configured by the TOML file at `emailConfigPath`.
+func newNotifyTask(emailConfigPath string, tenants *store.Tenants, workers *worker.Server, effective *config.Effective) maintenanceTask {
+	emailConfig, err := notify.LoadEmailConfig(emailConfigPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	effective.SetFile("notify-email", emailConfigPath, emailConfig)
+
+	email, err := notify.NewEmailNotifier(*emailConfig)
+	if err != nil {
+		log.Fatalf("invalid email configuration: %v", err)
+	}
+
+	watchers := make(map[string]*notify.Watcher)
+
+	return maintenanceTask{
+		name:     "notifications",
+		interval: time.Minute,
+		run: func() error {
+			var firstErr error
+			for tenant, s := range tenants.All() {
+				watcher, exists := watchers[tenant]
+				if !exists {
+					watcher = notify.NewWatcher(s, workers, email)
+					watchers[tenant] = watcher
+				}
+
+				err := watcher.Check()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+			}
+			return firstErr
+		},
+	}
+}
+
+// newWebhookTask returns a maintenance task that posts callbacks about
+// compose state transitions to the webhooks registered with the weldr API,
+// by any of the tenants. It doesn't do anything while there are no webhooks.
+func newWebhookTask(tenants *store.Tenants, workers *worker.Server) maintenanceTask {
+	dispatchers := make(map[string]*notify.WebhookDispatcher)
+
+	return maintenanceTask{
+		name:     "webhooks",
+		interval: 10 * time.Second,
+		run: func() error {
+			var firstErr error
+			for tenant, s := range tenants.All() {
+				dispatcher, exists := dispatchers[tenant]
+				if !exists {
+					dispatcher = notify.NewWebhookDispatcher(s, workers)
+					dispatchers[tenant] = dispatcher
+				}
+
+				err := dispatcher.Check()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+			}
+			return firstErr
+		},
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/osbuild/osbuild-composer/internal/config"
+	"github.com/osbuild/osbuild-composer/internal/weldr"
+)
+
+// newRebuildTask returns a maintenance task that rebuilds the blueprints
+// configured by the TOML file at `rebuildConfigPath` when they change.
+func newRebuildTask(rebuildConfigPath string, api *weldr.API, effective *config.Effective) maintenanceTask {
+	rebuildConfig, err := weldr.LoadRebuildConfig(rebuildConfigPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	effective.SetFile("rebuild", rebuildConfigPath, rebuildConfig)
+
+	err = api.SetRebuild(rebuildConfig)
+	if err != nil {
+		log.Fatalf("invalid rebuild configuration: %v", err)
+	}
+
+	return maintenanceTask{
+		name:     "rebuild",
+		interval: time.Minute,
+		run: func() error {
+			return api.RunRebuilds(time.Now())
+		},
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/events"
+	"github.com/osbuild/osbuild-composer/internal/logging"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+// newRetentionTask returns a maintenance task that deletes finished and
+// failed composes of each tenant according to `policy`.
+func newRetentionTask(tenants *store.Tenants, workers *worker.Server, policy store.RetentionPolicy) maintenanceTask {
+	done := func(c compose.Compose) (bool, time.Time) {
+		state, _, _, finished := workers.ComposeState(c)
+		return state == common.CFinished || state == common.CFailed, finished
+	}
+
+	return maintenanceTask{
+		name:     "retention",
+		interval: 10 * time.Minute,
+		run: func() error {
+			var firstErr error
+			for _, s := range tenants.All() {
+				pruned, err := s.Prune(policy, done)
+
+				for _, p := range pruned {
+					logging.Default().Info("pruned compose", "compose_id", p.ID, "reason", p.Reason, "tenant", s.Tenant())
+					events.Emit(events.ComposeDeleted, fmt.Sprintf("Compose %s pruned (%s)", p.ID, p.Reason),
+						"COMPOSE_ID", p.ID.String(),
+						"REASON", p.Reason)
+				}
+
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+			}
+			return firstErr
+		},
+	}
+}
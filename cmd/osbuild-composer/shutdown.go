@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/osbuild/osbuild-composer/internal/weldr"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+// jobRunners run the jobs that composer runs itself, like scans, each in its
+// own goroutine. When they are stopped, they finish the job they are running.
+type jobRunners struct {
+	ctx  context.Context
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+func newJobRunners() *jobRunners {
+	ctx, stop := context.WithCancel(context.Background())
+	return &jobRunners{ctx: ctx, stop: stop}
+}
+
+// start runs `run` until the runners are stopped. Composer exits when it
+// fails.
+func (r *jobRunners) start(name string, run func(ctx context.Context) error) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		err := run(r.ctx)
+		if err != nil {
+			log.Fatalf("%s failed: %v", name, err)
+		}
+	}()
+}
+
+// wait stops the runners and waits until they finished their jobs, or until
+// `ctx` is done.
+func (r *jobRunners) wait(ctx context.Context) error {
+	r.stop()
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// waitForShutdown waits until composer receives SIGTERM or SIGINT, and then
+// drains it: no new composes are accepted, and workers and `runners` finish
+// the jobs they are running, for at most `timeout`. Everything composer knows
+// is on disk already, so jobs which are still running afterwards continue
+// when it is back.
+func waitForShutdown(timeout time.Duration, weldrAPI *weldr.API, workers *worker.Server, runners *jobRunners) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-signals
+	signal.Stop(signals)
+
+	log.Printf("received %v, draining for up to %v", sig, timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := workers.Drain(ctx)
+	if err != nil {
+		log.Printf("shutting down although %v, they continue when composer is back", err)
+	}
+
+	err = runners.wait(ctx)
+	if err != nil {
+		log.Printf("shutting down although scans, signing, or promotions are still running: %v", err)
+	}
+
+	// Requests still being served get a few seconds to finish, even if
+	// draining took all of `timeout`
+	ctx, cancel = context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancel()
+
+	err = weldrAPI.Shutdown(ctx)
+	if err != nil {
+		log.Printf("cannot shut down the Weldr API cleanly: %v", err)
+	}
+	err = workers.Shutdown(ctx)
+	if err != nil {
+		log.Printf("cannot shut down the worker API cleanly: %v", err)
+	}
+
+	log.Printf("shut down")
+}
+
+// How long requests that are being served when composer shuts down may take
+const shutdownGrace = 5 * time.Second
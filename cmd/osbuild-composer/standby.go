@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/osbuild/osbuild-composer/internal/lease"
+	"github.com/osbuild/osbuild-composer/internal/replication"
+)
+
+// How long leases are valid without being renewed. A standby takes over (or
+// another replica becomes leader) at most this long after the holder went
+// away.
+const leaseTTL = 30 * time.Second
+
+func newLease(path string) *lease.Lease {
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("cannot determine hostname: %v", err)
+	}
+
+	return lease.New(path, fmt.Sprintf("%s:%d", hostname, os.Getpid()), leaseTTL)
+}
+
+// waitForTakeover replicates the active instance's state until the lease can
+// be acquired, i.e., until the active instance stopped renewing it.
+func waitForTakeover(leaderLease *lease.Lease, follower *replication.Follower) uint64 {
+	for {
+		err := follower.Sync()
+		if err != nil {
+			log.Printf("replicating state failed: %v", err)
+		}
+
+		epoch, err := leaderLease.Acquire()
+		if err == nil {
+			return epoch
+		} else if err != lease.ErrHeld {
+			log.Printf("error acquiring lease: %v", err)
+		}
+
+		time.Sleep(leaseTTL / 3)
+	}
+}
+
+// keepLease renews the lease until that fails, which means that a standby
+// took over. The process exits in that case, because it must not touch the
+// job queue anymore.
+func keepLease(leaderLease *lease.Lease, epoch uint64) {
+	for {
+		time.Sleep(leaseTTL / 3)
+
+		e, err := leaderLease.Acquire()
+		if err != nil {
+			log.Fatalf("lost lease, shutting down: %v", err)
+		}
+		if e != epoch {
+			log.Fatalf("lease was taken over in the meantime (epoch %d, expected %d), shutting down", e, epoch)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"log"
+
+	"github.com/osbuild/osbuild-composer/internal/store"
+)
+
+// newTenants opens the stores of all tenants, which are configured like the
+// default tenant's store `s`.
+func newTenants(s *store.Store, encoding store.ArtifactEncoding, historyDepth int) *store.Tenants {
+	tenants, err := store.NewTenants(s, func(tenantStore *store.Store) error {
+		err := tenantStore.SetArtifactEncoding(encoding)
+		if err != nil {
+			return err
+		}
+		return tenantStore.SetBlueprintHistoryDepth(historyDepth)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return tenants
+}
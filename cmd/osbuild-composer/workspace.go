@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/osbuild/osbuild-composer/internal/events"
+	"github.com/osbuild/osbuild-composer/internal/logging"
+	"github.com/osbuild/osbuild-composer/internal/store"
+)
+
+// newWorkspaceTask returns a maintenance task that removes workspace copies
+// of blueprints of all tenants which haven't been changed for `ttl`. A
+// warning is emitted `warning` before a copy is removed.
+func newWorkspaceTask(tenants *store.Tenants, ttl, warning time.Duration) maintenanceTask {
+	return maintenanceTask{
+		name:     "workspace",
+		interval: time.Hour,
+		run: func() error {
+			for _, s := range tenants.All() {
+				err := sweepWorkspace(s, ttl, warning)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func sweepWorkspace(s *store.Store, ttl, warning time.Duration) error {
+	expiring, expired, err := s.SweepWorkspace(ttl, warning)
+	if err != nil {
+		return err
+	}
+
+	logger := logging.Default()
+	infos := s.GetWorkspaceInfo()
+	for _, name := range expiring {
+		expires := infos[name].Updated.Add(ttl).Format(time.RFC3339)
+		logger.Warning("workspace copy of blueprint expires soon", "blueprint", name, "expires", expires, "tenant", s.Tenant())
+		events.Emit(events.WorkspaceExpiring, fmt.Sprintf("Workspace copy of blueprint %s expires soon", name),
+			"BLUEPRINT", name,
+			"EXPIRES", expires)
+	}
+
+	for _, name := range expired {
+		logger.Info("removed stale workspace copy of blueprint", "blueprint", name, "ttl", ttl, "tenant", s.Tenant())
+		events.Emit(events.WorkspaceExpired, fmt.Sprintf("Workspace copy of blueprint %s removed", name),
+			"BLUEPRINT", name,
+			"TTL", ttl.String())
+	}
+
+	return nil
+}
@@ -75,11 +75,11 @@ func TestCrossArchDepsolve(t *testing.T) {
 							require.NoError(t, err)
 
 							buildPackages := imgType.BuildPackages()
-							_, _, err = rpm.Depsolve(buildPackages, []string{}, repos[archStr], distroStruct.ModulePlatformID(), archStr)
+							_, _, err = rpm.Depsolve(buildPackages, []string{}, nil, repos[archStr], distroStruct.ModulePlatformID(), archStr)
 							assert.NoError(t, err)
 
 							basePackagesInclude, basePackagesExclude := imgType.BasePackages()
-							_, _, err = rpm.Depsolve(basePackagesInclude, basePackagesExclude, repos[archStr], distroStruct.ModulePlatformID(), archStr)
+							_, _, err = rpm.Depsolve(basePackagesInclude, basePackagesExclude, nil, repos[archStr], distroStruct.ModulePlatformID(), archStr)
 							assert.NoError(t, err)
 						})
 					}
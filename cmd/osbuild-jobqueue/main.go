@@ -0,0 +1,171 @@
+// osbuild-jobqueue exports and imports all jobs of osbuild-composer's job
+// queue, keeping their ids, arguments, dependencies, state and results. This
+// allows moving an existing installation to another job queue backend
+// without rebuilding anything. It also lists jobs page by page.
+//
+// osbuild-composer must not be running while jobs are exported or imported,
+// because job queues require exclusive access to their storage.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue/fsjobqueue"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-queue DIR] export|import [FILE]\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "       %s [-queue DIR] [-type TYPES] [-status STATUSES] [-offset N] [-limit N] list\n\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "Jobs are written to or read from FILE (default: stdout or stdin), one JSON object per line.\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "list writes summaries of the most recently queued jobs to stdout in the same format.\n\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	var queueDir string
+	flag.StringVar(&queueDir, "queue", "/var/lib/osbuild-composer/jobs", "Directory of the job queue")
+	var types, statuses string
+	var offset, limit int
+	flag.StringVar(&types, "type", "", "Comma-separated job types to list (default: all)")
+	flag.StringVar(&statuses, "status", "", "Comma-separated statuses of jobs to list: pending, running, finished (default: all)")
+	flag.IntVar(&offset, "offset", 0, "Number of jobs to skip")
+	flag.IntVar(&limit, "limit", 50, "Maximum number of jobs to list, 0 for all")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 || flag.NArg() > 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	queue, err := fsjobqueue.New(queueDir)
+	if err != nil {
+		log.Fatalf("cannot open job queue: %v", err)
+	}
+
+	switch flag.Arg(0) {
+	case "export":
+		out := os.Stdout
+		if flag.NArg() == 2 {
+			out, err = os.OpenFile(flag.Arg(1), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		n, err := exportJobs(queue, out)
+		if err == nil {
+			err = out.Close()
+		}
+		if err != nil {
+			log.Fatalf("cannot export jobs: %v", err)
+		}
+		log.Printf("exported %d jobs", n)
+
+	case "import":
+		in := os.Stdin
+		if flag.NArg() == 2 {
+			in, err = os.Open(flag.Arg(1))
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer in.Close()
+		}
+
+		n, err := importJobs(queue, in)
+		if err != nil {
+			log.Fatalf("cannot import jobs: %v", err)
+		}
+		log.Printf("imported %d jobs", n)
+
+	case "list":
+		filter, err := parseFilter(types, statuses)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		jobs, total, err := queue.ListJobs(filter, offset, limit)
+		if err != nil {
+			log.Fatalf("cannot list jobs: %v", err)
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		for _, j := range jobs {
+			err := encoder.Encode(j)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		log.Printf("listed %d of %d jobs", len(jobs), total)
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func parseFilter(types, statuses string) (jobqueue.JobFilter, error) {
+	var filter jobqueue.JobFilter
+	if types != "" {
+		filter.Types = strings.Split(types, ",")
+	}
+	if statuses != "" {
+		for _, s := range strings.Split(statuses, ",") {
+			var status jobqueue.JobStatus
+			switch s {
+			case "pending":
+				status = jobqueue.JobPending
+			case "running":
+				status = jobqueue.JobRunning
+			case "finished":
+				status = jobqueue.JobFinished
+			default:
+				return jobqueue.JobFilter{}, fmt.Errorf("unknown job status: %s", s)
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+	return filter, nil
+}
+
+func exportJobs(queue jobqueue.Migrator, out io.Writer) (int, error) {
+	records, err := queue.Export()
+	if err != nil {
+		return 0, err
+	}
+
+	w := bufio.NewWriter(out)
+	encoder := json.NewEncoder(w)
+	for _, r := range records {
+		err := encoder.Encode(r)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(records), w.Flush()
+}
+
+func importJobs(queue jobqueue.Migrator, in io.Reader) (int, error) {
+	var records []jobqueue.Record
+
+	decoder := json.NewDecoder(bufio.NewReader(in))
+	for decoder.More() {
+		var r jobqueue.Record
+		err := decoder.Decode(&r)
+		if err != nil {
+			return 0, fmt.Errorf("error reading job %d: %v", len(records)+1, err)
+		}
+		records = append(records, r)
+	}
+
+	return len(records), queue.Import(records)
+}
@@ -29,11 +29,12 @@ type repository struct {
 }
 
 type composeRequest struct {
-	Distro       string              `json:"distro"`
-	Arch         string              `json:"arch"`
-	ImageType    string              `json:"image-type"`
-	Blueprint    blueprint.Blueprint `json:"blueprint"`
-	Repositories []repository        `json:"repositories"`
+	Distro       string                    `json:"distro"`
+	Arch         string                    `json:"arch"`
+	ImageType    string                    `json:"image-type"`
+	Blueprint    blueprint.Blueprint       `json:"blueprint"`
+	Repositories []repository              `json:"repositories"`
+	OSTree       distro.OSTreeImageOptions `json:"ostree"`
 }
 
 type rpmMD struct {
@@ -77,8 +78,8 @@ func main() {
 		panic(err)
 	}
 
-	distro := distros.GetDistro(composeRequest.Distro)
-	if distro == nil {
+	d := distros.GetDistro(composeRequest.Distro)
+	if d == nil {
 		_, _ = fmt.Fprintf(os.Stderr, "The provided distribution '%s' is not supported. Use one of these:\n", composeRequest.Distro)
 		for _, d := range distros.List() {
 			_, _ = fmt.Fprintln(os.Stderr, " *", d)
@@ -86,10 +87,10 @@ func main() {
 		return
 	}
 
-	arch, err := distro.GetArch(composeRequest.Arch)
+	arch, err := d.GetArch(composeRequest.Arch)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "The provided architecture '%s' is not supported by %s. Use one of these:\n", composeRequest.Arch, distro.Name())
-		for _, a := range distro.ListArches() {
+		fmt.Fprintf(os.Stderr, "The provided architecture '%s' is not supported by %s. Use one of these:\n", composeRequest.Arch, d.Name())
+		for _, a := range d.ListArches() {
 			_, _ = fmt.Fprintln(os.Stderr, " *", a)
 		}
 		return
@@ -97,7 +98,7 @@ func main() {
 
 	imageType, err := arch.GetImageType(composeRequest.ImageType)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "The provided image type '%s' is not supported by %s for %s. Use one of these:\n", composeRequest.ImageType, distro.Name(), arch.Name())
+		fmt.Fprintf(os.Stderr, "The provided image type '%s' is not supported by %s for %s. Use one of these:\n", composeRequest.ImageType, d.Name(), arch.Name())
 		for _, t := range arch.ListImageTypes() {
 			_, _ = fmt.Fprintln(os.Stderr, " *", t)
 		}
@@ -128,7 +129,13 @@ func main() {
 	}
 
 	pkgs, excludePkgs := imageType.BasePackages()
-	packages = append(pkgs, packages...)
+	kernel := composeRequest.Blueprint.Customizations.GetKernelName()
+	for _, pkg := range pkgs {
+		if pkg == "kernel" {
+			pkg = kernel
+		}
+		packages = append(packages, pkg)
+	}
 
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -136,13 +143,13 @@ func main() {
 	}
 
 	rpmmd := rpmmd.NewRPMMD(path.Join(home, ".cache/osbuild-composer/rpmmd"))
-	packageSpecs, checksums, err := rpmmd.Depsolve(packages, excludePkgs, repos, distro.ModulePlatformID(), arch.Name())
+	packageSpecs, checksums, err := rpmmd.Depsolve(packages, excludePkgs, composeRequest.Blueprint.GetEnabledModules(), repos, d.ModulePlatformID(), arch.Name())
 	if err != nil {
 		panic("Could not depsolve: " + err.Error())
 	}
 
 	buildPkgs := imageType.BuildPackages()
-	buildPackageSpecs, _, err := rpmmd.Depsolve(buildPkgs, nil, repos, distro.ModulePlatformID(), arch.Name())
+	buildPackageSpecs, _, err := rpmmd.Depsolve(buildPkgs, nil, nil, repos, d.ModulePlatformID(), arch.Name())
 	if err != nil {
 		panic("Could not depsolve build packages: " + err.Error())
 	}
@@ -159,7 +166,7 @@ func main() {
 			panic(err)
 		}
 	} else {
-		manifest, err := imageType.Manifest(composeRequest.Blueprint.Customizations, repos, packageSpecs, buildPackageSpecs, imageType.Size(0))
+		manifest, err := imageType.Manifest(composeRequest.Blueprint.Customizations, repos, packageSpecs, buildPackageSpecs, distro.ImageOptions{Size: imageType.Size(0), OSTree: composeRequest.OSTree})
 		if err != nil {
 			panic(err.Error())
 		}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/target"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+// qemuImgOptions are the options qemu-img needs for output formats, by
+// format. Azure only accepts fixed-size VHDs whose size is a whole number of
+// megabytes, which force_size preserves.
+var qemuImgOptions = map[string][]string{
+	"vpc": {"-o", "subformat=fixed,force_size"},
+}
+
+// RunConversion downloads the input of conversion job `job` with
+// `downloadFunc`, converts it with qemu-img, and sends the result to the
+// job's targets like RunJob() does. qemu-img's output is written to
+// `logWriter`.
+func RunConversion(job *worker.Job, logWriter io.Writer, downloadFunc func(*worker.Job) (io.ReadCloser, error), uploadFunc func(uuid.UUID, int, io.Reader) error) ([]worker.TargetResult, error) {
+	conversion := job.Conversion
+
+	dir, err := ioutil.TempDir("/var/tmp", "osbuild-conversion")
+	if err != nil {
+		return nil, fmt.Errorf("error setting up conversion directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := path.Join(dir, "input")
+	err = downloadInput(job, input, downloadFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	output := path.Join(dir, path.Base(conversion.Filename))
+	if conversion.Format == "" {
+		err = os.Rename(input, output)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		args := append([]string{"convert", "-O", conversion.Format}, qemuImgOptions[conversion.Format]...)
+		cmd := exec.Command("qemu-img", append(args, input, output)...)
+		cmd.Stdout = logWriter
+		cmd.Stderr = logWriter
+		err = cmd.Run()
+		if err != nil {
+			return nil, fmt.Errorf("qemu-img failed: %v", err)
+		}
+	}
+
+	return uploadToTargets(job, func(*target.Target) string {
+		return output
+	}, uploadFunc)
+}
+
+func downloadInput(job *worker.Job, filename string, downloadFunc func(*worker.Job) (io.ReadCloser, error)) error {
+	body, err := downloadFunc(job)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("error downloading conversion input: %v", err)
+	}
+
+	return nil
+}
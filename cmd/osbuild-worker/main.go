@@ -1,54 +1,28 @@
 package main
 
 import (
-	"crypto/tls"
-	"crypto/x509"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
+	"os/exec"
 	"path"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/osbuild/osbuild-composer/internal/common"
+	osbuild_mock "github.com/osbuild/osbuild-composer/internal/mocks/osbuild"
+	"github.com/osbuild/osbuild-composer/internal/osbuild"
 	"github.com/osbuild/osbuild-composer/internal/target"
-	"github.com/osbuild/osbuild-composer/internal/upload/awsupload"
-	"github.com/osbuild/osbuild-composer/internal/upload/azure"
+	"github.com/osbuild/osbuild-composer/internal/upload"
 	"github.com/osbuild/osbuild-composer/internal/worker"
 )
 
-type connectionConfig struct {
-	CACertFile     string
-	ClientKeyFile  string
-	ClientCertFile string
-}
-
-func createTLSConfig(config *connectionConfig) (*tls.Config, error) {
-	caCertPEM, err := ioutil.ReadFile(config.CACertFile)
-	if err != nil {
-		return nil, err
-	}
-
-	roots := x509.NewCertPool()
-	ok := roots.AppendCertsFromPEM(caCertPEM)
-	if !ok {
-		return nil, errors.New("failed to append root certificate")
-	}
-
-	cert, err := tls.LoadX509KeyPair(config.ClientCertFile, config.ClientKeyFile)
-	if err != nil {
-		return nil, err
-	}
-
-	return &tls.Config{
-		RootCAs:      roots,
-		Certificates: []tls.Certificate{cert},
-	}, nil
-}
-
 type TargetsError struct {
 	Errors []error
 }
@@ -63,101 +37,226 @@ func (e *TargetsError) Error() string {
 	return errString
 }
 
-func RunJob(job *worker.Job, uploadFunc func(uuid.UUID, int, io.Reader) error) (*common.ComposeResult, error) {
+// targetFilename returns the name of the file in osbuild's output that is
+// uploaded to `t`.
+func targetFilename(t *target.Target) string {
+	switch options := t.Options.(type) {
+	case *target.LocalTargetOptions:
+		return options.Filename
+	case *target.AWSTargetOptions:
+		return options.Filename
+	case *target.AzureTargetOptions:
+		return options.Filename
+	case *target.VMWareTargetOptions:
+		return options.Filename
+	default:
+		return ""
+	}
+}
+
+// CheckInputs returns an error if any of the jobs that `job` depends on
+// failed, because `job` cannot be built without their output.
+func CheckInputs(job *worker.Job) error {
+	for _, input := range job.Inputs {
+		if input.Result.OSBuildOutput == nil || !input.Result.OSBuildOutput.Success {
+			return fmt.Errorf("job %s, which this job depends on, failed", input.JobId)
+		}
+	}
+	return nil
+}
+
+// RunJob builds the image of `job` and uploads it to the job's targets.
+// Payloads of installers are downloaded with `payloadFunc`. osbuild's log is
+// written to `logWriter`. It returns how the upload to each non-local target
+// went, even when some of them failed.
+func RunJob(job *worker.Job, runner OSBuildRunner, logWriter io.Writer, payloadFunc func(uuid.UUID) (io.ReadCloser, error), uploadFunc func(uuid.UUID, int, io.Reader) error, checkpointFunc func(uuid.UUID, int, string, io.Reader) error) (*common.ComposeResult, []worker.TargetResult, error) {
 	tmpStore, err := ioutil.TempDir("/var/tmp", "osbuild-store")
 	if err != nil {
-		return nil, fmt.Errorf("error setting up osbuild store: %v", err)
+		return nil, nil, fmt.Errorf("error setting up osbuild store: %v", err)
 	}
 	// FIXME: how to handle errors in defer?
 	defer os.RemoveAll(tmpStore)
 
-	result, err := RunOSBuild(job.Manifest, tmpStore, os.Stderr)
+	if job.Manifest.HasPayload() {
+		err = addPayload(job, tmpStore, payloadFunc)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error adding payload: %v", err)
+		}
+	}
+
+	result, err := runner.RunOSBuild(job.Manifest, tmpStore, logWriter)
+
+	// Checkpoints are most useful when building the image failed, so
+	// export them whenever osbuild ran
+	if _, ok := err.(*OSBuildError); err == nil || ok {
+		exportCheckpoints(job, runner, tmpStore, logWriter, checkpointFunc)
+	}
+
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if !result.Success {
+		return nil, nil, &OSBuildError{
+			Message: "running osbuild failed",
+			Result:  result,
+		}
 	}
 
+	targetResults, err := uploadToTargets(job, func(t *target.Target) string {
+		return path.Join(tmpStore, "refs", result.OutputID, targetFilename(t))
+	}, uploadFunc)
+
+	return result, targetResults, err
+}
+
+// uploadToTargets sends the image of `job` to the job's targets, reading it
+// from the file `imagePath` returns for each target. Local targets get the
+// image with `uploadFunc`. It returns how the upload to each non-local target
+// went, even when some of them failed.
+func uploadToTargets(job *worker.Job, imagePath func(*target.Target) string, uploadFunc func(uuid.UUID, int, io.Reader) error) ([]worker.TargetResult, error) {
 	var r []error
+	var targetResults []worker.TargetResult
 
 	for _, t := range job.Targets {
 		switch options := t.Options.(type) {
 		case *target.LocalTargetOptions:
-			f, err := os.Open(path.Join(tmpStore, "refs", result.OutputID, options.Filename))
+			f, err := os.Open(imagePath(t))
 			if err != nil {
 				r = append(r, err)
 				continue
 			}
 
 			err = uploadFunc(options.ComposeId, options.ImageBuildId, f)
+			f.Close()
 			if err != nil {
 				r = append(r, err)
 				continue
 			}
+		default:
+			start := time.Now()
+			err := upload.Upload(t, imagePath(t), job.Id.String())
 
-		case *target.AWSTargetOptions:
-
-			a, err := awsupload.New(options.Region, options.AccessKeyID, options.SecretAccessKey)
-			if err != nil {
-				r = append(r, err)
-				continue
-			}
-
-			if options.Key == "" {
-				options.Key = job.Id.String()
+			targetResult := worker.TargetResult{
+				Name:     t.Name,
+				Profile:  t.Profile(),
+				Duration: time.Since(start),
 			}
-
-			_, err = a.Upload(path.Join(tmpStore, "refs", result.OutputID, options.Filename), options.Bucket, options.Key)
 			if err != nil {
+				targetResult.Error = err.Error()
 				r = append(r, err)
-				continue
 			}
+			targetResults = append(targetResults, targetResult)
+		}
+	}
 
-			/* TODO: communicate back the AMI */
-			_, err = a.Register(t.ImageName, options.Bucket, options.Key)
-			if err != nil {
-				r = append(r, err)
-				continue
-			}
-		case *target.AzureTargetOptions:
+	if len(r) > 0 {
+		return targetResults, &TargetsError{r}
+	}
 
-			credentials := azure.Credentials{
-				StorageAccount:   options.StorageAccount,
-				StorageAccessKey: options.StorageAccessKey,
-			}
-			metadata := azure.ImageMetadata{
-				ContainerName: options.Container,
-				ImageName:     t.ImageName,
-			}
+	return targetResults, nil
+}
 
-			const azureMaxUploadGoroutines = 4
-			err := azure.UploadImage(
-				credentials,
-				metadata,
-				path.Join(tmpStore, "refs", result.OutputID, options.Filename),
-				azureMaxUploadGoroutines,
-			)
+// exportCheckpoints builds and uploads the checkpoints requested by the local
+// targets of `job`, reusing what is left in `store` from building the image.
+// Checkpoints are only meant for debugging, so failures are logged to
+// `logWriter` but don't fail the job.
+func exportCheckpoints(job *worker.Job, runner OSBuildRunner, store string, logWriter io.Writer, checkpointFunc func(uuid.UUID, int, string, io.Reader) error) {
+	for _, t := range job.Targets {
+		options, ok := t.Options.(*target.LocalTargetOptions)
+		if !ok {
+			continue
+		}
 
+		for _, name := range options.Checkpoints {
+			err := exportCheckpoint(job.Manifest, name, runner, store, logWriter, func(reader io.Reader) error {
+				return checkpointFunc(options.ComposeId, options.ImageBuildId, name, reader)
+			})
 			if err != nil {
-				r = append(r, err)
-				continue
+				fmt.Fprintf(logWriter, "Exporting checkpoint %s failed: %v\n", name, err)
 			}
-		default:
-			r = append(r, fmt.Errorf("invalid target type"))
 		}
 	}
+}
+
+func exportCheckpoint(manifest *osbuild.Manifest, name string, runner OSBuildRunner, store string, logWriter io.Writer, uploadFunc func(io.Reader) error) error {
+	checkpoint, filename, err := manifest.CheckpointManifest(name)
+	if err != nil {
+		return err
+	}
 
-	if len(r) > 0 {
-		return result, &TargetsError{r}
+	result, err := runner.RunOSBuild(checkpoint, store, logWriter)
+	if err != nil {
+		return err
+	}
+	if !result.Success {
+		return errors.New("running osbuild failed")
 	}
 
-	return result, nil
+	f, err := os.Open(path.Join(store, "refs", result.OutputID, filename))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return uploadFunc(f)
+}
+
+// How often workers tell composer that they are still running a job
+const heartbeatInterval = 30 * time.Second
+
+// sendHeartbeats sends a heartbeat for `job` with `heartbeat` every
+// heartbeatInterval, until `done` is closed. Composer uses them to detect a
+// skewed clock, which is logged here as well.
+func sendHeartbeats(heartbeat func(*worker.Job) (time.Duration, error), job *worker.Job, done <-chan struct{}) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		offset, err := heartbeat(job)
+		if err != nil {
+			log.Printf("  Cannot send heartbeat: %v", err)
+		} else if offset > worker.ClockSkewThreshold || offset < -worker.ClockSkewThreshold {
+			log.Printf("  Clock is %v behind composer's", offset)
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
 }
 
 func main() {
 	var unix bool
+	var mock bool
+	var sandbox string
+	var sandboxImage string
+	var runnersPath string
+	var tlsConfig worker.TLSConfig
+	var arches string
+	var pullListen string
+	var pullURL string
+	var region string
+	var drainTimeout time.Duration
+	var signingKeyPath string
 	flag.BoolVar(&unix, "unix", false, "Interpret 'address' as a path to a unix domain socket instead of a network address")
+	flag.BoolVar(&mock, "mock-osbuild", false, "Fabricate results instead of running osbuild (for testing only)")
+	flag.StringVar(&sandbox, "sandbox", "", "Run osbuild in a sandbox: 'bwrap' or 'podman'")
+	flag.StringVar(&sandboxImage, "sandbox-image", "", "Root directory (bwrap) or container image (podman) containing osbuild")
+	flag.StringVar(&runnersPath, "runners", "", "Path to a TOML file selecting the sandbox and image per distro; only the distros it lists are built")
+	flag.StringVar(&arches, "arches", common.CurrentArch(), "Comma-separated list of architectures this worker can build images for")
+	flag.StringVar(&pullListen, "pull-listen", "", "Let composer pull images from this address instead of uploading them (for workers which cannot send large requests)")
+	flag.StringVar(&region, "region", "", "Region this worker runs in, to be preferred for jobs uploading to it (e.g., 'us-east-1')")
+	flag.DurationVar(&drainTimeout, "drain-timeout", 30*time.Minute, "On SIGTERM, finish the running job if it takes no longer than this, and fail it otherwise, before exiting")
+	flag.StringVar(&signingKeyPath, "signing-key", "", "File containing a key shared with composer (see its -worker-signing-key), with which all requests are signed")
+	flag.StringVar(&pullURL, "pull-url", "", "URL at which composer reaches -pull-listen (default: http://<pull-listen>)")
+	flag.StringVar(&tlsConfig.CACertFile, "ca", "/etc/osbuild-composer/ca-crt.pem", "CA certificate that signed composer's certificate")
+	flag.StringVar(&tlsConfig.CertFile, "cert", "/etc/osbuild-composer/worker-crt.pem", "Certificate used to authenticate with composer")
+	flag.StringVar(&tlsConfig.KeyFile, "key", "/etc/osbuild-composer/worker-key.pem", "Private key of -cert")
 
 	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-unix] address\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-unix] [-mock-osbuild] [-sandbox bwrap|podman -sandbox-image image] [-runners file] [-arches arch,...] [-region region] [-drain-timeout duration] [-signing-key file] [-pull-listen address [-pull-url url]] [-ca file -cert file -key file] address\n", os.Args[0])
 		flag.PrintDefaults()
 		os.Exit(0)
 	}
@@ -173,29 +272,162 @@ func main() {
 	if unix {
 		client = worker.NewClientUnix(address)
 	} else {
-		conf, err := createTLSConfig(&connectionConfig{
-			CACertFile:     "/etc/osbuild-composer/ca-crt.pem",
-			ClientKeyFile:  "/etc/osbuild-composer/worker-key.pem",
-			ClientCertFile: "/etc/osbuild-composer/worker-crt.pem",
-		})
+		conf, err := tlsConfig.ClientConfig()
 		if err != nil {
 			log.Fatalf("Error creating TLS config: %v", err)
 		}
 
 		client = worker.NewClient(address, conf)
 	}
+	client.SetRegion(region)
+
+	if signingKeyPath != "" {
+		key, err := worker.ReadSigningKey(signingKeyPath)
+		if err != nil {
+			log.Fatalf("Error reading signing key: %v", err)
+		}
+		client.SetSigningKey(key)
+	}
+
+	// Workers that can run qemu-img also convert uploaded images
+	if _, err := exec.LookPath("qemu-img"); err == nil {
+		client.EnableConversions()
+	}
+
+	var runners *distroRunners
+	if mock {
+		runners = &distroRunners{fallback: osbuild_mock.NewOSBuildMock(false)}
+	} else {
+		runner, err := newRunner(sandbox, sandboxImage)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		runners, err = loadDistroRunners(runnersPath, runner)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	client.SetDistros(runners.Distros())
+
+	// Fail early with a clear error when composer is too old or too new
+	info, err := client.Info()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if runners.Distros() != nil && !info.Supports(worker.CapabilityDistros) {
+		log.Printf("Composer cannot hand out jobs by distro, failing jobs of distros other than %s", strings.Join(runners.Distros(), ", "))
+	}
+	if pullListen != "" && !info.Supports(worker.CapabilityImagePull) {
+		log.Printf("Composer cannot pull images, uploading them instead")
+		pullListen = ""
+	}
+
+	uploadImage := client.UploadImage
+	if pullListen != "" {
+		uploadImage = func(composeId uuid.UUID, imageBuildId int, reader io.Reader) error {
+			file, ok := reader.(io.ReadSeeker)
+			if !ok {
+				return client.UploadImage(composeId, imageBuildId, reader)
+			}
+
+			listener, err := net.Listen("tcp", pullListen)
+			if err != nil {
+				return fmt.Errorf("cannot offer image: %v", err)
+			}
+			return client.OfferImage(composeId, imageBuildId, file, listener, pullURL)
+		}
+	}
+
+	shutdown := handleShutdown(drainTimeout, client.FailJob)
 
 	for {
 		fmt.Println("Waiting for a new job...")
-		job, err := client.AddJob()
+		job, err := client.AddJob(strings.Split(arches, ","))
+		if jobErr, ok := err.(*worker.JobError); ok {
+			// Composer is newer than this worker
+			log.Printf("Failing job %s: %v", jobErr.Job.Id, jobErr.Err)
+			err = client.FailJob(jobErr.Job, jobErr.Error())
+			if err != nil {
+				log.Printf("Error failing job %s: %v", jobErr.Job.Id, err)
+			}
+			continue
+		}
 		if err != nil {
 			log.Fatal(err)
 		}
 
+		// Composers without CapabilityDistros hand out jobs of all
+		// distros
+		runner, err := runners.RunnerFor(job.Distro)
+		if err != nil && job.Conversion == nil {
+			log.Printf("Failing job %s: %v", job.Id, err)
+			err = client.FailJob(job, err.Error())
+			if err != nil {
+				log.Printf("Error failing job %s: %v", job.Id, err)
+			}
+			continue
+		}
+
 		fmt.Printf("Running job %s\n", job.Id)
+		shutdown.jobStarted(job)
 
 		var status common.ImageBuildState
-		result, err := RunJob(job, client.UploadImage)
+		// Stream the log to composer, so that users can follow it while
+		// the job is running. Building doesn't depend on it.
+		var logWriter io.Writer = os.Stderr
+		logStream, err := client.StreamJobLog(job)
+		if err != nil {
+			log.Printf("  Cannot stream log: %v", err)
+		} else {
+			logWriter = io.MultiWriter(os.Stderr, logStream)
+		}
+
+		done := make(chan struct{})
+		go sendHeartbeats(client.Heartbeat, job, done)
+
+		// Older composers don't take progress, which is only reported
+		// until the first error
+		var progressFailed bool
+		report := func(progress *worker.JobProgress) {
+			if progressFailed {
+				return
+			}
+			err := client.UpdateJobProgress(job, progress)
+			if err != nil {
+				log.Printf("  Cannot report progress: %v", err)
+				progressFailed = true
+			}
+		}
+		if job.Manifest != nil {
+			progress := newProgressWriter(job.Manifest, report)
+			logWriter = io.MultiWriter(logWriter, progress)
+			runner = &timedRunner{OSBuildRunner: runner, progress: progress}
+		}
+		jobUpload := func(composeId uuid.UUID, imageBuildId int, reader io.Reader) error {
+			report(&worker.JobProgress{Message: "uploading the image", Percent: uploadProgress})
+			return uploadImage(composeId, imageBuildId, reader)
+		}
+
+		job.Started = time.Now()
+		var result *common.ComposeResult
+		var targetResults []worker.TargetResult
+		switch err = CheckInputs(job); {
+		case err != nil:
+			result = &common.ComposeResult{}
+		case job.Conversion != nil:
+			targetResults, err = RunConversion(job, logWriter, client.DownloadConversionInput, jobUpload)
+			// Conversions don't run osbuild, but composer takes
+			// whether they succeeded from its result
+			result = &common.ComposeResult{Success: err == nil}
+		default:
+			result, targetResults, err = RunJob(job, runner, logWriter, client.DownloadPayload, jobUpload, client.UploadCheckpoint)
+		}
+		job.Finished = time.Now()
+		close(done)
+		if logStream != nil {
+			_ = logStream.Close()
+		}
 		if err != nil {
 			log.Printf("  Job failed: %v", err)
 			status = common.IBFailed
@@ -208,7 +440,9 @@ func main() {
 			status = common.IBFinished
 		}
 
-		err = client.UpdateJob(job, status, result)
+		err = shutdown.jobDone(func() error {
+			return client.UpdateJob(job, status, result, targetResults)
+		})
 		if err != nil {
 			log.Fatalf("Error reporting job result: %v", err)
 		}
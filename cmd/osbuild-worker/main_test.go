@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	test_distro "github.com/osbuild/osbuild-composer/internal/distro/fedoratest"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue/testjobqueue"
+	osbuild_mock "github.com/osbuild/osbuild-composer/internal/mocks/osbuild"
+	"github.com/osbuild/osbuild-composer/internal/osbuild"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/target"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+// TestRunJobWithMockOSBuild queues a compose like composer does, and runs
+// its job like the worker does, but with the mock osbuild. The compose's
+// state and image are then taken from composer's job queue and store.
+func TestRunJobWithMockOSBuild(t *testing.T) {
+	for _, fail := range []bool{false, true} {
+		dir, err := ioutil.TempDir("", "osbuild-worker-test-")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		s := store.New(&dir)
+		workers := worker.NewServer(nil, testjobqueue.New(), s.AddImageToImageUpload, "")
+		server := httptest.NewServer(workers)
+		defer server.Close()
+		client := worker.NewClient(strings.TrimPrefix(server.URL, "http://"), nil)
+
+		d := test_distro.New()
+		arch, err := d.GetArch("x86_64")
+		require.NoError(t, err)
+		imageType, err := arch.GetImageType("qcow2")
+		require.NoError(t, err)
+
+		composeID := uuid.New()
+		manifest := &osbuild.Manifest{
+			Pipeline: osbuild.Pipeline{
+				Stages: []*osbuild.Stage{osbuild.NewFixBLSStage()},
+				Assembler: osbuild.NewQEMUAssembler(&osbuild.QEMUAssemblerOptions{
+					Format:   "qcow2",
+					Filename: "disk.qcow2",
+				}),
+			},
+		}
+		targets := []*target.Target{target.NewLocalTarget(&target.LocalTargetOptions{
+			ComposeId:    composeID,
+			ImageBuildId: 0,
+			Filename:     "disk.qcow2",
+		})}
+		jobID, err := workers.Enqueue(d.Name(), arch.Name(), manifest, targets, nil, jobqueue.PriorityNormal)
+		require.NoError(t, err)
+		err = s.PushCompose(composeID, manifest, imageType, &blueprint.Blueprint{Name: "test"}, 0, targets, nil, jobID)
+		require.NoError(t, err)
+
+		job, err := client.AddJob([]string{arch.Name()})
+		require.NoError(t, err)
+		require.Equal(t, jobID, job.Id)
+
+		runners := &distroRunners{fallback: osbuild_mock.NewOSBuildMock(fail)}
+		var log bytes.Buffer
+		runner, err := runners.RunnerFor(job.Distro)
+		require.NoError(t, err)
+		result, targetResults, err := RunJob(job, runner, &log, client.DownloadPayload, client.UploadImage, client.UploadCheckpoint)
+		require.Contains(t, log.String(), "mock: assembler org.osbuild.qemu")
+
+		status := common.IBFinished
+		if fail {
+			require.IsType(t, &OSBuildError{}, err)
+			result = err.(*OSBuildError).Result
+			status = common.IBFailed
+		} else {
+			require.NoError(t, err)
+		}
+		require.NoError(t, client.UpdateJob(job, status, result, targetResults))
+
+		c, exists := s.GetCompose(composeID)
+		require.True(t, exists)
+		state, _, _, _ := workers.ComposeState(c)
+		_, jobResult, err := workers.JobResult(jobID)
+		require.NoError(t, err)
+		require.Equal(t, !fail, jobResult.Success)
+		require.Len(t, jobResult.Stages, 1)
+
+		image, _, err := s.GetImageBuildImage(composeID, 0)
+		if fail {
+			require.Equal(t, common.CFailed, state)
+			require.Error(t, err)
+			continue
+		}
+		require.Equal(t, common.CFinished, state)
+		require.NoError(t, err)
+		defer image.Close()
+		data, err := ioutil.ReadAll(image)
+		require.NoError(t, err)
+		require.True(t, bytes.HasPrefix(data, []byte("QFI\xfb")))
+		require.Contains(t, string(data), "org.osbuild.fix-bls")
+	}
+}
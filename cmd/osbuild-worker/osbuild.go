@@ -19,12 +19,32 @@ func (e *OSBuildError) Error() string {
 	return e.Message
 }
 
+// OSBuildRunner runs osbuild on a manifest, storing its output in `store`.
+// The worker runs the osbuild binary on the host, but tests can substitute a
+// runner that doesn't require root privileges.
+type OSBuildRunner interface {
+	RunOSBuild(manifest *osbuild.Manifest, store string, errorWriter io.Writer) (*common.ComposeResult, error)
+}
+
+type hostOSBuildRunner struct{}
+
+func (hostOSBuildRunner) RunOSBuild(manifest *osbuild.Manifest, store string, errorWriter io.Writer) (*common.ComposeResult, error) {
+	return RunOSBuild(manifest, store, errorWriter)
+}
+
 func RunOSBuild(manifest *osbuild.Manifest, store string, errorWriter io.Writer) (*common.ComposeResult, error) {
 	cmd := exec.Command(
 		"osbuild",
 		"--store", store,
 		"--json", "-",
 	)
+
+	return runOSBuildCommand(cmd, manifest, errorWriter)
+}
+
+// Runs `cmd`, which must invoke osbuild with `--json -`, passing `manifest` on
+// its standard input.
+func runOSBuildCommand(cmd *exec.Cmd, manifest *osbuild.Manifest, errorWriter io.Writer) (*common.ComposeResult, error) {
 	cmd.Stderr = errorWriter
 
 	stdin, err := cmd.StdinPipe()
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+// addPayload downloads the image that the installer `job` embeds with
+// `payloadFunc` and adds it to the job's manifest. The payload is the image
+// of the job that `job` depends on. It is downloaded into `store`, because
+// that is the only directory which osbuild can read when it is sandboxed.
+func addPayload(job *worker.Job, store string, payloadFunc func(uuid.UUID) (io.ReadCloser, error)) error {
+	if len(job.Inputs) != 1 {
+		return fmt.Errorf("installers must depend on exactly one job, but job %s depends on %d", job.Id, len(job.Inputs))
+	}
+
+	body, err := payloadFunc(job.Inputs[0].JobId)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	filename := path.Join(store, "payload")
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, hash), body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	job.Manifest.SetPayload("sha256:"+hex.EncodeToString(hash.Sum(nil)), "file://"+filename)
+	return nil
+}
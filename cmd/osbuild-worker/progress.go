@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/osbuild"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+// How far a job is when its image was built and is being uploaded. Building
+// takes up the progress before.
+const uploadProgress = 90
+
+// How often progress is reported at most, unless a new stage starts
+const progressInterval = 5 * time.Second
+
+// Packages that rpm prints while installing them, like
+// "bash-5.0.11-1.fc31.x86_64"
+var packageLine = regexp.MustCompile(`^[^\s]+-[^\s-]+-[^\s-]+\.[A-Za-z0-9_]+$`)
+
+// A manifestStage is a stage of a manifest, or its assembler.
+type manifestStage struct {
+	name      string
+	assembler bool
+
+	// For org.osbuild.rpm stages, the number of packages they install
+	packages int
+}
+
+// manifestStages returns the stages of `manifest` in the order osbuild runs
+// them, starting with those of the build pipelines.
+func manifestStages(pipeline *osbuild.Pipeline) []manifestStage {
+	var stages []manifestStage
+
+	if pipeline.Build != nil && pipeline.Build.Pipeline != nil {
+		stages = append(stages, manifestStages(pipeline.Build.Pipeline)...)
+	}
+
+	for _, stage := range pipeline.Stages {
+		s := manifestStage{name: stage.Name}
+		if options, ok := stage.Options.(*osbuild.RPMStageOptions); ok {
+			s.packages = len(options.Packages)
+		}
+		stages = append(stages, s)
+	}
+
+	if pipeline.Assembler != nil {
+		stages = append(stages, manifestStage{name: pipeline.Assembler.Name, assembler: true})
+	}
+
+	return stages
+}
+
+// A progressWriter follows osbuild's log to tell how far building an image
+// is and how long each stage took. osbuild logs the name of each stage when
+// it starts it, and rpm the name of each package it installs. Writing never
+// fails; progress is best-effort.
+type progressWriter struct {
+	mu     sync.Mutex
+	stages []manifestStage
+	report func(*worker.JobProgress)
+
+	// The running stage, -1 before the first one
+	current   int
+	installed int
+
+	// When each stage started, zero for those that were not seen
+	started []time.Time
+
+	partial      []byte
+	lastReported time.Time
+}
+
+func newProgressWriter(manifest *osbuild.Manifest, report func(*worker.JobProgress)) *progressWriter {
+	stages := manifestStages(&manifest.Pipeline)
+	return &progressWriter{
+		stages:  stages,
+		report:  report,
+		current: -1,
+		started: make([]time.Time, len(stages)),
+	}
+}
+
+// durations returns how long each stage took, if osbuild finished running
+// them at `finished`. Each stage that was seen lasted until the next one
+// started.
+func (w *progressWriter) durations(finished time.Time) []time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	durations := make([]time.Duration, len(w.stages))
+	end := finished
+	for i := len(w.stages) - 1; i >= 0; i-- {
+		if w.started[i].IsZero() {
+			continue
+		}
+		durations[i] = end.Sub(w.started[i])
+		end = w.started[i]
+	}
+	return durations
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.line(strings.TrimSpace(string(w.partial[:i])))
+		w.partial = w.partial[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// line handles one line of the log. Only the stages after the running one
+// are considered, so that options which look like stage names don't move
+// the progress backwards.
+func (w *progressWriter) line(line string) {
+	name := strings.TrimPrefix(strings.TrimPrefix(line, "Stage "), "Assembler ")
+	for i := w.current + 1; i < len(w.stages); i++ {
+		if name == w.stages[i].name || strings.HasPrefix(name, w.stages[i].name+":") {
+			w.current = i
+			w.installed = 0
+			w.started[i] = time.Now()
+			w.send(true)
+			return
+		}
+	}
+
+	if w.current >= 0 && w.stages[w.current].packages > 0 && packageLine.MatchString(line) {
+		if w.installed < w.stages[w.current].packages {
+			w.installed++
+		}
+		w.send(false)
+	}
+}
+
+// send reports the current progress, unless it was reported recently and
+// `force` is false.
+func (w *progressWriter) send(force bool) {
+	if !force && time.Since(w.lastReported) < progressInterval {
+		return
+	}
+	w.lastReported = time.Now()
+
+	stage := w.stages[w.current]
+	done := float64(w.current)
+	var message string
+	switch {
+	case stage.packages > 0:
+		done += float64(w.installed) / float64(stage.packages)
+		message = fmt.Sprintf("installing packages (%d/%d)", w.installed, stage.packages)
+	case stage.assembler:
+		message = "assembling the image"
+	default:
+		message = "running " + stage.name
+	}
+
+	w.report(&worker.JobProgress{
+		Stage:   stage.name,
+		Message: message,
+		Percent: int(done / float64(len(w.stages)) * uploadProgress),
+	})
+}
+
+// A timedRunner sets the durations of the stages that a progressWriter saw
+// on the result of the first run, which builds the image. Later runs export
+// checkpoints.
+type timedRunner struct {
+	OSBuildRunner
+	progress *progressWriter
+	done     bool
+}
+
+func (r *timedRunner) RunOSBuild(manifest *osbuild.Manifest, store string, errorWriter io.Writer) (*common.ComposeResult, error) {
+	result, err := r.OSBuildRunner.RunOSBuild(manifest, store, errorWriter)
+	if r.done {
+		return result, err
+	}
+	r.done = true
+
+	durations := r.progress.durations(time.Now())
+	if result != nil {
+		result.SetStageDurations(durations)
+	}
+	if osbuildError, ok := err.(*OSBuildError); ok && osbuildError.Result != nil {
+		osbuildError.Result.SetStageDurations(durations)
+	}
+
+	return result, err
+}
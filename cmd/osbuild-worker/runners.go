@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+)
+
+// runnerConfig describes how to run osbuild for jobs of one distro. The
+// worker's runner configuration maps distro names to these, for example:
+//
+//	[fedora-32]
+//	sandbox = "podman"
+//	image = "quay.io/osbuild/osbuild:fedora-32"
+//
+//	["rhel-8.3"]
+//
+// Names of distros with a dot must be quoted. A worker with a runner
+// configuration only builds images of the distros that are listed, and tells
+// composer so. Distros without a sandbox use the host's osbuild. Workers
+// without a configuration use the runner given on the command line for all
+// distros.
+type runnerConfig struct {
+	Sandbox string `toml:"sandbox"`
+	Image   string `toml:"image"`
+}
+
+// newRunner returns the runner for `sandbox` ("" for the host's osbuild,
+// "bwrap" or "podman"). `image` is passed on to sandboxed runners.
+func newRunner(sandbox, image string) (OSBuildRunner, error) {
+	if sandbox == "" {
+		return hostOSBuildRunner{}, nil
+	}
+
+	if image == "" {
+		return nil, fmt.Errorf("sandbox '%s' requires an image", sandbox)
+	}
+
+	switch sandbox {
+	case "bwrap":
+		return bwrapOSBuildRunner{image}, nil
+	case "podman":
+		return podmanOSBuildRunner{image}, nil
+	default:
+		return nil, fmt.Errorf("unknown sandbox: %s", sandbox)
+	}
+}
+
+// distroRunners picks the runner for a job based on the distro it was
+// created for. `fallback` runs jobs of all distros; it is nil for workers
+// which only build images of the distros in `runners`.
+type distroRunners struct {
+	runners  map[string]OSBuildRunner
+	fallback OSBuildRunner
+}
+
+// loadDistroRunners reads the runner configuration at `path`. Jobs of all
+// distros are run with `fallback` if `path` is empty.
+func loadDistroRunners(path string, fallback OSBuildRunner) (*distroRunners, error) {
+	r := &distroRunners{
+		runners: make(map[string]OSBuildRunner),
+	}
+
+	if path == "" {
+		r.fallback = fallback
+		return r, nil
+	}
+
+	var configs map[string]runnerConfig
+	metadata, err := toml.DecodeFile(path, &configs)
+	if err != nil {
+		return nil, fmt.Errorf("error reading runner configuration: %v", err)
+	}
+	if undecoded := metadata.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("unknown keys in runner configuration: %v", undecoded)
+	}
+
+	for distro, config := range configs {
+		runner, err := newRunner(config.Sandbox, config.Image)
+		if err != nil {
+			return nil, fmt.Errorf("invalid runner for %s: %v", distro, err)
+		}
+		r.runners[distro] = runner
+	}
+
+	return r, nil
+}
+
+// Distros returns the distros that the worker builds images of, or nil if it
+// builds images of all distros.
+func (r *distroRunners) Distros() []string {
+	if r.fallback != nil {
+		return nil
+	}
+
+	distros := []string{}
+	for distro := range r.runners {
+		distros = append(distros, distro)
+	}
+	sort.Strings(distros)
+	return distros
+}
+
+// RunnerFor returns the runner for jobs of `distro`, or an error if the
+// worker doesn't build images of it.
+func (r *distroRunners) RunnerFor(distro string) (OSBuildRunner, error) {
+	if runner, exists := r.runners[distro]; exists {
+		return runner, nil
+	}
+	if r.fallback == nil {
+		return nil, fmt.Errorf("this worker has no osbuild runner for distro %s", distro)
+	}
+	return r.fallback, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRunner(t *testing.T) {
+	runner, err := newRunner("", "")
+	require.NoError(t, err)
+	require.Equal(t, hostOSBuildRunner{}, runner)
+
+	runner, err = newRunner("podman", "quay.io/osbuild/osbuild:fedora-32")
+	require.NoError(t, err)
+	require.Equal(t, podmanOSBuildRunner{"quay.io/osbuild/osbuild:fedora-32"}, runner)
+
+	_, err = newRunner("podman", "")
+	require.Error(t, err)
+	_, err = newRunner("chroot", "/")
+	require.Error(t, err)
+}
+
+func TestDistroRunners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-worker-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fallback := bwrapOSBuildRunner{"/srv/osbuild"}
+
+	// without a configuration, jobs of all distros use the fallback
+	runners, err := loadDistroRunners("", fallback)
+	require.NoError(t, err)
+	require.Nil(t, runners.Distros())
+	runner, err := runners.RunnerFor("fedora-32")
+	require.NoError(t, err)
+	require.Equal(t, fallback, runner)
+
+	config := path.Join(dir, "runners.toml")
+	err = ioutil.WriteFile(config, []byte(`
+[fedora-32]
+sandbox = "podman"
+image = "quay.io/osbuild/osbuild:fedora-32"
+
+["rhel-8.3"]
+`), 0600)
+	require.NoError(t, err)
+
+	runners, err = loadDistroRunners(config, fallback)
+	require.NoError(t, err)
+	require.Equal(t, []string{"fedora-32", "rhel-8.3"}, runners.Distros())
+	runner, err = runners.RunnerFor("fedora-32")
+	require.NoError(t, err)
+	require.Equal(t, podmanOSBuildRunner{"quay.io/osbuild/osbuild:fedora-32"}, runner)
+	runner, err = runners.RunnerFor("rhel-8.3")
+	require.NoError(t, err)
+	require.Equal(t, hostOSBuildRunner{}, runner)
+
+	// other distros are not built with the fallback
+	_, err = runners.RunnerFor("fedora-31")
+	require.EqualError(t, err, "this worker has no osbuild runner for distro fedora-31")
+
+	err = ioutil.WriteFile(config, []byte("[fedora-32]\nsandbox = \"podman\"\n"), 0600)
+	require.NoError(t, err)
+	_, err = loadDistroRunners(config, fallback)
+	require.Error(t, err)
+
+	// unquoted names with dots are tables in tables
+	err = ioutil.WriteFile(config, []byte("[rhel-8.3]\n"), 0600)
+	require.NoError(t, err)
+	_, err = loadDistroRunners(config, fallback)
+	require.Error(t, err)
+
+	_, err = loadDistroRunners(path.Join(dir, "missing.toml"), fallback)
+	require.Error(t, err)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"os/exec"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/osbuild"
+)
+
+// bwrapOSBuildRunner runs osbuild from a separate root file system with
+// bubblewrap. The root file system is usually an unpacked container image
+// with a specific version of osbuild, which allows running several versions of
+// osbuild on the same host.
+//
+// osbuild still needs to be run as root, because it creates loop devices and
+// mounts file systems. The sandbox only isolates osbuild from the host's file
+// system and processes.
+type bwrapOSBuildRunner struct {
+	root string
+}
+
+func (r bwrapOSBuildRunner) RunOSBuild(manifest *osbuild.Manifest, store string, errorWriter io.Writer) (*common.ComposeResult, error) {
+	cmd := exec.Command(
+		"bwrap",
+		"--bind", r.root, "/",
+		"--dev-bind", "/dev", "/dev",
+		"--proc", "/proc",
+		"--ro-bind", "/sys", "/sys",
+		"--tmpfs", "/run",
+		"--tmpfs", "/tmp",
+		"--bind", store, store,
+		"--unshare-pid",
+		"--unshare-ipc",
+		"--unshare-uts",
+		"--die-with-parent",
+		"osbuild",
+		"--store", store,
+		"--json", "-",
+	)
+
+	return runOSBuildCommand(cmd, manifest, errorWriter)
+}
+
+// podmanOSBuildRunner runs osbuild in a privileged podman container created
+// from `image`, which must contain osbuild. Only the store is shared with the
+// host.
+type podmanOSBuildRunner struct {
+	image string
+}
+
+func (r podmanOSBuildRunner) RunOSBuild(manifest *osbuild.Manifest, store string, errorWriter io.Writer) (*common.ComposeResult, error) {
+	cmd := exec.Command(
+		"podman", "run",
+		"--rm",
+		"--interactive",
+		"--privileged",
+		"--volume", "/dev:/dev",
+		"--volume", store+":"+store+":Z",
+		r.image,
+		"osbuild",
+		"--store", store,
+		"--json", "-",
+	)
+
+	return runOSBuildCommand(cmd, manifest, errorWriter)
+}
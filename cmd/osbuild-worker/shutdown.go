@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+// A shutdown lets the worker finish the job it is running when it receives
+// SIGTERM or SIGINT, instead of leaving it running forever in composer's
+// queue.
+type shutdown struct {
+	mu       sync.Mutex
+	job      *worker.Job
+	stopping bool
+}
+
+// handleShutdown waits for SIGTERM or SIGINT in the background. Idle workers
+// exit right away. Workers running a job exit when it is done, or fail it
+// with `failJob` and exit when it takes longer than `timeout`.
+func handleShutdown(timeout time.Duration, failJob func(job *worker.Job, message string) error) *shutdown {
+	s := &shutdown{}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		sig := <-signals
+
+		s.mu.Lock()
+		s.stopping = true
+		job := s.job
+		s.mu.Unlock()
+
+		if job == nil {
+			log.Printf("Received %v, exiting", sig)
+			os.Exit(0)
+		}
+
+		log.Printf("Received %v, finishing job %s for at most %v before exiting", sig, job.Id, timeout)
+		time.Sleep(timeout)
+
+		// Holding the lock keeps the job from being reported as done
+		// while it is failed
+		s.mu.Lock()
+		if s.job != nil {
+			log.Printf("Failing job %s, which didn't finish in time", s.job.Id)
+			err := failJob(s.job, "the worker was shut down before the job finished")
+			if err != nil {
+				log.Printf("Error failing job %s: %v", s.job.Id, err)
+			}
+		}
+		os.Exit(0)
+	}()
+
+	return s
+}
+
+// jobStarted records that the worker is running `job`.
+func (s *shutdown) jobStarted(job *worker.Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.job = job
+}
+
+// jobDone records that the worker finished its job, which is reported with
+// `report`. It exits if the worker is shutting down.
+func (s *shutdown) jobDone(report func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.job = nil
+	err := report()
+	if s.stopping {
+		if err != nil {
+			log.Fatalf("Error reporting job result: %v", err)
+		}
+		log.Printf("Finished the job, exiting")
+		os.Exit(0)
+	}
+	return err
+}
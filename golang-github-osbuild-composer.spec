@@ -101,6 +101,9 @@ install -m 0644 -vp repositories/*                          %{buildroot}%{_datad
 install -m 0755 -vd                                         %{buildroot}%{_unitdir}
 install -m 0644 -vp distribution/*.{service,socket}         %{buildroot}%{_unitdir}/
 
+install -m 0755 -vd                                         %{buildroot}%{_prefix}/lib/systemd/catalog
+install -m 0644 -vp distribution/osbuild-composer.catalog   %{buildroot}%{_prefix}/lib/systemd/catalog/
+
 install -m 0755 -vd                                         %{buildroot}%{_sysusersdir}
 install -m 0644 -vp distribution/osbuild-composer.conf      %{buildroot}%{_sysusersdir}/
 
@@ -152,6 +155,10 @@ export GOPATH=$PWD/_build:%{gopath}
 %{_unitdir}/osbuild-composer.service
 %{_unitdir}/osbuild-composer.socket
 %{_unitdir}/osbuild-remote-worker.socket
+%{_unitdir}/osbuild-replication.socket
+%{_unitdir}/osbuild-gallery.socket
+%{_unitdir}/osbuild-cloudapi.socket
+%{_prefix}/lib/systemd/catalog/osbuild-composer.catalog
 %{_sysusersdir}/osbuild-composer.conf
 
 %package rcm
@@ -0,0 +1,259 @@
+// Package admission decides whether a compose request is accepted.
+//
+// Controllers are consulted after a compose request was validated, but
+// before any work is done for it. Built-in Rules cover common policies. More
+// elaborate policies can be delegated to an external HTTP endpoint, which
+// speaks the protocol of Open Policy Agent's data API.
+package admission
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/events"
+)
+
+// A Request describes a compose that is about to be accepted.
+type Request struct {
+	Blueprint    *blueprint.Blueprint `json:"blueprint"`
+	Distro       string               `json:"distro"`
+	Arch         string               `json:"arch"`
+	ImageType    string               `json:"image_type"`
+	Size         uint64               `json:"size"`
+	Repositories []Repository         `json:"repositories"`
+
+	// Identifies the client, if known
+	Client string `json:"client,omitempty"`
+}
+
+// A Repository is a package repository the compose would use.
+type Repository struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+
+	// System repositories are configured on the host, as opposed to sources
+	// added through the API.
+	System bool `json:"system"`
+}
+
+// A Decision is the result of evaluating a request. `Reasons` explains why a
+// request was denied.
+type Decision struct {
+	Allowed bool     `json:"allow"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+func allow() Decision {
+	return Decision{Allowed: true}
+}
+
+func deny(format string, args ...interface{}) Decision {
+	return Decision{Allowed: false, Reasons: []string{fmt.Sprintf(format, args...)}}
+}
+
+// A Controller decides about compose requests. It returns an error only when
+// it could not come to a decision, in which case the request is denied.
+type Controller interface {
+	Admit(request *Request) (Decision, error)
+}
+
+// Rules are the built-in admission rules. The zero value allows everything.
+type Rules struct {
+	// Deny blueprints which set a password for root
+	DenyRootPassword bool `toml:"deny_root_password"`
+
+	// If not empty, sources added through the API must be in this list,
+	// either by name or by URL. System repositories are always allowed.
+	ApprovedRepositories []string `toml:"approved_repositories"`
+
+	// Maximum image size in bytes, or 0 for no limit
+	MaxImageSize uint64 `toml:"max_image_size"`
+}
+
+func (r *Rules) Admit(request *Request) (Decision, error) {
+	if r.DenyRootPassword && request.Blueprint != nil && request.Blueprint.Customizations != nil {
+		for _, user := range request.Blueprint.Customizations.User {
+			if user.Name == "root" && user.Password != nil {
+				return deny("setting a password for root is not allowed"), nil
+			}
+		}
+	}
+
+	if len(r.ApprovedRepositories) > 0 {
+		for _, repo := range request.Repositories {
+			if !repo.System && !r.isApproved(repo) {
+				return deny("repository %s is not approved", repo.Name), nil
+			}
+		}
+	}
+
+	if r.MaxImageSize > 0 && request.Size > r.MaxImageSize {
+		return deny("image size %d exceeds the maximum of %d bytes", request.Size, r.MaxImageSize), nil
+	}
+
+	return allow(), nil
+}
+
+func (r *Rules) isApproved(repo Repository) bool {
+	for _, approved := range r.ApprovedRepositories {
+		if approved == repo.Name || strings.TrimSuffix(approved, "/") == strings.TrimSuffix(repo.URL, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// A Policy delegates decisions to an external HTTP endpoint. The request is
+// posted as `{"input": <request>}` and the endpoint must reply with either
+// `{"result": true|false}` or `{"result": {"allow": true|false, "reasons":
+// [...]}}`, as Open Policy Agent does for a rule or a package, respectively.
+// An undefined result (no "result" field) denies the request.
+type Policy struct {
+	url    string
+	client *http.Client
+}
+
+func NewPolicy(url string) *Policy {
+	return &Policy{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *Policy) Admit(request *Request) (Decision, error) {
+	body, err := json.Marshal(struct {
+		Input *Request `json:"input"`
+	}{request})
+	if err != nil {
+		return Decision{}, err
+	}
+
+	response, err := p.client.Post(p.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return Decision{}, fmt.Errorf("error querying policy: %v", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return Decision{}, fmt.Errorf("policy endpoint returned %d", response.StatusCode)
+	}
+
+	var reply struct {
+		Result *json.RawMessage `json:"result"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&reply)
+	if err != nil {
+		return Decision{}, fmt.Errorf("cannot parse policy decision: %v", err)
+	}
+
+	if reply.Result == nil {
+		return deny("policy is undefined for this request"), nil
+	}
+
+	var allowed bool
+	if json.Unmarshal(*reply.Result, &allowed) == nil {
+		if !allowed {
+			return deny("denied by policy"), nil
+		}
+		return allow(), nil
+	}
+
+	var decision Decision
+	err = json.Unmarshal(*reply.Result, &decision)
+	if err != nil {
+		return Decision{}, fmt.Errorf("cannot parse policy decision: %v", err)
+	}
+	if !decision.Allowed && len(decision.Reasons) == 0 {
+		decision.Reasons = []string{"denied by policy"}
+	}
+
+	return decision, nil
+}
+
+// Controllers combines several controllers. A request is admitted only if
+// all of them admit it.
+type Controllers []Controller
+
+func (cs Controllers) Admit(request *Request) (Decision, error) {
+	for _, c := range cs {
+		decision, err := c.Admit(request)
+		if err != nil || !decision.Allowed {
+			return decision, err
+		}
+	}
+
+	return allow(), nil
+}
+
+// Check asks `controller` about `request`, and logs and emits an event of
+// its decision. It returns whether the request is admitted and, if it isn't,
+// why not. The Weldr and cloud APIs check all compose requests with it.
+func Check(controller Controller, request *Request) (bool, string) {
+	decision, err := controller.Admit(request)
+	if err != nil {
+		decision = Decision{Reasons: []string{err.Error()}}
+	}
+	reasons := strings.Join(decision.Reasons, "; ")
+
+	if decision.Allowed {
+		log.Printf("admitted compose of blueprint %s (%s) for %s", request.Blueprint.Name, request.ImageType, request.Client)
+		events.Emit(events.AdmissionAllowed, fmt.Sprintf("Compose of blueprint %s admitted", request.Blueprint.Name),
+			"BLUEPRINT", request.Blueprint.Name,
+			"BLUEPRINT_VERSION", request.Blueprint.Version,
+			"IMAGE_TYPE", request.ImageType,
+			"CLIENT", request.Client)
+		return true, ""
+	}
+
+	log.Printf("denied compose of blueprint %s (%s) for %s: %s", request.Blueprint.Name, request.ImageType, request.Client, reasons)
+	events.Emit(events.AdmissionDenied, fmt.Sprintf("Compose of blueprint %s denied: %s", request.Blueprint.Name, reasons),
+		"BLUEPRINT", request.Blueprint.Name,
+		"BLUEPRINT_VERSION", request.Blueprint.Version,
+		"IMAGE_TYPE", request.ImageType,
+		"CLIENT", request.Client,
+		"REASON", reasons)
+	return false, reasons
+}
+
+// Config is the admission configuration, usually loaded from a TOML file:
+//
+//	deny_root_password = true
+//	approved_repositories = ["internal-mirror", "https://mirror.example.com/fedora/"]
+//	max_image_size = 21474836480
+//
+//	[policy]
+//	url = "http://localhost:8181/v1/data/composer/admission"
+type Config struct {
+	Rules
+	Policy *struct {
+		URL string `toml:"url"`
+	} `toml:"policy"`
+}
+
+func LoadConfig(path string) (*Config, error) {
+	var config Config
+	_, err := toml.DecodeFile(path, &config)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load admission configuration: %v", err)
+	}
+
+	return &config, nil
+}
+
+// Controller returns the controller described by the configuration.
+func (c *Config) Controller() Controller {
+	rules := c.Rules
+	controllers := Controllers{&rules}
+	if c.Policy != nil && c.Policy.URL != "" {
+		controllers = append(controllers, NewPolicy(c.Policy.URL))
+	}
+	return controllers
+}
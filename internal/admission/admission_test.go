@@ -0,0 +1,137 @@
+package admission_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/admission"
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+)
+
+func newRequest() *admission.Request {
+	return &admission.Request{
+		Blueprint: &blueprint.Blueprint{Name: "test"},
+		Distro:    "fedora-32",
+		Arch:      "x86_64",
+		ImageType: "qcow2",
+		Size:      2048,
+		Repositories: []admission.Repository{
+			{Name: "fedora", URL: "https://mirrors.fedoraproject.org/metalink?repo=fedora-32", System: true},
+			{Name: "internal", URL: "https://mirror.example.com/internal/"},
+		},
+	}
+}
+
+func TestRules(t *testing.T) {
+	password := "$6$..."
+
+	withRoot := newRequest()
+	withRoot.Blueprint.Customizations = &blueprint.Customizations{
+		User: []blueprint.UserCustomization{{Name: "root", Password: &password}},
+	}
+
+	var cases = []struct {
+		Rules   admission.Rules
+		Request *admission.Request
+		Allowed bool
+	}{
+		{admission.Rules{}, withRoot, true},
+		{admission.Rules{DenyRootPassword: true}, withRoot, false},
+		{admission.Rules{DenyRootPassword: true}, newRequest(), true},
+		{admission.Rules{ApprovedRepositories: []string{"other"}}, newRequest(), false},
+		{admission.Rules{ApprovedRepositories: []string{"internal"}}, newRequest(), true},
+		{admission.Rules{ApprovedRepositories: []string{"https://mirror.example.com/internal"}}, newRequest(), true},
+		{admission.Rules{MaxImageSize: 1024}, newRequest(), false},
+		{admission.Rules{MaxImageSize: 2048}, newRequest(), true},
+	}
+
+	for i, c := range cases {
+		decision, err := c.Rules.Admit(c.Request)
+		require.NoError(t, err)
+		require.Equalf(t, c.Allowed, decision.Allowed, "case %d", i)
+		if !decision.Allowed {
+			require.NotEmptyf(t, decision.Reasons, "case %d", i)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	var reply string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Input admission.Request `json:"input"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		require.NoError(t, err)
+		require.Equal(t, "qcow2", body.Input.ImageType)
+		require.Equal(t, "test", body.Input.Blueprint.Name)
+
+		_, _ = w.Write([]byte(reply))
+	}))
+	defer server.Close()
+
+	policy := admission.NewPolicy(server.URL)
+
+	var cases = []struct {
+		Reply   string
+		Allowed bool
+		Reasons []string
+	}{
+		{`{"result": true}`, true, nil},
+		{`{"result": false}`, false, []string{"denied by policy"}},
+		{`{}`, false, []string{"policy is undefined for this request"}},
+		{`{"result": {"allow": true}}`, true, nil},
+		{`{"result": {"allow": false, "reasons": ["no"]}}`, false, []string{"no"}},
+	}
+
+	for _, c := range cases {
+		reply = c.Reply
+		decision, err := policy.Admit(newRequest())
+		require.NoError(t, err)
+		require.Equal(t, c.Allowed, decision.Allowed, c.Reply)
+		require.Equal(t, c.Reasons, decision.Reasons, c.Reply)
+	}
+
+	reply = `{"result": "maybe"}`
+	_, err := policy.Admit(newRequest())
+	require.Error(t, err)
+}
+
+func TestConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"result": false}`))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(dir, "admission.toml")
+	err = ioutil.WriteFile(path, []byte(`
+deny_root_password = true
+max_image_size = 4096
+
+[policy]
+url = "`+server.URL+`"
+`), 0600)
+	require.NoError(t, err)
+
+	config, err := admission.LoadConfig(path)
+	require.NoError(t, err)
+	require.True(t, config.DenyRootPassword)
+	require.Equal(t, uint64(4096), config.MaxImageSize)
+
+	// the rules allow the request, but the policy doesn't
+	decision, err := config.Controller().Admit(newRequest())
+	require.NoError(t, err)
+	require.False(t, decision.Allowed)
+	require.Equal(t, []string{"denied by policy"}, decision.Reasons)
+}
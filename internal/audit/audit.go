@@ -0,0 +1,207 @@
+// Package audit keeps an append-only log of the requests that change
+// composer's state, like pushing blueprints, changing sources, starting and
+// deleting composes, and workers taking and finishing jobs.
+//
+// Each entry records who made the request, what it was, how it ended, and a
+// hash of its payload, so that a copy of the payload can be matched to the
+// entry. Payloads themselves are not logged, because they can contain
+// credentials. The log is a file with one JSON object per line, usually
+// audit.log in the state directory.
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An Entry is a single request in the audit log.
+type Entry struct {
+	Time time.Time `json:"time"`
+
+	// Who made the request: the name of an authenticated client or
+	// worker, or the address it came from
+	Actor string `json:"actor"`
+
+	// The method and path of the request, e.g., "POST /api/v1/compose"
+	Action string `json:"action"`
+
+	// The HTTP status of the response
+	Status int `json:"status"`
+
+	// The sha256 of the request's body, empty if it had none
+	PayloadHash string `json:"payload_hash,omitempty"`
+}
+
+// A Filter selects entries in Query(). Empty fields match all entries.
+type Filter struct {
+	Actor string
+
+	// A prefix of the action, e.g., "DELETE" or "POST /api/v1/compose"
+	Action string
+
+	Since time.Time
+	Until time.Time
+}
+
+// Matches returns whether `entry` is selected by the filter.
+func (f *Filter) Matches(entry *Entry) bool {
+	return (f.Actor == "" || entry.Actor == f.Actor) &&
+		strings.HasPrefix(entry.Action, f.Action) &&
+		(f.Since.IsZero() || !entry.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || entry.Time.Before(f.Until))
+}
+
+type Log struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// Open opens the audit log at `path`, creating it if it doesn't exist.
+func Open(path string) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open audit log: %v", err)
+	}
+
+	return &Log{path: path, file: file}, nil
+}
+
+// Close closes the audit log.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.file.Close()
+}
+
+// Record appends `entry` to the audit log.
+func (l *Log) Record(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err = l.file.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("cannot write audit log: %v", err)
+	}
+	return nil
+}
+
+// Query returns the entries that match `filter`, newest first, skipping the
+// first `offset` of them and returning at most `limit`, as well as how many
+// match in total.
+func (l *Log) Query(filter Filter, offset, limit int) ([]Entry, int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot read audit log: %v", err)
+	}
+	defer file.Close()
+
+	var matching []Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return nil, 0, fmt.Errorf("corrupt audit log entry: %v", err)
+		}
+		if filter.Matches(&entry) {
+			matching = append(matching, entry)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("cannot read audit log: %v", err)
+	}
+
+	entries := []Entry{}
+	for i := len(matching) - 1 - offset; i >= 0 && len(entries) < limit; i-- {
+		entries = append(entries, matching[i])
+	}
+	return entries, len(matching), nil
+}
+
+// Handler returns a handler which passes requests to `next` and records
+// those that can change state, which are all but GET, HEAD, and OPTIONS
+// requests, in `l`. `actor` returns who made a request.
+func Handler(l *Log, next http.Handler, actor func(request *http.Request) string) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == http.MethodGet || request.Method == http.MethodHead || request.Method == http.MethodOptions {
+			next.ServeHTTP(writer, request)
+			return
+		}
+
+		body := &hashingReader{ReadCloser: request.Body, hash: sha256.New()}
+		request.Body = body
+		recorder := &statusRecorder{writer, http.StatusOK}
+		next.ServeHTTP(recorder, request)
+
+		// The whole payload is hashed, even if the handler didn't read it
+		_, _ = io.Copy(ioutil.Discard, body)
+
+		entry := Entry{
+			Time:   time.Now().UTC(),
+			Actor:  actor(request),
+			Action: request.Method + " " + request.URL.Path,
+			Status: recorder.status,
+		}
+		if body.size > 0 {
+			entry.PayloadHash = "sha256:" + hex.EncodeToString(body.hash.Sum(nil))
+		}
+
+		err := l.Record(entry)
+		if err != nil {
+			// The request has been served already, logging is the
+			// best that can be done
+			fmt.Fprintf(os.Stderr, "cannot record %s by %s: %v\n", entry.Action, entry.Actor, err)
+		}
+	})
+}
+
+type hashingReader struct {
+	io.ReadCloser
+	hash hash.Hash
+	size int64
+}
+
+func (r *hashingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	r.size += int64(n)
+	return n, err
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush passes on flushes, which streaming handlers rely on.
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
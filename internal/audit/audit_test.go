@@ -0,0 +1,78 @@
+package audit
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	log, err := Open(filepath.Join(dir, "audit.log"))
+	require.NoError(t, err)
+	defer log.Close()
+
+	next := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == http.MethodDelete {
+			writer.WriteHeader(http.StatusNotFound)
+		}
+	})
+	handler := Handler(log, next, func(request *http.Request) string { return "alice" })
+
+	for _, r := range []struct {
+		method, path, body string
+	}{
+		{"GET", "/api/v1/blueprints/list", ""},
+		{"POST", "/api/v1/blueprints/new", "name = \"test\""},
+		{"DELETE", "/api/v1/blueprints/delete/test", ""},
+	} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(r.method, r.path, strings.NewReader(r.body)))
+	}
+
+	entries, total, err := log.Query(Filter{}, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, 2, total)
+	require.Equal(t, "DELETE /api/v1/blueprints/delete/test", entries[0].Action)
+	require.Equal(t, http.StatusNotFound, entries[0].Status)
+	require.Empty(t, entries[0].PayloadHash)
+	require.Equal(t, "POST /api/v1/blueprints/new", entries[1].Action)
+	require.Equal(t, "alice", entries[1].Actor)
+	require.Equal(t, http.StatusOK, entries[1].Status)
+	// sha256 of `name = "test"`, even though the handler didn't read it
+	require.Equal(t, "sha256:0555e7f2450d2adff540643380b66f49489bbb041261ed02c8b30adbdd5c96c1", entries[1].PayloadHash)
+
+	entries, total, err = log.Query(Filter{Action: "POST"}, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, total)
+	require.Len(t, entries, 1)
+
+	entries, total, err = log.Query(Filter{Since: time.Now().Add(time.Hour)}, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, 0, total)
+	require.Empty(t, entries)
+
+	entries, total, err = log.Query(Filter{}, 1, 10)
+	require.NoError(t, err)
+	require.Equal(t, 2, total)
+	require.Len(t, entries, 1)
+	require.Equal(t, "POST /api/v1/blueprints/new", entries[0].Action)
+
+	// Entries survive reopening the log
+	require.NoError(t, log.Close())
+	log, err = Open(filepath.Join(dir, "audit.log"))
+	require.NoError(t, err)
+	defer log.Close()
+	_, total, err = log.Query(Filter{}, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, 2, total)
+}
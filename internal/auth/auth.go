@@ -0,0 +1,219 @@
+// Package auth authenticates clients of composer's HTTP APIs and assigns
+// them a role.
+//
+// Clients send a bearer token in the Authorization header. Tokens are either
+// static tokens listed in the configuration or JSON Web Tokens issued by an
+// OpenID Connect provider. Each API decides which role a request requires.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// A Role is what a client is allowed to do. Each role includes the ones
+// before it: readers can list blueprints and composes, builders can also
+// start composes, and admins can also manage sources and delete state.
+type Role string
+
+const (
+	RoleReader  Role = "reader"
+	RoleBuilder Role = "builder"
+	RoleAdmin   Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleReader:  1,
+	RoleBuilder: 2,
+	RoleAdmin:   3,
+}
+
+// Valid returns true if `r` is one of the known roles.
+func (r Role) Valid() bool {
+	_, exists := roleRanks[r]
+	return exists
+}
+
+// Includes returns true if clients with role `r` may do what `other` may.
+func (r Role) Includes(other Role) bool {
+	return roleRanks[r] >= roleRanks[other]
+}
+
+// An Identity is an authenticated client. Clients which belong to a
+// `Tenant` only see the blueprints, sources, and composes of that tenant.
+type Identity struct {
+	Name   string `json:"name"`
+	Role   Role   `json:"role"`
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// ErrNoCredentials is returned by Authenticate for requests without a
+// bearer token.
+var ErrNoCredentials = errors.New("no bearer token in request")
+
+// Config is the authentication configuration, usually loaded from a TOML
+// file:
+//
+//	[[tokens]]
+//	name = "ci"
+//	token_sha256 = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+//	role = "builder"
+//	tenant = "web-team"
+//
+//	[oidc]
+//	issuer = "https://sso.example.com/realms/composer"
+//	audience = "osbuild-composer"
+//	roles_claim = "realm_access.roles"
+//	tenant_claim = "org"
+//
+//	[oidc.roles]
+//	composer-admins = "admin"
+//	composer-users = "builder"
+//
+// Static tokens are only stored as the hex-encoded SHA-256 of the token.
+// JSON Web Tokens must be signed with one of the issuer's keys (RS256 or
+// ES256), which are discovered from its /.well-known/openid-configuration
+// unless `jwks_url` is set. The role is taken from `roles_claim` (default
+// "roles", dots descend into objects), whose values are mapped through
+// `roles`, or are role names themselves if there is no mapping. Clients with
+// several roles get the highest one. Clients belong to the tenant of their
+// token or, with `tenant_claim`, to the tenant named by that claim of their
+// JSON Web Token. Other clients belong to the default tenant.
+type Config struct {
+	Tokens []TokenConfig `toml:"tokens" json:"tokens,omitempty"`
+	OIDC   *OIDCConfig   `toml:"oidc" json:"oidc,omitempty"`
+}
+
+type TokenConfig struct {
+	Name        string `toml:"name" json:"name"`
+	TokenSHA256 string `toml:"token_sha256" json:"token_sha256"`
+	Role        Role   `toml:"role" json:"role"`
+	Tenant      string `toml:"tenant" json:"tenant,omitempty"`
+}
+
+type OIDCConfig struct {
+	Issuer      string          `toml:"issuer" json:"issuer"`
+	Audience    string          `toml:"audience" json:"audience"`
+	JWKSURL     string          `toml:"jwks_url" json:"jwks_url,omitempty"`
+	RolesClaim  string          `toml:"roles_claim" json:"roles_claim"`
+	Roles       map[string]Role `toml:"roles" json:"roles,omitempty"`
+	TenantClaim string          `toml:"tenant_claim" json:"tenant_claim,omitempty"`
+}
+
+func LoadConfig(path string) (*Config, error) {
+	var config Config
+	_, err := toml.DecodeFile(path, &config)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load authentication configuration: %v", err)
+	}
+
+	if len(config.Tokens) == 0 && config.OIDC == nil {
+		return nil, fmt.Errorf("%s: neither tokens nor oidc are configured", path)
+	}
+
+	names := make(map[string]bool)
+	for i, token := range config.Tokens {
+		if token.Name == "" {
+			return nil, fmt.Errorf("%s: token without name", path)
+		}
+		if names[token.Name] {
+			return nil, fmt.Errorf("%s: duplicate token %s", path, token.Name)
+		}
+		hash, err := hex.DecodeString(token.TokenSHA256)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("%s: token %s: token_sha256 must be a hex-encoded SHA-256 hash", path, token.Name)
+		}
+		if !token.Role.Valid() {
+			return nil, fmt.Errorf("%s: token %s: unknown role '%s'", path, token.Name, token.Role)
+		}
+		config.Tokens[i].TokenSHA256 = strings.ToLower(token.TokenSHA256)
+		names[token.Name] = true
+	}
+
+	if config.OIDC != nil {
+		if config.OIDC.Issuer == "" || config.OIDC.Audience == "" {
+			return nil, fmt.Errorf("%s: oidc needs an issuer and an audience", path)
+		}
+		if config.OIDC.RolesClaim == "" {
+			config.OIDC.RolesClaim = "roles"
+		}
+		for value, role := range config.OIDC.Roles {
+			if !role.Valid() {
+				return nil, fmt.Errorf("%s: oidc role of '%s' is unknown: '%s'", path, value, role)
+			}
+		}
+	}
+
+	return &config, nil
+}
+
+// Authenticator returns the authenticator described by the configuration.
+func (c *Config) Authenticator() *Authenticator {
+	a := &Authenticator{
+		tokens: make(map[string]Identity),
+	}
+	for _, token := range c.Tokens {
+		a.tokens[token.TokenSHA256] = Identity{Name: token.Name, Role: token.Role, Tenant: token.Tenant}
+	}
+	if c.OIDC != nil {
+		a.oidc = newOIDCVerifier(*c.OIDC)
+	}
+	return a
+}
+
+// An Authenticator identifies clients by their bearer tokens.
+type Authenticator struct {
+	// Identities of static tokens, by the hex-encoded SHA-256 of the token
+	tokens map[string]Identity
+
+	oidc *oidcVerifier
+}
+
+// Authenticate returns the identity of the client sending `request`. It
+// returns ErrNoCredentials if the request doesn't contain a token, and
+// another error if the token is not valid.
+func (a *Authenticator) Authenticate(request *http.Request) (*Identity, error) {
+	header := request.Header.Get("Authorization")
+	if header == "" {
+		return nil, ErrNoCredentials
+	}
+	fields := strings.Fields(header)
+	if len(fields) != 2 || !strings.EqualFold(fields[0], "Bearer") {
+		return nil, errors.New("authorization is not a bearer token")
+	}
+	token := fields[1]
+
+	if strings.Count(token, ".") == 2 && a.oidc != nil {
+		return a.oidc.verify(token)
+	}
+
+	hash := sha256.Sum256([]byte(token))
+	identity, exists := a.tokens[hex.EncodeToString(hash[:])]
+	if !exists {
+		return nil, errors.New("unknown token")
+	}
+
+	return &identity, nil
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of `ctx` which carries `identity`, so that
+// handlers can find out who sent a request.
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the identity stored in `ctx` by WithIdentity,
+// or nil if there is none.
+func IdentityFromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func bearerRequest(token string) *http.Request {
+	request := httptest.NewRequest("GET", "/", nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	return request
+}
+
+func writeConfig(t *testing.T, dir, content string) string {
+	path := filepath.Join(dir, "auth.toml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestRoles(t *testing.T) {
+	require.True(t, RoleAdmin.Includes(RoleReader))
+	require.True(t, RoleBuilder.Includes(RoleBuilder))
+	require.False(t, RoleReader.Includes(RoleBuilder))
+	require.False(t, Role("").Includes(RoleReader))
+	require.False(t, Role("root").Valid())
+}
+
+func TestStaticTokens(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	hash := sha256.Sum256([]byte("s3cret"))
+	path := writeConfig(t, dir, `
+[[tokens]]
+name = "ci"
+token_sha256 = "`+hex.EncodeToString(hash[:])+`"
+role = "builder"
+tenant = "web-team"
+`)
+	config, err := LoadConfig(path)
+	require.NoError(t, err)
+	a := config.Authenticator()
+
+	identity, err := a.Authenticate(bearerRequest("s3cret"))
+	require.NoError(t, err)
+	require.Equal(t, &Identity{Name: "ci", Role: RoleBuilder, Tenant: "web-team"}, identity)
+
+	_, err = a.Authenticate(bearerRequest("guess"))
+	require.Error(t, err)
+
+	_, err = a.Authenticate(bearerRequest(""))
+	require.Equal(t, ErrNoCredentials, err)
+
+	request := bearerRequest("")
+	request.SetBasicAuth("ci", "s3cret")
+	_, err = a.Authenticate(request)
+	require.Error(t, err)
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	configs := []string{
+		``,
+		`[[tokens]]
+		name = "ci"
+		token_sha256 = "abc"
+		role = "builder"`,
+		`[[tokens]]
+		name = "ci"
+		token_sha256 = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+		role = "root"`,
+		`[oidc]
+		issuer = "https://sso.example.com"`,
+		`[oidc]
+		issuer = "https://sso.example.com"
+		audience = "composer"
+		[oidc.roles]
+		users = "user"`,
+	}
+	for _, content := range configs {
+		_, err := LoadConfig(writeConfig(t, dir, content))
+		require.Error(t, err, content)
+	}
+}
+
+func signToken(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, hash[:])
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, hash[:])
+		require.NoError(t, err)
+		signature = make([]byte, 64)
+		rBytes, sBytes := r.Bytes(), s.Bytes()
+		copy(signature[32-len(rBytes):32], rBytes)
+		copy(signature[64-len(sBytes):], sBytes)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDC(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	b64 := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+	jwksFetches := 0
+	var provider *httptest.Server
+	provider = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(writer).Encode(map[string]string{"jwks_uri": provider.URL + "/keys"})
+		case "/keys":
+			jwksFetches++
+			_ = json.NewEncoder(writer).Encode(map[string]interface{}{
+				"keys": []map[string]string{
+					{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(rsaKey.N), "e": b64(big.NewInt(int64(rsaKey.E)))},
+					{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X), "y": b64(ecKey.Y)},
+				},
+			})
+		default:
+			writer.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer provider.Close()
+
+	config := Config{
+		OIDC: &OIDCConfig{
+			Issuer:      provider.URL,
+			Audience:    "composer",
+			RolesClaim:  "realm_access.roles",
+			Roles:       map[string]Role{"composer-users": RoleBuilder, "composer-admins": RoleAdmin},
+			TenantClaim: "org",
+		},
+	}
+	a := config.Authenticator()
+
+	claims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":                provider.URL,
+			"aud":                []string{"account", "composer"},
+			"sub":                "f3c1",
+			"preferred_username": "alice",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"realm_access":       map[string]interface{}{"roles": []string{"offline_access", "composer-users"}},
+			"org":                "web-team",
+		}
+	}
+
+	identity, err := a.Authenticate(bearerRequest(signToken(t, "RS256", "rsa", rsaKey, claims())))
+	require.NoError(t, err)
+	require.Equal(t, &Identity{Name: "alice", Role: RoleBuilder, Tenant: "web-team"}, identity)
+
+	c := claims()
+	c["realm_access"] = map[string]interface{}{"roles": []string{"composer-admins", "composer-users"}}
+	delete(c, "preferred_username")
+	identity, err = a.Authenticate(bearerRequest(signToken(t, "ES256", "ec", ecKey, c)))
+	require.NoError(t, err)
+	require.Equal(t, &Identity{Name: "f3c1", Role: RoleAdmin, Tenant: "web-team"}, identity)
+	require.Equal(t, 1, jwksFetches)
+
+	invalid := map[string]func(c map[string]interface{}){
+		"expired":      func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"not yet":      func(c map[string]interface{}) { c["nbf"] = time.Now().Add(time.Hour).Unix() },
+		"no expiry":    func(c map[string]interface{}) { delete(c, "exp") },
+		"issuer":       func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" },
+		"audience":     func(c map[string]interface{}) { c["aud"] = "account" },
+		"no role":      func(c map[string]interface{}) { c["realm_access"] = map[string]interface{}{"roles": "offline_access"} },
+		"role by name": func(c map[string]interface{}) { c["realm_access"] = map[string]interface{}{"roles": "admin"} },
+		"no tenant":    func(c map[string]interface{}) { delete(c, "org") },
+	}
+	for name, change := range invalid {
+		c := claims()
+		change(c)
+		_, err := a.Authenticate(bearerRequest(signToken(t, "RS256", "rsa", rsaKey, c)))
+		require.Error(t, err, name)
+	}
+
+	// signed with a key of the right id, but the wrong key
+	_, err = a.Authenticate(bearerRequest(signToken(t, "RS256", "rsa", otherKey, claims())))
+	require.Error(t, err)
+
+	// unknown keys cause a refetch, but not more than once a minute
+	_, err = a.Authenticate(bearerRequest(signToken(t, "RS256", "other", otherKey, claims())))
+	require.Error(t, err)
+	require.Equal(t, 1, jwksFetches)
+	a.oidc.fetched = time.Now().Add(-2 * jwksRefetchWait)
+	_, err = a.Authenticate(bearerRequest(signToken(t, "RS256", "other", otherKey, claims())))
+	require.Error(t, err)
+	require.Equal(t, 2, jwksFetches)
+}
@@ -0,0 +1,318 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How much the clocks of composer and the issuer may differ, and how often
+// the issuer's keys are fetched again at most when a token is signed with an
+// unknown key.
+const (
+	clockSkew       = time.Minute
+	jwksRefetchWait = time.Minute
+)
+
+// oidcVerifier verifies JSON Web Tokens issued by an OpenID Connect
+// provider. The provider's keys are fetched when they are first needed and
+// again when a token is signed with a key that isn't known yet.
+type oidcVerifier struct {
+	config OIDCConfig
+	client *http.Client
+
+	mu      sync.Mutex
+	jwksURL string
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+func newOIDCVerifier(config OIDCConfig) *oidcVerifier {
+	return &oidcVerifier{
+		config:  config,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		jwksURL: config.JWKSURL,
+	}
+}
+
+func (v *oidcVerifier) verify(token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token header: %v", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token signature: %v", err)
+	}
+
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch header.Alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, hash[:], signature) != nil {
+			return nil, errors.New("invalid token signature")
+		}
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return nil, errors.New("invalid token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, hash[:], r, s) {
+			return nil, errors.New("invalid token signature")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported token algorithm '%s'", header.Alg)
+	}
+
+	var claims map[string]interface{}
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token claims: %v", err)
+	}
+
+	return v.identity(claims, time.Now())
+}
+
+// identity checks the claims of a token whose signature was verified and
+// returns the identity they describe.
+func (v *oidcVerifier) identity(claims map[string]interface{}, now time.Time) (*Identity, error) {
+	if issuer, _ := claims["iss"].(string); issuer != v.config.Issuer {
+		return nil, fmt.Errorf("token was issued by '%s'", issuer)
+	}
+
+	audienceFound := false
+	for _, audience := range claimStrings(claims["aud"]) {
+		if audience == v.config.Audience {
+			audienceFound = true
+		}
+	}
+	if !audienceFound {
+		return nil, fmt.Errorf("token is not meant for '%s'", v.config.Audience)
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("token doesn't expire")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("token is not valid yet")
+	}
+
+	identity := &Identity{}
+	identity.Name, _ = claims["preferred_username"].(string)
+	if identity.Name == "" {
+		identity.Name, _ = claims["sub"].(string)
+	}
+
+	var value interface{} = claims
+	for _, name := range strings.Split(v.config.RolesClaim, ".") {
+		object, _ := value.(map[string]interface{})
+		value = object[name]
+	}
+	for _, s := range claimStrings(value) {
+		role := Role(s)
+		if len(v.config.Roles) > 0 {
+			role = v.config.Roles[s]
+		}
+		if role.Valid() && role.Includes(identity.Role) {
+			identity.Role = role
+		}
+	}
+	if identity.Role == "" {
+		return nil, fmt.Errorf("token of '%s' doesn't grant a role", identity.Name)
+	}
+
+	if v.config.TenantClaim != "" {
+		identity.Tenant, _ = claims[v.config.TenantClaim].(string)
+		if identity.Tenant == "" {
+			return nil, fmt.Errorf("token of '%s' doesn't name a tenant", identity.Name)
+		}
+	}
+
+	return identity, nil
+}
+
+// key returns the issuer's key with id `kid`.
+func (v *oidcVerifier) key(kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	key, exists := v.keys[kid]
+	if exists {
+		return key, nil
+	}
+
+	if time.Since(v.fetched) < jwksRefetchWait {
+		return nil, fmt.Errorf("token is signed with unknown key '%s'", kid)
+	}
+	v.fetched = time.Now()
+
+	err := v.fetchKeys()
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch keys of %s: %v", v.config.Issuer, err)
+	}
+
+	key, exists = v.keys[kid]
+	if !exists {
+		return nil, fmt.Errorf("token is signed with unknown key '%s'", kid)
+	}
+	return key, nil
+}
+
+func (v *oidcVerifier) fetchKeys() error {
+	if v.jwksURL == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		err := v.getJSON(strings.TrimSuffix(v.config.Issuer, "/")+"/.well-known/openid-configuration", &discovery)
+		if err != nil {
+			return err
+		}
+		if discovery.JWKSURI == "" {
+			return errors.New("provider doesn't announce a jwks_uri")
+		}
+		v.jwksURL = discovery.JWKSURI
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	err := v.getJSON(v.jwksURL, &jwks)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// other keys may still be usable
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	v.keys = keys
+
+	return nil
+}
+
+func (v *oidcVerifier) getJSON(url string, result interface{}) error {
+	response, err := v.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, response.Status)
+	}
+
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// jsonWebKey is a public key as published by OpenID Connect providers (RFC
+// 7517). Only RSA and P-256 keys are supported.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve '%s'", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return key, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type '%s'", jwk.Kty)
+	}
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// claimStrings returns the strings in `claim`, which is either a single
+// string or a list of them.
+func claimStrings(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var strs []string
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				strs = append(strs, s)
+			}
+		}
+		return strs
+	default:
+		return nil
+	}
+}
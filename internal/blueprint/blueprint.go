@@ -16,6 +16,7 @@ type Blueprint struct {
 	Packages       []Package       `json:"packages" toml:"packages"`
 	Modules        []Package       `json:"modules" toml:"modules"`
 	Groups         []Group         `json:"groups" toml:"groups"`
+	EnabledModules []EnabledModule `json:"enabled_modules,omitempty" toml:"enabled_modules,omitempty"`
 	Customizations *Customizations `json:"customizations,omitempty" toml:"customizations,omitempty"`
 }
 
@@ -33,6 +34,15 @@ type Package struct {
 	Version string `json:"version,omitempty" toml:"version,omitempty"`
 }
 
+// An EnabledModule selects the stream of a DNF module (e.g., nodejs:14), so
+// that packages are installed from that stream instead of the default one.
+// Unlike "modules", which are installed like packages, it doesn't install
+// anything by itself.
+type EnabledModule struct {
+	Name   string `json:"name" toml:"name"`
+	Stream string `json:"stream" toml:"stream"`
+}
+
 // A group specifies an package group.
 type Group struct {
 	Name string `json:"name" toml:"name"`
@@ -106,6 +116,16 @@ func (b *Blueprint) GetPackages() []string {
 	return packages
 }
 
+// GetEnabledModules returns the module streams to enable when depsolving the
+// blueprint, as "name:stream" strings.
+func (b *Blueprint) GetEnabledModules() []string {
+	var modules []string
+	for _, m := range b.EnabledModules {
+		modules = append(modules, m.Name+":"+m.Stream)
+	}
+	return modules
+}
+
 func (p Package) ToNameVersion() string {
 	// Omit version to prevent all packages with prefix of name to be installed
 	if p.Version == "*" {
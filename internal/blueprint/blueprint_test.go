@@ -94,3 +94,14 @@ func TestGetPackages(t *testing.T) {
 	Received_packages := bp.GetPackages()
 	assert.ElementsMatch(t, []string{"tmux-1.2", "openssh-server", "@anaconda-tools"}, Received_packages)
 }
+
+func TestGetEnabledModules(t *testing.T) {
+	bp := Blueprint{
+		Name:           "modules-test",
+		EnabledModules: []EnabledModule{{Name: "nodejs", Stream: "14"}, {Name: "postgresql", Stream: "12"}},
+	}
+	assert.Equal(t, []string{"nodejs:14", "postgresql:12"}, bp.GetEnabledModules())
+
+	bp.EnabledModules = nil
+	assert.Empty(t, bp.GetEnabledModules())
+}
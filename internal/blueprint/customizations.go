@@ -1,18 +1,24 @@
 package blueprint
 
+import "strings"
+
 type Customizations struct {
-	Hostname *string                `json:"hostname,omitempty" toml:"hostname,omitempty"`
-	Kernel   *KernelCustomization   `json:"kernel,omitempty" toml:"kernel,omitempty"`
-	SSHKey   []SSHKeyCustomization  `json:"sshkey,omitempty" toml:"sshkey,omitempty"`
-	User     []UserCustomization    `json:"user,omitempty" toml:"user,omitempty"`
-	Group    []GroupCustomization   `json:"group,omitempty" toml:"group,omitempty"`
-	Timezone *TimezoneCustomization `json:"timezone,omitempty" toml:"timezone,omitempty"`
-	Locale   *LocaleCustomization   `json:"locale,omitempty" toml:"locale,omitempty"`
-	Firewall *FirewallCustomization `json:"firewall,omitempty" toml:"firewall,omitempty"`
-	Services *ServicesCustomization `json:"services,omitempty" toml:"services,omitempty"`
+	Hostname   *string                   `json:"hostname,omitempty" toml:"hostname,omitempty"`
+	Kernel     *KernelCustomization      `json:"kernel,omitempty" toml:"kernel,omitempty"`
+	SSHKey     []SSHKeyCustomization     `json:"sshkey,omitempty" toml:"sshkey,omitempty"`
+	User       []UserCustomization       `json:"user,omitempty" toml:"user,omitempty"`
+	Group      []GroupCustomization      `json:"group,omitempty" toml:"group,omitempty"`
+	Timezone   *TimezoneCustomization    `json:"timezone,omitempty" toml:"timezone,omitempty"`
+	Locale     *LocaleCustomization      `json:"locale,omitempty" toml:"locale,omitempty"`
+	Firewall   *FirewallCustomization    `json:"firewall,omitempty" toml:"firewall,omitempty"`
+	Services   *ServicesCustomization    `json:"services,omitempty" toml:"services,omitempty"`
+	Filesystem []FilesystemCustomization `json:"filesystem,omitempty" toml:"filesystem,omitempty"`
 }
 
 type KernelCustomization struct {
+	// The kernel package to install instead of "kernel", for example
+	// "kernel-rt" or "kernel-debug"
+	Name   string `json:"name,omitempty" toml:"name,omitempty"`
 	Append string `json:"append" toml:"append"`
 }
 
@@ -51,6 +57,15 @@ type LocaleCustomization struct {
 type FirewallCustomization struct {
 	Ports    []string                       `json:"ports,omitempty" toml:"ports,omitempty"`
 	Services *FirewallServicesCustomization `json:"services,omitempty" toml:"services,omitempty"`
+	Zones    []FirewallZoneCustomization    `json:"zones,omitempty" toml:"zones,omitempty"`
+}
+
+// A FirewallZoneCustomization assigns traffic from Sources to the firewalld
+// zone Name. Sources are IP addresses, networks in CIDR notation, MAC
+// addresses, or ipsets prefixed with "ipset:".
+type FirewallZoneCustomization struct {
+	Name    string   `json:"name" toml:"name"`
+	Sources []string `json:"sources,omitempty" toml:"sources,omitempty"`
 }
 
 type FirewallServicesCustomization struct {
@@ -63,6 +78,13 @@ type ServicesCustomization struct {
 	Disabled []string `json:"disabled,omitempty" toml:"disabled,omitempty"`
 }
 
+// A FilesystemCustomization requests a filesystem at Mountpoint with at least
+// MinSize bytes. Mountpoints other than "/" get their own partition.
+type FilesystemCustomization struct {
+	Mountpoint string `json:"mountpoint" toml:"mountpoint"`
+	MinSize    uint64 `json:"minsize" toml:"minsize"`
+}
+
 type CustomizationError struct {
 	Message string
 }
@@ -101,24 +123,51 @@ func (c *Customizations) GetTimezoneSettings() (*string, []string) {
 	return c.Timezone.Timezone, c.Timezone.NTPServers
 }
 
+// GetUsers returns the users to create, including those which only have SSH
+// keys set with the sshkey customization. SSH keys of a user are merged into
+// its user customization, unless that sets a key of its own. Multiple keys
+// for the same user are joined into a single authorized_keys file.
 func (c *Customizations) GetUsers() []UserCustomization {
 	if c == nil {
 		return nil
 	}
 
+	keys := make(map[string][]string)
+	var keyUsers []string
+	for _, k := range c.SSHKey {
+		if _, exists := keys[k.User]; !exists {
+			keyUsers = append(keyUsers, k.User)
+		}
+		keys[k.User] = append(keys[k.User], k.Key)
+	}
+
+	defined := make(map[string]bool)
+	for _, u := range c.User {
+		defined[u.Name] = true
+	}
+
 	users := []UserCustomization{}
 
-	// prepend sshkey for backwards compat (overridden by users)
-	if len(c.SSHKey) > 0 {
-		for _, c := range c.SSHKey {
+	// prepend users which only have an sshkey for backwards compat
+	for _, name := range keyUsers {
+		if !defined[name] {
+			key := strings.Join(keys[name], "\n")
 			users = append(users, UserCustomization{
-				Name: c.User,
-				Key:  &c.Key,
+				Name: name,
+				Key:  &key,
 			})
 		}
 	}
 
-	return append(users, c.User...)
+	for _, u := range c.User {
+		if u.Key == nil && len(keys[u.Name]) > 0 {
+			key := strings.Join(keys[u.Name], "\n")
+			u.Key = &key
+		}
+		users = append(users, u)
+	}
+
+	return users
 }
 
 func (c *Customizations) GetGroups() []GroupCustomization {
@@ -161,6 +210,16 @@ func (c *Customizations) GetKernel() *KernelCustomization {
 	return c.Kernel
 }
 
+// GetKernelName returns the name of the kernel package to install, which is
+// "kernel" unless another one was selected.
+func (c *Customizations) GetKernelName() string {
+	if c == nil || c.Kernel == nil || c.Kernel.Name == "" {
+		return "kernel"
+	}
+
+	return c.Kernel.Name
+}
+
 func (c *Customizations) GetFirewall() *FirewallCustomization {
 	if c == nil {
 		return nil
@@ -176,3 +235,11 @@ func (c *Customizations) GetServices() *ServicesCustomization {
 
 	return c.Services
 }
+
+func (c *Customizations) GetFilesystems() []FilesystemCustomization {
+	if c == nil {
+		return nil
+	}
+
+	return c.Filesystem
+}
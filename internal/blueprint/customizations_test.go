@@ -31,6 +31,10 @@ func TestGetKernel(t *testing.T) {
 	retKernel := TestCustomizations.GetKernel()
 
 	assert.Equal(t, &expectedKernel, retKernel)
+	assert.Equal(t, "kernel", TestCustomizations.GetKernelName())
+
+	expectedKernel.Name = "kernel-rt"
+	assert.Equal(t, "kernel-rt", TestCustomizations.GetKernelName())
 }
 
 func TestSSHKey(t *testing.T) {
@@ -89,6 +93,35 @@ func TestGetUsers(t *testing.T) {
 	assert.ElementsMatch(t, expectedUsers, retUsers)
 }
 
+func TestGetUsersMergesSSHKeys(t *testing.T) {
+	Key := "own-key"
+	customizations := Customizations{
+		SSHKey: []SSHKeyCustomization{
+			{User: "root", Key: "root-key-1"},
+			{User: "admin", Key: "admin-key"},
+			{User: "root", Key: "root-key-2"},
+			{User: "keyed", Key: "ignored-key"},
+		},
+		User: []UserCustomization{
+			{Name: "admin"},
+			{Name: "keyed", Key: &Key},
+		},
+	}
+
+	users := customizations.GetUsers()
+	assert.Len(t, users, 3)
+
+	assert.Equal(t, "root", users[0].Name)
+	assert.Equal(t, "root-key-1\nroot-key-2", *users[0].Key)
+	assert.Equal(t, "admin", users[1].Name)
+	assert.Equal(t, "admin-key", *users[1].Key)
+	assert.Equal(t, "keyed", users[2].Name)
+	assert.Equal(t, "own-key", *users[2].Key)
+
+	// the blueprint itself is not modified
+	assert.Nil(t, customizations.User[0].Key)
+}
+
 func TestGetGroups(t *testing.T) {
 
 	GID := 1234
@@ -215,6 +248,7 @@ func TestNoCustomizationsInBlueprint(t *testing.T) {
 	assert.Nil(t, TestBP.Customizations.GetUsers())
 	assert.Nil(t, TestBP.Customizations.GetGroups())
 	assert.Nil(t, TestBP.Customizations.GetKernel())
+	assert.Equal(t, "kernel", TestBP.Customizations.GetKernelName())
 	assert.Nil(t, TestBP.Customizations.GetFirewall())
 	assert.Nil(t, TestBP.Customizations.GetServices())
 
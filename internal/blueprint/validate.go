@@ -0,0 +1,313 @@
+package blueprint
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/coreos/go-semver/semver"
+)
+
+// A ValidationError describes a problem with a field of a blueprint.
+type ValidationError struct {
+	// Path to the offending field, for example "customizations.user[1].name"
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+var (
+	blueprintNameRegex  = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+	packageNameRegex    = regexp.MustCompile(`^[a-zA-Z0-9._+*?-]+$`)
+	packageVersionRegex = regexp.MustCompile(`^[a-zA-Z0-9._+~^*:-]+$`)
+	hostnameLabelRegex  = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+	firewallPortRegex   = regexp.MustCompile(`^([0-9]+(-[0-9]+)?|[a-zA-Z][a-zA-Z0-9-]*):(tcp|udp|sctp|dccp)$`)
+	firewallZoneRegex   = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,17}$`)
+	moduleNameRegex     = regexp.MustCompile(`^[a-zA-Z0-9._+-]+$`)
+)
+
+// Validate checks the blueprint for problems that would make it fail to
+// depsolve or build on any distribution. It returns all problems it found,
+// which is none for valid blueprints. Unlike Initialize, it does not modify
+// the blueprint.
+func (b *Blueprint) Validate() []ValidationError {
+	var errors []ValidationError
+	fail := func(field, format string, a ...interface{}) {
+		errors = append(errors, ValidationError{field, fmt.Sprintf(format, a...)})
+	}
+
+	if b.Name == "" {
+		fail("name", "name is required")
+	} else if !blueprintNameRegex.MatchString(b.Name) {
+		fail("name", "%q may only contain letters, digits, '.', '_', and '-'", b.Name)
+	}
+
+	if b.Version != "" {
+		if _, err := semver.NewVersion(b.Version); err != nil {
+			fail("version", "must use Semantic Versioning: %v", err)
+		}
+	}
+
+	seen := make(map[string]string)
+	checkPackages := func(kind string, packages []Package) {
+		for i, p := range packages {
+			field := fmt.Sprintf("%s[%d]", kind, i)
+			if p.Name == "" {
+				fail(field+".name", "name is required")
+				continue
+			}
+			if !packageNameRegex.MatchString(p.Name) {
+				fail(field+".name", "%q is not a valid package name", p.Name)
+			}
+			if p.Version != "" && !packageVersionRegex.MatchString(p.Version) {
+				fail(field+".version", "%q is not a valid package version", p.Version)
+			}
+			if other, exists := seen[p.Name]; exists {
+				fail(field+".name", "%s is listed more than once, also in %s", p.Name, other)
+			} else {
+				seen[p.Name] = field
+			}
+		}
+	}
+	checkPackages("packages", b.Packages)
+	checkPackages("modules", b.Modules)
+
+	for i, g := range b.Groups {
+		if g.Name == "" {
+			fail(fmt.Sprintf("groups[%d].name", i), "name is required")
+		}
+	}
+
+	enabled := make(map[string]string)
+	for i, m := range b.EnabledModules {
+		field := fmt.Sprintf("enabled_modules[%d]", i)
+		if !moduleNameRegex.MatchString(m.Name) {
+			fail(field+".name", "%q is not a valid module name", m.Name)
+		} else if other, exists := enabled[m.Name]; exists {
+			fail(field+".name", "only one stream of module %s can be enabled, also in %s", m.Name, other)
+		} else {
+			enabled[m.Name] = field
+		}
+		if !moduleNameRegex.MatchString(m.Stream) {
+			fail(field+".stream", "%q is not a valid module stream", m.Stream)
+		}
+	}
+
+	if b.Customizations != nil {
+		errors = append(errors, b.Customizations.validate()...)
+	}
+
+	return errors
+}
+
+func (c *Customizations) validate() []ValidationError {
+	var errors []ValidationError
+	fail := func(field, format string, a ...interface{}) {
+		errors = append(errors, ValidationError{"customizations." + field, fmt.Sprintf(format, a...)})
+	}
+
+	if c.Hostname != nil && !isValidHostname(*c.Hostname) {
+		fail("hostname", "%q is not a valid hostname", *c.Hostname)
+	}
+
+	if c.Kernel != nil {
+		if name := c.Kernel.Name; name != "" && (!strings.HasPrefix(name, "kernel") || !packageNameRegex.MatchString(name)) {
+			fail("kernel.name", "%q is not a kernel package", name)
+		}
+		if strings.ContainsAny(c.Kernel.Append, "\r\n") {
+			fail("kernel.append", "kernel arguments must not contain line breaks")
+		}
+	}
+
+	for i, key := range c.SSHKey {
+		field := fmt.Sprintf("sshkey[%d]", i)
+		if key.User == "" {
+			fail(field+".user", "user is required")
+		} else if hasUserKey(c.User, key.User) {
+			fail(field+".user", "user %s already has a key in its user customization", key.User)
+		}
+		if key.Key == "" {
+			fail(field+".key", "key is required")
+		}
+	}
+
+	users := make(map[string]UserCustomization)
+	uids := make(map[int]string)
+	for i, u := range c.User {
+		field := fmt.Sprintf("user[%d]", i)
+		if u.Name == "" {
+			fail(field+".name", "name is required")
+		} else if _, exists := users[u.Name]; exists {
+			fail(field+".name", "user %s is defined more than once", u.Name)
+		} else {
+			users[u.Name] = u
+		}
+		if u.UID != nil {
+			if *u.UID < 0 {
+				fail(field+".uid", "uid must not be negative")
+			} else if other, exists := uids[*u.UID]; exists {
+				fail(field+".uid", "uid %d is also used by user %s", *u.UID, other)
+			} else {
+				uids[*u.UID] = u.Name
+			}
+		}
+		if u.GID != nil && *u.GID < 0 {
+			fail(field+".gid", "gid must not be negative")
+		}
+	}
+
+	groups := make(map[string]bool)
+	gids := make(map[int]string)
+	for i, g := range c.Group {
+		field := fmt.Sprintf("group[%d]", i)
+		if g.Name == "" {
+			fail(field+".name", "name is required")
+		} else if groups[g.Name] {
+			fail(field+".name", "group %s is defined more than once", g.Name)
+		}
+		groups[g.Name] = true
+		if g.GID == nil {
+			continue
+		}
+		if *g.GID < 0 {
+			fail(field+".gid", "gid must not be negative")
+		} else if other, exists := gids[*g.GID]; exists {
+			fail(field+".gid", "gid %d is also used by group %s", *g.GID, other)
+		} else {
+			gids[*g.GID] = g.Name
+		}
+		// Groups named like a user are not created separately (see
+		// GetGroups), so their gid must be set on the user instead
+		if u, exists := users[g.Name]; exists && (u.GID == nil || *u.GID != *g.GID) {
+			fail(field+".gid", "gid conflicts with the gid of user %s", g.Name)
+		}
+	}
+
+	if c.Firewall != nil {
+		for i, port := range c.Firewall.Ports {
+			if !firewallPortRegex.MatchString(port) {
+				fail(fmt.Sprintf("firewall.ports[%d]", i), "%q must be a port, port range, or service name followed by a protocol, like 22:tcp", port)
+			}
+		}
+		if s := c.Firewall.Services; s != nil {
+			for _, service := range intersect(s.Enabled, s.Disabled) {
+				fail("firewall.services", "%s is both enabled and disabled", service)
+			}
+		}
+		zones := make(map[string]bool)
+		for i, zone := range c.Firewall.Zones {
+			field := fmt.Sprintf("firewall.zones[%d]", i)
+			if !firewallZoneRegex.MatchString(zone.Name) {
+				fail(field+".name", "%q is not a valid zone name", zone.Name)
+			} else if zones[zone.Name] {
+				fail(field+".name", "zone %s is defined more than once", zone.Name)
+			}
+			zones[zone.Name] = true
+			for j, source := range zone.Sources {
+				if !isValidFirewallSource(source) {
+					fail(fmt.Sprintf("%s.sources[%d]", field, j), "%q must be an address, network, MAC address, or ipset", source)
+				}
+			}
+		}
+	}
+
+	if s := c.Services; s != nil {
+		for _, service := range intersect(s.Enabled, s.Disabled) {
+			fail("services", "%s is both enabled and disabled", service)
+		}
+	}
+
+	mountpoints := make(map[string]bool)
+	for i, fs := range c.Filesystem {
+		field := fmt.Sprintf("filesystem[%d]", i)
+		if !path.IsAbs(fs.Mountpoint) || path.Clean(fs.Mountpoint) != fs.Mountpoint {
+			fail(field+".mountpoint", "%q must be a clean, absolute path", fs.Mountpoint)
+		} else if isReservedMountpoint(fs.Mountpoint) {
+			fail(field+".mountpoint", "%s cannot be customized", fs.Mountpoint)
+		} else if mountpoints[fs.Mountpoint] {
+			fail(field+".mountpoint", "%s is defined more than once", fs.Mountpoint)
+		}
+		mountpoints[fs.Mountpoint] = true
+		if fs.MinSize == 0 {
+			fail(field+".minsize", "minsize is required")
+		}
+	}
+
+	return errors
+}
+
+// hasUserKey returns whether `users` defines a user called `name`, which has
+// a key set.
+func hasUserKey(users []UserCustomization, name string) bool {
+	for _, u := range users {
+		if u.Name == name && u.Key != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// isReservedMountpoint returns whether `mountpoint` is or is below a
+// directory that must be part of the root filesystem or is managed by the
+// image type or the running system.
+func isReservedMountpoint(mountpoint string) bool {
+	for _, reserved := range []string{"/boot", "/dev", "/etc", "/proc", "/run", "/sys"} {
+		if mountpoint == reserved || strings.HasPrefix(mountpoint, reserved+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// isValidFirewallSource returns whether `source` can be bound to a firewalld
+// zone.
+func isValidFirewallSource(source string) bool {
+	if strings.HasPrefix(source, "ipset:") {
+		return len(source) > len("ipset:")
+	}
+	if net.ParseIP(source) != nil {
+		return true
+	}
+	if _, _, err := net.ParseCIDR(source); err == nil {
+		return true
+	}
+	_, err := net.ParseMAC(source)
+	return err == nil
+}
+
+func isValidHostname(hostname string) bool {
+	if len(hostname) == 0 || len(hostname) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(hostname, ".") {
+		if !hostnameLabelRegex.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+// intersect returns the strings contained in both `a` and `b`, in the order
+// they appear in `a`.
+func intersect(a, b []string) []string {
+	inB := make(map[string]bool)
+	for _, s := range b {
+		inB[s] = true
+	}
+
+	var both []string
+	for _, s := range a {
+		if inB[s] {
+			both = append(both, s)
+		}
+	}
+	return both
+}
@@ -0,0 +1,142 @@
+package blueprint
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	hostname := "octopus.example.com"
+	uid := 1000
+	valid := Blueprint{
+		Name:           "octopus",
+		Version:        "0.0.1",
+		Packages:       []Package{{Name: "tmux", Version: "3.0*"}, {Name: "python3-*"}},
+		Modules:        []Package{{Name: "httpd"}},
+		Groups:         []Group{{Name: "core"}},
+		EnabledModules: []EnabledModule{{Name: "nodejs", Stream: "14"}, {Name: "postgresql", Stream: "12"}},
+		Customizations: &Customizations{
+			Hostname: &hostname,
+			Kernel:   &KernelCustomization{Name: "kernel-rt", Append: "nosmt"},
+			User:     []UserCustomization{{Name: "admin", UID: &uid}},
+			Firewall: &FirewallCustomization{
+				Ports: []string{"22:tcp", "60000-60010:udp", "imap:tcp"},
+				Zones: []FirewallZoneCustomization{
+					{Name: "trusted", Sources: []string{"192.0.2.0/24", "2001:db8::1", "52:54:00:12:34:56", "ipset:office"}},
+				},
+			},
+			Services: &ServicesCustomization{Enabled: []string{"sshd"}, Disabled: []string{"cockpit"}},
+			Filesystem: []FilesystemCustomization{
+				{Mountpoint: "/", MinSize: 2147483648},
+				{Mountpoint: "/var/lib/containers", MinSize: 10737418240},
+			},
+		},
+	}
+	require.Empty(t, valid.Validate())
+
+	badHostname := "-octopus"
+	negative := -1
+	invalid := Blueprint{
+		Name:           "octo pus",
+		Version:        "1",
+		Packages:       []Package{{Name: "tmux"}, {Name: ""}, {Name: "vim enhanced", Version: "8 2"}},
+		Modules:        []Package{{Name: "tmux"}},
+		Groups:         []Group{{}},
+		EnabledModules: []EnabledModule{{Name: "nodejs", Stream: "14"}, {Name: "nodejs", Stream: "12"}, {Name: "ruby"}},
+		Customizations: &Customizations{
+			Hostname: &badHostname,
+			Kernel:   &KernelCustomization{Name: "linux", Append: "quiet\nsplash"},
+			SSHKey:   []SSHKeyCustomization{{User: "root"}},
+			User:     []UserCustomization{{Name: "admin"}, {Name: "admin", UID: &negative}},
+			Group:    []GroupCustomization{{Name: ""}},
+			Firewall: &FirewallCustomization{
+				Ports: []string{"22"},
+				Zones: []FirewallZoneCustomization{
+					{Name: "trusted", Sources: []string{"192.0.2.0/33"}},
+					{Name: "trusted"},
+					{Name: "zone names are short"},
+				},
+			},
+			Services: &ServicesCustomization{Enabled: []string{"sshd"}, Disabled: []string{"sshd"}},
+			Filesystem: []FilesystemCustomization{
+				{Mountpoint: "var", MinSize: 1024},
+				{Mountpoint: "/home/", MinSize: 1024},
+				{Mountpoint: "/boot/efi", MinSize: 1024},
+				{Mountpoint: "/srv", MinSize: 1024},
+				{Mountpoint: "/srv"},
+			},
+		},
+	}
+
+	var fields []string
+	for _, e := range invalid.Validate() {
+		fields = append(fields, e.Field)
+	}
+	require.Equal(t, []string{
+		"name",
+		"version",
+		"packages[1].name",
+		"packages[2].name",
+		"packages[2].version",
+		"modules[0].name",
+		"groups[0].name",
+		"enabled_modules[1].name",
+		"enabled_modules[2].stream",
+		"customizations.hostname",
+		"customizations.kernel.name",
+		"customizations.kernel.append",
+		"customizations.sshkey[0].key",
+		"customizations.user[1].name",
+		"customizations.user[1].uid",
+		"customizations.group[0].name",
+		"customizations.firewall.ports[0]",
+		"customizations.firewall.zones[0].sources[0]",
+		"customizations.firewall.zones[1].name",
+		"customizations.firewall.zones[2].name",
+		"customizations.services",
+		"customizations.filesystem[0].mountpoint",
+		"customizations.filesystem[1].mountpoint",
+		"customizations.filesystem[2].mountpoint",
+		"customizations.filesystem[4].mountpoint",
+		"customizations.filesystem[4].minsize",
+	}, fields)
+}
+
+func TestValidateUserConflicts(t *testing.T) {
+	key := "ssh-ed25519 AAAA"
+	uid := 1000
+	gid := 1000
+	otherGID := 2000
+	adminGID := 3000
+	conflicting := Blueprint{
+		Name: "octopus",
+		Customizations: &Customizations{
+			SSHKey: []SSHKeyCustomization{
+				{User: "admin", Key: key},
+				{User: "octopus", Key: key},
+			},
+			User: []UserCustomization{
+				{Name: "admin", Key: &key, UID: &uid},
+				{Name: "octopus", UID: &uid, GID: &gid},
+			},
+			Group: []GroupCustomization{
+				{Name: "ink", GID: &otherGID},
+				{Name: "tentacles", GID: &otherGID},
+				{Name: "admin", GID: &adminGID},
+				{Name: "octopus", GID: &gid},
+			},
+		},
+	}
+
+	var fields []string
+	for _, e := range conflicting.Validate() {
+		fields = append(fields, e.Field)
+	}
+	require.Equal(t, []string{
+		"customizations.sshkey[0].user",
+		"customizations.user[1].uid",
+		"customizations.group[1].gid",
+		"customizations.group[2].gid",
+	}, fields)
+}
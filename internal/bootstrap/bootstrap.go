@@ -0,0 +1,211 @@
+// Package bootstrap prepares composer for its first use on a new host.
+//
+// It installs the repositories that the distro ships with to the
+// configuration directory, where administrators can adapt them, adds example
+// blueprints, and checks that packages can be resolved from the repositories.
+// What it did and found is summed up in a report. All steps are idempotent:
+// existing repository configuration and blueprints are kept as they are.
+package bootstrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/distro"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+	"github.com/osbuild/osbuild-composer/internal/store"
+)
+
+// Examples are the blueprints which are added to new hosts.
+var Examples = []blueprint.Blueprint{
+	{
+		Name:        "example-http-server",
+		Description: "An example http server with PHP support.",
+		Version:     "0.0.1",
+		Packages: []blueprint.Package{
+			{Name: "httpd"},
+			{Name: "mod_ssl"},
+			{Name: "php"},
+			{Name: "openssh-server"},
+		},
+		Modules: []blueprint.Package{},
+		Groups:  []blueprint.Group{},
+	},
+	{
+		Name:        "example-development",
+		Description: "A general purpose development image.",
+		Version:     "0.0.1",
+		Packages: []blueprint.Package{
+			{Name: "cmake"},
+			{Name: "gcc"},
+			{Name: "git"},
+			{Name: "make"},
+		},
+		Modules: []blueprint.Package{},
+		Groups:  []blueprint.Group{},
+	},
+}
+
+// The image type whose base packages are resolved with the examples, if the
+// architecture supports it. The first image type is used otherwise.
+const defaultImageType = "qcow2"
+
+type Report struct {
+	Time   time.Time `json:"time"`
+	Distro string    `json:"distro"`
+	Arch   string    `json:"arch"`
+
+	// The repository configuration of the distro, and whether it was
+	// installed by this run
+	Repositories          string `json:"repositories"`
+	RepositoriesInstalled bool   `json:"repositories_installed"`
+
+	RepositoryChecks []RepositoryCheck `json:"repository_checks"`
+	BlueprintChecks  []BlueprintCheck  `json:"blueprint_checks"`
+
+	// Whether all checks passed, i.e., composes can be started
+	Ready bool `json:"ready"`
+}
+
+// A RepositoryCheck reports whether the metadata of a repository could be
+// fetched.
+type RepositoryCheck struct {
+	Name     string `json:"name"`
+	Packages int    `json:"packages"`
+	Error    string `json:"error,omitempty"`
+}
+
+// A BlueprintCheck reports whether the packages of an example blueprint
+// could be resolved, and whether the blueprint was added by this run.
+type BlueprintCheck struct {
+	Name      string `json:"name"`
+	ImageType string `json:"image_type"`
+	Created   bool   `json:"created"`
+	Packages  int    `json:"packages"`
+	Error     string `json:"error,omitempty"`
+}
+
+// InstallRepositories copies the repository configuration `src` to `dst`,
+// unless `dst` already exists. It returns whether it copied it.
+func InstallRepositories(src, dst string) (bool, error) {
+	if _, err := os.Stat(dst); err == nil {
+		return false, nil
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return false, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return false, err
+	}
+
+	err = ioutil.WriteFile(dst, data, 0644)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Run adds the examples which don't exist yet to `s` and checks that they
+// can be resolved from `repos` for `arch`. Examples which cannot be added are
+// reported as failed checks.
+func Run(s *store.Store, rpm rpmmd.RPMMD, d distro.Distro, arch distro.Arch, repos []rpmmd.RepoConfig) *Report {
+	report := &Report{
+		Time:             time.Now(),
+		Distro:           d.Name(),
+		Arch:             arch.Name(),
+		RepositoryChecks: []RepositoryCheck{},
+		BlueprintChecks:  []BlueprintCheck{},
+		Ready:            len(repos) > 0,
+	}
+
+	for _, repo := range repos {
+		check := RepositoryCheck{Name: repo.Id}
+		packages, _, err := rpm.FetchMetadata([]rpmmd.RepoConfig{repo}, d.ModulePlatformID(), arch.Name())
+		if err != nil {
+			check.Error = err.Error()
+			report.Ready = false
+		}
+		check.Packages = len(packages)
+		report.RepositoryChecks = append(report.RepositoryChecks, check)
+	}
+
+	imageType, imageTypeErr := exampleImageType(arch)
+	if imageTypeErr != nil {
+		report.Ready = false
+	}
+
+	for _, example := range Examples {
+		bp := example
+		check := BlueprintCheck{Name: bp.Name}
+
+		if existing := s.GetBlueprintCommitted(bp.Name); existing != nil {
+			bp = *existing
+		} else {
+			err := s.PushBlueprint(bp, "Example blueprint added on first use")
+			if err != nil {
+				check.Error = fmt.Sprintf("cannot add blueprint: %v", err)
+				report.Ready = false
+				report.BlueprintChecks = append(report.BlueprintChecks, check)
+				continue
+			}
+			check.Created = true
+		}
+
+		if imageTypeErr != nil {
+			check.Error = imageTypeErr.Error()
+			report.BlueprintChecks = append(report.BlueprintChecks, check)
+			continue
+		}
+		check.ImageType = imageType.Name()
+
+		specs := []string{}
+		for _, pkg := range bp.Packages {
+			specs = append(specs, pkg.Name)
+		}
+		basePackages, excludePackages := imageType.BasePackages()
+		specs = append(specs, basePackages...)
+
+		packages, _, err := rpm.Depsolve(specs, excludePackages, nil, repos, d.ModulePlatformID(), arch.Name())
+		if err != nil {
+			check.Error = err.Error()
+			report.Ready = false
+		}
+		check.Packages = len(packages)
+		report.BlueprintChecks = append(report.BlueprintChecks, check)
+	}
+
+	return report
+}
+
+func exampleImageType(arch distro.Arch) (distro.ImageType, error) {
+	if imageType, err := arch.GetImageType(defaultImageType); err == nil {
+		return imageType, nil
+	}
+
+	names := arch.ListImageTypes()
+	if len(names) == 0 {
+		return nil, fmt.Errorf("architecture %s has no image types", arch.Name())
+	}
+	return arch.GetImageType(names[0])
+}
+
+// WriteFile writes `report` to `path` as JSON.
+func (report *Report) WriteFile(path string) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
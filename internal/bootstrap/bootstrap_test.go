@@ -0,0 +1,99 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/distro/fedoratest"
+	rpmmd_mock "github.com/osbuild/osbuild-composer/internal/mocks/rpmmd"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+	"github.com/osbuild/osbuild-composer/internal/store"
+)
+
+func TestInstallRepositories(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "usr", "fedora-30.json")
+	require.NoError(t, os.MkdirAll(filepath.Dir(src), 0755))
+	require.NoError(t, ioutil.WriteFile(src, []byte(`{"x86_64":[]}`), 0644))
+
+	dst := filepath.Join(dir, "etc", "repositories", "fedora-30.json")
+	installed, err := InstallRepositories(src, dst)
+	require.NoError(t, err)
+	require.True(t, installed)
+	data, err := ioutil.ReadFile(dst)
+	require.NoError(t, err)
+	require.Equal(t, `{"x86_64":[]}`, string(data))
+
+	// existing configuration is kept
+	require.NoError(t, ioutil.WriteFile(dst, []byte(`{}`), 0644))
+	installed, err = InstallRepositories(src, dst)
+	require.NoError(t, err)
+	require.False(t, installed)
+	data, err = ioutil.ReadFile(dst)
+	require.NoError(t, err)
+	require.Equal(t, `{}`, string(data))
+}
+
+func TestRun(t *testing.T) {
+	d := fedoratest.New()
+	arch, err := d.GetArch("x86_64")
+	require.NoError(t, err)
+	repos := []rpmmd.RepoConfig{{Id: "test-id", BaseURL: "http://example.com/test/os/x86_64"}}
+
+	s := store.New(nil)
+	rpm := rpmmd_mock.NewRPMMDMock(rpmmd_mock.NoComposesFixture())
+
+	report := Run(s, rpm, d, arch, repos)
+	require.True(t, report.Ready)
+	require.Equal(t, "fedora-30", report.Distro)
+	require.Len(t, report.RepositoryChecks, 1)
+	require.Equal(t, "test-id", report.RepositoryChecks[0].Name)
+	require.Len(t, report.BlueprintChecks, len(Examples))
+	for i, check := range report.BlueprintChecks {
+		require.Equal(t, Examples[i].Name, check.Name)
+		require.Equal(t, "qcow2", check.ImageType)
+		require.True(t, check.Created)
+		require.Empty(t, check.Error)
+		require.NotNil(t, s.GetBlueprintCommitted(check.Name))
+	}
+
+	// examples are only added once, and changes to them are kept
+	changed := Examples[0]
+	changed.Packages = []blueprint.Package{{Name: "nginx"}}
+	require.NoError(t, s.PushBlueprint(changed, "use nginx"))
+	report = Run(s, rpm, d, arch, repos)
+	require.True(t, report.Ready)
+	for _, check := range report.BlueprintChecks {
+		require.False(t, check.Created)
+	}
+	require.Equal(t, "nginx", s.GetBlueprintCommitted(changed.Name).Packages[0].Name)
+
+	// problems are reported
+	report = Run(s, rpmmd_mock.NewRPMMDMock(rpmmd_mock.BadFetch()), d, arch, repos)
+	require.False(t, report.Ready)
+	require.NotEmpty(t, report.RepositoryChecks[0].Error)
+	require.NotEmpty(t, report.BlueprintChecks[0].Error)
+
+	report = Run(s, rpm, d, arch, nil)
+	require.False(t, report.Ready)
+
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bootstrap.json")
+	require.NoError(t, report.WriteFile(path))
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	var written map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &written))
+	require.Equal(t, false, written["ready"])
+}
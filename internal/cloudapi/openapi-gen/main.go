@@ -0,0 +1,193 @@
+// openapi-gen generates the request and response types of the cloud API
+// from its OpenAPI definition, along with a copy of the definition that the
+// API serves.
+//
+// It only supports the subset of OpenAPI schemas that the definition uses:
+// objects, arrays, string enums, references, and the primitive types.
+//
+// Usage: openapi-gen <package> <openapi.json> <output.go>
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+type schema struct {
+	Type        string             `json:"type"`
+	Format      string             `json:"format"`
+	Description string             `json:"description"`
+	Ref         string             `json:"$ref"`
+	Items       *schema            `json:"items"`
+	Properties  map[string]*schema `json:"properties"`
+	Required    []string           `json:"required"`
+	Enum        []string           `json:"enum"`
+}
+
+type definition struct {
+	Components struct {
+		Schemas map[string]*schema `json:"schemas"`
+	} `json:"components"`
+}
+
+// Parts of property names which are written in upper case in Go
+var initialisms = map[string]bool{
+	"id":   true,
+	"url":  true,
+	"uuid": true,
+}
+
+func goName(name string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(name, "_") {
+		if initialisms[part] {
+			b.WriteString(strings.ToUpper(part))
+		} else if part != "" {
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return b.String()
+}
+
+func goType(s *schema) (string, error) {
+	if s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/components/schemas/")
+		if name == s.Ref {
+			return "", fmt.Errorf("unsupported reference: %s", s.Ref)
+		}
+		return name, nil
+	}
+
+	switch s.Type {
+	case "string":
+		return "string", nil
+	case "integer":
+		return "int", nil
+	case "boolean":
+		return "bool", nil
+	case "array":
+		if s.Items == nil {
+			return "", fmt.Errorf("array without items")
+		}
+		item, err := goType(s.Items)
+		if err != nil {
+			return "", err
+		}
+		return "[]" + item, nil
+	default:
+		return "", fmt.Errorf("unsupported type: %s", s.Type)
+	}
+}
+
+func comment(b *bytes.Buffer, text, indent string) {
+	if text == "" {
+		return
+	}
+	fmt.Fprintf(b, "%s// %s\n", indent, text)
+}
+
+func generate(pkg string, data []byte) ([]byte, error) {
+	var def definition
+	err := json.Unmarshal(data, &def)
+	if err != nil {
+		return nil, err
+	}
+
+	schemas := def.Components.Schemas
+	var names []string
+	for name := range schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by openapi-gen from openapi.json. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+
+	for _, name := range names {
+		s := schemas[name]
+		switch {
+		case s.Type == "string" && len(s.Enum) > 0:
+			comment(&b, s.Description, "")
+			fmt.Fprintf(&b, "type %s string\n\nconst (\n", name)
+			for _, value := range s.Enum {
+				fmt.Fprintf(&b, "\t%s%s %s = %q\n", name, goName(value), name, value)
+			}
+			fmt.Fprintf(&b, ")\n\n")
+
+		case s.Type == "object":
+			required := make(map[string]bool)
+			for _, property := range s.Required {
+				required[property] = true
+			}
+			var properties []string
+			for property := range s.Properties {
+				properties = append(properties, property)
+			}
+			sort.Strings(properties)
+
+			comment(&b, s.Description, "")
+			fmt.Fprintf(&b, "type %s struct {\n", name)
+			for _, property := range properties {
+				p := s.Properties[property]
+				typ, err := goType(p)
+				if err != nil {
+					return nil, fmt.Errorf("%s.%s: %v", name, property, err)
+				}
+				tag := property
+				if !required[property] {
+					tag += ",omitempty"
+					if p.Ref != "" && schemas[strings.TrimPrefix(p.Ref, "#/components/schemas/")].Type == "object" {
+						typ = "*" + typ
+					}
+				}
+				comment(&b, p.Description, "\t")
+				fmt.Fprintf(&b, "\t%s %s `json:%q`\n", goName(property), typ, tag)
+			}
+			fmt.Fprintf(&b, "}\n\n")
+
+		default:
+			return nil, fmt.Errorf("%s: unsupported schema", name)
+		}
+	}
+
+	// The definition is served by the API, as a raw string
+	if bytes.ContainsRune(data, '`') {
+		return nil, fmt.Errorf("backticks are not supported")
+	}
+	fmt.Fprintf(&b, "// openAPIDefinition is the content of openapi.json\n")
+	fmt.Fprintf(&b, "const openAPIDefinition = `%s`\n", bytes.TrimSpace(data))
+
+	return format.Source(b.Bytes())
+}
+
+func main() {
+	if len(os.Args) != 4 {
+		fmt.Fprintf(os.Stderr, "usage: %s <package> <openapi.json> <output.go>\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	data, err := ioutil.ReadFile(os.Args[2])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	source, err := generate(os.Args[1], data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[2], err)
+		os.Exit(1)
+	}
+
+	err = ioutil.WriteFile(os.Args[3], source, 0644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The generated types must be regenerated whenever the definition changes
+func TestGeneratedCodeIsCurrent(t *testing.T) {
+	definition, err := ioutil.ReadFile("../openapi.json")
+	require.NoError(t, err)
+
+	source, err := generate("cloudapi", definition)
+	require.NoError(t, err)
+
+	generated, err := ioutil.ReadFile("../openapi.gen.go")
+	require.NoError(t, err)
+	require.Equal(t, string(source), string(generated), "run `go generate ./internal/cloudapi`")
+}
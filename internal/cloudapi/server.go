@@ -0,0 +1,535 @@
+// Package cloudapi provides composer's REST API for remote clients, such as
+// services which build images on behalf of their users. Unlike the Weldr
+// API, it doesn't rely on blueprints or sources stored in composer: each
+// request carries everything that is needed to build the images.
+//
+// The API is described by openapi.json, from which the request and response
+// types are generated. Change the definition and run `go generate` instead
+// of editing them.
+package cloudapi
+
+//go:generate go run ./openapi-gen cloudapi openapi.json openapi.gen.go
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/admission"
+	"github.com/osbuild/osbuild-composer/internal/auth"
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/distro"
+	"github.com/osbuild/osbuild-composer/internal/events"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/osbuild"
+	"github.com/osbuild/osbuild-composer/internal/quota"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/target"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+// BasePath is the path all routes of the API are below. Incrementing the
+// version is reserved for incompatible changes.
+const BasePath = "/api/composer/v1"
+
+// Composes created through this API appear in the Weldr API as composes of a
+// blueprint of this name, which doesn't exist.
+const blueprintName = "cloudapi"
+
+// Server serves the cloud API.
+type Server struct {
+	logger  *log.Logger
+	workers *worker.Server
+	router  *httprouter.Router
+	// rpmMetadata is an interface to dnf-json and we include it here so that we can
+	// mock it in the unit tests
+	rpmMetadata   rpmmd.RPMMD
+	distros       *distro.Registry
+	tenants       *store.Tenants
+	authenticator *auth.Authenticator
+	admission     admission.Controller
+}
+
+// NewServer creates a new cloud API server. Composes are kept in the store
+// of the client's tenant.
+func NewServer(logger *log.Logger, workers *worker.Server, rpmMetadata rpmmd.RPMMD, distros *distro.Registry, tenants *store.Tenants) *Server {
+	server := &Server{
+		logger:      logger,
+		workers:     workers,
+		router:      httprouter.New(),
+		rpmMetadata: rpmMetadata,
+		distros:     distros,
+		tenants:     tenants,
+	}
+
+	server.router.RedirectTrailingSlash = false
+	server.router.RedirectFixedPath = false
+	server.router.MethodNotAllowed = http.HandlerFunc(methodNotAllowedHandler)
+	server.router.NotFound = http.HandlerFunc(notFoundHandler)
+
+	server.router.GET(BasePath+"/openapi.json", server.openAPIHandler)
+	server.router.POST(BasePath+"/compose", server.composeHandler)
+	server.router.GET(BasePath+"/compose/:id", server.composeStatusHandler)
+	server.router.GET(BasePath+"/compose/:id/images/:index", server.composeImageHandler)
+
+	return server
+}
+
+// SetAuthenticator requires clients to authenticate. Readers may query
+// composes and download their images, and builders may also create them.
+func (server *Server) SetAuthenticator(authenticator *auth.Authenticator) {
+	server.authenticator = authenticator
+}
+
+// SetAdmission makes `controller` decide about each image request of a
+// compose, like it does for composes of the Weldr API. Quotas are enforced
+// by the stores of the tenants.
+func (server *Server) SetAdmission(controller admission.Controller) {
+	server.admission = controller
+}
+
+// Serve serves the cloud API over the provided listener socket
+func (server *Server) Serve(listener net.Listener) error {
+	s := http.Server{Handler: server}
+
+	err := s.Serve(listener)
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+// ServeHTTP logs the request, authenticates the client, and forwards the
+// request to the appropriate handler
+func (server *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if server.logger != nil {
+		log.Println(request.Method, request.URL.Path)
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	// The definition is public, so that clients can be generated from it
+	if server.authenticator != nil && request.URL.Path != BasePath+"/openapi.json" {
+		identity, err := server.authenticator.Authenticate(request)
+		if err != nil {
+			writer.Header().Set("WWW-Authenticate", `Bearer realm="osbuild-composer"`)
+			errorf(writer, common.ErrorUnauthorized, "authentication failed: %v", err)
+			return
+		}
+
+		role := auth.RoleBuilder
+		if request.Method == "GET" {
+			role = auth.RoleReader
+		}
+		if !identity.Role.Includes(role) {
+			errorf(writer, common.ErrorForbidden, "this request requires the %s role", role)
+			return
+		}
+
+		request = request.WithContext(auth.WithIdentity(request.Context(), identity))
+	}
+
+	server.router.ServeHTTP(writer, request)
+}
+
+// errorf writes an error response, whose body matches the Error schema.
+func errorf(writer http.ResponseWriter, code common.APIErrorCode, format string, args ...interface{}) {
+	common.NewAPIError(code, format, args...).WriteJSON(writer)
+}
+
+func methodNotAllowedHandler(writer http.ResponseWriter, request *http.Request) {
+	errorf(writer, common.ErrorMethodNotAllowed, "method not allowed")
+}
+
+func notFoundHandler(writer http.ResponseWriter, request *http.Request) {
+	errorf(writer, common.ErrorNotFound, "not found")
+}
+
+// requestOwner returns the name of the client sending `request`, or "" if it
+// didn't authenticate.
+func requestOwner(request *http.Request) string {
+	if identity := auth.IdentityFromContext(request.Context()); identity != nil {
+		return identity.Name
+	}
+	return ""
+}
+
+// store returns the store of the tenant of the client sending `request`. It
+// writes an error response and returns nil if there is none.
+func (server *Server) store(writer http.ResponseWriter, request *http.Request) *store.Store {
+	var tenant string
+	if identity := auth.IdentityFromContext(request.Context()); identity != nil {
+		tenant = identity.Tenant
+	}
+
+	s, err := server.tenants.Get(tenant)
+	if err != nil {
+		errorf(writer, common.ErrorForbidden, "cannot use tenant %s: %v", tenant, err)
+		return nil
+	}
+
+	return s
+}
+
+func (server *Server) openAPIHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	_, _ = io.WriteString(writer, openAPIDefinition)
+}
+
+// imageBuild is an image request which was checked and resolved, and is
+// ready to be queued.
+type imageBuild struct {
+	arch          distro.Arch
+	imageType     distro.ImageType
+	size          uint64
+	packages      []rpmmd.PackageSpec
+	buildPackages []rpmmd.PackageSpec
+	manifest      *osbuild.Manifest
+}
+
+func (server *Server) composeHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	if server.workers.Draining() {
+		errorf(writer, common.ErrorShuttingDown, "composer is shutting down and doesn't accept new composes, try again later")
+		return
+	}
+
+	contentType := request.Header["Content-Type"]
+	if len(contentType) != 1 || contentType[0] != "application/json" {
+		errorf(writer, common.ErrorUnsupportedMediaType, "request must contain application/json data")
+		return
+	}
+
+	var composeRequest ComposeRequest
+	decoder := json.NewDecoder(request.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&composeRequest)
+	if err != nil {
+		errorf(writer, common.ErrorInvalidRequest, "%v", err)
+		return
+	}
+
+	d := server.distros.GetDistro(composeRequest.Distribution)
+	if d == nil {
+		errorf(writer, common.ErrorUnknownDistro, "unknown distribution: %s", composeRequest.Distribution)
+		return
+	}
+
+	if len(composeRequest.ImageRequests) == 0 {
+		errorf(writer, common.ErrorInvalidRequest, "image_requests must not be empty")
+		return
+	}
+
+	bp := &blueprint.Blueprint{Name: blueprintName}
+	if composeRequest.Customizations != nil {
+		for _, name := range composeRequest.Customizations.Packages {
+			bp.Packages = append(bp.Packages, blueprint.Package{Name: name})
+		}
+	}
+
+	s := server.store(writer, request)
+	if s == nil {
+		return
+	}
+
+	owner := requestOwner(request)
+	client := owner
+	if client == "" {
+		client = request.RemoteAddr
+	}
+
+	var builds []imageBuild
+	var totalSize uint64
+	for i, imageRequest := range composeRequest.ImageRequests {
+		build, apiError := server.resolveImageRequest(d, bp, imageRequest, client)
+		if apiError != nil {
+			apiError.WithDetails(fmt.Sprintf("image_requests[%d]", i)).WriteJSON(writer)
+			return
+		}
+		builds = append(builds, build)
+		totalSize += build.size
+	}
+
+	// The store checks the quotas again when the compose is pushed, but
+	// checking them before queuing any jobs refuses most composes early
+	err = s.CheckAdmission(owner, totalSize)
+	if err != nil {
+		server.composePushError(writer, err)
+		return
+	}
+
+	composeID := uuid.New()
+	var queued []uuid.UUID
+	pushed := false
+	for i, build := range builds {
+		targets := []*target.Target{target.NewLocalTarget(
+			&target.LocalTargetOptions{
+				ComposeId:    composeID,
+				ImageBuildId: i,
+				Filename:     build.imageType.Filename(),
+			},
+		)}
+
+		var jobID uuid.UUID
+		jobID, err = server.workers.EnqueueForTenant(s.Tenant(), d.Name(), build.arch.Name(), build.manifest, targets, nil, jobqueue.PriorityNormal)
+		if err != nil {
+			break
+		}
+		queued = append(queued, jobID)
+
+		if i == 0 {
+			err = s.PushComposeFor(owner, totalSize, composeID, build.manifest, build.imageType, bp, build.size, targets, nil, jobID)
+			pushed = err == nil
+		} else {
+			_, err = s.AddImageBuild(composeID, build.manifest, build.imageType, build.size, targets, jobID)
+		}
+		if err == nil {
+			err = s.SetImageBuildPackages(composeID, i, build.packages, build.buildPackages)
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	if err != nil {
+		server.unqueueCompose(s, composeID, queued, pushed)
+		server.composePushError(writer, err)
+		return
+	}
+
+	events.Emit(events.ComposeQueued, fmt.Sprintf("Compose %s queued", composeID),
+		"COMPOSE_ID", composeID.String(),
+		"BLUEPRINT", bp.Name)
+
+	writer.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(writer).Encode(ComposeResult{ID: composeID.String()})
+	common.PanicOnError(err)
+}
+
+// unqueueCompose cancels the jobs that were `queued` for a compose, and
+// removes the compose if it was `pushed` already, so that a compose which
+// couldn't be queued completely leaves nothing behind.
+func (server *Server) unqueueCompose(s *store.Store, composeID uuid.UUID, queued []uuid.UUID, pushed bool) {
+	for i := len(queued) - 1; i >= 0; i-- {
+		err := server.workers.CancelJob(queued[i])
+		if err != nil && server.logger != nil {
+			server.logger.Printf("cannot cancel job %s of compose %s: %v", queued[i], composeID, err)
+		}
+	}
+
+	if pushed {
+		err := s.DeleteCompose(composeID)
+		if err != nil && server.logger != nil {
+			server.logger.Printf("cannot remove compose %s: %v", composeID, err)
+		}
+	}
+}
+
+// composePushError writes the error response for `err`, which was returned
+// when queuing a compose.
+func (server *Server) composePushError(writer http.ResponseWriter, err error) {
+	if _, ok := err.(*quota.ExceededError); ok {
+		errorf(writer, common.ErrorQuotaExceeded, "quota exceeded: %v", err)
+		return
+	}
+
+	if server.logger != nil {
+		server.logger.Println("cloud API failed to push compose:", err)
+	}
+	errorf(writer, common.ErrorInternal, "failed to push compose: %v", err)
+}
+
+// resolveImageRequest checks an image request, asks the admission controller
+// whether `client` may build it, resolves its packages, and creates its
+// manifest.
+func (server *Server) resolveImageRequest(d distro.Distro, bp *blueprint.Blueprint, imageRequest ImageRequest, client string) (imageBuild, *common.APIError) {
+	var build imageBuild
+	var err error
+
+	build.arch, err = d.GetArch(imageRequest.Architecture)
+	if err != nil {
+		return build, common.NewAPIError(common.ErrorUnknownArch, "unknown architecture for %s: %s", d.Name(), imageRequest.Architecture)
+	}
+
+	build.imageType, err = build.arch.GetImageType(imageRequest.ImageType)
+	if err != nil {
+		return build, common.NewAPIError(common.ErrorUnknownImageType, "unknown image type for %s: %s", imageRequest.Architecture, imageRequest.ImageType)
+	}
+
+	if len(imageRequest.Repositories) == 0 {
+		return build, common.NewAPIError(common.ErrorInvalidRequest, "repositories must not be empty")
+	}
+	var repos []rpmmd.RepoConfig
+	for i, repo := range imageRequest.Repositories {
+		urls := 0
+		for _, url := range []string{repo.Baseurl, repo.Metalink, repo.Mirrorlist} {
+			if url != "" {
+				urls++
+			}
+		}
+		if urls != 1 {
+			return build, common.NewAPIError(common.ErrorInvalidRequest, "repositories[%d]: exactly one of baseurl, metalink, and mirrorlist must be set", i)
+		}
+		repos = append(repos, rpmmd.RepoConfig{
+			Id:         fmt.Sprintf("repo-%d", i),
+			BaseURL:    repo.Baseurl,
+			Metalink:   repo.Metalink,
+			MirrorList: repo.Mirrorlist,
+			GPGKey:     repo.Gpgkey,
+		})
+	}
+
+	build.size = build.imageType.Size(0)
+	if server.admission != nil {
+		req := &admission.Request{
+			Blueprint: bp,
+			Distro:    d.Name(),
+			Arch:      build.arch.Name(),
+			ImageType: build.imageType.Name(),
+			Size:      build.size,
+			Client:    client,
+		}
+		for _, repo := range repos {
+			url := repo.BaseURL
+			if url == "" {
+				url = repo.Metalink
+			}
+			if url == "" {
+				url = repo.MirrorList
+			}
+			req.Repositories = append(req.Repositories, admission.Repository{Name: repo.Id, URL: url})
+		}
+		admitted, reasons := admission.Check(server.admission, req)
+		if !admitted {
+			return build, common.NewAPIError(common.ErrorForbidden, "compose denied: %s", reasons)
+		}
+	}
+
+	specs, excludeSpecs := build.imageType.BasePackages()
+	specs = append(specs, bp.GetPackages()...)
+	build.packages, _, err = server.rpmMetadata.Depsolve(specs, excludeSpecs, nil, repos, d.ModulePlatformID(), build.arch.Name())
+	if err != nil {
+		return build, common.NewAPIError(common.ErrorDepsolveFailed, "%v", err)
+	}
+
+	build.buildPackages, _, err = server.rpmMetadata.Depsolve(build.imageType.BuildPackages(), nil, nil, repos, d.ModulePlatformID(), build.arch.Name())
+	if err != nil {
+		return build, common.NewAPIError(common.ErrorDepsolveFailed, "%v", err)
+	}
+
+	build.manifest, err = build.imageType.Manifest(nil, repos, build.packages, build.buildPackages, distro.ImageOptions{Size: build.size})
+	if err != nil {
+		return build, common.NewAPIError(common.ErrorManifestCreationFailed, "%v", err)
+	}
+
+	return build, nil
+}
+
+// statuses maps the states of composes and image builds to their status in
+// the API.
+var statuses = map[common.ComposeState]Status{
+	common.CWaiting:  StatusPending,
+	common.CRunning:  StatusBuilding,
+	common.CFinished: StatusSuccess,
+	common.CFailed:   StatusFailure,
+}
+
+func (server *Server) composeStatusHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	s := server.store(writer, request)
+	if s == nil {
+		return
+	}
+
+	composeID, c, ok := getCompose(writer, s, params)
+	if !ok {
+		return
+	}
+
+	state, _, _, _ := server.workers.ComposeState(c)
+	reply := ComposeStatus{
+		Status:        statuses[state],
+		ImageStatuses: []ImageStatus{},
+	}
+	for i := range c.ImageBuilds {
+		ibState, _, _, _ := server.workers.ImageBuildState(c, i)
+		status := ImageStatus{Status: statuses[ibState]}
+		if ibState == common.CFinished {
+			status.ImageURL = fmt.Sprintf("%s/compose/%s/images/%d", BasePath, composeID, i)
+		}
+		reply.ImageStatuses = append(reply.ImageStatuses, status)
+	}
+
+	err := json.NewEncoder(writer).Encode(reply)
+	common.PanicOnError(err)
+}
+
+func (server *Server) composeImageHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	s := server.store(writer, request)
+	if s == nil {
+		return
+	}
+
+	composeID, c, ok := getCompose(writer, s, params)
+	if !ok {
+		return
+	}
+
+	index, err := strconv.Atoi(params.ByName("index"))
+	if err != nil || index < 0 || index >= len(c.ImageBuilds) {
+		errorf(writer, common.ErrorArtifactNotFound, "compose %s has no image %s", composeID, params.ByName("index"))
+		return
+	}
+
+	state, _, _, _ := server.workers.ImageBuildState(c, index)
+	if state != common.CFinished {
+		errorf(writer, common.ErrorComposeWrongState, "image %d of compose %s is %s", index, composeID, statuses[state])
+		return
+	}
+
+	filename, err := s.GetImageBuildFilename(composeID, index)
+	if err == nil && filename == "" {
+		err = fmt.Errorf("compose has no local target")
+	}
+	var image io.ReadCloser
+	var size int64
+	if err == nil {
+		image, size, err = s.GetImageBuildImage(composeID, index)
+	}
+	if err != nil {
+		errorf(writer, common.ErrorArtifactNotFound, "image %d of compose %s is not available: %v", index, composeID, err)
+		return
+	}
+	defer image.Close()
+
+	writer.Header().Set("Content-Type", "application/octet-stream")
+	writer.Header().Set("Content-Disposition", "attachment; filename="+filename)
+	writer.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	_, _ = io.Copy(writer, image)
+}
+
+// getCompose returns the compose whose id is the `id` parameter. It writes
+// an error response and returns false if there is none.
+func getCompose(writer http.ResponseWriter, s *store.Store, params httprouter.Params) (uuid.UUID, compose.Compose, bool) {
+	composeID, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		errorf(writer, common.ErrorInvalidRequest, "malformed compose id: %s", params.ByName("id"))
+		return uuid.Nil, compose.Compose{}, false
+	}
+
+	c, exists := s.GetCompose(composeID)
+	if !exists {
+		errorf(writer, common.ErrorComposeNotFound, "compose %s does not exist", composeID)
+		return uuid.Nil, compose.Compose{}, false
+	}
+
+	return composeID, c, true
+}
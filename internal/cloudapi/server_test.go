@@ -0,0 +1,316 @@
+package cloudapi_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/admission"
+	"github.com/osbuild/osbuild-composer/internal/auth"
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/cloudapi"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/distro/fedoratest"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue/testjobqueue"
+	distro_mock "github.com/osbuild/osbuild-composer/internal/mocks/distro"
+	rpmmd_mock "github.com/osbuild/osbuild-composer/internal/mocks/rpmmd"
+	"github.com/osbuild/osbuild-composer/internal/quota"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/target"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+func newTestServer(t *testing.T, stateDir string) (*cloudapi.Server, *store.Store) {
+	return newTestServerWithQueue(t, stateDir, testjobqueue.New())
+}
+
+func newTestServerWithQueue(t *testing.T, stateDir string, jobs jobqueue.JobQueue) (*cloudapi.Server, *store.Store) {
+	registry, err := distro_mock.NewDefaultRegistry()
+	require.NoError(t, err)
+
+	s := store.New(&stateDir)
+	tenants, err := store.NewTenants(s, nil)
+	require.NoError(t, err)
+
+	workers := worker.NewServer(nil, jobs, nil, "")
+	server := cloudapi.NewServer(nil, workers, rpmmd_mock.NewRPMMDMock(rpmmd_mock.BaseFixture()), registry, tenants)
+
+	return server, s
+}
+
+func send(server *cloudapi.Server, method, path, body, token string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+	request.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	return recorder
+}
+
+const composeRequest = `{
+	"distribution": "fedora-30",
+	"image_requests": [{
+		"architecture": "x86_64",
+		"image_type": "qcow2",
+		"repositories": [{"baseurl": "http://example.com/fedora/30/x86_64/os/"}]
+	}],
+	"customizations": {"packages": ["tmux"]}
+}`
+
+func TestOpenAPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	server, _ := newTestServer(t, dir)
+
+	resp := send(server, "GET", cloudapi.BasePath+"/openapi.json", "", "")
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	// the served definition is the one in the repository
+	definition, err := ioutil.ReadFile("openapi.json")
+	require.NoError(t, err)
+	require.JSONEq(t, string(definition), resp.Body.String())
+}
+
+func TestCompose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	server, s := newTestServer(t, dir)
+
+	var cases = []struct {
+		Body   string
+		Status int
+		Code   string
+	}{
+		{`{"distribution": "fedora-30", "image_requests": []}`, http.StatusBadRequest, "INVALID_REQUEST"},
+		{`{"distribution": "fedora-30", "unknown": true}`, http.StatusBadRequest, "INVALID_REQUEST"},
+		{`{"distribution": "invalid", "image_requests": [{"architecture": "x86_64", "image_type": "qcow2", "repositories": [{"baseurl": "http://example.com"}]}]}`, http.StatusBadRequest, "UNKNOWN_DISTRO"},
+		{`{"distribution": "fedora-30", "image_requests": [{"architecture": "invalid", "image_type": "qcow2", "repositories": [{"baseurl": "http://example.com"}]}]}`, http.StatusBadRequest, "UNKNOWN_ARCH"},
+		{`{"distribution": "fedora-30", "image_requests": [{"architecture": "x86_64", "image_type": "invalid", "repositories": [{"baseurl": "http://example.com"}]}]}`, http.StatusBadRequest, "UNKNOWN_IMAGE_TYPE"},
+		{`{"distribution": "fedora-30", "image_requests": [{"architecture": "x86_64", "image_type": "qcow2", "repositories": [{}]}]}`, http.StatusBadRequest, "INVALID_REQUEST"},
+	}
+	for _, c := range cases {
+		resp := send(server, "POST", cloudapi.BasePath+"/compose", c.Body, "")
+		require.Equalf(t, c.Status, resp.Code, "%s: %s", c.Body, resp.Body.String())
+		var apiError cloudapi.Error
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &apiError))
+		require.Equal(t, c.Code, apiError.Code)
+	}
+
+	resp := send(server, "POST", cloudapi.BasePath+"/compose", composeRequest, "")
+	require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+	var result cloudapi.ComposeResult
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result))
+
+	composeID, err := uuid.Parse(result.ID)
+	require.NoError(t, err)
+	c, exists := s.GetCompose(composeID)
+	require.True(t, exists)
+	require.Equal(t, []blueprint.Package{{Name: "tmux"}}, c.Blueprint.Packages)
+
+	resp = send(server, "GET", cloudapi.BasePath+"/compose/"+result.ID, "", "")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.JSONEq(t, `{"status": "pending", "image_statuses": [{"status": "pending"}]}`, resp.Body.String())
+
+	resp = send(server, "GET", cloudapi.BasePath+"/compose/"+result.ID+"/images/0", "", "")
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	require.Contains(t, resp.Body.String(), "COMPOSE_WRONG_STATE")
+
+	resp = send(server, "GET", cloudapi.BasePath+"/compose/"+uuid.New().String(), "", "")
+	require.Equal(t, http.StatusNotFound, resp.Code)
+	require.Contains(t, resp.Body.String(), "COMPOSE_NOT_FOUND")
+
+	request := httptest.NewRequest("POST", cloudapi.BasePath+"/compose", bytes.NewReader([]byte(composeRequest)))
+	request.Header.Set("Content-Type", "text/plain")
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusUnsupportedMediaType, recorder.Code)
+}
+
+// failingJobQueue fails all calls of Enqueue() after the first `enqueues`.
+type failingJobQueue struct {
+	jobqueue.Canceler
+	enqueues int
+}
+
+func (q *failingJobQueue) Enqueue(jobType string, args interface{}, dependencies []uuid.UUID, priority int) (uuid.UUID, error) {
+	if q.enqueues == 0 {
+		return uuid.Nil, errors.New("the job queue is full")
+	}
+	q.enqueues--
+	return q.Canceler.Enqueue(jobType, args, dependencies, priority)
+}
+
+func TestComposeRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// The first image build is queued and pushed, but queuing the second
+	// one fails
+	queue := &failingJobQueue{testjobqueue.New(), 1}
+	server, s := newTestServerWithQueue(t, dir, queue)
+
+	resp := send(server, "POST", cloudapi.BasePath+"/compose", `{
+		"distribution": "fedora-30",
+		"image_requests": [
+			{"architecture": "x86_64", "image_type": "qcow2", "repositories": [{"baseurl": "http://example.com"}]},
+			{"architecture": "x86_64", "image_type": "openstack", "repositories": [{"baseurl": "http://example.com"}]}
+		]
+	}`, "")
+	require.Equal(t, http.StatusInternalServerError, resp.Code)
+	require.Contains(t, resp.Body.String(), "the job queue is full")
+
+	require.Equal(t, 0, queue.enqueues)
+	require.Empty(t, s.GetAllComposes())
+	_, total, err := queue.ListJobs(jobqueue.JobFilter{}, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, 0, total)
+}
+
+func TestComposeAdmission(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	server, s := newTestServer(t, dir)
+	server.SetAdmission(&admission.Rules{ApprovedRepositories: []string{"http://example.com/fedora/30/x86_64/os"}})
+
+	resp := send(server, "POST", cloudapi.BasePath+"/compose", `{
+		"distribution": "fedora-30",
+		"image_requests": [{
+			"architecture": "x86_64",
+			"image_type": "qcow2",
+			"repositories": [{"baseurl": "http://example.com/unknown/"}]
+		}]
+	}`, "")
+	require.Equal(t, http.StatusForbidden, resp.Code)
+	var apiError cloudapi.Error
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &apiError))
+	require.Equal(t, "FORBIDDEN", apiError.Code)
+	require.Equal(t, "compose denied: repository repo-0 is not approved", apiError.Message)
+	require.Empty(t, s.GetAllComposes())
+
+	resp = send(server, "POST", cloudapi.BasePath+"/compose", composeRequest, "")
+	require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+}
+
+func TestComposeQuota(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	server, s := newTestServer(t, dir)
+	config := &quota.Config{Tenant: quota.Limits{MaxComposesPerDay: 1}}
+	s.SetAdmissionCheck(config.AdmissionCheck(func(c compose.Compose) common.ComposeState {
+		return common.CWaiting
+	}))
+
+	resp := send(server, "POST", cloudapi.BasePath+"/compose", composeRequest, "")
+	require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+
+	resp = send(server, "POST", cloudapi.BasePath+"/compose", composeRequest, "")
+	require.Equal(t, http.StatusInsufficientStorage, resp.Code)
+	var apiError cloudapi.Error
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &apiError))
+	require.Equal(t, "QUOTA_EXCEEDED", apiError.Code)
+	require.Equal(t, "quota exceeded: the default tenant uses 1 of 1 composes_per_day", apiError.Message)
+	require.Len(t, s.GetAllComposes(), 1)
+}
+
+func TestComposeImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	server, s := newTestServer(t, dir)
+
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+
+	composeID := uuid.New()
+	targets := []*target.Target{target.NewLocalTarget(&target.LocalTargetOptions{
+		ComposeId: composeID,
+		Filename:  imageType.Filename(),
+	})}
+	err = s.PushTestCompose(composeID, nil, imageType, &blueprint.Blueprint{Name: "cloudapi"}, 0, targets, nil, true)
+	require.NoError(t, err)
+	imagePath := filepath.Join(dir, "outputs", composeID.String(), "0", imageType.Filename())
+	require.NoError(t, ioutil.WriteFile(imagePath, []byte("image"), 0600))
+
+	resp := send(server, "GET", cloudapi.BasePath+"/compose/"+composeID.String(), "", "")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.JSONEq(t, `{
+		"status": "success",
+		"image_statuses": [{"status": "success", "image_url": "/api/composer/v1/compose/`+composeID.String()+`/images/0"}]
+	}`, resp.Body.String())
+
+	resp = send(server, "GET", cloudapi.BasePath+"/compose/"+composeID.String()+"/images/0", "", "")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "application/octet-stream", resp.Header().Get("Content-Type"))
+	require.Equal(t, "image", resp.Body.String())
+
+	resp = send(server, "GET", cloudapi.BasePath+"/compose/"+composeID.String()+"/images/1", "", "")
+	require.Equal(t, http.StatusNotFound, resp.Code)
+	require.Contains(t, resp.Body.String(), "ARTIFACT_NOT_FOUND")
+}
+
+func TestAuthentication(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	server, _ := newTestServer(t, dir)
+
+	hash := func(token string) string {
+		sum := sha256.Sum256([]byte(token))
+		return hex.EncodeToString(sum[:])
+	}
+	config := auth.Config{
+		Tokens: []auth.TokenConfig{
+			{Name: "dashboard", TokenSHA256: hash("r"), Role: auth.RoleReader},
+			{Name: "ci", TokenSHA256: hash("b"), Role: auth.RoleBuilder, Tenant: "ci"},
+		},
+	}
+	server.SetAuthenticator(config.Authenticator())
+
+	resp := send(server, "GET", cloudapi.BasePath+"/openapi.json", "", "")
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	resp = send(server, "POST", cloudapi.BasePath+"/compose", composeRequest, "")
+	require.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	resp = send(server, "POST", cloudapi.BasePath+"/compose", composeRequest, "r")
+	require.Equal(t, http.StatusForbidden, resp.Code)
+
+	resp = send(server, "POST", cloudapi.BasePath+"/compose", composeRequest, "b")
+	require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+	var result cloudapi.ComposeResult
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result))
+
+	// composes belong to the tenant of the client that created them
+	resp = send(server, "GET", cloudapi.BasePath+"/compose/"+result.ID, "", "b")
+	require.Equal(t, http.StatusOK, resp.Code)
+	resp = send(server, "GET", cloudapi.BasePath+"/compose/"+result.ID, "", "r")
+	require.Equal(t, http.StatusNotFound, resp.Code)
+}
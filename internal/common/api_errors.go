@@ -0,0 +1,153 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// APIErrorCode is a machine-readable identifier of an error returned by one
+// of the HTTP APIs. Clients should match on it instead of parsing messages.
+//
+// There is no COMPOSE_QUEUE_FULL: composer's job queue is unbounded. Composes
+// are only refused for exceeding a limit on concurrent composes, which is
+// QUOTA_EXCEEDED.
+type APIErrorCode string
+
+const (
+	ErrorInvalidRequest         APIErrorCode = "INVALID_REQUEST"
+	ErrorUnsupportedMediaType   APIErrorCode = "UNSUPPORTED_MEDIA_TYPE"
+	ErrorUnauthorized           APIErrorCode = "UNAUTHORIZED"
+	ErrorForbidden              APIErrorCode = "FORBIDDEN"
+	ErrorNotFound               APIErrorCode = "NOT_FOUND"
+	ErrorMethodNotAllowed       APIErrorCode = "METHOD_NOT_ALLOWED"
+	ErrorBlueprintNotFound      APIErrorCode = "BLUEPRINT_NOT_FOUND"
+	ErrorCommitNotFound         APIErrorCode = "COMMIT_NOT_FOUND"
+	ErrorSourceNotFound         APIErrorCode = "SOURCE_NOT_FOUND"
+	ErrorPackageNotFound        APIErrorCode = "PACKAGE_NOT_FOUND"
+	ErrorComposeNotFound        APIErrorCode = "COMPOSE_NOT_FOUND"
+	ErrorComposeWrongState      APIErrorCode = "COMPOSE_WRONG_STATE"
+	ErrorArtifactNotFound       APIErrorCode = "ARTIFACT_NOT_FOUND"
+	ErrorJobNotFound            APIErrorCode = "JOB_NOT_FOUND"
+	ErrorJobNotRunning          APIErrorCode = "JOB_NOT_RUNNING"
+	ErrorUploadOffsetMismatch   APIErrorCode = "UPLOAD_OFFSET_MISMATCH"
+	ErrorImportConflict         APIErrorCode = "IMPORT_CONFLICT"
+	ErrorImageTooLarge          APIErrorCode = "IMAGE_TOO_LARGE"
+	ErrorImageFormatMismatch    APIErrorCode = "IMAGE_FORMAT_MISMATCH"
+	ErrorImageChecksumMismatch  APIErrorCode = "IMAGE_CHECKSUM_MISMATCH"
+	ErrorImagePullFailed        APIErrorCode = "IMAGE_PULL_FAILED"
+	ErrorUnknownDistro          APIErrorCode = "UNKNOWN_DISTRO"
+	ErrorUnknownArch            APIErrorCode = "UNKNOWN_ARCH"
+	ErrorUnknownImageType       APIErrorCode = "UNKNOWN_IMAGE_TYPE"
+	ErrorDepsolveFailed         APIErrorCode = "DEPSOLVE_FAILED"
+	ErrorManifestCreationFailed APIErrorCode = "MANIFEST_CREATION_FAILED"
+	ErrorQuotaExceeded          APIErrorCode = "QUOTA_EXCEEDED"
+	ErrorNoWorkers              APIErrorCode = "NO_WORKERS"
+	ErrorShuttingDown           APIErrorCode = "SHUTTING_DOWN"
+	ErrorInternal               APIErrorCode = "INTERNAL_ERROR"
+)
+
+var apiErrorStatus = map[APIErrorCode]int{
+	ErrorInvalidRequest:         http.StatusBadRequest,
+	ErrorUnsupportedMediaType:   http.StatusUnsupportedMediaType,
+	ErrorUnauthorized:           http.StatusUnauthorized,
+	ErrorForbidden:              http.StatusForbidden,
+	ErrorNotFound:               http.StatusNotFound,
+	ErrorMethodNotAllowed:       http.StatusMethodNotAllowed,
+	ErrorBlueprintNotFound:      http.StatusNotFound,
+	ErrorCommitNotFound:         http.StatusNotFound,
+	ErrorSourceNotFound:         http.StatusNotFound,
+	ErrorPackageNotFound:        http.StatusNotFound,
+	ErrorComposeNotFound:        http.StatusNotFound,
+	ErrorComposeWrongState:      http.StatusBadRequest,
+	ErrorArtifactNotFound:       http.StatusNotFound,
+	ErrorJobNotFound:            http.StatusNotFound,
+	ErrorJobNotRunning:          http.StatusBadRequest,
+	ErrorUploadOffsetMismatch:   http.StatusConflict,
+	ErrorImportConflict:         http.StatusConflict,
+	ErrorImageTooLarge:          http.StatusRequestEntityTooLarge,
+	ErrorImageFormatMismatch:    http.StatusUnprocessableEntity,
+	ErrorImageChecksumMismatch:  http.StatusUnprocessableEntity,
+	ErrorImagePullFailed:        http.StatusBadGateway,
+	ErrorUnknownDistro:          http.StatusBadRequest,
+	ErrorUnknownArch:            http.StatusBadRequest,
+	ErrorUnknownImageType:       http.StatusBadRequest,
+	ErrorDepsolveFailed:         http.StatusBadRequest,
+	ErrorManifestCreationFailed: http.StatusBadRequest,
+	ErrorQuotaExceeded:          http.StatusInsufficientStorage,
+	ErrorNoWorkers:              http.StatusServiceUnavailable,
+	ErrorShuttingDown:           http.StatusServiceUnavailable,
+	ErrorInternal:               http.StatusInternalServerError,
+}
+
+// HTTPStatus returns the HTTP status code that is usually sent along with
+// errors of this kind. Unknown codes map to 500 Internal Server Error.
+func (code APIErrorCode) HTTPStatus() int {
+	status, exists := apiErrorStatus[code]
+	if !exists {
+		return http.StatusInternalServerError
+	}
+	return status
+}
+
+// APIErrorCodeFromHTTPStatus returns a generic error code for `status`, for
+// errors which have nothing more specific to say.
+func APIErrorCodeFromHTTPStatus(status int) APIErrorCode {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrorInvalidRequest
+	case http.StatusUnauthorized:
+		return ErrorUnauthorized
+	case http.StatusForbidden:
+		return ErrorForbidden
+	case http.StatusNotFound:
+		return ErrorNotFound
+	case http.StatusMethodNotAllowed:
+		return ErrorMethodNotAllowed
+	case http.StatusUnsupportedMediaType:
+		return ErrorUnsupportedMediaType
+	default:
+		return ErrorInternal
+	}
+}
+
+// APIError is the error type shared by all HTTP APIs. Each API embeds it
+// into its own wire format, but the code, message and details always have the
+// same meaning.
+type APIError struct {
+	Code    APIErrorCode `json:"code"`
+	Message string       `json:"message"`
+	Details []string     `json:"details,omitempty"`
+}
+
+func NewAPIError(code APIErrorCode, format string, args ...interface{}) *APIError {
+	return &APIError{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// WithDetails returns a copy of `e` with `details` appended.
+func (e *APIError) WithDetails(details ...string) *APIError {
+	n := *e
+	n.Details = append(append([]string{}, e.Details...), details...)
+	return &n
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// HTTPStatus returns the HTTP status code for this error.
+func (e *APIError) HTTPStatus() int {
+	return e.Code.HTTPStatus()
+}
+
+// WriteJSON writes `e` as the JSON body of a response, along with the
+// matching status code.
+func (e *APIError) WriteJSON(writer http.ResponseWriter) {
+	writer.WriteHeader(e.HTTPStatus())
+
+	// ignore error, because we cannot do anything useful with it
+	_ = json.NewEncoder(writer).Encode(e)
+}
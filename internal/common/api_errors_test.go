@@ -0,0 +1,37 @@
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIErrorCodeHTTPStatus(t *testing.T) {
+	assert.Equal(t, http.StatusNotFound, ErrorBlueprintNotFound.HTTPStatus())
+	assert.Equal(t, http.StatusBadRequest, ErrorInvalidRequest.HTTPStatus())
+	assert.Equal(t, http.StatusInternalServerError, APIErrorCode("BOGUS").HTTPStatus())
+}
+
+func TestAPIErrorCodeFromHTTPStatus(t *testing.T) {
+	assert.Equal(t, ErrorNotFound, APIErrorCodeFromHTTPStatus(http.StatusNotFound))
+	assert.Equal(t, ErrorInternal, APIErrorCodeFromHTTPStatus(http.StatusTeapot))
+}
+
+func TestAPIErrorWithDetails(t *testing.T) {
+	e := NewAPIError(ErrorDepsolveFailed, "cannot depsolve %s", "bp")
+	d := e.WithDetails("package foo not found")
+
+	assert.Empty(t, e.Details)
+	assert.Equal(t, []string{"package foo not found"}, d.Details)
+	assert.Equal(t, "DEPSOLVE_FAILED: cannot depsolve bp", d.Error())
+}
+
+func TestAPIErrorWriteJSON(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	NewAPIError(ErrorComposeNotFound, "no such compose").WithDetails("x").WriteJSON(recorder)
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.JSONEq(t, `{"code":"COMPOSE_NOT_FOUND","message":"no such compose","details":["x"]}`, recorder.Body.String())
+}
@@ -4,20 +4,27 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
+	"time"
 )
 
+// Workers measure how long stages take, because osbuild doesn't report it.
+// The durations are zero when they couldn't.
+
 type assembler struct {
-	Name    string          `json:"name"`
-	Options json.RawMessage `json:"options"`
-	Success bool            `json:"success"`
-	Output  string          `json:"output"`
+	Name     string          `json:"name"`
+	Options  json.RawMessage `json:"options"`
+	Success  bool            `json:"success"`
+	Output   string          `json:"output"`
+	Duration time.Duration   `json:"duration,omitempty"`
 }
 
 type stage struct {
-	Name    string          `json:"name"`
-	Options json.RawMessage `json:"options"`
-	Success bool            `json:"success"`
-	Output  string          `json:"output"`
+	Name     string          `json:"name"`
+	Options  json.RawMessage `json:"options"`
+	Success  bool            `json:"success"`
+	Output   string          `json:"output"`
+	Duration time.Duration   `json:"duration,omitempty"`
 }
 
 type build struct {
@@ -35,6 +42,83 @@ type ComposeResult struct {
 	Success   bool       `json:"success"`
 }
 
+// The number of bytes at the end of a stage's output that StageResults()
+// keeps
+const stageOutputSnippetSize = 1024
+
+// A StageResult is how a stage or the assembler of osbuild's pipelines went.
+type StageResult struct {
+	// "build" for the stages of the build pipeline, "tree" for those
+	// which build the image's file system, and "assembler"
+	Pipeline string        `json:"pipeline"`
+	Name     string        `json:"name"`
+	Success  bool          `json:"success"`
+	Duration time.Duration `json:"duration,omitempty"`
+
+	// The end of the stage's output, where errors usually are
+	Output string `json:"output,omitempty"`
+}
+
+// StageResults returns the results of all stages, in the order osbuild ran
+// them.
+func (cr *ComposeResult) StageResults() []StageResult {
+	var results []StageResult
+
+	if cr.Build != nil {
+		for _, s := range cr.Build.Stages {
+			results = append(results, StageResult{"build", s.Name, s.Success, s.Duration, outputSnippet(s.Output)})
+		}
+	}
+	for _, s := range cr.Stages {
+		results = append(results, StageResult{"tree", s.Name, s.Success, s.Duration, outputSnippet(s.Output)})
+	}
+	if cr.Assembler != nil {
+		a := cr.Assembler
+		results = append(results, StageResult{"assembler", a.Name, a.Success, a.Duration, outputSnippet(a.Output)})
+	}
+
+	return results
+}
+
+// SetStageDurations sets how long each stage took, in the order of
+// StageResults(). Extra durations are ignored.
+func (cr *ComposeResult) SetStageDurations(durations []time.Duration) {
+	next := func() time.Duration {
+		if len(durations) == 0 {
+			return 0
+		}
+		d := durations[0]
+		durations = durations[1:]
+		return d
+	}
+
+	if cr.Build != nil {
+		for i := range cr.Build.Stages {
+			cr.Build.Stages[i].Duration = next()
+		}
+	}
+	for i := range cr.Stages {
+		cr.Stages[i].Duration = next()
+	}
+	if cr.Assembler != nil {
+		cr.Assembler.Duration = next()
+	}
+}
+
+// outputSnippet returns the last lines of `output`, which take up to
+// stageOutputSnippetSize bytes.
+func outputSnippet(output string) string {
+	if len(output) <= stageOutputSnippetSize {
+		return output
+	}
+
+	output = output[len(output)-stageOutputSnippetSize:]
+	if i := strings.IndexByte(output, '\n'); i >= 0 {
+		output = output[i+1:]
+	}
+	return output
+}
+
 func (cr *ComposeResult) Write(writer io.Writer) error {
 	if cr.Build == nil && len(cr.Stages) == 0 && cr.Assembler == nil {
 		fmt.Fprintf(writer, "The compose result is empty.\n")
@@ -2,8 +2,11 @@ package common
 
 import (
 	"bytes"
-	"github.com/stretchr/testify/assert"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestWriteFull(t *testing.T) {
@@ -78,3 +81,26 @@ func TestWriteEmpty(t *testing.T) {
 	assert.Equal(t, "The compose result is empty.\n", b.String())
 
 }
+
+func TestStageResults(t *testing.T) {
+	longOutput := strings.Repeat("Installing package\n", 100) + "error: disk full\n"
+	result := ComposeResult{
+		Build:     &build{Stages: []stage{{Name: "org.osbuild.rpm", Success: true, Output: "ok"}}},
+		Stages:    []stage{{Name: "org.osbuild.rpm", Success: true}, {Name: "org.osbuild.selinux", Output: longOutput}},
+		Assembler: &assembler{Name: "org.osbuild.qemu"},
+	}
+	result.SetStageDurations([]time.Duration{time.Minute, 3 * time.Minute, time.Second})
+
+	stages := result.StageResults()
+	assert.Equal(t, []StageResult{
+		{Pipeline: "build", Name: "org.osbuild.rpm", Success: true, Duration: time.Minute, Output: "ok"},
+		{Pipeline: "tree", Name: "org.osbuild.rpm", Success: true, Duration: 3 * time.Minute},
+		{Pipeline: "tree", Name: "org.osbuild.selinux", Duration: time.Second, Output: stages[2].Output},
+		{Pipeline: "assembler", Name: "org.osbuild.qemu"},
+	}, stages)
+
+	// only whole lines at the end of long outputs are kept
+	assert.True(t, len(stages[2].Output) <= stageOutputSnippetSize)
+	assert.True(t, strings.HasPrefix(stages[2].Output, "Installing package\n"))
+	assert.True(t, strings.HasSuffix(stages[2].Output, "error: disk full\n"))
+}
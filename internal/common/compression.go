@@ -0,0 +1,170 @@
+package common
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// Compression methods for artifacts. gzip is built in, while xz and zstd
+// run the xz and zstd tools. All of them compress and decompress while
+// streaming.
+const (
+	CompressionGzip = "gzip"
+	CompressionXZ   = "xz"
+	CompressionZstd = "zstd"
+)
+
+var compressionTools = map[string]string{
+	CompressionXZ:   "xz",
+	CompressionZstd: "zstd",
+}
+
+// IsCompression returns whether `method` is a supported compression method.
+func IsCompression(method string) bool {
+	return method == CompressionGzip || compressionTools[method] != ""
+}
+
+// CompressionAvailable returns whether data can be compressed with `method`
+// on this machine, i.e., whether the tool it needs is installed.
+func CompressionAvailable(method string) bool {
+	if method == CompressionGzip {
+		return true
+	}
+
+	tool, ok := compressionTools[method]
+	if !ok {
+		return false
+	}
+	_, err := exec.LookPath(tool)
+	return err == nil
+}
+
+// CompressionExtension returns the file name extension of files compressed
+// with `method`.
+func CompressionExtension(method string) string {
+	switch method {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionXZ:
+		return ".xz"
+	case CompressionZstd:
+		return ".zst"
+	}
+	return ""
+}
+
+// CompressionMIMEType returns the MIME type of files compressed with
+// `method`.
+func CompressionMIMEType(method string) string {
+	switch method {
+	case CompressionGzip:
+		return "application/gzip"
+	case CompressionXZ:
+		return "application/x-xz"
+	case CompressionZstd:
+		return "application/zstd"
+	}
+	return "application/octet-stream"
+}
+
+// NewCompressor returns a writer which compresses what is written to it
+// with `method` and writes it to `w`. It must be closed to write the end of
+// the compressed data.
+func NewCompressor(w io.Writer, method string) (io.WriteCloser, error) {
+	if method == CompressionGzip {
+		return gzip.NewWriter(w), nil
+	}
+
+	tool, ok := compressionTools[method]
+	if !ok {
+		return nil, fmt.Errorf("unsupported compression: %s", method)
+	}
+
+	cmd := exec.Command(tool, "-c", "-q")
+	cmd.Stdout = w
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("cannot run %s: %v", tool, err)
+	}
+
+	return &compressor{stdin, cmd}, nil
+}
+
+type compressor struct {
+	io.WriteCloser
+	cmd *exec.Cmd
+}
+
+func (c *compressor) Close() error {
+	err := c.WriteCloser.Close()
+	if werr := c.cmd.Wait(); werr != nil {
+		return fmt.Errorf("compressing failed: %v", werr)
+	}
+	return err
+}
+
+// NewDecompressor returns a reader of the data that `r` contains compressed
+// with `method`.
+func NewDecompressor(r io.Reader, method string) (io.ReadCloser, error) {
+	if method == CompressionGzip {
+		return gzip.NewReader(r)
+	}
+
+	tool, ok := compressionTools[method]
+	if !ok {
+		return nil, fmt.Errorf("unsupported compression: %s", method)
+	}
+
+	cmd := exec.Command(tool, "-d", "-c", "-q")
+	cmd.Stdin = r
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("cannot run %s: %v", tool, err)
+	}
+
+	return &decompressor{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+// A decompressor reports errors of the tool when it reaches the end of the
+// data, so that corrupted data isn't mistaken for complete data.
+type decompressor struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	waited bool
+}
+
+func (d *decompressor) Read(p []byte) (int, error) {
+	if d.waited {
+		return 0, io.EOF
+	}
+
+	n, err := d.ReadCloser.Read(p)
+	if err == io.EOF {
+		d.waited = true
+		if werr := d.cmd.Wait(); werr != nil {
+			return n, fmt.Errorf("decompressing failed: %v", werr)
+		}
+	}
+	return n, err
+}
+
+// Close stops the tool if not everything was read.
+func (d *decompressor) Close() error {
+	d.ReadCloser.Close()
+	if !d.waited {
+		d.waited = true
+		_ = d.cmd.Process.Kill()
+		_ = d.cmd.Wait()
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package common
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompression(t *testing.T) {
+	data := bytes.Repeat([]byte("octopus!"), 100000)
+
+	for _, method := range []string{CompressionGzip, CompressionXZ, CompressionZstd} {
+		if tool, ok := compressionTools[method]; ok {
+			if _, err := exec.LookPath(tool); err != nil {
+				t.Logf("skipping %s: %v", method, err)
+				continue
+			}
+		}
+		require.True(t, IsCompression(method))
+		require.True(t, CompressionAvailable(method))
+
+		var compressed bytes.Buffer
+		w, err := NewCompressor(&compressed, method)
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		require.True(t, compressed.Len() < len(data))
+
+		r, err := NewDecompressor(bytes.NewReader(compressed.Bytes()), method)
+		require.NoError(t, err)
+		decompressed, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		require.Equal(t, data, decompressed)
+
+		// truncated data doesn't decompress
+		r, err = NewDecompressor(bytes.NewReader(compressed.Bytes()[:compressed.Len()/2]), method)
+		if err == nil {
+			_, err = ioutil.ReadAll(r)
+			r.Close()
+		}
+		require.Error(t, err, method)
+
+		// stopping early is fine
+		r, err = NewDecompressor(bytes.NewReader(compressed.Bytes()), method)
+		require.NoError(t, err)
+		_, err = r.Read(make([]byte, 10))
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+	}
+
+	require.False(t, IsCompression("lzma"))
+	require.False(t, CompressionAvailable("lzma"))
+	_, err := NewCompressor(ioutil.Discard, "lzma")
+	require.Error(t, err)
+}
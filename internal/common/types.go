@@ -74,6 +74,8 @@ const (
 	RawFilesystem
 	PartitionedDisk
 	TarArchive
+	OSTreeCommit
+	ImageInstaller
 )
 
 // getArchMapping is a helper function that defines the conversion from JSON string value
@@ -89,6 +91,8 @@ func getImageTypeMapping() map[string]int {
 		"Raw-filesystem":   int(RawFilesystem),
 		"Partitioned-disk": int(PartitionedDisk),
 		"Tar":              int(TarArchive),
+		"OSTree-commit":    int(OSTreeCommit),
+		"Image-installer":  int(ImageInstaller),
 	}
 	return mapping
 }
@@ -105,6 +109,8 @@ func getCompatImageTypeMapping() map[int]string {
 		int(RawFilesystem):   "ext4-filesystem",
 		int(PartitionedDisk): "partitioned-disk",
 		int(TarArchive):      "tar",
+		int(OSTreeCommit):    "fedora-iot-commit",
+		int(ImageInstaller):  "image-installer",
 	}
 	return mapping
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/osbuild/osbuild-composer/internal/blueprint"
 	"github.com/osbuild/osbuild-composer/internal/common"
 	"github.com/osbuild/osbuild-composer/internal/osbuild"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
 	"github.com/osbuild/osbuild-composer/internal/target"
 )
 
@@ -31,6 +32,27 @@ type ImageBuild struct {
 	JobFinished time.Time         `json:"job_finished"`
 	Size        uint64            `json:"size"`
 	JobId       uuid.UUID         `json:"jobid,omitempty"`
+	ScanJobId   uuid.UUID         `json:"scan_jobid,omitempty"`
+	SignJobId   uuid.UUID         `json:"sign_jobid,omitempty"`
+
+	// The job which uploads the image of a conversion to its cloud
+	// targets, see Conversion
+	UploadJobId uuid.UUID `json:"upload_jobid,omitempty"`
+
+	// The checksum of the image stored by composer, as "sha256:<hex>".
+	// Empty for older composes.
+	Checksum string `json:"checksum,omitempty"`
+
+	// The packages installed into the image and into the build root, as
+	// they were resolved when the compose was started. Empty for older
+	// composes.
+	Packages      []rpmmd.PackageSpec `json:"packages,omitempty"`
+	BuildPackages []rpmmd.PackageSpec `json:"build_packages,omitempty"`
+
+	// The workers which uploaded the image and finished the image build's
+	// job. Nil for older image builds.
+	UploadedBy *WorkerIdentity `json:"uploaded_by,omitempty"`
+	FinishedBy *WorkerIdentity `json:"finished_by,omitempty"`
 
 	// Kept for backwards compatibility. Image builds which were done
 	// before the move to the job queue use this to store whether they
@@ -50,18 +72,43 @@ func (ib *ImageBuild) DeepCopy() ImageBuild {
 		newTarget := *t
 		newTargets = append(newTargets, &newTarget)
 	}
+	var newPackages []rpmmd.PackageSpec
+	if ib.Packages != nil {
+		newPackages = append([]rpmmd.PackageSpec{}, ib.Packages...)
+	}
+	var newBuildPackages []rpmmd.PackageSpec
+	if ib.BuildPackages != nil {
+		newBuildPackages = append([]rpmmd.PackageSpec{}, ib.BuildPackages...)
+	}
+	var newUploadedBy, newFinishedBy *WorkerIdentity
+	if ib.UploadedBy != nil {
+		uploadedByCopy := *ib.UploadedBy
+		newUploadedBy = &uploadedByCopy
+	}
+	if ib.FinishedBy != nil {
+		finishedByCopy := *ib.FinishedBy
+		newFinishedBy = &finishedByCopy
+	}
 	// Create new image build struct
 	return ImageBuild{
-		Id:          ib.Id,
-		QueueStatus: ib.QueueStatus,
-		ImageType:   ib.ImageType,
-		Manifest:    newManifestPtr,
-		Targets:     newTargets,
-		JobCreated:  ib.JobCreated,
-		JobStarted:  ib.JobStarted,
-		JobFinished: ib.JobFinished,
-		Size:        ib.Size,
-		JobId:       ib.JobId,
+		Id:            ib.Id,
+		QueueStatus:   ib.QueueStatus,
+		ImageType:     ib.ImageType,
+		Manifest:      newManifestPtr,
+		Targets:       newTargets,
+		JobCreated:    ib.JobCreated,
+		JobStarted:    ib.JobStarted,
+		JobFinished:   ib.JobFinished,
+		Size:          ib.Size,
+		Checksum:      ib.Checksum,
+		JobId:         ib.JobId,
+		ScanJobId:     ib.ScanJobId,
+		SignJobId:     ib.SignJobId,
+		UploadJobId:   ib.UploadJobId,
+		Packages:      newPackages,
+		BuildPackages: newBuildPackages,
+		UploadedBy:    newUploadedBy,
+		FinishedBy:    newFinishedBy,
 	}
 }
 
@@ -76,12 +123,128 @@ func (ib *ImageBuild) GetLocalTargetOptions() *target.LocalTargetOptions {
 	return nil
 }
 
+// A WorkerIdentity identifies a worker by the subject and the SHA-256
+// fingerprint of the client certificate it authenticated with. Workers which
+// connect through composer's local socket don't authenticate. They are
+// identified by the subject "local" and have no fingerprint.
+type WorkerIdentity struct {
+	Subject     string `json:"subject"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// A Warning describes a problem with a compose request that was not severe
+// enough to reject it. `ID` is a machine-readable identifier, `Msg` is meant
+// for humans.
+type Warning struct {
+	ID  string `json:"id"`
+	Msg string `json:"msg"`
+}
+
+// A Publication marks a compose as published in the image gallery. The size
+// and checksum of the image are recorded when it is published, so that the
+// gallery doesn't have to read the image to list it.
+type Publication struct {
+	PublishedAt time.Time `json:"published_at"`
+	Size        int64     `json:"size"`
+	Checksum    string    `json:"checksum"`
+}
+
+// An InventoryExport tracks the export of a finished compose to an external
+// inventory system. Failed exports are retried with increasing delays until
+// `Attempts` reaches a limit.
+type InventoryExport struct {
+	ExportedAt  time.Time `json:"exported_at,omitempty"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error,omitempty"`
+	NextAttempt time.Time `json:"next_attempt,omitempty"`
+}
+
+// A Promotion records that the image of a compose was uploaded to the targets
+// of a promotion stage (e.g., "staging" or "prod") after it was built.
+// `Status` is IBWaiting until the upload job is done.
+type Promotion struct {
+	Stage       string                 `json:"stage"`
+	JobId       uuid.UUID              `json:"jobid"`
+	Status      common.ImageBuildState `json:"status"`
+	RequestedAt time.Time              `json:"requested_at"`
+	FinishedAt  time.Time              `json:"finished_at,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+}
+
+// A Registration records that the image of a compose was built elsewhere and
+// registered with composer afterwards, so that it takes part in retention,
+// inventory, promotion, and comparisons like the images composer builds.
+// Images in the cloud are identified by `ImageID` (e.g., an AMI ID) or `URL`
+// (e.g., of an Azure VHD). Images uploaded to composer have neither.
+type Registration struct {
+	Provider     string            `json:"provider"`
+	ImageID      string            `json:"image_id,omitempty"`
+	Region       string            `json:"region,omitempty"`
+	URL          string            `json:"url,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	RegisteredAt time.Time         `json:"registered_at"`
+}
+
+// A Conversion records that the image of a compose was not built from a
+// blueprint, but converted from an uploaded image to `Format` (the output
+// format of qemu-img, e.g., "vpc" for VHD). An empty `Format` means the
+// uploaded image is used as it is.
+type Conversion struct {
+	Format      string    `json:"format,omitempty"`
+	InputSize   uint64    `json:"input_size"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// A Repository is a repository that the packages of a compose were resolved
+// from, as it was configured when the compose was started. `Checksum` is the
+// checksum of the repository's metadata that was used.
+type Repository struct {
+	rpmmd.RepoConfig
+	Checksum string `json:"checksum,omitempty"`
+}
+
 // A Compose represent the task of building a set of images from a single blueprint.
 // It contains all the information necessary to generate the inputs for the job, as
 // well as the job's state.
 type Compose struct {
 	Blueprint   *blueprint.Blueprint `json:"blueprint"`
 	ImageBuilds []ImageBuild         `json:"image_builds"`
+	Warnings    []Warning            `json:"warnings,omitempty"`
+	Publication *Publication         `json:"publication,omitempty"`
+
+	InventoryExport *InventoryExport `json:"inventory_export,omitempty"`
+
+	// Whether notifications about the compose's completion were sent
+	Notified bool `json:"notified,omitempty"`
+
+	// The webhook callbacks about the compose that were delivered, see
+	// notify.WebhookDispatcher
+	WebhooksDelivered []string `json:"webhooks_delivered,omitempty"`
+
+	// All promotions of the compose, in the order they were requested
+	Promotions []Promotion `json:"promotions,omitempty"`
+
+	// Set for composes of images that composer didn't build
+	Registration *Registration `json:"registration,omitempty"`
+
+	// Set for composes which convert an uploaded image
+	Conversion *Conversion `json:"conversion,omitempty"`
+
+	// The repositories the packages were resolved from. Empty for older
+	// composes.
+	Repositories []Repository `json:"repositories,omitempty"`
+
+	// The tenant that owns the compose, empty for the default tenant (see
+	// store.Tenants)
+	Tenant string `json:"tenant,omitempty"`
+
+	// The name of the client that requested the compose, if it
+	// authenticated (see auth.Identity)
+	Owner string `json:"owner,omitempty"`
+
+	// The compose that this compose rebuilds, if it was started as a
+	// rebuild of another one
+	RebuildOf uuid.UUID `json:"rebuild_of,omitempty"`
 }
 
 // DeepCopy creates a copy of the Compose structure
@@ -95,9 +258,63 @@ func (c *Compose) DeepCopy() Compose {
 	for _, ib := range c.ImageBuilds {
 		newImageBuilds = append(newImageBuilds, ib.DeepCopy())
 	}
+	var newWarnings []Warning
+	if c.Warnings != nil {
+		newWarnings = append([]Warning{}, c.Warnings...)
+	}
+	var newPublication *Publication
+	if c.Publication != nil {
+		publicationCopy := *c.Publication
+		newPublication = &publicationCopy
+	}
+	var newInventoryExport *InventoryExport
+	if c.InventoryExport != nil {
+		inventoryExportCopy := *c.InventoryExport
+		newInventoryExport = &inventoryExportCopy
+	}
+	var newPromotions []Promotion
+	if c.Promotions != nil {
+		newPromotions = append([]Promotion{}, c.Promotions...)
+	}
+	var newRegistration *Registration
+	if c.Registration != nil {
+		registrationCopy := *c.Registration
+		if c.Registration.Metadata != nil {
+			registrationCopy.Metadata = make(map[string]string)
+			for k, v := range c.Registration.Metadata {
+				registrationCopy.Metadata[k] = v
+			}
+		}
+		newRegistration = &registrationCopy
+	}
+	var newConversion *Conversion
+	if c.Conversion != nil {
+		conversionCopy := *c.Conversion
+		newConversion = &conversionCopy
+	}
+	var newRepositories []Repository
+	if c.Repositories != nil {
+		newRepositories = append([]Repository{}, c.Repositories...)
+	}
+	var newWebhooksDelivered []string
+	if c.WebhooksDelivered != nil {
+		newWebhooksDelivered = append([]string{}, c.WebhooksDelivered...)
+	}
 	return Compose{
-		Blueprint:   newBpPtr,
-		ImageBuilds: newImageBuilds,
+		Blueprint:         newBpPtr,
+		ImageBuilds:       newImageBuilds,
+		Warnings:          newWarnings,
+		Publication:       newPublication,
+		InventoryExport:   newInventoryExport,
+		Notified:          c.Notified,
+		WebhooksDelivered: newWebhooksDelivered,
+		Promotions:        newPromotions,
+		Registration:      newRegistration,
+		Conversion:        newConversion,
+		Repositories:      newRepositories,
+		Tenant:            c.Tenant,
+		Owner:             c.Owner,
+		RebuildOf:         c.RebuildOf,
 	}
 }
 
@@ -0,0 +1,184 @@
+// Package config records the effective configuration of a running
+// osbuild-composer, so that operators can verify what it actually uses.
+//
+// osbuild-composer is configured through command line flags, environment
+// variables set by systemd, and configuration files. Each of these is
+// recorded as a Setting, together with where its value came from. Secrets,
+// like passwords in configuration files, are redacted before settings are
+// handed out.
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A Source describes where the value of a setting came from.
+type Source string
+
+const (
+	SourceDefault     Source = "default"
+	SourceFlag        Source = "flag"
+	SourceEnvironment Source = "environment"
+	SourceFile        Source = "file"
+)
+
+// Redacted replaces the values of secrets.
+const Redacted = "<redacted>"
+
+type Setting struct {
+	Name   string      `json:"name"`
+	Value  interface{} `json:"value"`
+	Source Source      `json:"source"`
+
+	// The file the value was read from, if Source is SourceFile
+	Path string `json:"path,omitempty"`
+}
+
+// Effective is a set of settings. It is safe to use from multiple
+// goroutines.
+type Effective struct {
+	mu       sync.Mutex
+	settings map[string]Setting
+}
+
+func New() *Effective {
+	return &Effective{
+		settings: make(map[string]Setting),
+	}
+}
+
+// Set records setting `name`, replacing a previous value.
+func (e *Effective) Set(name string, value interface{}, source Source) {
+	e.set(Setting{Name: name, Value: value, Source: source})
+}
+
+// SetSecret records a setting whose value must not be handed out.
+func (e *Effective) SetSecret(name string, value string, source Source) {
+	if value != "" {
+		value = Redacted
+	}
+	e.set(Setting{Name: name, Value: value, Source: source})
+}
+
+// SetFile records setting `name`, which was read from the file at `path`.
+// `content` is the parsed content of the file. Secrets in it are redacted.
+func (e *Effective) SetFile(name, path string, content interface{}) {
+	e.set(Setting{Name: name, Value: Redact(content), Source: SourceFile, Path: path})
+}
+
+// AddFlags records all flags of `fs`, which must have been parsed. Flags that
+// were not given on the command line are recorded with their defaults.
+func (e *Effective) AddFlags(fs *flag.FlagSet) {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	fs.VisitAll(func(f *flag.Flag) {
+		source := SourceDefault
+		if set[f.Name] {
+			source = SourceFlag
+		}
+
+		var value interface{} = f.Value.String()
+		if getter, ok := f.Value.(flag.Getter); ok {
+			value = getter.Get()
+		}
+
+		e.Set("flag."+f.Name, value, source)
+	})
+}
+
+func (e *Effective) set(s Setting) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.settings[s.Name] = s
+}
+
+// Settings returns all settings, sorted by name.
+func (e *Effective) Settings() []Setting {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	settings := make([]Setting, 0, len(e.settings))
+	for _, s := range e.settings {
+		settings = append(settings, s)
+	}
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Name < settings[j].Name })
+
+	return settings
+}
+
+// secretFields are the fields of configuration files that contain secrets,
+// in lower case. Fields without JSON tags are named like their Go field.
+var secretFields = map[string]bool{
+	"password":         true, // SMTP password of notify, VMware targets
+	"secretaccesskey":  true, // AWS targets
+	"storageaccesskey": true, // Azure targets
+	"token_sha256":     true, // hashes of API tokens
+	"secret":           true, // webhook secrets
+}
+
+// stripUserinfo removes user names and passwords from `s` if it is a URL.
+func stripUserinfo(s string) string {
+	if !strings.Contains(s, "://") {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	u.User = nil
+	return u.String()
+}
+
+// Redact returns the JSON representation of `v` (as maps, slices, and
+// values), with the string values of secret fields replaced by Redacted, and
+// credentials removed from URLs. It returns nil if `v` cannot be represented
+// as JSON.
+func Redact(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+
+	var generic interface{}
+	err = json.Unmarshal(data, &generic)
+	if err != nil {
+		return nil
+	}
+
+	return redact(generic)
+}
+
+func redact(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, field := range value {
+			if s, ok := field.(string); ok && s != "" && secretFields[strings.ToLower(k)] {
+				value[k] = Redacted
+				continue
+			}
+			value[k] = redact(field)
+		}
+		return value
+
+	case []interface{}:
+		for i := range value {
+			value[i] = redact(value[i])
+		}
+		return value
+
+	case string:
+		return stripUserinfo(value)
+
+	default:
+		return v
+	}
+}
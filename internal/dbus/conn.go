@@ -0,0 +1,564 @@
+// Package dbus implements the parts of the D-Bus protocol that composer
+// needs to offer a service on a message bus: connecting to the system or
+// session bus, owning a well-known name, answering method calls, and emitting
+// signals.
+//
+// It is deliberately small. Only unix sockets and the EXTERNAL
+// authentication mechanism are supported, and file descriptors cannot be
+// passed. That is all composer's service needs, and it is less than
+// vendoring a general D-Bus library (and its dependencies) would add. As
+// messages come from any peer on the bus, messages that cannot be decoded
+// only fail the call they belong to, and never the connection.
+package dbus
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	busName      = "org.freedesktop.DBus"
+	busPath      = ObjectPath("/org/freedesktop/DBus")
+	busInterface = "org.freedesktop.DBus"
+)
+
+// Standard error names
+const (
+	ErrFailed        = "org.freedesktop.DBus.Error.Failed"
+	ErrInvalidArgs   = "org.freedesktop.DBus.Error.InvalidArgs"
+	ErrUnknownMethod = "org.freedesktop.DBus.Error.UnknownMethod"
+	ErrUnknownObject = "org.freedesktop.DBus.Error.UnknownObject"
+)
+
+// An Error is a D-Bus error reply. Methods return it to send an error with a
+// specific name. All other errors are sent as ErrFailed.
+type Error struct {
+	Name    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Name, e.Message)
+}
+
+// A Method is a method of an exported interface. `In` and `Out` are the
+// signatures of its arguments and return values. Call is only invoked with
+// arguments matching `In`, decoded as described in Message.
+type Method struct {
+	In   Signature
+	Out  Signature
+	Call func(args []interface{}) ([]interface{}, error)
+}
+
+// An Interface is a set of methods and signals exported on an object.
+// Signals maps the name of each signal to its signature.
+type Interface struct {
+	Methods map[string]Method
+	Signals map[string]Signature
+}
+
+type Conn struct {
+	conn io.ReadWriteCloser
+
+	// Unique name of the connection on the bus
+	name string
+
+	writeMutex sync.Mutex
+
+	mutex   sync.Mutex
+	serial  uint32
+	pending map[uint32]chan *Message
+	objects map[ObjectPath]map[string]Interface
+	err     error
+}
+
+// SystemBus connects to the system bus.
+func SystemBus() (*Conn, error) {
+	address := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS")
+	if address == "" {
+		address = "unix:path=/var/run/dbus/system_bus_socket"
+	}
+	return Dial(address)
+}
+
+// SessionBus connects to the session bus of the current user.
+func SessionBus() (*Conn, error) {
+	address := os.Getenv("DBUS_SESSION_BUS_ADDRESS")
+	if address == "" {
+		return nil, errors.New("DBUS_SESSION_BUS_ADDRESS is not set")
+	}
+	return Dial(address)
+}
+
+// Dial connects to the message bus at `address`, which is a D-Bus server
+// address like "unix:path=/run/dbus/system_bus_socket". Of several
+// addresses separated by semicolons, the first one that works is used.
+func Dial(address string) (*Conn, error) {
+	var errs []string
+	for _, a := range strings.Split(address, ";") {
+		path, err := unixPath(a)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		c, err := newConn(conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+
+	return nil, fmt.Errorf("cannot connect to %s: %s", address, strings.Join(errs, "; "))
+}
+
+// unixPath returns the socket path of the unix transport `address`.
+// Abstract sockets are prefixed with "@", as the net package expects.
+func unixPath(address string) (string, error) {
+	if !strings.HasPrefix(address, "unix:") {
+		return "", fmt.Errorf("unsupported transport: %s", address)
+	}
+
+	for _, kv := range strings.Split(strings.TrimPrefix(address, "unix:"), ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value, err := unescapeAddress(parts[1])
+		if err != nil {
+			return "", err
+		}
+		switch parts[0] {
+		case "path":
+			return value, nil
+		case "abstract":
+			return "@" + value, nil
+		}
+	}
+
+	return "", fmt.Errorf("no socket path in address: %s", address)
+}
+
+// unescapeAddress decodes the %-escapes of a value in a server address.
+func unescapeAddress(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", fmt.Errorf("invalid escape in address: %s", s)
+		}
+		c, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in address: %s", s)
+		}
+		b.WriteByte(byte(c))
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// newConn authenticates on `conn` and registers with the bus.
+func newConn(conn io.ReadWriteCloser) (*Conn, error) {
+	reader := bufio.NewReader(conn)
+	err := authenticate(conn, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newPeerConn(conn, reader)
+
+	reply, err := c.Call(busName, busPath, busInterface, "Hello")
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if len(reply) != 1 {
+		c.Close()
+		return nil, errors.New("invalid reply to Hello")
+	}
+	c.name, _ = reply[0].(string)
+
+	return c, nil
+}
+
+// newPeerConn starts reading messages from `conn`, on which authentication
+// has already happened.
+func newPeerConn(conn io.ReadWriteCloser, reader io.Reader) *Conn {
+	c := &Conn{
+		conn:    conn,
+		pending: make(map[uint32]chan *Message),
+		objects: make(map[ObjectPath]map[string]Interface),
+	}
+	go c.readLoop(reader)
+	return c
+}
+
+// authenticate runs the EXTERNAL authentication mechanism with the uid of
+// the process.
+func authenticate(w io.Writer, r *bufio.Reader) error {
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	_, err := fmt.Fprintf(w, "\x00AUTH EXTERNAL %s\r\n", uid)
+	if err != nil {
+		return err
+	}
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return fmt.Errorf("authentication failed: %s", strings.TrimSpace(line))
+	}
+
+	_, err = io.WriteString(w, "BEGIN\r\n")
+	return err
+}
+
+// Name returns the unique name of the connection on the bus.
+func (c *Conn) Name() string {
+	return c.name
+}
+
+// Close closes the connection. Pending calls fail.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Conn) readLoop(r io.Reader) {
+	for {
+		m, err := readMessage(r)
+		if invalid, ok := err.(*invalidMessageError); ok {
+			c.handleInvalid(invalid)
+			continue
+		}
+		if err != nil {
+			c.mutex.Lock()
+			c.err = err
+			for serial, ch := range c.pending {
+				close(ch)
+				delete(c.pending, serial)
+			}
+			c.mutex.Unlock()
+			return
+		}
+
+		switch m.Type {
+		case TypeMethodReturn, TypeError:
+			c.deliver(m)
+
+		case TypeMethodCall:
+			go c.handleCall(m)
+		}
+	}
+}
+
+// deliver hands the reply `m` to the call waiting for it.
+func (c *Conn) deliver(m *Message) {
+	c.mutex.Lock()
+	ch, exists := c.pending[m.ReplySerial]
+	delete(c.pending, m.ReplySerial)
+	c.mutex.Unlock()
+	if exists {
+		ch <- m
+	}
+}
+
+// handleInvalid fails the call or the pending call that the message which
+// couldn't be decoded belongs to.
+func (c *Conn) handleInvalid(invalid *invalidMessageError) {
+	m := invalid.message
+	switch m.Type {
+	case TypeMethodReturn, TypeError:
+		c.deliver(&Message{
+			Type:        TypeError,
+			ReplySerial: m.ReplySerial,
+			ErrorName:   ErrFailed,
+			Body:        []interface{}{"invalid reply: " + invalid.Error()},
+		})
+
+	case TypeMethodCall:
+		if m.Flags&FlagNoReplyExpected != 0 {
+			return
+		}
+		go func() {
+			// The caller might have gone away; there is no one to tell
+			_ = c.send(&Message{
+				Type:        TypeError,
+				ReplySerial: m.Serial,
+				Destination: m.Sender,
+				ErrorName:   ErrInvalidArgs,
+				Body:        []interface{}{invalid.Error()},
+			}, nil)
+		}()
+	}
+}
+
+// send assigns a serial to `m` and sends it.
+func (c *Conn) send(m *Message, reply chan *Message) error {
+	c.mutex.Lock()
+	if c.err != nil {
+		c.mutex.Unlock()
+		return c.err
+	}
+	c.serial++
+	m.Serial = c.serial
+	if reply != nil {
+		c.pending[m.Serial] = reply
+	}
+	c.mutex.Unlock()
+
+	data, err := m.marshal()
+	if err == nil {
+		c.writeMutex.Lock()
+		_, err = c.conn.Write(data)
+		c.writeMutex.Unlock()
+	}
+
+	if err != nil && reply != nil {
+		c.mutex.Lock()
+		delete(c.pending, m.Serial)
+		c.mutex.Unlock()
+	}
+
+	return err
+}
+
+// Call calls `method` of `iface` on the object at `path` of the peer with
+// name `dest`, and waits for its reply.
+func (c *Conn) Call(dest string, path ObjectPath, iface, method string, args ...interface{}) ([]interface{}, error) {
+	reply := make(chan *Message, 1)
+	err := c.send(&Message{
+		Type:        TypeMethodCall,
+		Path:        path,
+		Interface:   iface,
+		Member:      method,
+		Destination: dest,
+		Body:        args,
+	}, reply)
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := <-reply
+	if !ok {
+		c.mutex.Lock()
+		err := c.err
+		c.mutex.Unlock()
+		return nil, fmt.Errorf("connection closed: %v", err)
+	}
+
+	if m.Type == TypeError {
+		e := &Error{Name: m.ErrorName}
+		if len(m.Body) > 0 {
+			e.Message, _ = m.Body[0].(string)
+		}
+		return nil, e
+	}
+
+	return m.Body, nil
+}
+
+// RequestName asks the bus to assign the well-known `name` to the
+// connection. It fails if another connection owns the name already.
+func (c *Conn) RequestName(name string) error {
+	// DBUS_NAME_FLAG_DO_NOT_QUEUE
+	reply, err := c.Call(busName, busPath, busInterface, "RequestName", name, uint32(4))
+	if err != nil {
+		return err
+	}
+
+	// DBUS_REQUEST_NAME_REPLY_PRIMARY_OWNER or _ALREADY_OWNER
+	if len(reply) != 1 || (reply[0] != uint32(1) && reply[0] != uint32(4)) {
+		return fmt.Errorf("cannot own name %s: it is in use", name)
+	}
+
+	return nil
+}
+
+// Export makes the methods of `iface` callable on the object at `path`.
+// Objects are introspectable.
+func (c *Conn) Export(path ObjectPath, name string, iface Interface) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.objects[path] == nil {
+		c.objects[path] = make(map[string]Interface)
+	}
+	c.objects[path][name] = iface
+}
+
+// Emit sends the signal `name` of `iface`, emitted by the object at `path`,
+// to everyone listening.
+func (c *Conn) Emit(path ObjectPath, iface, name string, args ...interface{}) error {
+	return c.send(&Message{
+		Type:      TypeSignal,
+		Flags:     FlagNoReplyExpected,
+		Path:      path,
+		Interface: iface,
+		Member:    name,
+		Body:      args,
+	}, nil)
+}
+
+func (c *Conn) handleCall(call *Message) {
+	body, err := c.dispatch(call)
+	if call.Flags&FlagNoReplyExpected != 0 {
+		return
+	}
+
+	reply := &Message{
+		Type:        TypeMethodReturn,
+		ReplySerial: call.Serial,
+		Destination: call.Sender,
+		Body:        body,
+	}
+	if err != nil {
+		e, ok := err.(*Error)
+		if !ok {
+			e = &Error{ErrFailed, err.Error()}
+		}
+		reply.Type = TypeError
+		reply.ErrorName = e.Name
+		reply.Body = []interface{}{e.Message}
+	}
+
+	// The caller might have gone away; there is no one to tell
+	_ = c.send(reply, nil)
+}
+
+func (c *Conn) dispatch(call *Message) ([]interface{}, error) {
+	c.mutex.Lock()
+	ifaces, exists := c.objects[call.Path]
+	c.mutex.Unlock()
+
+	if !exists {
+		return nil, &Error{ErrUnknownObject, fmt.Sprintf("no object at %s", call.Path)}
+	}
+
+	switch {
+	case call.Interface == "org.freedesktop.DBus.Peer" && call.Member == "Ping":
+		return nil, nil
+	case (call.Interface == "org.freedesktop.DBus.Introspectable" || call.Interface == "") && call.Member == "Introspect":
+		return []interface{}{introspect(ifaces)}, nil
+	}
+
+	var method Method
+	found := false
+	if call.Interface != "" {
+		method, found = ifaces[call.Interface].Methods[call.Member]
+	} else {
+		for _, iface := range ifaces {
+			if method, found = iface.Methods[call.Member]; found {
+				break
+			}
+		}
+	}
+	if !found {
+		return nil, &Error{ErrUnknownMethod, fmt.Sprintf("unknown method %s.%s", call.Interface, call.Member)}
+	}
+
+	if call.Signature != method.In {
+		return nil, &Error{ErrInvalidArgs, fmt.Sprintf("expected arguments of type %q, got %q", method.In, call.Signature)}
+	}
+
+	body, err := method.Call(call.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := SignatureOf(body...)
+	if err != nil || sig != method.Out {
+		return nil, &Error{ErrFailed, fmt.Sprintf("method %s returned values of type %q instead of %q", call.Member, sig, method.Out)}
+	}
+
+	return body, nil
+}
+
+// introspect returns the introspection XML of an object with `ifaces`.
+func introspect(ifaces map[string]Interface) string {
+	var b strings.Builder
+	b.WriteString(`<!DOCTYPE node PUBLIC "-//freedesktop//DTD D-BUS Object Introspection 1.0//EN"` + "\n")
+	b.WriteString(` "http://www.freedesktop.org/standards/dbus/1.0/introspect.dtd">` + "\n")
+	b.WriteString("<node>\n")
+
+	writeArgs := func(sig Signature, direction string) {
+		types, _ := splitSignature(sig)
+		for _, t := range types {
+			if direction != "" {
+				fmt.Fprintf(&b, "      <arg type=\"%s\" direction=\"%s\"/>\n", t, direction)
+			} else {
+				fmt.Fprintf(&b, "      <arg type=\"%s\"/>\n", t)
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(ifaces) {
+		iface := ifaces[name]
+		fmt.Fprintf(&b, "  <interface name=\"%s\">\n", name)
+
+		var methods []string
+		for m := range iface.Methods {
+			methods = append(methods, m)
+		}
+		sort.Strings(methods)
+		for _, m := range methods {
+			fmt.Fprintf(&b, "    <method name=\"%s\">\n", m)
+			writeArgs(iface.Methods[m].In, "in")
+			writeArgs(iface.Methods[m].Out, "out")
+			b.WriteString("    </method>\n")
+		}
+
+		var signals []string
+		for s := range iface.Signals {
+			signals = append(signals, s)
+		}
+		sort.Strings(signals)
+		for _, s := range signals {
+			fmt.Fprintf(&b, "    <signal name=\"%s\">\n", s)
+			writeArgs(iface.Signals[s], "")
+			b.WriteString("    </signal>\n")
+		}
+
+		b.WriteString("  </interface>\n")
+	}
+
+	b.WriteString("  <interface name=\"org.freedesktop.DBus.Introspectable\">\n")
+	b.WriteString("    <method name=\"Introspect\">\n")
+	b.WriteString("      <arg type=\"s\" direction=\"out\"/>\n")
+	b.WriteString("    </method>\n")
+	b.WriteString("  </interface>\n")
+	b.WriteString("  <interface name=\"org.freedesktop.DBus.Peer\">\n")
+	b.WriteString("    <method name=\"Ping\"/>\n")
+	b.WriteString("  </interface>\n")
+	b.WriteString("</node>\n")
+
+	return b.String()
+}
+
+func sortedKeys(ifaces map[string]Interface) []string {
+	var keys []string
+	for k := range ifaces {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
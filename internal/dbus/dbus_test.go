@@ -0,0 +1,278 @@
+package dbus
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"math/rand"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	type entry struct {
+		ID    string
+		State string
+		Size  uint64
+	}
+
+	m := &Message{
+		Type:        TypeMethodCall,
+		Serial:      7,
+		Path:        "/org/osbuild/Composer1",
+		Interface:   "org.osbuild.Composer1",
+		Member:      "Test",
+		Destination: "org.osbuild.Composer1",
+		Body: []interface{}{
+			byte(3),
+			true,
+			int32(-2),
+			"hello",
+			ObjectPath("/a"),
+			[]string{"a", "bc"},
+			[]entry{{"x", "FINISHED", 1 << 40}},
+			map[string]string{"k": "v"},
+			Variant{uint32(42)},
+			[]uint64{},
+		},
+	}
+
+	sig, err := SignatureOf(m.Body...)
+	require.NoError(t, err)
+	require.Equal(t, Signature("ybisoasa(sst)a{ss}vat"), sig)
+
+	data, err := m.marshal()
+	require.NoError(t, err)
+	require.Zero(t, len(data)%8)
+
+	decoded, err := readMessage(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, m.Path, decoded.Path)
+	require.Equal(t, m.Interface, decoded.Interface)
+	require.Equal(t, m.Member, decoded.Member)
+	require.Equal(t, m.Destination, decoded.Destination)
+	require.Equal(t, uint32(7), decoded.Serial)
+	require.Equal(t, sig, decoded.Signature)
+	require.Equal(t, []interface{}{
+		byte(3),
+		true,
+		int32(-2),
+		"hello",
+		ObjectPath("/a"),
+		[]interface{}{"a", "bc"},
+		[]interface{}{[]interface{}{"x", "FINISHED", uint64(1 << 40)}},
+		map[interface{}]interface{}{"k": "v"},
+		Variant{uint32(42)},
+		[]interface{}{},
+	}, decoded.Body)
+}
+
+func TestSignature(t *testing.T) {
+	types, err := splitSignature("sa{sv}(ia(ss))")
+	require.NoError(t, err)
+	require.Equal(t, []string{"s", "a{sv}", "(ia(ss))"}, types)
+
+	types, err = splitSignature("a{sa{sv}}a{y(as)}")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a{sa{sv}}", "a{y(as)}"}, types)
+
+	// dict entries only exist in arrays, and have keys of basic types
+	for _, sig := range []Signature{"a", "(", "()", "(s", "z", "a{s", "{sy}", "a{s}", "a{sss}", "a{ayy}", "a{vs}", "a{(s)s}", "a{a{ss}s}", "(s{sy})"} {
+		_, err := splitSignature(sig)
+		require.Error(t, err, sig)
+	}
+
+	_, err = SignatureOf(map[[2]string]string{})
+	require.Error(t, err)
+	_, err = SignatureOf(nil)
+	require.Error(t, err)
+}
+
+// rawMessage encodes a call of Greet on /test with the arguments `body`,
+// which are claimed to be of type `sig`.
+func rawMessage(t *testing.T, serial uint32, sig Signature, body []byte) []byte {
+	fields := []headerField{
+		{fieldPath, Variant{ObjectPath("/test")}},
+		{fieldMember, Variant{"Greet"}},
+		{fieldSignature, Variant{sig}},
+	}
+
+	var e encoder
+	e.buf.Write([]byte{'l', TypeMethodCall, 0, 1})
+	e.uint32(uint32(len(body)))
+	e.uint32(serial)
+	require.NoError(t, e.encode(reflect.ValueOf(fields)))
+	e.align(8)
+	e.buf.Write(body)
+	return e.buf.Bytes()
+}
+
+func TestReadInvalidMessage(t *testing.T) {
+	// a variant in a variant in a variant ... of a byte
+	nested := bytes.Repeat([]byte{1, 'v', 0}, 100)
+	nested = append(nested, 1, 'y', 0, 42)
+
+	cases := []struct {
+		sig  Signature
+		body []byte
+	}{
+		{"a{ayy}", []byte{8, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 2}},
+		{"{sy}", []byte{1, 0, 0, 0, 'a', 0, 1}},
+		{"a{sy}", []byte{0xff, 0, 0, 0}},
+		{"as", []byte{3, 0, 0, 0, 1, 0, 0, 0, 'a', 0}},
+		{"s", []byte{0xff, 0xff, 0xff, 0xff, 'a', 0}},
+		{"b", []byte{2, 0, 0, 0}},
+		{"v", []byte{2, 's', 's', 0}},
+		{"v", nested},
+		{"u", []byte{1, 2}},
+	}
+	for _, c := range cases {
+		_, err := readMessage(bytes.NewReader(rawMessage(t, 1, c.sig, c.body)))
+		require.IsType(t, &invalidMessageError{}, err, c.sig)
+	}
+
+	// truncated messages can't be read at all
+	data := rawMessage(t, 1, "s", []byte{1, 0, 0, 0, 'a', 0})
+	_, err := readMessage(bytes.NewReader(data[:len(data)-1]))
+	require.Error(t, err)
+	_, invalid := err.(*invalidMessageError)
+	require.False(t, invalid)
+}
+
+// TestReadCorruptMessage makes sure that no corruption of a message makes
+// readMessage() panic.
+func TestReadCorruptMessage(t *testing.T) {
+	m := &Message{
+		Type:   TypeMethodCall,
+		Path:   "/test",
+		Member: "Test",
+		Body: []interface{}{
+			map[string]Variant{"a": {[]string{"b"}}, "c": {map[uint32]float64{1: 2}}},
+			[]struct {
+				A byte
+				B int64
+				C ObjectPath
+			}{{1, -1, "/x"}},
+			Signature("a{sv}"),
+		},
+	}
+	data, err := m.marshal()
+	require.NoError(t, err)
+
+	random := rand.New(rand.NewSource(0))
+	for i := 0; i < 20000; i++ {
+		corrupt := append([]byte(nil), data...)
+		for n := random.Intn(4) + 1; n > 0; n-- {
+			corrupt[random.Intn(len(corrupt))] = byte(random.Intn(256))
+		}
+		_, _ = readMessage(bytes.NewReader(corrupt))
+	}
+}
+
+func TestUnixPath(t *testing.T) {
+	path, err := unixPath("unix:path=/run/dbus/system_bus_socket")
+	require.NoError(t, err)
+	require.Equal(t, "/run/dbus/system_bus_socket", path)
+
+	path, err = unixPath("unix:abstract=/tmp/dbus-x%2cy,guid=123")
+	require.NoError(t, err)
+	require.Equal(t, "@/tmp/dbus-x,y", path)
+
+	_, err = unixPath("tcp:host=localhost,port=1234")
+	require.Error(t, err)
+}
+
+func TestAuthenticate(t *testing.T) {
+	var sent bytes.Buffer
+	err := authenticate(&sent, bufio.NewReader(strings.NewReader("OK 1234deadbeef\r\n")))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(sent.String(), "\x00AUTH EXTERNAL "))
+	require.True(t, strings.HasSuffix(sent.String(), "\r\nBEGIN\r\n"))
+
+	err = authenticate(&sent, bufio.NewReader(strings.NewReader("REJECTED EXTERNAL\r\n")))
+	require.Error(t, err)
+}
+
+func TestCall(t *testing.T) {
+	a, b := net.Pipe()
+	server := newPeerConn(a, a)
+	defer server.Close()
+	client := newPeerConn(b, b)
+	defer client.Close()
+
+	server.Export("/test", "org.example.Test", Interface{
+		Methods: map[string]Method{
+			"Greet": {
+				In:  "s",
+				Out: "s",
+				Call: func(args []interface{}) ([]interface{}, error) {
+					return []interface{}{"hello " + args[0].(string)}, nil
+				},
+			},
+			"Fail": {
+				Call: func(args []interface{}) ([]interface{}, error) {
+					return nil, errors.New("oops")
+				},
+			},
+			"Wrong": {
+				Out: "s",
+				Call: func(args []interface{}) ([]interface{}, error) {
+					return []interface{}{uint32(1)}, nil
+				},
+			},
+		},
+		Signals: map[string]Signature{"Changed": "ss"},
+	})
+
+	reply, err := client.Call("", "/test", "org.example.Test", "Greet", "world")
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{"hello world"}, reply)
+
+	_, err = client.Call("", "/test", "org.example.Test", "Greet", uint32(1))
+	require.Equal(t, ErrInvalidArgs, err.(*Error).Name)
+
+	_, err = client.Call("", "/test", "org.example.Test", "Fail")
+	require.Equal(t, &Error{ErrFailed, "oops"}, err)
+
+	_, err = client.Call("", "/test", "org.example.Test", "Wrong")
+	require.Equal(t, ErrFailed, err.(*Error).Name)
+
+	_, err = client.Call("", "/test", "org.example.Test", "Unknown")
+	require.Equal(t, ErrUnknownMethod, err.(*Error).Name)
+
+	_, err = client.Call("", "/other", "org.example.Test", "Greet", "world")
+	require.Equal(t, ErrUnknownObject, err.(*Error).Name)
+
+	// calls which can't be decoded fail, but don't break the connection
+	invalid := make(chan *Message, 1)
+	client.mutex.Lock()
+	client.serial++
+	serial := client.serial
+	client.pending[serial] = invalid
+	client.mutex.Unlock()
+	client.writeMutex.Lock()
+	_, err = b.Write(rawMessage(t, serial, "a{ayy}", []byte{8, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 2}))
+	client.writeMutex.Unlock()
+	require.NoError(t, err)
+	m := <-invalid
+	require.Equal(t, TypeError, m.Type)
+	require.Equal(t, ErrInvalidArgs, m.ErrorName)
+
+	reply, err = client.Call("", "/test", "org.example.Test", "Greet", "again")
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{"hello again"}, reply)
+
+	reply, err = client.Call("", "/test", "org.freedesktop.DBus.Introspectable", "Introspect")
+	require.NoError(t, err)
+	require.Contains(t, reply[0], `<method name="Greet">`)
+	require.Contains(t, reply[0], `<signal name="Changed">`)
+
+	// calls fail once the connection is gone
+	server.Close()
+	_, err = client.Call("", "/test", "org.example.Test", "Greet", "world")
+	require.Error(t, err)
+}
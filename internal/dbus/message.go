@@ -0,0 +1,813 @@
+package dbus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+)
+
+// ObjectPath is a value of D-Bus type "o".
+type ObjectPath string
+
+// Signature is a value of D-Bus type "g".
+type Signature string
+
+// Variant is a value of D-Bus type "v", which carries the type of the value
+// it contains.
+type Variant struct {
+	Value interface{}
+}
+
+// Message types
+const (
+	TypeMethodCall   byte = 1
+	TypeMethodReturn byte = 2
+	TypeError        byte = 3
+	TypeSignal       byte = 4
+)
+
+// Message flags
+const (
+	FlagNoReplyExpected byte = 0x1
+)
+
+// Header fields
+const (
+	fieldPath        byte = 1
+	fieldInterface   byte = 2
+	fieldMember      byte = 3
+	fieldErrorName   byte = 4
+	fieldReplySerial byte = 5
+	fieldDestination byte = 6
+	fieldSender      byte = 7
+	fieldSignature   byte = 8
+)
+
+// Messages larger than this are rejected, as mandated by the specification.
+const maxMessageSize = 128 * 1024 * 1024
+
+// Values nested deeper than this are rejected, as mandated by the
+// specification. It also keeps decoding from exhausting the stack.
+const maxDepth = 64
+
+// A Message is a single D-Bus message. Body holds the arguments, which are
+// encoded according to their Go type (see SignatureOf()). Decoded arguments
+// are of type byte, bool, int16, uint16, int32, uint32, int64, uint64,
+// float64, string, ObjectPath, Signature, Variant, []interface{} for arrays
+// and structs, or map[interface{}]interface{} for dictionaries.
+type Message struct {
+	Type        byte
+	Flags       byte
+	Serial      uint32
+	Path        ObjectPath
+	Interface   string
+	Member      string
+	ErrorName   string
+	ReplySerial uint32
+	Destination string
+	Sender      string
+	Body        []interface{}
+
+	// Signature of the body. It is derived from Body when the message is
+	// sent.
+	Signature Signature
+}
+
+var (
+	objectPathType = reflect.TypeOf(ObjectPath(""))
+	signatureType  = reflect.TypeOf(Signature(""))
+	variantType    = reflect.TypeOf(Variant{})
+)
+
+// SignatureOf returns the D-Bus signature of `values`. Go types map to D-Bus
+// types in the obvious way: slices to arrays, maps to dictionaries, and
+// structs (with only exported fields) to structs.
+func SignatureOf(values ...interface{}) (Signature, error) {
+	var sig string
+	for _, v := range values {
+		if v == nil {
+			return "", errors.New("cannot encode nil")
+		}
+		s, err := signatureOfType(reflect.TypeOf(v))
+		if err != nil {
+			return "", err
+		}
+		sig += s
+	}
+	return Signature(sig), nil
+}
+
+func signatureOfType(t reflect.Type) (string, error) {
+	switch t {
+	case objectPathType:
+		return "o", nil
+	case signatureType:
+		return "g", nil
+	case variantType:
+		return "v", nil
+	}
+
+	switch t.Kind() {
+	case reflect.Uint8:
+		return "y", nil
+	case reflect.Bool:
+		return "b", nil
+	case reflect.Int16:
+		return "n", nil
+	case reflect.Uint16:
+		return "q", nil
+	case reflect.Int32:
+		return "i", nil
+	case reflect.Uint32:
+		return "u", nil
+	case reflect.Int64:
+		return "x", nil
+	case reflect.Uint64:
+		return "t", nil
+	case reflect.Float64:
+		return "d", nil
+	case reflect.String:
+		return "s", nil
+	case reflect.Slice, reflect.Array:
+		elem, err := signatureOfType(t.Elem())
+		if err != nil {
+			return "", err
+		}
+		return "a" + elem, nil
+	case reflect.Map:
+		key, err := signatureOfType(t.Key())
+		if err != nil {
+			return "", err
+		}
+		if !isBasic(key[0]) {
+			return "", fmt.Errorf("cannot encode map with key type %s", t.Key())
+		}
+		elem, err := signatureOfType(t.Elem())
+		if err != nil {
+			return "", err
+		}
+		return "a{" + key + elem + "}", nil
+	case reflect.Struct:
+		sig := "("
+		for i := 0; i < t.NumField(); i++ {
+			s, err := signatureOfType(t.Field(i).Type)
+			if err != nil {
+				return "", err
+			}
+			sig += s
+		}
+		if sig == "(" {
+			return "", fmt.Errorf("cannot encode empty struct %s", t)
+		}
+		return sig + ")", nil
+	}
+
+	return "", fmt.Errorf("cannot encode values of type %s", t)
+}
+
+func isBasic(c byte) bool {
+	return bytes.IndexByte([]byte("ybnqiuxtdsog"), c) >= 0
+}
+
+// alignment returns the alignment of values of the type starting with `c`.
+func alignment(c byte) int {
+	switch c {
+	case 'n', 'q':
+		return 2
+	case 'b', 'i', 'u', 's', 'o', 'a':
+		return 4
+	case 'x', 't', 'd', '(', '{':
+		return 8
+	}
+	return 1
+}
+
+// nextType splits the first complete type off `sig`.
+func nextType(sig string) (string, string, error) {
+	if sig == "" {
+		return "", "", errors.New("empty signature")
+	}
+
+	switch sig[0] {
+	case 'a':
+		if len(sig) > 1 && sig[1] == '{' {
+			entry, rest, err := nextDictEntry(sig[1:])
+			if err != nil {
+				return "", "", err
+			}
+			return "a" + entry, rest, nil
+		}
+		elem, rest, err := nextType(sig[1:])
+		if err != nil {
+			return "", "", err
+		}
+		return "a" + elem, rest, nil
+	case '(':
+		n := 1
+		rest := sig[1:]
+		for rest != "" && rest[0] != ')' {
+			t, r, err := nextType(rest)
+			if err != nil {
+				return "", "", err
+			}
+			n += len(t)
+			rest = r
+		}
+		if rest == "" || n == 1 {
+			return "", "", fmt.Errorf("invalid signature: %s", sig)
+		}
+		return sig[:n+1], rest[1:], nil
+	}
+
+	if !isBasic(sig[0]) && sig[0] != 'v' {
+		return "", "", fmt.Errorf("invalid signature: %s", sig)
+	}
+	return sig[:1], sig[1:], nil
+}
+
+// nextDictEntry splits the dict entry type off `sig`, which starts with "{".
+// Dict entries only exist as array elements, and have a key of a basic type
+// and a value.
+func nextDictEntry(sig string) (string, string, error) {
+	if len(sig) < 2 || !isBasic(sig[1]) {
+		return "", "", fmt.Errorf("invalid signature: %s", sig)
+	}
+	value, rest, err := nextType(sig[2:])
+	if err != nil {
+		return "", "", err
+	}
+	if rest == "" || rest[0] != '}' {
+		return "", "", fmt.Errorf("invalid signature: %s", sig)
+	}
+	return sig[:len(value)+3], rest[1:], nil
+}
+
+// splitSignature returns the complete types in `sig`.
+func splitSignature(sig Signature) ([]string, error) {
+	var types []string
+	rest := string(sig)
+	for rest != "" {
+		t, r, err := nextType(rest)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+		rest = r
+	}
+	return types, nil
+}
+
+// encoder writes values in little endian byte order. Alignment is relative
+// to the start of its buffer, which must start at an 8-byte boundary of the
+// message.
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) align(n int) {
+	for e.buf.Len()%n != 0 {
+		e.buf.WriteByte(0)
+	}
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.align(4)
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) uint64(v uint64) {
+	e.align(8)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) string(s string) {
+	e.uint32(uint32(len(s)))
+	e.buf.WriteString(s)
+	e.buf.WriteByte(0)
+}
+
+func (e *encoder) signature(s string) {
+	e.buf.WriteByte(byte(len(s)))
+	e.buf.WriteString(s)
+	e.buf.WriteByte(0)
+}
+
+func (e *encoder) encode(v reflect.Value) error {
+	switch v.Type() {
+	case objectPathType:
+		e.string(v.String())
+		return nil
+	case signatureType:
+		e.signature(v.String())
+		return nil
+	case variantType:
+		value := v.Interface().(Variant).Value
+		sig, err := SignatureOf(value)
+		if err != nil {
+			return err
+		}
+		e.signature(string(sig))
+		return e.encode(reflect.ValueOf(value))
+	}
+
+	switch v.Kind() {
+	case reflect.Uint8:
+		e.buf.WriteByte(byte(v.Uint()))
+	case reflect.Bool:
+		if v.Bool() {
+			e.uint32(1)
+		} else {
+			e.uint32(0)
+		}
+	case reflect.Int16, reflect.Uint16:
+		e.align(2)
+		var b [2]byte
+		if v.Kind() == reflect.Int16 {
+			binary.LittleEndian.PutUint16(b[:], uint16(v.Int()))
+		} else {
+			binary.LittleEndian.PutUint16(b[:], uint16(v.Uint()))
+		}
+		e.buf.Write(b[:])
+	case reflect.Int32:
+		e.uint32(uint32(v.Int()))
+	case reflect.Uint32:
+		e.uint32(uint32(v.Uint()))
+	case reflect.Int64:
+		e.uint64(uint64(v.Int()))
+	case reflect.Uint64:
+		e.uint64(v.Uint())
+	case reflect.Float64:
+		e.uint64(math.Float64bits(v.Float()))
+	case reflect.String:
+		e.string(v.String())
+	case reflect.Slice, reflect.Array:
+		elem, err := signatureOfType(v.Type().Elem())
+		if err != nil {
+			return err
+		}
+		return e.array(alignment(elem[0]), func() error {
+			for i := 0; i < v.Len(); i++ {
+				err := e.encode(v.Index(i))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	case reflect.Map:
+		return e.array(8, func() error {
+			iter := v.MapRange()
+			for iter.Next() {
+				e.align(8)
+				err := e.encode(iter.Key())
+				if err != nil {
+					return err
+				}
+				err = e.encode(iter.Value())
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	case reflect.Struct:
+		e.align(8)
+		for i := 0; i < v.NumField(); i++ {
+			err := e.encode(v.Field(i))
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cannot encode values of type %s", v.Type())
+	}
+
+	return nil
+}
+
+// array writes the length of the array whose elements `elements` writes.
+// The length doesn't include the padding before the first element.
+func (e *encoder) array(align int, elements func() error) error {
+	e.uint32(0)
+	lengthPos := e.buf.Len() - 4
+	e.align(align)
+	start := e.buf.Len()
+
+	err := elements()
+	if err != nil {
+		return err
+	}
+
+	binary.LittleEndian.PutUint32(e.buf.Bytes()[lengthPos:], uint32(e.buf.Len()-start))
+	return nil
+}
+
+type decoder struct {
+	data  []byte
+	pos   int
+	order binary.ByteOrder
+
+	// Number of arrays, structs, and variants that contain the value which
+	// is being decoded
+	depth int
+}
+
+func (d *decoder) align(n int) error {
+	for d.pos%n != 0 {
+		if d.pos >= len(d.data) {
+			return io.ErrUnexpectedEOF
+		}
+		d.pos++
+	}
+	return nil
+}
+
+func (d *decoder) read(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *decoder) uint32() (uint32, error) {
+	err := d.align(4)
+	if err != nil {
+		return 0, err
+	}
+	b, err := d.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return d.order.Uint32(b), nil
+}
+
+func (d *decoder) uint64() (uint64, error) {
+	err := d.align(8)
+	if err != nil {
+		return 0, err
+	}
+	b, err := d.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return d.order.Uint64(b), nil
+}
+
+func (d *decoder) string() (string, error) {
+	n, err := d.uint32()
+	if err != nil {
+		return "", err
+	}
+	b, err := d.read(int(n) + 1)
+	if err != nil {
+		return "", err
+	}
+	return string(b[:n]), nil
+}
+
+func (d *decoder) signature() (string, error) {
+	n, err := d.read(1)
+	if err != nil {
+		return "", err
+	}
+	b, err := d.read(int(n[0]) + 1)
+	if err != nil {
+		return "", err
+	}
+	return string(b[:n[0]]), nil
+}
+
+// decode decodes a value of the single complete type `sig`.
+func (d *decoder) decode(sig string) (interface{}, error) {
+	switch sig[0] {
+	case 'a', '(', 'v':
+		if d.depth == maxDepth {
+			return nil, errors.New("values are nested too deeply")
+		}
+		d.depth++
+		defer func() { d.depth-- }()
+	}
+
+	switch sig[0] {
+	case 'y':
+		b, err := d.read(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0], nil
+	case 'b':
+		v, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		if v > 1 {
+			return nil, fmt.Errorf("invalid boolean value: %d", v)
+		}
+		return v == 1, nil
+	case 'n', 'q':
+		err := d.align(2)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.read(2)
+		if err != nil {
+			return nil, err
+		}
+		if sig[0] == 'n' {
+			return int16(d.order.Uint16(b)), nil
+		}
+		return d.order.Uint16(b), nil
+	case 'i':
+		v, err := d.uint32()
+		return int32(v), err
+	case 'u':
+		return d.uint32()
+	case 'x':
+		v, err := d.uint64()
+		return int64(v), err
+	case 't':
+		return d.uint64()
+	case 'd':
+		v, err := d.uint64()
+		return math.Float64frombits(v), err
+	case 's':
+		return d.string()
+	case 'o':
+		s, err := d.string()
+		return ObjectPath(s), err
+	case 'g':
+		s, err := d.signature()
+		return Signature(s), err
+	case 'v':
+		s, err := d.signature()
+		if err != nil {
+			return nil, err
+		}
+		t, rest, err := nextType(s)
+		if err != nil {
+			return nil, err
+		}
+		if rest != "" {
+			return nil, fmt.Errorf("invalid variant signature: %s", s)
+		}
+		value, err := d.decode(t)
+		return Variant{value}, err
+	case '(':
+		err := d.align(8)
+		if err != nil {
+			return nil, err
+		}
+		types, err := splitSignature(Signature(sig[1 : len(sig)-1]))
+		if err != nil {
+			return nil, err
+		}
+		fields := make([]interface{}, len(types))
+		for i, t := range types {
+			fields[i], err = d.decode(t)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return fields, nil
+	case 'a':
+		n, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		elem := sig[1:]
+		err = d.align(alignment(elem[0]))
+		if err != nil {
+			return nil, err
+		}
+		end := d.pos + int(n)
+		if end > len(d.data) {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		if elem[0] == '{' {
+			types, err := splitSignature(Signature(elem[1 : len(elem)-1]))
+			if err != nil {
+				return nil, err
+			}
+			if len(types) != 2 {
+				return nil, fmt.Errorf("invalid signature: %s", sig)
+			}
+			dict := make(map[interface{}]interface{})
+			for d.pos < end {
+				err = d.align(8)
+				if err != nil {
+					return nil, err
+				}
+				key, err := d.decode(types[0])
+				if err != nil {
+					return nil, err
+				}
+				dict[key], err = d.decode(types[1])
+				if err != nil {
+					return nil, err
+				}
+			}
+			if d.pos != end {
+				return nil, fmt.Errorf("array elements exceed its length of %d bytes", n)
+			}
+			return dict, nil
+		}
+
+		elems := []interface{}{}
+		for d.pos < end {
+			v, err := d.decode(elem)
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, v)
+		}
+		if d.pos != end {
+			return nil, fmt.Errorf("array elements exceed its length of %d bytes", n)
+		}
+		return elems, nil
+	}
+
+	return nil, fmt.Errorf("invalid signature: %s", sig)
+}
+
+type headerField struct {
+	Code  byte
+	Value Variant
+}
+
+// marshal encodes `m` in little endian byte order.
+func (m *Message) marshal() ([]byte, error) {
+	sig, err := SignatureOf(m.Body...)
+	if err != nil {
+		return nil, err
+	}
+	m.Signature = sig
+
+	var body encoder
+	for _, v := range m.Body {
+		err := body.encode(reflect.ValueOf(v))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var fields []headerField
+	if m.Path != "" {
+		fields = append(fields, headerField{fieldPath, Variant{m.Path}})
+	}
+	if m.Interface != "" {
+		fields = append(fields, headerField{fieldInterface, Variant{m.Interface}})
+	}
+	if m.Member != "" {
+		fields = append(fields, headerField{fieldMember, Variant{m.Member}})
+	}
+	if m.ErrorName != "" {
+		fields = append(fields, headerField{fieldErrorName, Variant{m.ErrorName}})
+	}
+	if m.ReplySerial != 0 {
+		fields = append(fields, headerField{fieldReplySerial, Variant{m.ReplySerial}})
+	}
+	if m.Destination != "" {
+		fields = append(fields, headerField{fieldDestination, Variant{m.Destination}})
+	}
+	if m.Sender != "" {
+		fields = append(fields, headerField{fieldSender, Variant{m.Sender}})
+	}
+	if m.Signature != "" {
+		fields = append(fields, headerField{fieldSignature, Variant{m.Signature}})
+	}
+
+	var e encoder
+	e.buf.Write([]byte{'l', m.Type, m.Flags, 1})
+	e.uint32(uint32(body.buf.Len()))
+	e.uint32(m.Serial)
+	err = e.encode(reflect.ValueOf(fields))
+	if err != nil {
+		return nil, err
+	}
+	e.align(8)
+	e.buf.Write(body.buf.Bytes())
+
+	if e.buf.Len() > maxMessageSize {
+		return nil, errors.New("message too large")
+	}
+
+	return e.buf.Bytes(), nil
+}
+
+// An invalidMessageError is returned by readMessage() for a message which was
+// read completely, but cannot be decoded. Only the call or reply it belongs
+// to fails; the messages after it can still be read.
+type invalidMessageError struct {
+	message *Message
+	err     error
+}
+
+func (e *invalidMessageError) Error() string {
+	return e.err.Error()
+}
+
+// readMessage reads a single message from `r`.
+func readMessage(r io.Reader) (*Message, error) {
+	// The fixed part of the header and the length of the header fields
+	fixed := make([]byte, 16)
+	_, err := io.ReadFull(r, fixed)
+	if err != nil {
+		return nil, err
+	}
+
+	var order binary.ByteOrder
+	switch fixed[0] {
+	case 'l':
+		order = binary.LittleEndian
+	case 'B':
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid byte order: %q", fixed[0])
+	}
+	if fixed[3] != 1 {
+		return nil, fmt.Errorf("unsupported protocol version: %d", fixed[3])
+	}
+
+	bodyLength := order.Uint32(fixed[4:])
+	fieldsLength := order.Uint32(fixed[12:])
+	if uint64(bodyLength)+uint64(fieldsLength) > maxMessageSize {
+		return nil, errors.New("message too large")
+	}
+
+	headerLength := 16 + int(fieldsLength)
+	headerLength += (8 - headerLength%8) % 8
+	data := make([]byte, headerLength+int(bodyLength))
+	copy(data, fixed)
+	_, err = io.ReadFull(r, data[16:])
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Message{
+		Type:   fixed[1],
+		Flags:  fixed[2],
+		Serial: order.Uint32(fixed[8:]),
+	}
+
+	d := decoder{data: data[:headerLength], pos: 12, order: order}
+	fields, err := d.decode("a(yv)")
+	if err != nil {
+		return nil, &invalidMessageError{m, fmt.Errorf("invalid header: %v", err)}
+	}
+	for _, f := range fields.([]interface{}) {
+		field := f.([]interface{})
+		value := field[1].(Variant).Value
+
+		var ok bool
+		switch field[0].(byte) {
+		case fieldPath:
+			m.Path, ok = value.(ObjectPath)
+		case fieldInterface:
+			m.Interface, ok = value.(string)
+		case fieldMember:
+			m.Member, ok = value.(string)
+		case fieldErrorName:
+			m.ErrorName, ok = value.(string)
+		case fieldReplySerial:
+			m.ReplySerial, ok = value.(uint32)
+		case fieldDestination:
+			m.Destination, ok = value.(string)
+		case fieldSender:
+			m.Sender, ok = value.(string)
+		case fieldSignature:
+			m.Signature, ok = value.(Signature)
+		default:
+			// unknown fields must be ignored
+			ok = true
+		}
+		if !ok {
+			return nil, &invalidMessageError{m, fmt.Errorf("invalid header field %d", field[0])}
+		}
+	}
+
+	types, err := splitSignature(m.Signature)
+	if err != nil {
+		return nil, &invalidMessageError{m, err}
+	}
+	d = decoder{data: data[headerLength:], order: order}
+	for _, t := range types {
+		v, err := d.decode(t)
+		if err != nil {
+			return nil, &invalidMessageError{m, fmt.Errorf("invalid body: %v", err)}
+		}
+		m.Body = append(m.Body, v)
+	}
+
+	return m, nil
+}
@@ -73,7 +73,35 @@ type ImageType interface {
 	// Returns an osbuild manifest, containing the sources and pipeline necessary
 	// to build an image, given output format with all packages and customizations
 	// specified in the given blueprint.
-	Manifest(b *blueprint.Customizations, repos []rpmmd.RepoConfig, packageSpecs, buildPackageSpecs []rpmmd.PackageSpec, size uint64) (*osbuild.Manifest, error)
+	Manifest(b *blueprint.Customizations, repos []rpmmd.RepoConfig, packageSpecs, buildPackageSpecs []rpmmd.PackageSpec, options ImageOptions) (*osbuild.Manifest, error)
+}
+
+// An InstallerImageType is an image type whose images embed the image of
+// another image type, their payload. Images are built in two stages: the
+// payload is built first, from its own manifest. The manifest of the image
+// itself only names the payload. It is completed with the payload when it is
+// built, which is why it must not be built before its payload.
+type InstallerImageType interface {
+	ImageType
+
+	// Returns the name of the image type of the payload, which is built
+	// for the same architecture.
+	PayloadImageType() string
+}
+
+// The ImageOptions specify properties of a specific image build, which are
+// not part of its blueprint.
+type ImageOptions struct {
+	Size   uint64
+	OSTree OSTreeImageOptions
+}
+
+// The OSTreeImageOptions specify the ref an ostree commit is made for, and
+// the checksum of the commit it is made on top of. Only image types that
+// produce ostree commits use them.
+type OSTreeImageOptions struct {
+	Ref    string `json:"ref"`
+	Parent string `json:"parent,omitempty"`
 }
 
 type Registry struct {
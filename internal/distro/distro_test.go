@@ -5,6 +5,7 @@ import (
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
 	"github.com/osbuild/osbuild-composer/internal/distro"
 	"github.com/osbuild/osbuild-composer/internal/distro/distro_test_common"
 	"github.com/osbuild/osbuild-composer/internal/distro/fedora30"
@@ -13,6 +14,7 @@ import (
 	"github.com/osbuild/osbuild-composer/internal/distro/rhel81"
 	"github.com/osbuild/osbuild-composer/internal/distro/rhel82"
 	"github.com/osbuild/osbuild-composer/internal/distro/rhel83"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
 )
 
 func TestDistro_Manifest(t *testing.T) {
@@ -40,3 +42,41 @@ func TestDistro_RegistryList(t *testing.T) {
 
 	require.Equalf(t, expected, distros.List(), "unexpected list of distros")
 }
+
+func TestMinimumImageSize(t *testing.T) {
+	const MiB = 1024 * 1024
+	packages := []rpmmd.PackageSpec{
+		{Name: "kernel", Version: "5.6.6", Release: "300.fc32", Arch: "x86_64", InstallSize: 600 * MiB},
+		{Name: "bash", Version: "5.0.11", Release: "2.fc32", Arch: "x86_64", InstallSize: 400 * MiB},
+	}
+
+	arch, err := fedora32.New().GetArch("x86_64")
+	require.NoError(t, err)
+
+	// contents and overhead, plus the partitions in front of the root partition
+	qcow2, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+	manifest, err := qcow2.Manifest(nil, nil, packages, nil, distro.ImageOptions{Size: qcow2.Size(1)})
+	require.NoError(t, err)
+	size := distro.MinimumImageSize(manifest, packages)
+	require.True(t, size > 1200*MiB && size < 1300*MiB, "unexpected size %d", size)
+	require.Zero(t, size%MiB)
+
+	// filesystem customizations raise the minimum
+	customizations := &blueprint.Customizations{
+		Filesystem: []blueprint.FilesystemCustomization{
+			{Mountpoint: "/", MinSize: 4096 * MiB},
+			{Mountpoint: "/var", MinSize: 1024 * MiB},
+		},
+	}
+	manifest, err = qcow2.Manifest(customizations, nil, packages, nil, distro.ImageOptions{Size: qcow2.Size(1)})
+	require.NoError(t, err)
+	require.True(t, distro.MinimumImageSize(manifest, packages) > 5120*MiB)
+
+	// archives only need room for their contents
+	tar, err := arch.GetImageType("tar")
+	require.NoError(t, err)
+	manifest, err = tar.Manifest(nil, nil, packages, nil, distro.ImageOptions{Size: tar.Size(1)})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1200*MiB), distro.MinimumImageSize(manifest, packages))
+}
@@ -29,11 +29,12 @@ func TestDistro_Manifest(t *testing.T, pipelinePath string, prefix string, distr
 			GPGKey     string `json:"gpgkey,omitempty"`
 		}
 		type composeRequest struct {
-			Distro       string               `json:"distro"`
-			Arch         string               `json:"arch"`
-			ImageType    string               `json:"image-type"`
-			Repositories []repository         `json:"repositories"`
-			Blueprint    *blueprint.Blueprint `json:"blueprint"`
+			Distro       string                    `json:"distro"`
+			Arch         string                    `json:"arch"`
+			ImageType    string                    `json:"image-type"`
+			Repositories []repository              `json:"repositories"`
+			Blueprint    *blueprint.Blueprint      `json:"blueprint"`
+			OSTree       distro.OSTreeImageOptions `json:"ostree"`
 		}
 		type rpmMD struct {
 			BuildPackages []rpmmd.PackageSpec `json:"build-packages"`
@@ -85,7 +86,7 @@ func TestDistro_Manifest(t *testing.T, pipelinePath string, prefix string, distr
 				repos,
 				tt.RpmMD.Packages,
 				tt.RpmMD.BuildPackages,
-				imageType.Size(0))
+				distro.ImageOptions{Size: imageType.Size(0), OSTree: tt.ComposeRequest.OSTree})
 
 			if (err == nil && tt.Manifest == nil) || (err != nil && tt.Manifest != nil) {
 				t.Errorf("distro.Manifest() error = %v", err)
@@ -2,8 +2,10 @@ package fedora31
 
 import (
 	"errors"
+	"fmt"
 	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/osbuild/osbuild-composer/internal/distro"
 	"github.com/osbuild/osbuild-composer/internal/osbuild"
@@ -162,8 +164,8 @@ func (t *imageType) Manifest(c *blueprint.Customizations,
 	repos []rpmmd.RepoConfig,
 	packageSpecs,
 	buildPackageSpecs []rpmmd.PackageSpec,
-	size uint64) (*osbuild.Manifest, error) {
-	pipeline, err := t.pipeline(c, repos, packageSpecs, buildPackageSpecs, size)
+	options distro.ImageOptions) (*osbuild.Manifest, error) {
+	pipeline, err := t.pipeline(c, repos, packageSpecs, buildPackageSpecs, options.Size)
 	if err != nil {
 		return nil, err
 	}
@@ -468,6 +470,23 @@ func sources(packages []rpmmd.PackageSpec) *osbuild.Sources {
 	}
 }
 
+// kernelVerStr returns the version of kernel package `name` in `packages`,
+// as it appears in the names of boot loader entries, or "" if `packages`
+// don't contain it.
+func kernelVerStr(packages []rpmmd.PackageSpec, name string) string {
+	for _, pkg := range packages {
+		if pkg.Name != name {
+			continue
+		}
+		version := fmt.Sprintf("%s-%s.%s", pkg.Version, pkg.Release, pkg.Arch)
+		if strings.HasSuffix(name, "-debug") {
+			version += "+debug"
+		}
+		return version
+	}
+	return ""
+}
+
 func (t *imageType) pipeline(c *blueprint.Customizations, repos []rpmmd.RepoConfig, packageSpecs, buildPackageSpecs []rpmmd.PackageSpec, size uint64) (*osbuild.Pipeline, error) {
 	p := &osbuild.Pipeline{}
 	p.SetBuild(t.buildPipeline(repos, *t.arch, buildPackageSpecs), "org.osbuild.fedora31")
@@ -503,6 +522,11 @@ func (t *imageType) pipeline(c *blueprint.Customizations, repos []rpmmd.RepoConf
 		p.AddStage(osbuild.NewChronyStage(&osbuild.ChronyStageOptions{ntpServers}))
 	}
 
+	// Groups are created first, so that users can be added to them
+	if groups := c.GetGroups(); len(groups) > 0 {
+		p.AddStage(osbuild.NewGroupsStage(t.groupStageOptions(groups)))
+	}
+
 	if users := c.GetUsers(); len(users) > 0 {
 		options, err := t.userStageOptions(users)
 		if err != nil {
@@ -511,13 +535,23 @@ func (t *imageType) pipeline(c *blueprint.Customizations, repos []rpmmd.RepoConf
 		p.AddStage(osbuild.NewUsersStage(options))
 	}
 
-	if groups := c.GetGroups(); len(groups) > 0 {
-		p.AddStage(osbuild.NewGroupsStage(t.groupStageOptions(groups)))
+	var fstabOptions *osbuild.FSTabStageOptions
+	if t.bootable {
+		fstabOptions = t.fsTabStageOptions(t.arch.uefi)
+	}
+	assembler, err := t.customizedAssembler(c.GetFilesystems(), fstabOptions, size)
+	if err != nil {
+		return nil, err
 	}
 
 	if t.bootable {
-		p.AddStage(osbuild.NewFSTabStage(t.fsTabStageOptions(t.arch.uefi)))
-		p.AddStage(osbuild.NewGRUB2Stage(t.grub2StageOptions(t.kernelOptions, c.GetKernel(), t.arch.uefi)))
+		p.AddStage(osbuild.NewFSTabStage(fstabOptions))
+		kernel := c.GetKernel()
+		var kernelVer string
+		if kernel != nil && kernel.Name != "" {
+			kernelVer = kernelVerStr(packageSpecs, kernel.Name)
+		}
+		p.AddStage(osbuild.NewGRUB2Stage(t.grub2StageOptions(t.kernelOptions, kernel, kernelVer, t.arch.uefi)))
 	}
 
 	if services := c.GetServices(); services != nil || t.enabledServices != nil {
@@ -530,7 +564,7 @@ func (t *imageType) pipeline(c *blueprint.Customizations, repos []rpmmd.RepoConf
 
 	p.AddStage(osbuild.NewSELinuxStage(t.selinuxStageOptions()))
 
-	p.Assembler = t.assembler(t.arch.uefi, size)
+	p.Assembler = assembler
 
 	return p, nil
 }
@@ -544,10 +578,10 @@ func (r *imageType) buildPipeline(repos []rpmmd.RepoConfig, arch arch, buildPack
 func (r *imageType) rpmStageOptions(repos []rpmmd.RepoConfig, specs []rpmmd.PackageSpec) *osbuild.RPMStageOptions {
 	var gpgKeys []string
 	for _, repo := range repos {
-		if repo.GPGKey == "" {
-			continue
+		if repo.GPGKey != "" {
+			gpgKeys = append(gpgKeys, repo.GPGKey)
 		}
-		gpgKeys = append(gpgKeys, repo.GPGKey)
+		gpgKeys = append(gpgKeys, repo.GPGKeys...)
 	}
 
 	var packages []string
@@ -631,13 +665,21 @@ func (r *imageType) firewallStageOptions(firewall *blueprint.FirewallCustomizati
 		options.DisabledServices = firewall.Services.Disabled
 	}
 
+	for _, zone := range firewall.Zones {
+		options.Zones = append(options.Zones, osbuild.FirewallZone{
+			Name:    zone.Name,
+			Sources: zone.Sources,
+		})
+	}
+
 	return &options
 }
 
 func (r *imageType) systemdStageOptions(enabledServices, disabledServices []string, s *blueprint.ServicesCustomization) *osbuild.SystemdStageOptions {
 	if s != nil {
-		enabledServices = append(enabledServices, s.Enabled...)
-		disabledServices = append(disabledServices, s.Disabled...)
+		// The customization overrides the defaults of the image type
+		enabledServices = append(without(enabledServices, s.Disabled), s.Enabled...)
+		disabledServices = append(without(disabledServices, s.Enabled), s.Disabled...)
 	}
 	return &osbuild.SystemdStageOptions{
 		EnabledServices:  enabledServices,
@@ -645,6 +687,55 @@ func (r *imageType) systemdStageOptions(enabledServices, disabledServices []stri
 	}
 }
 
+// without returns a copy of `list` without the strings in `remove`.
+func without(list, remove []string) []string {
+	removed := make(map[string]bool)
+	for _, s := range remove {
+		removed[s] = true
+	}
+
+	result := []string{}
+	for _, s := range list {
+		if !removed[s] {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// customizedAssembler creates the assembler of the image type and applies
+// the filesystem customizations to it. Mountpoints other than "/" get their
+// own partition, which is also added to `fstab`, unless it is nil.
+func (t *imageType) customizedAssembler(filesystems []blueprint.FilesystemCustomization, fstab *osbuild.FSTabStageOptions, size uint64) (*osbuild.Assembler, error) {
+	assembler := t.assembler(t.arch.uefi, size)
+	for _, fs := range filesystems {
+		switch options := assembler.Options.(type) {
+		case *osbuild.QEMUAssemblerOptions:
+			if fs.Mountpoint == "/" {
+				options.GrowRootPartition(fs.MinSize)
+				continue
+			}
+			id, err := options.AddPartition("ext4", fs.Mountpoint, fs.MinSize)
+			if err != nil {
+				return nil, fmt.Errorf("cannot add a partition for %s: %v", fs.Mountpoint, err)
+			}
+			if fstab != nil {
+				fstab.AddFilesystem(id, "ext4", fs.Mountpoint, "defaults", 1, 2)
+			}
+		case *osbuild.RawFSAssemblerOptions:
+			if fs.Mountpoint != "/" {
+				return nil, fmt.Errorf("%s images only have a root filesystem", t.name)
+			}
+			if options.Size < fs.MinSize {
+				options.Size = fs.MinSize
+			}
+		default:
+			return nil, fmt.Errorf("%s images do not support filesystem customizations", t.name)
+		}
+	}
+	return assembler, nil
+}
+
 func (r *imageType) fsTabStageOptions(uefi bool) *osbuild.FSTabStageOptions {
 	options := osbuild.FSTabStageOptions{}
 	options.AddFilesystem("76a22bf4-f153-4541-b6c7-0332c0dfaeac", "ext4", "/", "defaults", 1, 1)
@@ -654,13 +745,19 @@ func (r *imageType) fsTabStageOptions(uefi bool) *osbuild.FSTabStageOptions {
 	return &options
 }
 
-func (r *imageType) grub2StageOptions(kernelOptions string, kernel *blueprint.KernelCustomization, uefi bool) *osbuild.GRUB2StageOptions {
+func (r *imageType) grub2StageOptions(kernelOptions string, kernel *blueprint.KernelCustomization, kernelVer string, uefi bool) *osbuild.GRUB2StageOptions {
 	id := uuid.MustParse("76a22bf4-f153-4541-b6c7-0332c0dfaeac")
 
-	if kernel != nil {
+	if kernel != nil && kernel.Append != "" {
 		kernelOptions += " " + kernel.Append
 	}
 
+	// Boot the selected kernel by default, even if there are others
+	var savedEntry string
+	if kernelVer != "" {
+		savedEntry = "ffffffffffffffffffffffffffffffff-" + kernelVer
+	}
+
 	var uefiOptions *osbuild.GRUB2UEFI
 	if uefi {
 		uefiOptions = &osbuild.GRUB2UEFI{
@@ -673,6 +770,7 @@ func (r *imageType) grub2StageOptions(kernelOptions string, kernel *blueprint.Ke
 		KernelOptions:      kernelOptions,
 		Legacy:             !uefi,
 		UEFI:               uefiOptions,
+		SavedEntry:         savedEntry,
 	}
 }
 
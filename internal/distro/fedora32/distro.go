@@ -2,8 +2,10 @@ package fedora32
 
 import (
 	"errors"
+	"fmt"
 	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/osbuild/osbuild-composer/internal/distro"
 	"github.com/osbuild/osbuild-composer/internal/osbuild"
@@ -44,8 +46,11 @@ type imageType struct {
 	disabledServices []string
 	kernelOptions    string
 	bootable         bool
+	rpmOstree        bool
+	payload          string // the image type embedded by installers
+	buildPackages    []string
 	defaultSize      uint64
-	assembler        func(uefi bool, size uint64) *osbuild.Assembler
+	assembler        func(uefi bool, options distro.ImageOptions) *osbuild.Assembler
 }
 
 func (d *Fedora32) ListArches() []string {
@@ -99,6 +104,10 @@ func (a *arch) GetImageType(imageType string) (distro.ImageType, error) {
 		return nil, errors.New("invalid image type: " + imageType)
 	}
 
+	if t.payload != "" {
+		return &installerImageType{&t}, nil
+	}
+
 	return &t, nil
 }
 
@@ -116,6 +125,9 @@ func (a *arch) setImageTypes(imageTypes ...imageType) {
 			disabledServices: it.disabledServices,
 			kernelOptions:    it.kernelOptions,
 			bootable:         it.bootable,
+			rpmOstree:        it.rpmOstree,
+			payload:          it.payload,
+			buildPackages:    it.buildPackages,
 			defaultSize:      it.defaultSize,
 			assembler:        it.assembler,
 		}
@@ -156,15 +168,22 @@ func (t *imageType) BasePackages() ([]string, []string) {
 }
 
 func (t *imageType) BuildPackages() []string {
-	return append(t.arch.distro.buildPackages, t.arch.buildPackages...)
+	packages := append(t.arch.distro.buildPackages, t.arch.buildPackages...)
+	return append(packages, t.buildPackages...)
 }
 
 func (t *imageType) Manifest(c *blueprint.Customizations,
 	repos []rpmmd.RepoConfig,
 	packageSpecs,
 	buildPackageSpecs []rpmmd.PackageSpec,
-	size uint64) (*osbuild.Manifest, error) {
-	pipeline, err := t.pipeline(c, repos, packageSpecs, buildPackageSpecs, size)
+	options distro.ImageOptions) (*osbuild.Manifest, error) {
+	var pipeline *osbuild.Pipeline
+	var err error
+	if t.payload != "" {
+		pipeline, err = t.installerPipeline(repos, packageSpecs, buildPackageSpecs)
+	} else {
+		pipeline, err = t.pipeline(c, repos, packageSpecs, buildPackageSpecs, options)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -175,6 +194,16 @@ func (t *imageType) Manifest(c *blueprint.Customizations,
 	}, nil
 }
 
+// An installerImageType is an image type which embeds the image of another
+// image type (see distro.InstallerImageType).
+type installerImageType struct {
+	*imageType
+}
+
+func (t *installerImageType) PayloadImageType() string {
+	return t.payload
+}
+
 func New() *Fedora32 {
 	const GigaByte = 1024 * 1024 * 1024
 
@@ -203,8 +232,8 @@ func New() *Fedora32 {
 		kernelOptions: "ro no_timer_check console=ttyS0,115200n8 console=tty1 biosdevname=0 net.ifnames=0 console=ttyS0,115200",
 		bootable:      true,
 		defaultSize:   6 * GigaByte,
-		assembler: func(uefi bool, size uint64) *osbuild.Assembler {
-			return qemuAssembler("vhdx", "image.vhdx", uefi, size)
+		assembler: func(uefi bool, options distro.ImageOptions) *osbuild.Assembler {
+			return qemuAssembler("vhdx", "image.vhdx", uefi, options.Size)
 		},
 	}
 
@@ -226,7 +255,123 @@ func New() *Fedora32 {
 		kernelOptions: "ro biosdevname=0 net.ifnames=0",
 		bootable:      false,
 		defaultSize:   2 * GigaByte,
-		assembler:     func(uefi bool, size uint64) *osbuild.Assembler { return rawFSAssembler("filesystem.img", size) },
+		assembler: func(uefi bool, options distro.ImageOptions) *osbuild.Assembler {
+			return rawFSAssembler("filesystem.img", options.Size)
+		},
+	}
+
+	iotCommitImgType := imageType{
+		name:     "fedora-iot-commit",
+		filename: "commit.tar",
+		mimeType: "application/x-tar",
+		packages: []string{
+			"fedora-release-iot",
+			"glibc", "glibc-minimal-langpack", "nss-altfiles",
+			"sssd-client", "libsss_sudo", "shadow-utils",
+			"kernel",
+			"dracut-config-generic", "dracut-network",
+			"rpm-ostree",
+			"polkit",
+			"lvm2",
+			"cryptsetup",
+			"pinentry",
+			"e2fsprogs",
+			"xfsprogs",
+			"dosfstools",
+			"gnupg2",
+			"basesystem",
+			"python3",
+			"bash",
+			"xz",
+			"gzip",
+			"coreutils",
+			"which",
+			"curl",
+			"firewalld",
+			"iptables",
+			"NetworkManager", "NetworkManager-wifi", "NetworkManager-wwan",
+			"wpa_supplicant",
+			"iproute",
+			"iputils",
+			"openssh-clients", "openssh-server",
+			"passwd",
+			"policycoreutils",
+			"procps-ng",
+			"rootfiles",
+			"rpm",
+			"selinux-policy-targeted",
+			"setup",
+			"sudo",
+			"systemd",
+			"util-linux",
+			"vim-minimal",
+			"less",
+			"tar",
+			"greenboot", "greenboot-grub2", "greenboot-rpm-ostree-grub2", "greenboot-reboot", "greenboot-status",
+			"chrony",
+			"podman", "container-selinux", "skopeo",
+			"grub2", "grub2-efi-x64", "efibootmgr", "shim-x64",
+			"langpacks-en",
+		},
+		excludedPackages: []string{
+			"dracut-config-rescue",
+		},
+		enabledServices: []string{
+			"NetworkManager.service",
+			"firewalld.service",
+			"sshd.service",
+			"greenboot-grub2-set-counter.service",
+			"greenboot-grub2-set-success.service",
+			"greenboot-healthcheck.service",
+			"greenboot-rpm-ostree-grub2-check-fallback.service",
+			"greenboot-status.service",
+			"greenboot-task-runner.service",
+			"redboot-auto-reboot.service",
+			"redboot-task-runner.service",
+		},
+		rpmOstree: true,
+		buildPackages: []string{
+			"ostree",
+			"rpm-ostree",
+		},
+		assembler: func(uefi bool, options distro.ImageOptions) *osbuild.Assembler {
+			return ostreeCommitAssembler("commit.tar", options.OSTree)
+		},
+	}
+
+	imageInstallerImgType := imageType{
+		name:     "image-installer",
+		filename: "installer.iso",
+		mimeType: "application/x-iso9660-image",
+		packages: []string{
+			"anaconda",
+			"anaconda-dracut",
+			"anaconda-install-env-deps",
+			"dracut-config-generic",
+			"dracut-network",
+			"grub2-efi-x64-cdboot",
+			"grub2-pc-modules",
+			"grub2-tools",
+			"grub2-tools-extra",
+			"isomd5sum",
+			"kernel",
+			"kernel-modules",
+			"kernel-modules-extra",
+			"lorax-templates-generic",
+			"plymouth",
+			"selinux-policy-targeted",
+			"shim-x64",
+			"syslinux",
+			"tar",
+			"xz",
+		},
+		payload: "tar",
+		buildPackages: []string{
+			"isomd5sum",
+			"lorax",
+			"squashfs-tools",
+			"xorriso",
+		},
 	}
 
 	partitionedDisk := imageType{
@@ -247,8 +392,8 @@ func New() *Fedora32 {
 		kernelOptions: "ro biosdevname=0 net.ifnames=0",
 		bootable:      true,
 		defaultSize:   2 * GigaByte,
-		assembler: func(uefi bool, size uint64) *osbuild.Assembler {
-			return qemuAssembler("raw", "disk.img", uefi, size)
+		assembler: func(uefi bool, options distro.ImageOptions) *osbuild.Assembler {
+			return qemuAssembler("raw", "disk.img", uefi, options.Size)
 		},
 	}
 
@@ -275,8 +420,8 @@ func New() *Fedora32 {
 		kernelOptions: "ro biosdevname=0 net.ifnames=0",
 		bootable:      true,
 		defaultSize:   2 * GigaByte,
-		assembler: func(uefi bool, size uint64) *osbuild.Assembler {
-			return qemuAssembler("qcow2", "disk.qcow2", uefi, size)
+		assembler: func(uefi bool, options distro.ImageOptions) *osbuild.Assembler {
+			return qemuAssembler("qcow2", "disk.qcow2", uefi, options.Size)
 		},
 	}
 
@@ -302,8 +447,8 @@ func New() *Fedora32 {
 		kernelOptions: "ro biosdevname=0 net.ifnames=0",
 		bootable:      true,
 		defaultSize:   2 * GigaByte,
-		assembler: func(uefi bool, size uint64) *osbuild.Assembler {
-			return qemuAssembler("qcow2", "disk.qcow2", uefi, size)
+		assembler: func(uefi bool, options distro.ImageOptions) *osbuild.Assembler {
+			return qemuAssembler("qcow2", "disk.qcow2", uefi, options.Size)
 		},
 	}
 
@@ -325,7 +470,9 @@ func New() *Fedora32 {
 		kernelOptions: "ro biosdevname=0 net.ifnames=0",
 		bootable:      false,
 		defaultSize:   2 * GigaByte,
-		assembler:     func(uefi bool, size uint64) *osbuild.Assembler { return tarAssembler("root.tar.xz", "xz") },
+		assembler: func(uefi bool, options distro.ImageOptions) *osbuild.Assembler {
+			return tarAssembler("root.tar.xz", "xz")
+		},
 	}
 
 	vhdImgType := imageType{
@@ -360,8 +507,8 @@ func New() *Fedora32 {
 		kernelOptions: "ro biosdevname=0 rootdelay=300 console=ttyS0 earlyprintk=ttyS0 net.ifnames=0",
 		bootable:      true,
 		defaultSize:   2 * GigaByte,
-		assembler: func(uefi bool, size uint64) *osbuild.Assembler {
-			return qemuAssembler("vpc", "disk.vhd", uefi, size)
+		assembler: func(uefi bool, options distro.ImageOptions) *osbuild.Assembler {
+			return qemuAssembler("vpc", "disk.vhd", uefi, options.Size)
 		},
 	}
 
@@ -384,8 +531,8 @@ func New() *Fedora32 {
 		kernelOptions: "ro biosdevname=0 net.ifnames=0",
 		bootable:      true,
 		defaultSize:   2 * GigaByte,
-		assembler: func(uefi bool, size uint64) *osbuild.Assembler {
-			return qemuAssembler("vmdk", "disk.vmdk", uefi, size)
+		assembler: func(uefi bool, options distro.ImageOptions) *osbuild.Assembler {
+			return qemuAssembler("vmdk", "disk.vmdk", uefi, options.Size)
 		},
 	}
 
@@ -416,6 +563,8 @@ func New() *Fedora32 {
 	x8664.setImageTypes(
 		amiImgType,
 		ext4FilesystemType,
+		iotCommitImgType,
+		imageInstallerImgType,
 		partitionedDisk,
 		qcow2ImageType,
 		openstackImgType,
@@ -470,7 +619,24 @@ func sources(packages []rpmmd.PackageSpec) *osbuild.Sources {
 	}
 }
 
-func (t *imageType) pipeline(c *blueprint.Customizations, repos []rpmmd.RepoConfig, packageSpecs, buildPackageSpecs []rpmmd.PackageSpec, size uint64) (*osbuild.Pipeline, error) {
+// kernelVerStr returns the version of kernel package `name` in `packages`,
+// as it appears in the names of boot loader entries, or "" if `packages`
+// don't contain it.
+func kernelVerStr(packages []rpmmd.PackageSpec, name string) string {
+	for _, pkg := range packages {
+		if pkg.Name != name {
+			continue
+		}
+		version := fmt.Sprintf("%s-%s.%s", pkg.Version, pkg.Release, pkg.Arch)
+		if strings.HasSuffix(name, "-debug") {
+			version += "+debug"
+		}
+		return version
+	}
+	return ""
+}
+
+func (t *imageType) pipeline(c *blueprint.Customizations, repos []rpmmd.RepoConfig, packageSpecs, buildPackageSpecs []rpmmd.PackageSpec, options distro.ImageOptions) (*osbuild.Pipeline, error) {
 	p := &osbuild.Pipeline{}
 	p.SetBuild(t.buildPipeline(repos, *t.arch, buildPackageSpecs), "org.osbuild.fedora32")
 
@@ -505,6 +671,11 @@ func (t *imageType) pipeline(c *blueprint.Customizations, repos []rpmmd.RepoConf
 		p.AddStage(osbuild.NewChronyStage(&osbuild.ChronyStageOptions{ntpServers}))
 	}
 
+	// Groups are created first, so that users can be added to them
+	if groups := c.GetGroups(); len(groups) > 0 {
+		p.AddStage(osbuild.NewGroupsStage(t.groupStageOptions(groups)))
+	}
+
 	if users := c.GetUsers(); len(users) > 0 {
 		options, err := t.userStageOptions(users)
 		if err != nil {
@@ -513,13 +684,28 @@ func (t *imageType) pipeline(c *blueprint.Customizations, repos []rpmmd.RepoConf
 		p.AddStage(osbuild.NewUsersStage(options))
 	}
 
-	if groups := c.GetGroups(); len(groups) > 0 {
-		p.AddStage(osbuild.NewGroupsStage(t.groupStageOptions(groups)))
+	// Commits are made for the ref of Fedora IoT, unless another one is given
+	if t.rpmOstree && options.OSTree.Ref == "" {
+		options.OSTree.Ref = "fedora/32/" + t.arch.name + "/iot"
 	}
 
+	var fstabOptions *osbuild.FSTabStageOptions
 	if t.bootable {
-		p.AddStage(osbuild.NewFSTabStage(t.fsTabStageOptions(t.arch.uefi)))
-		p.AddStage(osbuild.NewGRUB2Stage(t.grub2StageOptions(t.kernelOptions, c.GetKernel(), t.arch.uefi)))
+		fstabOptions = t.fsTabStageOptions(t.arch.uefi)
+	}
+	assembler, err := t.customizedAssembler(c.GetFilesystems(), fstabOptions, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.bootable {
+		p.AddStage(osbuild.NewFSTabStage(fstabOptions))
+		kernel := c.GetKernel()
+		var kernelVer string
+		if kernel != nil && kernel.Name != "" {
+			kernelVer = kernelVerStr(packageSpecs, kernel.Name)
+		}
+		p.AddStage(osbuild.NewGRUB2Stage(t.grub2StageOptions(t.kernelOptions, kernel, kernelVer, t.arch.uefi)))
 	}
 
 	if services := c.GetServices(); services != nil || t.enabledServices != nil {
@@ -532,7 +718,58 @@ func (t *imageType) pipeline(c *blueprint.Customizations, repos []rpmmd.RepoConf
 
 	p.AddStage(osbuild.NewSELinuxStage(t.selinuxStageOptions()))
 
-	p.Assembler = t.assembler(t.arch.uefi, size)
+	if t.rpmOstree {
+		p.AddStage(osbuild.NewRPMOSTreeStage(&osbuild.RPMOSTreeStageOptions{
+			EtcGroupMembers: []string{
+				// NOTE: We may want to make this configurable.
+				"wheel", "docker",
+			},
+		}))
+	}
+
+	p.Assembler = assembler
+
+	return p, nil
+}
+
+// installerPipeline creates the pipeline of an installer ISO, which installs
+// the payload unattended. Customizations of the blueprint only apply to the
+// payload.
+func (t *imageType) installerPipeline(repos []rpmmd.RepoConfig, packageSpecs, buildPackageSpecs []rpmmd.PackageSpec) (*osbuild.Pipeline, error) {
+	payload, exists := t.arch.imageTypes[t.payload]
+	if !exists {
+		return nil, fmt.Errorf("%s embeds unknown image type %s", t.name, t.payload)
+	}
+
+	p := &osbuild.Pipeline{}
+	p.SetBuild(t.buildPipeline(repos, *t.arch, buildPackageSpecs), "org.osbuild.fedora32")
+
+	p.AddStage(osbuild.NewRPMStage(t.rpmStageOptions(*t.arch, repos, packageSpecs)))
+	p.AddStage(osbuild.NewLocaleStage(&osbuild.LocaleStageOptions{Language: "en_US"}))
+	p.AddStage(osbuild.NewAnacondaStage(&osbuild.AnacondaStageOptions{
+		KickstartModules: []string{
+			"org.fedoraproject.Anaconda.Modules.Network",
+			"org.fedoraproject.Anaconda.Modules.Payloads",
+			"org.fedoraproject.Anaconda.Modules.Storage",
+		},
+	}))
+	p.AddStage(osbuild.NewPayloadStage(&osbuild.PayloadStageOptions{Filename: payload.filename}))
+	p.AddStage(osbuild.NewKickstartStage(&osbuild.KickstartStageOptions{
+		Path: "/usr/share/anaconda/interactive-defaults.ks",
+		LiveIMG: &osbuild.KickstartLiveIMGOptions{
+			URL: "file://" + osbuild.PayloadPath(payload.filename),
+		},
+	}))
+	p.AddStage(osbuild.NewSELinuxStage(t.selinuxStageOptions()))
+
+	p.Assembler = osbuild.NewBootISOAssembler(&osbuild.BootISOAssemblerOptions{
+		Filename: t.filename,
+		Product: osbuild.BootISOProduct{
+			Name:    "Fedora",
+			Version: "32",
+		},
+		ISOLabel: "Fedora-32-" + t.arch.name,
+	})
 
 	return p, nil
 }
@@ -546,10 +783,10 @@ func (r *imageType) buildPipeline(repos []rpmmd.RepoConfig, arch arch, buildPack
 func (r *imageType) rpmStageOptions(arch arch, repos []rpmmd.RepoConfig, specs []rpmmd.PackageSpec) *osbuild.RPMStageOptions {
 	var gpgKeys []string
 	for _, repo := range repos {
-		if repo.GPGKey == "" {
-			continue
+		if repo.GPGKey != "" {
+			gpgKeys = append(gpgKeys, repo.GPGKey)
 		}
-		gpgKeys = append(gpgKeys, repo.GPGKey)
+		gpgKeys = append(gpgKeys, repo.GPGKeys...)
 	}
 
 	var packages []string
@@ -633,13 +870,21 @@ func (r *imageType) firewallStageOptions(firewall *blueprint.FirewallCustomizati
 		options.DisabledServices = firewall.Services.Disabled
 	}
 
+	for _, zone := range firewall.Zones {
+		options.Zones = append(options.Zones, osbuild.FirewallZone{
+			Name:    zone.Name,
+			Sources: zone.Sources,
+		})
+	}
+
 	return &options
 }
 
 func (r *imageType) systemdStageOptions(enabledServices, disabledServices []string, s *blueprint.ServicesCustomization) *osbuild.SystemdStageOptions {
 	if s != nil {
-		enabledServices = append(enabledServices, s.Enabled...)
-		disabledServices = append(disabledServices, s.Disabled...)
+		// The customization overrides the defaults of the image type
+		enabledServices = append(without(enabledServices, s.Disabled), s.Enabled...)
+		disabledServices = append(without(disabledServices, s.Enabled), s.Disabled...)
 	}
 	return &osbuild.SystemdStageOptions{
 		EnabledServices:  enabledServices,
@@ -647,6 +892,55 @@ func (r *imageType) systemdStageOptions(enabledServices, disabledServices []stri
 	}
 }
 
+// without returns a copy of `list` without the strings in `remove`.
+func without(list, remove []string) []string {
+	removed := make(map[string]bool)
+	for _, s := range remove {
+		removed[s] = true
+	}
+
+	result := []string{}
+	for _, s := range list {
+		if !removed[s] {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// customizedAssembler creates the assembler of the image type and applies
+// the filesystem customizations to it. Mountpoints other than "/" get their
+// own partition, which is also added to `fstab`, unless it is nil.
+func (t *imageType) customizedAssembler(filesystems []blueprint.FilesystemCustomization, fstab *osbuild.FSTabStageOptions, options distro.ImageOptions) (*osbuild.Assembler, error) {
+	assembler := t.assembler(t.arch.uefi, options)
+	for _, fs := range filesystems {
+		switch options := assembler.Options.(type) {
+		case *osbuild.QEMUAssemblerOptions:
+			if fs.Mountpoint == "/" {
+				options.GrowRootPartition(fs.MinSize)
+				continue
+			}
+			id, err := options.AddPartition("ext4", fs.Mountpoint, fs.MinSize)
+			if err != nil {
+				return nil, fmt.Errorf("cannot add a partition for %s: %v", fs.Mountpoint, err)
+			}
+			if fstab != nil {
+				fstab.AddFilesystem(id, "ext4", fs.Mountpoint, "defaults", 1, 2)
+			}
+		case *osbuild.RawFSAssemblerOptions:
+			if fs.Mountpoint != "/" {
+				return nil, fmt.Errorf("%s images only have a root filesystem", t.name)
+			}
+			if options.Size < fs.MinSize {
+				options.Size = fs.MinSize
+			}
+		default:
+			return nil, fmt.Errorf("%s images do not support filesystem customizations", t.name)
+		}
+	}
+	return assembler, nil
+}
+
 func (r *imageType) fsTabStageOptions(uefi bool) *osbuild.FSTabStageOptions {
 	options := osbuild.FSTabStageOptions{}
 	options.AddFilesystem("76a22bf4-f153-4541-b6c7-0332c0dfaeac", "ext4", "/", "defaults", 1, 1)
@@ -656,13 +950,19 @@ func (r *imageType) fsTabStageOptions(uefi bool) *osbuild.FSTabStageOptions {
 	return &options
 }
 
-func (r *imageType) grub2StageOptions(kernelOptions string, kernel *blueprint.KernelCustomization, uefi bool) *osbuild.GRUB2StageOptions {
+func (r *imageType) grub2StageOptions(kernelOptions string, kernel *blueprint.KernelCustomization, kernelVer string, uefi bool) *osbuild.GRUB2StageOptions {
 	id := uuid.MustParse("76a22bf4-f153-4541-b6c7-0332c0dfaeac")
 
-	if kernel != nil {
+	if kernel != nil && kernel.Append != "" {
 		kernelOptions += " " + kernel.Append
 	}
 
+	// Boot the selected kernel by default, even if there are others
+	var savedEntry string
+	if kernelVer != "" {
+		savedEntry = "ffffffffffffffffffffffffffffffff-" + kernelVer
+	}
+
 	var uefiOptions *osbuild.GRUB2UEFI
 	if uefi {
 		uefiOptions = &osbuild.GRUB2UEFI{
@@ -675,6 +975,7 @@ func (r *imageType) grub2StageOptions(kernelOptions string, kernel *blueprint.Ke
 		KernelOptions:      kernelOptions,
 		Legacy:             !uefi,
 		UEFI:               uefiOptions,
+		SavedEntry:         savedEntry,
 	}
 }
 
@@ -749,6 +1050,18 @@ func tarAssembler(filename, compression string) *osbuild.Assembler {
 		})
 }
 
+func ostreeCommitAssembler(filename string, options distro.OSTreeImageOptions) *osbuild.Assembler {
+	return osbuild.NewOSTreeCommitAssembler(
+		&osbuild.OSTreeCommitAssemblerOptions{
+			Ref:    options.Ref,
+			Parent: options.Parent,
+			Tar: osbuild.OSTreeCommitAssemblerTarOptions{
+				Filename: filename,
+			},
+		},
+	)
+}
+
 func rawFSAssembler(filename string, size uint64) *osbuild.Assembler {
 	id := uuid.MustParse("76a22bf4-f153-4541-b6c7-0332c0dfaeac")
 	return osbuild.NewRawFSAssembler(
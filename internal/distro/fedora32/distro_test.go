@@ -4,8 +4,12 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/distro"
 	"github.com/osbuild/osbuild-composer/internal/distro/distro_test_common"
 	"github.com/osbuild/osbuild-composer/internal/distro/fedora32"
+	"github.com/osbuild/osbuild-composer/internal/osbuild"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -32,6 +36,18 @@ func TestFilenameFromType(t *testing.T) {
 			want:  "filesystem.img",
 			want1: "application/octet-stream",
 		},
+		{
+			name:  "fedora-iot-commit",
+			args:  args{"fedora-iot-commit"},
+			want:  "commit.tar",
+			want1: "application/x-tar",
+		},
+		{
+			name:  "image-installer",
+			args:  args{"image-installer"},
+			want:  "installer.iso",
+			want1: "application/x-iso9660-image",
+		},
 		{
 			name:  "openstack",
 			args:  args{"openstack"},
@@ -152,6 +168,8 @@ func TestImageType_Name(t *testing.T) {
 			imgNames: []string{
 				"ami",
 				"ext4-filesystem",
+				"fedora-iot-commit",
+				"image-installer",
 				"partitioned-disk",
 				"qcow2",
 				"openstack",
@@ -228,6 +246,232 @@ func TestImageType_Size(t *testing.T) {
 	}
 }
 
+func TestImageType_FilesystemCustomizations(t *testing.T) {
+	const gigaByte = 1024 * 1024 * 1024
+	customizations := &blueprint.Customizations{
+		Filesystem: []blueprint.FilesystemCustomization{
+			{Mountpoint: "/", MinSize: 8 * gigaByte},
+			{Mountpoint: "/var", MinSize: 2 * gigaByte},
+		},
+	}
+
+	d := fedora32.New()
+	arch, err := d.GetArch("x86_64")
+	assert.NoError(t, err)
+
+	imgType, err := arch.GetImageType("qcow2")
+	assert.NoError(t, err)
+	manifest, err := imgType.Manifest(customizations, nil, nil, nil, distro.ImageOptions{Size: imgType.Size(0)})
+	assert.NoError(t, err)
+
+	options := manifest.Pipeline.Assembler.Options.(*osbuild.QEMUAssemblerOptions)
+	assert.Len(t, options.Partitions, 2)
+	assert.Equal(t, "/var", options.Partitions[0].Filesystem.Mountpoint)
+	assert.Equal(t, uint64(2*gigaByte/512), options.Partitions[0].Size)
+	assert.Equal(t, "/", options.Partitions[1].Filesystem.Mountpoint)
+	assert.Equal(t, options.Partitions[0].Start+options.Partitions[0].Size, options.Partitions[1].Start)
+	assert.Equal(t, options.Partitions[1].Start*512+8*gigaByte, options.Size)
+
+	var fstab *osbuild.FSTabStageOptions
+	for _, stage := range manifest.Pipeline.Stages {
+		if stage.Name == "org.osbuild.fstab" {
+			fstab = stage.Options.(*osbuild.FSTabStageOptions)
+		}
+	}
+	if assert.NotNil(t, fstab) {
+		assert.Equal(t, "/var", fstab.FileSystems[len(fstab.FileSystems)-1].Path)
+		assert.Equal(t, options.Partitions[0].Filesystem.UUID, fstab.FileSystems[len(fstab.FileSystems)-1].UUID)
+	}
+
+	imgType, err = arch.GetImageType("tar")
+	assert.NoError(t, err)
+	_, err = imgType.Manifest(customizations, nil, nil, nil, distro.ImageOptions{})
+	assert.Error(t, err)
+}
+
+func TestImageType_OSTreeCommit(t *testing.T) {
+	d := fedora32.New()
+	arch, err := d.GetArch("x86_64")
+	assert.NoError(t, err)
+	imgType, err := arch.GetImageType("fedora-iot-commit")
+	assert.NoError(t, err)
+
+	manifest, err := imgType.Manifest(nil, nil, nil, nil, distro.ImageOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "org.osbuild.rpm-ostree", manifest.Pipeline.Stages[len(manifest.Pipeline.Stages)-1].Name)
+	options := manifest.Pipeline.Assembler.Options.(*osbuild.OSTreeCommitAssemblerOptions)
+	assert.Equal(t, "fedora/32/x86_64/iot", options.Ref)
+	assert.Equal(t, "", options.Parent)
+	assert.Equal(t, "commit.tar", options.Tar.Filename)
+
+	ostree := distro.OSTreeImageOptions{
+		Ref:    "example/iot",
+		Parent: "8d2cb4ee5d4d0c27c41bc1d2ed9b6a0a24c6a5a31f7a3bd7a6c6a2c5e9b7f001",
+	}
+	manifest, err = imgType.Manifest(nil, nil, nil, nil, distro.ImageOptions{OSTree: ostree})
+	assert.NoError(t, err)
+	options = manifest.Pipeline.Assembler.Options.(*osbuild.OSTreeCommitAssemblerOptions)
+	assert.Equal(t, ostree.Ref, options.Ref)
+	assert.Equal(t, ostree.Parent, options.Parent)
+
+	customizations := &blueprint.Customizations{
+		Filesystem: []blueprint.FilesystemCustomization{{Mountpoint: "/", MinSize: 1024}},
+	}
+	_, err = imgType.Manifest(customizations, nil, nil, nil, distro.ImageOptions{})
+	assert.Error(t, err)
+}
+
+func TestImageType_Installer(t *testing.T) {
+	d := fedora32.New()
+	arch, err := d.GetArch("x86_64")
+	assert.NoError(t, err)
+
+	imgType, err := arch.GetImageType("qcow2")
+	assert.NoError(t, err)
+	_, isInstaller := imgType.(distro.InstallerImageType)
+	assert.False(t, isInstaller)
+
+	imgType, err = arch.GetImageType("image-installer")
+	assert.NoError(t, err)
+	installer, isInstaller := imgType.(distro.InstallerImageType)
+	if !assert.True(t, isInstaller) {
+		return
+	}
+	assert.Equal(t, "tar", installer.PayloadImageType())
+
+	manifest, err := installer.Manifest(nil, nil, nil, nil, distro.ImageOptions{})
+	assert.NoError(t, err)
+	assert.True(t, manifest.HasPayload())
+	assert.Equal(t, "org.osbuild.bootiso", manifest.Pipeline.Assembler.Name)
+	for _, stage := range manifest.Pipeline.Stages {
+		if options, ok := stage.Options.(*osbuild.PayloadStageOptions); ok {
+			assert.Equal(t, "root.tar.xz", options.Filename)
+		}
+	}
+}
+
+func TestImageType_KernelCustomizations(t *testing.T) {
+	customizations := &blueprint.Customizations{
+		Kernel: &blueprint.KernelCustomization{Name: "kernel-debug", Append: "nosmt"},
+	}
+	packages := []rpmmd.PackageSpec{
+		{Name: "kernel-debug", Version: "5.6.6", Release: "300.fc32", Arch: "x86_64"},
+	}
+
+	d := fedora32.New()
+	arch, err := d.GetArch("x86_64")
+	assert.NoError(t, err)
+	imgType, err := arch.GetImageType("qcow2")
+	assert.NoError(t, err)
+	manifest, err := imgType.Manifest(customizations, nil, packages, nil, distro.ImageOptions{Size: imgType.Size(0)})
+	assert.NoError(t, err)
+
+	var grub2 *osbuild.GRUB2StageOptions
+	for _, stage := range manifest.Pipeline.Stages {
+		if stage.Name == "org.osbuild.grub2" {
+			grub2 = stage.Options.(*osbuild.GRUB2StageOptions)
+		}
+	}
+	if assert.NotNil(t, grub2) {
+		assert.Equal(t, "ffffffffffffffffffffffffffffffff-5.6.6-300.fc32.x86_64+debug", grub2.SavedEntry)
+		assert.Contains(t, grub2.KernelOptions, " nosmt")
+	}
+}
+
+func TestImageType_UserCustomizations(t *testing.T) {
+	key := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOctopus octopus@example.com"
+	password := "$6$octopus$Xk5fT2zqbY8hVX1PMLV5eYQAKQ3p3JmUlLu2m9UQ2P4GQvA3lKXkPBqGkQFb0nH5k9m1eTo2Bk1eO7f7Tq3k0."
+	uid := 1001
+	gid := 1010
+	customizations := &blueprint.Customizations{
+		SSHKey: []blueprint.SSHKeyCustomization{{User: "octopus", Key: key}},
+		User: []blueprint.UserCustomization{
+			{Name: "octopus", Password: &password, Groups: []string{"wheel", "ink"}, UID: &uid},
+		},
+		Group: []blueprint.GroupCustomization{{Name: "ink", GID: &gid}},
+	}
+
+	d := fedora32.New()
+	arch, err := d.GetArch("x86_64")
+	assert.NoError(t, err)
+	imgType, err := arch.GetImageType("qcow2")
+	assert.NoError(t, err)
+	manifest, err := imgType.Manifest(customizations, nil, nil, nil, distro.ImageOptions{Size: imgType.Size(0)})
+	assert.NoError(t, err)
+
+	var stages []string
+	var users *osbuild.UsersStageOptions
+	var groups *osbuild.GroupsStageOptions
+	for _, stage := range manifest.Pipeline.Stages {
+		switch stage.Name {
+		case "org.osbuild.users":
+			users = stage.Options.(*osbuild.UsersStageOptions)
+		case "org.osbuild.groups":
+			groups = stage.Options.(*osbuild.GroupsStageOptions)
+		default:
+			continue
+		}
+		stages = append(stages, stage.Name)
+	}
+	assert.Equal(t, []string{"org.osbuild.groups", "org.osbuild.users"}, stages)
+
+	if assert.NotNil(t, groups) {
+		assert.Equal(t, "1010", *groups.Groups["ink"].GID)
+	}
+	if assert.NotNil(t, users) {
+		octopus := users.Users["octopus"]
+		assert.Equal(t, password, *octopus.Password)
+		assert.Equal(t, key, *octopus.Key)
+		assert.Equal(t, "1001", *octopus.UID)
+		assert.Equal(t, []string{"wheel", "ink"}, octopus.Groups)
+	}
+}
+
+func TestImageType_ServiceCustomizations(t *testing.T) {
+	customizations := &blueprint.Customizations{
+		Services: &blueprint.ServicesCustomization{
+			Enabled:  []string{"sshd"},
+			Disabled: []string{"cloud-init.service"},
+		},
+		Firewall: &blueprint.FirewallCustomization{
+			Ports: []string{"8080:tcp"},
+			Zones: []blueprint.FirewallZoneCustomization{
+				{Name: "trusted", Sources: []string{"192.0.2.0/24"}},
+			},
+		},
+	}
+
+	d := fedora32.New()
+	arch, err := d.GetArch("x86_64")
+	assert.NoError(t, err)
+	imgType, err := arch.GetImageType("ami")
+	assert.NoError(t, err)
+	manifest, err := imgType.Manifest(customizations, nil, nil, nil, distro.ImageOptions{Size: imgType.Size(0)})
+	assert.NoError(t, err)
+
+	var systemd *osbuild.SystemdStageOptions
+	var firewall *osbuild.FirewallStageOptions
+	for _, stage := range manifest.Pipeline.Stages {
+		switch stage.Name {
+		case "org.osbuild.systemd":
+			systemd = stage.Options.(*osbuild.SystemdStageOptions)
+		case "org.osbuild.firewall":
+			firewall = stage.Options.(*osbuild.FirewallStageOptions)
+		}
+	}
+
+	// cloud-init is enabled by default, but the customization disables it
+	if assert.NotNil(t, systemd) {
+		assert.Contains(t, systemd.EnabledServices, "sshd")
+		assert.NotContains(t, systemd.EnabledServices, "cloud-init.service")
+		assert.Contains(t, systemd.DisabledServices, "cloud-init.service")
+	}
+	if assert.NotNil(t, firewall) {
+		assert.Equal(t, []string{"8080:tcp"}, firewall.Ports)
+		assert.Equal(t, []osbuild.FirewallZone{{Name: "trusted", Sources: []string{"192.0.2.0/24"}}}, firewall.Zones)
+	}
+}
+
 func TestImageType_BasePackages(t *testing.T) {
 	pkgMaps := []struct {
 		name               string
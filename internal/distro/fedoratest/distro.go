@@ -25,11 +25,11 @@ type fedoraTestDistroImageType struct {
 }
 
 func (d *FedoraTestDistro) ListArches() []string {
-	return []string{"x86_64"}
+	return []string{"aarch64", "x86_64"}
 }
 
 func (d *FedoraTestDistro) GetArch(arch string) (distro.Arch, error) {
-	if arch != "x86_64" {
+	if arch != "x86_64" && arch != "aarch64" {
 		return nil, errors.New("invalid architecture: " + arch)
 	}
 
@@ -44,11 +44,11 @@ func (a *fedoraTestDistroArch) Name() string {
 }
 
 func (a *fedoraTestDistroArch) ListImageTypes() []string {
-	return []string{"qcow2"}
+	return []string{"qcow2", "openstack"}
 }
 
 func (a *fedoraTestDistroArch) GetImageType(imageType string) (distro.ImageType, error) {
-	if imageType != "qcow2" {
+	if imageType != "qcow2" && imageType != "openstack" {
 		return nil, errors.New("invalid image type: " + imageType)
 	}
 
@@ -86,7 +86,7 @@ func (t *fedoraTestDistroImageType) Manifest(c *blueprint.Customizations,
 	repos []rpmmd.RepoConfig,
 	packageSpecs,
 	buildPackageSpecs []rpmmd.PackageSpec,
-	size uint64) (*osbuild.Manifest, error) {
+	options distro.ImageOptions) (*osbuild.Manifest, error) {
 	return &osbuild.Manifest{
 		Pipeline: osbuild.Pipeline{},
 		Sources:  osbuild.Sources{},
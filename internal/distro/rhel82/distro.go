@@ -2,8 +2,10 @@ package rhel82
 
 import (
 	"errors"
+	"fmt"
 	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/osbuild/osbuild-composer/internal/distro"
 	"github.com/osbuild/osbuild-composer/internal/osbuild"
@@ -146,8 +148,8 @@ func (t *rhel82ImageType) Manifest(c *blueprint.Customizations,
 	repos []rpmmd.RepoConfig,
 	packageSpecs,
 	buildPackageSpecs []rpmmd.PackageSpec,
-	size uint64) (*osbuild.Manifest, error) {
-	pipeline, err := t.pipeline(c, repos, packageSpecs, buildPackageSpecs, size)
+	options distro.ImageOptions) (*osbuild.Manifest, error) {
+	pipeline, err := t.pipeline(c, repos, packageSpecs, buildPackageSpecs, options.Size)
 	if err != nil {
 		return nil, err
 	}
@@ -576,6 +578,23 @@ func sources(packages []rpmmd.PackageSpec) *osbuild.Sources {
 	}
 }
 
+// kernelVerStr returns the version of kernel package `name` in `packages`,
+// as it appears in the names of boot loader entries, or "" if `packages`
+// don't contain it.
+func kernelVerStr(packages []rpmmd.PackageSpec, name string) string {
+	for _, pkg := range packages {
+		if pkg.Name != name {
+			continue
+		}
+		version := fmt.Sprintf("%s-%s.%s", pkg.Version, pkg.Release, pkg.Arch)
+		if strings.HasSuffix(name, "-debug") {
+			version += "+debug"
+		}
+		return version
+	}
+	return ""
+}
+
 func (t *rhel82ImageType) pipeline(c *blueprint.Customizations, repos []rpmmd.RepoConfig, packageSpecs, buildPackageSpecs []rpmmd.PackageSpec, size uint64) (*osbuild.Pipeline, error) {
 	p := &osbuild.Pipeline{}
 	p.SetBuild(t.buildPipeline(repos, *t.arch.arch, buildPackageSpecs), "org.osbuild.rhel82")
@@ -583,15 +602,30 @@ func (t *rhel82ImageType) pipeline(c *blueprint.Customizations, repos []rpmmd.Re
 	p.AddStage(osbuild.NewRPMStage(t.rpmStageOptions(*t.arch.arch, repos, packageSpecs)))
 	p.AddStage(osbuild.NewFixBLSStage())
 
+	var fstabOptions *osbuild.FSTabStageOptions
 	if t.imageType.bootable {
-		p.AddStage(osbuild.NewFSTabStage(t.fsTabStageOptions(t.arch.arch.uefi)))
+		fstabOptions = t.fsTabStageOptions(t.arch.arch.uefi)
+	}
+	assembler, err := t.customizedAssembler(c.GetFilesystems(), fstabOptions, size)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.imageType.bootable {
+		p.AddStage(osbuild.NewFSTabStage(fstabOptions))
 	}
 
 	kernelOptions := t.imageType.kernelOptions
+	var kernelVer string
 	if kernel := c.GetKernel(); kernel != nil {
-		kernelOptions += " " + kernel.Append
+		if kernel.Append != "" {
+			kernelOptions += " " + kernel.Append
+		}
+		if kernel.Name != "" {
+			kernelVer = kernelVerStr(packageSpecs, kernel.Name)
+		}
 	}
-	p.AddStage(osbuild.NewGRUB2Stage(t.grub2StageOptions(kernelOptions, t.arch.arch.uefi)))
+	p.AddStage(osbuild.NewGRUB2Stage(t.grub2StageOptions(kernelOptions, kernelVer, t.arch.arch.uefi)))
 
 	// TODO support setting all languages and install corresponding langpack-* package
 	language, keyboard := c.GetPrimaryLocale()
@@ -621,6 +655,11 @@ func (t *rhel82ImageType) pipeline(c *blueprint.Customizations, repos []rpmmd.Re
 		p.AddStage(osbuild.NewChronyStage(&osbuild.ChronyStageOptions{ntpServers}))
 	}
 
+	// Groups are created first, so that users can be added to them
+	if groups := c.GetGroups(); len(groups) > 0 {
+		p.AddStage(osbuild.NewGroupsStage(t.groupStageOptions(groups)))
+	}
+
 	if users := c.GetUsers(); len(users) > 0 {
 		options, err := t.userStageOptions(users)
 		if err != nil {
@@ -629,10 +668,6 @@ func (t *rhel82ImageType) pipeline(c *blueprint.Customizations, repos []rpmmd.Re
 		p.AddStage(osbuild.NewUsersStage(options))
 	}
 
-	if groups := c.GetGroups(); len(groups) > 0 {
-		p.AddStage(osbuild.NewGroupsStage(t.groupStageOptions(groups)))
-	}
-
 	if services := c.GetServices(); services != nil || t.imageType.enabledServices != nil {
 		p.AddStage(osbuild.NewSystemdStage(t.systemdStageOptions(t.imageType.enabledServices, t.imageType.disabledServices, services, t.imageType.defaultTarget)))
 	}
@@ -643,7 +678,7 @@ func (t *rhel82ImageType) pipeline(c *blueprint.Customizations, repos []rpmmd.Re
 
 	p.AddStage(osbuild.NewSELinuxStage(t.selinuxStageOptions()))
 
-	p.Assembler = t.imageType.assembler(t.arch.arch.uefi, size)
+	p.Assembler = assembler
 
 	return p, nil
 }
@@ -657,10 +692,10 @@ func (r *rhel82ImageType) buildPipeline(repos []rpmmd.RepoConfig, arch arch, bui
 func (r *rhel82ImageType) rpmStageOptions(arch arch, repos []rpmmd.RepoConfig, specs []rpmmd.PackageSpec) *osbuild.RPMStageOptions {
 	var gpgKeys []string
 	for _, repo := range repos {
-		if repo.GPGKey == "" {
-			continue
+		if repo.GPGKey != "" {
+			gpgKeys = append(gpgKeys, repo.GPGKey)
 		}
-		gpgKeys = append(gpgKeys, repo.GPGKey)
+		gpgKeys = append(gpgKeys, repo.GPGKeys...)
 	}
 
 	var packages []string
@@ -743,13 +778,21 @@ func (r *rhel82ImageType) firewallStageOptions(firewall *blueprint.FirewallCusto
 		options.DisabledServices = firewall.Services.Disabled
 	}
 
+	for _, zone := range firewall.Zones {
+		options.Zones = append(options.Zones, osbuild.FirewallZone{
+			Name:    zone.Name,
+			Sources: zone.Sources,
+		})
+	}
+
 	return &options
 }
 
 func (r *rhel82ImageType) systemdStageOptions(enabledServices, disabledServices []string, s *blueprint.ServicesCustomization, target string) *osbuild.SystemdStageOptions {
 	if s != nil {
-		enabledServices = append(enabledServices, s.Enabled...)
-		disabledServices = append(disabledServices, s.Disabled...)
+		// The customization overrides the defaults of the image type
+		enabledServices = append(without(enabledServices, s.Disabled), s.Enabled...)
+		disabledServices = append(without(disabledServices, s.Enabled), s.Disabled...)
 	}
 	return &osbuild.SystemdStageOptions{
 		EnabledServices:  enabledServices,
@@ -758,6 +801,55 @@ func (r *rhel82ImageType) systemdStageOptions(enabledServices, disabledServices
 	}
 }
 
+// without returns a copy of `list` without the strings in `remove`.
+func without(list, remove []string) []string {
+	removed := make(map[string]bool)
+	for _, s := range remove {
+		removed[s] = true
+	}
+
+	result := []string{}
+	for _, s := range list {
+		if !removed[s] {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// customizedAssembler creates the assembler of the image type and applies
+// the filesystem customizations to it. Mountpoints other than "/" get their
+// own partition, which is also added to `fstab`, unless it is nil.
+func (t *rhel82ImageType) customizedAssembler(filesystems []blueprint.FilesystemCustomization, fstab *osbuild.FSTabStageOptions, size uint64) (*osbuild.Assembler, error) {
+	assembler := t.imageType.assembler(t.arch.arch.uefi, size)
+	for _, fs := range filesystems {
+		switch options := assembler.Options.(type) {
+		case *osbuild.QEMUAssemblerOptions:
+			if fs.Mountpoint == "/" {
+				options.GrowRootPartition(fs.MinSize)
+				continue
+			}
+			id, err := options.AddPartition("xfs", fs.Mountpoint, fs.MinSize)
+			if err != nil {
+				return nil, fmt.Errorf("cannot add a partition for %s: %v", fs.Mountpoint, err)
+			}
+			if fstab != nil {
+				fstab.AddFilesystem(id, "xfs", fs.Mountpoint, "defaults", 0, 0)
+			}
+		case *osbuild.RawFSAssemblerOptions:
+			if fs.Mountpoint != "/" {
+				return nil, fmt.Errorf("%s images only have a root filesystem", t.imageType.name)
+			}
+			if options.Size < fs.MinSize {
+				options.Size = fs.MinSize
+			}
+		default:
+			return nil, fmt.Errorf("%s images do not support filesystem customizations", t.imageType.name)
+		}
+	}
+	return assembler, nil
+}
+
 func (r *rhel82ImageType) fsTabStageOptions(uefi bool) *osbuild.FSTabStageOptions {
 	options := osbuild.FSTabStageOptions{}
 	options.AddFilesystem("0bd700f8-090f-4556-b797-b340297ea1bd", "xfs", "/", "defaults", 0, 0)
@@ -767,9 +859,15 @@ func (r *rhel82ImageType) fsTabStageOptions(uefi bool) *osbuild.FSTabStageOption
 	return &options
 }
 
-func (r *rhel82ImageType) grub2StageOptions(kernelOptions string, uefi bool) *osbuild.GRUB2StageOptions {
+func (r *rhel82ImageType) grub2StageOptions(kernelOptions, kernelVer string, uefi bool) *osbuild.GRUB2StageOptions {
 	id := uuid.MustParse("0bd700f8-090f-4556-b797-b340297ea1bd")
 
+	// Boot the selected kernel by default, even if there are others
+	var savedEntry string
+	if kernelVer != "" {
+		savedEntry = "ffffffffffffffffffffffffffffffff-" + kernelVer
+	}
+
 	var uefiOptions *osbuild.GRUB2UEFI
 	if uefi {
 		uefiOptions = &osbuild.GRUB2UEFI{
@@ -782,6 +880,7 @@ func (r *rhel82ImageType) grub2StageOptions(kernelOptions string, uefi bool) *os
 		KernelOptions:      kernelOptions,
 		Legacy:             !uefi,
 		UEFI:               uefiOptions,
+		SavedEntry:         savedEntry,
 	}
 }
 
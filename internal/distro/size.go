@@ -0,0 +1,47 @@
+package distro
+
+import (
+	"github.com/osbuild/osbuild-composer/internal/osbuild"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+)
+
+// Filesystems need room for their metadata and installed systems for files
+// that are created at runtime. This is added to the installed size of the
+// packages, in percent.
+const filesystemOverhead = 20
+
+// MinimumImageSize estimates the smallest size an image built from
+// `manifest` can have to fit `packages` when installed. The manifest should
+// be created with the smallest size the image type accepts, so that its
+// assembler is only grown by filesystem customizations, which are taken
+// into account. Images without partitions or filesystems are estimated by
+// the size of their contents. The result is rounded up to whole MiB.
+func MinimumImageSize(manifest *osbuild.Manifest, packages []rpmmd.PackageSpec) uint64 {
+	var contents uint64
+	for _, p := range packages {
+		contents += p.InstallSize
+	}
+	contents += contents * filesystemOverhead / 100
+
+	size := contents
+	if manifest != nil && manifest.Pipeline.Assembler != nil {
+		switch options := manifest.Pipeline.Assembler.Options.(type) {
+		case *osbuild.QEMUAssemblerOptions:
+			// Everything in front of the root partition, which is
+			// the last one, is needed in addition to the contents
+			if n := len(options.Partitions); n > 0 {
+				size += options.Partitions[n-1].Start * 512
+			}
+			if options.Size > size {
+				size = options.Size
+			}
+		case *osbuild.RawFSAssemblerOptions:
+			if options.Size > size {
+				size = options.Size
+			}
+		}
+	}
+
+	const MiB = 1024 * 1024
+	return (size + MiB - 1) / MiB * MiB
+}
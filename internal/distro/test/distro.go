@@ -66,7 +66,7 @@ func (t *testImageType) BuildPackages() []string {
 	return nil
 }
 
-func (t *testImageType) Manifest(b *blueprint.Customizations, repos []rpmmd.RepoConfig, packageSpecs, buildPackageSpecs []rpmmd.PackageSpec, size uint64) (*osbuild.Manifest, error) {
+func (t *testImageType) Manifest(b *blueprint.Customizations, repos []rpmmd.RepoConfig, packageSpecs, buildPackageSpecs []rpmmd.PackageSpec, options distro.ImageOptions) (*osbuild.Manifest, error) {
 	return &osbuild.Manifest{
 		Sources:  osbuild.Sources{},
 		Pipeline: osbuild.Pipeline{},
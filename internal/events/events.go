@@ -0,0 +1,217 @@
+// Package events emits structured records of composer's activity, so that
+// log pipelines on the host can follow composes without polling the APIs.
+//
+// Like the standard library's log package, the package has a single global
+// emitter. It discards all events until a backend is set with SetEmitter(),
+// usually the journal.
+//
+// Each type of event has a MESSAGE_ID, which is documented in the journal
+// catalog shipped in distribution/osbuild-composer.catalog. The other fields
+// are named like journal fields (upper case, underscores), e.g., COMPOSE_ID.
+package events
+
+import (
+	"sync"
+
+	"github.com/coreos/go-systemd/journal"
+)
+
+// A Type identifies a kind of event. Its value is the event's MESSAGE_ID.
+type Type string
+
+const (
+	ComposeQueued     Type = "7f644669433741648b1b4cb7a0c12ddb"
+	ComposeDeleted    Type = "ae1c1592d70b4842b054730489424b11"
+	JobAssigned       Type = "1f316e3a8f5248b78148e561ae0b9575"
+	JobFinished       Type = "02c8f48e079a420899b52d13b19bb2d4"
+	JobFailed         Type = "a5d2de9e4c4a475595ed749f903380a4"
+	ImageUploaded     Type = "b9f45fc028f04d948dca609d68d7964d"
+	ImageUploadFailed Type = "edb5287c0530424185b75a7915ddb485"
+	AdmissionAllowed  Type = "4d0c4f3a9c2e4a7e8e0f5b1d7a6c2e91"
+	AdmissionDenied   Type = "c3b8e5a17f6d4f0c9a2b8d4e6f1a3c57"
+	WorkspaceExpiring Type = "8a5e2c71d3f94b06a1e7c9b4d2f60a83"
+	WorkspaceExpired  Type = "f17b9d4e2a6c4c58b3d0e8a5c9f21b64"
+)
+
+var names = map[Type]string{
+	ComposeQueued:     "compose-queued",
+	ComposeDeleted:    "compose-deleted",
+	JobAssigned:       "job-assigned",
+	JobFinished:       "job-finished",
+	JobFailed:         "job-failed",
+	ImageUploaded:     "image-uploaded",
+	ImageUploadFailed: "image-upload-failed",
+	AdmissionAllowed:  "admission-allowed",
+	AdmissionDenied:   "admission-denied",
+	WorkspaceExpiring: "workspace-expiring",
+	WorkspaceExpired:  "workspace-expired",
+}
+
+// String returns a short, human-readable name of the event type, which is
+// also emitted as COMPOSER_EVENT.
+func (t Type) String() string {
+	if name, exists := names[t]; exists {
+		return name
+	}
+	return string(t)
+}
+
+// An Event is a single record of something composer did.
+type Event struct {
+	Type    Type
+	Message string
+	Fields  map[string]string
+}
+
+// failed returns true for events that report a problem.
+func (e *Event) failed() bool {
+	return e.Type == JobFailed || e.Type == ImageUploadFailed || e.Type == AdmissionDenied
+}
+
+// An Emitter sends events to a backend.
+type Emitter interface {
+	Emit(event Event) error
+}
+
+var (
+	mu      sync.Mutex
+	emitter Emitter
+)
+
+// SetEmitter sets the backend for all events. Passing nil discards events.
+func SetEmitter(e Emitter) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	emitter = e
+}
+
+// Emit sends an event of type `t` to the current emitter. `fields` alternate
+// between field names and values. Errors are ignored, because events are
+// informational only.
+func Emit(t Type, message string, fields ...string) {
+	mu.Lock()
+	e := emitter
+	mu.Unlock()
+
+	if e == nil {
+		return
+	}
+
+	event := Event{
+		Type:    t,
+		Message: message,
+		Fields:  make(map[string]string),
+	}
+	for i := 0; i+1 < len(fields); i += 2 {
+		event.Fields[fields[i]] = fields[i+1]
+	}
+
+	_ = e.Emit(event)
+}
+
+// Multi returns an emitter that sends events to all `emitters`, skipping nil
+// ones. It returns the first error, but sends to all emitters regardless.
+func Multi(emitters ...Emitter) Emitter {
+	var m multiEmitter
+	for _, e := range emitters {
+		if e != nil {
+			m = append(m, e)
+		}
+	}
+	return m
+}
+
+type multiEmitter []Emitter
+
+func (m multiEmitter) Emit(event Event) error {
+	var firstErr error
+	for _, e := range m {
+		err := e.Emit(event)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// JournalEmitter sends events to the systemd journal.
+type JournalEmitter struct{}
+
+// NewJournalEmitter returns an emitter for the journal, or nil if the journal
+// is not available.
+func NewJournalEmitter() Emitter {
+	if !journal.Enabled() {
+		return nil
+	}
+	return JournalEmitter{}
+}
+
+func (JournalEmitter) Emit(event Event) error {
+	vars := map[string]string{
+		"MESSAGE_ID":     string(event.Type),
+		"COMPOSER_EVENT": event.Type.String(),
+	}
+	for name, value := range event.Fields {
+		vars[name] = value
+	}
+
+	priority := journal.PriInfo
+	if event.failed() {
+		priority = journal.PriWarning
+	}
+
+	return journal.Send(event.Message, priority, vars)
+}
+
+// The number of events a subscriber of a Broadcaster may fall behind before
+// it is dropped.
+const subscriberBacklog = 64
+
+// A Broadcaster is an emitter that passes events on to any number of
+// subscribers in the same process, e.g., clients of an API streaming them.
+type Broadcaster struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{subscribers: make(map[chan Event]struct{})}
+}
+
+// Subscribe returns a channel on which all events emitted from now on are
+// received, and a function to stop receiving them. Subscribers that don't
+// keep up are dropped: their channel is closed and events are lost.
+func (b *Broadcaster) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBacklog)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, exists := b.subscribers[ch]; exists {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (b *Broadcaster) Emit(event Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package events_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/events"
+)
+
+type recorder struct {
+	events []events.Event
+}
+
+func (r *recorder) Emit(event events.Event) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+func TestEmit(t *testing.T) {
+	// events are discarded without emitter
+	events.Emit(events.ComposeQueued, "dropped")
+
+	r := &recorder{}
+	events.SetEmitter(r)
+	defer events.SetEmitter(nil)
+
+	events.Emit(events.JobAssigned, "Job assigned", "JOB_ID", "42", "WORKER", "reef")
+	require.Equal(t, []events.Event{
+		{
+			Type:    events.JobAssigned,
+			Message: "Job assigned",
+			Fields:  map[string]string{"JOB_ID": "42", "WORKER": "reef"},
+		},
+	}, r.events)
+
+	require.Equal(t, "job-assigned", events.JobAssigned.String())
+}
+
+func TestMulti(t *testing.T) {
+	a := &recorder{}
+	b := &recorder{}
+	events.SetEmitter(events.Multi(a, nil, b))
+	defer events.SetEmitter(nil)
+
+	events.Emit(events.ComposeDeleted, "Compose deleted", "COMPOSE_ID", "42")
+	require.Len(t, a.events, 1)
+	require.Equal(t, a.events, b.events)
+}
+
+func TestBroadcaster(t *testing.T) {
+	b := events.NewBroadcaster()
+	events.SetEmitter(b)
+	defer events.SetEmitter(nil)
+
+	a, unsubscribeA := b.Subscribe()
+	slow, unsubscribeSlow := b.Subscribe()
+	defer unsubscribeSlow()
+
+	events.Emit(events.JobFinished, "Job finished", "JOB_ID", "42")
+	event := <-a
+	require.Equal(t, events.JobFinished, event.Type)
+	require.Equal(t, "42", event.Fields["JOB_ID"])
+
+	// unsubscribed channels are closed
+	unsubscribeA()
+	_, ok := <-a
+	require.False(t, ok)
+	unsubscribeA()
+
+	// subscribers that fall behind are dropped
+	for i := 0; i < 100; i++ {
+		events.Emit(events.JobFinished, "Job finished")
+	}
+	n := 0
+	for range slow {
+		n++
+	}
+	require.Less(t, n, 100)
+}
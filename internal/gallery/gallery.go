@@ -0,0 +1,186 @@
+// Package gallery provides a read-only HTTP API listing published images.
+//
+// Composes are not visible in the gallery unless they were explicitly
+// published through the Weldr API. The gallery does not authenticate clients
+// and is served on its own socket, so that it can be exposed separately.
+package gallery
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/store"
+)
+
+type Gallery struct {
+	logger *log.Logger
+	store  *store.Tenants
+	router *httprouter.Router
+}
+
+type image struct {
+	ID               uuid.UUID `json:"id"`
+	Blueprint        string    `json:"blueprint"`
+	BlueprintVersion string    `json:"blueprint_version"`
+	ImageType        string    `json:"image_type"`
+	Filename         string    `json:"filename"`
+	Size             int64     `json:"size"`
+	Checksum         string    `json:"checksum"`
+	PublishedAt      time.Time `json:"published_at"`
+	Download         string    `json:"download"`
+}
+
+// New returns a gallery of the images that any of `tenants` published.
+func New(logger *log.Logger, store *store.Tenants) *Gallery {
+	g := &Gallery{
+		logger: logger,
+		store:  store,
+	}
+
+	g.router = httprouter.New()
+	g.router.RedirectTrailingSlash = false
+	g.router.RedirectFixedPath = false
+	g.router.MethodNotAllowed = http.HandlerFunc(methodNotAllowedHandler)
+	g.router.NotFound = http.HandlerFunc(notFoundHandler)
+
+	g.router.GET("/v1/images", g.listHandler)
+	g.router.GET("/v1/images/:uuid", g.imageHandler)
+	g.router.GET("/v1/images/:uuid/download", g.downloadHandler)
+
+	return g
+}
+
+func (g *Gallery) Serve(listener net.Listener) error {
+	server := http.Server{Handler: g}
+
+	err := server.Serve(listener)
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+func (g *Gallery) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if g.logger != nil {
+		log.Println(request.Method, request.URL.Path)
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	g.router.ServeHTTP(writer, request)
+}
+
+func methodNotAllowedHandler(writer http.ResponseWriter, request *http.Request) {
+	common.NewAPIError(common.ErrorMethodNotAllowed, "method not allowed").WriteJSON(writer)
+}
+
+func notFoundHandler(writer http.ResponseWriter, request *http.Request) {
+	common.NewAPIError(common.ErrorNotFound, "not found").WriteJSON(writer)
+}
+
+func (g *Gallery) listHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	images := []image{}
+	for _, s := range g.store.All() {
+		for id, c := range s.GetAllComposes() {
+			if c.Publication != nil {
+				images = append(images, imageFromCompose(id, c))
+			}
+		}
+	}
+
+	// newest first
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].PublishedAt.After(images[j].PublishedAt)
+	})
+
+	err := json.NewEncoder(writer).Encode(struct {
+		Images []image `json:"images"`
+	}{images})
+	common.PanicOnError(err)
+}
+
+func (g *Gallery) imageHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	id, c, ok := g.publishedCompose(writer, params)
+	if !ok {
+		return
+	}
+
+	err := json.NewEncoder(writer).Encode(imageFromCompose(id, c))
+	common.PanicOnError(err)
+}
+
+func (g *Gallery) downloadHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	id, c, ok := g.publishedCompose(writer, params)
+	if !ok {
+		return
+	}
+
+	reader, size, err := g.store.GetImageBuildImage(id, 0)
+	if err != nil {
+		common.NewAPIError(common.ErrorArtifactNotFound, "image of %s is missing", id).WriteJSON(writer)
+		return
+	}
+	defer reader.Close()
+
+	img := imageFromCompose(id, c)
+	writer.Header().Set("Content-Type", "application/octet-stream")
+	writer.Header().Set("Content-Disposition", "attachment; filename="+id.String()+"-"+img.Filename)
+	writer.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	writer.Header().Set("Digest", img.Checksum)
+
+	_, _ = io.Copy(writer, reader)
+}
+
+// Returns the compose with the id in `params`, if it exists and is published.
+// Writes an error response and returns false otherwise.
+func (g *Gallery) publishedCompose(writer http.ResponseWriter, params httprouter.Params) (uuid.UUID, compose.Compose, bool) {
+	id, err := uuid.Parse(params.ByName("uuid"))
+	if err != nil {
+		common.NewAPIError(common.ErrorInvalidRequest, "invalid image id: %v", err).WriteJSON(writer)
+		return uuid.Nil, compose.Compose{}, false
+	}
+
+	c, exists := g.store.GetCompose(id)
+	if !exists || c.Publication == nil {
+		common.NewAPIError(common.ErrorNotFound, "image %s is not published", id).WriteJSON(writer)
+		return uuid.Nil, compose.Compose{}, false
+	}
+
+	return id, c, true
+}
+
+func imageFromCompose(id uuid.UUID, c compose.Compose) image {
+	img := image{
+		ID:          id,
+		Size:        c.Publication.Size,
+		Checksum:    c.Publication.Checksum,
+		PublishedAt: c.Publication.PublishedAt,
+		Download:    fmt.Sprintf("/v1/images/%s/download", id),
+	}
+
+	if c.Blueprint != nil {
+		img.Blueprint = c.Blueprint.Name
+		img.BlueprintVersion = c.Blueprint.Version
+	}
+
+	if len(c.ImageBuilds) > 0 {
+		img.ImageType, _ = c.ImageBuilds[0].ImageType.ToCompatString()
+		if options := c.ImageBuilds[0].GetLocalTargetOptions(); options != nil {
+			img.Filename = options.Filename
+		}
+	}
+
+	return img
+}
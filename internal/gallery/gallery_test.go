@@ -0,0 +1,69 @@
+package gallery_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/distro/fedoratest"
+	"github.com/osbuild/osbuild-composer/internal/gallery"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/target"
+	"github.com/osbuild/osbuild-composer/internal/test"
+)
+
+func TestGallery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gallery-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// the compose belongs to a tenant, which the runner has to find
+	tenants, err := store.NewTenants(store.New(&dir), nil)
+	require.NoError(t, err)
+	s, err := tenants.Get("web")
+	require.NoError(t, err)
+	g := gallery.New(nil, tenants)
+
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+
+	id := uuid.MustParse("30000000-0000-0000-0000-000000000000")
+	targets := []*target.Target{
+		target.NewLocalTarget(&target.LocalTargetOptions{Filename: imageType.Filename()}),
+	}
+	bp := &blueprint.Blueprint{Name: "gallery", Version: "0.0.1"}
+	err = s.PushTestCompose(id, nil, imageType, bp, 0, targets, nil, true)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(path.Join(dir, "tenants", "web", "outputs", id.String(), "0", imageType.Filename()), []byte("image"), 0600)
+	require.NoError(t, err)
+
+	// not published yet
+	test.TestRoute(t, g, false, "GET", "/v1/images", ``, http.StatusOK, `{"images":[]}`)
+	test.TestRoute(t, g, false, "GET", "/v1/images/"+id.String(), ``, http.StatusNotFound, `{"code":"NOT_FOUND"}`, "message")
+	test.TestRoute(t, g, false, "GET", "/v1/images/"+id.String()+"/download", ``, http.StatusNotFound, `{"code":"NOT_FOUND"}`, "message")
+
+	require.NoError(t, s.PublishCompose(id))
+
+	expected := `{"id":"` + id.String() + `","blueprint":"gallery","blueprint_version":"0.0.1","image_type":"qcow2","filename":"test.img","size":5,"checksum":"sha256:6105d6cc76af400325e94d588ce511be5bfdbb73b437dc51eca43917d7a43e3d","download":"/v1/images/` + id.String() + `/download"}`
+	test.TestRoute(t, g, false, "GET", "/v1/images", ``, http.StatusOK, `{"images":[`+expected+`]}`, "published_at")
+	test.TestRoute(t, g, false, "GET", "/v1/images/"+id.String(), ``, http.StatusOK, expected, "published_at")
+	test.TestRoute(t, g, false, "GET", "/v1/images/invalid", ``, http.StatusBadRequest, `{"code":"INVALID_REQUEST"}`, "message")
+
+	req := httptest.NewRequest("GET", "/v1/images/"+id.String()+"/download", nil)
+	resp := httptest.NewRecorder()
+	g.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "image", resp.Body.String())
+
+	require.NoError(t, s.UnpublishCompose(id))
+	test.TestRoute(t, g, false, "GET", "/v1/images", ``, http.StatusOK, `{"images":[]}`)
+}
@@ -0,0 +1,344 @@
+// Package gitrepo writes commits to a bare git repository.
+//
+// Only the small subset of git that is needed to keep a history of flat
+// directories of files is supported: loose blob, tree, and commit objects,
+// branches, and lightweight tags. The resulting repository can be read,
+// cloned, and diffed with regular git tools:
+//
+//	git clone /var/lib/osbuild-composer/blueprints.git
+//
+// The store commits to the blueprint history on every change of a
+// blueprint, while it holds its lock. This package is used instead of the
+// git command, so that these changes don't fork a process each and work
+// where git isn't installed; git is only needed for syncing blueprints from
+// remote repositories (see weldr's gitsync.go), which is optional and needs
+// git's transports. It is used instead of go-git, because writing loose
+// objects takes a few lines of code, while go-git would add a large tree of
+// dependencies to the vendor directory for features which aren't used.
+//
+// A repository without a directory only computes object ids. This is useful
+// for tests and for running without persistent state.
+package gitrepo
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The author and committer of all commits
+const signature = "osbuild-composer <osbuild-composer@localhost>"
+
+type Repository struct {
+	dir     string
+	refs    map[string]string
+	objects map[string]bool
+}
+
+// Open opens the bare git repository in `dir`, creating it if it doesn't
+// exist yet.
+func Open(dir string) (*Repository, error) {
+	_, err := os.Stat(filepath.Join(dir, "HEAD"))
+	if os.IsNotExist(err) {
+		err = initRepository(dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot open git repository %s: %v", dir, err)
+	}
+
+	return &Repository{dir: dir}, nil
+}
+
+// NewInMemory returns a repository that doesn't write any objects. Branches
+// and tags are only kept in memory.
+func NewInMemory() *Repository {
+	return &Repository{
+		refs:    make(map[string]string),
+		objects: make(map[string]bool),
+	}
+}
+
+func initRepository(dir string) error {
+	for _, d := range []string{"objects", "refs/heads", "refs/tags"} {
+		err := os.MkdirAll(filepath.Join(dir, d), 0700)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := ioutil.WriteFile(filepath.Join(dir, "config"), []byte("[core]\n\trepositoryformatversion = 0\n\tbare = true\n"), 0600)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, "HEAD"), []byte("ref: refs/heads/master\n"), 0600)
+}
+
+// ValidRefName returns true if `name` can be the name of a branch or tag. It
+// implements the rules of git-check-ref-format(1). Names may contain slashes
+// to group refs, like "octopus/r1".
+func ValidRefName(name string) bool {
+	if name == "" || name == "@" || strings.HasSuffix(name, ".") || strings.Contains(name, "..") || strings.Contains(name, "@{") {
+		return false
+	}
+	for _, component := range strings.Split(name, "/") {
+		if component == "" || strings.HasPrefix(component, ".") || strings.HasSuffix(component, ".lock") {
+			return false
+		}
+	}
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f || strings.ContainsRune(" ~^:?*[\\", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidFileName returns true if `name` can be the name of a file in a
+// commit. Files cannot be in subdirectories, so names must not contain
+// slashes.
+func ValidFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && name != ".git" && !strings.ContainsAny(name, "/\x00")
+}
+
+// Head returns the id of the latest commit on `branch`, or an empty string if
+// the branch doesn't exist.
+func (r *Repository) Head(branch string) (string, error) {
+	if !ValidRefName(branch) {
+		return "", fmt.Errorf("invalid branch name: %q", branch)
+	}
+	return r.readRef("refs/heads/" + branch)
+}
+
+// Branches returns the names of all branches, sorted.
+func (r *Repository) Branches() ([]string, error) {
+	return r.listRefs("refs/heads/")
+}
+
+// Tags returns the names of all tags, sorted.
+func (r *Repository) Tags() ([]string, error) {
+	return r.listRefs("refs/tags/")
+}
+
+// Commit adds a commit to `branch` (creating it, if necessary) with exactly
+// the files in `files`, which maps file names to their content. File names
+// must be valid (see ValidFileName). Nothing is written if any of the names
+// is invalid. It returns the id of the new commit.
+func (r *Repository) Commit(branch string, files map[string][]byte, message string, when time.Time) (string, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		if !ValidFileName(name) {
+			return "", fmt.Errorf("invalid file name: %q", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parent, err := r.Head(branch)
+	if err != nil {
+		return "", err
+	}
+
+	var tree bytes.Buffer
+	for _, name := range names {
+		blob, err := r.writeObject("blob", files[name])
+		if err != nil {
+			return "", err
+		}
+		raw, _ := hex.DecodeString(blob)
+		fmt.Fprintf(&tree, "100644 %s\x00", name)
+		tree.Write(raw)
+	}
+	treeId, err := r.writeObject("tree", tree.Bytes())
+	if err != nil {
+		return "", err
+	}
+
+	var commit bytes.Buffer
+	fmt.Fprintf(&commit, "tree %s\n", treeId)
+	if parent != "" {
+		fmt.Fprintf(&commit, "parent %s\n", parent)
+	}
+	timestamp := fmt.Sprintf("%d +0000", when.Unix())
+	fmt.Fprintf(&commit, "author %s %s\n", signature, timestamp)
+	fmt.Fprintf(&commit, "committer %s %s\n", signature, timestamp)
+	fmt.Fprintf(&commit, "\n%s\n", strings.TrimRight(message, "\n"))
+
+	commitId, err := r.writeObject("commit", commit.Bytes())
+	if err != nil {
+		return "", err
+	}
+
+	err = r.writeRef("refs/heads/"+branch, commitId)
+	if err != nil {
+		return "", err
+	}
+
+	return commitId, nil
+}
+
+// HasObject returns true if an object with `id` was written to the
+// repository.
+func (r *Repository) HasObject(id string) bool {
+	if len(id) != 2*sha1.Size {
+		return false
+	}
+	if r.dir == "" {
+		return r.objects[id]
+	}
+	_, err := os.Stat(filepath.Join(r.dir, "objects", id[:2], id[2:]))
+	return err == nil
+}
+
+// Tag points the lightweight tag `name` at `commit`. Existing tags are moved.
+func (r *Repository) Tag(name, commit string) error {
+	if !ValidRefName(name) {
+		return fmt.Errorf("invalid tag name: %q", name)
+	}
+	if len(commit) != 2*sha1.Size {
+		return fmt.Errorf("invalid commit id: %s", commit)
+	}
+	return r.writeRef("refs/tags/"+name, commit)
+}
+
+// writeObject stores an object of type `kind` and returns its id.
+func (r *Repository) writeObject(kind string, content []byte) (string, error) {
+	var object bytes.Buffer
+	fmt.Fprintf(&object, "%s %d\x00", kind, len(content))
+	object.Write(content)
+
+	sum := sha1.Sum(object.Bytes())
+	id := hex.EncodeToString(sum[:])
+
+	if r.dir == "" {
+		r.objects[id] = true
+		return id, nil
+	}
+
+	p := filepath.Join(r.dir, "objects", id[:2], id[2:])
+	if _, err := os.Stat(p); err == nil {
+		return id, nil
+	}
+
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	_, err := w.Write(object.Bytes())
+	if err != nil {
+		return "", err
+	}
+	err = w.Close()
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(filepath.Dir(p), 0700)
+	if err != nil {
+		return "", err
+	}
+
+	return id, writeFileAtomically(p, compressed.Bytes(), 0400)
+}
+
+func (r *Repository) readRef(ref string) (string, error) {
+	if r.dir == "" {
+		return r.refs[ref], nil
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(r.dir, filepath.FromSlash(ref)))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	id := strings.TrimSpace(string(data))
+	if len(id) != 2*sha1.Size {
+		return "", errors.New("corrupt ref: " + ref)
+	}
+	return id, nil
+}
+
+// listRefs returns the names of all refs below `prefix`, without the
+// prefix.
+func (r *Repository) listRefs(prefix string) ([]string, error) {
+	names := []string{}
+	if r.dir == "" {
+		for ref := range r.refs {
+			if strings.HasPrefix(ref, prefix) {
+				names = append(names, strings.TrimPrefix(ref, prefix))
+			}
+		}
+		sort.Strings(names)
+		return names, nil
+	}
+
+	root := filepath.Join(r.dir, filepath.FromSlash(prefix))
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".tmp-") {
+			return nil
+		}
+		name, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(name))
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+func (r *Repository) writeRef(ref, id string) error {
+	if r.dir == "" {
+		r.refs[ref] = id
+		return nil
+	}
+
+	p := filepath.Join(r.dir, filepath.FromSlash(ref))
+	err := os.MkdirAll(filepath.Dir(p), 0700)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomically(p, []byte(id+"\n"), 0600)
+}
+
+func writeFileAtomically(p string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(p), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(f.Name(), perm)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), p)
+}
@@ -0,0 +1,117 @@
+package gitrepo
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemory(t *testing.T) {
+	r := NewInMemory()
+
+	head, err := r.Head("master")
+	require.NoError(t, err)
+	require.Empty(t, head)
+
+	when := time.Unix(1588000000, 0)
+	first, err := r.Commit("master", map[string][]byte{"a.toml": []byte("name = \"a\"\n")}, "first", when)
+	require.NoError(t, err)
+	require.Len(t, first, 40)
+	require.True(t, r.HasObject(first))
+	require.False(t, r.HasObject("0123456789abcdef0123456789abcdef01234567"))
+
+	// commits are content-addressed
+	other, err := NewInMemory().Commit("master", map[string][]byte{"a.toml": []byte("name = \"a\"\n")}, "first", when)
+	require.NoError(t, err)
+	require.Equal(t, first, other)
+
+	second, err := r.Commit("master", map[string][]byte{"a.toml": []byte("name = \"a\"\n")}, "first", when)
+	require.NoError(t, err)
+	require.NotEqual(t, first, second)
+
+	head, err = r.Head("master")
+	require.NoError(t, err)
+	require.Equal(t, second, head)
+
+	_, err = r.Commit("master", map[string][]byte{"../a.toml": nil}, "invalid", when)
+	require.Error(t, err)
+
+	// invalid names are rejected before anything is written
+	_, err = r.Commit("../other", map[string][]byte{"a.toml": nil}, "invalid", when)
+	require.Error(t, err)
+	require.Error(t, r.Tag("../a/r1", second))
+	require.NoError(t, r.Tag("a/r1", second))
+
+	branches, err := r.Branches()
+	require.NoError(t, err)
+	require.Equal(t, []string{"master"}, branches)
+	tags, err := r.Tags()
+	require.NoError(t, err)
+	require.Equal(t, []string{"a/r1"}, tags)
+}
+
+func TestValidNames(t *testing.T) {
+	for _, name := range []string{"master", "a/r1", "my-blueprint", "a.b"} {
+		require.True(t, ValidRefName(name), name)
+	}
+	for _, name := range []string{"", "@", "/a", "a/", "a//b", "../a", "a/.b", "a..b", "a.", "a.lock", "a b", "a:b", "a@{1}", "a\\b", "a\x00b"} {
+		require.False(t, ValidRefName(name), name)
+	}
+
+	require.True(t, ValidFileName("a.toml"))
+	for _, name := range []string{"", ".", "..", ".git", "a/b.toml", "a\x00b"} {
+		require.False(t, ValidFileName(name), name)
+	}
+}
+
+func TestReadableByGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	r, err := Open(dir)
+	require.NoError(t, err)
+
+	when := time.Now()
+	_, err = r.Commit("master", map[string][]byte{"a.toml": []byte("one\n"), "b.toml": []byte("two\n")}, "Add a and b", when)
+	require.NoError(t, err)
+	second, err := r.Commit("master", map[string][]byte{"a.toml": []byte("three\n")}, "Change a, remove b", when)
+	require.NoError(t, err)
+	require.NoError(t, r.Tag("a/r1", second))
+
+	// reopening keeps the history
+	r, err = Open(dir)
+	require.NoError(t, err)
+	head, err := r.Head("master")
+	require.NoError(t, err)
+	require.Equal(t, second, head)
+	_, err = r.Commit("other", map[string][]byte{"a.toml": []byte("one\n")}, "Add a", when)
+	require.NoError(t, err)
+	branches, err := r.Branches()
+	require.NoError(t, err)
+	require.Equal(t, []string{"master", "other"}, branches)
+	tags, err := r.Tags()
+	require.NoError(t, err)
+	require.Equal(t, []string{"a/r1"}, tags)
+
+	git := func(args ...string) string {
+		out, err := exec.Command("git", append([]string{"--git-dir", dir}, args...)...).CombinedOutput()
+		require.NoError(t, err, string(out))
+		return string(out)
+	}
+
+	git("fsck", "--strict")
+	require.Equal(t, "three\n", git("show", "master:a.toml"))
+	require.Equal(t, "one\n", git("show", "master~1:a.toml"))
+	require.Equal(t, second, strings.TrimSpace(git("rev-parse", "a/r1")))
+	require.Contains(t, git("diff", "master~1", "master"), "deleted file mode 100644")
+}
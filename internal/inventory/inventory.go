@@ -0,0 +1,316 @@
+// Package inventory exports records of finished composes to external
+// inventory systems, like a CMDB.
+//
+// Records are pushed to a webhook as JSON. The fields of the JSON object are
+// configurable with a mapping, so that records fit the schema the inventory
+// system expects. Exports that fail are retried with exponential backoff and
+// the state of each compose's export is kept in the store.
+package inventory
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/osbuild"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+// A Record describes the image of a finished compose.
+type Record struct {
+	ImageID          uuid.UUID  `json:"image_id"`
+	Blueprint        string     `json:"blueprint"`
+	BlueprintVersion string     `json:"blueprint_version"`
+	ImageType        string     `json:"image_type"`
+	PackageDigest    string     `json:"package_digest"`
+	Targets          []string   `json:"targets"`
+	Provenance       Provenance `json:"provenance"`
+
+	// Module streams that were enabled when the image's packages were
+	// resolved, as "name:stream"
+	Modules []string `json:"modules,omitempty"`
+
+	// Registration is set for images that were built elsewhere
+	Registration *compose.Registration `json:"registration,omitempty"`
+}
+
+// Provenance describes where and when an image was built.
+type Provenance struct {
+	Builder    string    `json:"builder"`
+	JobID      uuid.UUID `json:"job_id"`
+	QueuedAt   time.Time `json:"queued_at"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+
+	// The workers which uploaded the image and finished its job, if they
+	// were recorded
+	UploadedBy *compose.WorkerIdentity `json:"uploaded_by,omitempty"`
+	FinishedBy *compose.WorkerIdentity `json:"finished_by,omitempty"`
+}
+
+// NewRecord creates the record of compose `id`. `builder` identifies the
+// composer instance that built it.
+func NewRecord(id uuid.UUID, c compose.Compose, builder string) Record {
+	record := Record{
+		ImageID: id,
+		Targets: []string{},
+		Provenance: Provenance{
+			Builder: builder,
+		},
+		Registration: c.Registration,
+	}
+
+	if c.Blueprint != nil {
+		record.Blueprint = c.Blueprint.Name
+		record.BlueprintVersion = c.Blueprint.Version
+		record.Modules = c.Blueprint.GetEnabledModules()
+	}
+
+	if len(c.ImageBuilds) > 0 {
+		ib := c.ImageBuilds[0]
+		record.ImageType, _ = ib.ImageType.ToCompatString()
+		record.PackageDigest = packageDigest(ib.Manifest)
+		for _, t := range ib.Targets {
+			record.Targets = append(record.Targets, t.Name)
+		}
+		record.Provenance.JobID = ib.JobId
+		record.Provenance.UploadedBy = ib.UploadedBy
+		record.Provenance.FinishedBy = ib.FinishedBy
+	}
+
+	return record
+}
+
+// packageDigest returns a digest of all packages that went into an image.
+// Packages are identified by their checksum in the manifest's sources, so the
+// digest is independent of the order in which they were installed.
+func packageDigest(manifest *osbuild.Manifest) string {
+	if manifest == nil {
+		return ""
+	}
+
+	var checksums []string
+	if files, ok := manifest.Sources["org.osbuild.files"].(*osbuild.FilesSource); ok {
+		for checksum := range files.URLs {
+			checksums = append(checksums, checksum)
+		}
+	}
+	sort.Strings(checksums)
+
+	h := sha256.New()
+	for _, checksum := range checksums {
+		fmt.Fprintln(h, checksum)
+	}
+
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// An Exporter pushes records to an inventory system.
+type Exporter interface {
+	Export(record Record) error
+}
+
+// A WebhookExporter posts records as JSON objects to a URL.
+type WebhookExporter struct {
+	url     string
+	mapping map[string]string
+	client  *http.Client
+}
+
+// NewWebhookExporter creates an exporter that posts to `url`. `mapping` maps
+// the names of fields in the posted object to the names of fields in Record
+// (as they appear in its JSON representation). Fields of Provenance are
+// prefixed with "provenance.". A nil mapping posts records unchanged.
+func NewWebhookExporter(url string, mapping map[string]string) (*WebhookExporter, error) {
+	known, err := recordFields(Record{})
+	if err != nil {
+		return nil, err
+	}
+
+	for name, field := range mapping {
+		if _, exists := known[field]; !exists {
+			return nil, fmt.Errorf("mapping for %s refers to unknown field %s", name, field)
+		}
+	}
+
+	return &WebhookExporter{
+		url:     url,
+		mapping: mapping,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// LoadMapping reads a mapping for NewWebhookExporter from a JSON file.
+func LoadMapping(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mapping map[string]string
+	err = json.NewDecoder(f).Decode(&mapping)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %v", path, err)
+	}
+
+	return mapping, nil
+}
+
+// recordFields flattens the JSON representation of `record` into a map.
+func recordFields(record Record) (map[string]interface{}, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]interface{}
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	if provenance, ok := fields["provenance"].(map[string]interface{}); ok {
+		for name, value := range provenance {
+			fields["provenance."+name] = value
+		}
+	}
+
+	return fields, nil
+}
+
+func (e *WebhookExporter) Export(record Record) error {
+	var body interface{} = record
+	if e.mapping != nil {
+		fields, err := recordFields(record)
+		if err != nil {
+			return err
+		}
+
+		mapped := make(map[string]interface{})
+		for name, field := range e.mapping {
+			mapped[name] = fields[field]
+		}
+		body = mapped
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	response, err := e.client.Post(e.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("inventory returned %d: %s", response.StatusCode, bytes.TrimSpace(message))
+	}
+
+	return nil
+}
+
+const (
+	// How often an export is attempted before giving up
+	maxAttempts = 10
+
+	// Delay before the first retry, doubled for each retry after that
+	initialBackoff = time.Minute
+	maxBackoff     = 6 * time.Hour
+)
+
+// A Pusher exports all composes in a store that have finished successfully
+// and haven't been exported yet.
+type Pusher struct {
+	exporter Exporter
+	store    *store.Store
+	workers  *worker.Server
+	builder  string
+}
+
+func NewPusher(exporter Exporter, store *store.Store, workers *worker.Server, builder string) *Pusher {
+	return &Pusher{exporter, store, workers, builder}
+}
+
+// Push exports all composes that are due for export. It is meant to be called
+// periodically.
+func (p *Pusher) Push() error {
+	now := time.Now()
+
+	for id, c := range p.store.GetAllComposes() {
+		export := compose.InventoryExport{}
+		if c.InventoryExport != nil {
+			export = *c.InventoryExport
+		}
+
+		if !export.ExportedAt.IsZero() || export.Attempts >= maxAttempts || now.Before(export.NextAttempt) {
+			continue
+		}
+
+		// Composes from before the job queue existed are never exported
+		if len(c.ImageBuilds) == 0 || c.ImageBuilds[0].JobId == uuid.Nil {
+			continue
+		}
+
+		state, queued, started, finished := p.workers.ComposeState(c)
+		if state != common.CFinished {
+			continue
+		}
+
+		record := NewRecord(id, c, p.builder)
+		record.Provenance.QueuedAt = queued
+		record.Provenance.StartedAt = started
+		record.Provenance.FinishedAt = finished
+
+		export.Attempts++
+		err := p.exporter.Export(record)
+		if err == nil {
+			export.ExportedAt = time.Now()
+			export.LastError = ""
+			export.NextAttempt = time.Time{}
+		} else {
+			export.LastError = err.Error()
+			export.NextAttempt = now.Add(backoff(export.Attempts))
+		}
+
+		err = p.store.SetInventoryExport(id, export)
+		if err != nil {
+			// the compose was deleted in the meantime
+			if _, ok := err.(*store.NotFoundError); ok {
+				continue
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// backoff returns how long to wait after the `attempts`th failed export.
+func backoff(attempts int) time.Duration {
+	delay := initialBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+
+	return delay
+}
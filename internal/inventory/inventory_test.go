@@ -0,0 +1,120 @@
+package inventory_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/distro"
+	"github.com/osbuild/osbuild-composer/internal/distro/fedoratest"
+	"github.com/osbuild/osbuild-composer/internal/inventory"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue/testjobqueue"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+func TestWebhookExporter(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer server.Close()
+
+	_, err := inventory.NewWebhookExporter(server.URL, map[string]string{"u_name": "bogus"})
+	require.Error(t, err)
+
+	exporter, err := inventory.NewWebhookExporter(server.URL, map[string]string{
+		"u_name":    "blueprint",
+		"u_digest":  "package_digest",
+		"u_builder": "provenance.builder",
+	})
+	require.NoError(t, err)
+
+	record := inventory.Record{
+		ImageID:       uuid.New(),
+		Blueprint:     "octopus",
+		PackageDigest: "sha256:0",
+		Provenance:    inventory.Provenance{Builder: "reef"},
+	}
+	require.NoError(t, exporter.Export(record))
+	require.Equal(t, map[string]interface{}{
+		"u_name":    "octopus",
+		"u_digest":  "sha256:0",
+		"u_builder": "reef",
+	}, received)
+}
+
+type fakeExporter struct {
+	err     error
+	records []inventory.Record
+}
+
+func (e *fakeExporter) Export(record inventory.Record) error {
+	e.records = append(e.records, record)
+	return e.err
+}
+
+func TestPusher(t *testing.T) {
+	s := store.New(nil)
+	jobs := testjobqueue.New()
+	workers := worker.NewServer(nil, jobs, nil, "")
+
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+	manifest, err := imageType.Manifest(nil, nil, nil, nil, distro.ImageOptions{Size: imageType.Size(0)})
+	require.NoError(t, err)
+
+	jobId, err := workers.Enqueue("fedoratest", "x86_64", manifest, nil, nil, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+	id := uuid.New()
+	bp := &blueprint.Blueprint{
+		Name:           "octopus",
+		Version:        "0.0.1",
+		EnabledModules: []blueprint.EnabledModule{{Name: "nodejs", Stream: "14"}},
+	}
+	err = s.PushCompose(id, manifest, imageType, bp, 0, nil, nil, jobId)
+	require.NoError(t, err)
+
+	exporter := &fakeExporter{err: errors.New("inventory is down")}
+	pusher := inventory.NewPusher(exporter, s, workers, "reef")
+
+	// the compose hasn't finished yet
+	require.NoError(t, pusher.Push())
+	require.Empty(t, exporter.records)
+
+	_, err = jobs.Dequeue(context.Background(), []string{"osbuild:x86_64/fedoratest"}, &worker.OSBuildJob{})
+	require.NoError(t, err)
+	err = jobs.FinishJob(jobId, worker.OSBuildJobResult{OSBuildOutput: &common.ComposeResult{Success: true}})
+	require.NoError(t, err)
+
+	require.NoError(t, pusher.Push())
+	require.Len(t, exporter.records, 1)
+	require.Equal(t, id, exporter.records[0].ImageID)
+	require.Equal(t, "octopus", exporter.records[0].Blueprint)
+	require.Equal(t, "reef", exporter.records[0].Provenance.Builder)
+	require.Equal(t, jobId, exporter.records[0].Provenance.JobID)
+	require.Equal(t, []string{"nodejs:14"}, exporter.records[0].Modules)
+
+	c, _ := s.GetCompose(id)
+	require.NotNil(t, c.InventoryExport)
+	require.Equal(t, 1, c.InventoryExport.Attempts)
+	require.Equal(t, "inventory is down", c.InventoryExport.LastError)
+	require.True(t, c.InventoryExport.ExportedAt.IsZero())
+
+	// retries are delayed
+	exporter.err = nil
+	require.NoError(t, pusher.Push())
+	require.Len(t, exporter.records, 1)
+}
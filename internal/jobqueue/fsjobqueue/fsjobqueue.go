@@ -12,10 +12,10 @@
 package fsjobqueue
 
 import (
+	"container/heap"
 	"context"
 	"encoding/json"
 	"fmt"
-	"reflect"
 	"sort"
 	"sync"
 	"time"
@@ -28,17 +28,30 @@ import (
 type fsJobQueue struct {
 	db *jsondb.JSONDatabase
 
-	// Maps job types to channels of job ids for that type. Only access
-	// through pendingChannel() to ensure concurrent access is restricted
-	// by the mutex.
-	pending      map[string]chan uuid.UUID
-	pendingMutex sync.Mutex
+	// Maps job types to the pending jobs of that type, ordered by
+	// priority. `pendingChanged` is closed (and replaced) whenever a job
+	// is added, to wake up waiting Dequeue() calls. Only access through
+	// pushPending(), popPending(), and pendingPositions() to ensure
+	// concurrent access is restricted by the mutex.
+	pending        map[string]*pendingHeap
+	pendingSeq     uint64
+	pendingChanged chan struct{}
+	pendingMutex   sync.Mutex
 
 	// Maps job ids to the jobs that depend on it, if any of those
 	// dependants have not yet finished. Only acccess while holding the
 	// associated mutex.
 	dependants      map[uuid.UUID][]uuid.UUID
 	dependantsMutex sync.Mutex
+
+	// Summaries of all jobs, so that they can be listed without reading
+	// each of them. Only access through indexJob() and listSummaries().
+	summaries      map[uuid.UUID]jobqueue.JobSummary
+	summariesMutex sync.Mutex
+
+	// Held while running jobs are updated, so that recording progress
+	// never overwrites a job that finished in the meantime.
+	runningMutex sync.Mutex
 }
 
 // On-disk job struct. Contains all necessary (but non-redundant) information
@@ -49,7 +62,9 @@ type job struct {
 	Type         string          `json:"type"`
 	Args         json.RawMessage `json:"args,omitempty"`
 	Dependencies []uuid.UUID     `json:"dependencies"`
+	Priority     int             `json:"priority,omitempty"`
 	Result       json.RawMessage `json:"result,omitempty"`
+	Progress     json.RawMessage `json:"progress,omitempty"`
 
 	Status     jobqueue.JobStatus `json:"status"`
 	QueuedAt   time.Time          `json:"queued-at,omitempty"`
@@ -62,9 +77,11 @@ type job struct {
 // loaded and rescheduled to run if necessary.
 func New(dir string) (*fsJobQueue, error) {
 	q := &fsJobQueue{
-		db:         jsondb.New(dir, 0600),
-		pending:    make(map[string]chan uuid.UUID),
-		dependants: make(map[uuid.UUID][]uuid.UUID),
+		db:             jsondb.New(dir, 0600),
+		pending:        make(map[string]*pendingHeap),
+		pendingChanged: make(chan struct{}),
+		dependants:     make(map[uuid.UUID][]uuid.UUID),
+		summaries:      make(map[uuid.UUID]jobqueue.JobSummary),
 	}
 
 	// Look for jobs that are still pending and build the dependant map.
@@ -81,13 +98,13 @@ func New(dir string) (*fsJobQueue, error) {
 		if err != nil {
 			return nil, err
 		}
+		q.indexJob(j)
 		// We only enqueue jobs that were previously pending.
 		if j.Status != jobqueue.JobPending {
 			continue
 		}
-		// Initialize dependants for this job.
-		q.dependantsMutex.Lock()
-		defer q.dependantsMutex.Unlock()
+		// Initialize dependants for this job. No other goroutine can
+		// access `q` yet, so there's no need to hold the mutex.
 		for _, dep := range j.Dependencies {
 			q.dependants[dep] = append(q.dependants[dep], j.Id)
 		}
@@ -98,18 +115,19 @@ func New(dir string) (*fsJobQueue, error) {
 			return nil, err
 		}
 		if n == len(j.Dependencies) {
-			q.pendingChannel(j.Type) <- j.Id
+			q.pushPending(j)
 		}
 	}
 
 	return q, nil
 }
 
-func (q *fsJobQueue) Enqueue(jobType string, args interface{}, dependencies []uuid.UUID) (uuid.UUID, error) {
+func (q *fsJobQueue) Enqueue(jobType string, args interface{}, dependencies []uuid.UUID, priority int) (uuid.UUID, error) {
 	var j = job{
 		Id:           uuid.New(),
 		Type:         jobType,
 		Dependencies: uniqueUUIDList(dependencies),
+		Priority:     priority,
 		Status:       jobqueue.JobPending,
 		QueuedAt:     time.Now(),
 	}
@@ -132,12 +150,13 @@ func (q *fsJobQueue) Enqueue(jobType string, args interface{}, dependencies []uu
 	if err != nil {
 		return uuid.Nil, fmt.Errorf("cannot write job: %v:", err)
 	}
+	q.indexJob(&j)
 
 	// If all dependencies have finished, or there are none, queue the job.
 	// Otherwise, update dependants so that this check is done again when
 	// FinishJob() is called for a dependency.
 	if finished == len(j.Dependencies) {
-		q.pendingChannel(j.Type) <- j.Id
+		q.pushPending(&j)
 	} else {
 		q.dependantsMutex.Lock()
 		defer q.dependantsMutex.Unlock()
@@ -155,30 +174,21 @@ func (q *fsJobQueue) Dequeue(ctx context.Context, jobTypes []string, args interf
 		return uuid.Nil, err
 	}
 
-	// Use reflect.Select(), because the `select` statement cannot operate
-	// on an unknown amount of channels.
-	cases := []reflect.SelectCase{
-		{
-			Dir:  reflect.SelectRecv,
-			Chan: reflect.ValueOf(ctx.Done()),
-		},
-	}
-	for _, jt := range jobTypes {
-		cases = append(cases, reflect.SelectCase{
-			Dir:  reflect.SelectRecv,
-			Chan: reflect.ValueOf(q.pendingChannel(jt)),
-		})
-	}
+	var id uuid.UUID
+	for {
+		var changed chan struct{}
+		var ok bool
+		id, changed, ok = q.popPending(jobTypes)
+		if ok {
+			break
+		}
 
-	chosen, value, recvOK := reflect.Select(cases)
-	if chosen == 0 && !recvOK {
-		return uuid.Nil, ctx.Err()
-	}
-	// pending channels are never closed
-	if !recvOK {
-		panic("pending channel was closed unexpectedly")
+		select {
+		case <-ctx.Done():
+			return uuid.Nil, ctx.Err()
+		case <-changed:
+		}
 	}
-	id := value.Interface().(uuid.UUID)
 
 	j, err := q.readJob(id)
 	if err != nil {
@@ -197,11 +207,15 @@ func (q *fsJobQueue) Dequeue(ctx context.Context, jobTypes []string, args interf
 	if err != nil {
 		return uuid.Nil, fmt.Errorf("error writing job %s: %v", id, err)
 	}
+	q.indexJob(j)
 
 	return j.Id, nil
 }
 
 func (q *fsJobQueue) FinishJob(id uuid.UUID, result interface{}) error {
+	q.runningMutex.Lock()
+	defer q.runningMutex.Unlock()
+
 	j, err := q.readJob(id)
 	if err != nil {
 		return err
@@ -213,6 +227,7 @@ func (q *fsJobQueue) FinishJob(id uuid.UUID, result interface{}) error {
 
 	j.Status = jobqueue.JobFinished
 	j.FinishedAt = time.Now()
+	j.Progress = nil
 
 	j.Result, err = json.Marshal(result)
 	if err != nil {
@@ -224,6 +239,7 @@ func (q *fsJobQueue) FinishJob(id uuid.UUID, result interface{}) error {
 	if err != nil {
 		return fmt.Errorf("error writing job %s: %v", id, err)
 	}
+	q.indexJob(j)
 
 	q.dependantsMutex.Lock()
 	defer q.dependantsMutex.Unlock()
@@ -237,7 +253,7 @@ func (q *fsJobQueue) FinishJob(id uuid.UUID, result interface{}) error {
 			return err
 		}
 		if n == len(dep.Dependencies) {
-			q.pendingChannel(dep.Type) <- dep.Id
+			q.pushPending(dep)
 		}
 	}
 	delete(q.dependants, id)
@@ -245,6 +261,104 @@ func (q *fsJobQueue) FinishJob(id uuid.UUID, result interface{}) error {
 	return nil
 }
 
+func (q *fsJobQueue) CancelJob(id uuid.UUID) error {
+	j, err := q.readJob(id)
+	if err != nil {
+		return err
+	}
+
+	if j.Status != jobqueue.JobPending {
+		return jobqueue.ErrNotPending
+	}
+
+	// Holding the mutex keeps FinishJob() from making the job ready to
+	// run while it is canceled.
+	q.dependantsMutex.Lock()
+	defer q.dependantsMutex.Unlock()
+
+	if len(q.dependants[id]) > 0 {
+		return jobqueue.ErrDependants
+	}
+
+	// The job is either waiting for its dependencies or ready to run. If
+	// it is neither, a worker has just dequeued it.
+	waiting := false
+	for _, dep := range j.Dependencies {
+		for i, depid := range q.dependants[dep] {
+			if depid == id {
+				q.dependants[dep] = append(q.dependants[dep][:i], q.dependants[dep][i+1:]...)
+				if len(q.dependants[dep]) == 0 {
+					delete(q.dependants, dep)
+				}
+				waiting = true
+				break
+			}
+		}
+	}
+	if !waiting && !q.removePending(j) {
+		return jobqueue.ErrNotPending
+	}
+
+	err = q.db.Delete(id.String())
+	if err != nil {
+		return err
+	}
+
+	q.summariesMutex.Lock()
+	defer q.summariesMutex.Unlock()
+	delete(q.summaries, id)
+
+	return nil
+}
+
+func (q *fsJobQueue) SetJobProgress(id uuid.UUID, progress interface{}) error {
+	q.runningMutex.Lock()
+	defer q.runningMutex.Unlock()
+
+	j, err := q.readJob(id)
+	if err != nil {
+		return err
+	}
+
+	if j.Status != jobqueue.JobRunning {
+		return jobqueue.ErrNotRunning
+	}
+
+	j.Progress, err = json.Marshal(progress)
+	if err != nil {
+		return fmt.Errorf("error marshaling progress: %v", err)
+	}
+
+	err = q.db.Write(id.String(), j)
+	if err != nil {
+		return fmt.Errorf("error writing job %s: %v", id, err)
+	}
+
+	return nil
+}
+
+func (q *fsJobQueue) JobProgress(id uuid.UUID, progress interface{}) error {
+	j, err := q.readJob(id)
+	if err != nil {
+		return err
+	}
+
+	if j.Status != jobqueue.JobRunning {
+		return jobqueue.ErrNotRunning
+	}
+
+	if j.Progress == nil {
+		return nil
+	}
+
+	err = json.Unmarshal(j.Progress, progress)
+	if err != nil {
+		return fmt.Errorf("error unmarshaling progress for job '%s': %v", id, err)
+	}
+
+	return nil
+}
+
 func (q *fsJobQueue) JobStatus(id uuid.UUID, result interface{}) (status jobqueue.JobStatus, queued, started, finished time.Time, err error) {
 	var j *job
 
@@ -269,6 +383,183 @@ func (q *fsJobQueue) JobStatus(id uuid.UUID, result interface{}) (status jobqueu
 	return
 }
 
+func (q *fsJobQueue) Export() ([]jobqueue.Record, error) {
+	ids, err := q.db.List()
+	if err != nil {
+		return nil, fmt.Errorf("error listing jobs: %v", err)
+	}
+
+	records := make([]jobqueue.Record, 0, len(ids))
+	for _, id := range ids {
+		uuid, err := uuid.Parse(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid job '%s' in db: %v", id, err)
+		}
+		j, err := q.readJob(uuid)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, jobqueue.Record(*j))
+	}
+
+	sortRecords(records)
+
+	return records, nil
+}
+
+func (q *fsJobQueue) QueuedJobs() ([]jobqueue.QueuedJob, error) {
+	// Positions are taken before listing the jobs, so that jobs which are
+	// dequeued in the meantime are not missing. They show up as running.
+	positions := q.pendingPositions()
+	summaries := q.listSummaries(jobqueue.JobFilter{
+		Statuses: []jobqueue.JobStatus{jobqueue.JobPending, jobqueue.JobRunning},
+	})
+
+	var ready, waiting []jobqueue.QueuedJob
+	for _, j := range summaries {
+		queued := jobqueue.QueuedJob{
+			Id:        j.Id,
+			Type:      j.Type,
+			Status:    j.Status,
+			QueuedAt:  j.QueuedAt,
+			StartedAt: j.StartedAt,
+		}
+		if j.Status == jobqueue.JobPending {
+			queued.Position = positions[j.Id]
+		}
+		if queued.Position > 0 {
+			ready = append(ready, queued)
+		} else {
+			waiting = append(waiting, queued)
+		}
+	}
+
+	sort.Slice(ready, func(i, j int) bool {
+		return ready[i].Position < ready[j].Position
+	})
+	// Pending jobs without a position wait for their dependencies or
+	// became ready after the positions were taken
+	sort.SliceStable(waiting, func(i, j int) bool {
+		a, b := waiting[i], waiting[j]
+		if a.Status != b.Status {
+			return a.Status == jobqueue.JobPending
+		}
+		return a.QueuedAt.Before(b.QueuedAt)
+	})
+
+	return append(ready, waiting...), nil
+}
+
+func (q *fsJobQueue) ListJobs(filter jobqueue.JobFilter, offset, limit int) ([]jobqueue.JobSummary, int, error) {
+	jobs := q.listSummaries(filter)
+	jobqueue.SortJobSummaries(jobs)
+
+	page, err := jobqueue.PageJobSummaries(jobs, offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return page, len(jobs), nil
+}
+
+// Returns the positions of all pending jobs which can be dequeued, starting
+// at 1.
+func (q *fsJobQueue) pendingPositions() map[uuid.UUID]int {
+	q.pendingMutex.Lock()
+	defer q.pendingMutex.Unlock()
+
+	var all []pendingJob
+	for _, h := range q.pending {
+		all = append(all, *h...)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].before(all[j])
+	})
+
+	positions := make(map[uuid.UUID]int, len(all))
+	for i, p := range all {
+		positions[p.id] = i + 1
+	}
+	return positions
+}
+
+func (q *fsJobQueue) Import(records []jobqueue.Record) error {
+	imported := make(map[uuid.UUID]bool)
+	for _, r := range records {
+		if r.Id == uuid.Nil || r.Type == "" {
+			return fmt.Errorf("invalid job record '%s'", r.Id)
+		}
+		if imported[r.Id] {
+			return fmt.Errorf("duplicate job record '%s'", r.Id)
+		}
+		imported[r.Id] = true
+	}
+
+	for _, r := range records {
+		_, err := q.readJob(r.Id)
+		if err == nil {
+			return fmt.Errorf("cannot import job '%s': %v", r.Id, jobqueue.ErrExist)
+		} else if err != jobqueue.ErrNotExist {
+			return err
+		}
+
+		for _, dep := range r.Dependencies {
+			if imported[dep] {
+				continue
+			}
+			_, err := q.readJob(dep)
+			if err != nil {
+				return fmt.Errorf("cannot import job '%s': dependency '%s': %v", r.Id, dep, err)
+			}
+		}
+	}
+
+	records = append([]jobqueue.Record{}, records...)
+	sortRecords(records)
+
+	// Write all jobs before scheduling any of them, because scheduling
+	// depends on the state of dependencies.
+	for _, r := range records {
+		j := job(r)
+		j.Dependencies = uniqueUUIDList(j.Dependencies)
+		err := q.db.Write(j.Id.String(), j)
+		if err != nil {
+			return fmt.Errorf("cannot write job: %v:", err)
+		}
+		q.indexJob(&j)
+	}
+
+	q.dependantsMutex.Lock()
+	defer q.dependantsMutex.Unlock()
+	for _, r := range records {
+		if r.Status != jobqueue.JobPending {
+			continue
+		}
+		j := job(r)
+		n, err := q.countFinishedJobs(j.Dependencies)
+		if err != nil {
+			return err
+		}
+		if n == len(j.Dependencies) {
+			q.pushPending(&j)
+		} else {
+			for _, dep := range j.Dependencies {
+				q.dependants[dep] = append(q.dependants[dep], j.Id)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Sorts `records` by the time they were queued, so that jobs come after
+// their dependencies.
+func sortRecords(records []jobqueue.Record) {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].QueuedAt.Before(records[j].QueuedAt)
+	})
+}
+
 // Returns the number of finished jobs in `ids`.
 func (q *fsJobQueue) countFinishedJobs(ids []uuid.UUID) (int, error) {
 	n := 0
@@ -285,6 +576,36 @@ func (q *fsJobQueue) countFinishedJobs(ids []uuid.UUID) (int, error) {
 	return n, nil
 }
 
+// Updates the summary of `j`. Must be called whenever a job is written.
+func (q *fsJobQueue) indexJob(j *job) {
+	q.summariesMutex.Lock()
+	defer q.summariesMutex.Unlock()
+
+	q.summaries[j.Id] = jobqueue.JobSummary{
+		Id:         j.Id,
+		Type:       j.Type,
+		Status:     j.Status,
+		QueuedAt:   j.QueuedAt,
+		StartedAt:  j.StartedAt,
+		FinishedAt: j.FinishedAt,
+	}
+}
+
+// Returns the summaries of all jobs matching `filter`, in no particular
+// order.
+func (q *fsJobQueue) listSummaries(filter jobqueue.JobFilter) []jobqueue.JobSummary {
+	q.summariesMutex.Lock()
+	defer q.summariesMutex.Unlock()
+
+	var summaries []jobqueue.JobSummary
+	for _, summary := range q.summaries {
+		if filter.Matches(summary.Type, summary.Status) {
+			summaries = append(summaries, summary)
+		}
+	}
+	return summaries
+}
+
 // Reads job with `id`. This is a thin wrapper around `q.db.Read`, which
 // returns the job directly, or and error if a job with `id` does not exist.
 func (q *fsJobQueue) readJob(id uuid.UUID) (*job, error) {
@@ -300,19 +621,98 @@ func (q *fsJobQueue) readJob(id uuid.UUID) (*job, error) {
 	return &j, nil
 }
 
-// Safe access to the pending channel for `jobType`. Channels are created on
-// demand.
-func (q *fsJobQueue) pendingChannel(jobType string) chan uuid.UUID {
+// Adds `j` to the pending jobs of its type and wakes up all waiting Dequeue()
+// calls.
+func (q *fsJobQueue) pushPending(j *job) {
 	q.pendingMutex.Lock()
 	defer q.pendingMutex.Unlock()
 
-	c, exists := q.pending[jobType]
+	h, exists := q.pending[j.Type]
 	if !exists {
-		c = make(chan uuid.UUID, 100)
-		q.pending[jobType] = c
+		h = &pendingHeap{}
+		q.pending[j.Type] = h
 	}
 
-	return c
+	q.pendingSeq += 1
+	heap.Push(h, pendingJob{j.Id, j.Priority, q.pendingSeq})
+
+	close(q.pendingChanged)
+	q.pendingChanged = make(chan struct{})
+}
+
+// Removes and returns the pending job with the highest priority among all
+// `jobTypes`. Jobs of the same priority are returned in the order they were
+// added. If there is no such job, returns false and a channel which is closed
+// when the next job is added.
+func (q *fsJobQueue) popPending(jobTypes []string) (uuid.UUID, chan struct{}, bool) {
+	q.pendingMutex.Lock()
+	defer q.pendingMutex.Unlock()
+
+	var best *pendingHeap
+	for _, jt := range jobTypes {
+		h, exists := q.pending[jt]
+		if !exists || h.Len() == 0 {
+			continue
+		}
+		if best == nil || (*h)[0].before((*best)[0]) {
+			best = h
+		}
+	}
+
+	if best == nil {
+		return uuid.Nil, q.pendingChanged, false
+	}
+
+	return heap.Pop(best).(pendingJob).id, nil, true
+}
+
+// Removes `j` from the pending jobs of its type. Returns false if it isn't
+// one of them.
+func (q *fsJobQueue) removePending(j *job) bool {
+	q.pendingMutex.Lock()
+	defer q.pendingMutex.Unlock()
+
+	h, exists := q.pending[j.Type]
+	if !exists {
+		return false
+	}
+	for i, p := range *h {
+		if p.id == j.Id {
+			heap.Remove(h, i)
+			return true
+		}
+	}
+	return false
+}
+
+type pendingJob struct {
+	id       uuid.UUID
+	priority int
+	seq      uint64
+}
+
+func (a pendingJob) before(b pendingJob) bool {
+	if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+	return a.seq < b.seq
+}
+
+// pendingHeap implements heap.Interface, with the job that should be run
+// next at the top.
+type pendingHeap []pendingJob
+
+func (h pendingHeap) Len() int            { return len(h) }
+func (h pendingHeap) Less(i, j int) bool  { return h[i].before(h[j]) }
+func (h pendingHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *pendingHeap) Push(x interface{}) { *h = append(*h, x.(pendingJob)) }
+
+func (h *pendingHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
 }
 
 // Sorts and removes duplicates from `ids`.
@@ -0,0 +1,116 @@
+package fsjobqueue_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue/fsjobqueue"
+)
+
+type benchmarkArgs struct {
+	Payload string `json:"payload"`
+}
+
+func newBenchmarkQueue(b *testing.B) (jobqueue.JobQueue, string) {
+	dir, err := ioutil.TempDir("", "jobqueue-bench-")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	q, err := fsjobqueue.New(dir)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return q, dir
+}
+
+// Enqueues `n` jobs while a separate goroutine dequeues and finishes them, to
+// keep the number of pending jobs low.
+func BenchmarkEnqueue(b *testing.B) {
+	q, dir := newBenchmarkQueue(b)
+	defer os.RemoveAll(dir)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < b.N; i++ {
+			id, err := q.Dequeue(context.Background(), []string{"bench"}, &json.RawMessage{})
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			err = q.FinishJob(id, testResult{})
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := q.Enqueue("bench", benchmarkArgs{"payload"}, nil, jobqueue.PriorityNormal)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	<-done
+}
+
+func BenchmarkEnqueueDequeue(b *testing.B) {
+	q, dir := newBenchmarkQueue(b)
+	defer os.RemoveAll(dir)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := q.Enqueue("bench", benchmarkArgs{"payload"}, nil, jobqueue.PriorityNormal)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		var args benchmarkArgs
+		id, err := q.Dequeue(context.Background(), []string{"bench"}, &args)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		err = q.FinishJob(id, testResult{})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEnqueueDequeueParallel(b *testing.B) {
+	q, dir := newBenchmarkQueue(b)
+	defer os.RemoveAll(dir)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, err := q.Enqueue("bench", benchmarkArgs{"payload"}, nil, jobqueue.PriorityNormal)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+
+			var args benchmarkArgs
+			id, err := q.Dequeue(context.Background(), []string{"bench"}, &args)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+
+			err = q.FinishJob(id, testResult{})
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
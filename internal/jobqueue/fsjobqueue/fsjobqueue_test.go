@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
@@ -34,14 +35,14 @@ func newTemporaryQueue(t *testing.T) (jobqueue.JobQueue, string) {
 }
 
 func pushTestJob(t *testing.T, q jobqueue.JobQueue, jobType string, args interface{}, dependencies []uuid.UUID) uuid.UUID {
-	id, err := q.Enqueue(jobType, args, dependencies)
+	id, err := q.Enqueue(jobType, args, dependencies, jobqueue.PriorityNormal)
 	require.NoError(t, err)
 	require.NotEmpty(t, id)
 	return id
 }
 
-func finishNextTestJob(t *testing.T, q jobqueue.JobQueue, jobType string, result interface{}) uuid.UUID {
-	id, err := q.Dequeue(context.Background(), []string{jobType}, &json.RawMessage{})
+func finishNextTestJob(t *testing.T, q jobqueue.JobQueue, jobTypes []string, result interface{}) uuid.UUID {
+	id, err := q.Dequeue(context.Background(), jobTypes, &json.RawMessage{})
 	require.NoError(t, err)
 	require.NotEmpty(t, id)
 
@@ -62,12 +63,12 @@ func TestErrors(t *testing.T) {
 	defer cleanupTempDir(t, dir)
 
 	// not serializable to JSON
-	id, err := q.Enqueue("test", make(chan string), nil)
+	id, err := q.Enqueue("test", make(chan string), nil, jobqueue.PriorityNormal)
 	require.Error(t, err)
 	require.Equal(t, uuid.Nil, id)
 
 	// invalid dependency
-	id, err = q.Enqueue("test", "arg0", []uuid.UUID{uuid.New()})
+	id, err = q.Enqueue("test", "arg0", []uuid.UUID{uuid.New()}, jobqueue.PriorityNormal)
 	require.Error(t, err)
 	require.Equal(t, uuid.Nil, id)
 }
@@ -106,8 +107,8 @@ func TestJobTypes(t *testing.T) {
 	one := pushTestJob(t, q, "octopus", nil, nil)
 	two := pushTestJob(t, q, "clownfish", nil, nil)
 
-	require.Equal(t, two, finishNextTestJob(t, q, "clownfish", testResult{}))
-	require.Equal(t, one, finishNextTestJob(t, q, "octopus", testResult{}))
+	require.Equal(t, two, finishNextTestJob(t, q, []string{"clownfish"}, testResult{}))
+	require.Equal(t, one, finishNextTestJob(t, q, []string{"octopus"}, testResult{}))
 
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
@@ -116,6 +117,44 @@ func TestJobTypes(t *testing.T) {
 	require.Equal(t, uuid.Nil, id)
 }
 
+func TestJobProgress(t *testing.T) {
+	type progress struct {
+		Stage   string
+		Percent int
+	}
+
+	q, dir := newTemporaryQueue(t)
+	defer cleanupTempDir(t, dir)
+
+	id := pushTestJob(t, q, "octopus", nil, nil)
+	require.Equal(t, jobqueue.ErrNotRunning, q.SetJobProgress(id, progress{"org.osbuild.rpm", 10}))
+	require.Equal(t, jobqueue.ErrNotExist, q.SetJobProgress(uuid.New(), progress{"org.osbuild.rpm", 10}))
+
+	_, err := q.Dequeue(context.Background(), []string{"octopus"}, &json.RawMessage{})
+	require.NoError(t, err)
+
+	// no progress was recorded yet
+	p := progress{"none", -1}
+	require.NoError(t, q.JobProgress(id, &p))
+	require.Equal(t, progress{"none", -1}, p)
+
+	require.NoError(t, q.SetJobProgress(id, progress{"org.osbuild.rpm", 10}))
+	require.NoError(t, q.SetJobProgress(id, progress{"org.osbuild.rpm", 40}))
+	require.NoError(t, q.JobProgress(id, &p))
+	require.Equal(t, progress{"org.osbuild.rpm", 40}, p)
+
+	// progress survives restarts
+	q, err = fsjobqueue.New(dir)
+	require.NoError(t, err)
+	p = progress{}
+	require.NoError(t, q.JobProgress(id, &p))
+	require.Equal(t, progress{"org.osbuild.rpm", 40}, p)
+
+	require.NoError(t, q.FinishJob(id, testResult{}))
+	require.Equal(t, jobqueue.ErrNotRunning, q.JobProgress(id, &p))
+	require.Equal(t, jobqueue.ErrNotRunning, q.SetJobProgress(id, progress{"org.osbuild.rpm", 50}))
+}
+
 func TestDependencies(t *testing.T) {
 	q, dir := newTemporaryQueue(t)
 	defer cleanupTempDir(t, dir)
@@ -125,8 +164,8 @@ func TestDependencies(t *testing.T) {
 		two := pushTestJob(t, q, "test", nil, nil)
 
 		r := []uuid.UUID{}
-		r = append(r, finishNextTestJob(t, q, "test", testResult{}))
-		r = append(r, finishNextTestJob(t, q, "test", testResult{}))
+		r = append(r, finishNextTestJob(t, q, []string{"test"}, testResult{}))
+		r = append(r, finishNextTestJob(t, q, []string{"test"}, testResult{}))
 		require.ElementsMatch(t, []uuid.UUID{one, two}, r)
 
 		j := pushTestJob(t, q, "test", nil, []uuid.UUID{one, two})
@@ -134,7 +173,7 @@ func TestDependencies(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, jobqueue.JobPending, status)
 
-		require.Equal(t, j, finishNextTestJob(t, q, "test", testResult{}))
+		require.Equal(t, j, finishNextTestJob(t, q, []string{"test"}, testResult{}))
 
 		status, _, _, _, err = q.JobStatus(j, &testResult{})
 		require.NoError(t, err)
@@ -151,14 +190,227 @@ func TestDependencies(t *testing.T) {
 		require.Equal(t, jobqueue.JobPending, status)
 
 		r := []uuid.UUID{}
-		r = append(r, finishNextTestJob(t, q, "test", testResult{}))
-		r = append(r, finishNextTestJob(t, q, "test", testResult{}))
+		r = append(r, finishNextTestJob(t, q, []string{"test"}, testResult{}))
+		r = append(r, finishNextTestJob(t, q, []string{"test"}, testResult{}))
 		require.ElementsMatch(t, []uuid.UUID{one, two}, r)
 
-		require.Equal(t, j, finishNextTestJob(t, q, "test", testResult{}))
+		require.Equal(t, j, finishNextTestJob(t, q, []string{"test"}, testResult{}))
 
 		status, _, _, _, err = q.JobStatus(j, &testResult{})
 		require.NoError(t, err)
 		require.Equal(t, jobqueue.JobFinished, status)
 	})
 }
+
+func TestPriorities(t *testing.T) {
+	q, dir := newTemporaryQueue(t)
+	defer cleanupTempDir(t, dir)
+
+	push := func(jobType string, priority int) uuid.UUID {
+		id, err := q.Enqueue(jobType, nil, nil, priority)
+		require.NoError(t, err)
+		return id
+	}
+
+	one := push("fish", jobqueue.PriorityNormal)
+	two := push("octopus", jobqueue.PriorityNormal)
+	three := push("octopus", jobqueue.PriorityHigh)
+	four := push("fish", jobqueue.PriorityHigh)
+
+	types := []string{"fish", "octopus"}
+	require.Equal(t, three, finishNextTestJob(t, q, types, testResult{}))
+	require.Equal(t, four, finishNextTestJob(t, q, types, testResult{}))
+	require.Equal(t, one, finishNextTestJob(t, q, types, testResult{}))
+	require.Equal(t, two, finishNextTestJob(t, q, types, testResult{}))
+}
+
+func TestDequeueWaits(t *testing.T) {
+	q, dir := newTemporaryQueue(t)
+	defer cleanupTempDir(t, dir)
+
+	done := make(chan uuid.UUID)
+	go func() {
+		id, _ := q.Dequeue(context.Background(), []string{"octopus"}, &json.RawMessage{})
+		done <- id
+	}()
+
+	id := pushTestJob(t, q, "octopus", nil, nil)
+	require.Equal(t, id, <-done)
+}
+
+func TestMigrate(t *testing.T) {
+	src, srcDir := newTemporaryQueue(t)
+	defer cleanupTempDir(t, srcDir)
+	dst, dstDir := newTemporaryQueue(t)
+	defer cleanupTempDir(t, dstDir)
+
+	// one finished job, one pending job that is ready to run, one running
+	// job, and one pending job that waits for the running one
+	finished := pushTestJob(t, src, "build", nil, nil)
+	finishNextTestJob(t, src, []string{"build"}, testResult{})
+	ready := pushTestJob(t, src, "upload", nil, []uuid.UUID{finished})
+	running := pushTestJob(t, src, "build", nil, nil)
+	id, err := src.Dequeue(context.Background(), []string{"build"}, &json.RawMessage{})
+	require.NoError(t, err)
+	require.Equal(t, running, id)
+	waiting := pushTestJob(t, src, "upload", nil, []uuid.UUID{running})
+
+	records, err := src.(jobqueue.Migrator).Export()
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+
+	err = dst.(jobqueue.Migrator).Import(records)
+	require.NoError(t, err)
+
+	imported, err := dst.(jobqueue.Migrator).Export()
+	require.NoError(t, err)
+	require.Equal(t, records, imported)
+
+	// importing again must fail without changing anything
+	err = dst.(jobqueue.Migrator).Import(records)
+	require.Error(t, err)
+
+	// the jobs continue where they left off
+	require.Equal(t, ready, finishNextTestJob(t, dst, []string{"upload"}, testResult{}))
+	require.NoError(t, dst.FinishJob(running, testResult{}))
+	require.Equal(t, waiting, finishNextTestJob(t, dst, []string{"upload"}, testResult{}))
+}
+
+func TestImportMissingDependency(t *testing.T) {
+	q, dir := newTemporaryQueue(t)
+	defer cleanupTempDir(t, dir)
+
+	records := []jobqueue.Record{
+		{Id: uuid.New(), Type: "build", Dependencies: []uuid.UUID{uuid.New()}, Status: jobqueue.JobPending},
+	}
+	err := q.(jobqueue.Migrator).Import(records)
+	require.Error(t, err)
+
+	exported, err := q.(jobqueue.Migrator).Export()
+	require.NoError(t, err)
+	require.Empty(t, exported)
+}
+
+func TestQueuedJobs(t *testing.T) {
+	q, dir := newTemporaryQueue(t)
+	defer cleanupTempDir(t, dir)
+
+	finished := pushTestJob(t, q, "build", nil, nil)
+	finishNextTestJob(t, q, []string{"build"}, testResult{})
+	running := pushTestJob(t, q, "build", nil, nil)
+	id, err := q.Dequeue(context.Background(), []string{"build"}, &json.RawMessage{})
+	require.NoError(t, err)
+	require.Equal(t, running, id)
+	waiting := pushTestJob(t, q, "upload", nil, []uuid.UUID{running})
+	normal := pushTestJob(t, q, "upload", nil, []uuid.UUID{finished})
+	high, err := q.Enqueue("build", nil, nil, jobqueue.PriorityHigh)
+	require.NoError(t, err)
+
+	jobs, err := q.(jobqueue.Inspector).QueuedJobs()
+	require.NoError(t, err)
+	require.Len(t, jobs, 4)
+
+	var ids []uuid.UUID
+	for _, j := range jobs {
+		ids = append(ids, j.Id)
+	}
+	require.Equal(t, []uuid.UUID{high, normal, waiting, running}, ids)
+	require.Equal(t, 1, jobs[0].Position)
+	require.Equal(t, 2, jobs[1].Position)
+	require.Equal(t, "upload", jobs[1].Type)
+	require.Equal(t, 0, jobs[2].Position)
+	require.Equal(t, jobqueue.JobPending, jobs[2].Status)
+	require.Equal(t, jobqueue.JobRunning, jobs[3].Status)
+	require.False(t, jobs[3].StartedAt.IsZero())
+}
+
+func TestCancelJob(t *testing.T) {
+	q, dir := newTemporaryQueue(t)
+	defer cleanupTempDir(t, dir)
+	canceler := q.(jobqueue.Canceler)
+
+	running := pushTestJob(t, q, "build", nil, nil)
+	id, err := q.Dequeue(context.Background(), []string{"build"}, &json.RawMessage{})
+	require.NoError(t, err)
+	require.Equal(t, running, id)
+	require.Equal(t, jobqueue.ErrNotPending, canceler.CancelJob(running))
+
+	payload := pushTestJob(t, q, "build", nil, nil)
+	installer := pushTestJob(t, q, "build", nil, []uuid.UUID{payload})
+	require.Equal(t, jobqueue.ErrDependants, canceler.CancelJob(payload))
+	require.NoError(t, canceler.CancelJob(installer))
+	require.NoError(t, canceler.CancelJob(payload))
+	require.Equal(t, jobqueue.ErrNotExist, canceler.CancelJob(payload))
+
+	_, _, _, _, err = q.JobStatus(payload, &json.RawMessage{})
+	require.Equal(t, jobqueue.ErrNotExist, err)
+	_, total, err := q.ListJobs(jobqueue.JobFilter{}, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, total)
+
+	// canceled jobs are neither dequeued nor loaded again
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = q.Dequeue(ctx, []string{"build"}, &json.RawMessage{})
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	q, err = fsjobqueue.New(dir)
+	require.NoError(t, err)
+	_, total, err = q.ListJobs(jobqueue.JobFilter{}, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, total)
+}
+
+func TestListJobs(t *testing.T) {
+	q, dir := newTemporaryQueue(t)
+	defer cleanupTempDir(t, dir)
+
+	// queued one after another, so that they are listed in reverse order
+	var ids []uuid.UUID
+	for i := 0; i < 5; i++ {
+		ids = append(ids, pushTestJob(t, q, "octopus", nil, nil))
+		time.Sleep(time.Millisecond)
+	}
+	fish := pushTestJob(t, q, "fish", nil, nil)
+	finished := finishNextTestJob(t, q, []string{"octopus"}, testResult{})
+	require.Equal(t, ids[0], finished)
+
+	listIds := func(q jobqueue.JobQueue, filter jobqueue.JobFilter, offset, limit int) ([]uuid.UUID, int) {
+		jobs, total, err := q.ListJobs(filter, offset, limit)
+		require.NoError(t, err)
+		var ids []uuid.UUID
+		for _, j := range jobs {
+			ids = append(ids, j.Id)
+		}
+		return ids, total
+	}
+
+	all, total := listIds(q, jobqueue.JobFilter{}, 0, 0)
+	require.Equal(t, []uuid.UUID{fish, ids[4], ids[3], ids[2], ids[1], ids[0]}, all)
+	require.Equal(t, 6, total)
+
+	page, total := listIds(q, jobqueue.JobFilter{Types: []string{"octopus"}}, 1, 2)
+	require.Equal(t, []uuid.UUID{ids[3], ids[2]}, page)
+	require.Equal(t, 5, total)
+
+	page, total = listIds(q, jobqueue.JobFilter{Statuses: []jobqueue.JobStatus{jobqueue.JobFinished}}, 0, 10)
+	require.Equal(t, []uuid.UUID{ids[0]}, page)
+	require.Equal(t, 1, total)
+
+	page, total = listIds(q, jobqueue.JobFilter{}, 10, 10)
+	require.Empty(t, page)
+	require.Equal(t, 6, total)
+
+	_, _, err := q.ListJobs(jobqueue.JobFilter{}, -1, 0)
+	require.Equal(t, jobqueue.ErrInvalidRange, err)
+
+	// summaries are loaded from disk
+	reopened, err := fsjobqueue.New(dir)
+	require.NoError(t, err)
+	jobs, _, err := reopened.ListJobs(jobqueue.JobFilter{}, 5, 1)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	require.Equal(t, ids[0], jobs[0].Id)
+	require.Equal(t, jobqueue.JobFinished, jobs[0].Status)
+	require.False(t, jobs[0].FinishedAt.IsZero())
+}
@@ -1,7 +1,8 @@
 // Package jobqueue provides a generic interface to a simple job queue.
 //
 // Jobs are pushed to the queue with Enqueue(). Workers call Dequeue() to
-// receive a job and FinishJob() to report one as finished.
+// receive a job, SetJobProgress() to report how far they are with it, and
+// FinishJob() to report it as finished.
 //
 // Each job has a type and arguments corresponding to this type. These are
 // opaque to the job queue, but it mandates that the arguments must be
@@ -10,12 +11,17 @@
 //
 // A job can have dependencies. It is not run until all its dependencies have
 // finished.
+//
+// A job also has a priority. Jobs with a higher priority are dequeued before
+// jobs with a lower priority, and jobs of the same priority in the order they
+// became ready to run.
 package jobqueue
 
 import (
 	"context"
 	"encoding/json"
 	"errors"
+	"sort"
 	"time"
 
 	"github.com/google/uuid"
@@ -31,8 +37,11 @@ type JobQueue interface {
 	// All dependencies must already exist, but the job isn't run until all of them
 	// have finished.
 	//
+	// `priority` is usually one of PriorityNormal or PriorityHigh, but any
+	// integer is allowed.
+	//
 	// Returns the id of the new job, or an error.
-	Enqueue(jobType string, args interface{}, dependencies []uuid.UUID) (uuid.UUID, error)
+	Enqueue(jobType string, args interface{}, dependencies []uuid.UUID, priority int) (uuid.UUID, error)
 
 	// Dequeues a job, blocking until one is available.
 	//
@@ -49,6 +58,16 @@ type JobQueue interface {
 	// job type and must be serializable to JSON.
 	FinishJob(id uuid.UUID, result interface{}) error
 
+	// Records how far the worker is with the running job with `id`.
+	// `progress` is opaque to the job queue, but must be serializable to
+	// JSON. It replaces any progress recorded before, and is dropped when
+	// the job finishes.
+	SetJobProgress(id uuid.UUID, progress interface{}) error
+
+	// Returns the progress that was last recorded for the running job with
+	// `id` in `progress`, which is left untouched if there is none.
+	JobProgress(id uuid.UUID, progress interface{}) error
+
 	// Returns the current status of the job. If the job has already
 	// finished, its result will be returned in `result`. Also returns the
 	// time the job was
@@ -56,8 +75,161 @@ type JobQueue interface {
 	//    started  - valid when the job is running or has finished
 	//    finished - valid when the job has finished
 	JobStatus(id uuid.UUID, result interface{}) (status JobStatus, queued, started, finished time.Time, err error)
+
+	// Returns summaries of the jobs that match `filter`, most recently
+	// queued first, and the number of all jobs that match. The first
+	// `offset` of them are skipped and at most `limit` are returned, or
+	// all remaining ones if `limit` is 0.
+	ListJobs(filter JobFilter, offset, limit int) ([]JobSummary, int, error)
+}
+
+// A JobFilter selects jobs in ListJobs(). A job matches if it has any of
+// `Types` and any of `Statuses`. Empty lists match all jobs.
+type JobFilter struct {
+	Types    []string
+	Statuses []JobStatus
+}
+
+func (f *JobFilter) Matches(jobType string, status JobStatus) bool {
+	return (len(f.Types) == 0 || containsString(f.Types, jobType)) &&
+		(len(f.Statuses) == 0 || containsStatus(f.Statuses, status))
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
 }
 
+func containsStatus(list []JobStatus, s JobStatus) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// A JobSummary is what ListJobs() returns about a job, without its
+// arguments and result.
+type JobSummary struct {
+	Id         uuid.UUID `json:"id"`
+	Type       string    `json:"type"`
+	Status     JobStatus `json:"status"`
+	QueuedAt   time.Time `json:"queued-at,omitempty"`
+	StartedAt  time.Time `json:"started-at,omitempty"`
+	FinishedAt time.Time `json:"finished-at,omitempty"`
+}
+
+// SortJobSummaries sorts `jobs` the way ListJobs() returns them. Jobs
+// queued at the same time are sorted by id.
+func SortJobSummaries(jobs []JobSummary) {
+	sort.Slice(jobs, func(i, j int) bool {
+		a, b := jobs[i], jobs[j]
+		if !a.QueuedAt.Equal(b.QueuedAt) {
+			return a.QueuedAt.After(b.QueuedAt)
+		}
+		return a.Id.String() < b.Id.String()
+	})
+}
+
+// PageJobSummaries returns the page of `jobs` that ListJobs() returns for
+// `offset` and `limit`.
+func PageJobSummaries(jobs []JobSummary, offset, limit int) ([]JobSummary, error) {
+	if offset < 0 || limit < 0 {
+		return nil, ErrInvalidRange
+	}
+	if offset > len(jobs) {
+		offset = len(jobs)
+	}
+	jobs = jobs[offset:]
+	if limit > 0 && limit < len(jobs) {
+		jobs = jobs[:limit]
+	}
+	return jobs, nil
+}
+
+// A Migrator is a JobQueue whose jobs can be exported and imported with
+// their ids, state, and results intact. It is used to move the jobs of an
+// existing installation to another job queue backend.
+type Migrator interface {
+	JobQueue
+
+	// Returns all jobs, in the order they were queued.
+	Export() ([]Record, error)
+
+	// Adds jobs to the queue, keeping their ids and state. Pending jobs
+	// are scheduled as if they had been enqueued, and running jobs can be
+	// finished with FinishJob(). Fails without adding any job if a job
+	// already exists or depends on a job that neither exists nor is part
+	// of `records`.
+	Import(records []Record) error
+}
+
+// A Canceler is a JobQueue which can remove jobs before they run, to undo
+// enqueueing them.
+type Canceler interface {
+	JobQueue
+
+	// Removes the pending job with `id`, as if it had never been
+	// enqueued. Returns ErrNotPending if the job has been dequeued
+	// already, and ErrDependants if other jobs which are pending depend on
+	// it; those have to be canceled first.
+	CancelJob(id uuid.UUID) error
+}
+
+// An Inspector is a JobQueue which can list the jobs that have not finished
+// yet, to show what is in the queue.
+type Inspector interface {
+	JobQueue
+
+	// Returns all pending and running jobs. Pending jobs which can be
+	// dequeued come first, in the order they would be dequeued by a
+	// worker that takes jobs of any type. They are followed by pending
+	// jobs which wait for their dependencies and by running jobs, both in
+	// the order they were queued.
+	QueuedJobs() ([]QueuedJob, error)
+}
+
+// A QueuedJob is a job which has not finished yet.
+type QueuedJob struct {
+	Id     uuid.UUID `json:"id"`
+	Type   string    `json:"type"`
+	Status JobStatus `json:"status"`
+
+	// The position of a pending job in the queue, starting at 1. It is 0
+	// for jobs which wait for their dependencies and for running jobs.
+	Position int `json:"position,omitempty"`
+
+	QueuedAt  time.Time `json:"queued-at,omitempty"`
+	StartedAt time.Time `json:"started-at,omitempty"`
+}
+
+// A Record is the complete state of a job, in a format that is independent
+// of job queue backends.
+type Record struct {
+	Id           uuid.UUID       `json:"id"`
+	Type         string          `json:"type"`
+	Args         json.RawMessage `json:"args,omitempty"`
+	Dependencies []uuid.UUID     `json:"dependencies"`
+	Priority     int             `json:"priority,omitempty"`
+	Result       json.RawMessage `json:"result,omitempty"`
+	Progress     json.RawMessage `json:"progress,omitempty"`
+
+	Status     JobStatus `json:"status"`
+	QueuedAt   time.Time `json:"queued-at,omitempty"`
+	StartedAt  time.Time `json:"started-at,omitempty"`
+	FinishedAt time.Time `json:"finished-at,omitempty"`
+}
+
+const (
+	PriorityNormal = 0
+	PriorityHigh   = 100
+)
+
 type JobStatus int
 
 const (
@@ -102,4 +274,9 @@ func (s *JobStatus) UnmarshalJSON(data []byte) error {
 var (
 	ErrNotExist   = errors.New("job does not exist")
 	ErrNotRunning = errors.New("job is not running")
+	ErrExist      = errors.New("job already exists")
+	ErrNotPending = errors.New("job is not pending")
+	ErrDependants = errors.New("other jobs depend on the job")
+
+	ErrInvalidRange = errors.New("offset and limit must not be negative")
 )
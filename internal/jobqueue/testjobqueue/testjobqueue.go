@@ -1,6 +1,6 @@
 // Package testjobqueue implements jobqueue interface. It is meant for testing,
-// and as such doesn't implement two invariants of jobqueue: it is not safe for
-// concurrent access and `Dequeue()` doesn't wait for new jobs to appear.
+// and as such doesn't implement an invariant of jobqueue: `Dequeue()` doesn't
+// wait for new jobs to appear.
 package testjobqueue
 
 import (
@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -16,6 +17,9 @@ import (
 )
 
 type testJobQueue struct {
+	// Protects all fields below
+	mu sync.Mutex
+
 	jobs map[uuid.UUID]*job
 
 	pending map[string][]uuid.UUID
@@ -29,22 +33,29 @@ type job struct {
 	Type         string
 	Args         json.RawMessage
 	Dependencies []uuid.UUID
+	Priority     int
 	Result       json.RawMessage
+	Progress     json.RawMessage
 	Status       jobqueue.JobStatus
 }
 
 func New() *testJobQueue {
 	return &testJobQueue{
-		jobs:    make(map[uuid.UUID]*job),
-		pending: make(map[string][]uuid.UUID),
+		jobs:       make(map[uuid.UUID]*job),
+		pending:    make(map[string][]uuid.UUID),
+		dependants: make(map[uuid.UUID][]uuid.UUID),
 	}
 }
 
-func (q *testJobQueue) Enqueue(jobType string, args interface{}, dependencies []uuid.UUID) (uuid.UUID, error) {
+func (q *testJobQueue) Enqueue(jobType string, args interface{}, dependencies []uuid.UUID, priority int) (uuid.UUID, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
 	var j = job{
 		Id:           uuid.New(),
 		Type:         jobType,
 		Dependencies: uniqueUUIDList(dependencies),
+		Priority:     priority,
 		Status:       jobqueue.JobPending,
 	}
 
@@ -66,7 +77,7 @@ func (q *testJobQueue) Enqueue(jobType string, args interface{}, dependencies []
 	// Otherwise, update dependants so that this check is done again when
 	// FinishJob() is called for a dependency.
 	if finished == len(j.Dependencies) {
-		q.pending[j.Type] = append(q.pending[j.Type], j.Id)
+		q.pushPending(&j)
 	} else {
 		for _, id := range j.Dependencies {
 			q.dependants[id] = append(q.dependants[id], j.Id)
@@ -77,29 +88,39 @@ func (q *testJobQueue) Enqueue(jobType string, args interface{}, dependencies []
 }
 
 func (q *testJobQueue) Dequeue(ctx context.Context, jobTypes []string, args interface{}) (uuid.UUID, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var best *job
 	for _, t := range jobTypes {
 		if len(q.pending[t]) == 0 {
 			continue
 		}
+		j := q.jobs[q.pending[t][0]]
+		if best == nil || j.Priority > best.Priority {
+			best = j
+		}
+	}
 
-		id := q.pending[t][0]
-		q.pending[t] = q.pending[t][1:]
-
-		j := q.jobs[id]
+	if best == nil {
+		return uuid.Nil, errors.New("no job available")
+	}
 
-		err := json.Unmarshal(j.Args, args)
-		if err != nil {
-			return uuid.Nil, err
-		}
+	q.pending[best.Type] = q.pending[best.Type][1:]
 
-		j.Status = jobqueue.JobRunning
-		return j.Id, nil
+	err := json.Unmarshal(best.Args, args)
+	if err != nil {
+		return uuid.Nil, err
 	}
 
-	return uuid.Nil, errors.New("no job available")
+	best.Status = jobqueue.JobRunning
+	return best.Id, nil
 }
 
 func (q *testJobQueue) FinishJob(id uuid.UUID, result interface{}) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
 	j, exists := q.jobs[id]
 	if !exists {
 		return jobqueue.ErrNotExist
@@ -116,6 +137,7 @@ func (q *testJobQueue) FinishJob(id uuid.UUID, result interface{}) error {
 	}
 
 	j.Status = jobqueue.JobFinished
+	j.Progress = nil
 
 	for _, depid := range q.dependants[id] {
 		dep := q.jobs[depid]
@@ -124,7 +146,7 @@ func (q *testJobQueue) FinishJob(id uuid.UUID, result interface{}) error {
 			return err
 		}
 		if n == len(dep.Dependencies) {
-			q.pending[dep.Type] = append(q.pending[dep.Type], dep.Id)
+			q.pushPending(dep)
 		}
 	}
 	delete(q.dependants, id)
@@ -132,7 +154,80 @@ func (q *testJobQueue) FinishJob(id uuid.UUID, result interface{}) error {
 	return nil
 }
 
+func (q *testJobQueue) CancelJob(id uuid.UUID) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j, exists := q.jobs[id]
+	if !exists {
+		return jobqueue.ErrNotExist
+	}
+
+	if j.Status != jobqueue.JobPending {
+		return jobqueue.ErrNotPending
+	}
+
+	if len(q.dependants[id]) > 0 {
+		return jobqueue.ErrDependants
+	}
+
+	for _, dep := range j.Dependencies {
+		q.dependants[dep] = removeUUID(q.dependants[dep], id)
+		if len(q.dependants[dep]) == 0 {
+			delete(q.dependants, dep)
+		}
+	}
+	q.pending[j.Type] = removeUUID(q.pending[j.Type], id)
+	delete(q.jobs, id)
+
+	return nil
+}
+
+func (q *testJobQueue) SetJobProgress(id uuid.UUID, progress interface{}) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j, exists := q.jobs[id]
+	if !exists {
+		return jobqueue.ErrNotExist
+	}
+
+	if j.Status != jobqueue.JobRunning {
+		return jobqueue.ErrNotRunning
+	}
+
+	var err error
+	j.Progress, err = json.Marshal(progress)
+	if err != nil {
+		return fmt.Errorf("error marshaling progress: %v", err)
+	}
+
+	return nil
+}
+
+func (q *testJobQueue) JobProgress(id uuid.UUID, progress interface{}) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j, exists := q.jobs[id]
+	if !exists {
+		return jobqueue.ErrNotExist
+	}
+
+	if j.Status != jobqueue.JobRunning {
+		return jobqueue.ErrNotRunning
+	}
+
+	if j.Progress == nil {
+		return nil
+	}
+	return json.Unmarshal(j.Progress, progress)
+}
+
 func (q *testJobQueue) JobStatus(id uuid.UUID, result interface{}) (status jobqueue.JobStatus, queued, started, finished time.Time, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
 	var j *job
 
 	j, exists := q.jobs[id]
@@ -148,6 +243,7 @@ func (q *testJobQueue) JobStatus(id uuid.UUID, result interface{}) (status jobqu
 		}
 	}
 
+	status = j.Status
 	queued = time.Time{}
 	started = time.Time{}
 	finished = time.Time{}
@@ -155,6 +251,84 @@ func (q *testJobQueue) JobStatus(id uuid.UUID, result interface{}) (status jobqu
 	return
 }
 
+func (q *testJobQueue) ListJobs(filter jobqueue.JobFilter, offset, limit int) ([]jobqueue.JobSummary, int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var jobs []jobqueue.JobSummary
+	for _, j := range q.jobs {
+		if filter.Matches(j.Type, j.Status) {
+			jobs = append(jobs, jobqueue.JobSummary{Id: j.Id, Type: j.Type, Status: j.Status})
+		}
+	}
+	jobqueue.SortJobSummaries(jobs)
+
+	page, err := jobqueue.PageJobSummaries(jobs, offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return page, len(jobs), nil
+}
+
+// QueuedJobs orders jobs of different types with the same priority by type,
+// and jobs without a position by id, because this queue doesn't record when
+// jobs were queued.
+func (q *testJobQueue) QueuedJobs() ([]jobqueue.QueuedJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var types []string
+	for t := range q.pending {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	var ready []jobqueue.QueuedJob
+	positioned := make(map[uuid.UUID]bool)
+	for _, t := range types {
+		for _, id := range q.pending[t] {
+			ready = append(ready, jobqueue.QueuedJob{Id: id, Type: t, Status: jobqueue.JobPending})
+			positioned[id] = true
+		}
+	}
+	sort.SliceStable(ready, func(i, j int) bool {
+		return q.jobs[ready[i].Id].Priority > q.jobs[ready[j].Id].Priority
+	})
+	for i := range ready {
+		ready[i].Position = i + 1
+	}
+
+	var waiting []jobqueue.QueuedJob
+	for id, j := range q.jobs {
+		if j.Status != jobqueue.JobFinished && !positioned[id] {
+			waiting = append(waiting, jobqueue.QueuedJob{Id: id, Type: j.Type, Status: j.Status})
+		}
+	}
+	sort.Slice(waiting, func(i, j int) bool {
+		a, b := waiting[i], waiting[j]
+		if a.Status != b.Status {
+			return a.Status == jobqueue.JobPending
+		}
+		return a.Id.String() < b.Id.String()
+	})
+
+	return append(ready, waiting...), nil
+}
+
+// Adds `j` to the pending jobs of its type, behind all jobs with the same or a
+// higher priority.
+func (q *testJobQueue) pushPending(j *job) {
+	pending := q.pending[j.Type]
+	i := sort.Search(len(pending), func(i int) bool {
+		return q.jobs[pending[i]].Priority < j.Priority
+	})
+	pending = append(pending, uuid.Nil)
+	copy(pending[i+1:], pending[i:])
+	pending[i] = j.Id
+	q.pending[j.Type] = pending
+}
+
 // Returns the number of finished jobs in `ids`.
 func (q *testJobQueue) countFinishedJobs(ids []uuid.UUID) (int, error) {
 	n := 0
@@ -195,3 +369,13 @@ func uniqueUUIDList(ids []uuid.UUID) []uuid.UUID {
 
 	return l
 }
+
+func removeUUID(ids []uuid.UUID, id uuid.UUID) []uuid.UUID {
+	var result []uuid.UUID
+	for _, i := range ids {
+		if i != id {
+			result = append(result, i)
+		}
+	}
+	return result
+}
@@ -1,8 +1,8 @@
 // Package jsondb implements a simple database of JSON documents, backed by the
 // file system.
 //
-// It supports two operations: Read() and Write(). Their signatures mirror
-// those of json.Unmarshal() and json.Marshal():
+// It supports three operations: Read(), Write(), and Delete(). The signatures
+// of the first two mirror those of json.Unmarshal() and json.Marshal():
 //
 //     err := db.Write("my-string", "octopus")
 //
@@ -85,6 +85,15 @@ func (db *JSONDatabase) Write(name string, document interface{}) error {
 	})
 }
 
+// Deletes the document at `name`. It is not an error if it doesn't exist.
+func (db *JSONDatabase) Delete(name string) error {
+	err := os.Remove(path.Join(db.dir, name+".json"))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error deleting db file %s: %v", name, err)
+	}
+	return nil
+}
+
 // writeFileAtomically writes data to `filename` in `directory` atomically, by
 // first creating a temporary file in `directory` and only moving it when
 // writing succeeded. `writer` gets passed the open file handle to write to and
@@ -101,4 +101,10 @@ func TestMultiple(t *testing.T) {
 		require.True(t, exist)
 		require.Equalf(t, doc, d, "error retrieving document '%s'", name)
 	}
+
+	require.NoError(t, db.Delete("two"))
+	require.NoError(t, db.Delete("two"))
+	names, err = db.List()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"one", "three"}, names)
 }
@@ -0,0 +1,73 @@
+package lease
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Election elects a leader among all replicas that share a lease. It is meant
+// for duties that must not run on more than one replica at a time, like
+// garbage collection.
+type Election struct {
+	lease *Lease
+
+	mu     sync.Mutex
+	leader bool
+	epoch  uint64
+}
+
+func NewElection(lease *Lease) *Election {
+	return &Election{lease: lease}
+}
+
+// Run campaigns for leadership until `ctx` is canceled. The lease is acquired
+// or renewed every third of its TTL, and released when Run returns.
+func (e *Election) Run(ctx context.Context) {
+	interval := e.lease.TTL() / 3
+
+	for {
+		epoch, err := e.lease.Acquire()
+		if err != nil && err != ErrHeld {
+			log.Printf("error acquiring lease: %v", err)
+		}
+		e.setLeader(err == nil, epoch)
+
+		select {
+		case <-ctx.Done():
+			e.setLeader(false, 0)
+			err := e.lease.Release()
+			if err != nil {
+				log.Printf("error releasing lease: %v", err)
+			}
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// IsLeader returns true if this replica was the leader when the lease was
+// last acquired or renewed.
+func (e *Election) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.leader
+}
+
+func (e *Election) setLeader(leader bool, epoch uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if leader != e.leader || epoch != e.epoch {
+		if leader {
+			log.Printf("%s became leader (epoch %d)", e.lease.Holder(), epoch)
+		} else if e.leader {
+			log.Printf("%s is not leader anymore", e.lease.Holder())
+		}
+	}
+
+	e.leader = leader
+	e.epoch = epoch
+}
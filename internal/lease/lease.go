@@ -0,0 +1,170 @@
+// Package lease implements time-limited, exclusive leases backed by a file on
+// storage that is shared between all contenders.
+//
+// A lease is held by at most one holder at a time. The holder must renew it
+// before it expires, otherwise any other contender can take it over. Each
+// takeover increments the lease's epoch, which serves as a fencing token: a
+// holder that fails to renew its lease must assume that someone else took
+// over and stop doing whatever the lease protects.
+//
+// Access to the lease file is serialized with flock(2) on a separate lock
+// file, so the shared storage must support it.
+package lease
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+var ErrHeld = errors.New("lease is held by someone else")
+
+type Lease struct {
+	path   string
+	holder string
+	ttl    time.Duration
+}
+
+// On-disk representation of a lease.
+type state struct {
+	Holder  string    `json:"holder"`
+	Epoch   uint64    `json:"epoch"`
+	Expires time.Time `json:"expires"`
+}
+
+// New creates a lease object for `holder` for the lease at `path`. It doesn't
+// acquire the lease. `holder` must be unique among all contenders.
+func New(path, holder string, ttl time.Duration) *Lease {
+	return &Lease{
+		path:   path,
+		holder: holder,
+		ttl:    ttl,
+	}
+}
+
+func (l *Lease) Holder() string {
+	return l.holder
+}
+
+func (l *Lease) TTL() time.Duration {
+	return l.ttl
+}
+
+// Acquire acquires the lease, or renews it if it is already held by this
+// holder. It returns the lease's epoch, which only changes when the lease
+// changes hands. Returns ErrHeld if the lease is held by someone else and
+// has not expired yet.
+func (l *Lease) Acquire() (uint64, error) {
+	var epoch uint64
+
+	err := l.locked(func(s *state) (bool, error) {
+		now := time.Now()
+
+		if s.Holder != l.holder {
+			if s.Holder != "" && now.Before(s.Expires) {
+				return false, ErrHeld
+			}
+			s.Epoch += 1
+			s.Holder = l.holder
+		}
+
+		s.Expires = now.Add(l.ttl)
+		epoch = s.Epoch
+		return true, nil
+	})
+
+	return epoch, err
+}
+
+// Release gives up the lease, so that others don't have to wait until it
+// expires. Releasing a lease that is not held by this holder is a no-op.
+func (l *Lease) Release() error {
+	return l.locked(func(s *state) (bool, error) {
+		if s.Holder != l.holder {
+			return false, nil
+		}
+		s.Holder = ""
+		s.Expires = time.Time{}
+		return true, nil
+	})
+}
+
+// Current returns the current holder of the lease and its epoch, or an empty
+// holder if the lease is not held by anyone.
+func (l *Lease) Current() (string, uint64, error) {
+	var holder string
+	var epoch uint64
+
+	err := l.locked(func(s *state) (bool, error) {
+		if time.Now().Before(s.Expires) {
+			holder = s.Holder
+		}
+		epoch = s.Epoch
+		return false, nil
+	})
+
+	return holder, epoch, err
+}
+
+// Calls `f` with the current state of the lease while holding the lock file.
+// The state is written back if `f` returns true.
+func (l *Lease) locked(f func(s *state) (bool, error)) error {
+	lockfile, err := os.OpenFile(l.path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening lock file: %v", err)
+	}
+	defer lockfile.Close()
+
+	err = syscall.Flock(int(lockfile.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		return fmt.Errorf("error locking %s: %v", lockfile.Name(), err)
+	}
+	// closing the file releases the lock
+
+	var s state
+	data, err := ioutil.ReadFile(l.path)
+	if err == nil {
+		err = json.Unmarshal(data, &s)
+		if err != nil {
+			return fmt.Errorf("error reading lease %s: %v", l.path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("error reading lease %s: %v", l.path, err)
+	}
+
+	write, err := f(&s)
+	if err != nil || !write {
+		return err
+	}
+
+	data, err = json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	tmpfile, err := ioutil.TempFile(filepath.Dir(l.path), filepath.Base(l.path)+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("error writing lease: %v", err)
+	}
+	_, err = tmpfile.Write(data)
+	if err == nil {
+		err = tmpfile.Sync()
+	}
+	if closeErr := tmpfile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpfile.Name(), l.path)
+	}
+	if err != nil {
+		_ = os.Remove(tmpfile.Name())
+		return fmt.Errorf("error writing lease: %v", err)
+	}
+
+	return nil
+}
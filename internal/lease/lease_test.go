@@ -0,0 +1,117 @@
+package lease_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/lease"
+)
+
+func TestAcquire(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lease-tests-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	primary := lease.New(path.Join(dir, "lease"), "primary", time.Hour)
+	standby := lease.New(path.Join(dir, "lease"), "standby", time.Hour)
+
+	epoch, err := primary.Acquire()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), epoch)
+
+	// renewing keeps the epoch
+	epoch, err = primary.Acquire()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), epoch)
+
+	_, err = standby.Acquire()
+	require.Equal(t, lease.ErrHeld, err)
+
+	holder, epoch, err := standby.Current()
+	require.NoError(t, err)
+	require.Equal(t, "primary", holder)
+	require.Equal(t, uint64(1), epoch)
+
+	// releasing someone else's lease does nothing
+	require.NoError(t, standby.Release())
+	_, err = standby.Acquire()
+	require.Equal(t, lease.ErrHeld, err)
+
+	require.NoError(t, primary.Release())
+	epoch, err = standby.Acquire()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), epoch)
+
+	// the previous holder is fenced off
+	_, err = primary.Acquire()
+	require.Equal(t, lease.ErrHeld, err)
+}
+
+func TestExpiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lease-tests-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	primary := lease.New(path.Join(dir, "lease"), "primary", time.Millisecond)
+	standby := lease.New(path.Join(dir, "lease"), "standby", time.Hour)
+
+	_, err = primary.Acquire()
+	require.NoError(t, err)
+
+	time.Sleep(10 * time.Millisecond)
+
+	holder, _, err := standby.Current()
+	require.NoError(t, err)
+	require.Equal(t, "", holder)
+
+	epoch, err := standby.Acquire()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), epoch)
+}
+
+func TestElection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lease-tests-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	one := lease.NewElection(lease.New(path.Join(dir, "lease"), "one", 30*time.Millisecond))
+	two := lease.NewElection(lease.New(path.Join(dir, "lease"), "two", 30*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		one.Run(ctx)
+		close(done)
+	}()
+	waitFor(t, one.IsLeader)
+
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	go two.Run(ctx2)
+
+	// the leader keeps renewing its lease
+	time.Sleep(100 * time.Millisecond)
+	require.True(t, one.IsLeader())
+	require.False(t, two.IsLeader())
+
+	cancel()
+	<-done
+	require.False(t, one.IsLeader())
+	waitFor(t, two.IsLeader)
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	for i := 0; i < 100; i++ {
+		if condition() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for condition")
+}
@@ -0,0 +1,228 @@
+// Package logging writes leveled, structured log records, either as text or
+// as JSON objects (one per line), which log aggregation systems can ingest
+// without parsing free-form messages.
+//
+// A record consists of a time, a level, a message, and any number of fields.
+// Loggers created with With() add their fields to every record they write.
+// This is used to attach request ids to everything that is logged while
+// handling a request, so that requests can be correlated with jobs.
+//
+// Like the standard library's log package, the package has a default logger,
+// which is used by code that doesn't get a logger passed in.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warning", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return strconv.Itoa(int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level called `name`, e.g., "info".
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if n == strings.ToLower(name) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level: %s", name)
+}
+
+type Format int
+
+const (
+	FormatText Format = iota
+	FormatJSON
+)
+
+// ParseFormat returns the format called `name`, "text" or "json".
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "text":
+		return FormatText, nil
+	case "json":
+		return FormatJSON, nil
+	default:
+		return 0, fmt.Errorf("unknown log format: %s", name)
+	}
+}
+
+// A Logger writes records of at least its level to an output. It is safe to
+// use from multiple goroutines.
+type Logger struct {
+	out    *output
+	level  Level
+	fields []field
+}
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+type output struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format Format
+}
+
+// New returns a logger which writes records of `level` and higher to `out`.
+func New(out io.Writer, format Format, level Level) *Logger {
+	return &Logger{
+		out:   &output{w: out, format: format},
+		level: level,
+	}
+}
+
+// Discard returns a logger which doesn't write anything.
+func Discard() *Logger {
+	return New(ioutil.Discard, FormatText, LevelError+1)
+}
+
+var (
+	defaultMu     sync.Mutex
+	defaultLogger = New(os.Stderr, FormatText, LevelInfo)
+)
+
+// Default returns the default logger, which writes text to stderr unless it
+// was replaced with SetDefault().
+func Default() *Logger {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	return defaultLogger
+}
+
+// SetDefault replaces the default logger.
+func SetDefault(l *Logger) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	defaultLogger = l
+}
+
+// With returns a logger which adds the given fields to every record. `kv`
+// alternates between field names and values.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]field, len(l.fields), len(l.fields)+len(kv)/2)
+	copy(fields, l.fields)
+	fields = appendFields(fields, kv)
+
+	return &Logger{
+		out:    l.out,
+		level:  l.level,
+		fields: fields,
+	}
+}
+
+// Enabled returns true if records of `level` are written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+func (l *Logger) Debug(msg string, kv ...interface{}) {
+	l.log(LevelDebug, msg, kv)
+}
+
+func (l *Logger) Info(msg string, kv ...interface{}) {
+	l.log(LevelInfo, msg, kv)
+}
+
+func (l *Logger) Warning(msg string, kv ...interface{}) {
+	l.log(LevelWarning, msg, kv)
+}
+
+func (l *Logger) Error(msg string, kv ...interface{}) {
+	l.log(LevelError, msg, kv)
+}
+
+// Fatal writes an error record and exits the program.
+func (l *Logger) Fatal(msg string, kv ...interface{}) {
+	l.log(LevelError, msg, kv)
+	os.Exit(1)
+}
+
+func appendFields(fields []field, kv []interface{}) []field {
+	for i := 0; i < len(kv); i += 2 {
+		key := fmt.Sprint(kv[i])
+		var value interface{} = "MISSING"
+		if i+1 < len(kv) {
+			value = kv[i+1]
+		}
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		if s, ok := value.(fmt.Stringer); ok {
+			value = s.String()
+		}
+		fields = append(fields, field{key, value})
+	}
+	return fields
+}
+
+func (l *Logger) log(level Level, msg string, kv []interface{}) {
+	if l == nil || !l.Enabled(level) {
+		return
+	}
+
+	fields := appendFields(append([]field{}, l.fields...), kv)
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+
+	var buf bytes.Buffer
+	switch l.out.format {
+	case FormatJSON:
+		record := map[string]interface{}{
+			"time":  now,
+			"level": level.String(),
+			"msg":   msg,
+		}
+		for _, f := range fields {
+			record[f.key] = f.value
+		}
+		// json.Encoder sorts keys and appends a newline
+		err := json.NewEncoder(&buf).Encode(record)
+		if err != nil {
+			buf.Reset()
+			fmt.Fprintf(&buf, "{\"time\":%q,\"level\":\"error\",\"msg\":%q}\n", now, "cannot encode log record: "+err.Error())
+		}
+
+	default:
+		fmt.Fprintf(&buf, "%s %s %s", now, strings.ToUpper(level.String()), msg)
+		for _, f := range fields {
+			value := fmt.Sprint(f.value)
+			if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+				value = strconv.Quote(value)
+			}
+			fmt.Fprintf(&buf, " %s=%s", f.key, value)
+		}
+		buf.WriteByte('\n')
+	}
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	_, _ = l.out.w.Write(buf.Bytes())
+}
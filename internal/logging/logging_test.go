@@ -0,0 +1,71 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/logging"
+)
+
+func TestParse(t *testing.T) {
+	level, err := logging.ParseLevel("WARNING")
+	require.NoError(t, err)
+	require.Equal(t, logging.LevelWarning, level)
+
+	_, err = logging.ParseLevel("loud")
+	require.Error(t, err)
+
+	format, err := logging.ParseFormat("json")
+	require.NoError(t, err)
+	require.Equal(t, logging.FormatJSON, format)
+
+	_, err = logging.ParseFormat("xml")
+	require.Error(t, err)
+}
+
+func TestText(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf, logging.FormatText, logging.LevelInfo)
+
+	logger.Debug("hidden")
+	require.Empty(t, buf.String())
+
+	logger.With("request_id", "42").Warning("job failed", "job_id", "abc", "error", errors.New("out of disk"))
+	line := buf.String()
+	require.True(t, strings.HasSuffix(line, ` WARNING job failed request_id=42 job_id=abc error="out of disk"`+"\n"), line)
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf, logging.FormatJSON, logging.LevelDebug)
+
+	logger.With("request_id", "42").Debug("request", "status", 200)
+
+	var record map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &record)
+	require.NoError(t, err)
+	require.Equal(t, "debug", record["level"])
+	require.Equal(t, "request", record["msg"])
+	require.Equal(t, "42", record["request_id"])
+	require.Equal(t, float64(200), record["status"])
+	require.NotEmpty(t, record["time"])
+}
+
+func TestRequestID(t *testing.T) {
+	request := httptest.NewRequest("GET", "/", nil)
+	require.NotEmpty(t, logging.RequestID(request))
+
+	request.Header.Set(logging.RequestIDHeader, "my-id")
+	require.Equal(t, "my-id", logging.RequestID(request))
+
+	logger := logging.Discard()
+	ctx := logging.NewContext(request.Context(), logger)
+	require.Equal(t, logger, logging.FromContext(ctx))
+	require.Equal(t, logging.Default(), logging.FromContext(request.Context()))
+}
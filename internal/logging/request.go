@@ -0,0 +1,36 @@
+package logging
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader is the header carrying a request's id. Clients may set it
+// to correlate their own logs with the server's.
+const RequestIDHeader = "X-Request-Id"
+
+// RequestID returns the id a client sent with `request`, or a new one if it
+// didn't send any.
+func RequestID(request *http.Request) string {
+	if id := request.Header.Get(RequestIDHeader); id != "" && len(id) <= 128 {
+		return id
+	}
+	return uuid.New().String()
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying `l`.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger carried by `ctx`, or the default logger.
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return Default()
+}
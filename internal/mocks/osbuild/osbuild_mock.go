@@ -0,0 +1,182 @@
+// Package osbuild_mock provides a fake osbuild executor. It does not build
+// anything, but fabricates a plausible result, log and artifact for each
+// manifest it is given, so that the worker, the job queue, the store and the
+// APIs can be exercised end-to-end without root privileges.
+package osbuild_mock
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/osbuild"
+)
+
+type OSBuildMock struct {
+	// Fail makes every run report a failed assembler, like osbuild does
+	// when an image cannot be assembled. The result is returned without
+	// an error; callers must check its `Success` field.
+	Fail bool
+}
+
+func NewOSBuildMock(fail bool) *OSBuildMock {
+	return &OSBuildMock{Fail: fail}
+}
+
+// The JSON format osbuild prints on stdout with `--json`. The types in
+// package common are not exported, so the result is assembled here and
+// unmarshaled into a common.ComposeResult.
+type mockStage struct {
+	Name    string      `json:"name"`
+	Options interface{} `json:"options"`
+	Success bool        `json:"success"`
+	Output  string      `json:"output"`
+}
+
+type mockBuild struct {
+	Stages  []mockStage `json:"stages"`
+	TreeID  string      `json:"tree_id"`
+	Success bool        `json:"success"`
+}
+
+type mockResult struct {
+	TreeID    string      `json:"tree_id"`
+	OutputID  string      `json:"output_id"`
+	Build     *mockBuild  `json:"build,omitempty"`
+	Stages    []mockStage `json:"stages"`
+	Assembler *mockStage  `json:"assembler,omitempty"`
+	Success   bool        `json:"success"`
+}
+
+// RunOSBuild pretends to run `manifest`. The tree and output ids are derived
+// from the manifest, so running the same manifest twice yields the same ids.
+// The artifact named by the assembler is written to `refs/<output-id>/` in
+// `store`, just like osbuild would.
+func (m *OSBuildMock) RunOSBuild(manifest *osbuild.Manifest, store string, errorWriter io.Writer) (*common.ComposeResult, error) {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding osbuild pipeline: %v", err)
+	}
+
+	result := mockResult{
+		TreeID:   hashOf("tree", data),
+		OutputID: hashOf("output", data),
+		Stages:   mockStages(manifest.Pipeline.Stages, errorWriter),
+		Success:  !m.Fail,
+	}
+
+	if build := manifest.Pipeline.Build; build != nil && build.Pipeline != nil {
+		buildData, err := json.Marshal(build.Pipeline)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding osbuild build pipeline: %v", err)
+		}
+		result.Build = &mockBuild{
+			Stages:  mockStages(build.Pipeline.Stages, errorWriter),
+			TreeID:  hashOf("tree", buildData),
+			Success: true,
+		}
+	}
+
+	if assembler := manifest.Pipeline.Assembler; assembler != nil {
+		result.Assembler = &mockStage{
+			Name:    assembler.Name,
+			Options: assembler.Options,
+			Success: !m.Fail,
+			Output:  fmt.Sprintf("mock: assembled %s\n", assembler.Name),
+		}
+		fmt.Fprintf(errorWriter, "mock: assembler %s\n", assembler.Name)
+
+		if !m.Fail {
+			err = writeArtifact(path.Join(store, "refs", result.OutputID), assemblerFilename(assembler), data)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var cr common.ComposeResult
+	data, err = json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &cr)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding osbuild output: %#v", err)
+	}
+
+	return &cr, nil
+}
+
+func mockStages(stages []*osbuild.Stage, errorWriter io.Writer) []mockStage {
+	result := []mockStage{}
+	for _, stage := range stages {
+		fmt.Fprintf(errorWriter, "mock: stage %s\n", stage.Name)
+		result = append(result, mockStage{
+			Name:    stage.Name,
+			Options: stage.Options,
+			Success: true,
+			Output:  fmt.Sprintf("mock: ran %s\n", stage.Name),
+		})
+	}
+	return result
+}
+
+func hashOf(kind string, data []byte) string {
+	h := sha256.New()
+	_, _ = h.Write([]byte(kind))
+	_, _ = h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func assemblerFilename(assembler *osbuild.Assembler) string {
+	switch options := assembler.Options.(type) {
+	case *osbuild.QEMUAssemblerOptions:
+		return options.Filename
+	case *osbuild.TarAssemblerOptions:
+		return options.Filename
+	case *osbuild.RawFSAssemblerOptions:
+		return options.Filename
+	}
+	return ""
+}
+
+// Writes a small file which stands in for the real image. It contains the
+// manifest the image was "built" from, which comes in handy when debugging
+// tests, wrapped in just enough of the image format that its name indicates
+// to pass composer's format check.
+func writeArtifact(dir, filename string, manifest []byte) error {
+	if filename == "" {
+		return nil
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("error creating output directory: %v", err)
+	}
+
+	var data []byte
+	switch path.Ext(filename) {
+	case ".qcow2":
+		data = append([]byte("QFI\xfb"), manifest...)
+	case ".vhdx":
+		data = append([]byte("vhdxfile"), manifest...)
+	case ".vmdk":
+		data = append([]byte("KDMV"), manifest...)
+	case ".xz":
+		data = append([]byte("\xfd7zXZ\x00"), manifest...)
+	case ".vhd":
+		footer := make([]byte, 512)
+		copy(footer, "conectix")
+		data = append(append([]byte{}, manifest...), footer...)
+	default:
+		data = manifest
+	}
+
+	return ioutil.WriteFile(path.Join(dir, filename), data, 0644)
+}
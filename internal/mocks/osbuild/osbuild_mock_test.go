@@ -0,0 +1,66 @@
+package osbuild_mock
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/osbuild"
+)
+
+func testManifest() *osbuild.Manifest {
+	return &osbuild.Manifest{
+		Pipeline: osbuild.Pipeline{
+			Build: &osbuild.Build{
+				Pipeline: &osbuild.Pipeline{Stages: []*osbuild.Stage{osbuild.NewFixBLSStage()}},
+				Runner:   "org.osbuild.fedora32",
+			},
+			Stages: []*osbuild.Stage{osbuild.NewFixBLSStage()},
+			Assembler: osbuild.NewQEMUAssembler(&osbuild.QEMUAssemblerOptions{
+				Format:   "qcow2",
+				Filename: "disk.qcow2",
+			}),
+		},
+	}
+}
+
+func TestRunOSBuild(t *testing.T) {
+	store, err := ioutil.TempDir("", "osbuild-mock-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(store)
+
+	var log bytes.Buffer
+	result, err := NewOSBuildMock(false).RunOSBuild(testManifest(), store, &log)
+	require.NoError(t, err)
+	require.True(t, result.Success)
+	require.Len(t, result.Stages, 1)
+	require.NotNil(t, result.Build)
+	require.Contains(t, log.String(), "mock: assembler org.osbuild.qemu")
+
+	image, err := ioutil.ReadFile(path.Join(store, "refs", result.OutputID, "disk.qcow2"))
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(image, []byte("QFI\xfb")))
+
+	// the same manifest results in the same ids
+	again, err := NewOSBuildMock(false).RunOSBuild(testManifest(), store, ioutil.Discard)
+	require.NoError(t, err)
+	require.Equal(t, result.TreeID, again.TreeID)
+	require.Equal(t, result.OutputID, again.OutputID)
+}
+
+func TestRunOSBuildFails(t *testing.T) {
+	store, err := ioutil.TempDir("", "osbuild-mock-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(store)
+
+	result, err := NewOSBuildMock(true).RunOSBuild(testManifest(), store, ioutil.Discard)
+	require.NoError(t, err)
+	require.False(t, result.Success)
+
+	_, err = os.Stat(path.Join(store, "refs"))
+	require.True(t, os.IsNotExist(err))
+}
@@ -153,7 +153,7 @@ func createBaseStoreFixture() *store.Store {
 }
 
 func createBaseWorkersFixture() *worker.Server {
-	return worker.NewServer(nil, testjobqueue.New(), nil)
+	return worker.NewServer(nil, testjobqueue.New(), nil, "")
 }
 
 func createBaseDepsolveFixture() []rpmmd.PackageSpec {
@@ -36,6 +36,10 @@ func (r *rpmmdMock) FetchMetadata(repos []rpmmd.RepoConfig, modulePlatformID str
 	return r.Fixture.fetchPackageList.ret, r.Fixture.fetchPackageList.checksums, r.Fixture.fetchPackageList.err
 }
 
-func (r *rpmmdMock) Depsolve(specs, excludeSpecs []string, repos []rpmmd.RepoConfig, modulePlatformID, arch string) ([]rpmmd.PackageSpec, map[string]string, error) {
+func (r *rpmmdMock) FetchChecksums(repos []rpmmd.RepoConfig, modulePlatformID string, arch string) (map[string]string, error) {
+	return r.Fixture.fetchPackageList.checksums, r.Fixture.fetchPackageList.err
+}
+
+func (r *rpmmdMock) Depsolve(specs, excludeSpecs, moduleSpecs []string, repos []rpmmd.RepoConfig, modulePlatformID, arch string) ([]rpmmd.PackageSpec, map[string]string, error) {
 	return r.Fixture.depsolve.ret, r.Fixture.fetchPackageList.checksums, r.Fixture.depsolve.err
 }
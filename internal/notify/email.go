@@ -0,0 +1,143 @@
+package notify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// EmailConfig configures an EmailNotifier. It is usually loaded from a TOML
+// file:
+//
+//	server = "smtp.example.com:587"
+//	from = "composer@example.com"
+//	recipients = ["images@example.com"]
+//
+//	[blueprints.base-image]
+//	recipients = ["base-image-owners@example.com"]
+//
+// Composes of blueprints that are listed in `blueprints` are announced to the
+// recipients given there, all others to the default recipients. Subject and
+// body are Go templates which are executed on an Event.
+type EmailConfig struct {
+	Server     string                     `toml:"server"`
+	Username   string                     `toml:"username"`
+	Password   string                     `toml:"password"`
+	From       string                     `toml:"from"`
+	Recipients []string                   `toml:"recipients"`
+	Subject    string                     `toml:"subject"`
+	Body       string                     `toml:"body"`
+	Blueprints map[string]BlueprintConfig `toml:"blueprints"`
+}
+
+type BlueprintConfig struct {
+	Recipients []string `toml:"recipients"`
+}
+
+const defaultSubject = `Compose of {{.Blueprint}} {{if eq .Status "FINISHED"}}finished{{else}}failed{{end}}`
+
+const defaultBody = `Compose {{.ComposeID}} of blueprint {{.Blueprint}} ({{.BlueprintVersion}}) {{if eq .Status "FINISHED"}}finished successfully{{else}}failed{{end}} at {{.Finished.Format "2006-01-02 15:04:05 MST"}}.
+
+Image type: {{.ImageType}}
+`
+
+func LoadEmailConfig(path string) (*EmailConfig, error) {
+	var config EmailConfig
+	_, err := toml.DecodeFile(path, &config)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load email configuration: %v", err)
+	}
+
+	return &config, nil
+}
+
+// An EmailNotifier sends events as emails via SMTP. The connection is
+// upgraded with STARTTLS if the server supports it.
+type EmailNotifier struct {
+	config  EmailConfig
+	subject *template.Template
+	body    *template.Template
+
+	// overridden in tests
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func NewEmailNotifier(config EmailConfig) (*EmailNotifier, error) {
+	if config.Server == "" || config.From == "" {
+		return nil, errors.New("email notifications require a server and a sender")
+	}
+
+	if config.Subject == "" {
+		config.Subject = defaultSubject
+	}
+	if config.Body == "" {
+		config.Body = defaultBody
+	}
+
+	subject, err := template.New("subject").Parse(config.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject template: %v", err)
+	}
+
+	body, err := template.New("body").Parse(config.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body template: %v", err)
+	}
+
+	return &EmailNotifier{config, subject, body, smtp.SendMail}, nil
+}
+
+// Recipients returns the addresses which are notified about composes of
+// `blueprint`.
+func (n *EmailNotifier) Recipients(blueprint string) []string {
+	if bp, exists := n.config.Blueprints[blueprint]; exists {
+		return bp.Recipients
+	}
+
+	return n.config.Recipients
+}
+
+func (n *EmailNotifier) Notify(event Event) error {
+	to := n.Recipients(event.Blueprint)
+	if len(to) == 0 {
+		return nil
+	}
+
+	var subject, body bytes.Buffer
+	err := n.subject.Execute(&subject, event)
+	if err != nil {
+		return err
+	}
+	err = n.body.Execute(&body, event)
+	if err != nil {
+		return err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	// headers must not contain line breaks
+	fmt.Fprintf(&msg, "Subject: %s\r\n", strings.Join(strings.Fields(subject.String()), " "))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&msg, "\r\n")
+	msg.WriteString(strings.Replace(body.String(), "\n", "\r\n", -1))
+
+	var auth smtp.Auth
+	if n.config.Username != "" {
+		host, _, err := net.SplitHostPort(n.config.Server)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", n.config.Username, n.config.Password, host)
+	}
+
+	return n.sendMail(n.config.Server, auth, n.config.From, to, msg.Bytes())
+}
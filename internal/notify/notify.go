@@ -0,0 +1,101 @@
+// Package notify tells people when their composes have finished.
+//
+// A Watcher periodically looks for composes that finished or failed since it
+// was created and passes an Event for each of them to its notifiers. Each
+// compose is only announced once.
+package notify
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+// An Event describes a compose that has finished or failed.
+type Event struct {
+	ComposeID        uuid.UUID
+	Blueprint        string
+	BlueprintVersion string
+	ImageType        string
+	Status           string
+	Finished         time.Time
+}
+
+// A Notifier delivers events to people.
+type Notifier interface {
+	Notify(event Event) error
+}
+
+type Watcher struct {
+	notifiers []Notifier
+	store     *store.Store
+	workers   *worker.Server
+	since     time.Time
+}
+
+// NewWatcher creates a watcher which notifies `notifiers` about composes
+// that finish from now on. Composes that finished earlier are ignored, so
+// that a newly configured notifier doesn't announce all old composes.
+func NewWatcher(store *store.Store, workers *worker.Server, notifiers ...Notifier) *Watcher {
+	return &Watcher{notifiers, store, workers, time.Now()}
+}
+
+// Check sends notifications for all composes that have finished since the
+// last call. Composes for which a notifier failed are retried on the next
+// call.
+func (w *Watcher) Check() error {
+	var errs []error
+
+	for id, c := range w.store.GetAllComposes() {
+		if c.Notified || len(c.ImageBuilds) == 0 || c.ImageBuilds[0].JobId == uuid.Nil {
+			continue
+		}
+
+		state, _, _, finished := w.workers.ComposeState(c)
+		if (state != common.CFinished && state != common.CFailed) || finished.Before(w.since) {
+			continue
+		}
+
+		event := Event{
+			ComposeID: id,
+			Status:    state.ToString(),
+			Finished:  finished,
+		}
+		if c.Blueprint != nil {
+			event.Blueprint = c.Blueprint.Name
+			event.BlueprintVersion = c.Blueprint.Version
+		}
+		event.ImageType, _ = c.ImageBuilds[0].ImageType.ToCompatString()
+
+		failed := false
+		for _, n := range w.notifiers {
+			err := n.Notify(event)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("compose %s: %v", id, err))
+				failed = true
+			}
+		}
+		if failed {
+			continue
+		}
+
+		err := w.store.SetComposeNotified(id)
+		if err != nil {
+			if _, ok := err.(*store.NotFoundError); ok {
+				continue
+			}
+			return err
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d notification(s) failed, first error: %v", len(errs), errs[0])
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/smtp"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/distro/fedoratest"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue/fsjobqueue"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+type mail struct {
+	addr string
+	from string
+	to   []string
+	msg  string
+}
+
+func TestEmailNotifier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notify-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	configPath := path.Join(dir, "email.toml")
+	err = ioutil.WriteFile(configPath, []byte(`
+server = "smtp.example.com:25"
+from = "composer@example.com"
+recipients = ["all@example.com"]
+subject = "{{.Blueprint}}: {{.Status}}"
+
+[blueprints.octopus]
+recipients = ["octopus@example.com", "squid@example.com"]
+`), 0600)
+	require.NoError(t, err)
+
+	config, err := LoadEmailConfig(configPath)
+	require.NoError(t, err)
+	n, err := NewEmailNotifier(*config)
+	require.NoError(t, err)
+
+	var sent []mail
+	n.sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		require.Nil(t, a)
+		sent = append(sent, mail{addr, from, to, string(msg)})
+		return nil
+	}
+
+	require.Equal(t, []string{"all@example.com"}, n.Recipients("clownfish"))
+
+	err = n.Notify(Event{
+		ComposeID: uuid.MustParse("30000000-0000-0000-0000-000000000000"),
+		Blueprint: "octopus",
+		Status:    "FAILED",
+		Finished:  time.Now(),
+	})
+	require.NoError(t, err)
+
+	require.Len(t, sent, 1)
+	require.Equal(t, "smtp.example.com:25", sent[0].addr)
+	require.Equal(t, "composer@example.com", sent[0].from)
+	require.Equal(t, []string{"octopus@example.com", "squid@example.com"}, sent[0].to)
+	require.Contains(t, sent[0].msg, "To: octopus@example.com, squid@example.com\r\n")
+	require.Contains(t, sent[0].msg, "Subject: octopus: FAILED\r\n")
+	require.Contains(t, sent[0].msg, "Compose 30000000-0000-0000-0000-000000000000 of blueprint octopus")
+	require.Contains(t, sent[0].msg, ") failed at ")
+
+	_, err = NewEmailNotifier(EmailConfig{Server: "smtp.example.com:25"})
+	require.Error(t, err)
+	_, err = NewEmailNotifier(EmailConfig{Server: "smtp.example.com:25", From: "composer@example.com", Subject: "{{"})
+	require.Error(t, err)
+}
+
+type fakeNotifier struct {
+	err    error
+	events []Event
+}
+
+func (n *fakeNotifier) Notify(event Event) error {
+	n.events = append(n.events, event)
+	return n.err
+}
+
+func TestWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notify-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	jobs, err := fsjobqueue.New(dir)
+	require.NoError(t, err)
+	workers := worker.NewServer(nil, jobs, nil, dir)
+	s := store.New(nil)
+
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+
+	// pushes a compose and lets its job succeed or fail
+	compose := func(success bool) uuid.UUID {
+		jobId, err := workers.Enqueue("fedoratest", "x86_64", nil, nil, nil, jobqueue.PriorityNormal)
+		require.NoError(t, err)
+		id := uuid.New()
+		err = s.PushCompose(id, nil, imageType, &blueprint.Blueprint{Name: "octopus"}, 0, nil, nil, jobId)
+		require.NoError(t, err)
+
+		_, err = jobs.Dequeue(context.Background(), []string{"osbuild:x86_64/fedoratest"}, &worker.OSBuildJob{})
+		require.NoError(t, err)
+		err = jobs.FinishJob(jobId, worker.OSBuildJobResult{OSBuildOutput: &common.ComposeResult{Success: success}})
+		require.NoError(t, err)
+
+		return id
+	}
+
+	compose(true)
+
+	notifier := &fakeNotifier{err: errors.New("no route to mail server")}
+	w := NewWatcher(s, workers, notifier)
+
+	// composes that finished before the watcher was created are ignored
+	require.NoError(t, w.Check())
+	require.Empty(t, notifier.events)
+
+	id := compose(false)
+	require.Error(t, w.Check())
+	require.Len(t, notifier.events, 1)
+	require.Equal(t, id, notifier.events[0].ComposeID)
+	require.Equal(t, "FAILED", notifier.events[0].Status)
+	require.Equal(t, "octopus", notifier.events[0].Blueprint)
+
+	// failed notifications are retried, but successful ones aren't
+	notifier.err = nil
+	require.NoError(t, w.Check())
+	require.Len(t, notifier.events, 2)
+	require.NoError(t, w.Check())
+	require.Len(t, notifier.events, 2)
+
+	c, _ := s.GetCompose(id)
+	require.True(t, c.Notified)
+}
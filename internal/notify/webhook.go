@@ -0,0 +1,365 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/target"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+// Events that webhooks are called for
+const (
+	WebhookComposeStarted  = "compose.started"
+	WebhookComposeFinished = "compose.finished"
+	WebhookComposeFailed   = "compose.failed"
+	WebhookUploadFinished  = "upload.finished"
+)
+
+var WebhookEvents = []string{WebhookComposeStarted, WebhookComposeFinished, WebhookComposeFailed, WebhookUploadFinished}
+
+// IsWebhookEvent returns true if webhooks can be called for `event`.
+func IsWebhookEvent(event string) bool {
+	for _, e := range WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// How often a callback is attempted before it is given up on, and how long
+// to wait before the first retry. The delay doubles with every attempt.
+const (
+	maxWebhookAttempts = 5
+	webhookRetryDelay  = 30 * time.Second
+)
+
+// How long a webhook may take to accept a callback. Callbacks are posted
+// from the maintenance loop, which a slow webhook must not hold up.
+const webhookTimeout = 10 * time.Second
+
+// A WebhookCallback is posted as JSON to webhooks. It is signed with the
+// webhook's secret, if it has one: the X-Composer-Signature header contains
+// "sha256=" followed by the hex-encoded HMAC-SHA256 of the body.
+type WebhookCallback struct {
+	Event            string         `json:"event"`
+	ComposeID        uuid.UUID      `json:"compose_id"`
+	Blueprint        string         `json:"blueprint,omitempty"`
+	BlueprintVersion string         `json:"blueprint_version,omitempty"`
+	ImageType        string         `json:"image_type,omitempty"`
+	Status           string         `json:"status"`
+	Time             time.Time      `json:"time"`
+	Upload           *WebhookUpload `json:"upload,omitempty"`
+}
+
+// A WebhookUpload is the upload that an upload.finished callback is about:
+// either one of the compose's upload targets or a promotion.
+type WebhookUpload struct {
+	UUID      uuid.UUID `json:"uuid,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	ImageName string    `json:"image_name,omitempty"`
+	Stage     string    `json:"stage,omitempty"`
+}
+
+type webhookAttempts struct {
+	count int
+	next  time.Time
+}
+
+// A webhookPost is a callback that is due to be posted to a webhook.
+type webhookPost struct {
+	composeID uuid.UUID
+	delivery  string
+	callback  WebhookCallback
+
+	posted bool
+	err    error
+}
+
+// A WebhookDispatcher periodically looks for state transitions of composes
+// and posts a callback about each of them to the webhooks that want it.
+// Callbacks that fail are retried with increasing delays. Which callbacks
+// were delivered is recorded in the compose, so that each is only sent once.
+type WebhookDispatcher struct {
+	store   *store.Store
+	workers *worker.Server
+	client  *http.Client
+
+	// Failed callbacks, by delivery
+	attempts map[string]*webhookAttempts
+
+	// Composes which won't go through any further transitions and whose
+	// callbacks were all delivered, with their number of promotions. They
+	// are looked at again when they are promoted or the webhooks change.
+	settled map[uuid.UUID]int
+	hookIDs string
+}
+
+func NewWebhookDispatcher(store *store.Store, workers *worker.Server) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		store:    store,
+		workers:  workers,
+		client:   &http.Client{Timeout: webhookTimeout},
+		attempts: make(map[string]*webhookAttempts),
+		settled:  make(map[uuid.UUID]int),
+	}
+}
+
+// Check posts the callbacks about all state transitions that happened since
+// the last call, and retries those that failed and are due. Callbacks to
+// different webhooks are posted concurrently, and those to the same webhook
+// in order. A webhook's remaining callbacks are postponed to the next call
+// when one fails.
+func (d *WebhookDispatcher) Check() error {
+	hooks := d.store.GetWebhooks()
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	var ids []string
+	for _, hook := range hooks {
+		ids = append(ids, hook.ID.String())
+	}
+	if hookIDs := strings.Join(ids, ","); hookIDs != d.hookIDs {
+		d.hookIDs = hookIDs
+		d.settled = make(map[uuid.UUID]int)
+	}
+
+	now := time.Now()
+
+	// Only settled composes which weren't promoted since are skipped
+	exists := make(map[uuid.UUID]bool)
+	composes := d.store.FilterComposes(func(id uuid.UUID, c *compose.Compose) bool {
+		exists[id] = true
+		if len(c.ImageBuilds) == 0 || c.ImageBuilds[0].JobId == uuid.Nil {
+			return false
+		}
+		promotions, settled := d.settled[id]
+		return !settled || promotions != len(c.Promotions)
+	})
+	for id := range d.settled {
+		if !exists[id] {
+			delete(d.settled, id)
+		}
+	}
+
+	posts := make(map[uuid.UUID][]*webhookPost)
+	for id, c := range composes {
+		delivered := make(map[string]bool)
+		for _, delivery := range c.WebhooksDelivered {
+			delivered[delivery] = true
+		}
+
+		callbacks, final := d.callbacks(id, c)
+		settled := final
+		for _, callback := range callbacks {
+			for _, hook := range hooks {
+				if (hook.ComposeID != uuid.Nil && hook.ComposeID != id) || !hook.Wants(callback.Event) || callback.Time.Before(hook.Created) {
+					continue
+				}
+
+				delivery := webhookDelivery(hook, callback)
+				if delivered[delivery] {
+					continue
+				}
+
+				settled = false
+				attempts := d.attempts[delivery]
+				if attempts != nil && now.Before(attempts.next) {
+					continue
+				}
+
+				posts[hook.ID] = append(posts[hook.ID], &webhookPost{composeID: id, delivery: delivery, callback: callback})
+			}
+		}
+
+		if settled {
+			d.settled[id] = len(c.Promotions)
+		} else {
+			delete(d.settled, id)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, hook := range hooks {
+		if len(posts[hook.ID]) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func(hook store.Webhook, hookPosts []*webhookPost) {
+			defer wg.Done()
+			for _, p := range hookPosts {
+				p.posted = true
+				p.err = d.post(hook, p.delivery, p.callback)
+				if p.err != nil {
+					return
+				}
+			}
+		}(hook, posts[hook.ID])
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, hook := range hooks {
+		for _, p := range posts[hook.ID] {
+			if !p.posted {
+				break
+			}
+			if p.err != nil {
+				attempts := d.attempts[p.delivery]
+				if attempts == nil {
+					attempts = &webhookAttempts{}
+					d.attempts[p.delivery] = attempts
+				}
+				attempts.count++
+				if attempts.count < maxWebhookAttempts {
+					attempts.next = now.Add(webhookRetryDelay << uint(attempts.count-1))
+					errs = append(errs, fmt.Errorf("compose %s: webhook %s: %v", p.composeID, hook.ID, p.err))
+					continue
+				}
+				log.Printf("giving up on %s callback about compose %s to webhook %s after %d attempts: %v", p.callback.Event, p.composeID, hook.ID, attempts.count, p.err)
+			}
+			delete(d.attempts, p.delivery)
+
+			err := d.store.SetWebhookDelivered(p.composeID, p.delivery)
+			if err != nil {
+				if _, ok := err.(*store.NotFoundError); ok {
+					continue
+				}
+				return err
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d webhook callback(s) failed, first error: %v", len(errs), errs[0])
+	}
+
+	return nil
+}
+
+// callbacks returns the callbacks about all state transitions that compose
+// `c` went through, in the order they happened, and whether it won't go
+// through any further ones, unless it is promoted again.
+func (d *WebhookDispatcher) callbacks(id uuid.UUID, c compose.Compose) ([]WebhookCallback, bool) {
+	state, _, started, finished := d.workers.ComposeState(c)
+
+	base := WebhookCallback{
+		ComposeID: id,
+		Status:    state.ToString(),
+	}
+	if c.Blueprint != nil {
+		base.Blueprint = c.Blueprint.Name
+		base.BlueprintVersion = c.Blueprint.Version
+	}
+	base.ImageType, _ = c.ImageBuilds[0].ImageType.ToCompatString()
+
+	var callbacks []WebhookCallback
+	add := func(event string, when time.Time, upload *WebhookUpload) {
+		callback := base
+		callback.Event = event
+		callback.Time = when
+		callback.Upload = upload
+		callbacks = append(callbacks, callback)
+	}
+
+	if state == common.CWaiting || started.IsZero() {
+		return nil, false
+	}
+	add(WebhookComposeStarted, started, nil)
+
+	switch state {
+	case common.CFinished:
+		for _, ib := range c.ImageBuilds {
+			for _, t := range ib.Targets {
+				if _, local := t.Options.(*target.LocalTargetOptions); local {
+					continue
+				}
+				add(WebhookUploadFinished, finished, &WebhookUpload{UUID: t.Uuid, Name: t.Name, ImageName: t.ImageName})
+			}
+		}
+		add(WebhookComposeFinished, finished, nil)
+	case common.CFailed:
+		add(WebhookComposeFailed, finished, nil)
+	}
+
+	final := state == common.CFinished || state == common.CFailed
+	for _, p := range c.Promotions {
+		switch p.Status {
+		case common.IBFinished:
+			add(WebhookUploadFinished, p.FinishedAt, &WebhookUpload{UUID: p.JobId, Stage: p.Stage})
+		case common.IBFailed:
+		default:
+			final = false
+		}
+	}
+
+	return callbacks, final
+}
+
+// webhookDelivery returns the name under which the delivery of `callback`
+// to `hook` is recorded.
+func webhookDelivery(hook store.Webhook, callback WebhookCallback) string {
+	delivery := hook.ID.String() + "/" + callback.Event
+	if callback.Upload != nil {
+		delivery += "/" + callback.Upload.UUID.String()
+	}
+	return delivery
+}
+
+func (d *WebhookDispatcher) post(hook store.Webhook, delivery string, callback WebhookCallback) error {
+	body, err := json.Marshal(callback)
+	if err != nil {
+		panic(err)
+	}
+
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Composer-Event", callback.Event)
+	req.Header.Set("X-Composer-Delivery", callback.ComposeID.String()+"/"+delivery)
+	if hook.Secret != "" {
+		req.Header.Set("X-Composer-Signature", "sha256="+WebhookSignature(hook.Secret, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", hook.URL, resp.Status)
+	}
+
+	return nil
+}
+
+// WebhookSignature returns the hex-encoded HMAC-SHA256 of `body` with
+// `secret`, for receivers to check callbacks against.
+func WebhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
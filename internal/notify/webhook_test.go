@@ -0,0 +1,275 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/distro/fedoratest"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue/fsjobqueue"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/target"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+func TestWebhookDispatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notify-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	jobs, err := fsjobqueue.New(dir)
+	require.NoError(t, err)
+	workers := worker.NewServer(nil, jobs, nil, dir)
+	s := store.New(nil)
+
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+
+	// a receiver which fails while `status` is not 200
+	status := http.StatusOK
+	var received []WebhookCallback
+	var signatures []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, err := ioutil.ReadAll(request.Body)
+		require.NoError(t, err)
+		var callback WebhookCallback
+		require.NoError(t, json.Unmarshal(body, &callback))
+		require.Equal(t, callback.Event, request.Header.Get("X-Composer-Event"))
+
+		if status == http.StatusOK {
+			received = append(received, callback)
+			signatures = append(signatures, request.Header.Get("X-Composer-Signature"))
+			if request.Header.Get("X-Composer-Signature") != "" {
+				require.Equal(t, "sha256="+WebhookSignature("octopus", body), request.Header.Get("X-Composer-Signature"))
+			}
+		}
+		writer.WriteHeader(status)
+	}))
+	defer receiver.Close()
+
+	d := NewWebhookDispatcher(s, workers)
+
+	// pushes a compose uploading to aws and starts its job
+	start := func() (uuid.UUID, uuid.UUID) {
+		jobId, err := workers.Enqueue("fedoratest", "x86_64", nil, nil, nil, jobqueue.PriorityNormal)
+		require.NoError(t, err)
+		id := uuid.New()
+		targets := []*target.Target{target.NewAWSTarget(&target.AWSTargetOptions{Region: "eu-central-1"})}
+		err = s.PushCompose(id, nil, imageType, &blueprint.Blueprint{Name: "octopus"}, 0, targets, nil, jobId)
+		require.NoError(t, err)
+
+		_, err = jobs.Dequeue(context.Background(), []string{"osbuild:x86_64/fedoratest"}, &worker.OSBuildJob{})
+		require.NoError(t, err)
+
+		return id, jobId
+	}
+	finish := func(jobId uuid.UUID, success bool) {
+		err := jobs.FinishJob(jobId, worker.OSBuildJobResult{OSBuildOutput: &common.ComposeResult{Success: success}})
+		require.NoError(t, err)
+	}
+	events := func() []string {
+		var events []string
+		for _, c := range received {
+			events = append(events, c.Event)
+		}
+		return events
+	}
+
+	// transitions that happened before a webhook was added are ignored
+	start()
+	time.Sleep(10 * time.Millisecond)
+
+	hook := store.Webhook{ID: uuid.New(), URL: receiver.URL, Secret: "octopus", Created: time.Now()}
+	require.NoError(t, s.AddWebhook(hook))
+	require.NoError(t, d.Check())
+	require.Empty(t, received)
+
+	id, jobId := start()
+	require.NoError(t, d.Check())
+	require.Equal(t, []string{WebhookComposeStarted}, events())
+	require.Equal(t, id, received[0].ComposeID)
+	require.Equal(t, "octopus", received[0].Blueprint)
+	require.Equal(t, "RUNNING", received[0].Status)
+
+	// callbacks are only sent once
+	require.NoError(t, d.Check())
+	require.Len(t, received, 1)
+
+	finish(jobId, true)
+	require.NoError(t, d.Check())
+	require.Equal(t, []string{WebhookComposeStarted, WebhookUploadFinished, WebhookComposeFinished}, events())
+	require.Equal(t, "org.osbuild.aws", received[1].Upload.Name)
+	require.Equal(t, "FINISHED", received[2].Status)
+	require.Equal(t, "sha256="+WebhookSignature("octopus", mustMarshal(t, received[2])), signatures[2])
+
+	// webhooks for a single compose only want some events
+	received = nil
+	id, jobId = start()
+	require.NoError(t, s.AddWebhook(store.Webhook{ID: uuid.New(), URL: receiver.URL, ComposeID: id, Events: []string{WebhookComposeFailed}, Created: time.Now().Add(-time.Minute)}))
+	require.NoError(t, s.DeleteWebhook(hook.ID))
+
+	// failed callbacks are retried when they are due
+	status = http.StatusServiceUnavailable
+	finish(jobId, false)
+	require.Error(t, d.Check())
+	require.NoError(t, d.Check())
+	status = http.StatusOK
+	require.NoError(t, d.Check())
+	require.Empty(t, received)
+
+	for _, attempts := range d.attempts {
+		attempts.next = time.Now()
+	}
+	require.NoError(t, d.Check())
+	require.Equal(t, []string{WebhookComposeFailed}, events())
+	require.Empty(t, d.attempts)
+
+	// webhooks of a compose are removed along with it
+	require.NoError(t, s.DeleteCompose(id))
+	require.Empty(t, s.GetWebhooks())
+}
+
+func TestWebhookGiveUp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notify-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	jobs, err := fsjobqueue.New(dir)
+	require.NoError(t, err)
+	workers := worker.NewServer(nil, jobs, nil, dir)
+	s := store.New(nil)
+
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+
+	calls := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		calls++
+		writer.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	require.NoError(t, s.AddWebhook(store.Webhook{ID: uuid.New(), URL: receiver.URL, Events: []string{WebhookComposeStarted}, Created: time.Now()}))
+
+	jobId, err := workers.Enqueue("fedoratest", "x86_64", nil, nil, nil, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+	id := uuid.New()
+	err = s.PushCompose(id, nil, imageType, &blueprint.Blueprint{Name: "octopus"}, 0, nil, nil, jobId)
+	require.NoError(t, err)
+	_, err = jobs.Dequeue(context.Background(), []string{"osbuild:x86_64/fedoratest"}, &worker.OSBuildJob{})
+	require.NoError(t, err)
+
+	d := NewWebhookDispatcher(s, workers)
+	for i := 0; i < maxWebhookAttempts; i++ {
+		for _, attempts := range d.attempts {
+			attempts.next = time.Now()
+		}
+		_ = d.Check()
+	}
+	require.Equal(t, maxWebhookAttempts, calls)
+
+	c, _ := s.GetCompose(id)
+	require.Len(t, c.WebhooksDelivered, 1)
+	require.NoError(t, d.Check())
+	require.Equal(t, maxWebhookAttempts, calls)
+}
+
+func TestWebhookConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notify-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	jobs, err := fsjobqueue.New(dir)
+	require.NoError(t, err)
+	workers := worker.NewServer(nil, jobs, nil, dir)
+	s := store.New(nil)
+
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+
+	// the slow webhook only answers once the fast one was called
+	fastCalled := make(chan struct{})
+	var slowCalls, fastCalls int32
+	slow := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		atomic.AddInt32(&slowCalls, 1)
+		select {
+		case <-fastCalled:
+		case <-time.After(5 * time.Second):
+			writer.WriteHeader(http.StatusGatewayTimeout)
+		}
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if atomic.AddInt32(&fastCalls, 1) == 1 {
+			close(fastCalled)
+		}
+	}))
+	defer fast.Close()
+
+	created := time.Now()
+	require.NoError(t, s.AddWebhook(store.Webhook{ID: uuid.New(), URL: slow.URL, Created: created.Add(-2 * time.Millisecond)}))
+	require.NoError(t, s.AddWebhook(store.Webhook{ID: uuid.New(), URL: fast.URL, Created: created.Add(-time.Millisecond)}))
+
+	jobId, err := workers.Enqueue("fedoratest", "x86_64", nil, nil, nil, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+	id := uuid.New()
+	err = s.PushCompose(id, nil, imageType, &blueprint.Blueprint{Name: "octopus"}, 0, nil, nil, jobId)
+	require.NoError(t, err)
+	_, err = jobs.Dequeue(context.Background(), []string{"osbuild:x86_64/fedoratest"}, &worker.OSBuildJob{})
+	require.NoError(t, err)
+
+	d := NewWebhookDispatcher(s, workers)
+	require.NoError(t, d.Check())
+	require.Equal(t, int32(1), atomic.LoadInt32(&slowCalls))
+	require.Equal(t, int32(1), atomic.LoadInt32(&fastCalls))
+	require.Empty(t, d.settled)
+
+	// composes whose callbacks were all delivered are skipped
+	err = jobs.FinishJob(jobId, worker.OSBuildJobResult{OSBuildOutput: &common.ComposeResult{Success: true}})
+	require.NoError(t, err)
+	require.NoError(t, d.Check())
+	require.NoError(t, d.Check())
+	require.Contains(t, d.settled, id)
+	require.Equal(t, int32(2), atomic.LoadInt32(&fastCalls))
+
+	// until they are promoted
+	promotionId := uuid.New()
+	require.NoError(t, s.AddPromotion(id, compose.Promotion{Stage: "prod", JobId: promotionId, Status: common.IBRunning, RequestedAt: time.Now()}))
+	require.NoError(t, d.Check())
+	require.NotContains(t, d.settled, id)
+	require.NoError(t, s.FinishPromotion(id, promotionId, nil))
+	require.NoError(t, d.Check())
+	require.NoError(t, d.Check())
+	require.Contains(t, d.settled, id)
+	require.Equal(t, int32(3), atomic.LoadInt32(&fastCalls))
+
+	// or deleted
+	require.NoError(t, s.DeleteCompose(id))
+	require.NoError(t, d.Check())
+	require.Empty(t, d.settled)
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return data
+}
@@ -0,0 +1,20 @@
+package osbuild
+
+// The AnacondaStageOptions describe how to configure the Anaconda installer
+// in the tree.
+//
+// KickstartModules are the names of the D-Bus modules of Anaconda which are
+// enabled, e.g., "org.fedoraproject.Anaconda.Modules.Payloads".
+type AnacondaStageOptions struct {
+	KickstartModules []string `json:"kickstart-modules"`
+}
+
+func (AnacondaStageOptions) isStageOptions() {}
+
+// NewAnacondaStage creates a new Anaconda Stage object.
+func NewAnacondaStage(options *AnacondaStageOptions) *Stage {
+	return &Stage{
+		Name:    "org.osbuild.anaconda",
+		Options: options,
+	}
+}
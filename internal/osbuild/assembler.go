@@ -38,6 +38,10 @@ func (assembler *Assembler) UnmarshalJSON(data []byte) error {
 		options = new(QEMUAssemblerOptions)
 	case "org.osbuild.rawfs":
 		options = new(RawFSAssemblerOptions)
+	case "org.osbuild.ostree.commit":
+		options = new(OSTreeCommitAssemblerOptions)
+	case "org.osbuild.bootiso":
+		options = new(BootISOAssemblerOptions)
 	default:
 		return errors.New("unexpected assembler name")
 	}
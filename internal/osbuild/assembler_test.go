@@ -108,6 +108,32 @@ func TestAssembler_UnmarshalJSON(t *testing.T) {
 			},
 			data: []byte(`{"name":"org.osbuild.rawfs","options":{"filename":"filesystem.img","root_fs_uuid":"76a22bf4-f153-4541-b6c7-0332c0dfaeac","size":2147483648}}`),
 		},
+		{
+			name: "ostree commit assembler",
+			assembler: Assembler{
+				Name: "org.osbuild.ostree.commit",
+				Options: &OSTreeCommitAssemblerOptions{
+					Ref:    "fedora/32/x86_64/iot",
+					Parent: "2e1b2b4f2b12bd2b0c7d6bd51a7bb07ca5d8ff5d1b8d2e2d9a8a39b8d9c81c4e",
+					Tar: OSTreeCommitAssemblerTarOptions{
+						Filename: "commit.tar",
+					},
+				},
+			},
+			data: []byte(`{"name":"org.osbuild.ostree.commit","options":{"ref":"fedora/32/x86_64/iot","parent":"2e1b2b4f2b12bd2b0c7d6bd51a7bb07ca5d8ff5d1b8d2e2d9a8a39b8d9c81c4e","tar":{"filename":"commit.tar"}}}`),
+		},
+		{
+			name: "bootiso assembler",
+			assembler: Assembler{
+				Name: "org.osbuild.bootiso",
+				Options: &BootISOAssemblerOptions{
+					Filename: "installer.iso",
+					Product:  BootISOProduct{Name: "Fedora", Version: "32"},
+					ISOLabel: "Fedora-32-x86_64",
+				},
+			},
+			data: []byte(`{"name":"org.osbuild.bootiso","options":{"filename":"installer.iso","product":{"name":"Fedora","version":"32"},"isolabel":"Fedora-32-x86_64"}}`),
+		},
 	}
 
 	assert := assert.New(t)
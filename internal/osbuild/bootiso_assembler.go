@@ -0,0 +1,30 @@
+package osbuild
+
+// BootISOAssemblerOptions describe how to assemble a tree into a bootable
+// ISO image.
+//
+// The assembler boots the kernel and initramfs of the tree from the image,
+// and uses a squashfs of the tree as the root filesystem, like the
+// installation media of Fedora. The image is labeled ISOLabel, which must be
+// the label the initramfs looks for.
+type BootISOAssemblerOptions struct {
+	Filename string         `json:"filename"`
+	Product  BootISOProduct `json:"product"`
+	ISOLabel string         `json:"isolabel"`
+	Kernel   string         `json:"kernel,omitempty"`
+}
+
+type BootISOProduct struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+func (BootISOAssemblerOptions) isAssemblerOptions() {}
+
+// NewBootISOAssembler creates a new BootISO Assembler object.
+func NewBootISOAssembler(options *BootISOAssemblerOptions) *Assembler {
+	return &Assembler{
+		Name:    "org.osbuild.bootiso",
+		Options: options,
+	}
+}
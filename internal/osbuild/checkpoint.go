@@ -0,0 +1,40 @@
+package osbuild
+
+import "fmt"
+
+// Checkpoints are intermediate results of a pipeline, which can be exported
+// in addition to the image for debugging. The only checkpoint is "tree", the
+// filesystem tree after all stages ran, before it is assembled into the image.
+var checkpointFilenames = map[string]string{
+	"tree": "tree.tar",
+}
+
+// IsCheckpoint returns true if `name` is a known checkpoint.
+func IsCheckpoint(name string) bool {
+	_, exists := checkpointFilenames[name]
+	return exists
+}
+
+// CheckpointFilename returns the name of the file that exports checkpoint
+// `name`, or an empty string if it is unknown.
+func CheckpointFilename(name string) string {
+	return checkpointFilenames[name]
+}
+
+// CheckpointManifest returns a manifest which exports checkpoint `name` of
+// `m`, and the name of the file it produces. It shares the sources and all
+// stages with `m`, so that osbuild can reuse the tree of a previous build of
+// `m` from its store.
+func (m *Manifest) CheckpointManifest(name string) (*Manifest, string, error) {
+	filename, exists := checkpointFilenames[name]
+	if !exists {
+		return nil, "", fmt.Errorf("unknown checkpoint: %s", name)
+	}
+
+	checkpoint := *m
+	checkpoint.Pipeline.Assembler = NewTarAssembler(&TarAssemblerOptions{
+		Filename: filename,
+	})
+
+	return &checkpoint, filename, nil
+}
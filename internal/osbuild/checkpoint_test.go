@@ -0,0 +1,34 @@
+package osbuild
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpointManifest(t *testing.T) {
+	manifest := &Manifest{
+		Pipeline: Pipeline{
+			Stages:    []*Stage{{Name: "org.osbuild.rpm"}},
+			Assembler: NewQEMUAssembler(&QEMUAssemblerOptions{Format: "qcow2", Filename: "disk.qcow2"}),
+		},
+	}
+
+	assert.True(t, IsCheckpoint("tree"))
+	assert.False(t, IsCheckpoint("image"))
+	assert.Equal(t, "tree.tar", CheckpointFilename("tree"))
+	assert.Equal(t, "", CheckpointFilename("image"))
+
+	checkpoint, filename, err := manifest.CheckpointManifest("tree")
+	require.NoError(t, err)
+	assert.Equal(t, "tree.tar", filename)
+	assert.Equal(t, manifest.Pipeline.Stages, checkpoint.Pipeline.Stages)
+	assert.Equal(t, NewTarAssembler(&TarAssemblerOptions{Filename: "tree.tar"}), checkpoint.Pipeline.Assembler)
+
+	// the original manifest is unchanged
+	assert.Equal(t, "org.osbuild.qemu", manifest.Pipeline.Assembler.Name)
+
+	_, _, err = manifest.CheckpointManifest("image")
+	assert.Error(t, err)
+}
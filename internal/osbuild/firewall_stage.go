@@ -1,9 +1,17 @@
 package osbuild
 
 type FirewallStageOptions struct {
-	Ports            []string `json:"ports,omitempty"`
-	EnabledServices  []string `json:"enabled_services,omitempty"`
-	DisabledServices []string `json:"disabled_services,omitempty"`
+	Ports            []string       `json:"ports,omitempty"`
+	EnabledServices  []string       `json:"enabled_services,omitempty"`
+	DisabledServices []string       `json:"disabled_services,omitempty"`
+	Zones            []FirewallZone `json:"zones,omitempty"`
+}
+
+// A FirewallZone binds traffic from its sources, which are addresses,
+// networks, MAC addresses, or ipsets, to the firewalld zone Name.
+type FirewallZone struct {
+	Name    string   `json:"name"`
+	Sources []string `json:"sources,omitempty"`
 }
 
 func (FirewallStageOptions) isStageOptions() {}
@@ -16,6 +16,10 @@ type GRUB2StageOptions struct {
 	KernelOptions      string     `json:"kernel_opts,omitempty"`
 	Legacy             bool       `json:"legacy"`
 	UEFI               *GRUB2UEFI `json:"uefi,omitempty"`
+
+	// The boot loader entry to boot by default, named after the machine id
+	// and kernel version
+	SavedEntry string `json:"saved_entry,omitempty"`
 }
 
 type GRUB2UEFI struct {
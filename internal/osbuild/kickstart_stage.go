@@ -0,0 +1,26 @@
+package osbuild
+
+// The KickstartStageOptions describe the kickstart file which makes Anaconda
+// install a system unattended.
+//
+// The file is written to Path in the tree. If LiveIMG is set, the system is
+// installed from the image at its URL, which is a tar ball or a filesystem
+// image of the system's root filesystem.
+type KickstartStageOptions struct {
+	Path    string                   `json:"path"`
+	LiveIMG *KickstartLiveIMGOptions `json:"liveimg,omitempty"`
+}
+
+type KickstartLiveIMGOptions struct {
+	URL string `json:"url"`
+}
+
+func (KickstartStageOptions) isStageOptions() {}
+
+// NewKickstartStage creates a new Kickstart Stage object.
+func NewKickstartStage(options *KickstartStageOptions) *Stage {
+	return &Stage{
+		Name:    "org.osbuild.kickstart",
+		Options: options,
+	}
+}
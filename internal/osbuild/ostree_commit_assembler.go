@@ -0,0 +1,27 @@
+package osbuild
+
+// OSTreeCommitAssemblerOptions describe how to assemble a tree into an ostree
+// commit.
+//
+// The assembler commits the tree to a new ostree repository as `Ref`, on top
+// of the commit with the checksum `Parent`, if it is set. The repository is
+// stored as a tar ball with the given filename.
+type OSTreeCommitAssemblerOptions struct {
+	Ref    string                          `json:"ref"`
+	Parent string                          `json:"parent,omitempty"`
+	Tar    OSTreeCommitAssemblerTarOptions `json:"tar"`
+}
+
+type OSTreeCommitAssemblerTarOptions struct {
+	Filename string `json:"filename"`
+}
+
+func (OSTreeCommitAssemblerOptions) isAssemblerOptions() {}
+
+// NewOSTreeCommitAssembler creates a new OSTree Commit Assembler object.
+func NewOSTreeCommitAssembler(options *OSTreeCommitAssemblerOptions) *Assembler {
+	return &Assembler{
+		Name:    "org.osbuild.ostree.commit",
+		Options: options,
+	}
+}
@@ -0,0 +1,63 @@
+package osbuild
+
+// The PayloadStageOptions describe an image that is embedded in the tree as
+// the payload of an installer.
+//
+// The image is taken from the files source by its Checksum and copied to
+// /payload/<Filename> in the tree. Composer only knows the name of the
+// payload when it creates the manifest, because the payload is built by
+// another job. The worker sets the checksum when it adds the image of that
+// job to the sources (see Manifest.SetPayload).
+type PayloadStageOptions struct {
+	Filename string `json:"filename"`
+	Checksum string `json:"checksum,omitempty"`
+}
+
+func (PayloadStageOptions) isStageOptions() {}
+
+// NewPayloadStage creates a new Payload Stage object.
+func NewPayloadStage(options *PayloadStageOptions) *Stage {
+	return &Stage{
+		Name:    "org.osbuild.payload",
+		Options: options,
+	}
+}
+
+// PayloadPath returns the path at which a payload named `filename` is
+// embedded in the tree.
+func PayloadPath(filename string) string {
+	return "/payload/" + filename
+}
+
+// HasPayload returns whether the pipeline of `m` embeds a payload.
+func (m *Manifest) HasPayload() bool {
+	for _, stage := range m.Pipeline.Stages {
+		if _, ok := stage.Options.(*PayloadStageOptions); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SetPayload makes the image with `checksum`, which can be fetched from
+// `url`, the payload embedded by the pipeline of `m`.
+func (m *Manifest) SetPayload(checksum, url string) {
+	for _, stage := range m.Pipeline.Stages {
+		if options, ok := stage.Options.(*PayloadStageOptions); ok {
+			options.Checksum = checksum
+		}
+	}
+
+	if m.Sources == nil {
+		m.Sources = Sources{}
+	}
+	files, ok := m.Sources["org.osbuild.files"].(*FilesSource)
+	if !ok {
+		files = &FilesSource{}
+		m.Sources["org.osbuild.files"] = files
+	}
+	if files.URLs == nil {
+		files.URLs = make(map[string]string)
+	}
+	files.URLs[checksum] = url
+}
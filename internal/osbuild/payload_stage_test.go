@@ -0,0 +1,36 @@
+package osbuild
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetPayload(t *testing.T) {
+	m := Manifest{
+		Sources: Sources{
+			"org.osbuild.files": &FilesSource{
+				URLs: map[string]string{"sha256:aaa": "https://example.com/a.rpm"},
+			},
+		},
+	}
+	m.Pipeline.AddStage(NewLocaleStage(&LocaleStageOptions{Language: "en_US"}))
+	assert.False(t, m.HasPayload())
+
+	payload := &PayloadStageOptions{Filename: "root.tar.xz"}
+	m.Pipeline.AddStage(NewPayloadStage(payload))
+	assert.True(t, m.HasPayload())
+
+	m.SetPayload("sha256:bbb", "file:///var/tmp/root.tar.xz")
+	assert.Equal(t, "sha256:bbb", payload.Checksum)
+	assert.Equal(t, map[string]string{
+		"sha256:aaa": "https://example.com/a.rpm",
+		"sha256:bbb": "file:///var/tmp/root.tar.xz",
+	}, m.Sources["org.osbuild.files"].(*FilesSource).URLs)
+
+	// manifests without sources get a files source
+	m = Manifest{}
+	m.Pipeline.AddStage(NewPayloadStage(&PayloadStageOptions{Filename: "root.tar.xz"}))
+	m.SetPayload("sha256:bbb", "file:///var/tmp/root.tar.xz")
+	assert.Equal(t, "file:///var/tmp/root.tar.xz", m.Sources["org.osbuild.files"].(*FilesSource).URLs["sha256:bbb"])
+}
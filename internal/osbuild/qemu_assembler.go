@@ -1,6 +1,10 @@
 package osbuild
 
-import "github.com/google/uuid"
+import (
+	"errors"
+
+	"github.com/google/uuid"
+)
 
 // QEMUAssemblerOptions desrcibe how to assemble a tree into an image using qemu.
 //
@@ -42,3 +46,61 @@ func NewQEMUAssembler(options *QEMUAssemblerOptions) *Assembler {
 		Options: options,
 	}
 }
+
+const (
+	sectorSize = 512
+
+	// Partitions are aligned to 1 MiB
+	partitionAlignment = 2048
+)
+
+// Namespace of the UUIDs of filesystems that are added with AddPartition
+var filesystemNamespace = uuid.MustParse("9f5bd6fe-e5e5-4d3a-a8ee-a8b4c8a4d1e4")
+
+// GrowRootPartition makes the image large enough for the last partition,
+// which extends to the end of the image, to have at least `size` bytes.
+func (options *QEMUAssemblerOptions) GrowRootPartition(size uint64) {
+	if len(options.Partitions) == 0 {
+		return
+	}
+	root := options.Partitions[len(options.Partitions)-1]
+	if min := root.Start*sectorSize + size; options.Size < min {
+		options.Size = min
+	}
+}
+
+// AddPartition inserts a partition of at least `size` bytes in front of the
+// last partition, which is the root partition filling the rest of the image,
+// and grows the image by the same amount. The partition is formatted with a
+// filesystem of `fsType`, which is mounted at `mountpoint`. Its UUID is
+// derived from the mountpoint, so that manifests stay reproducible, and
+// returned.
+func (options *QEMUAssemblerOptions) AddPartition(fsType, mountpoint string, size uint64) (string, error) {
+	if len(options.Partitions) == 0 {
+		return "", errors.New("partition table has no root partition")
+	}
+	if options.PTType == "mbr" && len(options.Partitions) >= 4 {
+		return "", errors.New("mbr partition tables cannot have more than 4 partitions")
+	}
+
+	sectors := (size + sectorSize - 1) / sectorSize
+	sectors = (sectors + partitionAlignment - 1) / partitionAlignment * partitionAlignment
+
+	id := uuid.NewSHA1(filesystemNamespace, []byte(mountpoint)).String()
+	last := len(options.Partitions) - 1
+	partition := QEMUPartition{
+		Start: options.Partitions[last].Start,
+		Size:  sectors,
+		Filesystem: QEMUFilesystem{
+			Type:       fsType,
+			UUID:       id,
+			Mountpoint: mountpoint,
+		},
+	}
+
+	options.Partitions[last].Start += sectors
+	options.Partitions = append(options.Partitions[:last], partition, options.Partitions[last])
+	options.Size += sectors * sectorSize
+
+	return id, nil
+}
@@ -0,0 +1,68 @@
+package osbuild
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQEMUAssemblerAddPartition(t *testing.T) {
+	const MiB = 1024 * 1024
+
+	options := QEMUAssemblerOptions{
+		Size:   4096 * MiB,
+		PTType: "mbr",
+		Partitions: []QEMUPartition{
+			{
+				Start:      2048,
+				Bootable:   true,
+				Filesystem: QEMUFilesystem{Type: "xfs", UUID: "0bd700f8-090f-4556-b797-b340297ea1bd", Mountpoint: "/"},
+			},
+		},
+	}
+
+	id, err := options.AddPartition("xfs", "/var", 1000*MiB+1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4096+1001)*MiB, options.Size)
+	require.Equal(t, []QEMUPartition{
+		{
+			Start:      2048,
+			Size:       1001 * 2048,
+			Filesystem: QEMUFilesystem{Type: "xfs", UUID: id, Mountpoint: "/var"},
+		},
+		{
+			Start:      2048 + 1001*2048,
+			Bootable:   true,
+			Filesystem: QEMUFilesystem{Type: "xfs", UUID: "0bd700f8-090f-4556-b797-b340297ea1bd", Mountpoint: "/"},
+		},
+	}, options.Partitions)
+
+	// filesystem uuids only depend on the mountpoint
+	other := QEMUAssemblerOptions{Partitions: []QEMUPartition{{Start: 2048}}}
+	otherID, err := other.AddPartition("ext4", "/var", MiB)
+	require.NoError(t, err)
+	require.Equal(t, id, otherID)
+
+	_, err = options.AddPartition("xfs", "/home", MiB)
+	require.NoError(t, err)
+	_, err = options.AddPartition("xfs", "/srv", MiB)
+	require.NoError(t, err)
+	_, err = options.AddPartition("xfs", "/opt", MiB)
+	require.Error(t, err)
+
+	_, err = (&QEMUAssemblerOptions{}).AddPartition("xfs", "/var", MiB)
+	require.Error(t, err)
+}
+
+func TestQEMUAssemblerGrowRootPartition(t *testing.T) {
+	options := QEMUAssemblerOptions{
+		Size:       4 * 1024 * 1024,
+		Partitions: []QEMUPartition{{Start: 2048}},
+	}
+
+	options.GrowRootPartition(1024)
+	require.Equal(t, uint64(4*1024*1024), options.Size)
+
+	options.GrowRootPartition(4 * 1024 * 1024)
+	require.Equal(t, uint64(2048*512+4*1024*1024), options.Size)
+}
@@ -0,0 +1,22 @@
+package osbuild
+
+// The RPMOSTreeStageOptions describe how to prepare a tree to be committed to
+// an ostree repository.
+//
+// The stage moves the rpm database and /etc to the places rpm-ostree
+// expects, and turns system users and groups into ones that are created
+// when the system boots, except for the members of the groups in
+// EtcGroupMembers, which stay in /etc/group.
+type RPMOSTreeStageOptions struct {
+	EtcGroupMembers []string `json:"etc_group_members,omitempty"`
+}
+
+func (RPMOSTreeStageOptions) isStageOptions() {}
+
+// NewRPMOSTreeStage creates a new rpm-ostree Stage object.
+func NewRPMOSTreeStage(options *RPMOSTreeStageOptions) *Stage {
+	return &Stage{
+		Name:    "org.osbuild.rpm-ostree",
+		Options: options,
+	}
+}
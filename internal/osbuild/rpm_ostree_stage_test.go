@@ -0,0 +1,16 @@
+package osbuild
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRPMOSTreeStage(t *testing.T) {
+	expectedStage := &Stage{
+		Name:    "org.osbuild.rpm-ostree",
+		Options: &RPMOSTreeStageOptions{},
+	}
+	actualStage := NewRPMOSTreeStage(&RPMOSTreeStageOptions{})
+	assert.Equal(t, expectedStage, actualStage)
+}
@@ -62,10 +62,18 @@ func (stage *Stage) UnmarshalJSON(data []byte) error {
 		options = new(FirewallStageOptions)
 	case "org.osbuild.rpm":
 		options = new(RPMStageOptions)
+	case "org.osbuild.rpm-ostree":
+		options = new(RPMOSTreeStageOptions)
 	case "org.osbuild.systemd":
 		options = new(SystemdStageOptions)
 	case "org.osbuild.script":
 		options = new(ScriptStageOptions)
+	case "org.osbuild.anaconda":
+		options = new(AnacondaStageOptions)
+	case "org.osbuild.kickstart":
+		options = new(KickstartStageOptions)
+	case "org.osbuild.payload":
+		options = new(PayloadStageOptions)
 	default:
 		return fmt.Errorf("unexpected stage name: %s", rawStage.Name)
 	}
@@ -195,6 +195,55 @@ func TestStage_UnmarshalJSON(t *testing.T) {
 				data: []byte(`{"name":"org.osbuild.rpm","options":{"gpgkeys":["key1","key2"],"packages":["checksum1","checksum2"]}}`),
 			},
 		},
+		{
+			name: "rpm-ostree",
+			fields: fields{
+				Name: "org.osbuild.rpm-ostree",
+				Options: &RPMOSTreeStageOptions{
+					EtcGroupMembers: []string{"wheel", "docker"},
+				},
+			},
+			args: args{
+				data: []byte(`{"name":"org.osbuild.rpm-ostree","options":{"etc_group_members":["wheel","docker"]}}`),
+			},
+		},
+		{
+			name: "anaconda",
+			fields: fields{
+				Name: "org.osbuild.anaconda",
+				Options: &AnacondaStageOptions{
+					KickstartModules: []string{"org.fedoraproject.Anaconda.Modules.Payloads"},
+				},
+			},
+			args: args{
+				data: []byte(`{"name":"org.osbuild.anaconda","options":{"kickstart-modules":["org.fedoraproject.Anaconda.Modules.Payloads"]}}`),
+			},
+		},
+		{
+			name: "kickstart",
+			fields: fields{
+				Name: "org.osbuild.kickstart",
+				Options: &KickstartStageOptions{
+					Path:    "/usr/share/anaconda/interactive-defaults.ks",
+					LiveIMG: &KickstartLiveIMGOptions{URL: "file:///payload/root.tar.xz"},
+				},
+			},
+			args: args{
+				data: []byte(`{"name":"org.osbuild.kickstart","options":{"path":"/usr/share/anaconda/interactive-defaults.ks","liveimg":{"url":"file:///payload/root.tar.xz"}}}`),
+			},
+		},
+		{
+			name: "payload",
+			fields: fields{
+				Name: "org.osbuild.payload",
+				Options: &PayloadStageOptions{
+					Filename: "root.tar.xz",
+				},
+			},
+			args: args{
+				data: []byte(`{"name":"org.osbuild.payload","options":{"filename":"root.tar.xz"}}`),
+			},
+		},
 		{
 			name: "script",
 			fields: fields{
@@ -0,0 +1,103 @@
+// Package promotion runs promotions of finished composes: uploads of their
+// images to the targets of a pre-configured stage (e.g., "staging" or
+// "prod"), without rebuilding them.
+//
+// Promotions are requested through the Weldr API, which enqueues jobs of
+// worker.PromoteJobType. The Runner takes these jobs from the job queue,
+// uploads the image that composer stored when the compose finished, and
+// records the outcome in the compose's promotion history.
+package promotion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/logging"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/target"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+// An UploadFunc uploads the image at `path` to target `t`. upload.Upload is
+// the one used in production.
+type UploadFunc func(t *target.Target, path string, defaultKey string) error
+
+// A Runner runs promotion jobs from a job queue.
+type Runner struct {
+	jobs   jobqueue.JobQueue
+	store  *store.Tenants
+	upload UploadFunc
+}
+
+func NewRunner(jobs jobqueue.JobQueue, store *store.Tenants, upload UploadFunc) *Runner {
+	return &Runner{jobs, store, upload}
+}
+
+// Run promotes images until `ctx` is canceled.
+func (r *Runner) Run(ctx context.Context) error {
+	for {
+		var job worker.PromoteJob
+		id, err := r.jobs.Dequeue(ctx, []string{worker.PromoteJobType}, &job)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		logger := logging.Default().With("job_id", id, "compose_id", job.ComposeID, "stage", job.Stage)
+
+		var result worker.PromoteJobResult
+		promotionErr := r.promote(job)
+		if promotionErr != nil {
+			logger.Error("promotion failed", "error", promotionErr)
+			result.Error = promotionErr.Error()
+		} else {
+			logger.Info("promotion finished")
+		}
+
+		err = r.store.FinishPromotion(job.ComposeID, id, promotionErr)
+		if err != nil {
+			// the compose was deleted in the meantime
+			if _, ok := err.(*store.NotFoundError); !ok {
+				return err
+			}
+		}
+
+		err = r.jobs.FinishJob(id, result)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (r *Runner) promote(job worker.PromoteJob) error {
+	image, _, err := r.store.GetImageBuildImage(job.ComposeID, job.ImageBuildID)
+	if err != nil {
+		return err
+	}
+	defer image.Close()
+
+	f, ok := image.(*os.File)
+	if !ok {
+		return errors.New("image is not stored in a file")
+	}
+
+	var failed []string
+	for _, t := range job.Targets {
+		err := r.upload(t, f.Name(), job.ComposeID.String())
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", t.Name, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d upload(s) failed: %s", len(failed), len(job.Targets), strings.Join(failed, "; "))
+	}
+
+	return nil
+}
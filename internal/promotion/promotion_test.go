@@ -0,0 +1,117 @@
+package promotion_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/distro/fedoratest"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue/fsjobqueue"
+	"github.com/osbuild/osbuild-composer/internal/promotion"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/target"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+func TestRunner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "promotion-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	queueDir := path.Join(dir, "jobs")
+	require.NoError(t, os.Mkdir(queueDir, 0700))
+	jobs, err := fsjobqueue.New(queueDir)
+	require.NoError(t, err)
+
+	// the compose belongs to a tenant, which the runner has to find
+	tenants, err := store.NewTenants(store.New(&dir), nil)
+	require.NoError(t, err)
+	s, err := tenants.Get("web")
+	require.NoError(t, err)
+	workers := worker.NewServer(nil, jobs, tenants.AddImageToImageUpload, dir)
+
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+
+	// a compose whose image was stored by composer
+	id := uuid.New()
+	targets := []*target.Target{
+		target.NewLocalTarget(&target.LocalTargetOptions{Filename: imageType.Filename()}),
+	}
+	err = s.PushCompose(id, nil, imageType, &blueprint.Blueprint{Name: "octopus"}, 0, targets, nil, uuid.New())
+	require.NoError(t, err)
+	err = ioutil.WriteFile(path.Join(dir, "tenants", "web", "outputs", id.String(), "0", imageType.Filename()), []byte("image"), 0600)
+	require.NoError(t, err)
+
+	// uploads to azure fail, all others succeed
+	var mu sync.Mutex
+	var uploaded []string
+	upload := func(t *target.Target, imagePath string, defaultKey string) error {
+		if t.Name == "org.osbuild.azure" {
+			return errors.New("no credentials")
+		}
+
+		content, err := ioutil.ReadFile(imagePath)
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		uploaded = append(uploaded, t.Name+":"+string(content)+":"+defaultKey)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = promotion.NewRunner(jobs, tenants, upload).Run(ctx)
+	}()
+
+	// promotes compose `id` to `stage` and returns the recorded promotion
+	// after it finished
+	promote := func(stage string, targets ...*target.Target) compose.Promotion {
+		jobId, err := workers.EnqueuePromotion(id, 0, stage, targets)
+		require.NoError(t, err)
+		err = s.AddPromotion(id, compose.Promotion{Stage: stage, JobId: jobId, Status: common.IBWaiting})
+		require.NoError(t, err)
+
+		for i := 0; i < 100; i++ {
+			c, _ := s.GetCompose(id)
+			for _, p := range c.Promotions {
+				if p.JobId == jobId && p.Status != common.IBWaiting {
+					return p
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatal("promotion did not finish")
+		return compose.Promotion{}
+	}
+
+	p := promote("staging", target.NewAWSTarget(&target.AWSTargetOptions{Region: "eu-central-1"}))
+	require.Equal(t, common.IBFinished, p.Status)
+	require.Empty(t, p.Error)
+	require.False(t, p.FinishedAt.IsZero())
+	require.Equal(t, []string{"org.osbuild.aws:image:" + id.String()}, uploaded)
+
+	p = promote("prod", target.NewAzureTarget(&target.AzureTargetOptions{}))
+	require.Equal(t, common.IBFailed, p.Status)
+	require.Contains(t, p.Error, "no credentials")
+
+	c, _ := s.GetCompose(id)
+	require.Len(t, c.Promotions, 2)
+}
@@ -0,0 +1,191 @@
+// Package quota limits how much tenants and users may build.
+//
+// Limits apply to the composes of a tenant (see store.Tenants) and, within
+// it, to those of each authenticated client (see auth.Identity). The stores
+// check the usage of both when a compose is pushed (see AdmissionCheck), so
+// that they apply to composes of all APIs.
+package quota
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/store"
+)
+
+// Limits restrict the composes of a tenant or a user. Zero means no limit.
+type Limits struct {
+	// Composes which are waiting or running at the same time
+	MaxConcurrentComposes int64 `toml:"max_concurrent_composes" json:"max_concurrent_composes,omitempty"`
+
+	// Composes started in the last 24 hours
+	MaxComposesPerDay int64 `toml:"max_composes_per_day" json:"max_composes_per_day,omitempty"`
+
+	// Bytes the outputs of all composes may take up
+	MaxArtifactBytes int64 `toml:"max_artifact_bytes" json:"max_artifact_bytes,omitempty"`
+}
+
+// Usage is what a tenant or a user currently uses of its limits.
+type Usage struct {
+	ConcurrentComposes int64 `json:"concurrent_composes"`
+	ComposesPerDay     int64 `json:"composes_per_day"`
+	ArtifactBytes      int64 `json:"artifact_bytes"`
+}
+
+// Add adds `other` to the usage.
+func (u *Usage) Add(other Usage) {
+	u.ConcurrentComposes += other.ConcurrentComposes
+	u.ComposesPerDay += other.ComposesPerDay
+	u.ArtifactBytes += other.ArtifactBytes
+}
+
+// ExceededError is returned by Check when a compose would exceed a limit.
+// `Scope` is "tenant" or "user", and `Name` the tenant or user, "" for the
+// default tenant. `Limit` names the limit like the configuration does,
+// without the "max_" prefix.
+type ExceededError struct {
+	Scope string `json:"scope"`
+	Name  string `json:"name"`
+	Limit string `json:"limit"`
+	Used  int64  `json:"used"`
+	Max   int64  `json:"max"`
+}
+
+func (e *ExceededError) Error() string {
+	who := e.Scope + " " + e.Name
+	if e.Scope == "tenant" && e.Name == "" {
+		who = "the default tenant"
+	}
+	return fmt.Sprintf("%s uses %d of %d %s", who, e.Used, e.Max, e.Limit)
+}
+
+// Check returns an *ExceededError if a compose whose outputs take up to
+// `size` bytes would exceed `limits`, given the current `usage`.
+func (limits Limits) Check(scope, name string, usage Usage, size uint64) error {
+	exceeded := func(limit string, used, max int64) error {
+		return &ExceededError{Scope: scope, Name: name, Limit: limit, Used: used, Max: max}
+	}
+
+	if limits.MaxConcurrentComposes > 0 && usage.ConcurrentComposes >= limits.MaxConcurrentComposes {
+		return exceeded("concurrent_composes", usage.ConcurrentComposes, limits.MaxConcurrentComposes)
+	}
+	if limits.MaxComposesPerDay > 0 && usage.ComposesPerDay >= limits.MaxComposesPerDay {
+		return exceeded("composes_per_day", usage.ComposesPerDay, limits.MaxComposesPerDay)
+	}
+	if limits.MaxArtifactBytes > 0 && usage.ArtifactBytes+int64(size) > limits.MaxArtifactBytes {
+		return exceeded("artifact_bytes", usage.ArtifactBytes, limits.MaxArtifactBytes)
+	}
+
+	return nil
+}
+
+// ComposeUsage returns what `composes` use of the quotas, in total and by
+// the client who requested them. `state` returns the state of a compose,
+// and `diskUsage` how many bytes its outputs take up.
+func ComposeUsage(composes map[uuid.UUID]compose.Compose, state func(compose.Compose) common.ComposeState, diskUsage func(uuid.UUID) int64) (Usage, map[string]Usage) {
+	var total Usage
+	users := make(map[string]Usage)
+
+	dayAgo := time.Now().Add(-24 * time.Hour)
+	for id, c := range composes {
+		usage := Usage{ArtifactBytes: diskUsage(id)}
+
+		s := state(c)
+		if s == common.CWaiting || s == common.CRunning {
+			usage.ConcurrentComposes = 1
+		}
+		if len(c.ImageBuilds) > 0 && c.ImageBuilds[0].JobCreated.After(dayAgo) {
+			usage.ComposesPerDay = 1
+		}
+
+		total.Add(usage)
+		if c.Owner != "" {
+			userUsage := users[c.Owner]
+			userUsage.Add(usage)
+			users[c.Owner] = userUsage
+		}
+	}
+
+	return total, users
+}
+
+// Config is the quota configuration, usually loaded from a TOML file:
+//
+//	[tenant]
+//	max_concurrent_composes = 10
+//	max_artifact_bytes = 536870912000
+//
+//	[tenants.web-team]
+//	max_concurrent_composes = 20
+//
+//	[user]
+//	max_composes_per_day = 50
+//
+//	[users.ci]
+//	max_composes_per_day = 500
+//
+// `tenant` and `user` are the limits of all tenants and users which aren't
+// listed in `tenants` and `users`, respectively. The default tenant is
+// listed as "default". Clients which didn't authenticate are only limited by
+// the limits of their tenant.
+type Config struct {
+	Tenant  Limits            `toml:"tenant" json:"tenant"`
+	Tenants map[string]Limits `toml:"tenants" json:"tenants,omitempty"`
+	User    Limits            `toml:"user" json:"user"`
+	Users   map[string]Limits `toml:"users" json:"users,omitempty"`
+}
+
+func LoadConfig(path string) (*Config, error) {
+	var config Config
+	_, err := toml.DecodeFile(path, &config)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load quota configuration: %v", err)
+	}
+
+	for tenant := range config.Tenants {
+		if tenant != "default" && !store.ValidTenantName(tenant) {
+			return nil, fmt.Errorf("%s: invalid tenant name '%s'", path, tenant)
+		}
+	}
+
+	return &config, nil
+}
+
+// TenantLimits returns the limits of `tenant`, "" for the default tenant.
+func (c *Config) TenantLimits(tenant string) Limits {
+	if tenant == "" {
+		tenant = "default"
+	}
+	if limits, exists := c.Tenants[tenant]; exists {
+		return limits
+	}
+	return c.Tenant
+}
+
+// UserLimits returns the limits of the client called `user`.
+func (c *Config) UserLimits(user string) Limits {
+	if limits, exists := c.Users[user]; exists {
+		return limits
+	}
+	return c.User
+}
+
+// AdmissionCheck returns a check for store.SetAdmissionCheck, which refuses
+// composes that would exceed the limits of their tenant or of the client
+// that requested them with an *ExceededError. `state` returns the state of
+// a compose.
+func (c *Config) AdmissionCheck(state func(compose.Compose) common.ComposeState) store.AdmissionCheck {
+	return func(admission store.Admission, composes map[uuid.UUID]compose.Compose, diskUsage func(uuid.UUID) int64) error {
+		total, users := ComposeUsage(composes, state, diskUsage)
+		err := c.TenantLimits(admission.Tenant).Check("tenant", admission.Tenant, total, admission.Size)
+		if err == nil && admission.Owner != "" {
+			err = c.UserLimits(admission.Owner).Check("user", admission.Owner, users[admission.Owner], admission.Size)
+		}
+		return err
+	}
+}
@@ -0,0 +1,146 @@
+package quota_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/quota"
+	"github.com/osbuild/osbuild-composer/internal/store"
+)
+
+func TestCheck(t *testing.T) {
+	limits := quota.Limits{
+		MaxConcurrentComposes: 2,
+		MaxComposesPerDay:     10,
+		MaxArtifactBytes:      1000,
+	}
+
+	var cases = []struct {
+		Usage quota.Usage
+		Size  uint64
+		Limit string
+	}{
+		{quota.Usage{}, 1000, ""},
+		{quota.Usage{ConcurrentComposes: 1, ComposesPerDay: 9, ArtifactBytes: 500}, 500, ""},
+		{quota.Usage{ConcurrentComposes: 2}, 0, "concurrent_composes"},
+		{quota.Usage{ComposesPerDay: 10}, 0, "composes_per_day"},
+		{quota.Usage{ArtifactBytes: 500}, 501, "artifact_bytes"},
+	}
+
+	for i, c := range cases {
+		err := limits.Check("user", "ci", c.Usage, c.Size)
+		if c.Limit == "" {
+			require.NoErrorf(t, err, "case %d", i)
+			continue
+		}
+		require.IsTypef(t, &quota.ExceededError{}, err, "case %d", i)
+		exceeded := err.(*quota.ExceededError)
+		require.Equal(t, "user", exceeded.Scope)
+		require.Equal(t, "ci", exceeded.Name)
+		require.Equalf(t, c.Limit, exceeded.Limit, "case %d", i)
+	}
+
+	// no limits
+	err := quota.Limits{}.Check("tenant", "", quota.Usage{ConcurrentComposes: 100, ArtifactBytes: 1 << 40}, 1<<40)
+	require.NoError(t, err)
+
+	err = limits.Check("tenant", "", quota.Usage{ConcurrentComposes: 2}, 0)
+	require.EqualError(t, err, "the default tenant uses 2 of 2 concurrent_composes")
+}
+
+func TestAdmissionCheck(t *testing.T) {
+	running := uuid.New()
+	finished := uuid.New()
+	composes := map[uuid.UUID]compose.Compose{
+		running: {
+			Owner:       "ci",
+			ImageBuilds: []compose.ImageBuild{{JobCreated: time.Now()}},
+		},
+		finished: {
+			Owner:       "alice",
+			ImageBuilds: []compose.ImageBuild{{JobCreated: time.Now().Add(-48 * time.Hour)}},
+		},
+	}
+	state := func(c compose.Compose) common.ComposeState {
+		if c.Owner == "ci" {
+			return common.CRunning
+		}
+		return common.CFinished
+	}
+	diskUsage := func(id uuid.UUID) int64 {
+		if id == finished {
+			return 600
+		}
+		return 0
+	}
+
+	total, users := quota.ComposeUsage(composes, state, diskUsage)
+	require.Equal(t, quota.Usage{ConcurrentComposes: 1, ComposesPerDay: 1, ArtifactBytes: 600}, total)
+	require.Equal(t, quota.Usage{ConcurrentComposes: 1, ComposesPerDay: 1}, users["ci"])
+	require.Equal(t, quota.Usage{ArtifactBytes: 600}, users["alice"])
+
+	config := &quota.Config{
+		Tenant:  quota.Limits{MaxArtifactBytes: 1000},
+		Tenants: map[string]quota.Limits{"web-team": {MaxConcurrentComposes: 1}},
+		User:    quota.Limits{MaxConcurrentComposes: 1},
+	}
+	check := config.AdmissionCheck(state)
+
+	require.NoError(t, check(store.Admission{Owner: "alice", Size: 400}, composes, diskUsage))
+	require.NoError(t, check(store.Admission{Size: 400}, composes, diskUsage))
+
+	err := check(store.Admission{Owner: "alice", Size: 401}, composes, diskUsage)
+	require.Equal(t, &quota.ExceededError{Scope: "tenant", Name: "", Limit: "artifact_bytes", Used: 600, Max: 1000}, err)
+
+	err = check(store.Admission{Owner: "ci"}, composes, diskUsage)
+	require.Equal(t, &quota.ExceededError{Scope: "user", Name: "ci", Limit: "concurrent_composes", Used: 1, Max: 1}, err)
+
+	err = check(store.Admission{Tenant: "web-team"}, composes, diskUsage)
+	require.Equal(t, &quota.ExceededError{Scope: "tenant", Name: "web-team", Limit: "concurrent_composes", Used: 1, Max: 1}, err)
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "quota.toml")
+	err = ioutil.WriteFile(path, []byte(`
+[tenant]
+max_concurrent_composes = 10
+
+[tenants.default]
+max_concurrent_composes = 30
+
+[tenants.web-team]
+max_concurrent_composes = 20
+
+[user]
+max_composes_per_day = 50
+
+[users.ci]
+max_composes_per_day = 500
+`), 0600)
+	require.NoError(t, err)
+
+	config, err := quota.LoadConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, int64(30), config.TenantLimits("").MaxConcurrentComposes)
+	require.Equal(t, int64(20), config.TenantLimits("web-team").MaxConcurrentComposes)
+	require.Equal(t, int64(10), config.TenantLimits("db-team").MaxConcurrentComposes)
+	require.Equal(t, int64(500), config.UserLimits("ci").MaxComposesPerDay)
+	require.Equal(t, int64(50), config.UserLimits("alice").MaxComposesPerDay)
+
+	err = ioutil.WriteFile(path, []byte("[tenants.\"Web Team\"]\nmax_concurrent_composes = 1\n"), 0600)
+	require.NoError(t, err)
+	_, err = quota.LoadConfig(path)
+	require.Error(t, err)
+}
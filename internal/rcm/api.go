@@ -9,12 +9,16 @@ import (
 	"net"
 	"net/http"
 
+	"github.com/osbuild/osbuild-composer/internal/auth"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
 	"github.com/osbuild/osbuild-composer/internal/rpmmd"
 	"github.com/osbuild/osbuild-composer/internal/worker"
 
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
 	"github.com/osbuild/osbuild-composer/internal/distro"
+	"github.com/osbuild/osbuild-composer/internal/events"
 )
 
 // API encapsulates RCM-specific API that is exposed over a separate TCP socket
@@ -24,8 +28,9 @@ type API struct {
 	router  *httprouter.Router
 	// rpmMetadata is an interface to dnf-json and we include it here so that we can
 	// mock it in the unit tests
-	rpmMetadata rpmmd.RPMMD
-	distros     *distro.Registry
+	rpmMetadata   rpmmd.RPMMD
+	distros       *distro.Registry
+	authenticator *auth.Authenticator
 }
 
 // New creates new RCM API
@@ -61,6 +66,12 @@ func (api *API) Serve(listener net.Listener) error {
 	return nil
 }
 
+// SetAuthenticator requires clients to authenticate. Readers may query the
+// status of composes, and builders may also submit them.
+func (api *API) SetAuthenticator(authenticator *auth.Authenticator) {
+	api.authenticator = authenticator
+}
+
 // ServeHTTP logs the request, sets content-type, and forwards the request to appropriate handler
 func (api *API) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 	if api.logger != nil {
@@ -68,28 +79,63 @@ func (api *API) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 	}
 
 	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if api.authenticator != nil {
+		identity, err := api.authenticator.Authenticate(request)
+		if err != nil {
+			writer.Header().Set("WWW-Authenticate", `Bearer realm="osbuild-composer"`)
+			errorf(writer, common.ErrorUnauthorized, "authentication failed: %v", err)
+			return
+		}
+
+		role := auth.RoleBuilder
+		if request.Method == "GET" {
+			role = auth.RoleReader
+		}
+		if !identity.Role.Includes(role) {
+			errorf(writer, common.ErrorForbidden, "this request requires the %s role", role)
+			return
+		}
+	}
+
 	api.router.ServeHTTP(writer, request)
 }
 
+// errorResponse is the JSON body of all error responses. `error_reason` is
+// kept for clients written before the shared error model existed; it always
+// equals `message`.
+type errorResponse struct {
+	*common.APIError
+	ErrorReason string `json:"error_reason"`
+}
+
+func errorf(writer http.ResponseWriter, code common.APIErrorCode, format string, args ...interface{}) {
+	apiError := common.NewAPIError(code, format, args...)
+
+	writer.WriteHeader(apiError.HTTPStatus())
+	// ignore error, because we cannot do anything useful with it
+	_ = json.NewEncoder(writer).Encode(errorResponse{apiError, apiError.Message})
+}
+
 func methodNotAllowedHandler(writer http.ResponseWriter, request *http.Request) {
-	writer.WriteHeader(http.StatusMethodNotAllowed)
+	errorf(writer, common.ErrorMethodNotAllowed, "method not allowed")
 }
 
 func notFoundHandler(writer http.ResponseWriter, request *http.Request) {
-	writer.WriteHeader(http.StatusNotFound)
+	errorf(writer, common.ErrorNotFound, "not found")
 }
 
 // Depsolves packages and build packages for building an image for a given
 // distro, in the given architecture
 func depsolve(rpmmd rpmmd.RPMMD, distro distro.Distro, imageType distro.ImageType, repos []rpmmd.RepoConfig, arch distro.Arch) ([]rpmmd.PackageSpec, []rpmmd.PackageSpec, error) {
 	specs, excludeSpecs := imageType.BasePackages()
-	packages, _, err := rpmmd.Depsolve(specs, excludeSpecs, repos, distro.ModulePlatformID(), arch.Name())
+	packages, _, err := rpmmd.Depsolve(specs, excludeSpecs, nil, repos, distro.ModulePlatformID(), arch.Name())
 	if err != nil {
 		return nil, nil, fmt.Errorf("RPMMD.Depsolve: %v", err)
 	}
 
 	specs = imageType.BuildPackages()
-	buildPackages, _, err := rpmmd.Depsolve(specs, nil, repos, distro.ModulePlatformID(), arch.Name())
+	buildPackages, _, err := rpmmd.Depsolve(specs, nil, nil, repos, distro.ModulePlatformID(), arch.Name())
 	if err != nil {
 		return nil, nil, fmt.Errorf("RPMMD.Depsolve: %v", err)
 	}
@@ -98,10 +144,15 @@ func depsolve(rpmmd rpmmd.RPMMD, distro distro.Distro, imageType distro.ImageTyp
 }
 
 func (api *API) submit(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	if api.workers.Draining() {
+		errorf(writer, common.ErrorShuttingDown, "composer is shutting down and doesn't accept new composes, try again later")
+		return
+	}
+
 	// Check some basic HTTP parameters
 	contentType := request.Header["Content-Type"]
 	if len(contentType) != 1 || contentType[0] != "application/json" {
-		writer.WriteHeader(http.StatusBadRequest)
+		errorf(writer, common.ErrorInvalidRequest, "request must contain application/json data")
 		return
 	}
 
@@ -123,61 +174,37 @@ func (api *API) submit(writer http.ResponseWriter, request *http.Request, _ http
 	var composeRequest struct {
 		ImageBuilds []imageBuild `json:"image_builds"`
 	}
-	// JSON structure with error message
-	var errorReason struct {
-		Error string `json:"error_reason"`
-	}
 	// Parse and verify the structure
 	decoder := json.NewDecoder(request.Body)
 	decoder.DisallowUnknownFields()
 	err := decoder.Decode(&composeRequest)
 	if err != nil {
-		writer.WriteHeader(http.StatusBadRequest)
-		err = json.NewEncoder(writer).Encode(err.Error())
-		if err != nil {
-			panic("Failed to write response")
-		}
+		errorf(writer, common.ErrorInvalidRequest, "%v", err)
 		return
 	}
 
 	if len(composeRequest.ImageBuilds) != 1 {
-		writer.WriteHeader(http.StatusBadRequest)
-		_, err := writer.Write([]byte("unsupported number of image builds"))
-		if err != nil {
-			panic("Failed to write response")
-		}
+		errorf(writer, common.ErrorInvalidRequest, "unsupported number of image builds")
 		return
 	}
 
 	buildRequest := composeRequest.ImageBuilds[0]
 
-	distro := api.distros.GetDistro(buildRequest.Distribution)
-	if distro == nil {
-		writer.WriteHeader(http.StatusBadRequest)
-		_, err := writer.Write([]byte("unknown distro"))
-		if err != nil {
-			panic("Failed to write response")
-		}
+	d := api.distros.GetDistro(buildRequest.Distribution)
+	if d == nil {
+		errorf(writer, common.ErrorUnknownDistro, "unknown distro")
 		return
 	}
 
-	arch, err := distro.GetArch(buildRequest.Architecture)
+	arch, err := d.GetArch(buildRequest.Architecture)
 	if err != nil {
-		writer.WriteHeader(http.StatusBadRequest)
-		_, err := writer.Write([]byte("unknown architecture for distro"))
-		if err != nil {
-			panic("Failed to write response")
-		}
+		errorf(writer, common.ErrorUnknownArch, "unknown architecture for distro")
 		return
 	}
 
 	imageType, err := arch.GetImageType(buildRequest.ImageType)
 	if err != nil {
-		writer.WriteHeader(http.StatusBadRequest)
-		_, err := writer.Write([]byte("unknown image type for distro and architecture"))
-		if err != nil {
-			panic("Failed to write response")
-		}
+		errorf(writer, common.ErrorUnknownImageType, "unknown image type for distro and architecture")
 		return
 	}
 
@@ -193,39 +220,32 @@ func (api *API) submit(writer http.ResponseWriter, request *http.Request, _ http
 		})
 	}
 
-	packages, buildPackages, err := depsolve(api.rpmMetadata, distro, imageType, repoConfigs, arch)
+	packages, buildPackages, err := depsolve(api.rpmMetadata, d, imageType, repoConfigs, arch)
 	if err != nil {
-		writer.WriteHeader(http.StatusBadRequest)
-		_, err := writer.Write([]byte(err.Error()))
-		if err != nil {
-			panic("Failed to write response")
-		}
+		errorf(writer, common.ErrorDepsolveFailed, "%v", err)
 		return
 	}
 
 	size := imageType.Size(0)
-	manifest, err := imageType.Manifest(nil, repoConfigs, packages, buildPackages, size)
+	manifest, err := imageType.Manifest(nil, repoConfigs, packages, buildPackages, distro.ImageOptions{Size: size})
 	if err != nil {
-		writer.WriteHeader(http.StatusBadRequest)
-		_, err := writer.Write([]byte(err.Error()))
-		if err != nil {
-			panic("Failed to write response")
-		}
+		errorf(writer, common.ErrorManifestCreationFailed, "%v", err)
 		return
 	}
 
-	composeID, err := api.workers.Enqueue(manifest, nil)
+	composeID, err := api.workers.Enqueue(d.Name(), arch.Name(), manifest, nil, nil, jobqueue.PriorityNormal)
 	if err != nil {
 		if api.logger != nil {
 			api.logger.Println("RCM API failed to push compose:", err)
 		}
-		writer.WriteHeader(http.StatusBadRequest)
-		errorReason.Error = "failed to push compose: " + err.Error()
-		// TODO: handle error
-		_ = json.NewEncoder(writer).Encode(errorReason)
+		errorf(writer, common.ErrorInternal, "failed to push compose: %v", err)
 		return
 	}
 
+	events.Emit(events.ComposeQueued, fmt.Sprintf("Compose %s queued", composeID),
+		"COMPOSE_ID", composeID.String(),
+		"IMAGE_TYPE", imageType.Name())
+
 	// Create the response JSON structure
 	var reply struct {
 		UUID uuid.UUID `json:"compose_id"`
@@ -236,28 +256,21 @@ func (api *API) submit(writer http.ResponseWriter, request *http.Request, _ http
 }
 
 func (api *API) status(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
-	// JSON structure in case of error
-	var errorReason struct {
-		Error string `json:"error_reason"`
-	}
 	// Check that the input is a valid UUID
 	uuidParam := params.ByName("uuid")
 	id, err := uuid.Parse(uuidParam)
 	if err != nil {
-		writer.WriteHeader(http.StatusBadRequest)
-		errorReason.Error = "Malformed UUID"
-		// TODO: handle error
-		_ = json.NewEncoder(writer).Encode(errorReason)
+		errorf(writer, common.ErrorInvalidRequest, "Malformed UUID")
 		return
 	}
 
 	// Check that the compose exists
 	status, _, err := api.workers.JobResult(id)
-	if err != nil {
-		writer.WriteHeader(http.StatusBadRequest)
-		errorReason.Error = err.Error()
-		// TODO: handle error
-		_ = json.NewEncoder(writer).Encode(errorReason)
+	if err == jobqueue.ErrNotExist {
+		errorf(writer, common.ErrorComposeNotFound, "%v", err)
+		return
+	} else if err != nil {
+		errorf(writer, common.ErrorInternal, "cannot get status of compose %s: %v", id, err)
 		return
 	}
 
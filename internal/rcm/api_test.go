@@ -2,17 +2,23 @@ package rcm_test
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
 
+	"github.com/osbuild/osbuild-composer/internal/auth"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
 	"github.com/osbuild/osbuild-composer/internal/jobqueue/testjobqueue"
 	distro_mock "github.com/osbuild/osbuild-composer/internal/mocks/distro"
 	rpmmd_mock "github.com/osbuild/osbuild-composer/internal/mocks/rpmmd"
@@ -37,12 +43,21 @@ func newTestWorkerServer(t *testing.T) (*worker.Server, string) {
 	dir, err := ioutil.TempDir("", "rcm-test-")
 	require.NoError(t, err)
 
-	w := worker.NewServer(nil, testjobqueue.New(), nil)
+	w := worker.NewServer(nil, testjobqueue.New(), nil, "")
 	require.NotNil(t, w)
 
 	return w, dir
 }
 
+// brokenJobQueue fails to look up any job.
+type brokenJobQueue struct {
+	jobqueue.JobQueue
+}
+
+func (q *brokenJobQueue) JobStatus(id uuid.UUID, result interface{}) (jobqueue.JobStatus, time.Time, time.Time, time.Time, error) {
+	return 0, time.Time{}, time.Time{}, time.Time{}, errors.New("the job queue is broken")
+}
+
 func cleanupTempDir(t *testing.T, dir string) {
 	err := os.RemoveAll(dir)
 	require.NoError(t, err)
@@ -63,9 +78,9 @@ func TestBasicRcmAPI(t *testing.T) {
 		{"GET", "/v1/compose", ``, "", http.StatusMethodNotAllowed, ``},
 		{"POST", "/v1/compose", `{"status":"RUNNING"}`, "application/json", http.StatusBadRequest, ``},
 		{"POST", "/v1/compose", `{"status":"RUNNING"}`, "text/plain", http.StatusBadRequest, ``},
-		{"POST", "/v1/compose", `{"image_builds":[]}`, "application/json", http.StatusBadRequest, ""},
+		{"POST", "/v1/compose", `{"image_builds":[]}`, "application/json", http.StatusBadRequest, `"code":"INVALID_REQUEST"`},
 		{"POST", "/v1/compose/111-222-333", `{"status":"RUNNING"}`, "application/json", http.StatusMethodNotAllowed, ``},
-		{"GET", "/v1/compose/7802c476-9cd1-41b7-ba81-43c1906bce73", `{"status":"RUNNING"}`, "application/json", http.StatusBadRequest, ``},
+		{"GET", "/v1/compose/7802c476-9cd1-41b7-ba81-43c1906bce73", `{"status":"RUNNING"}`, "application/json", http.StatusNotFound, `"code":"COMPOSE_NOT_FOUND"`},
 	}
 
 	registry, err := distro_mock.NewDefaultRegistry()
@@ -309,3 +324,53 @@ func TestStatus(t *testing.T) {
 		}
 	}
 }
+
+func TestAuthentication(t *testing.T) {
+	registry, err := distro_mock.NewDefaultRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	workers, dir := newTestWorkerServer(t)
+	defer cleanupTempDir(t, dir)
+
+	api := rcm.New(nil, workers, rpmmd_mock.NewRPMMDMock(rpmmd_mock.BaseFixture()), registry)
+
+	hash := sha256.Sum256([]byte("r"))
+	config := auth.Config{
+		Tokens: []auth.TokenConfig{{Name: "dashboard", TokenSHA256: hex.EncodeToString(hash[:]), Role: auth.RoleReader}},
+	}
+	api.SetAuthenticator(config.Authenticator())
+
+	resp := internalRequest(api, "GET", "/v1/compose/7802c476-9cd1-41b7-ba81-43c1906bce73", ``, "")
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	require.NotEmpty(t, resp.Header.Get("WWW-Authenticate"))
+
+	send := func(method, path, body string) *http.Response {
+		req := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer r")
+		resp := httptest.NewRecorder()
+		api.ServeHTTP(resp, req)
+		return resp.Result()
+	}
+
+	resp = send("GET", "/v1/compose/7802c476-9cd1-41b7-ba81-43c1906bce73", ``)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = send("POST", "/v1/compose", `{"image_builds":[]}`)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestStatusInternalError(t *testing.T) {
+	registry, err := distro_mock.NewDefaultRegistry()
+	require.NoError(t, err)
+	workers := worker.NewServer(nil, &brokenJobQueue{testjobqueue.New()}, nil, "")
+	api := rcm.New(nil, workers, rpmmd_mock.NewRPMMDMock(rpmmd_mock.BaseFixture()), registry)
+
+	resp := internalRequest(api, "GET", "/v1/compose/7802c476-9cd1-41b7-ba81-43c1906bce73", ``, "")
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), `"code":"INTERNAL_ERROR"`)
+}
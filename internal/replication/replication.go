@@ -0,0 +1,195 @@
+// Package replication keeps the stores of a standby osbuild-composer in sync
+// with the stores of all tenants of the active one.
+//
+// The active instance serves snapshots of its stores over HTTPS, to clients
+// with a certificate. A standby periodically fetches them and restores them
+// into its own stores, so that it can take over with (almost) up-to-date
+// state when the active instance goes away. Which instance is active is
+// decided by a lease (see package lease), not by this package.
+package replication
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/store"
+)
+
+// Server serves snapshots of the stores of all tenants of the active
+// instance. Snapshots contain the credentials of upload targets, which is
+// why it is only served over TLS to clients with a certificate (see
+// ServeTLS).
+type Server struct {
+	logger  *log.Logger
+	tenants *store.Tenants
+	router  *httprouter.Router
+}
+
+func NewServer(logger *log.Logger, tenants *store.Tenants) *Server {
+	s := &Server{
+		logger:  logger,
+		tenants: tenants,
+	}
+
+	s.router = httprouter.New()
+	s.router.RedirectTrailingSlash = false
+	s.router.RedirectFixedPath = false
+	s.router.MethodNotAllowed = http.HandlerFunc(methodNotAllowedHandler)
+	s.router.NotFound = http.HandlerFunc(notFoundHandler)
+
+	s.router.GET("/replication/v1/state", s.stateHandler)
+	s.router.GET("/replication/v1/tenants", s.tenantsHandler)
+	s.router.GET("/replication/v1/tenants/:tenant/state", s.stateHandler)
+
+	return s
+}
+
+// ServeTLS serves snapshots on `listener` to clients which authenticate
+// with a client certificate that `conf` trusts, usually the same
+// certificates as remote workers (see worker.TLSConfig.ServerConfig()).
+func (s *Server) ServeTLS(listener net.Listener, conf *tls.Config) error {
+	if conf.ClientAuth != tls.RequireAndVerifyClientCert {
+		return errors.New("replication connections must be authenticated with client certificates")
+	}
+
+	server := http.Server{Handler: s}
+
+	err := server.Serve(tls.NewListener(listener, conf))
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if s.logger != nil {
+		log.Println(request.Method, request.URL.Path)
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	s.router.ServeHTTP(writer, request)
+}
+
+// tenantsHandler lists the tenants other than the default one.
+func (s *Server) tenantsHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	tenants := []string{}
+	for tenant := range s.tenants.All() {
+		if tenant != "" {
+			tenants = append(tenants, tenant)
+		}
+	}
+	_ = json.NewEncoder(writer).Encode(tenants)
+}
+
+// stateHandler sends a snapshot of the store of the tenant in the path, or
+// of the default tenant.
+func (s *Server) stateHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	tenantStore, exists := s.tenants.All()[params.ByName("tenant")]
+	if !exists {
+		common.NewAPIError(common.ErrorNotFound, "unknown tenant: %s", params.ByName("tenant")).WriteJSON(writer)
+		return
+	}
+
+	err := tenantStore.Snapshot(writer)
+	if err != nil {
+		// headers are already sent, the standby notices the truncated body
+		log.Printf("error writing store snapshot: %v", err)
+	}
+}
+
+func methodNotAllowedHandler(writer http.ResponseWriter, request *http.Request) {
+	common.NewAPIError(common.ErrorMethodNotAllowed, "method not allowed").WriteJSON(writer)
+}
+
+func notFoundHandler(writer http.ResponseWriter, request *http.Request) {
+	common.NewAPIError(common.ErrorNotFound, "not found").WriteJSON(writer)
+}
+
+// Follower replicates the stores of all tenants of the active instance at
+// `url` into local stores.
+type Follower struct {
+	url     string
+	tenants *store.Tenants
+	client  *http.Client
+}
+
+// NewFollower returns a follower of the active instance at `url`. It
+// authenticates with `tlsConfig`, unless that is nil.
+func NewFollower(url string, tenants *store.Tenants, tlsConfig *tls.Config) *Follower {
+	return &Follower{
+		url:     url,
+		tenants: tenants,
+		client: &http.Client{
+			Timeout:   time.Minute,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}
+}
+
+// Sync fetches snapshots from the active instance and replaces the state of
+// the local stores with them. Each local store is left untouched when its
+// snapshot cannot be fetched. Tenants are created locally when they appear
+// on the active instance, but they are never removed.
+func (f *Follower) Sync() error {
+	defaultStore, err := f.tenants.Get("")
+	if err != nil {
+		return err
+	}
+	err = f.restore("/replication/v1/state", defaultStore)
+	if err != nil {
+		return err
+	}
+
+	response, err := f.client.Get(f.url + "/replication/v1/tenants")
+	if err != nil {
+		return fmt.Errorf("error fetching tenants: %v", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("error fetching tenants: unexpected status %s", response.Status)
+	}
+
+	var tenants []string
+	err = json.NewDecoder(response.Body).Decode(&tenants)
+	if err != nil {
+		return fmt.Errorf("error fetching tenants: %v", err)
+	}
+
+	for _, tenant := range tenants {
+		tenantStore, err := f.tenants.Get(tenant)
+		if err != nil {
+			return err
+		}
+		err = f.restore("/replication/v1/tenants/"+tenant+"/state", tenantStore)
+		if err != nil {
+			return fmt.Errorf("tenant %s: %v", tenant, err)
+		}
+	}
+
+	return nil
+}
+
+func (f *Follower) restore(path string, s *store.Store) error {
+	response, err := f.client.Get(f.url + path)
+	if err != nil {
+		return fmt.Errorf("error fetching state: %v", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("error fetching state: unexpected status %s", response.Status)
+	}
+
+	return s.Restore(response.Body)
+}
@@ -0,0 +1,105 @@
+package replication_test
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/replication"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/test"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+func newTenants(t *testing.T) *store.Tenants {
+	tenants, err := store.NewTenants(store.New(nil), nil)
+	require.NoError(t, err)
+	return tenants
+}
+
+func TestSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replication-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca, caKey := test.WriteCertificate(t, dir, "ca", nil, nil)
+	test.WriteCertificate(t, dir, "active", ca, caKey)
+	test.WriteCertificate(t, dir, "standby", ca, caKey)
+
+	serverConf, err := (&worker.TLSConfig{
+		CACertFile: path.Join(dir, "ca-crt.pem"),
+		CertFile:   path.Join(dir, "active-crt.pem"),
+		KeyFile:    path.Join(dir, "active-key.pem"),
+	}).ServerConfig()
+	require.NoError(t, err)
+	clientConf, err := (&worker.TLSConfig{
+		CACertFile: path.Join(dir, "ca-crt.pem"),
+		CertFile:   path.Join(dir, "standby-crt.pem"),
+		KeyFile:    path.Join(dir, "standby-key.pem"),
+	}).ClientConfig()
+	require.NoError(t, err)
+
+	primary := newTenants(t)
+	server := replication.NewServer(nil, primary)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		_ = server.ServeTLS(listener, serverConf)
+	}()
+	url := "https://" + listener.Addr().String()
+
+	standby := newTenants(t)
+	follower := replication.NewFollower(url, standby, clientConf)
+
+	primaryDefault, err := primary.Get("")
+	require.NoError(t, err)
+	primaryWeb, err := primary.Get("web")
+	require.NoError(t, err)
+
+	bp := blueprint.Blueprint{Name: "test", Version: "0.0.1"}
+	require.NoError(t, primaryDefault.PushBlueprint(bp, "first"))
+	require.NoError(t, primaryWeb.PushBlueprint(blueprint.Blueprint{Name: "web", Version: "0.0.1"}, "first"))
+	require.NoError(t, follower.Sync())
+
+	standbyDefault, err := standby.Get("")
+	require.NoError(t, err)
+	require.Equal(t, []string{"test"}, standbyDefault.ListBlueprints())
+	require.Contains(t, standby.All(), "web")
+	require.Equal(t, []string{"web"}, standby.All()["web"].ListBlueprints())
+
+	require.NoError(t, primaryDefault.DeleteBlueprint("test"))
+	require.NoError(t, follower.Sync())
+	require.Empty(t, standbyDefault.ListBlueprints())
+
+	// standbys without a certificate don't get the state
+	clientConf.Certificates = nil
+	require.Error(t, replication.NewFollower(url, newTenants(t), clientConf).Sync())
+
+	// the state is never served without client certificates
+	unauthenticated := serverConf.Clone()
+	unauthenticated.ClientAuth = tls.NoClientCert
+	require.Error(t, server.ServeTLS(listener, unauthenticated))
+}
+
+func TestSyncFails(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	standby := newTenants(t)
+	standbyDefault, err := standby.Get("")
+	require.NoError(t, err)
+	require.NoError(t, standbyDefault.PushBlueprint(blueprint.Blueprint{Name: "test"}, "first"))
+
+	follower := replication.NewFollower(server.URL, standby, nil)
+	require.Error(t, follower.Sync())
+	require.Equal(t, []string{"test"}, standbyDefault.ListBlueprints())
+}
@@ -0,0 +1,273 @@
+package rpmmd
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Loading the packages of all repositories is slow, because dnf has to read
+// the whole metadata, even when it is cached on disk. A Cache wraps an RPMMD
+// so that FetchMetadata keeps the packages of each repository in memory, and
+// only loads them again when the checksum of the repository's metadata
+// changed. Results of Depsolve are kept the same way. Checking the checksums
+// is cheap in comparison, but still takes a call to dnf. Checksums younger
+// than the cache's TTL are trusted without asking dnf again.
+type Cache struct {
+	RPMMD
+
+	ttl time.Duration
+
+	mu        sync.Mutex
+	checksums map[string]checksumEntry // by repoCacheKey()
+	entries   map[string]cacheEntry    // by repoCacheKey()
+	depsolves map[string]depsolveEntry // by depsolveCacheKey()
+}
+
+// At most this many results of Depsolve are cached. The oldest ones are
+// forgotten first.
+const maxCachedDepsolves = 64
+
+type checksumEntry struct {
+	repo     RepoConfig
+	checksum string
+	checked  time.Time
+}
+
+type cacheEntry struct {
+	// Sources with the same id might point to different URLs over time
+	repo RepoConfig
+
+	checksum string
+	packages PackageList
+}
+
+type depsolveEntry struct {
+	repoIDs  []string
+	packages []PackageSpec
+	added    time.Time
+}
+
+// NewCached returns a cache for `rpmmd`, which trusts checksums for `ttl`.
+// With a `ttl` of 0, checksums are checked for every call.
+func NewCached(rpmmd RPMMD, ttl time.Duration) *Cache {
+	return &Cache{
+		RPMMD:     rpmmd,
+		ttl:       ttl,
+		checksums: make(map[string]checksumEntry),
+		entries:   make(map[string]cacheEntry),
+		depsolves: make(map[string]depsolveEntry),
+	}
+}
+
+func repoCacheKey(repo RepoConfig, modulePlatformID, arch string) string {
+	return modulePlatformID + "/" + arch + "/" + repo.Id
+}
+
+func depsolveCacheKey(specs, excludeSpecs, moduleSpecs []string, repos []RepoConfig, checksums map[string]string, modulePlatformID, arch string) string {
+	key, err := json.Marshal([]interface{}{specs, excludeSpecs, moduleSpecs, repos, checksums, modulePlatformID, arch})
+	if err != nil {
+		panic(err)
+	}
+	return string(key)
+}
+
+func (c *Cache) FetchMetadata(repos []RepoConfig, modulePlatformID string, arch string) (PackageList, map[string]string, error) {
+	checksums, err := c.FetchChecksums(repos, modulePlatformID, arch)
+	if err == nil {
+		packages, ok := c.lookup(repos, checksums, modulePlatformID, arch)
+		if ok {
+			return packages, checksums, nil
+		}
+	}
+
+	packages, checksums, err := c.RPMMD.FetchMetadata(repos, modulePlatformID, arch)
+	if err == nil {
+		c.recordChecksums(repos, checksums, modulePlatformID, arch)
+		c.insert(repos, checksums, packages, modulePlatformID, arch)
+	}
+
+	return packages, checksums, err
+}
+
+// FetchChecksums returns the checksums of `repos`, without asking dnf if all
+// of them have been checked within the TTL.
+func (c *Cache) FetchChecksums(repos []RepoConfig, modulePlatformID string, arch string) (map[string]string, error) {
+	if checksums, ok := c.freshChecksums(repos, modulePlatformID, arch); ok {
+		return checksums, nil
+	}
+
+	checksums, err := c.RPMMD.FetchChecksums(repos, modulePlatformID, arch)
+	if err != nil {
+		return nil, err
+	}
+	c.recordChecksums(repos, checksums, modulePlatformID, arch)
+
+	return checksums, nil
+}
+
+func (c *Cache) Depsolve(specs, excludeSpecs, moduleSpecs []string, repos []RepoConfig, modulePlatformID, arch string) ([]PackageSpec, map[string]string, error) {
+	checksums, err := c.FetchChecksums(repos, modulePlatformID, arch)
+	if err == nil {
+		key := depsolveCacheKey(specs, excludeSpecs, moduleSpecs, repos, checksums, modulePlatformID, arch)
+		c.mu.Lock()
+		entry, exists := c.depsolves[key]
+		c.mu.Unlock()
+		if exists {
+			return append([]PackageSpec{}, entry.packages...), checksums, nil
+		}
+	}
+
+	packages, checksums, err := c.RPMMD.Depsolve(specs, excludeSpecs, moduleSpecs, repos, modulePlatformID, arch)
+	if err != nil {
+		return packages, checksums, err
+	}
+	c.recordChecksums(repos, checksums, modulePlatformID, arch)
+
+	var repoIDs []string
+	for _, repo := range repos {
+		if checksums[repo.Id] == "" {
+			return packages, checksums, nil
+		}
+		repoIDs = append(repoIDs, repo.Id)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.depsolves) >= maxCachedDepsolves {
+		var oldest string
+		for key, entry := range c.depsolves {
+			if oldest == "" || entry.added.Before(c.depsolves[oldest].added) {
+				oldest = key
+			}
+		}
+		delete(c.depsolves, oldest)
+	}
+
+	key := depsolveCacheKey(specs, excludeSpecs, moduleSpecs, repos, checksums, modulePlatformID, arch)
+	c.depsolves[key] = depsolveEntry{
+		repoIDs:  repoIDs,
+		packages: append([]PackageSpec{}, packages...),
+		added:    time.Now(),
+	}
+
+	return packages, checksums, nil
+}
+
+// Invalidate forgets everything that was cached about the repository with
+// id `repoID`, or about all repositories if it is empty. The next call for
+// the repository asks dnf again.
+func (c *Cache) Invalidate(repoID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if repoID == "" {
+		c.checksums = make(map[string]checksumEntry)
+		c.entries = make(map[string]cacheEntry)
+		c.depsolves = make(map[string]depsolveEntry)
+		return
+	}
+
+	for key, entry := range c.checksums {
+		if entry.repo.Id == repoID {
+			delete(c.checksums, key)
+		}
+	}
+	for key, entry := range c.entries {
+		if entry.repo.Id == repoID {
+			delete(c.entries, key)
+		}
+	}
+	for key, entry := range c.depsolves {
+		for _, id := range entry.repoIDs {
+			if id == repoID {
+				delete(c.depsolves, key)
+				break
+			}
+		}
+	}
+}
+
+// freshChecksums returns the checksums of `repos` if all of them have been
+// checked within the TTL.
+func (c *Cache) freshChecksums(repos []RepoConfig, modulePlatformID, arch string) (map[string]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	checksums := make(map[string]string)
+	for _, repo := range repos {
+		entry, exists := c.checksums[repoCacheKey(repo, modulePlatformID, arch)]
+		if !exists || !reflect.DeepEqual(entry.repo, repo) || now.Sub(entry.checked) >= c.ttl {
+			return nil, false
+		}
+		checksums[repo.Id] = entry.checksum
+	}
+
+	return checksums, true
+}
+
+func (c *Cache) recordChecksums(repos []RepoConfig, checksums map[string]string, modulePlatformID, arch string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for _, repo := range repos {
+		if checksum, exists := checksums[repo.Id]; exists && checksum != "" {
+			c.checksums[repoCacheKey(repo, modulePlatformID, arch)] = checksumEntry{repo, checksum, now}
+		}
+	}
+}
+
+// lookup returns the packages of all `repos` if all of them are cached with
+// the given checksums.
+func (c *Cache) lookup(repos []RepoConfig, checksums map[string]string, modulePlatformID, arch string) (PackageList, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	packages := PackageList{}
+	for _, repo := range repos {
+		entry, exists := c.entries[repoCacheKey(repo, modulePlatformID, arch)]
+		if !exists || !reflect.DeepEqual(entry.repo, repo) || entry.checksum == "" || entry.checksum != checksums[repo.Id] {
+			return nil, false
+		}
+		packages = append(packages, entry.packages...)
+	}
+
+	sort.SliceStable(packages, func(i, j int) bool {
+		return packages[i].Name < packages[j].Name
+	})
+
+	return packages, true
+}
+
+// insert caches `packages` by the repository they belong to. Nothing is
+// cached if a package doesn't say which of `repos` it belongs to.
+func (c *Cache) insert(repos []RepoConfig, checksums map[string]string, packages PackageList, modulePlatformID, arch string) {
+	byRepo := make(map[string]PackageList)
+	for _, repo := range repos {
+		byRepo[repo.Id] = PackageList{}
+	}
+
+	for _, pkg := range packages {
+		repoPackages, exists := byRepo[pkg.RepoID]
+		if !exists {
+			return
+		}
+		byRepo[pkg.RepoID] = append(repoPackages, pkg)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, repo := range repos {
+		c.entries[repoCacheKey(repo, modulePlatformID, arch)] = cacheEntry{
+			repo:     repo,
+			checksum: checksums[repo.Id],
+			packages: byRepo[repo.Id],
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package rpmmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRPMMD struct {
+	packages  PackageList
+	checksums map[string]string
+	fetched   int
+	checked   int
+	depsolved int
+}
+
+func (f *fakeRPMMD) FetchMetadata(repos []RepoConfig, modulePlatformID string, arch string) (PackageList, map[string]string, error) {
+	f.fetched++
+	return f.packages, f.checksums, nil
+}
+
+func (f *fakeRPMMD) FetchChecksums(repos []RepoConfig, modulePlatformID string, arch string) (map[string]string, error) {
+	f.checked++
+	return f.checksums, nil
+}
+
+func (f *fakeRPMMD) Depsolve(specs, excludeSpecs, moduleSpecs []string, repos []RepoConfig, modulePlatformID, arch string) ([]PackageSpec, map[string]string, error) {
+	f.depsolved++
+	var deps []PackageSpec
+	for _, spec := range specs {
+		deps = append(deps, PackageSpec{Name: spec, Version: f.checksums["base"]})
+	}
+	return deps, f.checksums, nil
+}
+
+func TestCachedFetchMetadata(t *testing.T) {
+	fake := &fakeRPMMD{
+		packages: PackageList{
+			{Name: "bash", Version: "5.0", RepoID: "base"},
+			{Name: "bash", Version: "5.1", RepoID: "updates"},
+			{Name: "zsh", Version: "5.8", RepoID: "base"},
+		},
+		checksums: map[string]string{"base": "sha256:1", "updates": "sha256:2"},
+	}
+	repos := []RepoConfig{{Id: "base", BaseURL: "http://example.com/base"}, {Id: "updates", BaseURL: "http://example.com/updates"}}
+	cached := NewCached(fake, 0)
+
+	packages, checksums, err := cached.FetchMetadata(repos, "platform:f32", "x86_64")
+	require.NoError(t, err)
+	require.Equal(t, fake.packages, packages)
+	require.Equal(t, fake.checksums, checksums)
+	require.Equal(t, 1, fake.fetched)
+
+	packages, _, err = cached.FetchMetadata(repos, "platform:f32", "x86_64")
+	require.NoError(t, err)
+	require.Equal(t, fake.packages, packages)
+	require.Equal(t, 1, fake.fetched)
+
+	// a single repository can be served from the cache
+	packages, _, err = cached.FetchMetadata(repos[1:], "platform:f32", "x86_64")
+	require.NoError(t, err)
+	require.Equal(t, PackageList{{Name: "bash", Version: "5.1", RepoID: "updates"}}, packages)
+	require.Equal(t, 1, fake.fetched)
+
+	// another architecture is not
+	_, _, err = cached.FetchMetadata(repos, "platform:f32", "aarch64")
+	require.NoError(t, err)
+	require.Equal(t, 2, fake.fetched)
+
+	// neither is a repository with the same id, but another url
+	_, _, err = cached.FetchMetadata([]RepoConfig{{Id: "base", BaseURL: "http://example.com/other"}}, "platform:f32", "x86_64")
+	require.NoError(t, err)
+	require.Equal(t, 3, fake.fetched)
+
+	// changed metadata is fetched again
+	fake.checksums = map[string]string{"base": "sha256:1", "updates": "sha256:3"}
+	fake.packages = fake.packages[:2]
+	packages, _, err = cached.FetchMetadata(repos, "platform:f32", "aarch64")
+	require.NoError(t, err)
+	require.Len(t, packages, 2)
+	require.Equal(t, 4, fake.fetched)
+
+	// packages from unknown repositories are not cached
+	fake.packages = PackageList{{Name: "bash"}}
+	_, _, err = cached.FetchMetadata(repos, "platform:f33", "x86_64")
+	require.NoError(t, err)
+	_, _, err = cached.FetchMetadata(repos, "platform:f33", "x86_64")
+	require.NoError(t, err)
+	require.Equal(t, 6, fake.fetched)
+}
+
+func TestCacheTTL(t *testing.T) {
+	fake := &fakeRPMMD{
+		packages:  PackageList{{Name: "bash", Version: "5.0", RepoID: "base"}},
+		checksums: map[string]string{"base": "sha256:1"},
+	}
+	repos := []RepoConfig{{Id: "base", BaseURL: "http://example.com/base"}}
+	cached := NewCached(fake, time.Hour)
+
+	_, _, err := cached.FetchMetadata(repos, "platform:f32", "x86_64")
+	require.NoError(t, err)
+	require.Equal(t, 1, fake.checked)
+	require.Equal(t, 1, fake.fetched)
+
+	// checksums are trusted within the ttl, even if they changed
+	fake.checksums = map[string]string{"base": "sha256:2"}
+	checksums, err := cached.FetchChecksums(repos, "platform:f32", "x86_64")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"base": "sha256:1"}, checksums)
+	_, _, err = cached.FetchMetadata(repos, "platform:f32", "x86_64")
+	require.NoError(t, err)
+	require.Equal(t, 1, fake.checked)
+	require.Equal(t, 1, fake.fetched)
+
+	// until they are invalidated
+	cached.Invalidate("updates")
+	_, err = cached.FetchChecksums(repos, "platform:f32", "x86_64")
+	require.NoError(t, err)
+	require.Equal(t, 1, fake.checked)
+
+	cached.Invalidate("base")
+	_, _, err = cached.FetchMetadata(repos, "platform:f32", "x86_64")
+	require.NoError(t, err)
+	require.Equal(t, 2, fake.checked)
+	require.Equal(t, 2, fake.fetched)
+}
+
+func TestCachedDepsolve(t *testing.T) {
+	fake := &fakeRPMMD{checksums: map[string]string{"base": "sha256:1"}}
+	repos := []RepoConfig{{Id: "base", BaseURL: "http://example.com/base"}}
+	cached := NewCached(fake, 0)
+
+	deps, _, err := cached.Depsolve([]string{"bash"}, nil, nil, repos, "platform:f32", "x86_64")
+	require.NoError(t, err)
+	require.Equal(t, []PackageSpec{{Name: "bash", Version: "sha256:1"}}, deps)
+	require.Equal(t, 1, fake.depsolved)
+
+	// changing the result doesn't change the cache
+	deps[0].Name = "zsh"
+	deps, _, err = cached.Depsolve([]string{"bash"}, nil, nil, repos, "platform:f32", "x86_64")
+	require.NoError(t, err)
+	require.Equal(t, []PackageSpec{{Name: "bash", Version: "sha256:1"}}, deps)
+	require.Equal(t, 1, fake.depsolved)
+
+	_, _, err = cached.Depsolve([]string{"bash"}, []string{"zsh"}, nil, repos, "platform:f32", "x86_64")
+	require.NoError(t, err)
+	require.Equal(t, 2, fake.depsolved)
+
+	// enabling a module stream changes the result
+	_, _, err = cached.Depsolve([]string{"bash"}, nil, []string{"nodejs:14"}, repos, "platform:f32", "x86_64")
+	require.NoError(t, err)
+	require.Equal(t, 3, fake.depsolved)
+
+	// changed metadata is depsolved again
+	fake.checksums = map[string]string{"base": "sha256:2"}
+	deps, _, err = cached.Depsolve([]string{"bash"}, nil, nil, repos, "platform:f32", "x86_64")
+	require.NoError(t, err)
+	require.Equal(t, []PackageSpec{{Name: "bash", Version: "sha256:2"}}, deps)
+	require.Equal(t, 4, fake.depsolved)
+
+	cached.Invalidate("")
+	_, _, err = cached.Depsolve([]string{"bash"}, nil, nil, repos, "platform:f32", "x86_64")
+	require.NoError(t, err)
+	require.Equal(t, 5, fake.depsolved)
+}
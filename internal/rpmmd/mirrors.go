@@ -0,0 +1,48 @@
+package rpmmd
+
+import (
+	"strings"
+)
+
+// selectMirrors returns `repos`, with the base URL of repositories that have
+// mirrors replaced by the first of their URLs (starting with the base URL)
+// whose metadata can be fetched. dnf tries the other URLs only for metadata,
+// but resolves the locations of packages against the first one, which is why
+// it must be reachable. Repositories which cannot be reached at all are
+// returned unchanged, for dnf to report the error.
+func selectMirrors(repos []RepoConfig, arch string) []RepoConfig {
+	var selected []RepoConfig
+	for i, repo := range repos {
+		if repo.BaseURL == "" || len(repo.Mirrors) == 0 {
+			continue
+		}
+
+		client, err := repoClient(repo)
+		if err != nil {
+			continue
+		}
+
+		urls := append([]string{repo.BaseURL}, repo.Mirrors...)
+		for j, u := range urls {
+			mirror := substituteArch(u, arch)
+			if !strings.HasSuffix(mirror, "/") {
+				mirror += "/"
+			}
+			if _, err := fetch(client, mirror+"repodata/repomd.xml"); err != nil {
+				continue
+			}
+
+			if selected == nil {
+				selected = append([]RepoConfig{}, repos...)
+			}
+			selected[i].BaseURL = u
+			selected[i].Mirrors = append(append([]string{}, urls[:j]...), urls[j+1:]...)
+			break
+		}
+	}
+
+	if selected == nil {
+		return repos
+	}
+	return selected
+}
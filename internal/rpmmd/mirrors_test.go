@@ -0,0 +1,27 @@
+package rpmmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectMirrors(t *testing.T) {
+	server := testRepoServer(t)
+	defer server.Close()
+
+	repos := []RepoConfig{
+		{Id: "test", BaseURL: server.URL + "/missing/", Mirrors: []string{server.URL + "/$basearch/", server.URL + "/repo/", server.URL + "/old/"}},
+		{Id: "unreachable", BaseURL: server.URL + "/missing/", Mirrors: []string{server.URL + "/$basearch/"}},
+		{Id: "plain", BaseURL: server.URL + "/missing/"},
+	}
+	selected := selectMirrors(repos, "x86_64")
+	require.Equal(t, []RepoConfig{
+		{Id: "test", BaseURL: server.URL + "/repo/", Mirrors: []string{server.URL + "/missing/", server.URL + "/$basearch/", server.URL + "/old/"}},
+		repos[1],
+		repos[2],
+	}, selected)
+
+	// the repositories are not modified
+	require.Equal(t, server.URL+"/missing/", repos[0].BaseURL)
+}
@@ -0,0 +1,688 @@
+package rpmmd
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// nativeRPMMD reads repositories and solves dependencies without dnf, for
+// platforms where dnf-json cannot run (see solver.go). It is experimental.
+type nativeRPMMD struct {
+	cacheDir string
+
+	mu    sync.Mutex
+	repos map[string]*nativeRepo // by repository id
+}
+
+// A nativeRepo is the parsed metadata of a repository, fetched from the
+// mirror at baseURL.
+type nativeRepo struct {
+	checksum string
+	baseURL  string
+	packages []*rpmPackage
+}
+
+// NewNativeRPMMD returns an RPMMD which parses the repositories' metadata and
+// solves dependencies itself. It doesn't support modules, package groups, or
+// repositories whose metadata is compressed with anything but gzip or zchunk.
+// The metadata of the repositories is kept in memory, until it changes. If
+// `cacheDir` is not empty, zchunk metadata is kept there, so that only the
+// parts which changed are downloaded when it changes (see zchunk.go).
+func NewNativeRPMMD(cacheDir string) RPMMD {
+	return &nativeRPMMD{
+		cacheDir: cacheDir,
+		repos:    make(map[string]*nativeRepo),
+	}
+}
+
+func (r *nativeRPMMD) FetchMetadata(repos []RepoConfig, modulePlatformID string, arch string) (PackageList, map[string]string, error) {
+	packages, checksums, err := r.load(repos, arch)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	list := make(PackageList, 0, len(packages))
+	for _, pkg := range packages {
+		list = append(list, pkg.info)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list, checksums, nil
+}
+
+func (r *nativeRPMMD) FetchChecksums(repos []RepoConfig, modulePlatformID string, arch string) (map[string]string, error) {
+	checksums := make(map[string]string)
+	for _, repo := range repos {
+		_, _, repomd, err := fetchRepomd(repo, arch)
+		if err != nil {
+			return nil, err
+		}
+		checksums[repo.Id] = repoChecksum(repomd)
+	}
+	return checksums, nil
+}
+
+func (r *nativeRPMMD) Depsolve(specs, excludeSpecs, moduleSpecs []string, repos []RepoConfig, modulePlatformID, arch string) ([]PackageSpec, map[string]string, error) {
+	if len(moduleSpecs) > 0 {
+		return nil, nil, &DNFError{"MarkingErrors", fmt.Sprintf("cannot enable modules %v: the native depsolver does not support modules", moduleSpecs)}
+	}
+
+	packages, checksums, err := r.load(repos, arch)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	all := newPool(packages)
+	excluded := make(map[*rpmPackage]bool)
+	for _, spec := range excludeSpecs {
+		matches, err := all.match(spec)
+		if err != nil {
+			return nil, nil, &DNFError{"MarkingErrors", fmt.Sprintf("invalid exclude %s: %v", spec, err)}
+		}
+		for _, pkg := range matches {
+			excluded[pkg] = true
+		}
+	}
+	var available []*rpmPackage
+	for _, pkg := range packages {
+		if !excluded[pkg] {
+			available = append(available, pkg)
+		}
+	}
+
+	s := newSolver(newPool(available))
+	for _, spec := range specs {
+		if strings.HasPrefix(spec, "@") {
+			return nil, nil, &DNFError{"MarkingErrors", fmt.Sprintf("cannot install %s: the native depsolver does not support package groups", spec)}
+		}
+
+		matches, err := s.pool.match(spec)
+		if err != nil {
+			return nil, nil, &DNFError{"MarkingErrors", fmt.Sprintf("invalid package %s: %v", spec, err)}
+		}
+		if len(matches) == 0 {
+			return nil, nil, &DNFError{"MarkingErrors", fmt.Sprintf("No match for argument: %s", spec)}
+		}
+
+		// install the newest version of each package the spec matches
+		byName := make(map[string][]*rpmPackage)
+		var names []string
+		for _, pkg := range matches {
+			if _, exists := byName[pkg.spec.Name]; !exists {
+				names = append(names, pkg.spec.Name)
+			}
+			byName[pkg.spec.Name] = append(byName[pkg.spec.Name], pkg)
+		}
+		for _, name := range names {
+			err = s.install(spec, byName[name])
+			if err != nil {
+				return nil, nil, &DNFError{"DepsolveError", err.Error()}
+			}
+		}
+	}
+
+	err = s.solve(0, 0)
+	if err != nil {
+		return nil, nil, &DNFError{"DepsolveError", fmt.Sprintf("There was a problem depsolving %v: %v", specs, err)}
+	}
+
+	dependencies := make([]PackageSpec, 0, len(s.installed))
+	for _, pkg := range s.installed {
+		dependencies = append(dependencies, pkg.spec)
+	}
+	sort.Slice(dependencies, func(i, j int) bool {
+		return dependencies[i].Name < dependencies[j].Name
+	})
+
+	return dependencies, checksums, nil
+}
+
+// dnf's defaults for the priority and cost of repositories
+const (
+	defaultRepoPriority = 99
+	defaultRepoCost     = 1000
+)
+
+func repoPriority(repo RepoConfig) int {
+	if repo.Priority == 0 {
+		return defaultRepoPriority
+	}
+	return repo.Priority
+}
+
+func repoCost(repo RepoConfig) int {
+	if repo.Cost == 0 {
+		return defaultRepoCost
+	}
+	return repo.Cost
+}
+
+// load returns the packages of `repos` which can be installed on `arch`,
+// and the checksums of the repositories. Like dnf, it takes packages only
+// from the repositories with the lowest priority that have packages of that
+// name. Packages with the same name, version, and architecture are taken
+// from the repository with the lowest cost.
+func (r *nativeRPMMD) load(repos []RepoConfig, arch string) ([]*rpmPackage, map[string]string, error) {
+	sorted := append([]RepoConfig{}, repos...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if repoPriority(sorted[i]) != repoPriority(sorted[j]) {
+			return repoPriority(sorted[i]) < repoPriority(sorted[j])
+		}
+		return repoCost(sorted[i]) < repoCost(sorted[j])
+	})
+
+	var packages []*rpmPackage
+	checksums := make(map[string]string)
+	seen := make(map[string]bool)
+	priorities := make(map[string]int) // by package name
+
+	for _, repo := range sorted {
+		parsed, err := r.loadRepo(repo, arch)
+		if err != nil {
+			return nil, nil, err
+		}
+		checksums[repo.Id] = parsed.checksum
+
+		priority := repoPriority(repo)
+		for _, pkg := range parsed.packages {
+			if pkg.spec.Arch != arch && pkg.spec.Arch != "noarch" {
+				continue
+			}
+			if p, exists := priorities[pkg.spec.Name]; exists && p < priority {
+				continue
+			}
+			if seen[pkg.nevra()] {
+				continue
+			}
+			seen[pkg.nevra()] = true
+			priorities[pkg.spec.Name] = priority
+			packages = append(packages, pkg)
+		}
+	}
+
+	return packages, checksums, nil
+}
+
+func (r *nativeRPMMD) loadRepo(repo RepoConfig, arch string) (*nativeRepo, error) {
+	client, baseURL, repomd, err := fetchRepomd(repo, arch)
+	if err != nil {
+		return nil, err
+	}
+	checksum := repoChecksum(repomd)
+
+	r.mu.Lock()
+	parsed, exists := r.repos[repo.Id]
+	r.mu.Unlock()
+	if exists && parsed.checksum == checksum && parsed.baseURL == baseURL {
+		return parsed, nil
+	}
+
+	var md struct {
+		Data []struct {
+			Type     string          `xml:"type,attr"`
+			Checksum primaryChecksum `xml:"checksum"`
+			Location struct {
+				Href string `xml:"href,attr"`
+			} `xml:"location"`
+		} `xml:"data"`
+	}
+	err = xml.Unmarshal(repomd, &md)
+	if err != nil {
+		return nil, repoError(repo, fmt.Errorf("invalid repomd.xml: %v", err))
+	}
+
+	var primary, primaryZck, primaryZckChecksum string
+	for _, data := range md.Data {
+		switch data.Type {
+		case "primary":
+			primary = data.Location.Href
+		case "primary_zck":
+			primaryZck = data.Location.Href
+			primaryZckChecksum = data.Checksum.Type + ":" + strings.TrimSpace(data.Checksum.Value)
+		}
+	}
+
+	var packages []*rpmPackage
+	if primaryZck != "" && r.cacheDir != "" {
+		path := filepath.Join(r.cacheDir, repoCacheName(repo), "primary.xml.zck")
+		packages, err = fetchPrimaryZck(client, repo.Id, baseURL, primaryZck, path, primaryZckChecksum)
+	}
+
+	// fall back to the whole metadata if zchunk metadata cannot be used
+	if packages == nil {
+		if primary == "" {
+			return nil, repoError(repo, fmt.Errorf("repomd.xml does not list primary metadata"))
+		}
+		packages, err = fetchPrimary(client, repo.Id, baseURL, primary)
+		if err != nil {
+			return nil, repoError(repo, err)
+		}
+	}
+
+	parsed = &nativeRepo{checksum, baseURL, packages}
+	r.mu.Lock()
+	r.repos[repo.Id] = parsed
+	r.mu.Unlock()
+
+	return parsed, nil
+}
+
+func repoError(repo RepoConfig, err error) error {
+	return &DNFError{"RepoError", fmt.Sprintf("Error occurred when setting up repo: %s: %v", repo.Id, err)}
+}
+
+// basearch returns the base architecture of `arch`, like dnf.rpm.basearch().
+func basearch(arch string) string {
+	switch arch {
+	case "i486", "i586", "i686":
+		return "i386"
+	case "armv7hl", "armv7hnl":
+		return "armhfp"
+	}
+	return arch
+}
+
+// substituteArch replaces $arch and $basearch in `url`, like dnf does.
+func substituteArch(url, arch string) string {
+	return strings.NewReplacer("$basearch", basearch(arch), "$arch", arch).Replace(url)
+}
+
+// repoCacheName returns the name of the directory in which metadata of
+// `repo` is cached, which dnf-json computes the same way.
+func repoCacheName(repo RepoConfig) string {
+	url := repo.BaseURL
+	if repo.Metalink != "" {
+		url = repo.Metalink
+	} else if repo.MirrorList != "" {
+		url = repo.MirrorList
+	}
+	digest := sha256.Sum256([]byte(url))
+	return repo.Id + "-" + hex.EncodeToString(digest[:])[:16]
+}
+
+// repoChecksum returns the checksum of a repository with `repomd`, computed
+// like dnf-json does.
+func repoChecksum(repomd []byte) string {
+	checksum := sha256.Sum256(repomd)
+	return "sha256:" + hex.EncodeToString(checksum[:])
+}
+
+// fetchRepomd finds a mirror of `repo` whose repomd.xml can be fetched. It
+// returns a client to fetch from the mirror with, its URL (ending in a
+// slash), and the repomd.xml.
+func fetchRepomd(repo RepoConfig, arch string) (*http.Client, string, []byte, error) {
+	client, err := repoClient(repo)
+	if err != nil {
+		return nil, "", nil, repoError(repo, err)
+	}
+
+	var mirrors []string
+	switch {
+	case repo.BaseURL != "":
+		for _, u := range append([]string{repo.BaseURL}, repo.Mirrors...) {
+			mirrors = append(mirrors, substituteArch(u, arch))
+		}
+	case repo.Metalink != "":
+		mirrors, err = fetchMetalink(client, substituteArch(repo.Metalink, arch))
+	case repo.MirrorList != "":
+		mirrors, err = fetchMirrorList(client, substituteArch(repo.MirrorList, arch))
+	default:
+		err = fmt.Errorf("no baseurl, metalink, or mirrorlist")
+	}
+	if err != nil {
+		return nil, "", nil, repoError(repo, err)
+	}
+
+	err = fmt.Errorf("no mirrors")
+	for _, mirror := range mirrors {
+		if !strings.HasSuffix(mirror, "/") {
+			mirror += "/"
+		}
+
+		var repomd []byte
+		repomd, err = fetch(client, mirror+"repodata/repomd.xml")
+		if err == nil {
+			return client, mirror, repomd, nil
+		}
+	}
+
+	return nil, "", nil, repoError(repo, err)
+}
+
+// repoClient returns a client which uses the proxy and certificates of
+// `repo`.
+func repoClient(repo RepoConfig) (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: repo.IgnoreSSL,
+	}
+
+	if repo.SSLCACert != "" {
+		ca, err := ioutil.ReadFile(repo.SSLCACert)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates in %s", repo.SSLCACert)
+		}
+	}
+
+	if repo.SSLClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(repo.SSLClientCert, repo.SSLClientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}
+	if repo.Proxy != "" {
+		proxy, err := url.Parse(repo.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %v", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	return &http.Client{Transport: transport, Timeout: 10 * time.Minute}, nil
+}
+
+func get(client *http.Client, url string) (io.ReadCloser, error) {
+	response, err := getResponse(client, url)
+	if err != nil {
+		return nil, err
+	}
+	return response.Body, nil
+}
+
+// getResponse is like get(), but returns the whole response.
+func getResponse(client *http.Client, url string) (*http.Response, error) {
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("cannot fetch %s: %s", url, response.Status)
+	}
+	return response, nil
+}
+
+func fetch(client *http.Client, url string) ([]byte, error) {
+	body, err := get(client, url)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return ioutil.ReadAll(body)
+}
+
+// fetchMetalink returns the mirrors listed in the metalink at `url`, most
+// preferred first.
+func fetchMetalink(client *http.Client, url string) ([]string, error) {
+	data, err := fetch(client, url)
+	if err != nil {
+		return nil, err
+	}
+
+	var metalink struct {
+		URLs []struct {
+			Protocol   string `xml:"protocol,attr"`
+			Preference int    `xml:"preference,attr"`
+			URL        string `xml:",chardata"`
+		} `xml:"files>file>resources>url"`
+	}
+	err = xml.Unmarshal(data, &metalink)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metalink: %v", err)
+	}
+
+	urls := metalink.URLs
+	sort.SliceStable(urls, func(i, j int) bool {
+		return urls[i].Preference > urls[j].Preference
+	})
+
+	var mirrors []string
+	for _, u := range urls {
+		if u.Protocol != "http" && u.Protocol != "https" {
+			continue
+		}
+		mirrors = append(mirrors, strings.TrimSuffix(strings.TrimSpace(u.URL), "repodata/repomd.xml"))
+	}
+	return mirrors, nil
+}
+
+// fetchMirrorList returns the mirrors listed in the mirror list at `url`.
+func fetchMirrorList(client *http.Client, url string) ([]string, error) {
+	body, err := get(client, url)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var mirrors []string
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			mirrors = append(mirrors, line)
+		}
+	}
+	return mirrors, scanner.Err()
+}
+
+type primaryEntry struct {
+	Name  string `xml:"name,attr"`
+	Flags string `xml:"flags,attr"`
+	Epoch string `xml:"epoch,attr"`
+	Ver   string `xml:"ver,attr"`
+	Rel   string `xml:"rel,attr"`
+}
+
+type primaryChecksum struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type primaryPackage struct {
+	Name    string `xml:"name"`
+	Arch    string `xml:"arch"`
+	Version struct {
+		Epoch string `xml:"epoch,attr"`
+		Ver   string `xml:"ver,attr"`
+		Rel   string `xml:"rel,attr"`
+	} `xml:"version"`
+	Checksum    primaryChecksum `xml:"checksum"`
+	Summary     string          `xml:"summary"`
+	Description string          `xml:"description"`
+	URL         string          `xml:"url"`
+	Time        struct {
+		Build int64 `xml:"build,attr"`
+	} `xml:"time"`
+	Size struct {
+		Installed uint64 `xml:"installed,attr"`
+	} `xml:"size"`
+	Location struct {
+		Href string `xml:"href,attr"`
+	} `xml:"location"`
+	Format struct {
+		License   string         `xml:"license"`
+		Provides  []primaryEntry `xml:"provides>entry"`
+		Requires  []primaryEntry `xml:"requires>entry"`
+		Conflicts []primaryEntry `xml:"conflicts>entry"`
+		Obsoletes []primaryEntry `xml:"obsoletes>entry"`
+		Files     []string       `xml:"file"`
+	} `xml:"format"`
+}
+
+// fetchPrimary fetches and parses the primary metadata at `href` of the
+// repository `repoID` at `baseURL`.
+func fetchPrimary(client *http.Client, repoID, baseURL, href string) ([]*rpmPackage, error) {
+	body, err := get(client, baseURL+href)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var reader io.Reader = body
+	switch {
+	case strings.HasSuffix(href, ".gz"):
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", href, err)
+		}
+		defer gz.Close()
+		reader = gz
+	case strings.HasSuffix(href, ".xml"):
+	default:
+		return nil, fmt.Errorf("unsupported compression of %s", href)
+	}
+
+	return parsePrimary(reader, repoID, baseURL, href)
+}
+
+// fetchPrimaryZck updates the zchunk primary metadata at `path` from the
+// one at `href` and parses it.
+func fetchPrimaryZck(client *http.Client, repoID, baseURL, href, path, checksum string) ([]*rpmPackage, error) {
+	err := fetchZck(client, baseURL+href, path, checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := readZck(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return parsePrimary(reader, repoID, baseURL, href)
+}
+
+func parsePrimary(reader io.Reader, repoID, baseURL, href string) ([]*rpmPackage, error) {
+	var packages []*rpmPackage
+	decoder := xml.NewDecoder(reader)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", href, err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "package" {
+			continue
+		}
+
+		var p primaryPackage
+		err = decoder.DecodeElement(&p, &start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", href, err)
+		}
+		packages = append(packages, newRPMPackage(&p, repoID, baseURL))
+	}
+
+	return packages, nil
+}
+
+func parseEpoch(epoch string) uint {
+	e, _ := strconv.ParseUint(epoch, 10, 32)
+	return uint(e)
+}
+
+func parseEntries(entries []primaryEntry) []relation {
+	var relations []relation
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name, "rpmlib(") {
+			continue
+		}
+		if strings.HasPrefix(e.Name, "(") {
+			if r, ok := parseRichDependency(e.Name); ok {
+				relations = append(relations, r)
+			}
+			continue
+		}
+		relations = append(relations, relation{
+			name:  e.Name,
+			flags: parseFlags(e.Flags),
+			evr: PackageSpec{
+				Epoch:   parseEpoch(e.Epoch),
+				Version: e.Ver,
+				Release: e.Rel,
+			},
+		})
+	}
+	return relations
+}
+
+func newRPMPackage(p *primaryPackage, repoID, baseURL string) *rpmPackage {
+	// dnf calls sha1 "sha1" (see hawkey.chksum_name())
+	checksumType := p.Checksum.Type
+	if checksumType == "sha" {
+		checksumType = "sha1"
+	}
+
+	pkg := &rpmPackage{
+		spec: PackageSpec{
+			Name:           p.Name,
+			Epoch:          parseEpoch(p.Version.Epoch),
+			Version:        p.Version.Ver,
+			Release:        p.Version.Rel,
+			Arch:           p.Arch,
+			RepoID:         repoID,
+			Path:           p.Location.Href,
+			RemoteLocation: baseURL + p.Location.Href,
+			Checksum:       checksumType + ":" + strings.TrimSpace(p.Checksum.Value),
+			InstallSize:    p.Size.Installed,
+			Mirror:         baseURL,
+		},
+		provides:  parseEntries(p.Format.Provides),
+		requires:  parseEntries(p.Format.Requires),
+		conflicts: parseEntries(p.Format.Conflicts),
+		obsoletes: parseEntries(p.Format.Obsoletes),
+	}
+
+	pkg.info = Package{
+		Name:        p.Name,
+		Summary:     p.Summary,
+		Description: p.Description,
+		URL:         p.URL,
+		Epoch:       pkg.spec.Epoch,
+		Version:     p.Version.Ver,
+		Release:     p.Version.Rel,
+		Arch:        p.Arch,
+		BuildTime:   time.Unix(p.Time.Build, 0).UTC(),
+		License:     p.Format.License,
+		RepoID:      repoID,
+	}
+
+	// packages provide themselves and their files
+	pkg.provides = append(pkg.provides, pkg.self())
+	for _, file := range p.Format.Files {
+		pkg.provides = append(pkg.provides, relation{name: file})
+	}
+
+	return pkg
+}
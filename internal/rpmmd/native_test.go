@@ -0,0 +1,227 @@
+package rpmmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testPrimary = `<?xml version="1.0" encoding="UTF-8"?>
+<metadata xmlns="http://linux.duke.edu/metadata/common" xmlns:rpm="http://linux.duke.edu/metadata/rpm" packages="%d">
+%s
+</metadata>`
+
+const testPackage = `<package type="rpm">
+  <name>%s</name>
+  <arch>%s</arch>
+  <version epoch="0" ver="%s" rel="1"/>
+  <checksum type="sha256" pkgid="YES">%[1]s%[3]s</checksum>
+  <summary>%[1]s</summary>
+  <time file="1" build="1588000000"/>
+  <size package="10" installed="100" archive="100"/>
+  <location href="Packages/%[1]s-%[3]s-1.%[2]s.rpm"/>
+  <format>
+    <rpm:license>MIT</rpm:license>
+    %[4]s
+  </format>
+</package>`
+
+func testRepoServer(t *testing.T) *httptest.Server {
+	packages := []string{
+		fmt.Sprintf(testPackage, "app", "x86_64", "1.0", `
+    <rpm:requires>
+      <rpm:entry name="rpmlib(PayloadIsZstd)" flags="LE" epoch="0" ver="5.4.18" rel="1"/>
+      <rpm:entry name="/bin/sh"/>
+      <rpm:entry name="libfoo" flags="GE" epoch="0" ver="2.0"/>
+      <rpm:entry name="(nano or vim)"/>
+    </rpm:requires>`),
+		fmt.Sprintf(testPackage, "bash", "x86_64", "5.0", `<file>/bin/sh</file>`),
+		fmt.Sprintf(testPackage, "libfoo", "x86_64", "1.0", `
+    <rpm:provides><rpm:entry name="libfoo" flags="EQ" epoch="0" ver="1.0" rel="1"/></rpm:provides>`),
+		fmt.Sprintf(testPackage, "libfoo", "x86_64", "2.0", `
+    <rpm:provides><rpm:entry name="libfoo" flags="EQ" epoch="0" ver="2.0" rel="1"/></rpm:provides>`),
+		fmt.Sprintf(testPackage, "libfoo", "x86_64", "3.0", `
+    <rpm:provides><rpm:entry name="libfoo" flags="EQ" epoch="0" ver="3.0" rel="1"/></rpm:provides>
+    <rpm:conflicts><rpm:entry name="bash"/></rpm:conflicts>`),
+		fmt.Sprintf(testPackage, "libfoo", "i686", "4.0", ""),
+		fmt.Sprintf(testPackage, "vim", "noarch", "8.2", ""),
+	}
+
+	mux := http.NewServeMux()
+	serveTestRepo(t, mux, "/repo/", packages)
+	serveTestRepo(t, mux, "/old/", []string{
+		fmt.Sprintf(testPackage, "libfoo", "x86_64", "1.5", `
+    <rpm:provides><rpm:entry name="libfoo" flags="EQ" epoch="0" ver="1.5" rel="1"/></rpm:provides>`),
+	})
+	mux.HandleFunc("/mirrorlist", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "# mirrors of %s\nhttp://%s/missing/\nhttp://%[2]s/repo/\n", r.URL.Query().Get("arch"), r.Host)
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func serveTestRepo(t *testing.T, mux *http.ServeMux, path string, packages []string) {
+	var primary bytes.Buffer
+	gz := gzip.NewWriter(&primary)
+	_, err := fmt.Fprintf(gz, testPrimary, len(packages), strings.Join(packages, "\n"))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	mux.HandleFunc(path+"repodata/repomd.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>
+<repomd xmlns="http://linux.duke.edu/metadata/repo">
+  <data type="primary"><location href="repodata/primary.xml.gz"/></data>
+</repomd>`)
+	})
+	mux.HandleFunc(path+"repodata/primary.xml.gz", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(primary.Bytes())
+	})
+}
+
+func TestNativeRPMMD(t *testing.T) {
+	server := testRepoServer(t)
+	defer server.Close()
+
+	repos := []RepoConfig{{Id: "test", BaseURL: server.URL + "/repo/"}}
+	r := NewNativeRPMMD("")
+
+	checksums, err := r.FetchChecksums(repos, "", "x86_64")
+	require.NoError(t, err)
+	require.Len(t, checksums["test"], len("sha256:")+64)
+
+	packages, metadataChecksums, err := r.FetchMetadata(repos, "", "x86_64")
+	require.NoError(t, err)
+	require.Equal(t, checksums, metadataChecksums)
+	require.Len(t, packages, 6)
+	require.Equal(t, "app", packages[0].Name)
+	require.Equal(t, "MIT", packages[0].License)
+	require.Equal(t, int64(1588000000), packages[0].BuildTime.Unix())
+
+	// libfoo-3.0 conflicts with bash, which provides /bin/sh
+	deps, depsolveChecksums, err := r.Depsolve([]string{"app"}, nil, nil, repos, "", "x86_64")
+	require.NoError(t, err)
+	require.Equal(t, checksums, depsolveChecksums)
+	var nevras []string
+	for _, dep := range deps {
+		nevras = append(nevras, dep.Name+"-"+dep.Version+"."+dep.Arch)
+	}
+	require.Equal(t, []string{"app-1.0.x86_64", "bash-5.0.x86_64", "libfoo-2.0.x86_64", "vim-8.2.noarch"}, nevras)
+	require.Equal(t, PackageSpec{
+		Name:           "app",
+		Version:        "1.0",
+		Release:        "1",
+		Arch:           "x86_64",
+		RepoID:         "test",
+		Path:           "Packages/app-1.0-1.x86_64.rpm",
+		RemoteLocation: server.URL + "/repo/Packages/app-1.0-1.x86_64.rpm",
+		Checksum:       "sha256:app1.0",
+		InstallSize:    100,
+		Mirror:         server.URL + "/repo/",
+	}, deps[0])
+
+	deps, _, err = r.Depsolve([]string{"libfoo-1*"}, nil, nil, repos, "", "x86_64")
+	require.NoError(t, err)
+	require.Len(t, deps, 1)
+	require.Equal(t, "1.0", deps[0].Version)
+
+	_, _, err = r.Depsolve([]string{"app"}, []string{"libfoo-2.0"}, nil, repos, "", "x86_64")
+	require.IsType(t, &DNFError{}, err)
+	require.Equal(t, "DepsolveError", err.(*DNFError).Kind)
+
+	for _, specs := range [][]string{{"nothing"}, {"@core"}} {
+		_, _, err = r.Depsolve(specs, nil, nil, repos, "", "x86_64")
+		require.IsType(t, &DNFError{}, err)
+		require.Equal(t, "MarkingErrors", err.(*DNFError).Kind)
+	}
+
+	_, _, err = r.Depsolve([]string{"app"}, nil, []string{"app:1"}, repos, "", "x86_64")
+	require.IsType(t, &DNFError{}, err)
+
+	// mirrors which cannot be reached are skipped
+	mirrored := []RepoConfig{{Id: "test", MirrorList: server.URL + "/mirrorlist?arch=$basearch"}}
+	mirroredChecksums, err := r.FetchChecksums(mirrored, "", "x86_64")
+	require.NoError(t, err)
+	require.Equal(t, checksums, mirroredChecksums)
+
+	_, err = r.FetchChecksums([]RepoConfig{{Id: "test", BaseURL: server.URL + "/missing/"}}, "", "x86_64")
+	require.IsType(t, &DNFError{}, err)
+	require.Equal(t, "RepoError", err.(*DNFError).Kind)
+}
+
+func TestNativeRPMMDPriorities(t *testing.T) {
+	server := testRepoServer(t)
+	defer server.Close()
+
+	r := NewNativeRPMMD("")
+
+	// libfoo is only taken from the repository with the lower priority
+	repos := []RepoConfig{
+		{Id: "test", BaseURL: server.URL + "/repo/"},
+		{Id: "old", BaseURL: server.URL + "/old/", Priority: 10},
+	}
+	deps, _, err := r.Depsolve([]string{"libfoo"}, nil, nil, repos, "", "x86_64")
+	require.NoError(t, err)
+	require.Len(t, deps, 1)
+	require.Equal(t, "1.5", deps[0].Version)
+	_, _, err = r.Depsolve([]string{"app"}, nil, nil, repos, "", "x86_64")
+	require.Error(t, err)
+
+	repos[1].Priority = 0
+	deps, _, err = r.Depsolve([]string{"libfoo"}, nil, nil, repos, "", "x86_64")
+	require.NoError(t, err)
+	require.Equal(t, "3.0", deps[0].Version)
+
+	// identical packages are taken from the cheaper repository
+	repos = []RepoConfig{
+		{Id: "test", BaseURL: server.URL + "/repo/"},
+		{Id: "copy", BaseURL: server.URL + "/repo/", Cost: 100},
+	}
+	deps, _, err = r.Depsolve([]string{"app"}, nil, nil, repos, "", "x86_64")
+	require.NoError(t, err)
+	for _, dep := range deps {
+		require.Equal(t, "copy", dep.RepoID)
+	}
+}
+
+func TestNativeRPMMDMirrors(t *testing.T) {
+	server := testRepoServer(t)
+	defer server.Close()
+
+	repos := []RepoConfig{{Id: "test", BaseURL: server.URL + "/missing/", Mirrors: []string{server.URL + "/$basearch/", server.URL + "/repo/"}}}
+	deps, _, err := NewNativeRPMMD("").Depsolve([]string{"vim"}, nil, nil, repos, "", "x86_64")
+	require.NoError(t, err)
+	require.Equal(t, server.URL+"/repo/", deps[0].Mirror)
+	require.Equal(t, server.URL+"/repo/Packages/vim-8.2-1.noarch.rpm", deps[0].RemoteLocation)
+}
+
+func TestRelationOverlaps(t *testing.T) {
+	r := func(flags string, evr string) relation {
+		return relation{name: "foo", flags: parseFlags(flags), evr: parseEVR(evr)}
+	}
+
+	cases := []struct {
+		provide, require relation
+		expected         bool
+	}{
+		{r("", ""), r("GE", "2.0"), true},
+		{r("EQ", "2.0-1"), r("", ""), true},
+		{r("EQ", "2.0-1"), r("GE", "2.0"), true},
+		{r("EQ", "2.0-1"), r("GT", "2.0-1"), false},
+		{r("EQ", "2.0-1"), r("LT", "2.0-2"), true},
+		{r("EQ", "1:1.0-1"), r("GE", "2.0"), true},
+		{r("EQ", "1.0-1"), r("EQ", "1.0"), true},
+		{r("EQ", "1.0-1"), r("EQ", "1.1"), false},
+		{r("GE", "1.0"), r("LT", "2.0"), true},
+		{r("LE", "1.0"), r("GT", "1.0"), false},
+	}
+
+	for _, c := range cases {
+		require.Equal(t, c.expected, c.provide.overlaps(c.require), "%s overlaps %s", c.provide, c.require)
+	}
+}
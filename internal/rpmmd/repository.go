@@ -20,6 +20,25 @@ type RepoConfig struct {
 	GPGKey         string `json:"gpgkey,omitempty"`
 	IgnoreSSL      bool   `json:"ignoressl"`
 	MetadataExpire string `json:"metadata_expire,omitempty"`
+
+	// Proxy, CA certificate, and client certificate to reach the
+	// repository with, as in dnf's repository options of the same name.
+	Proxy         string `json:"proxy,omitempty"`
+	SSLCACert     string `json:"sslcacert,omitempty"`
+	SSLClientCert string `json:"sslclientcert,omitempty"`
+	SSLClientKey  string `json:"sslclientkey,omitempty"`
+
+	// Additional armored keys to check packages against
+	GPGKeys []string `json:"gpgkeys,omitempty"`
+
+	// Mirrors are base URLs which are tried in order when BaseURL cannot
+	// be reached. Priority and Cost are dnf's repository options of the
+	// same name: packages are only taken from the repositories with the
+	// lowest priority that have them, and preferably from the one with the
+	// lowest cost. Zero means dnf's default.
+	Mirrors  []string `json:"mirrors,omitempty"`
+	Priority int      `json:"priority,omitempty"`
+	Cost     int      `json:"cost,omitempty"`
 }
 
 type PackageList []Package
@@ -35,6 +54,9 @@ type Package struct {
 	Arch        string
 	BuildTime   time.Time
 	License     string
+
+	// The repository the package is available from
+	RepoID string `json:"repo_id,omitempty"`
 }
 
 func (pkg Package) ToPackageBuild() PackageBuild {
@@ -44,6 +66,7 @@ func (pkg Package) ToPackageBuild() PackageBuild {
 		BuildTime: pkg.BuildTime.Format("2006-01-02T15:04:05"),
 		Epoch:     pkg.Epoch,
 		Release:   pkg.Release,
+		RepoID:    pkg.RepoID,
 		Source: PackageSource{
 			License: pkg.License,
 			Version: pkg.Version,
@@ -72,6 +95,13 @@ type PackageSpec struct {
 	Path           string `json:"path,omitempty"`
 	RemoteLocation string `json:"remote_location,omitempty"`
 	Checksum       string `json:"checksum,omitempty"`
+
+	// Bytes the package takes up when installed, 0 if unknown
+	InstallSize uint64 `json:"installsize,omitempty"`
+
+	// The base URL of the mirror of the repository that RemoteLocation
+	// points to
+	Mirror string `json:"mirror,omitempty"`
 }
 
 type PackageSource struct {
@@ -84,6 +114,7 @@ type PackageBuild struct {
 	BuildTime string        `json:"build_time"`
 	Epoch     uint          `json:"epoch"`
 	Release   string        `json:"release"`
+	RepoID    string        `json:"repo_id,omitempty"`
 	Source    PackageSource `json:"source"`
 }
 
@@ -102,10 +133,47 @@ type RPMMD interface {
 	// list of packages and dictionary of checksums of the repositories.
 	FetchMetadata(repos []RepoConfig, modulePlatformID string, arch string) (PackageList, map[string]string, error)
 
-	// Depsolve takes a list of required content (specs), explicitly unwanted content (excludeSpecs), list
-	// or repositories, and platform ID for modularity. It returns a list of all packages (with solved
-	// dependencies) that will be installed into the system.
-	Depsolve(specs, excludeSpecs []string, repos []RepoConfig, modulePlatformID, arch string) ([]PackageSpec, map[string]string, error)
+	// FetchChecksums returns the checksums of the repositories' metadata,
+	// which change whenever the metadata does. It is much cheaper than
+	// FetchMetadata, because it doesn't load the packages.
+	FetchChecksums(repos []RepoConfig, modulePlatformID string, arch string) (map[string]string, error)
+
+	Depsolver
+}
+
+// A Depsolver solves the dependencies of packages. dnf-json is the default
+// one, the native one (see NewNativeRPMMD()) works without the dnf stack.
+type Depsolver interface {
+	// Depsolve takes a list of required content (specs), explicitly unwanted content (excludeSpecs), module
+	// streams to enable (moduleSpecs, as "name:stream"), list or repositories, and platform ID for modularity.
+	// It returns a list of all packages (with solved dependencies) that will be installed into the system.
+	Depsolve(specs, excludeSpecs, moduleSpecs []string, repos []RepoConfig, modulePlatformID, arch string) ([]PackageSpec, map[string]string, error)
+}
+
+// Depsolvers which can be selected with NewRPMMDFor()
+const (
+	DepsolverDNF    = "dnf"
+	DepsolverNative = "native"
+
+	// dnf if it is available, the native depsolver otherwise
+	DepsolverAuto = "auto"
+)
+
+// NewRPMMDFor returns the RPMMD of `depsolver`, which keeps its cache in
+// `cacheDir`.
+func NewRPMMDFor(depsolver, cacheDir string) (RPMMD, error) {
+	switch depsolver {
+	case DepsolverDNF:
+		return NewRPMMD(cacheDir), nil
+	case DepsolverNative:
+		return NewNativeRPMMD(cacheDir), nil
+	case DepsolverAuto:
+		if exec.Command("python3", "-c", "import dnf").Run() != nil {
+			return NewNativeRPMMD(cacheDir), nil
+		}
+		return NewRPMMD(cacheDir), nil
+	}
+	return nil, fmt.Errorf("unknown depsolver: %s", depsolver)
 }
 
 type DNFError struct {
@@ -224,6 +292,7 @@ func NewRPMMD(cacheDir string) RPMMD {
 }
 
 func (r *rpmmdImpl) FetchMetadata(repos []RepoConfig, modulePlatformID string, arch string) (PackageList, map[string]string, error) {
+	repos = selectMirrors(repos, arch)
 	var arguments = struct {
 		Repos            []RepoConfig `json:"repos"`
 		CacheDir         string       `json:"cachedir"`
@@ -241,15 +310,32 @@ func (r *rpmmdImpl) FetchMetadata(repos []RepoConfig, modulePlatformID string, a
 	return reply.Packages, reply.Checksums, err
 }
 
-func (r *rpmmdImpl) Depsolve(specs, excludeSpecs []string, repos []RepoConfig, modulePlatformID, arch string) ([]PackageSpec, map[string]string, error) {
+func (r *rpmmdImpl) FetchChecksums(repos []RepoConfig, modulePlatformID string, arch string) (map[string]string, error) {
+	repos = selectMirrors(repos, arch)
+	var arguments = struct {
+		Repos            []RepoConfig `json:"repos"`
+		CacheDir         string       `json:"cachedir"`
+		ModulePlatformID string       `json:"module_platform_id"`
+		Arch             string       `json:"arch"`
+	}{repos, r.CacheDir, modulePlatformID, arch}
+	var reply struct {
+		Checksums map[string]string `json:"checksums"`
+	}
+	err := runDNF("checksums", arguments, &reply)
+	return reply.Checksums, err
+}
+
+func (r *rpmmdImpl) Depsolve(specs, excludeSpecs, moduleSpecs []string, repos []RepoConfig, modulePlatformID, arch string) ([]PackageSpec, map[string]string, error) {
+	repos = selectMirrors(repos, arch)
 	var arguments = struct {
 		PackageSpecs     []string     `json:"package-specs"`
 		ExcludSpecs      []string     `json:"exclude-specs"`
+		ModuleSpecs      []string     `json:"module-specs,omitempty"`
 		Repos            []RepoConfig `json:"repos"`
 		CacheDir         string       `json:"cachedir"`
 		ModulePlatformID string       `json:"module_platform_id"`
 		Arch             string       `json:"arch"`
-	}{specs, excludeSpecs, repos, r.CacheDir, modulePlatformID, arch}
+	}{specs, excludeSpecs, moduleSpecs, repos, r.CacheDir, modulePlatformID, arch}
 	var reply struct {
 		Checksums    map[string]string `json:"checksums"`
 		Dependencies []PackageSpec     `json:"dependencies"`
@@ -311,6 +397,6 @@ func (packages PackageList) ToPackageInfos() []PackageInfo {
 }
 
 func (pkg *PackageInfo) FillDependencies(rpmmd RPMMD, repos []RepoConfig, modulePlatformID string, arch string) (err error) {
-	pkg.Dependencies, _, err = rpmmd.Depsolve([]string{pkg.Name}, nil, repos, modulePlatformID, arch)
+	pkg.Dependencies, _, err = rpmmd.Depsolve([]string{pkg.Name}, nil, nil, repos, modulePlatformID, arch)
 	return
 }
@@ -0,0 +1,420 @@
+package rpmmd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+// The native depsolver resolves dependencies like dnf does for most package
+// sets, but it is much simpler: it installs the newest version of each
+// requested package and satisfies the requirements of installed packages one
+// by one, preferring providers whose name is the requirement's name, then
+// newer and shorter-named ones. When a choice leads to a conflict, it tries
+// the next provider (a backtracking search, like a SAT solver without
+// learning). Weak dependencies, modularity, and rich dependencies other than
+// "(a or b)" are not supported. The latter are ignored.
+
+// How many choices the solver makes before it gives up
+const maxSolverSteps = 100000
+
+var errTooComplex = errors.New("giving up, the transaction is too complex for the native depsolver")
+
+// Comparison flags of dependencies, as in rpm's repodata
+const (
+	flagLT = 1 << iota
+	flagGT
+	flagEQ
+)
+
+// A relation is a provide, requirement, conflict, or obsolete of a package.
+// Relations without flags match any version.
+type relation struct {
+	name  string
+	flags int
+	evr   PackageSpec // only Epoch, Version, and Release are set
+
+	// Alternatives of a rich dependency "(a or b or ...)", which is
+	// satisfied when any of them is
+	or []relation
+}
+
+func parseFlags(flags string) int {
+	switch flags {
+	case "EQ":
+		return flagEQ
+	case "LT":
+		return flagLT
+	case "LE":
+		return flagLT | flagEQ
+	case "GT":
+		return flagGT
+	case "GE":
+		return flagGT | flagEQ
+	}
+	return 0
+}
+
+func (r relation) String() string {
+	if len(r.or) > 0 {
+		var alternatives []string
+		for _, a := range r.or {
+			alternatives = append(alternatives, a.String())
+		}
+		return "(" + strings.Join(alternatives, " or ") + ")"
+	}
+	if r.flags == 0 {
+		return r.name
+	}
+
+	op := map[int]string{
+		flagLT: "<", flagLT | flagEQ: "<=", flagEQ: "=", flagGT: ">", flagGT | flagEQ: ">=",
+	}[r.flags]
+	evr := r.evr.Version
+	if r.evr.Epoch != 0 {
+		evr = fmt.Sprintf("%d:%s", r.evr.Epoch, evr)
+	}
+	if r.evr.Release != "" {
+		evr += "-" + r.evr.Release
+	}
+	return r.name + " " + op + " " + evr
+}
+
+// parseRichDependency parses rich dependencies of the form "(a or b ...)",
+// whose alternatives are names or simple comparisons like "b >= 1.0". It
+// returns false for all other rich dependencies.
+func parseRichDependency(dep string) (relation, bool) {
+	if !strings.HasPrefix(dep, "(") || !strings.HasSuffix(dep, ")") {
+		return relation{}, false
+	}
+	inner := dep[1 : len(dep)-1]
+	if strings.ContainsAny(inner, "()") {
+		return relation{}, false
+	}
+
+	r := relation{name: dep}
+	for _, alternative := range strings.Split(inner, " or ") {
+		fields := strings.Fields(alternative)
+		switch len(fields) {
+		case 1:
+			r.or = append(r.or, relation{name: fields[0]})
+		case 3:
+			flags := map[string]string{"<": "LT", "<=": "LE", "=": "EQ", ">": "GT", ">=": "GE"}[fields[1]]
+			if flags == "" {
+				return relation{}, false
+			}
+			r.or = append(r.or, relation{name: fields[0], flags: parseFlags(flags), evr: parseEVR(fields[2])})
+		default:
+			return relation{}, false
+		}
+	}
+
+	return r, true
+}
+
+// parseEVR parses "[epoch:]version[-release]".
+func parseEVR(evr string) PackageSpec {
+	var spec PackageSpec
+	if i := strings.Index(evr, ":"); i >= 0 {
+		var epoch uint
+		if _, err := fmt.Sscanf(evr[:i], "%d", &epoch); err == nil {
+			spec.Epoch = epoch
+		}
+		evr = evr[i+1:]
+	}
+	if i := strings.LastIndex(evr, "-"); i >= 0 {
+		spec.Version, spec.Release = evr[:i], evr[i+1:]
+	} else {
+		spec.Version = evr
+	}
+	return spec
+}
+
+// compareRelationEVR compares like CompareEVR(), but ignores the release if
+// either side doesn't have one, like rpm does for dependencies.
+func compareRelationEVR(a, b PackageSpec) int {
+	if a.Release == "" || b.Release == "" {
+		a.Release, b.Release = "", ""
+	}
+	return CompareEVR(a, b)
+}
+
+// overlaps returns true if the versions that `a` and `b` match overlap, which
+// is when a provide `a` satisfies a requirement `b`.
+func (a relation) overlaps(b relation) bool {
+	if a.name != b.name {
+		return false
+	}
+	if a.flags == 0 || b.flags == 0 {
+		return true
+	}
+
+	c := compareRelationEVR(a.evr, b.evr)
+	switch {
+	case c < 0:
+		return a.flags&flagGT != 0 || b.flags&flagLT != 0
+	case c > 0:
+		return a.flags&flagLT != 0 || b.flags&flagGT != 0
+	default:
+		return a.flags&b.flags != 0
+	}
+}
+
+// An rpmPackage is a package in the repositories the solver installs from.
+type rpmPackage struct {
+	spec PackageSpec
+	info Package
+
+	provides  []relation
+	requires  []relation
+	conflicts []relation
+	obsoletes []relation
+}
+
+func (p *rpmPackage) nevra() string {
+	return fmt.Sprintf("%s-%s", p.spec.Name, p.evra())
+}
+
+func (p *rpmPackage) evra() string {
+	evr := p.spec.Version + "-" + p.spec.Release + "." + p.spec.Arch
+	if p.spec.Epoch != 0 {
+		return fmt.Sprintf("%d:%s", p.spec.Epoch, evr)
+	}
+	return evr
+}
+
+// self returns the relation "name = evr" of the package itself.
+func (p *rpmPackage) self() relation {
+	return relation{name: p.spec.Name, flags: flagEQ, evr: p.spec}
+}
+
+func (p *rpmPackage) providesAny(r relation) bool {
+	for _, provide := range p.provides {
+		if provide.overlaps(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// A pool holds the packages that can be installed, indexed by what they
+// provide.
+type pool struct {
+	packages  []*rpmPackage
+	providers map[string][]*rpmPackage
+}
+
+func newPool(packages []*rpmPackage) *pool {
+	p := &pool{packages: packages, providers: make(map[string][]*rpmPackage)}
+	for _, pkg := range packages {
+		seen := make(map[string]bool)
+		for _, provide := range pkg.provides {
+			if !seen[provide.name] {
+				p.providers[provide.name] = append(p.providers[provide.name], pkg)
+				seen[provide.name] = true
+			}
+		}
+	}
+	return p
+}
+
+// whatProvides returns the packages that satisfy `r`, most preferred first.
+func (p *pool) whatProvides(r relation) []*rpmPackage {
+	var candidates []*rpmPackage
+	if len(r.or) > 0 {
+		seen := make(map[*rpmPackage]bool)
+		for _, alternative := range r.or {
+			for _, pkg := range p.whatProvides(alternative) {
+				if !seen[pkg] {
+					candidates = append(candidates, pkg)
+					seen[pkg] = true
+				}
+			}
+		}
+		return candidates
+	}
+
+	for _, pkg := range p.providers[r.name] {
+		if pkg.providesAny(r) {
+			candidates = append(candidates, pkg)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i].spec, candidates[j].spec
+		if (a.Name == r.name) != (b.Name == r.name) {
+			return a.Name == r.name
+		}
+		if a.Name == b.Name {
+			return CompareEVR(a, b) > 0
+		}
+		if len(a.Name) != len(b.Name) {
+			return len(a.Name) < len(b.Name)
+		}
+		return a.Name < b.Name
+	})
+
+	return candidates
+}
+
+// match returns the packages whose name, name-version, name-version-release,
+// or name-version-release.arch matches the glob `spec`, newest first for each
+// name.
+func (p *pool) match(spec string) ([]*rpmPackage, error) {
+	g, err := glob.Compile(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []*rpmPackage
+	for _, pkg := range p.packages {
+		s := pkg.spec
+		if g.Match(s.Name) || g.Match(s.Name+"-"+s.Version) || g.Match(s.Name+"-"+s.Version+"-"+s.Release) ||
+			g.Match(pkg.nevra()) || g.Match(s.Name+"."+s.Arch) {
+			matches = append(matches, pkg)
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i].spec, matches[j].spec
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return CompareEVR(a, b) > 0
+	})
+
+	return matches, nil
+}
+
+type solver struct {
+	pool      *pool
+	installed []*rpmPackage
+	byName    map[string]*rpmPackage // by name.arch
+	steps     int
+}
+
+func newSolver(p *pool) *solver {
+	return &solver{pool: p, byName: make(map[string]*rpmPackage)}
+}
+
+func (s *solver) satisfied(r relation) bool {
+	for _, pkg := range s.pool.whatProvides(r) {
+		if s.byName[pkg.spec.Name+"."+pkg.spec.Arch] == pkg {
+			return true
+		}
+	}
+	return false
+}
+
+// canInstall returns an error if `pkg` cannot be installed alongside the
+// installed packages.
+func (s *solver) canInstall(pkg *rpmPackage) error {
+	if other, exists := s.byName[pkg.spec.Name+"."+pkg.spec.Arch]; exists && other != pkg {
+		return fmt.Errorf("%s cannot be installed alongside %s", pkg.nevra(), other.nevra())
+	}
+
+	for _, other := range s.installed {
+		for _, c := range pkg.conflicts {
+			if other.providesAny(c) {
+				return fmt.Errorf("%s conflicts with %s provided by %s", pkg.nevra(), c, other.nevra())
+			}
+		}
+		for _, c := range other.conflicts {
+			if pkg.providesAny(c) {
+				return fmt.Errorf("%s conflicts with %s provided by %s", other.nevra(), c, pkg.nevra())
+			}
+		}
+		for _, o := range pkg.obsoletes {
+			if other.self().overlaps(o) {
+				return fmt.Errorf("%s obsoletes %s", pkg.nevra(), other.nevra())
+			}
+		}
+		for _, o := range other.obsoletes {
+			if pkg.self().overlaps(o) {
+				return fmt.Errorf("%s obsoletes %s", other.nevra(), pkg.nevra())
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *solver) add(pkg *rpmPackage) {
+	s.installed = append(s.installed, pkg)
+	s.byName[pkg.spec.Name+"."+pkg.spec.Arch] = pkg
+}
+
+// undo removes the packages which were installed after the first `n`.
+func (s *solver) undo(n int) {
+	for _, pkg := range s.installed[n:] {
+		delete(s.byName, pkg.spec.Name+"."+pkg.spec.Arch)
+	}
+	s.installed = s.installed[:n]
+}
+
+// install installs the newest of `candidates` that can be installed.
+func (s *solver) install(spec string, candidates []*rpmPackage) error {
+	var err error
+	for _, pkg := range candidates {
+		if s.byName[pkg.spec.Name+"."+pkg.spec.Arch] == pkg {
+			return nil
+		}
+		err = s.canInstall(pkg)
+		if err == nil {
+			s.add(pkg)
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot install %s: %v", spec, err)
+}
+
+// solve satisfies the requirements of all installed packages, starting at
+// requirement `req` of the `i`th package. The requirements before it are
+// already satisfied.
+func (s *solver) solve(i, req int) error {
+	for ; i < len(s.installed); i, req = i+1, 0 {
+		pkg := s.installed[i]
+		for ; req < len(pkg.requires); req++ {
+			r := pkg.requires[req]
+			if s.satisfied(r) {
+				continue
+			}
+			return s.choose(pkg, r, i, req)
+		}
+	}
+	return nil
+}
+
+// choose installs a provider of requirement `r` of `pkg` and solves the
+// remaining requirements, trying the next provider if that fails.
+func (s *solver) choose(pkg *rpmPackage, r relation, i, req int) error {
+	var firstErr error
+	for _, candidate := range s.pool.whatProvides(r) {
+		s.steps++
+		if s.steps > maxSolverSteps {
+			return errTooComplex
+		}
+
+		err := s.canInstall(candidate)
+		if err == nil {
+			mark := len(s.installed)
+			s.add(candidate)
+			err = s.solve(i, req+1)
+			if err == nil || err == errTooComplex {
+				return err
+			}
+			s.undo(mark)
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return fmt.Errorf("nothing provides %s needed by %s", r, pkg.nevra())
+}
@@ -0,0 +1,111 @@
+package rpmmd
+
+import (
+	"strings"
+	"unicode"
+)
+
+// CompareEVR compares the epoch, version, and release of two packages the
+// way rpm does. It returns a negative number when `a` is older than `b`, a
+// positive number when it is newer, and 0 when both are the same.
+func CompareEVR(a, b PackageSpec) int {
+	if a.Epoch != b.Epoch {
+		if a.Epoch < b.Epoch {
+			return -1
+		}
+		return 1
+	}
+	if c := CompareVersions(a.Version, b.Version); c != 0 {
+		return c
+	}
+	return CompareVersions(a.Release, b.Release)
+}
+
+// CompareVersions compares two version or release strings with the
+// algorithm of rpmvercmp(): strings are split into alternating segments of
+// digits and letters, which are compared one by one. Numeric segments are
+// newer than alphabetic ones and a tilde sorts before anything, even the end
+// of the string.
+func CompareVersions(a, b string) int {
+	for {
+		a = strings.TrimLeftFunc(a, isVersionSeparator)
+		b = strings.TrimLeftFunc(b, isVersionSeparator)
+
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		if a == "" || b == "" {
+			break
+		}
+
+		numeric := isDigit(a[0])
+		var segA, segB string
+		if numeric {
+			segA, a = splitSegment(a, isDigit)
+			segB, b = splitSegment(b, isDigit)
+		} else {
+			segA, a = splitSegment(a, isLetter)
+			segB, b = splitSegment(b, isLetter)
+		}
+
+		// segments of different types
+		if segB == "" {
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+
+		if numeric {
+			segA = strings.TrimLeft(segA, "0")
+			segB = strings.TrimLeft(segB, "0")
+			if len(segA) != len(segB) {
+				if len(segA) < len(segB) {
+					return -1
+				}
+				return 1
+			}
+		}
+
+		if c := strings.Compare(segA, segB); c != 0 {
+			return c
+		}
+	}
+
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	default:
+		return 1
+	}
+}
+
+func splitSegment(s string, f func(byte) bool) (string, string) {
+	i := 0
+	for i < len(s) && f(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isVersionSeparator(r rune) bool {
+	return r != '~' && !(r < unicode.MaxASCII && (isDigit(byte(r)) || isLetter(byte(r))))
+}
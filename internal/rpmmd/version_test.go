@@ -0,0 +1,40 @@
+package rpmmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "2.0", -1},
+		{"2.0.1", "2.0", 1},
+		{"1.10", "1.9", 1},
+		{"1.010", "1.10", 0},
+		{"1.0a", "1.0", 1},
+		{"1.0a", "1.0.1", -1},
+		{"1.0", "1_0", 0},
+		{"a", "1", -1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.1.1g", "1.1.1f", 1},
+		{"2.fc32", "10.fc32", -1},
+	}
+
+	for _, c := range cases {
+		require.Equal(t, c.expected, CompareVersions(c.a, c.b), "%s <=> %s", c.a, c.b)
+		require.Equal(t, -c.expected, CompareVersions(c.b, c.a), "%s <=> %s", c.b, c.a)
+	}
+}
+
+func TestCompareEVR(t *testing.T) {
+	old := PackageSpec{Name: "openssl", Version: "1.1.1g", Release: "1.fc32"}
+	require.Equal(t, 0, CompareEVR(old, old))
+	require.Equal(t, -1, CompareEVR(old, PackageSpec{Name: "openssl", Version: "1.1.1g", Release: "2.fc32"}))
+	require.Equal(t, 1, CompareEVR(PackageSpec{Name: "openssl", Epoch: 1, Version: "1.0"}, old))
+}
@@ -0,0 +1,617 @@
+package rpmmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Repositories can offer their metadata as zchunk files
+// (https://github.com/zchunk/zchunk), which consist of independently
+// compressed chunks whose checksums are listed in the file's header. When the
+// metadata changes, only the chunks which are not in the previous version of
+// the file need to be downloaded. The native RPMMD keeps the previous version
+// in its cache directory.
+
+const zckID = "\x00ZCK1"
+
+// Flags of zchunk files. Files with streams or checksums of the uncompressed
+// chunks are not supported.
+const (
+	zckFlagOptionalElements = 1 << 1
+)
+
+// Compression types of zchunk files
+const (
+	zckCompressionNone = 0
+	zckCompressionZstd = 2
+)
+
+// The largest zchunk header that is read. Headers of the metadata of large
+// repositories are a few megabytes.
+const maxZckHeaderSize = 64 * 1024 * 1024
+
+var errZckUnsupported = errors.New("unsupported zchunk file")
+
+type zckChunk struct {
+	checksum []byte
+	offset   int64
+	length   int64
+}
+
+type zckHeader struct {
+	raw         []byte // the lead and header, which precede the chunks
+	compression uint64
+	checksum    func([]byte) []byte
+
+	// The first chunk is the compression dictionary, which is empty if
+	// there is none
+	chunks []zckChunk
+}
+
+// zckChecksum returns the checksum function and size of zchunk checksum
+// type `t`.
+func zckChecksum(t uint64) (func([]byte) []byte, int, error) {
+	sum := func(h func() hash.Hash, size int) func([]byte) []byte {
+		return func(data []byte) []byte {
+			s := h()
+			_, _ = s.Write(data)
+			return s.Sum(nil)[:size]
+		}
+	}
+
+	switch t {
+	case 0:
+		return sum(sha1.New, sha1.Size), sha1.Size, nil
+	case 1:
+		return sum(sha256.New, sha256.Size), sha256.Size, nil
+	case 2:
+		return sum(sha512.New, sha512.Size), sha512.Size, nil
+	case 3:
+		// SHA-512/128: the first half of the SHA-512 checksum
+		return sum(sha512.New, 16), 16, nil
+	}
+	return nil, 0, fmt.Errorf("unknown zchunk checksum type %d", t)
+}
+
+// readCompint reads a zchunk integer, which is stored in little endian with
+// seven bits per byte. The last byte has its high bit set.
+func readCompint(r io.ByteReader) (uint64, error) {
+	var n uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		n |= uint64(b&0x7f) << shift
+		if b&0x80 != 0 {
+			return n, nil
+		}
+	}
+	return 0, errors.New("invalid zchunk integer")
+}
+
+// leadReader records the bytes of the lead of a zchunk file while reading
+// it.
+type leadReader struct {
+	r    *bufio.Reader
+	lead bytes.Buffer
+}
+
+func (l *leadReader) ReadByte() (byte, error) {
+	b, err := l.r.ReadByte()
+	if err == nil {
+		l.lead.WriteByte(b)
+	}
+	return b, err
+}
+
+func (l *leadReader) read(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	_, err := io.ReadFull(l.r, buf)
+	l.lead.Write(buf)
+	return buf, err
+}
+
+// readZckHeader reads the lead and header of a zchunk file of `size` bytes
+// from `r`, which is left at the start of the first chunk. `size` is negative
+// if it is unknown.
+func readZckHeader(r *bufio.Reader, size int64) (*zckHeader, error) {
+	if size < 0 {
+		size = math.MaxInt64
+	}
+
+	l := &leadReader{r: r}
+
+	id, err := l.read(len(zckID))
+	if err != nil || string(id) != zckID {
+		return nil, errors.New("not a zchunk file")
+	}
+
+	checksumType, err := readCompint(l)
+	if err != nil {
+		return nil, err
+	}
+	checksum, checksumSize, err := zckChecksum(checksumType)
+	if err != nil {
+		return nil, err
+	}
+
+	headerSize, err := readCompint(l)
+	if err != nil {
+		return nil, err
+	}
+	headerChecksum, err := l.read(checksumSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(l.lead.Len()) > size || headerSize > maxZckHeaderSize || headerSize > uint64(size-int64(l.lead.Len())) {
+		return nil, fmt.Errorf("invalid zchunk header: size of %d bytes exceeds the file", headerSize)
+	}
+	header := make([]byte, headerSize)
+	_, err = io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+
+	// the header checksum covers everything but itself
+	lead := l.lead.Bytes()
+	covered := append(append([]byte{}, lead[:len(lead)-checksumSize]...), header...)
+	if !bytes.Equal(checksum(covered), headerChecksum) {
+		return nil, errors.New("zchunk header checksum mismatch")
+	}
+
+	z := &zckHeader{raw: append(lead, header...)}
+	err = z.parse(bytes.NewReader(header), checksumSize, size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid zchunk header: %v", err)
+	}
+
+	return z, nil
+}
+
+// parse parses the preface, index, and signatures of a zchunk file of `size`
+// bytes.
+func (z *zckHeader) parse(r *bytes.Reader, checksumSize int, size int64) error {
+	skip := func(n uint64) error {
+		if n > uint64(r.Len()) {
+			return io.ErrUnexpectedEOF
+		}
+		_, err := r.Seek(int64(n), io.SeekCurrent)
+		return err
+	}
+
+	// the checksum of all chunks, which are verified individually instead
+	err := skip(uint64(checksumSize))
+	if err != nil {
+		return err
+	}
+
+	flags, err := readCompint(r)
+	if err != nil {
+		return err
+	}
+	if flags&^zckFlagOptionalElements != 0 {
+		return errZckUnsupported
+	}
+
+	z.compression, err = readCompint(r)
+	if err != nil {
+		return err
+	}
+	if z.compression != zckCompressionNone && z.compression != zckCompressionZstd {
+		return errZckUnsupported
+	}
+
+	if flags&zckFlagOptionalElements != 0 {
+		count, err := readCompint(r)
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < count; i++ {
+			_, err = readCompint(r) // type
+			if err != nil {
+				return err
+			}
+			size, err := readCompint(r)
+			if err != nil {
+				return err
+			}
+			err = skip(size)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// the size of the index
+	_, err = readCompint(r)
+	if err != nil {
+		return err
+	}
+
+	chunkChecksumType, err := readCompint(r)
+	if err != nil {
+		return err
+	}
+	z.checksum, checksumSize, err = zckChecksum(chunkChecksumType)
+	if err != nil {
+		return err
+	}
+
+	count, err := readCompint(r)
+	if err != nil {
+		return err
+	}
+	offset := int64(len(z.raw))
+	for i := uint64(0); i < count; i++ {
+		c := zckChunk{checksum: make([]byte, checksumSize), offset: offset}
+		_, err = io.ReadFull(r, c.checksum)
+		if err != nil {
+			return err
+		}
+		length, err := readCompint(r)
+		if err != nil {
+			return err
+		}
+		if length > uint64(size-offset) {
+			return fmt.Errorf("chunk %d of %d bytes exceeds the file", i, length)
+		}
+		c.length = int64(length)
+		_, err = readCompint(r) // the uncompressed length
+		if err != nil {
+			return err
+		}
+		z.chunks = append(z.chunks, c)
+		offset += c.length
+	}
+
+	if len(z.chunks) == 0 {
+		return errors.New("no dictionary chunk")
+	}
+
+	// signatures are not checked, like the rest of the metadata
+	return nil
+}
+
+// openZck opens the zchunk file at `path` and reads its header.
+func openZck(path string) (*os.File, *zckHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	z, err := readZckHeader(bufio.NewReader(f), info.Size())
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, z, nil
+}
+
+// fetchZck downloads the zchunk file at `url` to `path`, reusing the chunks
+// of the file that is already there. `checksum` is the checksum of the new
+// file, as listed in repomd.xml ("type:hex").
+func fetchZck(client *http.Client, url, path, checksum string) error {
+	response, err := getResponse(client, url)
+	if err != nil {
+		return err
+	}
+	body := response.Body
+	defer body.Close()
+
+	remote := bufio.NewReader(body)
+	z, err := readZckHeader(remote, response.ContentLength)
+	if err != nil {
+		return err
+	}
+
+	// chunks of the previous version of the file, by checksum
+	var local *os.File
+	localChunks := make(map[string]zckChunk)
+	if f, old, err := openZck(path); err == nil {
+		defer f.Close()
+		local = f
+		for _, c := range old.chunks {
+			localChunks[string(c.checksum)] = c
+		}
+	}
+
+	// download everything if no chunk can be reused, or only the chunks
+	// which cannot be reused otherwise
+	reuse := false
+	for _, c := range z.chunks {
+		if _, exists := localChunks[string(c.checksum)]; exists && c.length > 0 {
+			reuse = true
+			break
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	out, err := ioutil.TempFile(filepath.Dir(path), ".zck-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	_, err = out.Write(z.raw)
+	if err != nil {
+		return err
+	}
+
+	if reuse {
+		body.Close()
+		err = z.assemble(out, local, localChunks, func(offset, length int64) ([]byte, error) {
+			return fetchRange(client, url, offset, length)
+		})
+	} else {
+		err = z.assemble(out, nil, nil, func(offset, length int64) ([]byte, error) {
+			return readFull(remote, length)
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	err = verifyFileChecksum(out, checksum)
+	if err != nil {
+		return err
+	}
+
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), path)
+}
+
+// assemble writes the chunks of `z` to `out`, copying them from `local` if
+// they are in `localChunks`, and fetching runs of the other chunks with
+// `fetchFunc`, in the order in which they appear in the file.
+func (z *zckHeader) assemble(out io.Writer, local io.ReaderAt, localChunks map[string]zckChunk, fetchFunc func(offset, length int64) ([]byte, error)) error {
+	for i := 0; i < len(z.chunks); {
+		if c, exists := localChunks[string(z.chunks[i].checksum)]; exists {
+			data := make([]byte, c.length)
+			_, err := local.ReadAt(data, c.offset)
+			if err != nil {
+				return err
+			}
+			_, err = out.Write(data)
+			if err != nil {
+				return err
+			}
+			i++
+			continue
+		}
+
+		// fetch all consecutive chunks which are missing at once
+		j := i
+		length := int64(0)
+		for ; j < len(z.chunks); j++ {
+			if _, exists := localChunks[string(z.chunks[j].checksum)]; exists {
+				break
+			}
+			length += z.chunks[j].length
+		}
+
+		data, err := fetchFunc(z.chunks[i].offset, length)
+		if err != nil {
+			return err
+		}
+		for _, c := range z.chunks[i:j] {
+			chunk := data[c.offset-z.chunks[i].offset:][:c.length]
+			if c.length > 0 && !bytes.Equal(z.checksum(chunk), c.checksum) {
+				return errors.New("zchunk chunk checksum mismatch")
+			}
+		}
+		_, err = out.Write(data)
+		if err != nil {
+			return err
+		}
+
+		i = j
+	}
+
+	return nil
+}
+
+// fetchRange fetches `length` bytes at `offset` of the file at `url`.
+func fetchRange(client *http.Client, url string, offset, length int64) ([]byte, error) {
+	if length == 0 {
+		return nil, nil
+	}
+
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("cannot fetch part of %s: %s", url, response.Status)
+	}
+
+	return readFull(response.Body, length)
+}
+
+// readFull reads `length` bytes from `r`. Unlike io.ReadFull(), it only
+// allocates as much as `r` really contains, because `length` comes from
+// headers of remote files.
+func readFull(r io.Reader, length int64) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, length))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) < length {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
+
+// verifyFileChecksum checks that the file `f` has `checksum` ("type:hex").
+func verifyFileChecksum(f *os.File, checksum string) error {
+	var h hash.Hash
+	parts := strings.SplitN(checksum, ":", 2)
+	switch parts[0] {
+	case "sha", "sha1":
+		h = sha1.New()
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return fmt.Errorf("unsupported checksum: %s", checksum)
+	}
+
+	_, err := f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+
+	if len(parts) != 2 || hex.EncodeToString(h.Sum(nil)) != parts[1] {
+		return fmt.Errorf("checksum mismatch of %s", filepath.Base(f.Name()))
+	}
+	return nil
+}
+
+// zstdDecompress decompresses `data` with the zstd tool, using the
+// dictionary `dict` if it isn't empty.
+func zstdDecompress(data io.Reader, dict string) (io.ReadCloser, error) {
+	args := []string{"-d", "-c", "-q"}
+	if dict != "" {
+		args = append(args, "-D", dict)
+	}
+	cmd := exec.Command("zstd", args...)
+	cmd.Stdin = data
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	return &cmdReader{stdout, cmd}, nil
+}
+
+type cmdReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (r *cmdReader) Close() error {
+	r.ReadCloser.Close()
+	return r.cmd.Wait()
+}
+
+// readZck returns the decompressed contents of the zchunk file at `path`.
+// Files compressed with zstd are decompressed with the zstd tool.
+func readZck(path string) (io.ReadCloser, error) {
+	f, z, err := openZck(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dict := z.chunks[0]
+	end := z.chunks[len(z.chunks)-1].offset + z.chunks[len(z.chunks)-1].length
+	data := io.NewSectionReader(f, dict.offset+dict.length, end-dict.offset-dict.length)
+
+	if z.compression == zckCompressionNone {
+		return &sectionCloser{data, f}, nil
+	}
+
+	var dictPath string
+	if dict.length > 0 {
+		d, err := zstdDecompress(io.NewSectionReader(f, dict.offset, dict.length), "")
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		dictFile, err := ioutil.TempFile("", "zck-dict-")
+		if err == nil {
+			dictPath = dictFile.Name()
+			_, err = io.Copy(dictFile, d)
+			if cerr := dictFile.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if cerr := d.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			f.Close()
+			if dictPath != "" {
+				os.Remove(dictPath)
+			}
+			return nil, fmt.Errorf("cannot decompress zchunk dictionary: %v", err)
+		}
+	}
+
+	r, err := zstdDecompress(data, dictPath)
+	if err != nil {
+		f.Close()
+		if dictPath != "" {
+			os.Remove(dictPath)
+		}
+		return nil, err
+	}
+
+	return &zstdCloser{r, f, dictPath}, nil
+}
+
+type sectionCloser struct {
+	io.Reader
+	f *os.File
+}
+
+func (s *sectionCloser) Close() error {
+	return s.f.Close()
+}
+
+type zstdCloser struct {
+	io.ReadCloser
+	f        *os.File
+	dictPath string
+}
+
+func (z *zstdCloser) Close() error {
+	err := z.ReadCloser.Close()
+	z.f.Close()
+	if z.dictPath != "" {
+		os.Remove(z.dictPath)
+	}
+	return err
+}
@@ -0,0 +1,296 @@
+package rpmmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// compint encodes `n` like readCompint() decodes it.
+func compint(n uint64) []byte {
+	var buf []byte
+	for n >= 0x80 {
+		buf = append(buf, byte(n&0x7f))
+		n >>= 7
+	}
+	return append(buf, byte(n)|0x80)
+}
+
+// makeZck returns a zchunk file without a dictionary, whose chunks are
+// `chunks` compressed with `compress`.
+func makeZck(compression uint64, compress func(string) []byte, chunks ...string) []byte {
+	var index []zckIndexEntry
+	var data bytes.Buffer
+	for _, chunk := range chunks {
+		compressed := compress(chunk)
+		checksum := sha512.Sum512(compressed)
+		index = append(index, zckIndexEntry{checksum[:16], uint64(len(compressed)), uint64(len(chunk))})
+		data.Write(compressed)
+	}
+	return makeZckFile(compression, index, data.Bytes())
+}
+
+// zckIndexEntry is a chunk in the index of a zchunk file.
+type zckIndexEntry struct {
+	checksum []byte
+	length   uint64
+	size     uint64
+}
+
+// makeZckFile returns a zchunk file without a dictionary, whose chunks are
+// listed in `index` and contained in `data`. Both need not match.
+func makeZckFile(compression uint64, index []zckIndexEntry, data []byte) []byte {
+	var indexData bytes.Buffer
+	indexData.Write(compint(3)) // SHA-512/128
+	indexData.Write(compint(uint64(len(index) + 1)))
+	indexData.Write(make([]byte, 16))
+	indexData.Write(compint(0))
+	indexData.Write(compint(0))
+	for _, c := range index {
+		indexData.Write(c.checksum)
+		indexData.Write(compint(c.length))
+		indexData.Write(compint(c.size))
+	}
+
+	var header bytes.Buffer
+	dataChecksum := sha256.Sum256(data)
+	header.Write(dataChecksum[:])
+	header.Write(compint(0))
+	header.Write(compint(compression))
+	header.Write(compint(uint64(indexData.Len())))
+	header.Write(indexData.Bytes())
+	header.Write(compint(0)) // no signatures
+
+	lead := append([]byte(zckID), compint(1)...)
+	lead = append(lead, compint(uint64(header.Len()))...)
+	headerChecksum := sha256.Sum256(append(append([]byte{}, lead...), header.Bytes()...))
+
+	file := append(append(lead, headerChecksum[:]...), header.Bytes()...)
+	return append(file, data...)
+}
+
+func makeUncompressedZck(chunks ...string) []byte {
+	return makeZck(zckCompressionNone, func(chunk string) []byte { return []byte(chunk) }, chunks...)
+}
+
+func fileChecksum(data []byte) string {
+	checksum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(checksum[:])
+}
+
+func bufioReader(data []byte) *bufio.Reader {
+	return bufio.NewReader(bytes.NewReader(data))
+}
+
+// zckServer serves a zchunk file and records the ranges that were requested.
+type zckServer struct {
+	mu     sync.Mutex
+	file   []byte
+	ranges []string
+}
+
+func (z *zckServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if r.Header.Get("Range") != "" {
+		z.ranges = append(z.ranges, r.Header.Get("Range"))
+	}
+	http.ServeContent(w, r, "primary.xml.zck", time.Time{}, bytes.NewReader(z.file))
+}
+
+func (z *zckServer) set(file []byte) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.file = file
+	z.ranges = nil
+}
+
+func TestFetchZck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test", "primary.xml.zck")
+
+	zs := &zckServer{}
+	server := httptest.NewServer(zs)
+	defer server.Close()
+
+	read := func() string {
+		r, err := readZck(path)
+		require.NoError(t, err)
+		defer r.Close()
+		data, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	a, b, c := strings.Repeat("a", 1000), strings.Repeat("b", 1000), strings.Repeat("c", 1000)
+
+	// without a previous version, the whole file is downloaded at once
+	v1 := makeUncompressedZck(a, b, c)
+	zs.set(v1)
+	err = fetchZck(server.Client(), server.URL, path, fileChecksum(v1))
+	require.NoError(t, err)
+	require.Empty(t, zs.ranges)
+	require.Equal(t, a+b+c, read())
+
+	// only the chunks which changed are downloaded
+	v2 := makeUncompressedZck(a, "B", c, "d")
+	zs.set(v2)
+	err = fetchZck(server.Client(), server.URL, path, fileChecksum(v2))
+	require.NoError(t, err)
+	header, err := readZckHeader(bufioReader(v2), int64(len(v2)))
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		fmt.Sprintf("bytes=%d-%d", header.chunks[2].offset, header.chunks[2].offset),
+		fmt.Sprintf("bytes=%d-%d", header.chunks[4].offset, header.chunks[4].offset),
+	}, zs.ranges)
+	require.Equal(t, a+"B"+c+"d", read())
+
+	// the previous version is kept if the new one doesn't match its checksum
+	zs.set(makeUncompressedZck(a))
+	err = fetchZck(server.Client(), server.URL, path, fileChecksum(v1))
+	require.Error(t, err)
+	require.Equal(t, a+"B"+c+"d", read())
+
+	// corrupt headers are detected
+	corrupt := append([]byte{}, v1...)
+	corrupt[len(zckID)+3] ^= 0xff
+	_, err = readZckHeader(bufioReader(corrupt), int64(len(corrupt)))
+	require.Error(t, err)
+
+	// and so are headers listing chunks which the file doesn't contain
+	invalid := makeZckFile(zckCompressionNone, []zckIndexEntry{{make([]byte, 16), 1 << 40, 1 << 40}}, []byte("a"))
+	zs.set(invalid)
+	err = fetchZck(server.Client(), server.URL, path, fileChecksum(invalid))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "exceeds the file")
+	require.Equal(t, a+"B"+c+"d", read())
+}
+
+func TestReadZckHeaderMalformed(t *testing.T) {
+	lead := func(headerSize uint64) []byte {
+		lead := append([]byte(zckID), compint(1)...)
+		lead = append(lead, compint(headerSize)...)
+		return append(lead, make([]byte, sha256.Size)...)
+	}
+	chunk := func(length uint64) zckIndexEntry {
+		return zckIndexEntry{make([]byte, 16), length, length}
+	}
+	valid := makeZckFile(zckCompressionNone, []zckIndexEntry{chunk(1)}, []byte("a"))
+
+	tests := []struct {
+		name string
+		file []byte
+		size int64
+		err  string
+	}{
+		{"header size above the maximum", lead(maxZckHeaderSize + 1), -1, "exceeds the file"},
+		{"header size above math.MaxInt64", lead(math.MaxUint64), -1, "exceeds the file"},
+		{"header size beyond the file", lead(1000), int64(len(lead(1000))), "exceeds the file"},
+		{"truncated header", lead(1000), -1, "EOF"},
+		{"file shorter than the lead", valid, 3, "exceeds the file"},
+		{"invalid header size", append([]byte(zckID), 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00), -1, "invalid zchunk integer"},
+		{"chunk length above math.MaxInt64", makeZckFile(zckCompressionNone, []zckIndexEntry{chunk(math.MaxUint64)}, nil), -1, "exceeds the file"},
+		{"chunk lengths overflowing", makeZckFile(zckCompressionNone, []zckIndexEntry{chunk(math.MaxInt64 / 2), chunk(math.MaxInt64 / 2)}, nil), -1, "exceeds the file"},
+		{"chunk beyond the file", valid, int64(len(valid) - 1), "exceeds the file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readZckHeader(bufioReader(tt.file), tt.size)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.err)
+		})
+	}
+
+	header, err := readZckHeader(bufioReader(valid), int64(len(valid)))
+	require.NoError(t, err)
+	require.Len(t, header.chunks, 2)
+}
+
+func TestReadZckZstd(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("zstd is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "primary.xml.zck")
+
+	compress := func(chunk string) []byte {
+		cmd := exec.Command("zstd", "-c", "-q")
+		cmd.Stdin = strings.NewReader(chunk)
+		compressed, err := cmd.Output()
+		require.NoError(t, err)
+		return compressed
+	}
+	err = ioutil.WriteFile(path, makeZck(zckCompressionZstd, compress, "<metadata>", "<package/>", "</metadata>"), 0600)
+	require.NoError(t, err)
+
+	r, err := readZck(path)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, "<metadata><package/></metadata>", string(data))
+}
+
+func TestNativeRPMMDZchunk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// one chunk per package
+	packages := []string{
+		fmt.Sprintf(testPackage, "bash", "x86_64", "5.0", `<file>/bin/sh</file>`),
+		fmt.Sprintf(testPackage, "vim", "noarch", "8.2", ""),
+	}
+	chunks := append([]string{"<metadata>"}, packages...)
+	zck := makeUncompressedZck(append(chunks, "</metadata>")...)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repo/repodata/repomd.xml", func(w http.ResponseWriter, r *http.Request) {
+		checksum := strings.SplitN(fileChecksum(zck), ":", 2)[1]
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<repomd xmlns="http://linux.duke.edu/metadata/repo">
+  <data type="primary_zck">
+    <checksum type="sha256">%s</checksum>
+    <location href="repodata/primary.xml.zck"/>
+  </data>
+</repomd>`, checksum)
+	})
+	mux.HandleFunc("/repo/repodata/primary.xml.zck", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "primary.xml.zck", time.Time{}, bytes.NewReader(zck))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	repos := []RepoConfig{{Id: "test", BaseURL: server.URL + "/repo/"}}
+	deps, _, err := NewNativeRPMMD(dir).Depsolve([]string{"vim"}, nil, nil, repos, "", "x86_64")
+	require.NoError(t, err)
+	require.Len(t, deps, 1)
+	require.Equal(t, "vim", deps[0].Name)
+	require.FileExists(t, filepath.Join(dir, repoCacheName(repos[0]), "primary.xml.zck"))
+
+	// without a cache directory, only the whole metadata can be used
+	_, _, err = NewNativeRPMMD("").Depsolve([]string{"vim"}, nil, nil, repos, "", "x86_64")
+	require.IsType(t, &DNFError{}, err)
+}
@@ -0,0 +1,280 @@
+// Package scan scans images for vulnerabilities after they were built.
+//
+// Scans are jobs in the job queue, which depend on the osbuild job of the
+// image they scan (see worker.Server.EnqueueScan()). A Runner dequeues them
+// in composer itself, because that's where images are stored, and runs an
+// external scanner on the image.
+package scan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+// Config configures the scanner. It is usually loaded from a TOML file:
+//
+//	command = ["/usr/libexec/scan-image", "--json"]
+//	format = "generic"
+//	fail_on = "CRITICAL"
+//
+// The path of the image is appended to `command`, which must print its
+// findings to stdout in `format`:
+//
+//	generic  {"findings": [{"id": ..., "package": ..., "severity": ..., "title": ...}]}
+//	trivy    trivy's JSON output
+//
+// If `fail_on` is set, composes with findings of that severity or higher are
+// marked as failed, as are composes whose image could not be scanned.
+type Config struct {
+	Command []string `toml:"command"`
+	Format  string   `toml:"format"`
+	FailOn  string   `toml:"fail_on"`
+}
+
+func LoadConfig(path string) (*Config, error) {
+	var config Config
+	_, err := toml.DecodeFile(path, &config)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load scanner configuration: %v", err)
+	}
+
+	return &config, nil
+}
+
+// Severities in increasing order
+var severities = []string{"UNKNOWN", "LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+func severityRank(severity string) int {
+	for i, s := range severities {
+		if strings.EqualFold(s, severity) {
+			return i
+		}
+	}
+	return 0
+}
+
+func isSeverity(severity string) bool {
+	for _, s := range severities {
+		if strings.EqualFold(s, severity) {
+			return true
+		}
+	}
+	return false
+}
+
+type Scanner struct {
+	config Config
+}
+
+func NewScanner(config Config) (*Scanner, error) {
+	if len(config.Command) == 0 {
+		return nil, errors.New("scanner command is empty")
+	}
+
+	if config.Format == "" {
+		config.Format = "generic"
+	}
+	if config.Format != "generic" && config.Format != "trivy" {
+		return nil, fmt.Errorf("unknown scanner output format: %s", config.Format)
+	}
+
+	if config.FailOn != "" && !isSeverity(config.FailOn) {
+		return nil, fmt.Errorf("unknown severity: %s", config.FailOn)
+	}
+
+	return &Scanner{config}, nil
+}
+
+// Name returns a short name of the scanner, for reports.
+func (s *Scanner) Name() string {
+	return s.config.Command[0]
+}
+
+// Scan runs the scanner on the image at `path` and returns its findings.
+func (s *Scanner) Scan(path string) ([]worker.ScanFinding, error) {
+	args := append(append([]string{}, s.config.Command[1:]...), path)
+	cmd := exec.Command(s.config.Command[0], args...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %v: %s", s.Name(), err, strings.TrimSpace(stderr.String()))
+	}
+
+	switch s.config.Format {
+	case "trivy":
+		return parseTrivy(stdout.Bytes())
+	default:
+		return parseGeneric(stdout.Bytes())
+	}
+}
+
+// Violates returns true if any of `findings` fails the configured policy.
+func (s *Scanner) Violates(findings []worker.ScanFinding) bool {
+	if s.config.FailOn == "" {
+		return false
+	}
+
+	threshold := severityRank(s.config.FailOn)
+	for _, f := range findings {
+		if severityRank(f.Severity) >= threshold {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseGeneric(data []byte) ([]worker.ScanFinding, error) {
+	var report struct {
+		Findings []worker.ScanFinding `json:"findings"`
+	}
+	err := json.Unmarshal(data, &report)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse scanner output: %v", err)
+	}
+
+	for i := range report.Findings {
+		report.Findings[i].Severity = strings.ToUpper(report.Findings[i].Severity)
+	}
+
+	return report.Findings, nil
+}
+
+type trivyResult struct {
+	Vulnerabilities []struct {
+		VulnerabilityID string
+		PkgName         string
+		Severity        string
+		Title           string
+	}
+}
+
+func parseTrivy(data []byte) ([]worker.ScanFinding, error) {
+	// Older versions of trivy print a list of results, newer ones wrap
+	// it in an object.
+	var results []trivyResult
+	err := json.Unmarshal(data, &results)
+	if err != nil {
+		var report struct {
+			Results []trivyResult
+		}
+		err = json.Unmarshal(data, &report)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse trivy output: %v", err)
+		}
+		results = report.Results
+	}
+
+	findings := []worker.ScanFinding{}
+	for _, r := range results {
+		for _, v := range r.Vulnerabilities {
+			findings = append(findings, worker.ScanFinding{
+				ID:       v.VulnerabilityID,
+				Package:  v.PkgName,
+				Severity: strings.ToUpper(v.Severity),
+				Title:    v.Title,
+			})
+		}
+	}
+
+	return findings, nil
+}
+
+// A Runner runs scan jobs from a job queue.
+type Runner struct {
+	jobs    jobqueue.JobQueue
+	store   *store.Tenants
+	scanner *Scanner
+
+	durationRecorder func(phase string, d time.Duration)
+}
+
+func NewRunner(jobs jobqueue.JobQueue, store *store.Tenants, scanner *Scanner) *Runner {
+	return &Runner{jobs: jobs, store: store, scanner: scanner}
+}
+
+// SetDurationRecorder sets the function which is called with how long each
+// scan took, see worker.Server.RecordPhaseDuration().
+func (r *Runner) SetDurationRecorder(durationRecorder func(phase string, d time.Duration)) {
+	r.durationRecorder = durationRecorder
+}
+
+// Run scans images until `ctx` is canceled.
+func (r *Runner) Run(ctx context.Context) error {
+	for {
+		var job worker.ScanJob
+		id, err := r.jobs.Dequeue(ctx, []string{worker.ScanJobType}, &job)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		started := time.Now()
+		result := r.scan(job)
+		if r.durationRecorder != nil {
+			r.durationRecorder(worker.PhaseScan, time.Since(started))
+		}
+		if result.Error != "" {
+			log.Printf("scanning image of compose %s failed: %s", job.ComposeID, result.Error)
+		}
+
+		err = r.jobs.FinishJob(id, result)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (r *Runner) scan(job worker.ScanJob) worker.ScanJobResult {
+	result := worker.ScanJobResult{
+		Scanner:  r.scanner.Name(),
+		Findings: []worker.ScanFinding{},
+	}
+
+	findings, err := r.scanImage(job)
+	if err != nil {
+		// Images that couldn't be scanned can't be trusted either
+		result.Error = err.Error()
+		result.PolicyViolated = r.scanner.config.FailOn != ""
+		return result
+	}
+
+	result.Findings = findings
+	result.PolicyViolated = r.scanner.Violates(findings)
+	return result
+}
+
+func (r *Runner) scanImage(job worker.ScanJob) ([]worker.ScanFinding, error) {
+	image, _, err := r.store.GetImageBuildImage(job.ComposeID, job.ImageBuildID)
+	if err != nil {
+		return nil, err
+	}
+	defer image.Close()
+
+	f, ok := image.(*os.File)
+	if !ok {
+		return nil, errors.New("image is not stored in a file")
+	}
+
+	return r.scanner.Scan(f.Name())
+}
@@ -0,0 +1,153 @@
+package scan
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/distro/fedoratest"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue/fsjobqueue"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/target"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+func TestParse(t *testing.T) {
+	findings, err := parseGeneric([]byte(`{"findings":[{"id":"CVE-1","package":"bash","severity":"high"}]}`))
+	require.NoError(t, err)
+	require.Equal(t, []worker.ScanFinding{{ID: "CVE-1", Package: "bash", Severity: "HIGH"}}, findings)
+
+	trivy := []worker.ScanFinding{{ID: "CVE-2", Package: "glibc", Severity: "CRITICAL", Title: "overflow"}}
+	findings, err = parseTrivy([]byte(`[{"Target":"image","Vulnerabilities":[{"VulnerabilityID":"CVE-2","PkgName":"glibc","Severity":"CRITICAL","Title":"overflow"}]}]`))
+	require.NoError(t, err)
+	require.Equal(t, trivy, findings)
+	findings, err = parseTrivy([]byte(`{"Results":[{"Target":"image","Vulnerabilities":[{"VulnerabilityID":"CVE-2","PkgName":"glibc","Severity":"CRITICAL","Title":"overflow"}]}]}`))
+	require.NoError(t, err)
+	require.Equal(t, trivy, findings)
+
+	_, err = parseGeneric([]byte(`not json`))
+	require.Error(t, err)
+}
+
+func TestPolicy(t *testing.T) {
+	_, err := NewScanner(Config{})
+	require.Error(t, err)
+	_, err = NewScanner(Config{Command: []string{"true"}, Format: "xml"})
+	require.Error(t, err)
+	_, err = NewScanner(Config{Command: []string{"true"}, FailOn: "apocalyptic"})
+	require.Error(t, err)
+
+	s, err := NewScanner(Config{Command: []string{"true"}})
+	require.NoError(t, err)
+	require.False(t, s.Violates([]worker.ScanFinding{{Severity: "CRITICAL"}}))
+
+	s, err = NewScanner(Config{Command: []string{"true"}, FailOn: "high"})
+	require.NoError(t, err)
+	require.False(t, s.Violates([]worker.ScanFinding{{Severity: "MEDIUM"}, {Severity: "UNKNOWN"}}))
+	require.True(t, s.Violates([]worker.ScanFinding{{Severity: "LOW"}, {Severity: "CRITICAL"}}))
+}
+
+func TestRunner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scan-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// a scanner that finds a critical vulnerability in images containing "bad"
+	scriptPath := path.Join(dir, "scanner")
+	err = ioutil.WriteFile(scriptPath, []byte(`#!/bin/sh
+if grep -q bad "$1"; then
+	echo '{"findings":[{"id":"CVE-1","severity":"critical"}]}'
+else
+	echo '{"findings":[]}'
+fi
+`), 0700)
+	require.NoError(t, err)
+
+	scanner, err := NewScanner(Config{Command: []string{scriptPath}, FailOn: "CRITICAL"})
+	require.NoError(t, err)
+
+	queueDir := path.Join(dir, "jobs")
+	require.NoError(t, os.Mkdir(queueDir, 0700))
+	jobs, err := fsjobqueue.New(queueDir)
+	require.NoError(t, err)
+
+	// the compose belongs to a tenant, which the runner has to find
+	tenants, err := store.NewTenants(store.New(&dir), nil)
+	require.NoError(t, err)
+	s, err := tenants.Get("web")
+	require.NoError(t, err)
+	workers := worker.NewServer(nil, jobs, tenants.AddImageToImageUpload, dir)
+	workers.EnableScans()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = NewRunner(jobs, tenants, scanner).Run(ctx)
+	}()
+
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+
+	// builds a compose with `content` as the image and returns its id
+	build := func(content string) uuid.UUID {
+		jobId, err := workers.Enqueue("fedoratest", "x86_64", nil, nil, nil, jobqueue.PriorityNormal)
+		require.NoError(t, err)
+
+		id := uuid.New()
+		targets := []*target.Target{
+			target.NewLocalTarget(&target.LocalTargetOptions{Filename: imageType.Filename()}),
+		}
+		err = s.PushCompose(id, nil, imageType, &blueprint.Blueprint{Name: "octopus"}, 0, targets, nil, jobId)
+		require.NoError(t, err)
+
+		scanJobId, err := workers.EnqueueScan(jobId, id, 0)
+		require.NoError(t, err)
+		require.NoError(t, s.SetImageBuildScanJob(id, 0, scanJobId))
+
+		_, err = jobs.Dequeue(context.Background(), []string{"osbuild:x86_64/fedoratest"}, &worker.OSBuildJob{})
+		require.NoError(t, err)
+		err = ioutil.WriteFile(path.Join(dir, "tenants", "web", "outputs", id.String(), "0", imageType.Filename()), []byte(content), 0600)
+		require.NoError(t, err)
+		err = jobs.FinishJob(jobId, worker.OSBuildJobResult{OSBuildOutput: &common.ComposeResult{Success: true}})
+		require.NoError(t, err)
+
+		return id
+	}
+
+	// waits for the scan of compose `id` and returns the compose's state
+	waitForScan := func(id uuid.UUID) (common.ComposeState, *worker.ScanJobResult) {
+		c, _ := s.GetCompose(id)
+		for i := 0; i < 100; i++ {
+			result, err := workers.ScanResult(c.ImageBuilds[0].ScanJobId)
+			require.NoError(t, err)
+			if result != nil {
+				state, _, _, _ := workers.ComposeState(c)
+				return state, result
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatal("image was not scanned")
+		return common.CWaiting, nil
+	}
+
+	state, result := waitForScan(build("good"))
+	require.Equal(t, common.CFinished, state)
+	require.Empty(t, result.Findings)
+	require.False(t, result.PolicyViolated)
+
+	state, result = waitForScan(build("bad"))
+	require.Equal(t, common.CFailed, state)
+	require.Equal(t, []worker.ScanFinding{{ID: "CVE-1", Severity: "CRITICAL"}}, result.Findings)
+	require.True(t, result.PolicyViolated)
+}
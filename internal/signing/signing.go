@@ -0,0 +1,207 @@
+// Package signing signs images after they were built.
+//
+// Like scans, signing jobs are jobs in the job queue, which depend on the
+// osbuild job of the image they sign (see worker.Server.EnqueueSigning()). A
+// Runner dequeues them in composer itself, because that's where images are
+// stored, computes the checksum of the image, and signs it with an external
+// tool. The checksum and the signature are stored next to the image.
+package signing
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+// Config configures the signer. It is usually loaded from a TOML file:
+//
+//	type = "gpg"
+//	key = "releng@example.com"
+//	homedir = "/etc/osbuild-composer/gnupg"
+//
+// `type` is one of:
+//
+//	gpg       detached, ASCII-armored signatures made by gpg with the secret
+//	          key `key` from the keyring in `homedir`, or gpg's default
+//	          keyring if it is not set
+//	sigstore  signatures made by cosign with the private key in the file
+//	          `key`, whose password is read from $COSIGN_PASSWORD
+type Config struct {
+	Type    string `toml:"type"`
+	Key     string `toml:"key"`
+	Homedir string `toml:"homedir"`
+}
+
+func LoadConfig(path string) (*Config, error) {
+	var config Config
+	_, err := toml.DecodeFile(path, &config)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load signing configuration: %v", err)
+	}
+
+	return &config, nil
+}
+
+type Signer struct {
+	name string
+
+	// The command which reads the image from stdin and writes its
+	// signature to stdout
+	command []string
+}
+
+func NewSigner(config Config) (*Signer, error) {
+	if config.Key == "" {
+		return nil, errors.New("signing key is not set")
+	}
+
+	switch config.Type {
+	case "gpg":
+		command := []string{"gpg", "--batch", "--yes", "--armor", "--detach-sign", "--output", "-", "--local-user", config.Key}
+		if config.Homedir != "" {
+			command = append(command, "--homedir", config.Homedir)
+		}
+		return &Signer{name: "gpg", command: command}, nil
+
+	case "sigstore":
+		if config.Homedir != "" {
+			return nil, errors.New("homedir is only supported by gpg signers")
+		}
+		command := []string{"cosign", "sign-blob", "--yes", "--key", config.Key, "-"}
+		return &Signer{name: "sigstore", command: command}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown signer type: %s", config.Type)
+	}
+}
+
+// Name returns the type of the signer, for reports.
+func (s *Signer) Name() string {
+	return s.name
+}
+
+// Sign signs the image read from `image` and returns its checksum, as
+// "sha256:<hex>", and its signature.
+func (s *Signer) Sign(image io.Reader) (string, []byte, error) {
+	hash := sha256.New()
+
+	cmd := exec.Command(s.command[0], s.command[1:]...)
+	cmd.Stdin = io.TeeReader(image, hash)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return "", nil, fmt.Errorf("%s failed: %v: %s", s.command[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	// Signers might stop reading before the end of the image
+	_, err = io.Copy(hash, image)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if stdout.Len() == 0 {
+		return "", nil, fmt.Errorf("%s printed no signature", s.command[0])
+	}
+
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), stdout.Bytes(), nil
+}
+
+// A Runner runs signing jobs from a job queue.
+type Runner struct {
+	jobs   jobqueue.JobQueue
+	store  *store.Tenants
+	signer *Signer
+
+	durationRecorder func(phase string, d time.Duration)
+}
+
+func NewRunner(jobs jobqueue.JobQueue, store *store.Tenants, signer *Signer) *Runner {
+	return &Runner{jobs: jobs, store: store, signer: signer}
+}
+
+// SetDurationRecorder sets the function which is called with how long
+// signing each image took, see worker.Server.RecordPhaseDuration().
+func (r *Runner) SetDurationRecorder(durationRecorder func(phase string, d time.Duration)) {
+	r.durationRecorder = durationRecorder
+}
+
+// Run signs images until `ctx` is canceled.
+func (r *Runner) Run(ctx context.Context) error {
+	for {
+		var job worker.SignJob
+		id, err := r.jobs.Dequeue(ctx, []string{worker.SignJobType}, &job)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		started := time.Now()
+		result := r.sign(job)
+		if r.durationRecorder != nil {
+			r.durationRecorder(worker.PhaseSign, time.Since(started))
+		}
+		if result.Error != "" {
+			log.Printf("signing image of compose %s failed: %s", job.ComposeID, result.Error)
+		}
+
+		err = r.jobs.FinishJob(id, result)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (r *Runner) sign(job worker.SignJob) worker.SignJobResult {
+	result := worker.SignJobResult{
+		Signer: r.signer.Name(),
+	}
+
+	checksum, err := r.signImage(job)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Checksum = checksum
+	return result
+}
+
+func (r *Runner) signImage(job worker.SignJob) (string, error) {
+	image, _, err := r.store.GetImageBuildImage(job.ComposeID, job.ImageBuildID)
+	if err != nil {
+		return "", err
+	}
+	defer image.Close()
+
+	checksum, signature, err := r.signer.Sign(image)
+	if err != nil {
+		return "", err
+	}
+
+	err = r.store.SetImageBuildSignature(job.ComposeID, job.ImageBuildID, checksum, signature)
+	if err != nil {
+		return "", err
+	}
+
+	return checksum, nil
+}
@@ -0,0 +1,163 @@
+package signing
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/distro/fedoratest"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue/fsjobqueue"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/target"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+func TestNewSigner(t *testing.T) {
+	_, err := NewSigner(Config{Type: "gpg"})
+	require.Error(t, err)
+	_, err = NewSigner(Config{Type: "pencil", Key: "key"})
+	require.Error(t, err)
+	_, err = NewSigner(Config{Type: "sigstore", Key: "cosign.key", Homedir: "/tmp"})
+	require.Error(t, err)
+
+	s, err := NewSigner(Config{Type: "gpg", Key: "releng@example.com", Homedir: "/etc/gnupg"})
+	require.NoError(t, err)
+	require.Equal(t, "gpg", s.Name())
+	require.Contains(t, s.command, "--detach-sign")
+	require.Equal(t, []string{"--homedir", "/etc/gnupg"}, s.command[len(s.command)-2:])
+
+	s, err = NewSigner(Config{Type: "sigstore", Key: "cosign.key"})
+	require.NoError(t, err)
+	require.Equal(t, "sigstore", s.Name())
+	require.Equal(t, []string{"cosign", "sign-blob", "--yes", "--key", "cosign.key", "-"}, s.command)
+}
+
+func TestSign(t *testing.T) {
+	// a signer that only reads the beginning of the image
+	s := &Signer{name: "test", command: []string{"sh", "-c", "head -c 1 >/dev/null; echo signature"}}
+
+	image := strings.Repeat("image", 100000)
+	sum := sha256.Sum256([]byte(image))
+
+	checksum, signature, err := s.Sign(strings.NewReader(image))
+	require.NoError(t, err)
+	require.Equal(t, "sha256:"+hex.EncodeToString(sum[:]), checksum)
+	require.Equal(t, "signature\n", string(signature))
+
+	s = &Signer{name: "test", command: []string{"sh", "-c", "echo no key >&2; exit 1"}}
+	_, _, err = s.Sign(strings.NewReader(image))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "no key")
+
+	s = &Signer{name: "test", command: []string{"true"}}
+	_, _, err = s.Sign(strings.NewReader(image))
+	require.Error(t, err)
+}
+
+func TestRunner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signing-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// a signer that refuses to sign images containing "bad"
+	signer := &Signer{name: "test", command: []string{"sh", "-c", `if grep -q bad; then exit 1; fi; echo signature`}}
+
+	queueDir := path.Join(dir, "jobs")
+	require.NoError(t, os.Mkdir(queueDir, 0700))
+	jobs, err := fsjobqueue.New(queueDir)
+	require.NoError(t, err)
+
+	// the compose belongs to a tenant, which the runner has to find
+	tenants, err := store.NewTenants(store.New(&dir), nil)
+	require.NoError(t, err)
+	s, err := tenants.Get("web")
+	require.NoError(t, err)
+	workers := worker.NewServer(nil, jobs, tenants.AddImageToImageUpload, dir)
+	workers.EnableSigning()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = NewRunner(jobs, tenants, signer).Run(ctx)
+	}()
+
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+
+	// builds a compose with `content` as the image and returns its id
+	build := func(content string) uuid.UUID {
+		jobId, err := workers.Enqueue("fedoratest", "x86_64", nil, nil, nil, jobqueue.PriorityNormal)
+		require.NoError(t, err)
+
+		id := uuid.New()
+		targets := []*target.Target{
+			target.NewLocalTarget(&target.LocalTargetOptions{Filename: imageType.Filename()}),
+		}
+		err = s.PushCompose(id, nil, imageType, &blueprint.Blueprint{Name: "octopus"}, 0, targets, nil, jobId)
+		require.NoError(t, err)
+
+		signJobId, err := workers.EnqueueSigning(jobId, id, 0)
+		require.NoError(t, err)
+		require.NoError(t, s.SetImageBuildSignJob(id, 0, signJobId))
+
+		_, err = jobs.Dequeue(context.Background(), []string{"osbuild:x86_64/fedoratest"}, &worker.OSBuildJob{})
+		require.NoError(t, err)
+		err = ioutil.WriteFile(path.Join(dir, "tenants", "web", "outputs", id.String(), "0", imageType.Filename()), []byte(content), 0600)
+		require.NoError(t, err)
+		err = jobs.FinishJob(jobId, worker.OSBuildJobResult{OSBuildOutput: &common.ComposeResult{Success: true}})
+		require.NoError(t, err)
+
+		return id
+	}
+
+	// waits for the signing of compose `id` and returns the compose's state
+	waitForSigning := func(id uuid.UUID) (common.ComposeState, *worker.SignJobResult) {
+		c, _ := s.GetCompose(id)
+		for i := 0; i < 100; i++ {
+			result, err := workers.SignResult(c.ImageBuilds[0].SignJobId)
+			require.NoError(t, err)
+			if result != nil {
+				state, _, _, _ := workers.ComposeState(c)
+				return state, result
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatal("image was not signed")
+		return common.CWaiting, nil
+	}
+
+	id := build("good")
+	state, result := waitForSigning(id)
+	require.Equal(t, common.CFinished, state)
+	require.Empty(t, result.Error)
+	sum := sha256.Sum256([]byte("good"))
+	require.Equal(t, "sha256:"+hex.EncodeToString(sum[:]), result.Checksum)
+
+	checksum, err := s.GetImageBuildChecksum(id, 0)
+	require.NoError(t, err)
+	require.Equal(t, result.Checksum, checksum)
+	signature, err := s.GetImageBuildSignature(id, 0)
+	require.NoError(t, err)
+	require.Equal(t, "signature\n", string(signature))
+
+	id = build("bad")
+	state, result = waitForSigning(id)
+	require.Equal(t, common.CFailed, state)
+	require.NotEmpty(t, result.Error)
+	_, err = s.GetImageBuildSignature(id, 0)
+	require.Error(t, err)
+}
@@ -0,0 +1,69 @@
+package store
+
+import (
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/compose"
+)
+
+// An Admission describes a new compose to an AdmissionCheck.
+type Admission struct {
+	// The tenant of the store, "" for the default tenant
+	Tenant string
+
+	// The client that requested the compose, "" if it isn't known
+	Owner string
+
+	// Bytes the outputs of all image builds of the compose may take up
+	Size uint64
+}
+
+// An AdmissionCheck decides whether a new compose may be added to a store
+// which already contains `composes`, and returns an error if it may not.
+// `diskUsage` returns how many bytes the outputs of one of them take up.
+//
+// The check runs while the store is locked, so that composes which are
+// pushed at the same time are checked one after the other. It must neither
+// modify `composes` nor call back into the store.
+type AdmissionCheck func(admission Admission, composes map[uuid.UUID]compose.Compose, diskUsage func(uuid.UUID) int64) error
+
+// SetAdmissionCheck sets the check which new composes must pass before
+// PushCompose or PushComposeFor records them. Without it, all composes are
+// admitted.
+func (s *Store) SetAdmissionCheck(check AdmissionCheck) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.admissionCheck = check
+}
+
+// CheckAdmission returns the error of the admission check for a compose of
+// `owner`, whose outputs take up to `size` bytes, without recording it. It
+// lets callers refuse a compose before they queue its jobs; PushComposeFor
+// checks again, as other composes might have been pushed in the meantime.
+func (s *Store) CheckAdmission(owner string, size uint64) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.admit(owner, size)
+}
+
+// admit runs the admission check. The store must be locked.
+func (s *Store) admit(owner string, size uint64) error {
+	if s.admissionCheck == nil {
+		return nil
+	}
+	return s.admissionCheck(Admission{s.tenant, owner, size}, s.Composes, s.composeDiskUsage)
+}
+
+// SetAdmissionCheck sets the admission check of the stores of all tenants,
+// including those of tenants which are created later.
+func (t *Tenants) SetAdmissionCheck(check AdmissionCheck) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.admissionCheck = check
+	for _, s := range t.stores {
+		s.SetAdmissionCheck(check)
+	}
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/distro/fedoratest"
+	"github.com/osbuild/osbuild-composer/internal/osbuild"
+)
+
+func TestAdmissionCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+	bp := &blueprint.Blueprint{Name: "test"}
+
+	// admits one compose per owner
+	var admissions []Admission
+	s := New(&dir)
+	s.SetAdmissionCheck(func(admission Admission, composes map[uuid.UUID]compose.Compose, diskUsage func(uuid.UUID) int64) error {
+		admissions = append(admissions, admission)
+		for id, c := range composes {
+			require.Zero(t, diskUsage(id))
+			if c.Owner == admission.Owner {
+				return errors.New("owner has a compose already")
+			}
+		}
+		return nil
+	})
+
+	require.NoError(t, s.CheckAdmission("ci", 1))
+	id := uuid.New()
+	err = s.PushComposeFor("ci", 100, id, &osbuild.Manifest{}, imageType, bp, 50, nil, nil, uuid.New())
+	require.NoError(t, err)
+	c, exists := s.GetCompose(id)
+	require.True(t, exists)
+	require.Equal(t, "ci", c.Owner)
+	require.Equal(t, Admission{"", "ci", 1}, admissions[0])
+	require.Equal(t, Admission{"", "ci", 100}, admissions[1])
+
+	require.Error(t, s.CheckAdmission("ci", 1))
+	refused := uuid.New()
+	err = s.PushComposeFor("ci", 100, refused, &osbuild.Manifest{}, imageType, bp, 50, nil, nil, uuid.New())
+	require.EqualError(t, err, "owner has a compose already")
+	_, exists = s.GetCompose(refused)
+	require.False(t, exists)
+	_, err = os.Stat(s.getComposeDirectory(refused))
+	require.True(t, os.IsNotExist(err))
+
+	// composes pushed without an owner are checked, too
+	require.NoError(t, s.PushCompose(uuid.New(), &osbuild.Manifest{}, imageType, bp, 50, nil, nil, uuid.New()))
+	require.Error(t, s.PushCompose(uuid.New(), &osbuild.Manifest{}, imageType, bp, 50, nil, nil, uuid.New()))
+	require.Equal(t, Admission{"", "", 50}, admissions[len(admissions)-1])
+}
+
+// TestAdmissionCheckConcurrent makes sure that composes which are pushed at
+// the same time can't exceed a limit together.
+func TestAdmissionCheckConcurrent(t *testing.T) {
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+
+	s := New(nil)
+	s.SetAdmissionCheck(func(admission Admission, composes map[uuid.UUID]compose.Compose, diskUsage func(uuid.UUID) int64) error {
+		if len(composes) >= 5 {
+			return errors.New("too many composes")
+		}
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = s.PushCompose(uuid.New(), &osbuild.Manifest{}, imageType, &blueprint.Blueprint{Name: "test"}, 0, nil, nil, uuid.New())
+		}()
+	}
+	wg.Wait()
+
+	require.Len(t, s.GetAllComposes(), 5)
+}
+
+func TestTenantsAdmissionCheck(t *testing.T) {
+	tenants, err := NewTenants(New(nil), nil)
+	require.NoError(t, err)
+	existing, err := tenants.Get("existing")
+	require.NoError(t, err)
+
+	var checked []string
+	tenants.SetAdmissionCheck(func(admission Admission, composes map[uuid.UUID]compose.Compose, diskUsage func(uuid.UUID) int64) error {
+		checked = append(checked, admission.Tenant)
+		return nil
+	})
+
+	created, err := tenants.Get("created")
+	require.NoError(t, err)
+
+	for _, s := range []*Store{tenants.defaultStore, existing, created} {
+		require.NoError(t, s.CheckAdmission("", 0))
+	}
+	require.Equal(t, []string{"", "existing", "created"}, checked)
+}
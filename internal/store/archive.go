@@ -0,0 +1,288 @@
+package store
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+)
+
+// The version of the archive format written by ExportCompose
+const composeArchiveVersion = 1
+
+// The first entry of each compose archive, which describes the compose
+type composeArchiveHeader struct {
+	Version int             `json:"version"`
+	ID      uuid.UUID       `json:"id"`
+	Compose compose.Compose `json:"compose"`
+}
+
+// A ComposeStateFunc returns the state of a compose and the times it was
+// queued, started, and finished. The store cannot determine this itself,
+// because it doesn't know about jobs. worker.Server.ComposeState is the one
+// used in production.
+type ComposeStateFunc func(c compose.Compose) (state common.ComposeState, queued, started, finished time.Time)
+
+// ExportCompose writes a tar archive of the compose with `id` to `w`. It
+// contains the compose itself (including its manifest) as compose.json, a
+// copy of the manifest of each image build as <n>/manifest.json, and all
+// outputs of each image build (result.json with the logs, and the image) in
+// directory <n>/. Only finished and failed composes can be exported.
+//
+// Job queues are not shared between hosts. Thus, the state of the compose at
+// the time of the export is recorded in the archive, instead of references
+// to its jobs.
+func (s *Store) ExportCompose(id uuid.UUID, w io.Writer, state ComposeStateFunc) error {
+	c, exists := s.GetCompose(id)
+	if !exists {
+		return &NotFoundError{"compose does not exist"}
+	}
+	c = c.DeepCopy()
+
+	composeState, queued, started, finished := state(c)
+	var status common.ImageBuildState
+	switch composeState {
+	case common.CFinished:
+		status = common.IBFinished
+	case common.CFailed:
+		status = common.IBFailed
+	default:
+		return &InvalidRequestError{fmt.Sprintf("compose is %s, but only finished or failed composes can be exported", composeState.ToString())}
+	}
+
+	for i := range c.ImageBuilds {
+		ib := &c.ImageBuilds[i]
+		ib.QueueStatus = status
+		ib.JobCreated = queued
+		ib.JobStarted = started
+		ib.JobFinished = finished
+		ib.JobId = uuid.Nil
+		ib.ScanJobId = uuid.Nil
+		ib.SignJobId = uuid.Nil
+		ib.UploadJobId = uuid.Nil
+	}
+
+	tw := tar.NewWriter(w)
+	now := time.Now()
+
+	err := writeTarJSON(tw, "compose.json", now, composeArchiveHeader{
+		Version: composeArchiveVersion,
+		ID:      id,
+		Compose: c,
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, ib := range c.ImageBuilds {
+		if ib.Manifest != nil {
+			err := writeTarJSON(tw, fmt.Sprintf("%d/manifest.json", i), now, ib.Manifest)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.stateDir != nil {
+		dir := s.getComposeDirectory(id)
+		err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+
+			name, err := filepath.Rel(dir, p)
+			if err != nil {
+				return err
+			}
+
+			return writeTarFile(tw, filepath.ToSlash(name), p, info)
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("cannot export outputs of compose %s: %v", id, err)
+		}
+	}
+
+	return tw.Close()
+}
+
+// ImportCompose reads a tar archive written by ExportCompose from `r` and
+// adds the compose in it to the store, with the same id. It fails if a
+// compose with that id already exists.
+//
+// The imported compose is not published in the image gallery, and
+// promotions which hadn't finished when it was exported are dropped.
+func (s *Store) ImportCompose(r io.Reader) (uuid.UUID, error) {
+	tr := tar.NewReader(r)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return uuid.Nil, &InvalidRequestError{fmt.Sprintf("cannot read compose archive: %v", err)}
+	}
+	if hdr.Name != "compose.json" {
+		return uuid.Nil, &InvalidRequestError{"compose archive does not start with compose.json"}
+	}
+
+	var header composeArchiveHeader
+	err = json.NewDecoder(tr).Decode(&header)
+	if err != nil {
+		return uuid.Nil, &InvalidRequestError{fmt.Sprintf("cannot read compose.json: %v", err)}
+	}
+	if header.Version != composeArchiveVersion {
+		return uuid.Nil, &InvalidRequestError{fmt.Sprintf("unsupported compose archive version %d", header.Version)}
+	}
+	if header.ID == uuid.Nil || len(header.Compose.ImageBuilds) == 0 {
+		return uuid.Nil, &InvalidRequestError{"compose archive does not contain a compose"}
+	}
+
+	id := header.ID
+	c := header.Compose
+	c.Publication = nil
+
+	var promotions []compose.Promotion
+	for _, p := range c.Promotions {
+		if p.Status != common.IBWaiting {
+			promotions = append(promotions, p)
+		}
+	}
+	c.Promotions = promotions
+
+	if _, exists := s.GetCompose(id); exists {
+		return uuid.Nil, &InvalidRequestError{fmt.Sprintf("compose %s already exists", id)}
+	}
+
+	if s.stateDir != nil {
+		dir := s.getComposeDirectory(id)
+		err = extractComposeOutputs(tr, dir)
+		if err != nil {
+			_ = os.RemoveAll(dir)
+			return uuid.Nil, err
+		}
+	}
+
+	err = s.change(func() error {
+		if _, exists := s.Composes[id]; exists {
+			return &InvalidRequestError{fmt.Sprintf("compose %s already exists", id)}
+		}
+		c.Tenant = s.tenant
+		s.Composes[id] = c
+		return nil
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return id, nil
+}
+
+// extractComposeOutputs writes the outputs of all image builds in `tr` to
+// `dir`. Manifests are skipped, because they are part of compose.json.
+func extractComposeOutputs(tr *tar.Reader, dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("cannot create output directory: %v", err)
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &InvalidRequestError{fmt.Sprintf("cannot read compose archive: %v", err)}
+		}
+
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		// only accept files in image build directories, e.g., 0/result.json
+		name := path.Clean(hdr.Name)
+		parts := strings.Split(name, "/")
+		if len(parts) < 2 || strings.HasPrefix(name, "/") || parts[0] == ".." {
+			return &InvalidRequestError{fmt.Sprintf("invalid file in compose archive: %s", hdr.Name)}
+		}
+		if len(parts) == 2 && parts[1] == "manifest.json" {
+			continue
+		}
+
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(p), 0755)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("cannot write %s: %v", name, err)
+		}
+	}
+}
+
+func writeTarJSON(tw *tar.Writer, name string, modTime time.Time, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tw.Write(data)
+	return err
+}
+
+func writeTarFile(tw *tar.Writer, name, p string, info os.FileInfo) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	})
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(tw, f)
+	if err != nil {
+		return err
+	}
+	if n != info.Size() {
+		return errors.New("file changed while it was exported: " + name)
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package store
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/distro/fedoratest"
+	"github.com/osbuild/osbuild-composer/internal/osbuild"
+	"github.com/osbuild/osbuild-composer/internal/target"
+)
+
+func TestExportImportCompose(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(srcDir)
+	dstDir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dstDir)
+
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+
+	src := New(&srcDir)
+	id := uuid.New()
+	targets := []*target.Target{
+		target.NewLocalTarget(&target.LocalTargetOptions{Filename: imageType.Filename()}),
+	}
+	err = src.PushCompose(id, &osbuild.Manifest{}, imageType, &blueprint.Blueprint{Name: "octopus"}, 0, targets, nil, uuid.New())
+	require.NoError(t, err)
+	err = src.AddPromotion(id, compose.Promotion{Stage: "prod", JobId: uuid.New(), Status: common.IBWaiting})
+	require.NoError(t, err)
+
+	imageDir := src.getImageBuildDirectory(id, 0)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(imageDir, "result.json"), []byte(`{"success":true}`), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(imageDir, imageType.Filename()), []byte("image"), 0644))
+
+	finished := time.Now().Round(time.Second)
+	state := func(c compose.Compose) (common.ComposeState, time.Time, time.Time, time.Time) {
+		return common.CFinished, finished.Add(-time.Hour), finished.Add(-time.Minute), finished
+	}
+	running := func(c compose.Compose) (common.ComposeState, time.Time, time.Time, time.Time) {
+		return common.CRunning, finished, finished, time.Time{}
+	}
+
+	var archive bytes.Buffer
+	err = src.ExportCompose(id, &archive, running)
+	require.IsType(t, &InvalidRequestError{}, err)
+
+	err = src.ExportCompose(uuid.New(), &archive, state)
+	require.IsType(t, &NotFoundError{}, err)
+
+	err = src.ExportCompose(id, &archive, state)
+	require.NoError(t, err)
+
+	// the archive contains the compose, the manifest, and all outputs
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(archive.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, hdr.Name)
+	}
+	require.Equal(t, "compose.json", names[0])
+	require.ElementsMatch(t, []string{"compose.json", "0/manifest.json", "0/result.json", "0/" + imageType.Filename()}, names)
+
+	dst := New(&dstDir)
+	importedID, err := dst.ImportCompose(bytes.NewReader(archive.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, id, importedID)
+
+	c, exists := dst.GetCompose(id)
+	require.True(t, exists)
+	require.Equal(t, "octopus", c.Blueprint.Name)
+	require.Empty(t, c.Promotions)
+	ib := c.ImageBuilds[0]
+	require.Equal(t, uuid.Nil, ib.JobId)
+	require.Equal(t, common.IBFinished, ib.QueueStatus)
+	require.True(t, finished.Equal(ib.JobFinished))
+	require.NotNil(t, ib.Manifest)
+
+	image, size, err := dst.GetImageBuildImage(id, 0)
+	require.NoError(t, err)
+	defer image.Close()
+	require.Equal(t, int64(len("image")), size)
+
+	_, err = os.Stat(filepath.Join(dst.getImageBuildDirectory(id, 0), "manifest.json"))
+	require.True(t, os.IsNotExist(err))
+
+	_, err = dst.ImportCompose(bytes.NewReader(archive.Bytes()))
+	require.IsType(t, &InvalidRequestError{}, err)
+}
+
+func TestImportComposeInvalidPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	header := composeArchiveHeader{
+		Version: composeArchiveVersion,
+		ID:      uuid.New(),
+		Compose: compose.Compose{ImageBuilds: []compose.ImageBuild{{QueueStatus: common.IBFinished}}},
+	}
+	require.NoError(t, writeTarJSON(tw, "compose.json", time.Now(), header))
+	require.NoError(t, writeTarJSON(tw, "0/../../escape", time.Now(), "gotcha"))
+	require.NoError(t, tw.Close())
+
+	s := New(&dir)
+	_, err = s.ImportCompose(&archive)
+	require.IsType(t, &InvalidRequestError{}, err)
+
+	_, exists := s.GetCompose(header.ID)
+	require.False(t, exists)
+	_, err = os.Stat(filepath.Join(dir, "escape"))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "outputs", "escape"))
+	require.True(t, os.IsNotExist(err))
+}
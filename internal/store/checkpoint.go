@@ -0,0 +1,100 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/osbuild"
+)
+
+// getCheckpointPath returns where checkpoint `name` of an image build is
+// stored. It is kept next to the image, so that it is accounted for, exported
+// and deleted together with the rest of the compose's outputs.
+func (s *Store) getCheckpointPath(composeID uuid.UUID, imageBuildID int, name string) string {
+	return filepath.Join(s.getImageBuildDirectory(composeID, imageBuildID), "checkpoint-"+osbuild.CheckpointFilename(name))
+}
+
+// checkCheckpoint returns an error if the image build doesn't exist or didn't
+// request checkpoint `name`. Must be called with the store locked.
+func (s *Store) checkCheckpoint(composeID uuid.UUID, imageBuildID int, name string) error {
+	c, exists := s.Composes[composeID]
+	if !exists {
+		return &NotFoundError{"compose does not exist"}
+	}
+	if imageBuildID < 0 || imageBuildID >= len(c.ImageBuilds) {
+		return &NotFoundError{"image build does not exist"}
+	}
+
+	options := c.ImageBuilds[imageBuildID].GetLocalTargetOptions()
+	if options == nil {
+		return &NoLocalTargetError{fmt.Sprintf("compose %s has no local target", composeID)}
+	}
+	for _, checkpoint := range options.Checkpoints {
+		if checkpoint == name {
+			return nil
+		}
+	}
+
+	return &NotFoundError{fmt.Sprintf("checkpoint %s was not requested", name)}
+}
+
+// AddCheckpointToImageBuild stores checkpoint `name` of an image build. Only
+// checkpoints that were requested by the image build's local target are
+// accepted.
+func (s *Store) AddCheckpointToImageBuild(composeID uuid.UUID, imageBuildID int, name string, reader io.Reader) error {
+	s.mu.RLock()
+	err := s.checkCheckpoint(composeID, imageBuildID, name)
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if s.stateDir == nil {
+		_, err = io.Copy(ioutil.Discard, reader)
+		return err
+	}
+
+	path := s.getCheckpointPath(composeID, imageBuildID, name)
+	f, err := s.createArtifact(path)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, reader)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		removeArtifact(path)
+	}
+
+	return err
+}
+
+// GetImageBuildCheckpoint opens checkpoint `name` of an image build and
+// returns it with its size.
+func (s *Store) GetImageBuildCheckpoint(composeID uuid.UUID, imageBuildID int, name string) (io.ReadCloser, int64, error) {
+	s.mu.RLock()
+	err := s.checkCheckpoint(composeID, imageBuildID, name)
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, 0, err
+	}
+	if s.stateDir == nil {
+		return nil, 0, &NotFoundError{fmt.Sprintf("checkpoint %s was not exported", name)}
+	}
+
+	f, size, err := s.openArtifact(s.getCheckpointPath(composeID, imageBuildID, name))
+	if os.IsNotExist(err) {
+		return nil, 0, &NotFoundError{fmt.Sprintf("checkpoint %s was not exported", name)}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return f, size, nil
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/distro/fedoratest"
+	"github.com/osbuild/osbuild-composer/internal/osbuild"
+	"github.com/osbuild/osbuild-composer/internal/target"
+)
+
+func TestCheckpoints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+
+	s := New(&dir)
+	id := uuid.New()
+	targets := []*target.Target{
+		target.NewLocalTarget(&target.LocalTargetOptions{
+			ComposeId:   id,
+			Filename:    imageType.Filename(),
+			Checkpoints: []string{"tree"},
+		}),
+	}
+	err = s.PushCompose(id, &osbuild.Manifest{}, imageType, &blueprint.Blueprint{}, 0, targets, nil, uuid.New())
+	require.NoError(t, err)
+
+	_, _, err = s.GetImageBuildCheckpoint(id, 0, "tree")
+	require.IsType(t, &NotFoundError{}, err)
+
+	err = s.AddCheckpointToImageBuild(id, 0, "tree", strings.NewReader("tree"))
+	require.NoError(t, err)
+
+	f, size, err := s.GetImageBuildCheckpoint(id, 0, "tree")
+	require.NoError(t, err)
+	defer f.Close()
+	require.Equal(t, int64(len("tree")), size)
+
+	// only requested checkpoints are accepted
+	err = s.AddCheckpointToImageBuild(id, 0, "image", strings.NewReader("image"))
+	require.IsType(t, &NotFoundError{}, err)
+	err = s.AddCheckpointToImageBuild(id, 1, "tree", strings.NewReader("tree"))
+	require.IsType(t, &NotFoundError{}, err)
+	err = s.AddCheckpointToImageBuild(uuid.New(), 0, "tree", strings.NewReader("tree"))
+	require.IsType(t, &NotFoundError{}, err)
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/compose"
+)
+
+func (s *Store) getConversionInputPath(id uuid.UUID) string {
+	return filepath.Join(s.getComposeDirectory(id), "input")
+}
+
+// PushConversion adds `c` with `id`. It converts the uploaded image `input`
+// (see compose.Conversion) and must have a single image build with a local
+// target. The input is kept with the compose until the compose is deleted,
+// and the conversion's size is set to its size. The compose is waiting until
+// its jobs are set with SetConversionJobs().
+func (s *Store) PushConversion(id uuid.UUID, c compose.Compose, input io.Reader) error {
+	if c.Conversion == nil || len(c.ImageBuilds) != 1 {
+		return &InvalidRequestError{"a conversion needs a conversion and a single image build"}
+	}
+	if c.ImageBuilds[0].GetLocalTargetOptions() == nil {
+		return &NoLocalTargetError{"a conversion needs a local target"}
+	}
+	if s.stateDir == nil {
+		return &NoLocalTargetError{"images are not stored"}
+	}
+	if _, exists := s.GetCompose(id); exists {
+		return &InvalidRequestError{fmt.Sprintf("compose %s already exists", id)}
+	}
+
+	err := os.MkdirAll(s.getImageBuildDirectory(id, 0), 0755)
+	if err != nil {
+		return fmt.Errorf("cannot create output directory for compose %s: %v", id, err)
+	}
+
+	f, err := s.createArtifact(s.getConversionInputPath(id))
+	if err != nil {
+		return err
+	}
+	w := &countingWriter{Writer: f}
+	_, err = io.Copy(w, input)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		// don't keep incomplete inputs around
+		_ = os.RemoveAll(s.getComposeDirectory(id))
+		return err
+	}
+
+	c.Conversion.InputSize = w.n
+
+	return s.change(func() error {
+		if _, exists := s.Composes[id]; exists {
+			return &InvalidRequestError{fmt.Sprintf("compose %s already exists", id)}
+		}
+		c.Tenant = s.tenant
+		s.Composes[id] = c
+		return nil
+	})
+}
+
+// GetConversionInput opens the image that was uploaded for the conversion
+// of compose `id` and returns its size.
+func (s *Store) GetConversionInput(id uuid.UUID) (io.ReadCloser, int64, error) {
+	c, exists := s.GetCompose(id)
+	if !exists {
+		return nil, 0, &NotFoundError{"compose does not exist"}
+	}
+	if c.Conversion == nil {
+		return nil, 0, &NotFoundError{"compose is not a conversion"}
+	}
+	if s.stateDir == nil {
+		return nil, 0, &NoLocalTargetError{"images are not stored"}
+	}
+
+	return s.openArtifact(s.getConversionInputPath(id))
+}
+
+// SetConversionJobs records the jobs of the conversion of compose `id`: the
+// job which converts the image, and the one which uploads it to the cloud
+// (uuid.Nil if there is none).
+func (s *Store) SetConversionJobs(id uuid.UUID, convertJobId, uploadJobId uuid.UUID) error {
+	return s.change(func() error {
+		c, exists := s.Composes[id]
+		if !exists {
+			return &NotFoundError{"compose does not exist"}
+		}
+		if c.Conversion == nil {
+			return &InvalidRequestError{"compose is not a conversion"}
+		}
+
+		c.ImageBuilds[0].JobId = convertJobId
+		c.ImageBuilds[0].UploadJobId = uploadJobId
+		s.Composes[id] = c
+
+		return nil
+	})
+}
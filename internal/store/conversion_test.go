@@ -0,0 +1,74 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/target"
+)
+
+func TestPushConversion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := New(&dir)
+
+	conversion := func(targets ...*target.Target) compose.Compose {
+		return compose.Compose{
+			Blueprint: &blueprint.Blueprint{Name: "conversion"},
+			ImageBuilds: []compose.ImageBuild{{
+				ImageType:   common.Azure,
+				Targets:     targets,
+				QueueStatus: common.IBWaiting,
+			}},
+			Conversion: &compose.Conversion{
+				Format:      "vpc",
+				RequestedAt: time.Now(),
+			},
+		}
+	}
+
+	id := uuid.New()
+	err = s.PushConversion(id, conversion(), strings.NewReader("qcow2"))
+	require.IsType(t, &NoLocalTargetError{}, err)
+
+	local := target.NewLocalTarget(&target.LocalTargetOptions{ComposeId: id, Filename: "disk.vhd"})
+	err = s.PushConversion(id, conversion(local), strings.NewReader("qcow2"))
+	require.NoError(t, err)
+	c, exists := s.GetCompose(id)
+	require.True(t, exists)
+	require.Equal(t, uint64(5), c.Conversion.InputSize)
+
+	input, size, err := s.GetConversionInput(id)
+	require.NoError(t, err)
+	defer input.Close()
+	require.Equal(t, int64(5), size)
+	data, err := ioutil.ReadAll(input)
+	require.NoError(t, err)
+	require.Equal(t, "qcow2", string(data))
+
+	convertJobId, uploadJobId := uuid.New(), uuid.New()
+	err = s.SetConversionJobs(id, convertJobId, uploadJobId)
+	require.NoError(t, err)
+	c, _ = s.GetCompose(id)
+	require.Equal(t, convertJobId, c.ImageBuilds[0].JobId)
+	require.Equal(t, uploadJobId, c.ImageBuilds[0].UploadJobId)
+
+	// composes which are not conversions
+	_, _, err = s.GetConversionInput(uuid.New())
+	require.IsType(t, &NotFoundError{}, err)
+	c = conversion(local)
+	c.Conversion = nil
+	err = s.PushConversion(uuid.New(), c, strings.NewReader("qcow2"))
+	require.IsType(t, &InvalidRequestError{}, err)
+}
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+// DiskUsage returns how many bytes the outputs of each compose take up on
+// disk, and their sum. Composes whose outputs are stored elsewhere (i.e.,
+// only uploaded) take up no space. The store doesn't keep the sizes, because
+// outputs are written by the worker API and removed with their composes.
+func (s *Store) DiskUsage() (map[uuid.UUID]int64, int64) {
+	usage := make(map[uuid.UUID]int64)
+	var total int64
+
+	for id := range s.GetAllComposes() {
+		size := s.composeDiskUsage(id)
+		usage[id] = size
+		total += size
+	}
+
+	return usage, total
+}
+
+// composeDiskUsage returns the number of bytes the outputs of a compose
+// take up.
+func (s *Store) composeDiskUsage(id uuid.UUID) int64 {
+	if s.stateDir == nil {
+		return 0
+	}
+
+	var size int64
+	_ = filepath.Walk(s.getComposeDirectory(id), func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return size
+}
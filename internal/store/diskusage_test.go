@@ -0,0 +1,81 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/distro/fedoratest"
+	"github.com/osbuild/osbuild-composer/internal/osbuild"
+)
+
+func TestDiskUsage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+
+	s := New(&dir)
+
+	// one compose with an image and a log, one which was only uploaded
+	stored, uploaded := uuid.New(), uuid.New()
+	for _, id := range []uuid.UUID{stored, uploaded} {
+		err := s.PushCompose(id, nil, imageType, &blueprint.Blueprint{Name: "test"}, 0, nil, nil, uuid.New())
+		require.NoError(t, err)
+	}
+
+	imageDir := s.getImageBuildDirectory(stored, 0)
+	require.NoError(t, os.MkdirAll(imageDir, 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(imageDir, "disk.qcow2"), make([]byte, 300), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(imageDir, "log"), make([]byte, 20), 0600))
+
+	usage, total := s.DiskUsage()
+	require.Equal(t, map[uuid.UUID]int64{stored: 320, uploaded: 0}, usage)
+	require.Equal(t, int64(320), total)
+
+	require.NoError(t, s.DeleteCompose(stored))
+	usage, total = s.DiskUsage()
+	require.Equal(t, map[uuid.UUID]int64{uploaded: 0}, usage)
+	require.Equal(t, int64(0), total)
+}
+
+func TestGetImageBuildSize(t *testing.T) {
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+
+	s := New(nil)
+
+	// the manifest's image is larger than requested
+	grown, plain := uuid.New(), uuid.New()
+	manifest := &osbuild.Manifest{
+		Pipeline: osbuild.Pipeline{
+			Assembler: osbuild.NewQEMUAssembler(&osbuild.QEMUAssemblerOptions{Size: 4096}),
+		},
+	}
+	require.NoError(t, s.PushCompose(grown, manifest, imageType, &blueprint.Blueprint{Name: "test"}, 1024, nil, nil, uuid.New()))
+	require.NoError(t, s.PushCompose(plain, nil, imageType, &blueprint.Blueprint{Name: "test"}, 2048, nil, nil, uuid.New()))
+
+	size, err := s.GetImageBuildSize(grown, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4096), size)
+
+	size, err = s.GetImageBuildSize(plain, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2048), size)
+
+	_, err = s.GetImageBuildSize(plain, 1)
+	require.Error(t, err)
+	_, err = s.GetImageBuildSize(uuid.New(), 0)
+	require.Error(t, err)
+}
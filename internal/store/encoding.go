@@ -0,0 +1,551 @@
+package store
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+)
+
+// Artifacts stored under outputs/, that is images and checkpoints, can be
+// compressed and encrypted at rest. An encoded artifact has a metadata file
+// next to it, named like the artifact with ".encoding" appended, which
+// records how it was encoded and its decoded size. Artifacts without one are
+// stored as they are, so that changing the encoding doesn't affect artifacts
+// that were stored before. Artifacts are decoded on the fly when they are
+// read.
+
+const (
+	CompressionNone = ""
+	CompressionGzip = common.CompressionGzip
+	CompressionXZ   = common.CompressionXZ
+	CompressionZstd = common.CompressionZstd
+)
+
+// The only supported encryption: AES-256-GCM applied to chunks of the
+// artifact, see encryptWriter.
+const encryptionAES256GCM = "aes-256-gcm"
+
+// ArtifactEncoding configures how new artifacts are encoded.
+type ArtifactEncoding struct {
+	// One of the Compression* constants
+	Compression string
+
+	// 32-byte AES-256 key, or nil to store artifacts unencrypted
+	Key []byte
+}
+
+type artifactMetadata struct {
+	Compression string `json:"compression,omitempty"`
+	Encryption  string `json:"encryption,omitempty"`
+
+	// Identifies the key an artifact was encrypted with, so that a wrong
+	// key results in a clear error instead of a failing decryption
+	KeyID string `json:"key_id,omitempty"`
+
+	// Size of the decoded artifact
+	Size int64 `json:"size"`
+}
+
+// SetArtifactEncoding sets how artifacts which are stored from now on are
+// encoded. Artifacts are stored as they are by default. The key is also used
+// to decrypt encrypted artifacts, which cannot be read without it.
+func (s *Store) SetArtifactEncoding(encoding ArtifactEncoding) error {
+	if encoding.Compression != CompressionNone && !common.IsCompression(encoding.Compression) {
+		return fmt.Errorf("unsupported compression: %s", encoding.Compression)
+	}
+	if encoding.Key != nil && len(encoding.Key) != 32 {
+		return fmt.Errorf("encryption key must be 32 bytes long, not %d", len(encoding.Key))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.encoding = encoding
+
+	return nil
+}
+
+// ReadArtifactKey reads an AES-256 key from `path`, which contains either
+// the 32 bytes of the key or their hexadecimal representation.
+func ReadArtifactKey(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 32 {
+		return data, nil
+	}
+
+	key, err := hex.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%s must contain a 32-byte key, raw or hex-encoded", path)
+	}
+
+	return key, nil
+}
+
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+func artifactMetadataPath(path string) string {
+	return path + ".encoding"
+}
+
+// artifactWriter encodes an artifact while it is written and records its
+// metadata when it is closed.
+type artifactWriter struct {
+	file     *os.File
+	writer   io.Writer
+	closers  []io.Closer
+	metadata *artifactMetadata
+}
+
+func (w *artifactWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.metadata.Size += int64(n)
+	return n, err
+}
+
+func (w *artifactWriter) Close() error {
+	var err error
+	for _, closer := range w.closers {
+		if cerr := closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(w.metadata)
+	if err != nil {
+		panic(err)
+	}
+	return ioutil.WriteFile(artifactMetadataPath(w.file.Name()), data, 0600)
+}
+
+// createArtifact creates the artifact at `path`, encoding it as configured
+// with SetArtifactEncoding. The returned writer must be closed, also on
+// errors, after which removeArtifact cleans up incomplete artifacts.
+func (s *Store) createArtifact(path string) (io.WriteCloser, error) {
+	s.mu.RLock()
+	encoding := s.encoding
+	s.mu.RUnlock()
+
+	// An existing artifact might have been encoded differently
+	err := os.Remove(artifactMetadataPath(path))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if encoding.Compression == CompressionNone && encoding.Key == nil {
+		return file, nil
+	}
+
+	w := &artifactWriter{
+		file:   file,
+		writer: file,
+		metadata: &artifactMetadata{
+			Compression: encoding.Compression,
+		},
+	}
+
+	// Closers are called in order, so the outermost encoding comes first
+	if encoding.Key != nil {
+		encrypter, err := newEncryptWriter(w.writer, encoding.Key)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		w.writer = encrypter
+		w.closers = append([]io.Closer{encrypter}, w.closers...)
+		w.metadata.Encryption = encryptionAES256GCM
+		w.metadata.KeyID = keyID(encoding.Key)
+	}
+
+	if encoding.Compression != CompressionNone {
+		compressor, err := common.NewCompressor(w.writer, encoding.Compression)
+		if err != nil {
+			file.Close()
+			os.Remove(path)
+			return nil, err
+		}
+		w.writer = compressor
+		w.closers = append([]io.Closer{compressor}, w.closers...)
+	}
+
+	return w, nil
+}
+
+// removeArtifact removes the artifact at `path` along with its metadata.
+func removeArtifact(path string) {
+	_ = os.Remove(path)
+	_ = os.Remove(artifactMetadataPath(path))
+}
+
+type artifactReader struct {
+	io.Reader
+	decompressor io.Closer
+	file         *os.File
+}
+
+func (r *artifactReader) Close() error {
+	if r.decompressor != nil {
+		r.decompressor.Close()
+	}
+	return r.file.Close()
+}
+
+// openArtifact opens the artifact at `path` and returns a reader of its
+// decoded contents along with their size.
+func (s *Store) openArtifact(path string) (io.ReadCloser, int64, error) {
+	data, err := ioutil.ReadFile(artifactMetadataPath(path))
+	if os.IsNotExist(err) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+
+		return f, info.Size(), nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var metadata artifactMetadata
+	err = json.Unmarshal(data, &metadata)
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot read encoding of %s: %v", path, err)
+	}
+
+	s.mu.RLock()
+	key := s.encoding.Key
+	s.mu.RUnlock()
+
+	switch metadata.Encryption {
+	case "":
+	case encryptionAES256GCM:
+		if key == nil {
+			return nil, 0, fmt.Errorf("%s is encrypted, but no key is configured", path)
+		}
+		if keyID(key) != metadata.KeyID {
+			return nil, 0, fmt.Errorf("%s is encrypted with a different key", path)
+		}
+	default:
+		return nil, 0, fmt.Errorf("%s uses unsupported encryption: %s", path, metadata.Encryption)
+	}
+
+	if metadata.Compression != CompressionNone && !common.IsCompression(metadata.Compression) {
+		return nil, 0, fmt.Errorf("%s uses unsupported compression: %s", path, metadata.Compression)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var reader io.Reader = f
+	if metadata.Encryption != "" {
+		reader, err = newDecryptReader(reader, key)
+		if err != nil {
+			f.Close()
+			return nil, 0, fmt.Errorf("cannot decrypt %s: %v", path, err)
+		}
+	}
+	var decompressor io.ReadCloser
+	if metadata.Compression != CompressionNone {
+		decompressor, err = common.NewDecompressor(reader, metadata.Compression)
+		if err != nil {
+			f.Close()
+			return nil, 0, fmt.Errorf("cannot decompress %s: %v", path, err)
+		}
+		reader = decompressor
+	}
+
+	return &artifactReader{reader, decompressor, f}, metadata.Size, nil
+}
+
+// An ArtifactReader reads the decoded contents of an artifact and can seek
+// in them.
+type ArtifactReader interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// openSeekableArtifact is like openArtifact, but the reader can seek. Plain
+// artifacts are files, which seek cheaply. Encoded ones can only be read
+// from the start, so seeking forward skips decoded data and seeking
+// backwards opens them again.
+func (s *Store) openSeekableArtifact(path string) (ArtifactReader, int64, error) {
+	reader, size, err := s.openArtifact(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if f, ok := reader.(*os.File); ok {
+		return f, size, nil
+	}
+
+	open := func() (io.ReadCloser, error) {
+		reader, _, err := s.openArtifact(path)
+		return reader, err
+	}
+	return &seekableArtifact{reader: reader, open: open, size: size}, size, nil
+}
+
+type seekableArtifact struct {
+	reader io.ReadCloser
+	open   func() (io.ReadCloser, error)
+	size   int64
+
+	// The position of reader, and the one that the next read starts at
+	read   int64
+	offset int64
+}
+
+func (a *seekableArtifact) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += a.offset
+	case io.SeekEnd:
+		offset += a.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	// Seeking is lazy, so that finding out the size doesn't read the
+	// artifact
+	a.offset = offset
+	return offset, nil
+}
+
+func (a *seekableArtifact) Read(p []byte) (int, error) {
+	if a.offset < a.read {
+		reader, err := a.open()
+		if err != nil {
+			return 0, err
+		}
+		a.reader.Close()
+		a.reader = reader
+		a.read = 0
+	}
+
+	if a.offset > a.read {
+		n, err := io.CopyN(ioutil.Discard, a.reader, a.offset-a.read)
+		a.read += n
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := a.reader.Read(p)
+	a.read += int64(n)
+	a.offset = a.read
+	return n, err
+}
+
+func (a *seekableArtifact) Close() error {
+	return a.reader.Close()
+}
+
+// Artifacts are encrypted in chunks, so that they can be decrypted and
+// authenticated while streaming them. Each chunk is sealed with AES-GCM,
+// using a nonce made of a random prefix which is written at the start of
+// the artifact, the chunk's index, and a flag marking the last chunk. The
+// flag makes truncating an artifact at a chunk boundary detectable. All but
+// the last chunk are full, and the last one is always shorter than a full
+// chunk, if necessary empty.
+const (
+	encryptionChunkSize = 64 * 1024
+	noncePrefixSize     = 7
+)
+
+var errArtifactCorrupted = errors.New("encrypted artifact is corrupted or truncated")
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, noncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], index)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+type encryptWriter struct {
+	writer io.Writer
+	aead   cipher.AEAD
+	prefix []byte
+	index  uint32
+	buf    []byte
+}
+
+func newEncryptWriter(writer io.Writer, key []byte) (*encryptWriter, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, noncePrefixSize)
+	_, err = rand.Read(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = writer.Write(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{
+		writer: writer,
+		aead:   aead,
+		prefix: prefix,
+		buf:    make([]byte, 0, encryptionChunkSize),
+	}, nil
+}
+
+func (w *encryptWriter) seal(last bool) error {
+	if w.index == ^uint32(0) {
+		return errors.New("artifact is too large to be encrypted")
+	}
+
+	_, err := w.writer.Write(w.aead.Seal(nil, chunkNonce(w.prefix, w.index, last), w.buf, nil))
+	w.index++
+	w.buf = w.buf[:0]
+	return err
+}
+
+func (w *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := encryptionChunkSize - len(w.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(w.buf) == encryptionChunkSize {
+			err := w.seal(false)
+			if err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close writes the last chunk. It doesn't close the underlying writer.
+func (w *encryptWriter) Close() error {
+	return w.seal(true)
+}
+
+type decryptReader struct {
+	reader io.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	index  uint32
+
+	chunk     []byte
+	plaintext []byte
+	done      bool
+}
+
+func newDecryptReader(reader io.Reader, key []byte) (*decryptReader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, noncePrefixSize)
+	_, err = io.ReadFull(reader, prefix)
+	if err != nil {
+		return nil, errArtifactCorrupted
+	}
+
+	return &decryptReader{
+		reader: reader,
+		aead:   aead,
+		prefix: prefix,
+		chunk:  make([]byte, encryptionChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.plaintext) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		err := r.next()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.plaintext)
+	r.plaintext = r.plaintext[n:]
+	return n, nil
+}
+
+// next decrypts the next chunk.
+func (r *decryptReader) next() error {
+	n, err := io.ReadFull(r.reader, r.chunk)
+	last := false
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		last = true
+	} else if err != nil {
+		return err
+	}
+
+	plaintext, err := r.aead.Open(nil, chunkNonce(r.prefix, r.index, last), r.chunk[:n], nil)
+	if err != nil {
+		return errArtifactCorrupted
+	}
+
+	r.index++
+	r.plaintext = plaintext
+	r.done = last
+	return nil
+}
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/distro/fedoratest"
+	"github.com/osbuild/osbuild-composer/internal/osbuild"
+	"github.com/osbuild/osbuild-composer/internal/target"
+)
+
+func TestArtifactEncoding(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+
+	s := New(&dir)
+	key := bytes.Repeat([]byte{0x42}, 32)
+
+	// spans several encryption chunks and ends on a chunk boundary
+	image := bytes.Repeat([]byte("octopus!"), 3*encryptionChunkSize/8)
+
+	for _, encoding := range []ArtifactEncoding{
+		{},
+		{Compression: CompressionGzip},
+		{Key: key},
+		{Compression: CompressionGzip, Key: key},
+		{Compression: CompressionXZ},
+		{Compression: CompressionZstd, Key: key},
+	} {
+		err = s.SetArtifactEncoding(encoding)
+		require.NoError(t, err)
+
+		id := uuid.New()
+		targets := []*target.Target{
+			target.NewLocalTarget(&target.LocalTargetOptions{
+				ComposeId:   id,
+				Filename:    imageType.Filename(),
+				Checkpoints: []string{"tree"},
+			}),
+		}
+		err = s.PushCompose(id, &osbuild.Manifest{}, imageType, &blueprint.Blueprint{}, 0, targets, nil, uuid.New())
+		require.NoError(t, err)
+
+		err = s.AddImageToImageUpload(id, 0, bytes.NewReader(image), "sha256:0000")
+		require.Error(t, err)
+		_, err = os.Stat(filepath.Join(s.getImageBuildDirectory(id, 0), imageType.Filename()))
+		require.True(t, os.IsNotExist(err))
+
+		// the checksum is that of the decoded image
+		sum := sha256.Sum256(image)
+		checksum := "sha256:" + hex.EncodeToString(sum[:])
+		err = s.AddImageToImageUpload(id, 0, bytes.NewReader(image), checksum)
+		require.NoError(t, err)
+		c, _ := s.GetCompose(id)
+		require.Equal(t, checksum, c.ImageBuilds[0].Checksum)
+		err = s.AddCheckpointToImageBuild(id, 0, "tree", bytes.NewReader([]byte("tree")))
+		require.NoError(t, err)
+
+		path := filepath.Join(s.getImageBuildDirectory(id, 0), imageType.Filename())
+		stored, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		_, err = os.Stat(artifactMetadataPath(path))
+		if encoding.Compression == CompressionNone && encoding.Key == nil {
+			require.Equal(t, image, stored)
+			require.True(t, os.IsNotExist(err))
+		} else {
+			require.NotEqual(t, image, stored)
+			require.NoError(t, err)
+		}
+
+		reader, size, err := s.GetImageBuildImage(id, 0)
+		require.NoError(t, err)
+		decoded, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, int64(len(image)), size)
+		require.Equal(t, image, decoded)
+
+		// images can be read from anywhere, also backwards
+		for _, offset := range []int64{size / 2, 10, size - 1} {
+			position, err := reader.Seek(offset, io.SeekStart)
+			require.NoError(t, err)
+			require.Equal(t, offset, position)
+			part := make([]byte, 1)
+			_, err = io.ReadFull(reader, part)
+			require.NoError(t, err)
+			require.Equal(t, image[offset], part[0])
+		}
+		end, err := reader.Seek(0, io.SeekEnd)
+		require.NoError(t, err)
+		require.Equal(t, size, end)
+		reader.Close()
+
+		checkpoint, size, err := s.GetImageBuildCheckpoint(id, 0, "tree")
+		require.NoError(t, err)
+		decoded, err = ioutil.ReadAll(checkpoint)
+		checkpoint.Close()
+		require.NoError(t, err)
+		require.Equal(t, int64(4), size)
+		require.Equal(t, "tree", string(decoded))
+
+		if encoding.Key == nil {
+			continue
+		}
+
+		// encrypted artifacts cannot be read without the key
+		err = s.SetArtifactEncoding(ArtifactEncoding{})
+		require.NoError(t, err)
+		_, _, err = s.GetImageBuildImage(id, 0)
+		require.Error(t, err)
+
+		err = s.SetArtifactEncoding(ArtifactEncoding{Key: bytes.Repeat([]byte{0x23}, 32)})
+		require.NoError(t, err)
+		_, _, err = s.GetImageBuildImage(id, 0)
+		require.Error(t, err)
+
+		// and tampering is detected
+		err = s.SetArtifactEncoding(encoding)
+		require.NoError(t, err)
+		stored[len(stored)/2] ^= 1
+		err = ioutil.WriteFile(path, stored, 0600)
+		require.NoError(t, err)
+		reader, _, err = s.GetImageBuildImage(id, 0)
+		if err == nil {
+			_, err = ioutil.ReadAll(reader)
+			reader.Close()
+		}
+		require.Error(t, err)
+
+		// and so is truncating at a chunk boundary
+		if encoding.Compression == CompressionNone {
+			err = ioutil.WriteFile(path, stored[:noncePrefixSize+encryptionChunkSize+16], 0600)
+			require.NoError(t, err)
+			reader, _, err = s.GetImageBuildImage(id, 0)
+			require.NoError(t, err)
+			_, err = ioutil.ReadAll(reader)
+			reader.Close()
+			require.Error(t, err)
+		}
+	}
+
+	err = s.SetArtifactEncoding(ArtifactEncoding{Compression: "zip"})
+	require.Error(t, err)
+	err = s.SetArtifactEncoding(ArtifactEncoding{Key: []byte("short")})
+	require.Error(t, err)
+}
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"time"
+)
+
+// A GitSync records what the synchronization of blueprints from a git
+// repository (see weldr.GitSyncConfig) last did.
+type GitSync struct {
+	// The commit that was last synchronized, and when
+	Commit string    `json:"commit,omitempty"`
+	Synced time.Time `json:"synced,omitempty"`
+
+	// When the repository was last checked, and why that failed, if it did
+	Checked time.Time `json:"checked,omitempty"`
+	Error   string    `json:"error,omitempty"`
+
+	// The blueprints that the repository contains
+	Blueprints []string `json:"blueprints,omitempty"`
+}
+
+// GetGitSync returns what the synchronization of blueprints from git last
+// did, or nil if it never ran.
+func (s *Store) GetGitSync() *GitSync {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.GitSync == nil {
+		return nil
+	}
+	gitSync := *s.GitSync
+	gitSync.Blueprints = append([]string{}, s.GitSync.Blueprints...)
+	return &gitSync
+}
+
+// SetGitSync replaces what the synchronization of blueprints from git last
+// did.
+func (s *Store) SetGitSync(gitSync GitSync) error {
+	return s.change(func() error {
+		s.GitSync = &gitSync
+		return nil
+	})
+}
@@ -0,0 +1,200 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/osbuild/osbuild-composer/internal/logging"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+)
+
+// The key store keeps the armored GPG public keys that sources are checked
+// against, one file <name>.asc per key in the "gpgkeys" directory of the
+// state directory. Sources refer to keys by name (see SourceConfig.GPGKeys).
+// Keys are not part of the serialized store, so that they can also be added
+// by dropping files into the directory.
+
+var gpgKeyNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+const (
+	gpgKeyBegin = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+	gpgKeyEnd   = "-----END PGP PUBLIC KEY BLOCK-----"
+)
+
+// IsGPGKey returns true if `key` looks like an armored GPG public key.
+func IsGPGKey(key string) bool {
+	begin := strings.Index(key, gpgKeyBegin)
+	return begin >= 0 && strings.Index(key, gpgKeyEnd) > begin
+}
+
+// GPGKeyName returns the name under which `key` is stored when it is added
+// inline or from a URL, rather than under a name given by the user.
+func GPGKeyName(key string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(key)))
+	return "sha256-" + hex.EncodeToString(sum[:8])
+}
+
+func (s *Store) getGPGKeyDirectory() string {
+	return filepath.Join(*s.stateDir, "gpgkeys")
+}
+
+// loadGPGKeys reads the key store from the state directory.
+func (s *Store) loadGPGKeys() {
+	s.gpgKeys = make(map[string]string)
+	if s.stateDir == nil {
+		return
+	}
+
+	dir := s.getGPGKeyDirectory()
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		logging.Default().Fatal("cannot create gpg key directory", "path", dir, "error", err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		logging.Default().Fatal("cannot read gpg key directory", "path", dir, "error", err)
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), ".asc")
+		if file.IsDir() || name == file.Name() || !gpgKeyNameRegex.MatchString(name) {
+			continue
+		}
+		key, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			logging.Default().Fatal("cannot read gpg key", "path", file.Name(), "error", err)
+		}
+		if !IsGPGKey(string(key)) {
+			logging.Default().Warning("ignoring file that is not a gpg key", "path", file.Name())
+			continue
+		}
+		s.gpgKeys[name] = string(key)
+	}
+}
+
+// AddGPGKey stores the armored public key `key` as `name`, replacing a key
+// of the same name.
+func (s *Store) AddGPGKey(name, key string) error {
+	if !gpgKeyNameRegex.MatchString(name) {
+		return &InvalidRequestError{fmt.Sprintf("invalid gpg key name: %s", name)}
+	}
+	if !IsGPGKey(key) {
+		return &InvalidRequestError{fmt.Sprintf("gpg key %s is not an armored public key", name)}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stateDir != nil {
+		path := filepath.Join(s.getGPGKeyDirectory(), name+".asc")
+		err := ioutil.WriteFile(path+".tmp", []byte(key), 0600)
+		if err == nil {
+			err = os.Rename(path+".tmp", path)
+		}
+		if err != nil {
+			_ = os.Remove(path + ".tmp")
+			return fmt.Errorf("cannot write gpg key %s: %v", name, err)
+		}
+	}
+
+	s.gpgKeys[name] = key
+	return nil
+}
+
+// GetGPGKey returns the armored public key stored as `name`.
+func (s *Store) GetGPGKey(name string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, exists := s.gpgKeys[name]
+	return key, exists
+}
+
+// ListGPGKeys returns the names of all stored keys, sorted.
+func (s *Store) ListGPGKeys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.gpgKeys))
+	for name := range s.gpgKeys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// GPGKeyUsers returns the names of the sources that are checked against the
+// key `name`, sorted.
+func (s *Store) GPGKeyUsers(name string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.gpgKeyUsers(name)
+}
+
+func (s *Store) gpgKeyUsers(name string) []string {
+	users := []string{}
+	for _, source := range s.Sources {
+		for _, key := range source.GPGKeys {
+			if key == name {
+				users = append(users, source.Name)
+				break
+			}
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+// DeleteGPGKey removes the key `name`. Keys that sources are checked against
+// cannot be removed.
+func (s *Store) DeleteGPGKey(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.gpgKeys[name]; !exists {
+		return &NotFoundError{fmt.Sprintf("gpg key %s does not exist", name)}
+	}
+	if users := s.gpgKeyUsers(name); len(users) > 0 {
+		return &InvalidRequestError{fmt.Sprintf("gpg key %s is used by: %s", name, strings.Join(users, ", "))}
+	}
+
+	if s.stateDir != nil {
+		err := os.Remove(filepath.Join(s.getGPGKeyDirectory(), name+".asc"))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("cannot remove gpg key %s: %v", name, err)
+		}
+	}
+
+	delete(s.gpgKeys, name)
+	return nil
+}
+
+// SourceRepoConfig returns the repository config of `source`, including the
+// keys to check its packages against. Keys are only included if the source
+// checks signatures. Keys that were deleted from the key store are skipped.
+func (s *Store) SourceRepoConfig(source SourceConfig) rpmmd.RepoConfig {
+	repo := source.RepoConfig()
+	if !source.CheckGPG {
+		return repo
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, name := range source.GPGKeys {
+		if key, exists := s.gpgKeys[name]; exists {
+			repo.GPGKeys = append(repo.GPGKeys, key)
+		}
+	}
+
+	return repo
+}
@@ -0,0 +1,51 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+)
+
+const testGPGKey = "-----BEGIN PGP PUBLIC KEY BLOCK-----\n\nmQINBFturGcBEAC\n-----END PGP PUBLIC KEY BLOCK-----\n"
+
+func TestGPGKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := New(&dir)
+	require.Equal(t, []string{}, s.ListGPGKeys())
+
+	require.IsType(t, &InvalidRequestError{}, s.AddGPGKey("../corp", testGPGKey))
+	require.IsType(t, &InvalidRequestError{}, s.AddGPGKey("corp", "not a key"))
+	require.NoError(t, s.AddGPGKey("corp", testGPGKey))
+
+	s.PushSource(SourceConfig{Name: "corp", Type: "yum-baseurl", URL: "http://example.com/corp", CheckGPG: true, GPGKeys: []string{"corp"}})
+	require.Equal(t, []string{"corp"}, s.GPGKeyUsers("corp"))
+	require.Equal(t, rpmmd.RepoConfig{
+		Id:        "corp",
+		BaseURL:   "http://example.com/corp",
+		IgnoreSSL: true,
+		GPGKeys:   []string{testGPGKey},
+	}, s.SourceRepoConfig(*s.GetSource("corp")))
+
+	// keys are kept in the state directory
+	s = New(&dir)
+	require.Equal(t, []string{"corp"}, s.ListGPGKeys())
+	key, exists := s.GetGPGKey("corp")
+	require.True(t, exists)
+	require.Equal(t, testGPGKey, key)
+
+	// keys that are used cannot be deleted
+	require.IsType(t, &InvalidRequestError{}, s.DeleteGPGKey("corp"))
+	s.DeleteSource("corp")
+	require.NoError(t, s.DeleteGPGKey("corp"))
+	require.IsType(t, &NotFoundError{}, s.DeleteGPGKey("corp"))
+
+	s = New(&dir)
+	require.Equal(t, []string{}, s.ListGPGKeys())
+}
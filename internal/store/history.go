@@ -0,0 +1,181 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/gitrepo"
+	"github.com/osbuild/osbuild-composer/internal/logging"
+)
+
+// The branch of the blueprint repository that contains all committed blueprints
+const blueprintsBranch = "master"
+
+// openBlueprintRepo opens the git repository that records the history of all
+// committed blueprints, one file <name>.toml per blueprint. Commit ids of
+// blueprint changes are the ids of the commits in this repository.
+//
+// Blueprints which existed before the repository was created are added in a
+// single initial commit. Their earlier changes keep their previous
+// (synthetic) commit ids, which cannot be found in the repository.
+func (s *Store) openBlueprintRepo() {
+	if s.stateDir == nil {
+		s.blueprintRepo = gitrepo.NewInMemory()
+		return
+	}
+
+	dir := filepath.Join(*s.stateDir, "blueprints.git")
+	repo, err := gitrepo.Open(dir)
+	if err != nil {
+		logging.Default().Fatal("cannot open blueprint repository", "path", dir, "error", err)
+	}
+	s.blueprintRepo = repo
+
+	head, err := repo.Head(blueprintsBranch)
+	if err != nil {
+		logging.Default().Fatal("cannot read blueprint repository", "path", dir, "error", err)
+	}
+	if head == "" && len(s.Blueprints) > 0 {
+		_, err = s.commitBlueprints("Import existing blueprints", time.Now())
+		if err != nil {
+			logging.Default().Fatal("cannot import blueprints into repository", "path", dir, "error", err)
+		}
+	}
+}
+
+// validateBlueprintName returns an InvalidRequestError if a blueprint called
+// `name` cannot be recorded in the blueprint repository, as file <name>.toml
+// and with tags <name>/r<revision>.
+func validateBlueprintName(name string) error {
+	if !gitrepo.ValidFileName(name+".toml") || !gitrepo.ValidRefName(name) {
+		return &InvalidRequestError{fmt.Sprintf("Invalid blueprint name: %q", name)}
+	}
+	return nil
+}
+
+// commitBlueprints records all committed blueprints in the blueprint
+// repository and returns the id of the new commit. Must be called with the
+// store locked.
+func (s *Store) commitBlueprints(message string, when time.Time) (string, error) {
+	files := make(map[string][]byte, len(s.Blueprints))
+	for name, bp := range s.Blueprints {
+		var buf bytes.Buffer
+		err := toml.NewEncoder(&buf).Encode(bp)
+		if err != nil {
+			return "", fmt.Errorf("cannot encode blueprint %s: %v", name, err)
+		}
+		files[name+".toml"] = buf.Bytes()
+	}
+
+	commit, err := s.blueprintRepo.Commit(blueprintsBranch, files, message, when)
+	if err != nil {
+		return "", fmt.Errorf("cannot commit to blueprint repository: %v", err)
+	}
+
+	return commit, nil
+}
+
+// Changes which are pruned from the history of a blueprint are archived in
+// this directory below the state directory, in a file <name>.json for each
+// blueprint, which contains one change per line, oldest first.
+const blueprintChangesArchiveDir = "changes-archive"
+
+// SetBlueprintHistoryDepth limits the history of each blueprint to its
+// `depth` latest changes, plus all tagged ones. Older changes are pruned
+// when a blueprint is pushed, and are archived before (see
+// blueprintChangesArchiveDir). Histories are not limited by default, or when
+// `depth` is 0. Histories which are already longer are pruned immediately.
+func (s *Store) SetBlueprintHistoryDepth(depth int) error {
+	if depth < 0 {
+		return fmt.Errorf("invalid blueprint history depth: %d", depth)
+	}
+
+	return s.change(func() error {
+		s.historyDepth = depth
+
+		for name := range s.BlueprintsCommits {
+			err := s.pruneBlueprintHistory(name)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// pruneBlueprintHistory removes all but the latest and the tagged changes
+// from the history of blueprint `name`, according to the configured depth.
+// The history is left unchanged if the pruned changes cannot be archived.
+// Must be called with the store locked.
+func (s *Store) pruneBlueprintHistory(name string) error {
+	commits := s.BlueprintsCommits[name]
+	if s.historyDepth == 0 || len(commits) <= s.historyDepth {
+		return nil
+	}
+
+	cutoff := len(commits) - s.historyDepth
+	var kept []string
+	var pruned []blueprint.Change
+	for i, commit := range commits {
+		change := s.BlueprintsChanges[name][commit]
+		if i >= cutoff || change.Revision != nil {
+			kept = append(kept, commit)
+		} else {
+			pruned = append(pruned, change)
+		}
+	}
+
+	if len(pruned) == 0 {
+		return nil
+	}
+
+	err := s.archiveBlueprintChanges(name, pruned)
+	if err != nil {
+		return fmt.Errorf("cannot archive changes of blueprint %s: %v", name, err)
+	}
+
+	for _, change := range pruned {
+		delete(s.BlueprintsChanges[name], change.Commit)
+	}
+	s.BlueprintsCommits[name] = kept
+
+	return nil
+}
+
+// archiveBlueprintChanges appends `changes` to the archive of blueprint
+// `name`. Changes of stores without a state directory are not archived.
+func (s *Store) archiveBlueprintChanges(name string, changes []blueprint.Change) error {
+	if s.stateDir == nil {
+		return nil
+	}
+
+	dir := filepath.Join(*s.stateDir, blueprintChangesArchiveDir)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, name+".json"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(f)
+	for _, change := range changes {
+		err = encoder.Encode(change)
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+)
+
+func TestBlueprintRepository(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := New(&dir)
+	require.NoError(t, s.PushBlueprint(blueprint.Blueprint{Name: "octopus", Version: "0.0.1"}, "first"))
+	require.NoError(t, s.PushBlueprint(blueprint.Blueprint{Name: "squid", Version: "0.0.1"}, "second"))
+	require.NoError(t, s.PushBlueprint(blueprint.Blueprint{Name: "octopus", Version: "0.0.1"}, "third"))
+	require.NoError(t, s.TagBlueprint("octopus"))
+	require.NoError(t, s.DeleteBlueprint("squid"))
+
+	changes := s.GetBlueprintChanges("octopus")
+	require.Len(t, changes, 2)
+	for _, c := range changes {
+		require.True(t, s.blueprintRepo.HasObject(c.Commit))
+	}
+
+	// the history survives restarts
+	s = New(&dir)
+	head, err := s.blueprintRepo.Head(blueprintsBranch)
+	require.NoError(t, err)
+	require.NotEqual(t, changes[1].Commit, head)
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	git := func(args ...string) string {
+		out, err := exec.Command("git", append([]string{"--git-dir", filepath.Join(dir, "blueprints.git")}, args...)...).CombinedOutput()
+		require.NoError(t, err, string(out))
+		return strings.TrimSpace(string(out))
+	}
+
+	require.Equal(t, "Recipe squid deleted\nthird\nsecond\nfirst", git("log", "--format=%s"))
+	require.Equal(t, "octopus.toml", git("ls-tree", "--name-only", "master"))
+	require.Equal(t, changes[1].Commit, git("rev-parse", "octopus/r1"))
+	require.Contains(t, git("show", changes[1].Commit+":octopus.toml"), `version = "0.0.2"`)
+}
+
+func TestRevertBlueprint(t *testing.T) {
+	s := New(nil)
+	require.NoError(t, s.PushBlueprint(blueprint.Blueprint{Name: "octopus", Version: "0.0.1", Description: "eight arms"}, "first"))
+	require.NoError(t, s.PushBlueprint(blueprint.Blueprint{Name: "octopus", Version: "0.1.0", Description: "nine arms"}, "second"))
+	require.NoError(t, s.PushBlueprintToWorkspace(blueprint.Blueprint{Name: "octopus", Version: "0.1.1", Description: "ten arms"}))
+
+	first := s.GetBlueprintChanges("octopus")[0].Commit
+	commit, err := s.RevertBlueprint("octopus", first)
+	require.NoError(t, err)
+
+	changes := s.GetBlueprintChanges("octopus")
+	require.Len(t, changes, 3)
+	require.Equal(t, commit, changes[2].Commit)
+	require.Equal(t, "octopus.toml reverted to commit "+first, changes[2].Message)
+
+	bp, inWorkspace := s.GetBlueprint("octopus")
+	require.False(t, inWorkspace)
+	require.Equal(t, "eight arms", bp.Description)
+	require.Equal(t, "0.0.1", bp.Version)
+
+	_, err = s.RevertBlueprint("octopus", "FFFF")
+	require.IsType(t, &CommitNotFoundError{}, err)
+	_, err = s.RevertBlueprint("squid", first)
+	require.IsType(t, &NotFoundError{}, err)
+}
+
+func TestBlueprintHistoryDepth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := New(&dir)
+	for _, msg := range []string{"first", "second", "third"} {
+		require.NoError(t, s.PushBlueprint(blueprint.Blueprint{Name: "octopus"}, msg))
+		if msg == "first" {
+			require.NoError(t, s.TagBlueprint("octopus"))
+		}
+	}
+	require.NoError(t, s.PushBlueprint(blueprint.Blueprint{Name: "squid"}, "squid"))
+
+	require.Error(t, s.SetBlueprintHistoryDepth(-1))
+	require.NoError(t, s.SetBlueprintHistoryDepth(1))
+
+	messages := func(name string) []string {
+		var msgs []string
+		for _, c := range s.GetBlueprintChanges(name) {
+			msgs = append(msgs, c.Message)
+		}
+		return msgs
+	}
+
+	// the tagged change is kept
+	require.Equal(t, []string{"first", "third"}, messages("octopus"))
+	require.Equal(t, []string{"squid"}, messages("squid"))
+
+	require.NoError(t, s.PushBlueprint(blueprint.Blueprint{Name: "octopus"}, "fourth"))
+	require.Equal(t, []string{"first", "fourth"}, messages("octopus"))
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, blueprintChangesArchiveDir, "octopus.json"))
+	require.NoError(t, err)
+	var archived []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var change blueprint.Change
+		require.NoError(t, json.Unmarshal([]byte(line), &change))
+		archived = append(archived, change.Message)
+	}
+	require.Equal(t, []string{"second", "third"}, archived)
+
+	// pruning can be disabled
+	require.NoError(t, s.SetBlueprintHistoryDepth(0))
+	require.NoError(t, s.PushBlueprint(blueprint.Blueprint{Name: "octopus"}, "fifth"))
+	require.Equal(t, []string{"first", "fourth", "fifth"}, messages("octopus"))
+}
@@ -0,0 +1,40 @@
+package store
+
+import (
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/compose"
+)
+
+// GetComposeOfJob returns the compose that job `jobID` belongs to, as one of
+// the jobs of its image builds. Like the package index, the index of jobs is
+// rebuilt on the first query after a change.
+func (s *Store) GetComposeOfJob(jobID uuid.UUID) (uuid.UUID, compose.Compose, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.jobIndex == nil {
+		s.jobIndex = buildJobIndex(s.Composes)
+	}
+
+	id, exists := s.jobIndex[jobID]
+	if !exists {
+		return uuid.Nil, compose.Compose{}, false
+	}
+	c := s.Composes[id]
+	return id, c.DeepCopy(), true
+}
+
+func buildJobIndex(composes map[uuid.UUID]compose.Compose) map[uuid.UUID]uuid.UUID {
+	index := make(map[uuid.UUID]uuid.UUID)
+	for id, c := range composes {
+		for _, ib := range c.ImageBuilds {
+			for _, jobID := range []uuid.UUID{ib.JobId, ib.ScanJobId, ib.SignJobId, ib.UploadJobId} {
+				if jobID != uuid.Nil {
+					index[jobID] = id
+				}
+			}
+		}
+	}
+	return index
+}
@@ -0,0 +1,41 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/distro/fedoratest"
+)
+
+func TestGetComposeOfJob(t *testing.T) {
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+
+	s := New(nil)
+	id, jobID := uuid.New(), uuid.New()
+	require.NoError(t, s.PushCompose(id, nil, imageType, &blueprint.Blueprint{Name: "test"}, 0, nil, nil, jobID))
+
+	composeID, c, exists := s.GetComposeOfJob(jobID)
+	require.True(t, exists)
+	require.Equal(t, id, composeID)
+	require.Equal(t, "test", c.Blueprint.Name)
+
+	_, _, exists = s.GetComposeOfJob(uuid.New())
+	require.False(t, exists)
+
+	// the index follows changes
+	scanJobID := uuid.New()
+	require.NoError(t, s.SetImageBuildScanJob(id, 0, scanJobID))
+	composeID, _, exists = s.GetComposeOfJob(scanJobID)
+	require.True(t, exists)
+	require.Equal(t, id, composeID)
+
+	require.NoError(t, s.DeleteCompose(id))
+	_, _, exists = s.GetComposeOfJob(jobID)
+	require.False(t, exists)
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// How many nightly runs are kept. Older ones are forgotten when a new run is
+// added, but their composes are kept.
+const maxNightlyRuns = 30
+
+// States of a build of a nightly run
+const (
+	NightlyBuilding  = "building"
+	NightlyFailed    = "failed"
+	NightlyPassed    = "passed"
+	NightlyPublished = "published"
+)
+
+// A NightlyRun records one run of the nightly rebuild pipeline: the snapshot
+// of the repositories it built against and what became of each blueprint.
+type NightlyRun struct {
+	ID       uuid.UUID `json:"id"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitempty"`
+
+	// Checksums of the repositories' metadata, by repository name
+	Snapshot map[string]string `json:"snapshot"`
+
+	Builds []NightlyBuild `json:"builds"`
+}
+
+type NightlyBuild struct {
+	Blueprint        string    `json:"blueprint"`
+	BlueprintVersion string    `json:"blueprint_version,omitempty"`
+	ComposeID        uuid.UUID `json:"compose_id,omitempty"`
+	State            string    `json:"state"`
+	Error            string    `json:"error,omitempty"`
+}
+
+// Done returns true if all builds of the run are done.
+func (r NightlyRun) Done() bool {
+	for _, b := range r.Builds {
+		if b.State == NightlyBuilding {
+			return false
+		}
+	}
+	return true
+}
+
+// GetNightlyRuns returns the kept nightly runs, oldest first.
+func (s *Store) GetNightlyRuns() []NightlyRun {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	runs := make([]NightlyRun, len(s.NightlyRuns))
+	for i, run := range s.NightlyRuns {
+		run.Builds = append([]NightlyBuild{}, run.Builds...)
+		runs[i] = run
+	}
+
+	return runs
+}
+
+// AddNightlyRun adds `run`, forgetting the oldest runs if there are more than
+// can be kept.
+func (s *Store) AddNightlyRun(run NightlyRun) error {
+	return s.change(func() error {
+		s.NightlyRuns = append(s.NightlyRuns, run)
+		if n := len(s.NightlyRuns); n > maxNightlyRuns {
+			s.NightlyRuns = append([]NightlyRun{}, s.NightlyRuns[n-maxNightlyRuns:]...)
+		}
+		return nil
+	})
+}
+
+// UpdateNightlyRun replaces the run with the same id as `run`.
+func (s *Store) UpdateNightlyRun(run NightlyRun) error {
+	return s.change(func() error {
+		for i := range s.NightlyRuns {
+			if s.NightlyRuns[i].ID == run.ID {
+				s.NightlyRuns[i] = run
+				return nil
+			}
+		}
+		return &NotFoundError{fmt.Sprintf("nightly run %s does not exist", run.ID)}
+	})
+}
@@ -0,0 +1,40 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNightlyRuns(t *testing.T) {
+	s := New(nil)
+	require.Empty(t, s.GetNightlyRuns())
+
+	start := time.Date(2020, 6, 1, 2, 30, 0, 0, time.UTC)
+	for i := 0; i < maxNightlyRuns+2; i++ {
+		run := NightlyRun{
+			ID:      uuid.New(),
+			Started: start.AddDate(0, 0, i),
+			Builds:  []NightlyBuild{{Blueprint: "base", State: NightlyBuilding}},
+		}
+		require.NoError(t, s.AddNightlyRun(run))
+	}
+
+	runs := s.GetNightlyRuns()
+	require.Len(t, runs, maxNightlyRuns)
+	require.Equal(t, start.AddDate(0, 0, 2), runs[0].Started)
+	require.False(t, runs[len(runs)-1].Done())
+
+	// runs are copies
+	last := runs[len(runs)-1]
+	last.Builds[0].State = NightlyPassed
+	require.Equal(t, NightlyBuilding, s.GetNightlyRuns()[maxNightlyRuns-1].Builds[0].State)
+
+	require.NoError(t, s.UpdateNightlyRun(last))
+	require.True(t, s.GetNightlyRuns()[maxNightlyRuns-1].Done())
+
+	err := s.UpdateNightlyRun(NightlyRun{ID: uuid.New()})
+	require.IsType(t, &NotFoundError{}, err)
+}
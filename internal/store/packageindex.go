@@ -0,0 +1,160 @@
+package store
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/osbuild"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+)
+
+// A PackageUse records that the image of an image build contains a package.
+type PackageUse struct {
+	ComposeID    uuid.UUID
+	ImageBuildID int
+	Package      rpmmd.PackageSpec
+}
+
+// FindPackageUses returns all image builds whose image contains package
+// `name`. If any of `version`, `release`, or `arch` are not empty, only
+// packages matching them are returned. Results are ordered by compose id.
+//
+// Image builds of composes from before packages were recorded are indexed
+// by the package file names in their manifests. Their epoch is unknown and
+// always 0.
+func (s *Store) FindPackageUses(name, version, release, arch string) []PackageUse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.packageIndex == nil {
+		s.packageIndex = buildPackageIndex(s.Composes)
+	}
+
+	uses := []PackageUse{}
+	for _, use := range s.packageIndex[name] {
+		p := use.Package
+		if (version == "" || version == p.Version) && (release == "" || release == p.Release) && (arch == "" || arch == p.Arch) {
+			uses = append(uses, use)
+		}
+	}
+
+	return uses
+}
+
+// GetImageBuildPackages returns the packages installed into the image of an
+// image build. Like FindPackageUses, it falls back to the manifest for
+// composes from before packages were recorded.
+func (s *Store) GetImageBuildPackages(composeID uuid.UUID, imageBuildID int) ([]rpmmd.PackageSpec, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, exists := s.Composes[composeID]
+	if !exists {
+		return nil, &NotFoundError{"compose does not exist"}
+	}
+	if imageBuildID < 0 || imageBuildID >= len(c.ImageBuilds) {
+		return nil, &NotFoundError{"image build does not exist"}
+	}
+
+	ib := c.ImageBuilds[imageBuildID]
+	if ib.Packages != nil {
+		return append([]rpmmd.PackageSpec{}, ib.Packages...), nil
+	}
+	return packagesFromManifest(ib.Manifest), nil
+}
+
+func buildPackageIndex(composes map[uuid.UUID]compose.Compose) map[string][]PackageUse {
+	index := make(map[string][]PackageUse)
+
+	for id, c := range composes {
+		for i, ib := range c.ImageBuilds {
+			packages := ib.Packages
+			if packages == nil {
+				packages = packagesFromManifest(ib.Manifest)
+			}
+
+			for _, p := range packages {
+				index[p.Name] = append(index[p.Name], PackageUse{
+					ComposeID:    id,
+					ImageBuildID: i,
+					Package: rpmmd.PackageSpec{
+						Name:    p.Name,
+						Epoch:   p.Epoch,
+						Version: p.Version,
+						Release: p.Release,
+						Arch:    p.Arch,
+					},
+				})
+			}
+		}
+	}
+
+	for _, uses := range index {
+		sort.Slice(uses, func(i, j int) bool {
+			if uses[i].ComposeID == uses[j].ComposeID {
+				return uses[i].ImageBuildID < uses[j].ImageBuildID
+			}
+			return uses[i].ComposeID.String() < uses[j].ComposeID.String()
+		})
+	}
+
+	return index
+}
+
+// packagesFromManifest returns the packages that `manifest` downloads,
+// derived from their file names (name-version-release.arch.rpm).
+func packagesFromManifest(manifest *osbuild.Manifest) []rpmmd.PackageSpec {
+	if manifest == nil {
+		return nil
+	}
+	files, ok := manifest.Sources["org.osbuild.files"].(*osbuild.FilesSource)
+	if !ok {
+		return nil
+	}
+
+	var packages []rpmmd.PackageSpec
+	for _, url := range files.URLs {
+		p, ok := parseRPMFilename(path.Base(url))
+		if ok {
+			packages = append(packages, p)
+		}
+	}
+
+	return packages
+}
+
+func parseRPMFilename(filename string) (rpmmd.PackageSpec, bool) {
+	nvra := strings.TrimSuffix(filename, ".rpm")
+	if nvra == filename {
+		return rpmmd.PackageSpec{}, false
+	}
+
+	dot := strings.LastIndexByte(nvra, '.')
+	if dot < 0 {
+		return rpmmd.PackageSpec{}, false
+	}
+	nvr, arch := nvra[:dot], nvra[dot+1:]
+
+	dash := strings.LastIndexByte(nvr, '-')
+	if dash < 0 {
+		return rpmmd.PackageSpec{}, false
+	}
+	nv, release := nvr[:dash], nvr[dash+1:]
+
+	dash = strings.LastIndexByte(nv, '-')
+	if dash <= 0 {
+		return rpmmd.PackageSpec{}, false
+	}
+	name, version := nv[:dash], nv[dash+1:]
+
+	return rpmmd.PackageSpec{
+		Name:    name,
+		Version: version,
+		Release: release,
+		Arch:    arch,
+	}, true
+}
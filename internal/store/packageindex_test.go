@@ -0,0 +1,82 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/distro/fedoratest"
+	"github.com/osbuild/osbuild-composer/internal/osbuild"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+)
+
+func TestParseRPMFilename(t *testing.T) {
+	p, ok := parseRPMFilename("openssl-libs-1.1.1g-1.fc32.x86_64.rpm")
+	require.True(t, ok)
+	require.Equal(t, rpmmd.PackageSpec{Name: "openssl-libs", Version: "1.1.1g", Release: "1.fc32", Arch: "x86_64"}, p)
+
+	for _, filename := range []string{"openssl.tar.gz", "openssl.rpm", "openssl-1.1.1g.x86_64.rpm", "-1-1.noarch.rpm"} {
+		_, ok = parseRPMFilename(filename)
+		require.False(t, ok, filename)
+	}
+}
+
+func TestFindPackageUses(t *testing.T) {
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+
+	s := New(nil)
+
+	recent := uuid.New()
+	err = s.PushCompose(recent, &osbuild.Manifest{}, imageType, &blueprint.Blueprint{}, 0, nil, nil, uuid.New())
+	require.NoError(t, err)
+	require.Empty(t, s.FindPackageUses("openssl", "", "", ""))
+
+	err = s.SetImageBuildPackages(recent, 0, []rpmmd.PackageSpec{
+		{Name: "openssl", Epoch: 1, Version: "1.1.1g", Release: "1.fc32", Arch: "x86_64", Checksum: "sha256:aaaa"},
+		{Name: "bash", Version: "5.0.17", Release: "1.fc32", Arch: "x86_64"},
+	}, nil)
+	require.NoError(t, err)
+
+	// composes from before packages were recorded are found by their manifests
+	old := uuid.New()
+	manifest := &osbuild.Manifest{
+		Sources: osbuild.Sources{
+			"org.osbuild.files": &osbuild.FilesSource{URLs: map[string]string{
+				"sha256:bbbb": "http://example.com/Packages/o/openssl-1.1.1d-2.fc32.x86_64.rpm",
+			}},
+		},
+	}
+	err = s.PushCompose(old, manifest, imageType, &blueprint.Blueprint{}, 0, nil, nil, uuid.New())
+	require.NoError(t, err)
+
+	uses := s.FindPackageUses("openssl", "", "", "")
+	require.Len(t, uses, 2)
+	require.ElementsMatch(t, []uuid.UUID{recent, old}, []uuid.UUID{uses[0].ComposeID, uses[1].ComposeID})
+
+	uses = s.FindPackageUses("openssl", "1.1.1g", "", "x86_64")
+	require.Equal(t, []PackageUse{{
+		ComposeID: recent,
+		Package:   rpmmd.PackageSpec{Name: "openssl", Epoch: 1, Version: "1.1.1g", Release: "1.fc32", Arch: "x86_64"},
+	}}, uses)
+
+	require.Empty(t, s.FindPackageUses("openssl", "1.1.1g", "2.fc32", ""))
+
+	packages, err := s.GetImageBuildPackages(old, 0)
+	require.NoError(t, err)
+	require.Equal(t, []rpmmd.PackageSpec{{Name: "openssl", Version: "1.1.1d", Release: "2.fc32", Arch: "x86_64"}}, packages)
+	packages, err = s.GetImageBuildPackages(recent, 0)
+	require.NoError(t, err)
+	require.Len(t, packages, 2)
+	_, err = s.GetImageBuildPackages(recent, 1)
+	require.IsType(t, &NotFoundError{}, err)
+
+	require.NoError(t, s.DeleteCompose(recent))
+	uses = s.FindPackageUses("openssl", "", "", "")
+	require.Len(t, uses, 1)
+	require.Equal(t, old, uses[0].ComposeID)
+}
@@ -0,0 +1,56 @@
+package store
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A Rebuild records what the automatic rebuilds of a blueprint have seen and
+// built. Changes are only rebuilt once they were seen for a while, so that a
+// series of commits or repository updates leads to a single rebuild.
+type Rebuild struct {
+	// The blueprint version and the checksums of the repositories' metadata
+	// (by repository name) that were last seen, and when they were first
+	// seen. Either is empty if its changes don't trigger rebuilds.
+	SeenVersion  string            `json:"seen_version,omitempty"`
+	SeenSnapshot map[string]string `json:"seen_snapshot,omitempty"`
+	Seen         time.Time         `json:"seen"`
+
+	// The same, for the last rebuild
+	BuiltVersion  string            `json:"built_version,omitempty"`
+	BuiltSnapshot map[string]string `json:"built_snapshot,omitempty"`
+	Built         time.Time         `json:"built,omitempty"`
+
+	// What triggered the last rebuild and the composes it started
+	Reason   string      `json:"reason,omitempty"`
+	Composes []uuid.UUID `json:"composes,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// GetRebuilds returns the automatic rebuilds, by blueprint name.
+func (s *Store) GetRebuilds() map[string]Rebuild {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rebuilds := make(map[string]Rebuild, len(s.Rebuilds))
+	for name, rebuild := range s.Rebuilds {
+		rebuild.Composes = append([]uuid.UUID{}, rebuild.Composes...)
+		rebuilds[name] = rebuild
+	}
+
+	return rebuilds
+}
+
+// SetRebuild replaces the automatic rebuild of blueprint `name`. It is
+// forgotten when the blueprint is deleted.
+func (s *Store) SetRebuild(name string, rebuild Rebuild) error {
+	return s.change(func() error {
+		if _, exists := s.Blueprints[name]; !exists {
+			return &NotFoundError{"blueprint does not exist"}
+		}
+
+		s.Rebuilds[name] = rebuild
+		return nil
+	})
+}
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/compose"
+)
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	io.Writer
+	n uint64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n += uint64(n)
+	return n, err
+}
+
+// RegisterCompose adds `c` with `id`. It describes an image that was built
+// elsewhere (see compose.Registration) and must have a single image build.
+// If `image` is not nil, it is stored as the image of the image build's
+// local target, and the image build's size is set to its size.
+func (s *Store) RegisterCompose(id uuid.UUID, c compose.Compose, image io.Reader) error {
+	if c.Registration == nil || len(c.ImageBuilds) != 1 {
+		return &InvalidRequestError{"a registered compose needs a registration and a single image build"}
+	}
+	if _, exists := s.GetCompose(id); exists {
+		return &InvalidRequestError{fmt.Sprintf("compose %s already exists", id)}
+	}
+
+	ib := &c.ImageBuilds[0]
+
+	if image != nil {
+		options := ib.GetLocalTargetOptions()
+		if options == nil {
+			return &NoLocalTargetError{"the image of a registered compose needs a local target"}
+		}
+		if s.stateDir == nil {
+			return &NoLocalTargetError{"images are not stored"}
+		}
+
+		dir := s.getImageBuildDirectory(id, 0)
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return fmt.Errorf("cannot create output directory for compose %s: %v", id, err)
+		}
+
+		f, err := s.createArtifact(filepath.Join(dir, options.Filename))
+		if err != nil {
+			return err
+		}
+		w := &countingWriter{Writer: f}
+		_, err = io.Copy(w, image)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			// don't keep incomplete images around
+			_ = os.RemoveAll(s.getComposeDirectory(id))
+			return err
+		}
+
+		ib.Size = w.n
+	}
+
+	return s.change(func() error {
+		if _, exists := s.Composes[id]; exists {
+			return &InvalidRequestError{fmt.Sprintf("compose %s already exists", id)}
+		}
+		c.Tenant = s.tenant
+		s.Composes[id] = c
+		return nil
+	})
+}
@@ -0,0 +1,76 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/target"
+)
+
+func TestRegisterCompose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := New(&dir)
+
+	registered := func(id uuid.UUID, targets ...*target.Target) compose.Compose {
+		return compose.Compose{
+			Blueprint: &blueprint.Blueprint{Name: "web", Version: "1.2.0"},
+			ImageBuilds: []compose.ImageBuild{{
+				ImageType:   common.Qcow2Generic,
+				Targets:     targets,
+				QueueStatus: common.IBFinished,
+			}},
+			Registration: &compose.Registration{
+				Provider:     "aws",
+				ImageID:      "ami-0123",
+				RegisteredAt: time.Now(),
+			},
+		}
+	}
+
+	// images in the cloud
+	id := uuid.New()
+	err = s.RegisterCompose(id, registered(id), nil)
+	require.NoError(t, err)
+	c, exists := s.GetCompose(id)
+	require.True(t, exists)
+	require.Equal(t, "ami-0123", c.Registration.ImageID)
+
+	err = s.RegisterCompose(id, registered(id), nil)
+	require.IsType(t, &InvalidRequestError{}, err)
+
+	// uploaded images
+	id = uuid.New()
+	err = s.RegisterCompose(id, registered(id), strings.NewReader("image"))
+	require.IsType(t, &NoLocalTargetError{}, err)
+
+	local := target.NewLocalTarget(&target.LocalTargetOptions{ComposeId: id, Filename: "disk.qcow2"})
+	err = s.RegisterCompose(id, registered(id, local), strings.NewReader("image"))
+	require.NoError(t, err)
+	c, exists = s.GetCompose(id)
+	require.True(t, exists)
+	require.Equal(t, uint64(5), c.ImageBuilds[0].Size)
+
+	image, err := ioutil.ReadFile(filepath.Join(s.getImageBuildDirectory(id, 0), "disk.qcow2"))
+	require.NoError(t, err)
+	require.Equal(t, "image", string(image))
+
+	// composes without a registration
+	id = uuid.New()
+	c = registered(id)
+	c.Registration = nil
+	err = s.RegisterCompose(id, c, nil)
+	require.IsType(t, &InvalidRequestError{}, err)
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+)
+
+// A RetentionPolicy limits how many composes are kept. Zero values mean no
+// limit. Only composes which are done (finished or failed) are pruned, and
+// the oldest ones are pruned first.
+type RetentionPolicy struct {
+	// Composes that were done longer ago than this are pruned
+	MaxAge time.Duration
+
+	// At most this many composes which are done are kept
+	MaxCount int
+
+	// Composes are pruned until all composes' outputs take up at most
+	// this many bytes
+	MaxDiskUsage int64
+}
+
+// IsZero returns true if the policy doesn't limit anything.
+func (p RetentionPolicy) IsZero() bool {
+	return p.MaxAge == 0 && p.MaxCount == 0 && p.MaxDiskUsage == 0
+}
+
+// A DoneFunc returns whether a compose is done and when it was done. The
+// store cannot determine this itself, because it doesn't know about jobs.
+type DoneFunc func(c compose.Compose) (bool, time.Time)
+
+// A PrunedCompose is a compose that was deleted by Prune.
+type PrunedCompose struct {
+	ID     uuid.UUID
+	Reason string
+}
+
+type pruneCandidate struct {
+	id        uuid.UUID
+	done      time.Time
+	diskUsage int64
+}
+
+// Prune deletes composes according to `policy`, including their outputs.
+// Composes which are published in the image gallery or which are being
+// promoted are never pruned.
+func (s *Store) Prune(policy RetentionPolicy, done DoneFunc) ([]PrunedCompose, error) {
+	if policy.IsZero() {
+		return nil, nil
+	}
+
+	var candidates []pruneCandidate
+	var diskUsage int64
+	for id, c := range s.GetAllComposes() {
+		usage := s.composeDiskUsage(id)
+		diskUsage += usage
+
+		if c.Publication != nil || hasPendingPromotion(c) {
+			continue
+		}
+
+		isDone, doneAt := done(c)
+		if isDone {
+			candidates = append(candidates, pruneCandidate{id, doneAt, usage})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].done.Before(candidates[j].done)
+	})
+
+	var pruned []PrunedCompose
+	now := time.Now()
+	for i, candidate := range candidates {
+		var reason string
+		switch {
+		case policy.MaxAge > 0 && now.Sub(candidate.done) > policy.MaxAge:
+			reason = "max age"
+		case policy.MaxCount > 0 && len(candidates)-i > policy.MaxCount:
+			reason = "max count"
+		case policy.MaxDiskUsage > 0 && diskUsage > policy.MaxDiskUsage:
+			reason = "max disk usage"
+		default:
+			// candidates are sorted, so all remaining ones are kept
+			return pruned, nil
+		}
+
+		err := s.DeleteCompose(candidate.id)
+		if err != nil {
+			// the compose was deleted in the meantime
+			if _, ok := err.(*NotFoundError); ok {
+				diskUsage -= candidate.diskUsage
+				continue
+			}
+			return pruned, err
+		}
+
+		diskUsage -= candidate.diskUsage
+		pruned = append(pruned, PrunedCompose{candidate.id, reason})
+	}
+
+	return pruned, nil
+}
+
+func hasPendingPromotion(c compose.Compose) bool {
+	for _, p := range c.Promotions {
+		if p.Status == common.IBWaiting {
+			return true
+		}
+	}
+	return false
+}
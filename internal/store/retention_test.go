@@ -0,0 +1,104 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/distro/fedoratest"
+)
+
+func TestPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+
+	now := time.Now()
+
+	// creates a store with composes that were done `ages` ago (or are still
+	// running, for negative ages), each with a 100 byte image
+	setup := func(composeAges ...time.Duration) (*Store, []uuid.UUID, DoneFunc) {
+		s := New(&dir)
+		for id := range s.Composes {
+			require.NoError(t, s.DeleteCompose(id))
+		}
+
+		// keyed by job id, because composes don't know their own id
+		ages := make(map[uuid.UUID]time.Duration)
+		var ids []uuid.UUID
+		for _, age := range composeAges {
+			id := uuid.New()
+			jobId := uuid.New()
+			err := s.PushCompose(id, nil, imageType, &blueprint.Blueprint{Name: "test"}, 0, nil, nil, jobId)
+			require.NoError(t, err)
+
+			imageDir := s.getImageBuildDirectory(id, 0)
+			require.NoError(t, os.MkdirAll(imageDir, 0700))
+			require.NoError(t, ioutil.WriteFile(filepath.Join(imageDir, "disk.qcow2"), make([]byte, 100), 0600))
+
+			ages[jobId] = age
+			ids = append(ids, id)
+		}
+
+		done := func(c compose.Compose) (bool, time.Time) {
+			age := ages[c.ImageBuilds[0].JobId]
+			return age >= 0, now.Add(-age)
+		}
+
+		return s, ids, done
+	}
+
+	remaining := func(s *Store) int {
+		return len(s.GetAllComposes())
+	}
+
+	// nothing is pruned without a policy
+	s, _, done := setup(48 * time.Hour)
+	pruned, err := s.Prune(RetentionPolicy{}, done)
+	require.NoError(t, err)
+	require.Empty(t, pruned)
+
+	// max age
+	s, ids, done := setup(48*time.Hour, time.Hour, -1)
+	pruned, err = s.Prune(RetentionPolicy{MaxAge: 24 * time.Hour}, done)
+	require.NoError(t, err)
+	require.Equal(t, []PrunedCompose{{ids[0], "max age"}}, pruned)
+	require.Equal(t, 2, remaining(s))
+	_, err = os.Stat(s.getComposeDirectory(ids[0]))
+	require.True(t, os.IsNotExist(err))
+
+	// max count doesn't count running composes
+	s, ids, done = setup(3*time.Hour, time.Hour, 2*time.Hour, -1)
+	pruned, err = s.Prune(RetentionPolicy{MaxCount: 2}, done)
+	require.NoError(t, err)
+	require.Equal(t, []PrunedCompose{{ids[0], "max count"}}, pruned)
+	require.Equal(t, 3, remaining(s))
+
+	// max disk usage counts running composes, but doesn't prune them
+	s, ids, done = setup(-1, 2*time.Hour, time.Hour)
+	pruned, err = s.Prune(RetentionPolicy{MaxDiskUsage: 150}, done)
+	require.NoError(t, err)
+	require.Equal(t, []PrunedCompose{{ids[1], "max disk usage"}, {ids[2], "max disk usage"}}, pruned)
+	require.Equal(t, 1, remaining(s))
+
+	// published composes are kept
+	s, ids, done = setup(48 * time.Hour)
+	c := s.Composes[ids[0]]
+	c.Publication = &compose.Publication{}
+	s.Composes[ids[0]] = c
+	pruned, err = s.Prune(RetentionPolicy{MaxAge: time.Hour}, done)
+	require.NoError(t, err)
+	require.Empty(t, pruned)
+}
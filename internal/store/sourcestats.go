@@ -0,0 +1,77 @@
+package store
+
+import (
+	"time"
+)
+
+// SourceStats records how a source was used, so that administrators can
+// find sources which are unused or unreachable. Stats are kept for system
+// repositories, too.
+type SourceStats struct {
+	// The number of composes which included the source
+	Composes int `json:"composes"`
+
+	// The last time metadata of the source was fetched successfully
+	LastFetched time.Time `json:"last_fetched"`
+
+	// The number of times fetching metadata failed since LastFetched,
+	// and the error of the latest failure
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastFailed          time.Time `json:"last_failed"`
+	LastError           string    `json:"last_error,omitempty"`
+}
+
+// GetSourceStats returns the stats of all sources that were used at least
+// once.
+func (s *Store) GetSourceStats() map[string]SourceStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := make(map[string]SourceStats, len(s.SourceStats))
+	for name, st := range s.SourceStats {
+		stats[name] = st
+	}
+
+	return stats
+}
+
+// SourcesUsedInCompose counts a compose for each of the sources in `names`.
+func (s *Store) SourcesUsedInCompose(names []string) error {
+	return s.change(func() error {
+		for _, name := range names {
+			st := s.SourceStats[name]
+			st.Composes += 1
+			s.SourceStats[name] = st
+		}
+		return nil
+	})
+}
+
+// SourcesFetched records that metadata of the sources in `names` was
+// fetched successfully at `when`.
+func (s *Store) SourcesFetched(names []string, when time.Time) error {
+	return s.change(func() error {
+		for _, name := range names {
+			st := s.SourceStats[name]
+			st.LastFetched = when
+			st.ConsecutiveFailures = 0
+			s.SourceStats[name] = st
+		}
+		return nil
+	})
+}
+
+// SourcesFetchFailed records that fetching metadata of the sources in `names`
+// failed at `when` with `reason`.
+func (s *Store) SourcesFetchFailed(names []string, when time.Time, reason string) error {
+	return s.change(func() error {
+		for _, name := range names {
+			st := s.SourceStats[name]
+			st.ConsecutiveFailures += 1
+			st.LastFailed = when
+			st.LastError = reason
+			s.SourceStats[name] = st
+		}
+		return nil
+	})
+}
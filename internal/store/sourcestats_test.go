@@ -0,0 +1,38 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceStats(t *testing.T) {
+	s := New(nil)
+	s.PushSource(SourceConfig{Name: "extras", Type: "yum-baseurl", URL: "http://example.com/extras"})
+
+	now := time.Now()
+	require.NoError(t, s.SourcesFetched([]string{"base", "extras"}, now))
+	require.NoError(t, s.SourcesUsedInCompose([]string{"base", "extras"}))
+	require.NoError(t, s.SourcesUsedInCompose([]string{"base"}))
+	require.NoError(t, s.SourcesFetchFailed([]string{"extras"}, now.Add(time.Minute), "timeout"))
+	require.NoError(t, s.SourcesFetchFailed([]string{"extras"}, now.Add(2*time.Minute), "no route to host"))
+
+	stats := s.GetSourceStats()
+	require.Equal(t, SourceStats{Composes: 2, LastFetched: now}, stats["base"])
+	require.Equal(t, SourceStats{
+		Composes:            1,
+		LastFetched:         now,
+		ConsecutiveFailures: 2,
+		LastFailed:          now.Add(2 * time.Minute),
+		LastError:           "no route to host",
+	}, stats["extras"])
+
+	// a successful fetch resets the failure count, but keeps the last error
+	require.NoError(t, s.SourcesFetched([]string{"extras"}, now.Add(3*time.Minute)))
+	require.Equal(t, 0, s.GetSourceStats()["extras"].ConsecutiveFailures)
+	require.Equal(t, "no route to host", s.GetSourceStats()["extras"].LastError)
+
+	s.DeleteSource("extras")
+	require.NotContains(t, s.GetSourceStats(), "extras")
+}
@@ -6,21 +6,24 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/osbuild/osbuild-composer/internal/compose"
 	"github.com/osbuild/osbuild-composer/internal/distro"
+	"github.com/osbuild/osbuild-composer/internal/gitrepo"
 	"github.com/osbuild/osbuild-composer/internal/jsondb"
+	"github.com/osbuild/osbuild-composer/internal/logging"
 	"github.com/osbuild/osbuild-composer/internal/osbuild"
 
 	"github.com/osbuild/osbuild-composer/internal/blueprint"
@@ -44,11 +47,36 @@ type Store struct {
 	Sources           map[string]SourceConfig                `json:"sources"`
 	BlueprintsChanges map[string]map[string]blueprint.Change `json:"changes"`
 	BlueprintsCommits map[string][]string                    `json:"commits"`
+	WorkspaceInfo     map[string]WorkspaceInfo               `json:"workspace_info,omitempty"`
+	SourceStats       map[string]SourceStats                 `json:"source_stats,omitempty"`
+	UploadStats       map[string]UploadStats                 `json:"upload_stats,omitempty"`
+	NightlyRuns       []NightlyRun                           `json:"nightly_runs,omitempty"`
+	Webhooks          map[uuid.UUID]Webhook                  `json:"webhooks,omitempty"`
+	Rebuilds          map[string]Rebuild                     `json:"rebuilds,omitempty"`
+	GitSync           *GitSync                               `json:"git_sync,omitempty"`
+
+	mu            sync.RWMutex // protects all fields
+	pendingJobs   chan Job
+	stateDir      *string
+	db            *jsondb.JSONDatabase
+	blueprintRepo *gitrepo.Repository
+	packageIndex  map[string][]PackageUse
+	jobIndex      map[uuid.UUID]uuid.UUID // see GetComposeOfJob
+	encoding      ArtifactEncoding
+	gpgKeys       map[string]string // the key store, by name
+	historyDepth  int               // see SetBlueprintHistoryDepth
+	tenant        string            // see Tenants
+
+	admissionCheck AdmissionCheck // see SetAdmissionCheck
+}
+
+// WorkspaceInfo tracks when the workspace copy of a blueprint was last
+// changed, so that stale copies can be removed by SweepWorkspace.
+type WorkspaceInfo struct {
+	Updated time.Time `json:"updated"`
 
-	mu          sync.RWMutex // protects all fields
-	pendingJobs chan Job
-	stateDir    *string
-	db          *jsondb.JSONDatabase
+	// Whether a warning about the upcoming expiry was issued
+	Warned bool `json:"warned,omitempty"`
 }
 
 // A Job contains the information about a compose a worker needs to process it.
@@ -66,6 +94,30 @@ type SourceConfig struct {
 	CheckGPG bool   `json:"check_gpg" toml:"check_gpg"`
 	CheckSSL bool   `json:"check_ssl" toml:"check_ssl"`
 	System   bool   `json:"system" toml:"system"`
+
+	// Proxy is the URL of a proxy to reach the source through. SSLCACert
+	// is the path of a CA certificate to verify the source with, for
+	// proxies that intercept TLS. SSLClientCert and SSLClientKey are the
+	// paths of a client certificate and its key, for sources that require
+	// one. All paths are on the host composer runs on.
+	Proxy         string `json:"proxy,omitempty" toml:"proxy,omitempty"`
+	SSLCACert     string `json:"sslcacert,omitempty" toml:"sslcacert,omitempty"`
+	SSLClientCert string `json:"sslclientcert,omitempty" toml:"sslclientcert,omitempty"`
+	SSLClientKey  string `json:"sslclientkey,omitempty" toml:"sslclientkey,omitempty"`
+
+	// GPGKeys are the names of the keys in the key store that packages of
+	// the source are checked against. GPGKeyURLs are the URLs the keys were
+	// fetched from, if any.
+	GPGKeys    []string `json:"gpgkeys,omitempty" toml:"gpgkeys,omitempty"`
+	GPGKeyURLs []string `json:"gpgkey_urls,omitempty" toml:"gpgkey_urls,omitempty"`
+
+	// Mirrors are the URLs of mirrors of a yum-baseurl source, which are
+	// used when URL cannot be reached. Packages are taken from the sources
+	// with the lowest Priority that have them, preferably from the one with
+	// the lowest Cost. Zero means dnf's default.
+	Mirrors  []string `json:"mirrors,omitempty" toml:"mirrors,omitempty"`
+	Priority int      `json:"priority,omitempty" toml:"priority,omitempty"`
+	Cost     int      `json:"cost,omitempty" toml:"cost,omitempty"`
 }
 
 type NotFoundError struct {
@@ -76,6 +128,14 @@ func (e *NotFoundError) Error() string {
 	return e.message
 }
 
+type CommitNotFoundError struct {
+	message string
+}
+
+func (e *CommitNotFoundError) Error() string {
+	return e.message
+}
+
 type NotPendingError struct {
 	message string
 }
@@ -106,13 +166,13 @@ func New(stateDir *string) *Store {
 	if stateDir != nil {
 		err := os.Mkdir(*stateDir+"/"+"outputs", 0700)
 		if err != nil && !os.IsExist(err) {
-			log.Fatalf("cannot create output directory")
+			logging.Default().Fatal("cannot create output directory", "path", *stateDir+"/outputs", "error", err)
 		}
 
 		s.db = jsondb.New(*stateDir, 0600)
 		_, err = s.db.Read(StoreDBName, &s)
 		if err != nil {
-			log.Fatalf("cannot read state: %v", err)
+			logging.Default().Fatal("cannot read state", "path", *stateDir, "error", err)
 		}
 	}
 
@@ -139,6 +199,7 @@ func New(stateDir *string) *Store {
 			for imgID, imgBuild := range compose.ImageBuilds {
 				switch imgBuild.QueueStatus {
 				case common.IBRunning, common.IBWaiting:
+					logging.Default().Warning("failing compose that was interrupted", "compose_id", composeID, "image_build_id", imgID)
 					compose.ImageBuilds[imgID].QueueStatus = common.IBFailed
 					s.Composes[composeID] = compose
 				}
@@ -155,6 +216,24 @@ func New(stateDir *string) *Store {
 	if s.BlueprintsCommits == nil {
 		s.BlueprintsCommits = make(map[string][]string)
 	}
+	if s.WorkspaceInfo == nil {
+		s.WorkspaceInfo = make(map[string]WorkspaceInfo)
+	}
+	if s.SourceStats == nil {
+		s.SourceStats = make(map[string]SourceStats)
+	}
+	if s.UploadStats == nil {
+		s.UploadStats = make(map[string]UploadStats)
+	}
+	if s.Webhooks == nil {
+		s.Webhooks = make(map[uuid.UUID]Webhook)
+	}
+	if s.Rebuilds == nil {
+		s.Rebuilds = make(map[string]Rebuild)
+	}
+
+	s.openBlueprintRepo()
+	s.loadGPGKeys()
 
 	// Populate BlueprintsCommits for existing blueprints without commit history
 	// BlueprintsCommits tracks the order of the commits in BlueprintsChanges,
@@ -221,9 +300,15 @@ func (s *Store) change(f func() error) error {
 
 	result := f()
 
+	// The package and job indexes are rebuilt on the next query, because
+	// most changes touch composes in some way
+	s.packageIndex = nil
+	s.jobIndex = nil
+
 	if s.stateDir != nil {
 		err := s.db.Write(StoreDBName, s)
 		if err != nil {
+			logging.Default().Error("cannot write state", "path", *s.stateDir, "error", err)
 			panic(err)
 		}
 	}
@@ -231,6 +316,78 @@ func (s *Store) change(f func() error) error {
 	return result
 }
 
+// Snapshot writes the whole state of the store to `w`, in the same format
+// it is persisted in. It is meant to be read back by Restore on another
+// instance of osbuild-composer.
+func (s *Store) Snapshot(w io.Writer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return json.NewEncoder(w).Encode(s)
+}
+
+// Restore replaces the state of the store with a snapshot that was written by
+// Snapshot. Image build artifacts are not part of the snapshot.
+func (s *Store) Restore(r io.Reader) error {
+	var snapshot Store
+	err := json.NewDecoder(r).Decode(&snapshot)
+	if err != nil {
+		return fmt.Errorf("cannot decode snapshot: %v", err)
+	}
+
+	return s.change(func() error {
+		s.Blueprints = snapshot.Blueprints
+		s.Workspace = snapshot.Workspace
+		s.Composes = snapshot.Composes
+		s.Sources = snapshot.Sources
+		s.BlueprintsChanges = snapshot.BlueprintsChanges
+		s.BlueprintsCommits = snapshot.BlueprintsCommits
+		s.WorkspaceInfo = snapshot.WorkspaceInfo
+		s.SourceStats = snapshot.SourceStats
+		s.UploadStats = snapshot.UploadStats
+		s.NightlyRuns = snapshot.NightlyRuns
+		s.Webhooks = snapshot.Webhooks
+		s.Rebuilds = snapshot.Rebuilds
+		s.GitSync = snapshot.GitSync
+
+		if s.Blueprints == nil {
+			s.Blueprints = make(map[string]blueprint.Blueprint)
+		}
+		if s.Workspace == nil {
+			s.Workspace = make(map[string]blueprint.Blueprint)
+		}
+		if s.Composes == nil {
+			s.Composes = make(map[uuid.UUID]compose.Compose)
+		}
+		if s.Sources == nil {
+			s.Sources = make(map[string]SourceConfig)
+		}
+		if s.BlueprintsChanges == nil {
+			s.BlueprintsChanges = make(map[string]map[string]blueprint.Change)
+		}
+		if s.BlueprintsCommits == nil {
+			s.BlueprintsCommits = make(map[string][]string)
+		}
+		if s.WorkspaceInfo == nil {
+			s.WorkspaceInfo = make(map[string]WorkspaceInfo)
+		}
+		if s.SourceStats == nil {
+			s.SourceStats = make(map[string]SourceStats)
+		}
+		if s.UploadStats == nil {
+			s.UploadStats = make(map[string]UploadStats)
+		}
+		if s.Webhooks == nil {
+			s.Webhooks = make(map[uuid.UUID]Webhook)
+		}
+		if s.Rebuilds == nil {
+			s.Rebuilds = make(map[string]Rebuild)
+		}
+
+		return nil
+	})
+}
+
 func (s *Store) ListBlueprints() []string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -304,26 +461,93 @@ func (s *Store) GetBlueprintChanges(name string) []blueprint.Change {
 
 func (s *Store) PushBlueprint(bp blueprint.Blueprint, commitMsg string) error {
 	return s.change(func() error {
-		commit, err := randomSHA1String()
-		if err != nil {
-			return err
+		_, err := s.pushBlueprint(bp, commitMsg)
+		return err
+	})
+}
+
+// RevertBlueprint restores blueprint `name` to its state at `commit`. The
+// revert is recorded as a new change, whose commit id is returned, so that
+// the history leading up to it is kept. The workspace copy of the blueprint
+// is discarded, just like when pushing a blueprint.
+func (s *Store) RevertBlueprint(name, commit string) (string, error) {
+	var newCommit string
+	err := s.change(func() error {
+		if _, ok := s.BlueprintsChanges[name]; !ok {
+			return &NotFoundError{"Unknown blueprint"}
 		}
+		change, ok := s.BlueprintsChanges[name][commit]
+		if !ok {
+			return &CommitNotFoundError{"Unknown commit"}
+		}
+
+		var err error
+		newCommit, err = s.pushBlueprint(change.Blueprint, name+".toml reverted to commit "+commit)
+		return err
+	})
+
+	return newCommit, err
+}
+
+// pushBlueprint commits `bp` and returns the id of the new commit. Must be
+// called with the store locked.
+func (s *Store) pushBlueprint(bp blueprint.Blueprint, commitMsg string) (string, error) {
+	return s.pushBlueprints([]blueprint.Blueprint{bp}, []string{commitMsg}, commitMsg)
+}
 
+// pushBlueprints commits all of `bps` at once, with `repoMsg` as the message
+// in the blueprint repository, and `commitMsgs` as the messages of each
+// blueprint's change. It returns the id of the new commit. Either all or
+// none of `bps` are committed. Must be called with the store locked.
+func (s *Store) pushBlueprints(bps []blueprint.Blueprint, commitMsgs []string, repoMsg string) (string, error) {
+	for i := range bps {
 		// Make sure the blueprint has default values and that the version is valid
-		err = bp.Initialize()
+		err := bps[i].Initialize()
 		if err != nil {
-			return err
+			return "", err
+		}
+		err = validateBlueprintName(bps[i].Name)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	now := time.Now()
+	olds := make(map[string]blueprint.Blueprint)
+	for i := range bps {
+		bp := &bps[i]
+		old, exists := s.Blueprints[bp.Name]
+		if exists {
+			olds[bp.Name] = old
+			if bp.Version == "" || bp.Version == old.Version {
+				bp.BumpVersion(old.Version)
+			}
 		}
+		s.Blueprints[bp.Name] = *bp
+	}
 
-		timestamp := time.Now().Format("2006-01-02T15:04:05Z")
+	commit, err := s.commitBlueprints(repoMsg, now)
+	if err != nil {
+		for _, bp := range bps {
+			if old, exists := olds[bp.Name]; exists {
+				s.Blueprints[bp.Name] = old
+			} else {
+				delete(s.Blueprints, bp.Name)
+			}
+		}
+		return "", err
+	}
+
+	for i, bp := range bps {
 		change := blueprint.Change{
 			Commit:    commit,
-			Message:   commitMsg,
-			Timestamp: timestamp,
+			Message:   commitMsgs[i],
+			Timestamp: now.Format("2006-01-02T15:04:05Z"),
 			Blueprint: bp,
 		}
 
 		delete(s.Workspace, bp.Name)
+		delete(s.WorkspaceInfo, bp.Name)
 		if s.BlueprintsChanges[bp.Name] == nil {
 			s.BlueprintsChanges[bp.Name] = make(map[string]blueprint.Change)
 		}
@@ -331,12 +555,54 @@ func (s *Store) PushBlueprint(bp blueprint.Blueprint, commitMsg string) error {
 		// Keep track of the order of the commits
 		s.BlueprintsCommits[bp.Name] = append(s.BlueprintsCommits[bp.Name], commit)
 
-		if old, ok := s.Blueprints[bp.Name]; ok {
-			if bp.Version == "" || bp.Version == old.Version {
-				bp.BumpVersion(old.Version)
+		// The change was committed, so failing to prune the history is only
+		// worth a warning. It is pruned again with the next change.
+		err = s.pruneBlueprintHistory(bp.Name)
+		if err != nil {
+			logging.Default().Warning("cannot prune blueprint history", "blueprint", bp.Name, "error", err)
+		}
+	}
+
+	return commit, nil
+}
+
+// Import stores `gpgKeys`, adds `sources`, and commits `blueprints`, each
+// with the message that `commitMsg` returns for it. Blueprints and sources
+// are imported in one change: either all of them or none are. Keys which
+// were stored before an error are kept, but nothing refers to them.
+func (s *Store) Import(sources []SourceConfig, blueprints []blueprint.Blueprint, gpgKeys map[string]string, commitMsg func(bp blueprint.Blueprint) string) error {
+	for name, key := range gpgKeys {
+		if !gpgKeyNameRegex.MatchString(name) {
+			return &InvalidRequestError{fmt.Sprintf("invalid gpg key name: %s", name)}
+		}
+		if !IsGPGKey(key) {
+			return &InvalidRequestError{fmt.Sprintf("gpg key %s is not an armored public key", name)}
+		}
+	}
+	for name, key := range gpgKeys {
+		err := s.AddGPGKey(name, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.change(func() error {
+		if len(blueprints) > 0 {
+			msgs := make([]string, len(blueprints))
+			names := make([]string, len(blueprints))
+			for i, bp := range blueprints {
+				msgs[i] = commitMsg(bp)
+				names[i] = bp.Name
 			}
+			_, err := s.pushBlueprints(blueprints, msgs, "Imported "+strings.Join(names, ", "))
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, source := range sources {
+			s.Sources[source.Name] = source
 		}
-		s.Blueprints[bp.Name] = bp
 		return nil
 	})
 }
@@ -348,8 +614,13 @@ func (s *Store) PushBlueprintToWorkspace(bp blueprint.Blueprint) error {
 		if err != nil {
 			return err
 		}
+		err = validateBlueprintName(bp.Name)
+		if err != nil {
+			return err
+		}
 
 		s.Workspace[bp.Name] = bp
+		s.WorkspaceInfo[bp.Name] = WorkspaceInfo{Updated: time.Now()}
 		return nil
 	})
 }
@@ -360,10 +631,19 @@ func (s *Store) PushBlueprintToWorkspace(bp blueprint.Blueprint) error {
 func (s *Store) DeleteBlueprint(name string) error {
 	return s.change(func() error {
 		delete(s.Workspace, name)
-		if _, ok := s.Blueprints[name]; !ok {
-			return fmt.Errorf("Unknown blueprint: %s", name)
+		delete(s.WorkspaceInfo, name)
+		old, ok := s.Blueprints[name]
+		if !ok {
+			return &NotFoundError{fmt.Sprintf("Unknown blueprint: %s", name)}
 		}
 		delete(s.Blueprints, name)
+
+		_, err := s.commitBlueprints(fmt.Sprintf("Recipe %s deleted", name), time.Now())
+		if err != nil {
+			s.Blueprints[name] = old
+			return err
+		}
+		delete(s.Rebuilds, name)
 		return nil
 	})
 }
@@ -373,20 +653,79 @@ func (s *Store) DeleteBlueprint(name string) error {
 func (s *Store) DeleteBlueprintFromWorkspace(name string) error {
 	return s.change(func() error {
 		if _, ok := s.Workspace[name]; !ok {
-			return fmt.Errorf("Unknown blueprint: %s", name)
+			return &NotFoundError{fmt.Sprintf("Unknown blueprint: %s", name)}
 		}
 		delete(s.Workspace, name)
+		delete(s.WorkspaceInfo, name)
 		return nil
 	})
 }
 
+// GetWorkspaceInfo returns information about all blueprints in the
+// workspace. Blueprints which were pushed to the workspace before their
+// changes were tracked have a zero `Updated` time until the next sweep.
+func (s *Store) GetWorkspaceInfo() map[string]WorkspaceInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	infos := make(map[string]WorkspaceInfo, len(s.Workspace))
+	for name := range s.Workspace {
+		infos[name] = s.WorkspaceInfo[name]
+	}
+
+	return infos
+}
+
+// SweepWorkspace removes the workspace copies of blueprints which haven't
+// been changed for `ttl`. It returns the names of the removed blueprints and
+// of those which will be removed within `warning`. The latter are returned
+// only once per workspace copy, so that callers can warn about them.
+func (s *Store) SweepWorkspace(ttl, warning time.Duration) (expiring, expired []string, err error) {
+	now := time.Now()
+
+	err = s.change(func() error {
+		for name := range s.WorkspaceInfo {
+			if _, exists := s.Workspace[name]; !exists {
+				delete(s.WorkspaceInfo, name)
+			}
+		}
+
+		for name := range s.Workspace {
+			info, exists := s.WorkspaceInfo[name]
+			if !exists || info.Updated.IsZero() {
+				// start the clock for copies from before changes were tracked
+				s.WorkspaceInfo[name] = WorkspaceInfo{Updated: now}
+				continue
+			}
+
+			age := now.Sub(info.Updated)
+			if age >= ttl {
+				delete(s.Workspace, name)
+				delete(s.WorkspaceInfo, name)
+				expired = append(expired, name)
+			} else if age >= ttl-warning && !info.Warned {
+				info.Warned = true
+				s.WorkspaceInfo[name] = info
+				expiring = append(expiring, name)
+			}
+		}
+
+		return nil
+	})
+
+	sort.Strings(expiring)
+	sort.Strings(expired)
+
+	return expiring, expired, err
+}
+
 // TagBlueprint will tag the most recent commit
 // It will return an error if the blueprint doesn't exist
 func (s *Store) TagBlueprint(name string) error {
 	return s.change(func() error {
 		_, ok := s.Blueprints[name]
 		if !ok {
-			return errors.New("Unknown blueprint")
+			return &NotFoundError{"Unknown blueprint"}
 		}
 
 		if len(s.BlueprintsCommits[name]) == 0 {
@@ -401,30 +740,45 @@ func (s *Store) TagBlueprint(name string) error {
 
 		// Get the latest revision for this blueprint
 		var revision int
-		var change blueprint.Change
 		for i := len(s.BlueprintsCommits[name]) - 1; i >= 0; i-- {
 			commit := s.BlueprintsCommits[name][i]
-			change = s.BlueprintsChanges[name][commit]
-			if change.Revision != nil && *change.Revision > revision {
-				revision = *change.Revision
+			previous := s.BlueprintsChanges[name][commit]
+			if previous.Revision != nil && *previous.Revision > revision {
+				revision = *previous.Revision
 				break
 			}
 		}
 
 		// Bump the revision (if there was none it will start at 1)
 		revision++
+
+		// Changes from before the blueprint repository existed
+		// can't be tagged in it
+		if s.blueprintRepo.HasObject(latest) {
+			err := s.blueprintRepo.Tag(fmt.Sprintf("%s/r%d", name, revision), latest)
+			if err != nil {
+				return err
+			}
+		}
+
+		change := s.BlueprintsChanges[name][latest]
 		change.Revision = &revision
 		s.BlueprintsChanges[name][latest] = change
 		return nil
 	})
 }
 
+// GetCompose returns a deep copy of the compose with `id`, so that later
+// changes to the store, which modify composes in place, don't change it.
 func (s *Store) GetCompose(id uuid.UUID) (compose.Compose, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	compose, exists := s.Composes[id]
-	return compose, exists
+	if !exists {
+		return compose, false
+	}
+	return compose.DeepCopy(), true
 }
 
 // GetAllComposes creates a deep copy of all composes present in this store
@@ -443,6 +797,23 @@ func (s *Store) GetAllComposes() map[uuid.UUID]compose.Compose {
 	return composes
 }
 
+// FilterComposes returns deep copies of the composes for which `keep`
+// returns true, like GetAllComposes() does for all of them. `keep` is called
+// with the store locked and must not change the compose or call the store.
+func (s *Store) FilterComposes(keep func(id uuid.UUID, c *compose.Compose) bool) map[uuid.UUID]compose.Compose {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	composes := make(map[uuid.UUID]compose.Compose)
+	for id, c := range s.Composes {
+		if keep(id, &c) {
+			composes[id] = c.DeepCopy()
+		}
+	}
+
+	return composes
+}
+
 func (s *Store) GetImageBuildResult(composeId uuid.UUID, imageBuildId int) (io.ReadCloser, error) {
 	if s.stateDir == nil {
 		return ioutil.NopCloser(bytes.NewBuffer([]byte("{}"))), nil
@@ -451,7 +822,10 @@ func (s *Store) GetImageBuildResult(composeId uuid.UUID, imageBuildId int) (io.R
 	return os.Open(s.getImageBuildDirectory(composeId, imageBuildId) + "/result.json")
 }
 
-func (s *Store) GetImageBuildImage(composeId uuid.UUID, imageBuildId int) (io.ReadCloser, int64, error) {
+// GetImageBuildImage opens the image of an image build and returns a reader
+// of it along with its size. The reader can seek, so that parts of the
+// image can be read.
+func (s *Store) GetImageBuildImage(composeId uuid.UUID, imageBuildId int) (ArtifactReader, int64, error) {
 	c, ok := s.Composes[composeId]
 
 	if !ok {
@@ -465,20 +839,367 @@ func (s *Store) GetImageBuildImage(composeId uuid.UUID, imageBuildId int) (io.Re
 
 	path := fmt.Sprintf("%s/%s", s.getImageBuildDirectory(composeId, imageBuildId), localTargetOptions.Filename)
 
-	f, err := os.Open(path)
+	return s.openSeekableArtifact(path)
+}
+
+// imageBuildImagePath returns the path of the image of an image build.
+func (s *Store) imageBuildImagePath(composeID uuid.UUID, imageBuildID int) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
+	c, exists := s.Composes[composeID]
+	if !exists {
+		return "", &NotFoundError{"compose does not exist"}
+	}
+	if imageBuildID < 0 || imageBuildID >= len(c.ImageBuilds) {
+		return "", &NotFoundError{"image build does not exist"}
+	}
+
+	localTargetOptions := c.ImageBuilds[imageBuildID].GetLocalTargetOptions()
+	if localTargetOptions == nil {
+		return "", &NoLocalTargetError{"compose does not have local target"}
+	}
+
+	return fmt.Sprintf("%s/%s", s.getImageBuildDirectory(composeID, imageBuildID), localTargetOptions.Filename), nil
+}
+
+// SetImageBuildSignature stores the checksum and the detached signature of
+// the image of an image build next to the image. The checksum is that of the
+// decoded image, as it is downloaded.
+func (s *Store) SetImageBuildSignature(composeID uuid.UUID, imageBuildID int, checksum string, signature []byte) error {
+	path, err := s.imageBuildImagePath(composeID, imageBuildID)
 	if err != nil {
-		return nil, 0, err
+		return err
 	}
 
-	fileInfo, err := f.Stat()
+	err = ioutil.WriteFile(path+".sha256", []byte(checksum+"\n"), 0644)
+	if err != nil {
+		return err
+	}
 
+	return ioutil.WriteFile(path+".sig", signature, 0644)
+}
+
+// GetImageBuildChecksum returns the checksum of the image of an image build
+// that SetImageBuildSignature stored.
+func (s *Store) GetImageBuildChecksum(composeID uuid.UUID, imageBuildID int) (string, error) {
+	path, err := s.imageBuildImagePath(composeID, imageBuildID)
 	if err != nil {
-		return nil, 0, err
+		return "", err
 	}
 
-	return f, fileInfo.Size(), err
+	data, err := ioutil.ReadFile(path + ".sha256")
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// GetImageBuildSignature returns the signature of the image of an image
+// build that SetImageBuildSignature stored.
+func (s *Store) GetImageBuildSignature(composeID uuid.UUID, imageBuildID int) ([]byte, error) {
+	path, err := s.imageBuildImagePath(composeID, imageBuildID)
+	if err != nil {
+		return nil, err
+	}
 
+	return ioutil.ReadFile(path + ".sig")
+}
+
+// GetJobImage opens the image of the image build that job `jobID` built, for
+// installers which embed it.
+func (s *Store) GetJobImage(jobID uuid.UUID) (io.ReadCloser, int64, error) {
+	s.mu.RLock()
+	var composeID uuid.UUID
+	imageBuildID := -1
+	for id, c := range s.Composes {
+		for i := range c.ImageBuilds {
+			if c.ImageBuilds[i].JobId == jobID {
+				composeID = id
+				imageBuildID = i
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	if imageBuildID < 0 {
+		return nil, 0, &NotFoundError{"no image build was built by job " + jobID.String()}
+	}
+
+	return s.GetImageBuildImage(composeID, imageBuildID)
+}
+
+// PublishCompose marks a compose as published in the image gallery and
+// records the size and checksum of its image. The caller must make sure that
+// the compose has finished successfully.
+func (s *Store) PublishCompose(id uuid.UUID) error {
+	if s.stateDir == nil {
+		return &NoLocalTargetError{"images are not stored"}
+	}
+
+	reader, size, err := s.GetImageBuildImage(id, 0)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, reader)
+	if err != nil {
+		return fmt.Errorf("cannot compute checksum of image: %v", err)
+	}
+
+	return s.change(func() error {
+		c, exists := s.Composes[id]
+		if !exists {
+			return &NotFoundError{"compose does not exist"}
+		}
+
+		c.Publication = &compose.Publication{
+			PublishedAt: time.Now(),
+			Size:        size,
+			Checksum:    "sha256:" + hex.EncodeToString(h.Sum(nil)),
+		}
+		s.Composes[id] = c
+
+		return nil
+	})
+}
+
+// UnpublishCompose removes a compose from the image gallery. Unpublishing a
+// compose that isn't published is not an error.
+func (s *Store) UnpublishCompose(id uuid.UUID) error {
+	return s.change(func() error {
+		c, exists := s.Composes[id]
+		if !exists {
+			return &NotFoundError{"compose does not exist"}
+		}
+
+		c.Publication = nil
+		s.Composes[id] = c
+
+		return nil
+	})
+}
+
+// SetInventoryExport records the state of exporting a compose to an
+// external inventory system.
+func (s *Store) SetInventoryExport(id uuid.UUID, export compose.InventoryExport) error {
+	return s.change(func() error {
+		c, exists := s.Composes[id]
+		if !exists {
+			return &NotFoundError{"compose does not exist"}
+		}
+
+		c.InventoryExport = &export
+		s.Composes[id] = c
+
+		return nil
+	})
+}
+
+// SetComposeNotified records that notifications about the completion of a
+// compose were sent.
+func (s *Store) SetComposeNotified(id uuid.UUID) error {
+	return s.change(func() error {
+		c, exists := s.Composes[id]
+		if !exists {
+			return &NotFoundError{"compose does not exist"}
+		}
+
+		c.Notified = true
+		s.Composes[id] = c
+
+		return nil
+	})
+}
+
+// SetImageBuildScanJob records the job which scans the image of an image
+// build for vulnerabilities.
+func (s *Store) SetImageBuildScanJob(composeID uuid.UUID, imageBuildID int, jobId uuid.UUID) error {
+	return s.change(func() error {
+		c, exists := s.Composes[composeID]
+		if !exists {
+			return &NotFoundError{"compose does not exist"}
+		}
+		if imageBuildID < 0 || imageBuildID >= len(c.ImageBuilds) {
+			return &NotFoundError{"image build does not exist"}
+		}
+
+		c.ImageBuilds[imageBuildID].ScanJobId = jobId
+		s.Composes[composeID] = c
+
+		return nil
+	})
+}
+
+// SetImageBuildSignJob records the job which signs the image of an image
+// build.
+func (s *Store) SetImageBuildSignJob(composeID uuid.UUID, imageBuildID int, jobId uuid.UUID) error {
+	return s.change(func() error {
+		c, exists := s.Composes[composeID]
+		if !exists {
+			return &NotFoundError{"compose does not exist"}
+		}
+		if imageBuildID < 0 || imageBuildID >= len(c.ImageBuilds) {
+			return &NotFoundError{"image build does not exist"}
+		}
+
+		c.ImageBuilds[imageBuildID].SignJobId = jobId
+		s.Composes[composeID] = c
+
+		return nil
+	})
+}
+
+// SetImageBuildPackages records the packages that are installed into the
+// image of an image build and into its build root.
+func (s *Store) SetImageBuildPackages(composeID uuid.UUID, imageBuildID int, packages, buildPackages []rpmmd.PackageSpec) error {
+	return s.change(func() error {
+		c, exists := s.Composes[composeID]
+		if !exists {
+			return &NotFoundError{"compose does not exist"}
+		}
+		if imageBuildID < 0 || imageBuildID >= len(c.ImageBuilds) {
+			return &NotFoundError{"image build does not exist"}
+		}
+
+		c.ImageBuilds[imageBuildID].Packages = packages
+		c.ImageBuilds[imageBuildID].BuildPackages = buildPackages
+		s.Composes[composeID] = c
+
+		return nil
+	})
+}
+
+// SetImageBuildUploader records which worker uploaded the image of an image
+// build.
+func (s *Store) SetImageBuildUploader(composeID uuid.UUID, imageBuildID int, uploader compose.WorkerIdentity) error {
+	return s.change(func() error {
+		c, exists := s.Composes[composeID]
+		if !exists {
+			return &NotFoundError{"compose does not exist"}
+		}
+		if imageBuildID < 0 || imageBuildID >= len(c.ImageBuilds) {
+			return &NotFoundError{"image build does not exist"}
+		}
+
+		c.ImageBuilds[imageBuildID].UploadedBy = &uploader
+		s.Composes[composeID] = c
+
+		return nil
+	})
+}
+
+// SetJobFinisher records which worker finished the job of the image build
+// whose job is `jobID`. Jobs which don't build an image build, like uploads
+// of conversions, are ignored.
+func (s *Store) SetJobFinisher(jobID uuid.UUID, finisher compose.WorkerIdentity) error {
+	return s.change(func() error {
+		for id, c := range s.Composes {
+			for i := range c.ImageBuilds {
+				if c.ImageBuilds[i].JobId == jobID {
+					c.ImageBuilds[i].FinishedBy = &finisher
+					s.Composes[id] = c
+					return nil
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// SetComposeOwner records the name of the client that requested a compose.
+func (s *Store) SetComposeOwner(composeID uuid.UUID, owner string) error {
+	return s.change(func() error {
+		c, exists := s.Composes[composeID]
+		if !exists {
+			return &NotFoundError{"compose does not exist"}
+		}
+
+		c.Owner = owner
+		s.Composes[composeID] = c
+
+		return nil
+	})
+}
+
+// SetComposeRebuildOf records that a compose rebuilds the compose `of`.
+func (s *Store) SetComposeRebuildOf(composeID, of uuid.UUID) error {
+	return s.change(func() error {
+		c, exists := s.Composes[composeID]
+		if !exists {
+			return &NotFoundError{"compose does not exist"}
+		}
+
+		c.RebuildOf = of
+		s.Composes[composeID] = c
+
+		return nil
+	})
+}
+
+// SetComposeRepositories records the repositories that the packages of a
+// compose were resolved from.
+func (s *Store) SetComposeRepositories(composeID uuid.UUID, repos []compose.Repository) error {
+	return s.change(func() error {
+		c, exists := s.Composes[composeID]
+		if !exists {
+			return &NotFoundError{"compose does not exist"}
+		}
+
+		c.Repositories = repos
+		s.Composes[composeID] = c
+
+		return nil
+	})
+}
+
+// AddPromotion records that the image of a compose is being promoted.
+func (s *Store) AddPromotion(composeID uuid.UUID, promotion compose.Promotion) error {
+	return s.change(func() error {
+		c, exists := s.Composes[composeID]
+		if !exists {
+			return &NotFoundError{"compose does not exist"}
+		}
+
+		c.Promotions = append(c.Promotions, promotion)
+		s.Composes[composeID] = c
+
+		return nil
+	})
+}
+
+// FinishPromotion records the result of the promotion with upload job
+// `jobId`. A nil `promotionErr` means that the promotion succeeded.
+func (s *Store) FinishPromotion(composeID, jobId uuid.UUID, promotionErr error) error {
+	return s.change(func() error {
+		c, exists := s.Composes[composeID]
+		if !exists {
+			return &NotFoundError{"compose does not exist"}
+		}
+
+		for i := range c.Promotions {
+			p := &c.Promotions[i]
+			if p.JobId != jobId {
+				continue
+			}
+
+			p.FinishedAt = time.Now()
+			if promotionErr == nil {
+				p.Status = common.IBFinished
+			} else {
+				p.Status = common.IBFailed
+				p.Error = promotionErr.Error()
+			}
+			s.Composes[composeID] = c
+
+			return nil
+		}
+
+		return &NotFoundError{"promotion does not exist"}
+	})
 }
 
 func (s *Store) getComposeDirectory(composeID uuid.UUID) string {
@@ -489,7 +1210,17 @@ func (s *Store) getImageBuildDirectory(composeID uuid.UUID, imageBuildID int) st
 	return fmt.Sprintf("%s/%d", s.getComposeDirectory(composeID), imageBuildID)
 }
 
-func (s *Store) PushCompose(composeID uuid.UUID, manifest *osbuild.Manifest, imageType distro.ImageType, bp *blueprint.Blueprint, size uint64, targets []*target.Target, jobId uuid.UUID) error {
+// PushCompose records a new compose, whose first image build is built by
+// the job `jobId`. It fails with the error of the admission check (see
+// SetAdmissionCheck) if the compose isn't admitted.
+func (s *Store) PushCompose(composeID uuid.UUID, manifest *osbuild.Manifest, imageType distro.ImageType, bp *blueprint.Blueprint, size uint64, targets []*target.Target, warnings []compose.Warning, jobId uuid.UUID) error {
+	return s.PushComposeFor("", size, composeID, manifest, imageType, bp, size, targets, warnings, jobId)
+}
+
+// PushComposeFor is like PushCompose, but records `owner` as the client that
+// requested the compose, and admits it for outputs of `totalSize` bytes, the
+// size of all image builds it will have.
+func (s *Store) PushComposeFor(owner string, totalSize uint64, composeID uuid.UUID, manifest *osbuild.Manifest, imageType distro.ImageType, bp *blueprint.Blueprint, size uint64, targets []*target.Target, warnings []compose.Warning, jobId uuid.UUID) error {
 	if _, exists := s.GetCompose(composeID); exists {
 		panic("a compose with this id already exists")
 	}
@@ -513,8 +1244,12 @@ func (s *Store) PushCompose(composeID uuid.UUID, manifest *osbuild.Manifest, ima
 		}
 	}
 
-	// FIXME: handle or comment this possible error
-	_ = s.change(func() error {
+	err := s.change(func() error {
+		err := s.admit(owner, totalSize)
+		if err != nil {
+			return err
+		}
+
 		s.Composes[composeID] = compose.Compose{
 			Blueprint: bp,
 			ImageBuilds: []compose.ImageBuild{
@@ -527,16 +1262,99 @@ func (s *Store) PushCompose(composeID uuid.UUID, manifest *osbuild.Manifest, ima
 					JobId:      jobId,
 				},
 			},
+			Warnings: warnings,
+			Tenant:   s.tenant,
+			Owner:    owner,
 		}
 		return nil
 	})
-	return nil
+	if err != nil && s.stateDir != nil {
+		_ = os.RemoveAll(s.getComposeDirectory(composeID))
+	}
+	return err
+}
+
+// AddImageBuild adds another image build to an existing compose, for
+// composes of several image types. It returns the id of the new image build.
+func (s *Store) AddImageBuild(composeID uuid.UUID, manifest *osbuild.Manifest, imageType distro.ImageType, size uint64, targets []*target.Target, jobId uuid.UUID) (int, error) {
+	return s.addImageBuild(composeID, manifest, imageType, size, targets, func(ib *compose.ImageBuild) {
+		ib.JobId = jobId
+	})
+}
+
+// AddTestImageBuild is like AddImageBuild, but it immediately finishes the
+// new image build like PushTestCompose does.
+func (s *Store) AddTestImageBuild(composeID uuid.UUID, manifest *osbuild.Manifest, imageType distro.ImageType, size uint64, targets []*target.Target, testSuccess bool) (int, error) {
+	imageBuildID, err := s.addImageBuild(composeID, manifest, imageType, size, targets, func(ib *compose.ImageBuild) {
+		ib.QueueStatus = common.IBRunning
+		ib.JobStarted = ib.JobCreated
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	status := common.IBFailed
+	result := common.ComposeResult{}
+	if testSuccess {
+		status = common.IBFinished
+		result.Success = true
+	}
+
+	return imageBuildID, s.UpdateImageBuildInCompose(composeID, imageBuildID, status, &result)
+}
+
+// addImageBuild appends a new image build to a compose. `init` can modify the
+// image build before it is stored.
+func (s *Store) addImageBuild(composeID uuid.UUID, manifest *osbuild.Manifest, imageType distro.ImageType, size uint64, targets []*target.Target, init func(*compose.ImageBuild)) (int, error) {
+	if targets == nil {
+		targets = []*target.Target{}
+	}
+
+	// Compatibility layer for image types in Weldr API v0
+	imageTypeCommon, exists := common.ImageTypeFromCompatString(imageType.Name())
+	if !exists {
+		panic("fatal error, compose type does not exist")
+	}
+
+	var imageBuildID int
+	err := s.change(func() error {
+		c, exists := s.Composes[composeID]
+		if !exists {
+			return &NotFoundError{"compose does not exist"}
+		}
+		imageBuildID = len(c.ImageBuilds)
+
+		if s.stateDir != nil {
+			outputDir := s.getImageBuildDirectory(composeID, imageBuildID)
+
+			err := os.MkdirAll(outputDir, 0755)
+			if err != nil {
+				return fmt.Errorf("cannot create output directory for job %v: %#v", composeID, err)
+			}
+		}
+
+		ib := compose.ImageBuild{
+			Id:         imageBuildID,
+			Manifest:   manifest,
+			ImageType:  imageTypeCommon,
+			Targets:    targets,
+			JobCreated: time.Now(),
+			Size:       size,
+		}
+		init(&ib)
+		c.ImageBuilds = append(c.ImageBuilds, ib)
+		s.Composes[composeID] = c
+
+		return nil
+	})
+
+	return imageBuildID, err
 }
 
 // PushTestCompose is used for testing
 // Set testSuccess to create a fake successful compose, otherwise it will create a failed compose
 // It does not actually run a compose job
-func (s *Store) PushTestCompose(composeID uuid.UUID, manifest *osbuild.Manifest, imageType distro.ImageType, bp *blueprint.Blueprint, size uint64, targets []*target.Target, testSuccess bool) error {
+func (s *Store) PushTestCompose(composeID uuid.UUID, manifest *osbuild.Manifest, imageType distro.ImageType, bp *blueprint.Blueprint, size uint64, targets []*target.Target, warnings []compose.Warning, testSuccess bool) error {
 	if targets == nil {
 		targets = []*target.Target{}
 	}
@@ -571,6 +1389,8 @@ func (s *Store) PushTestCompose(composeID uuid.UUID, manifest *osbuild.Manifest,
 					Size:        size,
 				},
 			},
+			Warnings: warnings,
+			Tenant:   s.tenant,
 		}
 		return nil
 	})
@@ -604,6 +1424,12 @@ func (s *Store) DeleteCompose(id uuid.UUID) error {
 
 		delete(s.Composes, id)
 
+		for hookID, hook := range s.Webhooks {
+			if hook.ComposeID == id {
+				delete(s.Webhooks, hookID)
+			}
+		}
+
 		var err error
 		if s.stateDir != nil {
 			err = os.RemoveAll(s.getComposeDirectory(id))
@@ -659,7 +1485,9 @@ func (s *Store) UpdateImageBuildInCompose(composeID uuid.UUID, imageBuildID int,
 	})
 }
 
-func (s *Store) AddImageToImageUpload(composeID uuid.UUID, imageBuildID int, reader io.Reader) error {
+// AddImageToImageUpload stores the image of an image build and records its
+// checksum. If `checksum` is not "", the image must match it.
+func (s *Store) AddImageToImageUpload(composeID uuid.UUID, imageBuildID int, reader io.Reader, checksum string) error {
 	currentCompose, exists := s.Composes[composeID]
 	if !exists {
 		return &NotFoundError{"compose does not exist"}
@@ -671,19 +1499,97 @@ func (s *Store) AddImageToImageUpload(composeID uuid.UUID, imageBuildID int, rea
 	}
 
 	path := fmt.Sprintf("%s/%s", s.getImageBuildDirectory(composeID, imageBuildID), localTargetOptions.Filename)
-	f, err := os.Create(path)
+	f, err := s.createArtifact(path)
 
 	if err != nil {
 		return err
 	}
 
-	_, err = io.Copy(f, reader)
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, hash), reader)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	actual := "sha256:" + hex.EncodeToString(hash.Sum(nil))
+	if err == nil && checksum != "" && checksum != actual {
+		err = fmt.Errorf("image has checksum %s, but %s was expected", actual, checksum)
+	}
 
 	if err != nil {
+		// don't keep incomplete images around
+		removeArtifact(path)
 		return err
 	}
 
-	return nil
+	return s.change(func() error {
+		c, exists := s.Composes[composeID]
+		if !exists {
+			return &NotFoundError{"compose does not exist"}
+		}
+
+		c.ImageBuilds[imageBuildID].Checksum = actual
+		s.Composes[composeID] = c
+
+		return nil
+	})
+}
+
+// GetImageBuildFilename returns the file name of the image of an image
+// build, or "" if it has no local target, which means that the image is not
+// stored by composer.
+func (s *Store) GetImageBuildFilename(composeID uuid.UUID, imageBuildID int) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	compose, exists := s.Composes[composeID]
+	if !exists {
+		return "", &NotFoundError{"compose does not exist"}
+	}
+	if imageBuildID < 0 || imageBuildID >= len(compose.ImageBuilds) {
+		return "", &NotFoundError{"image build does not exist"}
+	}
+
+	options := compose.ImageBuilds[imageBuildID].GetLocalTargetOptions()
+	if options == nil {
+		return "", nil
+	}
+
+	return options.Filename, nil
+}
+
+// GetImageBuildSize returns the size that was declared for the image of an
+// image build. Customizations like additional partitions can make the image
+// larger than the size that was requested for the compose, which is why the
+// size in its manifest takes precedence.
+func (s *Store) GetImageBuildSize(composeID uuid.UUID, imageBuildID int) (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	compose, exists := s.Composes[composeID]
+	if !exists {
+		return 0, &NotFoundError{"compose does not exist"}
+	}
+	if imageBuildID < 0 || imageBuildID >= len(compose.ImageBuilds) {
+		return 0, &NotFoundError{"image build does not exist"}
+	}
+
+	ib := compose.ImageBuilds[imageBuildID]
+	size := ib.Size
+	if ib.Manifest != nil && ib.Manifest.Pipeline.Assembler != nil {
+		switch options := ib.Manifest.Pipeline.Assembler.Options.(type) {
+		case *osbuild.QEMUAssemblerOptions:
+			if options.Size > size {
+				size = options.Size
+			}
+		case *osbuild.RawFSAssemblerOptions:
+			if options.Size > size {
+				size = options.Size
+			}
+		}
+	}
+
+	return size, nil
 }
 
 func (s *Store) PushSource(source SourceConfig) {
@@ -698,6 +1604,7 @@ func (s *Store) DeleteSource(name string) {
 	// FIXME: handle or comment this possible error
 	_ = s.change(func() error {
 		delete(s.Sources, name)
+		delete(s.SourceStats, name)
 		return nil
 	})
 }
@@ -744,11 +1651,20 @@ func NewSourceConfig(repo rpmmd.RepoConfig, system bool) SourceConfig {
 		CheckGPG: true,
 		CheckSSL: !repo.IgnoreSSL,
 		System:   system,
+
+		Proxy:         repo.Proxy,
+		SSLCACert:     repo.SSLCACert,
+		SSLClientCert: repo.SSLClientCert,
+		SSLClientKey:  repo.SSLClientKey,
+
+		Priority: repo.Priority,
+		Cost:     repo.Cost,
 	}
 
 	if repo.BaseURL != "" {
 		sc.URL = repo.BaseURL
 		sc.Type = "yum-baseurl"
+		sc.Mirrors = repo.Mirrors
 	} else if repo.Metalink != "" {
 		sc.URL = repo.Metalink
 		sc.Type = "yum-metalink"
@@ -765,9 +1681,16 @@ func (s *SourceConfig) RepoConfig() rpmmd.RepoConfig {
 
 	repo.Id = s.Name
 	repo.IgnoreSSL = !s.CheckSSL
+	repo.Proxy = s.Proxy
+	repo.SSLCACert = s.SSLCACert
+	repo.SSLClientCert = s.SSLClientCert
+	repo.SSLClientKey = s.SSLClientKey
+	repo.Priority = s.Priority
+	repo.Cost = s.Cost
 
 	if s.Type == "yum-baseurl" {
 		repo.BaseURL = s.URL
+		repo.Mirrors = s.Mirrors
 	} else if s.Type == "yum-metalink" {
 		repo.Metalink = s.URL
 	} else if s.Type == "yum-mirrorlist" {
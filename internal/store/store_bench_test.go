@@ -0,0 +1,152 @@
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/distro"
+	"github.com/osbuild/osbuild-composer/internal/distro/fedoratest"
+	"github.com/osbuild/osbuild-composer/internal/osbuild"
+	"github.com/osbuild/osbuild-composer/internal/target"
+)
+
+const benchmarkComposes = 10000
+
+func benchmarkImageType(b *testing.B) (distro.ImageType, *osbuild.Manifest) {
+	arch, err := fedoratest.New().GetArch("x86_64")
+	if err != nil {
+		b.Fatalf("error getting arch from distro: %v", err)
+	}
+	imageType, err := arch.GetImageType("qcow2")
+	if err != nil {
+		b.Fatalf("error getting image type from arch: %v", err)
+	}
+	manifest, err := imageType.Manifest(nil, nil, nil, nil, distro.ImageOptions{Size: imageType.Size(0)})
+	if err != nil {
+		b.Fatalf("error creating osbuild manifest: %v", err)
+	}
+	return imageType, manifest
+}
+
+func newBenchmarkStore(b *testing.B) (*Store, string) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-bench-")
+	if err != nil {
+		b.Fatal(err)
+	}
+	return New(&dir), dir
+}
+
+// Fills the store with `n` composes in `status` without writing the state for
+// each of them, and returns their ids.
+func populateStore(b *testing.B, s *Store, n int, status common.ImageBuildState) []uuid.UUID {
+	imageType, manifest := benchmarkImageType(b)
+	imageTypeCommon, _ := common.ImageTypeFromCompatString(imageType.Name())
+	bp := &blueprint.Blueprint{Name: "bench", Version: "0.0.1"}
+
+	var finished time.Time
+	if status == common.IBFinished || status == common.IBFailed {
+		finished = time.Now()
+	}
+
+	ids := make([]uuid.UUID, 0, n)
+	err := s.change(func() error {
+		for i := 0; i < n; i++ {
+			id := uuid.New()
+			s.Composes[id] = compose.Compose{
+				Blueprint: bp,
+				ImageBuilds: []compose.ImageBuild{
+					{
+						QueueStatus: status,
+						Manifest:    manifest,
+						ImageType:   imageTypeCommon,
+						Targets:     []*target.Target{},
+						JobCreated:  time.Now(),
+						JobStarted:  time.Now(),
+						JobFinished: finished,
+					},
+				},
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return ids
+}
+
+func benchmarkPushCompose(b *testing.B, existing int) {
+	s, dir := newBenchmarkStore(b)
+	defer os.RemoveAll(dir)
+
+	populateStore(b, s, existing, common.IBFinished)
+	imageType, manifest := benchmarkImageType(b)
+	bp := &blueprint.Blueprint{Name: "bench", Version: "0.0.1"}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := s.PushCompose(uuid.New(), manifest, imageType, bp, 0, nil, nil, uuid.New())
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPushCompose(b *testing.B) {
+	benchmarkPushCompose(b, 0)
+}
+
+func BenchmarkPushCompose10k(b *testing.B) {
+	benchmarkPushCompose(b, benchmarkComposes)
+}
+
+func BenchmarkUpdateImageBuildInCompose10k(b *testing.B) {
+	s, dir := newBenchmarkStore(b)
+	defer os.RemoveAll(dir)
+
+	ids := populateStore(b, s, benchmarkComposes, common.IBRunning)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := s.UpdateImageBuildInCompose(ids[i%len(ids)], 0, common.IBRunning, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMarshal10k(b *testing.B) {
+	s := New(nil)
+	populateStore(b, s, benchmarkComposes, common.IBFinished)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := json.Marshal(s)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoad10k(b *testing.B) {
+	s, dir := newBenchmarkStore(b)
+	defer os.RemoveAll(dir)
+
+	// loading marks running composes as failed, which would change the state
+	// after the first iteration
+	populateStore(b, s, benchmarkComposes, common.IBFinished)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		New(&dir)
+	}
+}
@@ -1,13 +1,19 @@
 package store
 
 import (
+	"bytes"
+	"errors"
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/suite"
 
 	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
 )
 
 //struct for sharing state between tests
@@ -86,6 +92,17 @@ func (suite *storeTest) TestPushBlueprint() {
 	suite.Equal("0.0.2", suite.myStore.Blueprints["testBP"].Version)
 }
 
+func (suite *storeTest) TestPushBlueprintInvalidName() {
+	for _, name := range []string{"a/b", "../a", "a b", ""} {
+		bp := suite.myBP
+		bp.Name = name
+		suite.IsType(&InvalidRequestError{}, suite.myStore.PushBlueprint(bp, "testing commit"), name)
+		suite.IsType(&InvalidRequestError{}, suite.myStore.PushBlueprintToWorkspace(bp), name)
+	}
+	suite.Empty(suite.myStore.Blueprints)
+	suite.Empty(suite.myStore.Workspace)
+}
+
 //List the blueprint
 func (suite *storeTest) TestListBlueprints() {
 	suite.myStore.Blueprints["testBP"] = suite.myBP
@@ -98,6 +115,33 @@ func (suite *storeTest) TestPushBlueprintToWorkspace() {
 	suite.Equal(suite.myBP, suite.myStore.Workspace["testBP"])
 }
 
+func (suite *storeTest) TestSweepWorkspace() {
+	suite.NoError(suite.myStore.PushBlueprintToWorkspace(suite.myBP))
+	suite.myStore.Workspace["legacy"] = suite.myBP
+	suite.myStore.Workspace["stale"] = suite.myBP
+	suite.myStore.WorkspaceInfo["stale"] = WorkspaceInfo{Updated: time.Now().Add(-72 * time.Hour)}
+	suite.myStore.Workspace["old"] = suite.myBP
+	suite.myStore.WorkspaceInfo["old"] = WorkspaceInfo{Updated: time.Now().Add(-36 * time.Hour)}
+
+	expiring, expired, err := suite.myStore.SweepWorkspace(48*time.Hour, 24*time.Hour)
+	suite.NoError(err)
+	suite.Equal([]string{"old"}, expiring)
+	suite.Equal([]string{"stale"}, expired)
+	suite.NotContains(suite.myStore.Workspace, "stale")
+	suite.False(suite.myStore.WorkspaceInfo["legacy"].Updated.IsZero())
+
+	// warnings are issued only once
+	expiring, expired, err = suite.myStore.SweepWorkspace(48*time.Hour, 24*time.Hour)
+	suite.NoError(err)
+	suite.Empty(expiring)
+	suite.Empty(expired)
+	suite.Len(suite.myStore.GetWorkspaceInfo(), 3)
+
+	// committing a blueprint removes its workspace copy
+	suite.NoError(suite.myStore.PushBlueprint(suite.myBP, "commit"))
+	suite.NotContains(suite.myStore.WorkspaceInfo, "testBP")
+}
+
 func (suite *storeTest) TestGetBlueprint() {
 	suite.myStore.Blueprints["testBP"] = suite.myBP
 	suite.myStore.Workspace["WIPtestBP"] = suite.myBP
@@ -192,6 +236,63 @@ func (suite *storeTest) TestDeleteBlueprintFromWorkspace() {
 	suite.EqualError(suite.myStore.DeleteBlueprintFromWorkspace("WIPtestBP"), "Unknown blueprint: WIPtestBP")
 }
 
+//Restore a snapshot into a fresh store
+func (suite *storeTest) TestSnapshotRestore() {
+	suite.NoError(suite.myStore.PushBlueprint(suite.myBP, "testing commit"))
+	suite.NoError(suite.myStore.PushBlueprintToWorkspace(suite.myBP))
+
+	var buf bytes.Buffer
+	suite.NoError(suite.myStore.Snapshot(&buf))
+
+	standby := New(nil)
+	suite.NoError(standby.Restore(&buf))
+	suite.Equal(suite.myStore.Blueprints, standby.Blueprints)
+	suite.Equal(suite.myStore.Workspace, standby.Workspace)
+	suite.Equal(suite.myStore.BlueprintsCommits, standby.BlueprintsCommits)
+	suite.NotNil(standby.Composes)
+
+	suite.Error(standby.Restore(bytes.NewBufferString("not json")))
+	suite.Equal(suite.myStore.Blueprints, standby.Blueprints)
+}
+
+// Composes returned by GetCompose don't change when the store does
+func (suite *storeTest) TestFinishPromotion() {
+	id := uuid.New()
+	jobID := uuid.New()
+	suite.myStore.Composes[id] = compose.Compose{
+		Blueprint:  &suite.myBP,
+		Promotions: []compose.Promotion{{Stage: "prod", JobId: jobID, Status: common.IBWaiting}},
+	}
+
+	before, exists := suite.myStore.GetCompose(id)
+	suite.True(exists)
+	suite.NoError(suite.myStore.FinishPromotion(id, jobID, errors.New("denied")))
+	suite.Equal(common.IBWaiting, before.Promotions[0].Status)
+
+	after, exists := suite.myStore.GetCompose(id)
+	suite.True(exists)
+	suite.Equal(common.IBFailed, after.Promotions[0].Status)
+	suite.Equal("denied", after.Promotions[0].Error)
+
+	suite.Error(suite.myStore.FinishPromotion(id, uuid.New(), nil))
+}
+
+func (suite *storeTest) TestFilterComposes() {
+	kept, dropped := uuid.New(), uuid.New()
+	suite.myStore.Composes[kept] = compose.Compose{Blueprint: &suite.myBP, Notified: true}
+	suite.myStore.Composes[dropped] = compose.Compose{Blueprint: &suite.myBP}
+
+	composes := suite.myStore.FilterComposes(func(id uuid.UUID, c *compose.Compose) bool {
+		return c.Notified
+	})
+	suite.Len(composes, 1)
+	suite.Contains(composes, kept)
+
+	// the composes are copies
+	composes[kept].Blueprint.Name = "changed"
+	suite.Equal(suite.myBP.Name, suite.myStore.Composes[kept].Blueprint.Name)
+}
+
 func TestStore(t *testing.T) {
 	suite.Run(t, new(storeTest))
 }
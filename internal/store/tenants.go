@@ -0,0 +1,212 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/compose"
+)
+
+var tenantNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// ValidTenantName returns true if `name` can name a tenant: up to 63 lower
+// case letters, digits, dashes, and underscores.
+func ValidTenantName(name string) bool {
+	return tenantNameRegex.MatchString(name)
+}
+
+// Tenants holds a separate store for each tenant, so that tenants only see
+// their own blueprints, sources, and composes. The default tenant, "", uses
+// the store composer was started with. The stores of other tenants are kept
+// in tenants/<name> below its state directory, including their outputs, and
+// are created when a tenant is first used.
+//
+// Workers don't know about tenants. Tenants finds the store of the compose
+// or job that a worker refers to, and otherwise passes calls on to it.
+type Tenants struct {
+	defaultStore *Store
+	setup        func(s *Store) error
+
+	mu             sync.Mutex
+	stores         map[string]*Store
+	admissionCheck AdmissionCheck // see SetAdmissionCheck
+}
+
+// NewTenants opens the stores of all tenants which exist below the state
+// directory of `defaultStore`. `setup` is called on each store of a tenant
+// when it is opened, to configure it like `defaultStore`.
+func NewTenants(defaultStore *Store, setup func(s *Store) error) (*Tenants, error) {
+	t := &Tenants{
+		defaultStore: defaultStore,
+		setup:        setup,
+		stores:       map[string]*Store{"": defaultStore},
+	}
+
+	if defaultStore.stateDir != nil {
+		dirs, err := ioutil.ReadDir(filepath.Join(*defaultStore.stateDir, "tenants"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("cannot read tenants: %v", err)
+		}
+		for _, dir := range dirs {
+			if !dir.IsDir() || !ValidTenantName(dir.Name()) {
+				continue
+			}
+			_, err = t.Get(dir.Name())
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return t, nil
+}
+
+// Get returns the store of `tenant`, creating it if it doesn't exist yet.
+func (t *Tenants) Get(tenant string) (*Store, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, exists := t.stores[tenant]
+	if exists {
+		return s, nil
+	}
+
+	if !ValidTenantName(tenant) {
+		return nil, &InvalidRequestError{fmt.Sprintf("invalid tenant name '%s'", tenant)}
+	}
+
+	var stateDir *string
+	if t.defaultStore.stateDir != nil {
+		dir := filepath.Join(*t.defaultStore.stateDir, "tenants", tenant)
+		err := os.MkdirAll(dir, 0700)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create state directory of tenant %s: %v", tenant, err)
+		}
+		stateDir = &dir
+	}
+
+	s = New(stateDir)
+	s.tenant = tenant
+	s.admissionCheck = t.admissionCheck
+	if t.setup != nil {
+		err := t.setup(s)
+		if err != nil {
+			return nil, fmt.Errorf("cannot set up store of tenant %s: %v", tenant, err)
+		}
+	}
+
+	t.stores[tenant] = s
+	return s, nil
+}
+
+// All returns the stores of all tenants, by tenant.
+func (t *Tenants) All() map[string]*Store {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stores := make(map[string]*Store, len(t.stores))
+	for tenant, s := range t.stores {
+		stores[tenant] = s
+	}
+	return stores
+}
+
+// Tenant returns the tenant the store belongs to, "" for the default tenant.
+func (s *Store) Tenant() string {
+	return s.tenant
+}
+
+// composeStore returns the store containing compose `id`, or the default
+// store if there is none.
+func (t *Tenants) composeStore(id uuid.UUID) *Store {
+	for _, s := range t.All() {
+		if _, exists := s.GetCompose(id); exists {
+			return s
+		}
+	}
+	return t.defaultStore
+}
+
+// jobStore returns the store containing the compose of which job `jobID`
+// builds an image, or the default store if there is none.
+func (t *Tenants) jobStore(jobID uuid.UUID) *Store {
+	for _, s := range t.All() {
+		s.mu.RLock()
+		for _, c := range s.Composes {
+			for _, ib := range c.ImageBuilds {
+				if ib.JobId == jobID {
+					s.mu.RUnlock()
+					return s
+				}
+			}
+		}
+		s.mu.RUnlock()
+	}
+	return t.defaultStore
+}
+
+// The following are like the store methods of the same name, but operate on
+// the store of the compose or job. They are used by the worker server.
+
+func (t *Tenants) AddImageToImageUpload(composeID uuid.UUID, imageBuildID int, reader io.Reader, checksum string) error {
+	return t.composeStore(composeID).AddImageToImageUpload(composeID, imageBuildID, reader, checksum)
+}
+
+func (t *Tenants) AddCheckpointToImageBuild(composeID uuid.UUID, imageBuildID int, name string, reader io.Reader) error {
+	return t.composeStore(composeID).AddCheckpointToImageBuild(composeID, imageBuildID, name, reader)
+}
+
+func (t *Tenants) GetImageBuildSize(composeID uuid.UUID, imageBuildID int) (uint64, error) {
+	return t.composeStore(composeID).GetImageBuildSize(composeID, imageBuildID)
+}
+
+func (t *Tenants) GetImageBuildFilename(composeID uuid.UUID, imageBuildID int) (string, error) {
+	return t.composeStore(composeID).GetImageBuildFilename(composeID, imageBuildID)
+}
+
+func (t *Tenants) GetImageBuildImage(composeID uuid.UUID, imageBuildID int) (ArtifactReader, int64, error) {
+	return t.composeStore(composeID).GetImageBuildImage(composeID, imageBuildID)
+}
+
+func (t *Tenants) GetConversionInput(composeID uuid.UUID) (io.ReadCloser, int64, error) {
+	return t.composeStore(composeID).GetConversionInput(composeID)
+}
+
+func (t *Tenants) SetImageBuildUploader(composeID uuid.UUID, imageBuildID int, uploader compose.WorkerIdentity) error {
+	return t.composeStore(composeID).SetImageBuildUploader(composeID, imageBuildID, uploader)
+}
+
+func (t *Tenants) GetJobImage(jobID uuid.UUID) (io.ReadCloser, int64, error) {
+	return t.jobStore(jobID).GetJobImage(jobID)
+}
+
+func (t *Tenants) SetJobFinisher(jobID uuid.UUID, finisher compose.WorkerIdentity) error {
+	return t.jobStore(jobID).SetJobFinisher(jobID, finisher)
+}
+
+func (t *Tenants) UploadFinished(jobID uuid.UUID, name, profile string, when time.Time, duration time.Duration, uploadErr string) error {
+	return t.jobStore(jobID).UploadFinished(name, profile, when, duration, uploadErr)
+}
+
+// These are used by the runners of jobs that composer runs itself, like
+// scans, and by the gallery.
+
+func (t *Tenants) GetCompose(id uuid.UUID) (compose.Compose, bool) {
+	return t.composeStore(id).GetCompose(id)
+}
+
+func (t *Tenants) SetImageBuildSignature(composeID uuid.UUID, imageBuildID int, checksum string, signature []byte) error {
+	return t.composeStore(composeID).SetImageBuildSignature(composeID, imageBuildID, checksum, signature)
+}
+
+func (t *Tenants) FinishPromotion(composeID, jobID uuid.UUID, promotionErr error) error {
+	return t.composeStore(composeID).FinishPromotion(composeID, jobID, promotionErr)
+}
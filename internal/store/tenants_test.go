@@ -0,0 +1,72 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/distro/fedoratest"
+)
+
+func TestTenants(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+
+	s := New(&dir)
+	tenants, err := NewTenants(s, nil)
+	require.NoError(t, err)
+
+	defaultStore, err := tenants.Get("")
+	require.NoError(t, err)
+	require.Equal(t, s, defaultStore)
+
+	for _, name := range []string{"Team A", "../x", "-a"} {
+		_, err := tenants.Get(name)
+		require.Error(t, err, name)
+	}
+
+	teamA, err := tenants.Get("team-a")
+	require.NoError(t, err)
+	require.Equal(t, "team-a", teamA.Tenant())
+	require.DirExists(t, filepath.Join(dir, "tenants", "team-a"))
+
+	composeID, jobID := uuid.New(), uuid.New()
+	err = teamA.PushCompose(composeID, nil, imageType, &blueprint.Blueprint{Name: "test"}, 0, nil, nil, jobID)
+	require.NoError(t, err)
+
+	c, exists := teamA.GetCompose(composeID)
+	require.True(t, exists)
+	require.Equal(t, "team-a", c.Tenant)
+	_, exists = s.GetCompose(composeID)
+	require.False(t, exists)
+
+	// calls of workers reach the store of the compose
+	worker := compose.WorkerIdentity{Subject: "worker-1"}
+	require.NoError(t, tenants.SetImageBuildUploader(composeID, 0, worker))
+	require.NoError(t, tenants.SetJobFinisher(jobID, worker))
+	c, _ = teamA.GetCompose(composeID)
+	require.Equal(t, &worker, c.ImageBuilds[0].UploadedBy)
+	require.Equal(t, &worker, c.ImageBuilds[0].FinishedBy)
+
+	// tenants are found again when composer restarts
+	tenants, err = NewTenants(New(&dir), nil)
+	require.NoError(t, err)
+	require.Len(t, tenants.All(), 2)
+	teamA, err = tenants.Get("team-a")
+	require.NoError(t, err)
+	c, exists = teamA.GetCompose(composeID)
+	require.True(t, exists)
+	require.Equal(t, "team-a", c.Tenant)
+}
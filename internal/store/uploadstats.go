@@ -0,0 +1,81 @@
+package store
+
+import (
+	"time"
+)
+
+// UploadStats records how uploads to a target went, so that administrators
+// notice failing credentials before they are needed. Stats are kept per
+// target name and profile (see target.Target.Profile()).
+type UploadStats struct {
+	Target  string `json:"target"`
+	Profile string `json:"profile,omitempty"`
+
+	Uploads  int `json:"uploads"`
+	Failures int `json:"failures"`
+
+	// The sum of the durations of all uploads, failed ones included
+	TotalDuration time.Duration `json:"total_duration"`
+
+	LastSucceeded time.Time `json:"last_succeeded"`
+	LastFailed    time.Time `json:"last_failed"`
+	LastError     string    `json:"last_error,omitempty"`
+}
+
+// SuccessRate returns the fraction of uploads that succeeded, or 0 if
+// there were none.
+func (st UploadStats) SuccessRate() float64 {
+	if st.Uploads == 0 {
+		return 0
+	}
+	return float64(st.Uploads-st.Failures) / float64(st.Uploads)
+}
+
+// AverageDuration returns the average duration of an upload, or 0 if there
+// were none.
+func (st UploadStats) AverageDuration() time.Duration {
+	if st.Uploads == 0 {
+		return 0
+	}
+	return st.TotalDuration / time.Duration(st.Uploads)
+}
+
+// GetUploadStats returns the stats of all targets that were uploaded to at
+// least once.
+func (s *Store) GetUploadStats() []UploadStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := make([]UploadStats, 0, len(s.UploadStats))
+	for _, st := range s.UploadStats {
+		stats = append(stats, st)
+	}
+
+	return stats
+}
+
+// UploadFinished records an upload to target `name` with `profile` that
+// ended at `when` after `duration`. `uploadErr` is the reason it failed, or
+// empty if it succeeded.
+func (s *Store) UploadFinished(name, profile string, when time.Time, duration time.Duration, uploadErr string) error {
+	return s.change(func() error {
+		key := name + ":" + profile
+		st, exists := s.UploadStats[key]
+		if !exists {
+			st = UploadStats{Target: name, Profile: profile}
+		}
+
+		st.Uploads += 1
+		st.TotalDuration += duration
+		if uploadErr == "" {
+			st.LastSucceeded = when
+		} else {
+			st.Failures += 1
+			st.LastFailed = when
+			st.LastError = uploadErr
+		}
+
+		s.UploadStats[key] = st
+		return nil
+	})
+}
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadStats(t *testing.T) {
+	s := New(nil)
+	require.Empty(t, s.GetUploadStats())
+
+	now := time.Now()
+	require.NoError(t, s.UploadFinished("org.osbuild.aws", "AKIA@us-east-1/images", now, 3*time.Minute, ""))
+	require.NoError(t, s.UploadFinished("org.osbuild.aws", "AKIA@us-east-1/images", now.Add(time.Hour), time.Minute, "AccessDenied"))
+	require.NoError(t, s.UploadFinished("org.osbuild.azure", "account/images", now, time.Minute, ""))
+
+	stats := s.GetUploadStats()
+	require.Len(t, stats, 2)
+
+	var aws UploadStats
+	for _, st := range stats {
+		if st.Target == "org.osbuild.aws" {
+			aws = st
+		}
+	}
+	require.Equal(t, UploadStats{
+		Target:        "org.osbuild.aws",
+		Profile:       "AKIA@us-east-1/images",
+		Uploads:       2,
+		Failures:      1,
+		TotalDuration: 4 * time.Minute,
+		LastSucceeded: now,
+		LastFailed:    now.Add(time.Hour),
+		LastError:     "AccessDenied",
+	}, aws)
+	require.Equal(t, 0.5, aws.SuccessRate())
+	require.Equal(t, 2*time.Minute, aws.AverageDuration())
+
+	require.Equal(t, 0.0, UploadStats{}.SuccessRate())
+	require.Equal(t, time.Duration(0), UploadStats{}.AverageDuration())
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A Webhook is a URL to which callbacks about composes are posted, see
+// notify.WebhookDispatcher.
+type Webhook struct {
+	ID  uuid.UUID `json:"id"`
+	URL string    `json:"url"`
+
+	// The key with which callbacks are signed, if any
+	Secret string `json:"secret,omitempty"`
+
+	// The events the webhook is called for, all if empty
+	Events []string `json:"events,omitempty"`
+
+	// The compose the webhook is called for, all if uuid.Nil
+	ComposeID uuid.UUID `json:"compose_id,omitempty"`
+
+	// Only events after this are announced to the webhook
+	Created time.Time `json:"created"`
+}
+
+// Wants returns true if the webhook is called for `event`.
+func (hook *Webhook) Wants(event string) bool {
+	if len(hook.Events) == 0 {
+		return true
+	}
+	for _, e := range hook.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// AddWebhook registers `hook`. Webhooks for a single compose are removed
+// along with the compose.
+func (s *Store) AddWebhook(hook Webhook) error {
+	return s.change(func() error {
+		if hook.ComposeID != uuid.Nil {
+			if _, exists := s.Composes[hook.ComposeID]; !exists {
+				return &NotFoundError{"compose does not exist"}
+			}
+		}
+
+		s.Webhooks[hook.ID] = hook
+		return nil
+	})
+}
+
+// GetWebhooks returns all webhooks, oldest first.
+func (s *Store) GetWebhooks() []Webhook {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hooks := make([]Webhook, 0, len(s.Webhooks))
+	for _, hook := range s.Webhooks {
+		hook.Events = append([]string{}, hook.Events...)
+		hooks = append(hooks, hook)
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		return hooks[i].Created.Before(hooks[j].Created)
+	})
+
+	return hooks
+}
+
+func (s *Store) DeleteWebhook(id uuid.UUID) error {
+	return s.change(func() error {
+		if _, exists := s.Webhooks[id]; !exists {
+			return &NotFoundError{"webhook does not exist"}
+		}
+
+		delete(s.Webhooks, id)
+		return nil
+	})
+}
+
+// SetWebhookDelivered records that the callback `delivery` about a compose
+// was delivered (or given up on), so that it isn't sent again.
+func (s *Store) SetWebhookDelivered(composeID uuid.UUID, delivery string) error {
+	return s.change(func() error {
+		c, exists := s.Composes[composeID]
+		if !exists {
+			return &NotFoundError{"compose does not exist"}
+		}
+
+		c.WebhooksDelivered = append(c.WebhooksDelivered, delivery)
+		s.Composes[composeID] = c
+
+		return nil
+	})
+}
@@ -6,6 +6,10 @@ type LocalTargetOptions struct {
 	ComposeId    uuid.UUID `json:"compose_id"`
 	ImageBuildId int       `json:"image_build_id"`
 	Filename     string    `json:"filename"`
+
+	// Checkpoints of the build to export in addition to the image, see
+	// osbuild.IsCheckpoint()
+	Checkpoints []string `json:"checkpoints,omitempty"`
 }
 
 func (LocalTargetOptions) isTargetOptions() {}
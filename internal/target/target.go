@@ -15,6 +15,10 @@ type Target struct {
 	Created   time.Time              `json:"created"`
 	Status    common.ImageBuildState `json:"status"`
 	Options   TargetOptions          `json:"options"`
+
+	// Where the target is located, for targets whose options don't say
+	// (see UploadRegion())
+	Region string `json:"region,omitempty"`
 }
 
 func newTarget(name string, options TargetOptions) *Target {
@@ -38,6 +42,7 @@ type rawTarget struct {
 	Created   time.Time              `json:"created"`
 	Status    common.ImageBuildState `json:"status"`
 	Options   json.RawMessage        `json:"options"`
+	Region    string                 `json:"region,omitempty"`
 }
 
 func (target *Target) UnmarshalJSON(data []byte) error {
@@ -57,6 +62,7 @@ func (target *Target) UnmarshalJSON(data []byte) error {
 	target.Created = rawTarget.Created
 	target.Status = rawTarget.Status
 	target.Options = options
+	target.Region = rawTarget.Region
 
 	return nil
 }
@@ -68,6 +74,8 @@ func UnmarshalTargetOptions(targetName string, rawOptions json.RawMessage) (Targ
 		options = new(AzureTargetOptions)
 	case "org.osbuild.aws":
 		options = new(AWSTargetOptions)
+	case "org.osbuild.vmware":
+		options = new(VMWareTargetOptions)
 	case "org.osbuild.local":
 		options = new(LocalTargetOptions)
 	default:
@@ -77,3 +85,30 @@ func UnmarshalTargetOptions(targetName string, rawOptions json.RawMessage) (Targ
 
 	return options, err
 }
+
+// Profile identifies the account and location that `target` uploads to, for
+// example "<access key id>@<region>/<bucket>" for AWS. Targets with the same
+// name and profile share credentials and destination. It never contains
+// secrets and is empty for local targets.
+func (target *Target) Profile() string {
+	switch options := target.Options.(type) {
+	case *AWSTargetOptions:
+		return options.AccessKeyID + "@" + options.Region + "/" + options.Bucket
+	case *AzureTargetOptions:
+		return options.StorageAccount + "/" + options.Container
+	case *VMWareTargetOptions:
+		return options.Username + "@" + options.Host + "/" + options.Datacenter + "/" + options.Datastore
+	default:
+		return ""
+	}
+}
+
+// UploadRegion returns the region `target` uploads to, so that jobs can be
+// routed to workers close to it. It is the region of AWS targets and the
+// Region label of all others, if set.
+func (target *Target) UploadRegion() string {
+	if options, ok := target.Options.(*AWSTargetOptions); ok && options.Region != "" {
+		return options.Region
+	}
+	return target.Region
+}
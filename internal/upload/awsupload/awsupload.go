@@ -11,12 +11,14 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/sts"
 )
 
 type AWS struct {
 	uploader *s3manager.Uploader
 	importer *ec2.EC2
 	s3       *s3.S3
+	sts      *sts.STS
 }
 
 func New(region, accessKeyID, accessKey string) (*AWS, error) {
@@ -36,9 +38,30 @@ func New(region, accessKeyID, accessKey string) (*AWS, error) {
 		uploader: s3manager.NewUploader(sess),
 		importer: ec2.New(sess),
 		s3:       s3.New(sess),
+		sts:      sts.New(sess),
 	}, nil
 }
 
+// Identity returns the ARN of the user or role the credentials belong to.
+// It fails if the credentials are invalid.
+func (a *AWS) Identity() (string, error) {
+	output, err := a.sts.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(output.Arn), nil
+}
+
+// CheckBucket returns an error if `bucket` doesn't exist in the session's
+// region or cannot be accessed with the session's credentials.
+func (a *AWS) CheckBucket(bucket string) error {
+	_, err := a.s3.HeadBucket(&s3.HeadBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	return err
+}
+
 func (a *AWS) Upload(filename, bucket, key string) (*s3manager.UploadOutput, error) {
 	file, err := os.Open(filename)
 	if err != nil {
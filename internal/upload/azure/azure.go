@@ -31,6 +31,22 @@ type ImageMetadata struct {
 	ImageName     string
 }
 
+// CheckContainer returns an error if the container called `container` doesn't
+// exist or cannot be accessed with `credentials`.
+func CheckContainer(credentials Credentials, container string) error {
+	credential, err := azblob.NewSharedKeyCredential(credentials.StorageAccount, credentials.StorageAccessKey)
+	if err != nil {
+		return fmt.Errorf("cannot create azure credentials: %v", err)
+	}
+
+	p := azblob.NewPipeline(credential, azblob.PipelineOptions{})
+	URL, _ := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net/%s", credentials.StorageAccount, container))
+	containerURL := azblob.NewContainerURL(*URL, p)
+
+	_, err = containerURL.GetProperties(context.Background(), azblob.LeaseAccessConditions{})
+	return err
+}
+
 // UploadImage takes the metadata and credentials required to upload the image specified by `fileName`
 // It can speed up the upload by using goroutines. The number of parallel goroutines is bounded by
 // the `threads` argument.
@@ -0,0 +1,69 @@
+// Package upload uploads images to the cloud targets supported by
+// osbuild-composer. The providers' clients are in the sub-packages.
+package upload
+
+import (
+	"fmt"
+
+	"github.com/osbuild/osbuild-composer/internal/target"
+	"github.com/osbuild/osbuild-composer/internal/upload/awsupload"
+	"github.com/osbuild/osbuild-composer/internal/upload/azure"
+	"github.com/osbuild/osbuild-composer/internal/upload/vmware"
+)
+
+// Upload uploads the image at `path` to target `t`. `defaultKey` is used as
+// the object key for AWS targets that don't specify one. Local targets are
+// not supported, because they are handled by the caller.
+func Upload(t *target.Target, path string, defaultKey string) error {
+	switch options := t.Options.(type) {
+	case *target.AWSTargetOptions:
+		a, err := awsupload.New(options.Region, options.AccessKeyID, options.SecretAccessKey)
+		if err != nil {
+			return err
+		}
+
+		key := options.Key
+		if key == "" {
+			key = defaultKey
+		}
+
+		_, err = a.Upload(path, options.Bucket, key)
+		if err != nil {
+			return err
+		}
+
+		/* TODO: communicate back the AMI */
+		_, err = a.Register(t.ImageName, options.Bucket, key)
+		return err
+
+	case *target.AzureTargetOptions:
+		credentials := azure.Credentials{
+			StorageAccount:   options.StorageAccount,
+			StorageAccessKey: options.StorageAccessKey,
+		}
+		metadata := azure.ImageMetadata{
+			ContainerName: options.Container,
+			ImageName:     t.ImageName,
+		}
+
+		const azureMaxUploadGoroutines = 4
+		return azure.UploadImage(credentials, metadata, path, azureMaxUploadGoroutines)
+
+	case *target.VMWareTargetOptions:
+		credentials := vmware.Credentials{
+			Host:     options.Host,
+			Username: options.Username,
+			Password: options.Password,
+		}
+		metadata := vmware.ImageMetadata{
+			Datacenter: options.Datacenter,
+			Datastore:  options.Datastore,
+			ImageName:  t.ImageName,
+		}
+
+		return vmware.UploadImage(credentials, metadata, path)
+
+	default:
+		return fmt.Errorf("invalid target type: %s", t.Name)
+	}
+}
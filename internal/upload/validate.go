@@ -0,0 +1,201 @@
+package upload
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+
+	"github.com/osbuild/osbuild-composer/internal/target"
+	"github.com/osbuild/osbuild-composer/internal/upload/awsupload"
+	"github.com/osbuild/osbuild-composer/internal/upload/azure"
+	"github.com/osbuild/osbuild-composer/internal/upload/vmware"
+)
+
+// A Diagnostic describes a problem with the settings of an upload target,
+// together with a hint on how to fix it.
+type Diagnostic struct {
+	// The setting that is most likely wrong, if known
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+// Validate checks whether images could be uploaded to target `t`, without
+// uploading anything. It returns the problems it found, which is none if the
+// credentials work. Missing settings are reported without contacting the
+// provider.
+func Validate(t *target.Target) []Diagnostic {
+	switch options := t.Options.(type) {
+	case *target.AWSTargetOptions:
+		return validateAWS(options)
+	case *target.AzureTargetOptions:
+		return validateAzure(options)
+	case *target.VMWareTargetOptions:
+		return validateVMWare(options)
+	default:
+		return []Diagnostic{{Message: fmt.Sprintf("invalid target type: %s", t.Name)}}
+	}
+}
+
+// missing returns a diagnostic for each setting in `fields` (setting name to
+// value) that is empty.
+func missing(fields [][2]string) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, f := range fields {
+		if f[1] == "" {
+			diagnostics = append(diagnostics, Diagnostic{
+				Field:   f[0],
+				Message: fmt.Sprintf("%s is not set", f[0]),
+			})
+		}
+	}
+	return diagnostics
+}
+
+func validateAWS(options *target.AWSTargetOptions) []Diagnostic {
+	diagnostics := missing([][2]string{
+		{"region", options.Region},
+		{"accessKeyID", options.AccessKeyID},
+		{"secretAccessKey", options.SecretAccessKey},
+		{"bucket", options.Bucket},
+	})
+	if len(diagnostics) > 0 {
+		return diagnostics
+	}
+
+	a, err := awsupload.New(options.Region, options.AccessKeyID, options.SecretAccessKey)
+	if err != nil {
+		return []Diagnostic{{Message: err.Error()}}
+	}
+
+	_, err = a.Identity()
+	if err != nil {
+		d := Diagnostic{Message: fmt.Sprintf("cannot verify credentials: %v", err)}
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case "InvalidClientTokenId":
+				d.Field = "accessKeyID"
+				d.Hint = "The access key ID does not exist. Check that it was copied completely and that the key was not deleted."
+			case "SignatureDoesNotMatch":
+				d.Field = "secretAccessKey"
+				d.Hint = "The secret access key does not belong to the access key ID. Check for leading or trailing whitespace."
+			case "ExpiredToken":
+				d.Hint = "The credentials have expired. Create a new access key."
+			case "RequestError":
+				d.Field = "region"
+				d.Hint = "AWS could not be reached. Check that the region exists and that this host can connect to AWS."
+			}
+		}
+		return []Diagnostic{d}
+	}
+
+	err = a.CheckBucket(options.Bucket)
+	if err != nil {
+		d := Diagnostic{
+			Field:   "bucket",
+			Message: fmt.Sprintf("cannot access bucket %s: %v", options.Bucket, err),
+		}
+		if rerr, ok := err.(awserr.RequestFailure); ok {
+			switch rerr.StatusCode() {
+			case http.StatusNotFound:
+				d.Hint = "The bucket does not exist. Create it or fix its name."
+			case http.StatusForbidden:
+				d.Hint = "The credentials are not allowed to access the bucket. Grant them s3:ListBucket and s3:PutObject on it."
+			case http.StatusMovedPermanently:
+				d.Field = "region"
+				d.Hint = fmt.Sprintf("The bucket is not in region %s. Set the region the bucket was created in.", options.Region)
+			}
+		}
+		return []Diagnostic{d}
+	}
+
+	return nil
+}
+
+func validateAzure(options *target.AzureTargetOptions) []Diagnostic {
+	diagnostics := missing([][2]string{
+		{"storageAccount", options.StorageAccount},
+		{"storageAccessKey", options.StorageAccessKey},
+		{"container", options.Container},
+	})
+	if len(diagnostics) > 0 {
+		return diagnostics
+	}
+
+	credentials := azure.Credentials{
+		StorageAccount:   options.StorageAccount,
+		StorageAccessKey: options.StorageAccessKey,
+	}
+
+	err := azure.CheckContainer(credentials, options.Container)
+	if err != nil {
+		d := Diagnostic{Message: fmt.Sprintf("cannot access container %s: %v", options.Container, err)}
+		if serr, ok := err.(azblob.StorageError); ok {
+			code := serr.ServiceCode()
+			switch {
+			case code == azblob.ServiceCodeType(azblob.StorageErrorCodeAuthenticationFailed) || serr.Response().StatusCode == http.StatusForbidden:
+				d.Field = "storageAccessKey"
+				d.Hint = "The access key does not belong to the storage account. Copy one of the account's access keys from the Azure portal."
+			case code == azblob.ServiceCodeType(azblob.StorageErrorCodeContainerNotFound) || serr.Response().StatusCode == http.StatusNotFound:
+				d.Field = "container"
+				d.Hint = "The container does not exist. Create it in the storage account or fix its name."
+			}
+		} else if strings.Contains(err.Error(), "cannot create azure credentials") {
+			d.Field = "storageAccessKey"
+			d.Hint = "The access key must be base64 encoded, exactly as shown in the Azure portal."
+		} else if strings.Contains(err.Error(), "no such host") {
+			d.Field = "storageAccount"
+			d.Hint = "The storage account does not exist. Check its name."
+		}
+		return []Diagnostic{d}
+	}
+
+	return nil
+}
+
+func validateVMWare(options *target.VMWareTargetOptions) []Diagnostic {
+	diagnostics := missing([][2]string{
+		{"host", options.Host},
+		{"username", options.Username},
+		{"password", options.Password},
+		{"datacenter", options.Datacenter},
+		{"datastore", options.Datastore},
+	})
+	if len(diagnostics) > 0 {
+		return diagnostics
+	}
+
+	credentials := vmware.Credentials{
+		Host:     options.Host,
+		Username: options.Username,
+		Password: options.Password,
+	}
+	metadata := vmware.ImageMetadata{
+		Datacenter: options.Datacenter,
+		Datastore:  options.Datastore,
+	}
+
+	err := vmware.CheckDatastore(credentials, metadata)
+	if err != nil {
+		d := Diagnostic{Message: err.Error()}
+		switch {
+		case strings.HasPrefix(err.Error(), "cannot parse vSphere host"):
+			d.Field = "host"
+			d.Hint = "The host must be a hostname or a URL like https://vcenter.example.com/sdk."
+		case strings.HasPrefix(err.Error(), "cannot connect to vSphere"):
+			d.Hint = "Check that the host is reachable from this machine and that username and password are correct."
+		case strings.HasPrefix(err.Error(), "cannot find datacenter"):
+			d.Field = "datacenter"
+			d.Hint = "The datacenter does not exist or the user cannot see it."
+		case strings.HasPrefix(err.Error(), "cannot find datastore"):
+			d.Field = "datastore"
+			d.Hint = "The datastore does not exist in the datacenter or the user cannot see it."
+		}
+		return []Diagnostic{d}
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package upload_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/target"
+	"github.com/osbuild/osbuild-composer/internal/upload"
+)
+
+func TestValidateMissingSettings(t *testing.T) {
+	var cases = []struct {
+		Target *target.Target
+		Fields []string
+	}{
+		{
+			target.NewAWSTarget(&target.AWSTargetOptions{Region: "eu-central-1"}),
+			[]string{"accessKeyID", "secretAccessKey", "bucket"},
+		},
+		{
+			target.NewAzureTarget(&target.AzureTargetOptions{StorageAccount: "account", Container: "images"}),
+			[]string{"storageAccessKey"},
+		},
+		{
+			target.NewVMWareTarget(&target.VMWareTargetOptions{Host: "vcenter.example.com"}),
+			[]string{"username", "password", "datacenter", "datastore"},
+		},
+	}
+
+	for _, c := range cases {
+		diagnostics := upload.Validate(c.Target)
+
+		var fields []string
+		for _, d := range diagnostics {
+			fields = append(fields, d.Field)
+		}
+		require.Equal(t, c.Fields, fields, c.Target.Name)
+	}
+}
+
+func TestValidateLocalTarget(t *testing.T) {
+	diagnostics := upload.Validate(target.NewLocalTarget(&target.LocalTargetOptions{}))
+	require.Len(t, diagnostics, 1)
+	require.Contains(t, diagnostics[0].Message, "invalid target type")
+}
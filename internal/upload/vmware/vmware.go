@@ -0,0 +1,103 @@
+package vmware
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/soap"
+)
+
+// Credentials contains the address of a vCenter (or ESXi) host and the user
+// to log in as.
+type Credentials struct {
+	Host     string
+	Username string
+	Password string
+}
+
+// ImageMetadata contains information needed to store the image in a proper
+// place. In case of vSphere this is the datacenter and the datastore in it.
+type ImageMetadata struct {
+	Datacenter string
+	Datastore  string
+	ImageName  string
+}
+
+// UploadImage copies the VMDK image specified by `fileName` to the root of
+// the datastore described by `metadata`.
+func UploadImage(credentials Credentials, metadata ImageMetadata, fileName string) error {
+	// The datastore browser only recognizes disks with a .vmdk extension
+	if !strings.HasSuffix(metadata.ImageName, ".vmdk") {
+		metadata.ImageName = metadata.ImageName + ".vmdk"
+	}
+
+	ctx := context.Background()
+
+	client, datastore, err := connect(ctx, credentials, metadata)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// ignore error, the session times out eventually anyway
+		_ = client.Logout(ctx)
+	}()
+
+	err = datastore.UploadFile(ctx, fileName, metadata.ImageName, &soap.DefaultUpload)
+	if err != nil {
+		return fmt.Errorf("cannot upload image to datastore %s: %v", metadata.Datastore, err)
+	}
+
+	return nil
+}
+
+// CheckDatastore returns an error if the datastore described by `metadata`
+// cannot be accessed with `credentials`.
+func CheckDatastore(credentials Credentials, metadata ImageMetadata) error {
+	ctx := context.Background()
+
+	client, _, err := connect(ctx, credentials, metadata)
+	if err != nil {
+		return err
+	}
+
+	// ignore error, the session times out eventually anyway
+	_ = client.Logout(ctx)
+	return nil
+}
+
+// connect logs into vSphere and looks up the datastore described by
+// `metadata`. The caller must log out of the returned client.
+func connect(ctx context.Context, credentials Credentials, metadata ImageMetadata) (*govmomi.Client, *object.Datastore, error) {
+	u, err := soap.ParseURL(credentials.Host)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot parse vSphere host: %v", err)
+	}
+	u.User = url.UserPassword(credentials.Username, credentials.Password)
+
+	client, err := govmomi.NewClient(ctx, u, false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot connect to vSphere: %v", err)
+	}
+
+	finder := find.NewFinder(client.Client, true)
+
+	datacenter, err := finder.Datacenter(ctx, metadata.Datacenter)
+	if err != nil {
+		_ = client.Logout(ctx)
+		return nil, nil, fmt.Errorf("cannot find datacenter %s: %v", metadata.Datacenter, err)
+	}
+	finder.SetDatacenter(datacenter)
+
+	datastore, err := finder.Datastore(ctx, metadata.Datastore)
+	if err != nil {
+		_ = client.Logout(ctx)
+		return nil, nil, fmt.Errorf("cannot find datastore %s: %v", metadata.Datastore, err)
+	}
+
+	return client, datastore, nil
+}
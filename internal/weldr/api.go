@@ -3,14 +3,18 @@ package weldr
 import (
 	"archive/tar"
 	"bytes"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	errors_package "errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -20,13 +24,22 @@ import (
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
 
+	"github.com/osbuild/osbuild-composer/internal/admission"
+	"github.com/osbuild/osbuild-composer/internal/audit"
+	"github.com/osbuild/osbuild-composer/internal/auth"
 	"github.com/osbuild/osbuild-composer/internal/blueprint"
 	"github.com/osbuild/osbuild-composer/internal/common"
 	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/config"
 	"github.com/osbuild/osbuild-composer/internal/distro"
+	"github.com/osbuild/osbuild-composer/internal/events"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/osbuild"
+	"github.com/osbuild/osbuild-composer/internal/quota"
 	"github.com/osbuild/osbuild-composer/internal/rpmmd"
 	"github.com/osbuild/osbuild-composer/internal/store"
 	"github.com/osbuild/osbuild-composer/internal/target"
+	"github.com/osbuild/osbuild-composer/internal/upload"
 	"github.com/osbuild/osbuild-composer/internal/worker"
 )
 
@@ -39,6 +52,41 @@ type API struct {
 	distro distro.Distro
 	repos  []rpmmd.RepoConfig
 
+	// Repositories of other architectures, see arch.go
+	archRepos map[string][]rpmmd.RepoConfig
+
+	metadataCache *rpmmd.Cache
+
+	admission       admission.Controller
+	authenticator   *auth.Authenticator
+	promotionStages map[string]PromotionStage
+	workspaceTTL    time.Duration
+	diskQuota       int64
+	quotas          *quota.Config
+	effectiveConfig *config.Effective
+	validateUpload  func(t *target.Target) []upload.Diagnostic
+
+	eventBroadcaster *events.Broadcaster
+	auditLog         *audit.Log
+
+	nightly          *NightlyConfig
+	testNightlyImage func(id uuid.UUID) error
+
+	rebuild             *RebuildConfig
+	rebuildReposChecked time.Time
+
+	// See gitsync.go
+	gitSync           *GitSyncConfig
+	gitSyncChecked    time.Time
+	gitSyncBlueprints []blueprint.Blueprint
+
+	// See drain.go
+	servers *httpServers
+
+	// See tenants.go
+	tenants    *store.Tenants
+	tenantAPIs *tenantAPIs
+
 	logger *log.Logger
 	router *httprouter.Router
 }
@@ -52,8 +100,18 @@ func New(rpmmd rpmmd.RPMMD, arch distro.Arch, distro distro.Distro, repos []rpmm
 		distro:  distro,
 		repos:   repos,
 		logger:  logger,
+
+		validateUpload: upload.Validate,
+		servers:        &httpServers{closing: make(chan struct{})},
 	}
+	api.testNightlyImage = api.runNightlyTest
+	api.setupRouter()
+
+	return api
+}
 
+// setupRouter routes all requests to the handlers of `api`.
+func (api *API) setupRouter() {
 	api.router = httprouter.New()
 	api.router.RedirectTrailingSlash = false
 	api.router.RedirectFixedPath = false
@@ -61,11 +119,20 @@ func New(rpmmd rpmmd.RPMMD, arch distro.Arch, distro distro.Distro, repos []rpmm
 	api.router.NotFound = http.HandlerFunc(notFoundHandler)
 
 	api.router.GET("/api/status", api.statusHandler)
+	api.router.GET("/metrics", api.metricsHandler)
+	api.router.GET("/api/v:version/config", api.configHandler)
+	api.router.GET("/api/v:version/quota", api.quotaHandler)
+	api.router.GET("/api/v:version/export", api.exportHandler)
+	api.router.POST("/api/v:version/import", api.importHandler)
 	api.router.GET("/api/v:version/projects/source/list", api.sourceListHandler)
 	api.router.GET("/api/v:version/projects/source/info/", api.sourceEmptyInfoHandler)
 	api.router.GET("/api/v:version/projects/source/info/:sources", api.sourceInfoHandler)
 	api.router.POST("/api/v:version/projects/source/new", api.sourceNewHandler)
 	api.router.DELETE("/api/v:version/projects/source/delete/*source", api.sourceDeleteHandler)
+	api.router.GET("/api/v:version/projects/source/gpgkeys/list", api.gpgKeyListHandler)
+	api.router.GET("/api/v:version/projects/source/gpgkeys/info/:name", api.gpgKeyInfoHandler)
+	api.router.POST("/api/v:version/projects/source/gpgkeys/new", api.gpgKeyNewHandler)
+	api.router.DELETE("/api/v:version/projects/source/gpgkeys/delete/:name", api.gpgKeyDeleteHandler)
 
 	api.router.GET("/api/v:version/projects/depsolve", api.projectsDepsolveHandler)
 	api.router.GET("/api/v:version/projects/depsolve/*projects", api.projectsDepsolveHandler)
@@ -74,6 +141,8 @@ func New(rpmmd rpmmd.RPMMD, arch distro.Arch, distro distro.Distro, repos []rpmm
 	api.router.GET("/api/v:version/modules/list/*modules", api.modulesListHandler)
 	api.router.GET("/api/v:version/projects/list", api.projectsListHandler)
 	api.router.GET("/api/v:version/projects/list/", api.projectsListHandler)
+	api.router.GET("/api/v:version/projects/search", api.projectsSearchHandler)
+	api.router.POST("/api/v:version/projects/cache/invalidate", api.projectsCacheInvalidateHandler)
 
 	// these are the same, except that modules/info also includes dependencies
 	api.router.GET("/api/v:version/modules/info", api.modulesInfoHandler)
@@ -84,27 +153,51 @@ func New(rpmmd rpmmd.RPMMD, arch distro.Arch, distro distro.Distro, repos []rpmm
 	api.router.GET("/api/v:version/blueprints/list", api.blueprintsListHandler)
 	api.router.GET("/api/v:version/blueprints/info/*blueprints", api.blueprintsInfoHandler)
 	api.router.GET("/api/v:version/blueprints/depsolve/*blueprints", api.blueprintsDepsolveHandler)
+	api.router.GET("/api/v:version/blueprints/size/:blueprint", api.blueprintsSizeHandler)
 	api.router.GET("/api/v:version/blueprints/freeze/*blueprints", api.blueprintsFreezeHandler)
 	api.router.GET("/api/v:version/blueprints/diff/:blueprint/:from/:to", api.blueprintsDiffHandler)
 	api.router.GET("/api/v:version/blueprints/changes/*blueprints", api.blueprintsChangesHandler)
 	api.router.POST("/api/v:version/blueprints/new", api.blueprintsNewHandler)
+	api.router.POST("/api/v:version/blueprints/validate", api.blueprintsValidateHandler)
+	api.router.GET("/api/v:version/blueprints/workspace", api.blueprintsWorkspaceListHandler)
 	api.router.POST("/api/v:version/blueprints/workspace", api.blueprintsWorkspaceHandler)
 	api.router.POST("/api/v:version/blueprints/undo/:blueprint/:commit", api.blueprintUndoHandler)
+	api.router.POST("/api/v:version/blueprints/revert/:blueprint/:commit", api.blueprintRevertHandler)
 	api.router.POST("/api/v:version/blueprints/tag/:blueprint", api.blueprintsTagHandler)
 	api.router.DELETE("/api/v:version/blueprints/delete/:blueprint", api.blueprintDeleteHandler)
 	api.router.DELETE("/api/v:version/blueprints/workspace/:blueprint", api.blueprintDeleteWorkspaceHandler)
 
 	api.router.POST("/api/v:version/compose", api.composeHandler)
+	api.router.POST("/api/v:version/compose/blueprint", api.composeBlueprintHandler)
+	api.router.POST("/api/v:version/compose/manifest", api.composeManifestHandler)
 	api.router.DELETE("/api/v:version/compose/delete/:uuids", api.composeDeleteHandler)
+	api.router.POST("/api/v:version/compose/publish/:uuid", api.composePublishHandler)
+	api.router.POST("/api/v:version/compose/unpublish/:uuid", api.composeUnpublishHandler)
+	api.router.POST("/api/v:version/compose/promote/:uuid/:stage", api.composePromoteHandler)
+	api.router.POST("/api/v:version/compose/rebuild/:uuid", api.composeRebuildHandler)
 	api.router.GET("/api/v:version/compose/types", api.composeTypesHandler)
 	api.router.GET("/api/v:version/compose/queue", api.composeQueueHandler)
+	api.router.GET("/api/v:version/compose/queue/jobs", api.composeQueueJobsHandler)
 	api.router.GET("/api/v:version/compose/status/:uuids", api.composeStatusHandler)
 	api.router.GET("/api/v:version/compose/info/:uuid", api.composeInfoHandler)
 	api.router.GET("/api/v:version/compose/finished", api.composeFinishedHandler)
 	api.router.GET("/api/v:version/compose/failed", api.composeFailedHandler)
+	api.router.GET("/api/v:version/compose/disk-usage", api.composeDiskUsageHandler)
+	api.router.GET("/api/v:version/compose/using/:package", api.composeUsingHandler)
+	api.router.GET("/api/v:version/compose/diff/:from/:to", api.composeDiffHandler)
+	api.router.GET("/api/v:version/compose/export/:uuid", api.composeExportHandler)
+	api.router.GET("/api/v:version/compose/metadata/:uuid", api.composeMetadataHandler)
+	api.router.GET("/api/v:version/compose/timing/:uuid", api.composeTimingHandler)
+	api.router.POST("/api/v:version/compose/import", api.composeImportHandler)
+	api.router.POST("/api/v:version/compose/register", api.composeRegisterHandler)
+	api.router.POST("/api/v:version/compose/convert", api.composeConvertHandler)
 	api.router.GET("/api/v:version/compose/image/:uuid", api.composeImageHandler)
+	api.router.GET("/api/v:version/compose/image/:uuid/checksum", api.composeImageChecksumHandler)
+	api.router.GET("/api/v:version/compose/image/:uuid/signature", api.composeImageSignatureHandler)
+	api.router.GET("/api/v:version/compose/checkpoint/:uuid/:name", api.composeCheckpointHandler)
 	api.router.GET("/api/v:version/compose/logs/:uuid", api.composeLogsHandler)
 	api.router.GET("/api/v:version/compose/log/:uuid", api.composeLogHandler)
+	api.router.GET("/api/v:version/compose/log/:uuid/follow", api.composeLogFollowHandler)
 	api.router.POST("/api/v:version/compose/uploads/schedule/:uuid", api.uploadsScheduleHandler)
 
 	api.router.DELETE("/api/v:version/upload/delete/:uuid", api.uploadsDeleteHandler)
@@ -116,14 +209,32 @@ func New(rpmmd rpmmd.RPMMD, arch distro.Arch, distro distro.Distro, repos []rpmm
 	api.router.GET("/api/v:version/upload/providers", api.providersHandler)
 	api.router.POST("/api/v:version/upload/providers/save", api.providersSaveHandler)
 	api.router.DELETE("/api/v:version/upload/providers/delete/:provider/:profile", api.providersDeleteHandler)
+	api.router.POST("/api/v:version/upload/providers/validate", api.providersValidateHandler)
+	api.router.GET("/api/v:version/upload/health", api.uploadsHealthHandler)
 
-	return api
+	api.router.GET("/api/v:version/webhooks", api.webhooksListHandler)
+	api.router.POST("/api/v:version/webhooks/new", api.webhooksNewHandler)
+	api.router.DELETE("/api/v:version/webhooks/delete/:id", api.webhooksDeleteHandler)
+
+	api.router.GET("/api/v:version/events", api.eventsHandler)
+
+	api.router.GET("/api/v:version/nightly", api.nightlyHandler)
+	api.router.GET("/api/v:version/rebuilds", api.rebuildsHandler)
+	api.router.GET("/api/v:version/gitsync", api.gitSyncHandler)
+	api.router.GET("/api/v:version/audit", api.auditHandler)
+}
+
+// SetAdmission sets the controller that decides whether compose requests
+// are accepted. By default, all requests are accepted.
+func (api *API) SetAdmission(controller admission.Controller) {
+	api.admission = controller
 }
 
 func (api *API) Serve(listener net.Listener) error {
-	server := http.Server{Handler: api}
+	server := &http.Server{Handler: api}
+	api.servers.add(server)
 
-	err := server.Serve(listener)
+	err := server.Serve(peerCredListener{listener})
 	if err != nil && err != http.ErrServerClosed {
 		return err
 	}
@@ -137,41 +248,34 @@ func (api *API) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 	}
 
 	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
-	api.router.ServeHTTP(writer, request)
-}
 
-// Returns the state of the image in `compose` and the times the job was
-// queued, started, and finished. Assumes that there's only one image in the
-// compose. Returns CWaiting on error.
-func (api *API) getComposeState(compose compose.Compose) (state common.ComposeState, queued, started, finished time.Time) {
-	if len(compose.ImageBuilds) == 0 {
+	request, ok := api.authorize(writer, request)
+	if !ok {
+		return
+	}
+
+	tenantAPI := api.tenantAPI(writer, request)
+	if tenantAPI == nil {
 		return
 	}
 
-	jobId := compose.ImageBuilds[0].JobId
+	if !api.rejectWhileDraining(writer, request) {
+		return
+	}
 
-	// backwards compatibility: composes that were around before splitting
-	// the job queue from the store still contain their valid status and
-	// times. Return those here as a fallback.
-	if jobId == uuid.Nil {
-		switch compose.ImageBuilds[0].QueueStatus {
-		case common.IBWaiting:
-			state = common.CWaiting
-		case common.IBRunning:
-			state = common.CRunning
-		case common.IBFinished:
-			state = common.CFinished
-		case common.IBFailed:
-			state = common.CFailed
-		}
-		queued = compose.ImageBuilds[0].JobCreated
-		started = compose.ImageBuilds[0].JobStarted
-		finished = compose.ImageBuilds[0].JobFinished
+	if api.auditLog != nil {
+		audit.Handler(api.auditLog, tenantAPI.router, auditActor).ServeHTTP(writer, request)
 		return
 	}
 
-	state, queued, started, finished, _ = api.workers.JobStatus(jobId)
-	return
+	tenantAPI.router.ServeHTTP(writer, request)
+}
+
+// Returns the state of the image in `compose` and the times the job was
+// queued, started, and finished. Assumes that there's only one image in the
+// compose. Returns CWaiting on error.
+func (api *API) getComposeState(compose compose.Compose) (state common.ComposeState, queued, started, finished time.Time) {
+	return api.workers.ComposeState(compose)
 }
 
 func verifyRequestVersion(writer http.ResponseWriter, params httprouter.Params, minVersion uint) bool {
@@ -226,10 +330,60 @@ func statusResponseOK(writer http.ResponseWriter) {
 	common.PanicOnError(err)
 }
 
+// responseError is the lorax-compatible error object. `ID` is lorax's error
+// identifier and `Code` an optional HTTP status code. `ErrorCode` and
+// `Details` are shared with the other APIs (see common.APIError).
 type responseError struct {
-	Code int    `json:"code,omitempty"`
-	ID   string `json:"id"`
-	Msg  string `json:"msg"`
+	Code      int                 `json:"code,omitempty"`
+	ID        string              `json:"id"`
+	Msg       string              `json:"msg"`
+	ErrorCode common.APIErrorCode `json:"error_code,omitempty"`
+	Details   []string            `json:"details,omitempty"`
+
+	// Set for QuotaExceeded errors
+	Quota *quota.ExceededError `json:"quota,omitempty"`
+}
+
+// Maps lorax error ids to the error codes shared by all APIs. Ids which are
+// not listed here are too generic and are mapped based on the HTTP status.
+var errorCodes = map[string]common.APIErrorCode{
+	"UnknownBlueprint":       common.ErrorBlueprintNotFound,
+	"UnknownCommit":          common.ErrorCommitNotFound,
+	"UnknownSource":          common.ErrorSourceNotFound,
+	"UnknownGPGKey":          common.ErrorNotFound,
+	"UnknownProject":         common.ErrorPackageNotFound,
+	"UnknownModule":          common.ErrorPackageNotFound,
+	"UnknownUUID":            common.ErrorComposeNotFound,
+	"UnknownComposeType":     common.ErrorUnknownImageType,
+	"UnknownArchitecture":    common.ErrorUnknownArch,
+	"BuildInWrongState":      common.ErrorComposeWrongState,
+	"BuildMissingFile":       common.ErrorArtifactNotFound,
+	"DepsolveError":          common.ErrorDepsolveFailed,
+	"ManifestCreationFailed": common.ErrorManifestCreationFailed,
+	"InvalidChars":           common.ErrorInvalidRequest,
+	"UnknownCompression":     common.ErrorInvalidRequest,
+	"CompressionUnavailable": common.ErrorInvalidRequest,
+	"BadLimitOrOffset":       common.ErrorInvalidRequest,
+	"AuditUnavailable":       common.ErrorNotFound,
+	"AuditError":             common.ErrorInternal,
+	"MissingPost":            common.ErrorInvalidRequest,
+	"BadCompose":             common.ErrorInvalidRequest,
+	"BadRegistration":        common.ErrorInvalidRequest,
+	"BadConversion":          common.ErrorInvalidRequest,
+	"BadImport":              common.ErrorInvalidRequest,
+	"ImportConflict":         common.ErrorImportConflict,
+	"Unauthorized":           common.ErrorUnauthorized,
+	"PermissionDenied":       common.ErrorForbidden,
+	"ComposeDenied":          common.ErrorForbidden,
+	"UnknownPromotionStage":  common.ErrorInvalidRequest,
+	"BadUpload":              common.ErrorInvalidRequest,
+	"BadWebhook":             common.ErrorInvalidRequest,
+	"UnknownWebhook":         common.ErrorNotFound,
+	"EventsUnavailable":      common.ErrorNotFound,
+	"DiskQuotaExceeded":      common.ErrorQuotaExceeded,
+	"QuotaExceeded":          common.ErrorQuotaExceeded,
+	"NoWorkers":              common.ErrorNoWorkers,
+	"ShuttingDown":           common.ErrorShuttingDown,
 }
 
 func statusResponseError(writer http.ResponseWriter, code int, errors ...responseError) {
@@ -239,10 +393,40 @@ func statusResponseError(writer http.ResponseWriter, code int, errors ...respons
 	}
 
 	writer.WriteHeader(code)
-	err := json.NewEncoder(writer).Encode(reply{false, errors})
+	err := json.NewEncoder(writer).Encode(reply{false, setErrorCodes(errors, code)})
 	common.PanicOnError(err)
 }
 
+// setErrorCodes sets the error code of each of `errors` which doesn't have
+// one yet, based on its id or, if that is too generic, on `status`. It is
+// also used for errors of single items in successful responses, which are
+// internal errors unless their id says otherwise.
+func setErrorCodes(errors []responseError, status int) []responseError {
+	for i := range errors {
+		if errors[i].ErrorCode != "" {
+			continue
+		}
+		if errorCode, exists := errorCodes[errors[i].ID]; exists {
+			errors[i].ErrorCode = errorCode
+		} else {
+			errors[i].ErrorCode = common.APIErrorCodeFromHTTPStatus(status)
+		}
+	}
+	return errors
+}
+
+// blueprintErrorCode returns the error code of `err`, which the store
+// returned when changing a blueprint.
+func blueprintErrorCode(err error) common.APIErrorCode {
+	switch err.(type) {
+	case *store.NotFoundError:
+		return common.ErrorBlueprintNotFound
+	case *store.CommitNotFoundError:
+		return common.ErrorCommitNotFound
+	}
+	return common.ErrorInvalidRequest
+}
+
 func (api *API) statusHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
 	type reply struct {
 		API           string   `json:"api"`
@@ -309,6 +493,7 @@ func (api *API) sourceInfoHandler(writer http.ResponseWriter, request *http.Requ
 	// configuration
 	type reply struct {
 		Sources map[string]store.SourceConfig `json:"sources"`
+		Stats   map[string]store.SourceStats  `json:"stats,omitempty"`
 		Errors  []responseError               `json:"errors"`
 	}
 
@@ -360,11 +545,23 @@ func (api *API) sourceInfoHandler(writer http.ResponseWriter, request *http.Requ
 		return
 	}
 
+	// API version 1 also returns usage stats of each source, to help
+	// finding sources which are unused or broken
+	var stats map[string]store.SourceStats
+	if isRequestVersionAtLeast(params, 1) {
+		allStats := api.store.GetSourceStats()
+		stats = make(map[string]store.SourceStats, len(sources))
+		for name := range sources {
+			stats[name] = allStats[name]
+		}
+	}
+
 	format := q.Get("format")
 	if format == "json" || format == "" {
 		err := json.NewEncoder(writer).Encode(reply{
 			Sources: sources,
-			Errors:  errors,
+			Stats:   stats,
+			Errors:  setErrorCodes(errors, http.StatusOK),
 		})
 		common.PanicOnError(err)
 	} else if format == "toml" {
@@ -422,6 +619,11 @@ func (api *API) sourceNewHandler(writer http.ResponseWriter, request *http.Reque
 			err = errors_package.New("'name' field is missing from API v0 request")
 		} else if len(source.Type) == 0 {
 			err = errors_package.New("'type' field is missing from API v0 request")
+		} else {
+			err = validateSourceTLS(&source)
+		}
+		if err == nil {
+			err = validateSourceMirrors(&source)
 		}
 	}
 
@@ -434,11 +636,78 @@ func (api *API) sourceNewHandler(writer http.ResponseWriter, request *http.Reque
 		return
 	}
 
-	api.store.PushSource(source.SourceConfig())
+	sourceConfig := source.SourceConfig()
+	sourceConfig.GPGKeys, err = api.resolveSourceKeys(&source)
+	if err != nil {
+		errors := responseError{
+			ID:  "ProjectsError",
+			Msg: "Problem with the source's gpg keys: " + err.Error(),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	api.store.PushSource(sourceConfig)
 
 	statusResponseOK(writer)
 }
 
+// validateSourceTLS checks the proxy and certificates of `source`. The proxy
+// must be a URL, and certificates must be given as absolute paths, because
+// dnf reads them on the host.
+func validateSourceTLS(source *SourceConfigV0) error {
+	if source.Proxy != "" {
+		u, err := url.Parse(source.Proxy)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("'proxy' must be a URL: %s", source.Proxy)
+		}
+	}
+
+	paths := []struct {
+		field string
+		path  string
+	}{
+		{"sslcacert", source.SSLCACert},
+		{"sslclientcert", source.SSLClientCert},
+		{"sslclientkey", source.SSLClientKey},
+	}
+	for _, p := range paths {
+		if p.path != "" && !filepath.IsAbs(p.path) {
+			return fmt.Errorf("'%s' must be an absolute path: %s", p.field, p.path)
+		}
+	}
+
+	if (source.SSLClientCert == "") != (source.SSLClientKey == "") {
+		return errors_package.New("'sslclientcert' and 'sslclientkey' must be given together")
+	}
+
+	return nil
+}
+
+// validateSourceMirrors checks the mirrors, priority, and cost of `source`.
+// Only yum-baseurl sources have mirrors, which must be URLs. Priorities range
+// from 1 to 99, like in dnf.
+func validateSourceMirrors(source *SourceConfigV0) error {
+	if len(source.Mirrors) > 0 && source.Type != "yum-baseurl" {
+		return errors_package.New("'mirrors' are only supported by yum-baseurl sources")
+	}
+	for _, mirror := range source.Mirrors {
+		u, err := url.Parse(mirror)
+		if err != nil || u.Scheme == "" {
+			return fmt.Errorf("'mirrors' must be URLs: %s", mirror)
+		}
+	}
+
+	if source.Priority < 0 || source.Priority > 99 {
+		return fmt.Errorf("'priority' must be between 1 and 99: %d", source.Priority)
+	}
+	if source.Cost < 0 {
+		return fmt.Errorf("'cost' must not be negative: %d", source.Cost)
+	}
+
+	return nil
+}
+
 func (api *API) sourceDeleteHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
 	if !verifyRequestVersion(writer, params, 0) {
 		return
@@ -728,7 +997,8 @@ func (api *API) projectsDepsolveHandler(writer http.ResponseWriter, request *htt
 	projects = projects[1:]
 	names := strings.Split(projects, ",")
 
-	packages, _, err := api.rpmmd.Depsolve(names, nil, api.repos, api.distro.ModulePlatformID(), api.arch.Name())
+	packages, _, err := api.rpmmd.Depsolve(names, nil, nil, api.repos, api.distro.ModulePlatformID(), api.arch.Name())
+	api.recordSourceFetch(api.repos, err)
 
 	if err != nil {
 		errors := responseError{
@@ -844,7 +1114,7 @@ func (api *API) blueprintsInfoHandler(writer http.ResponseWriter, request *http.
 		err := json.NewEncoder(writer).Encode(reply{
 			Blueprints: blueprints,
 			Changes:    changes,
-			Errors:     blueprintErrors,
+			Errors:     setErrorCodes(blueprintErrors, http.StatusOK),
 		})
 		common.PanicOnError(err)
 	} else if format == "toml" {
@@ -918,12 +1188,13 @@ func (api *API) blueprintsDepsolveHandler(writer http.ResponseWriter, request *h
 			continue
 		}
 
-		dependencies, _, err := api.depsolveBlueprint(blueprint, nil)
+		dependencies, _, _, err := api.depsolveBlueprint(blueprint, nil)
 
 		if err != nil {
 			errors := responseError{
-				ID:  "BlueprintsError",
-				Msg: fmt.Sprintf("%s: %s", name, err.Error()),
+				ID:        "BlueprintsError",
+				Msg:       fmt.Sprintf("%s: %s", name, err.Error()),
+				ErrorCode: common.ErrorDepsolveFailed,
 			}
 			statusResponseError(writer, http.StatusBadRequest, errors)
 			return
@@ -934,7 +1205,7 @@ func (api *API) blueprintsDepsolveHandler(writer http.ResponseWriter, request *h
 
 	err := json.NewEncoder(writer).Encode(reply{
 		Blueprints: blueprints,
-		Errors:     blueprintsErrors,
+		Errors:     setErrorCodes(blueprintsErrors, http.StatusOK),
 	})
 	common.PanicOnError(err)
 }
@@ -1007,18 +1278,20 @@ func (api *API) blueprintsFreezeHandler(writer http.ResponseWriter, request *htt
 		blueprint, err := bp.DeepCopy()
 		if err != nil {
 			rerr := responseError{
-				ID:  "BlueprintDeepCopyError",
-				Msg: fmt.Sprintf("%s: %s", name, err.Error()),
+				ID:        "BlueprintDeepCopyError",
+				Msg:       fmt.Sprintf("%s: %s", name, err.Error()),
+				ErrorCode: common.ErrorInternal,
 			}
 			errors = append(errors, rerr)
 			break
 		}
 
-		dependencies, _, err := api.depsolveBlueprint(&blueprint, nil)
+		dependencies, _, _, err := api.depsolveBlueprint(&blueprint, nil)
 		if err != nil {
 			rerr := responseError{
-				ID:  "BlueprintsError",
-				Msg: fmt.Sprintf("%s: %s", name, err.Error()),
+				ID:        "BlueprintsError",
+				Msg:       fmt.Sprintf("%s: %s", name, err.Error()),
+				ErrorCode: common.ErrorDepsolveFailed,
 			}
 			errors = append(errors, rerr)
 			break
@@ -1031,8 +1304,9 @@ func (api *API) blueprintsFreezeHandler(writer http.ResponseWriter, request *htt
 		err = setPkgEVRA(dependencies, blueprint.Packages)
 		if err != nil {
 			rerr := responseError{
-				ID:  "BlueprintsError",
-				Msg: fmt.Sprintf("%s: %s", name, err.Error()),
+				ID:        "BlueprintsError",
+				Msg:       fmt.Sprintf("%s: %s", name, err.Error()),
+				ErrorCode: common.ErrorDepsolveFailed,
 			}
 			errors = append(errors, rerr)
 			break
@@ -1041,8 +1315,9 @@ func (api *API) blueprintsFreezeHandler(writer http.ResponseWriter, request *htt
 		err = setPkgEVRA(dependencies, blueprint.Modules)
 		if err != nil {
 			rerr := responseError{
-				ID:  "BlueprintsError",
-				Msg: fmt.Sprintf("%s: %s", name, err.Error()),
+				ID:        "BlueprintsError",
+				Msg:       fmt.Sprintf("%s: %s", name, err.Error()),
+				ErrorCode: common.ErrorDepsolveFailed,
 			}
 			errors = append(errors, rerr)
 			break
@@ -1052,7 +1327,7 @@ func (api *API) blueprintsFreezeHandler(writer http.ResponseWriter, request *htt
 
 	err := json.NewEncoder(writer).Encode(reply{
 		Blueprints: blueprints,
-		Errors:     errors,
+		Errors:     setErrorCodes(errors, http.StatusOK),
 	})
 	common.PanicOnError(err)
 }
@@ -1219,7 +1494,7 @@ func (api *API) blueprintsChangesHandler(writer http.ResponseWriter, request *ht
 
 	err = json.NewEncoder(writer).Encode(reply{
 		BlueprintsChanges: allChanges,
-		Errors:            errors,
+		Errors:            setErrorCodes(errors, http.StatusOK),
 		Offset:            offset,
 		Limit:             limit,
 	})
@@ -1234,8 +1509,9 @@ func (api *API) blueprintsNewHandler(writer http.ResponseWriter, request *http.R
 	contentType := request.Header["Content-Type"]
 	if len(contentType) == 0 {
 		errors := responseError{
-			ID:  "BlueprintsError",
-			Msg: "missing Content-Type header",
+			ID:        "BlueprintsError",
+			Msg:       "missing Content-Type header",
+			ErrorCode: common.ErrorInvalidRequest,
 		}
 		statusResponseError(writer, http.StatusBadRequest, errors)
 		return
@@ -1243,8 +1519,9 @@ func (api *API) blueprintsNewHandler(writer http.ResponseWriter, request *http.R
 
 	if request.ContentLength == 0 {
 		errors := responseError{
-			ID:  "BlueprintsError",
-			Msg: "Missing blueprint",
+			ID:        "BlueprintsError",
+			Msg:       "Missing blueprint",
+			ErrorCode: common.ErrorInvalidRequest,
 		}
 		statusResponseError(writer, http.StatusBadRequest, errors)
 		return
@@ -1262,8 +1539,9 @@ func (api *API) blueprintsNewHandler(writer http.ResponseWriter, request *http.R
 
 	if err != nil {
 		errors := responseError{
-			ID:  "BlueprintsError",
-			Msg: "400 Bad Request: The browser (or proxy) sent a request that this server could not understand: " + err.Error(),
+			ID:        "BlueprintsError",
+			Msg:       "400 Bad Request: The browser (or proxy) sent a request that this server could not understand: " + err.Error(),
+			ErrorCode: common.ErrorInvalidRequest,
 		}
 		statusResponseError(writer, http.StatusBadRequest, errors)
 		return
@@ -1273,8 +1551,9 @@ func (api *API) blueprintsNewHandler(writer http.ResponseWriter, request *http.R
 	err = api.store.PushBlueprint(blueprint, commitMsg)
 	if err != nil {
 		errors := responseError{
-			ID:  "BlueprintsError",
-			Msg: err.Error(),
+			ID:        "BlueprintsError",
+			Msg:       err.Error(),
+			ErrorCode: blueprintErrorCode(err),
 		}
 		statusResponseError(writer, http.StatusBadRequest, errors)
 		return
@@ -1291,8 +1570,9 @@ func (api *API) blueprintsWorkspaceHandler(writer http.ResponseWriter, request *
 	contentType := request.Header["Content-Type"]
 	if len(contentType) == 0 {
 		errors := responseError{
-			ID:  "BlueprintsError",
-			Msg: "missing Content-Type header",
+			ID:        "BlueprintsError",
+			Msg:       "missing Content-Type header",
+			ErrorCode: common.ErrorInvalidRequest,
 		}
 		statusResponseError(writer, http.StatusBadRequest, errors)
 		return
@@ -1300,8 +1580,9 @@ func (api *API) blueprintsWorkspaceHandler(writer http.ResponseWriter, request *
 
 	if request.ContentLength == 0 {
 		errors := responseError{
-			ID:  "BlueprintsError",
-			Msg: "Missing blueprint",
+			ID:        "BlueprintsError",
+			Msg:       "Missing blueprint",
+			ErrorCode: common.ErrorInvalidRequest,
 		}
 		statusResponseError(writer, http.StatusBadRequest, errors)
 		return
@@ -1319,8 +1600,9 @@ func (api *API) blueprintsWorkspaceHandler(writer http.ResponseWriter, request *
 
 	if err != nil {
 		errors := responseError{
-			ID:  "BlueprintsError",
-			Msg: "400 Bad Request: The browser (or proxy) sent a request that this server could not understand: " + err.Error(),
+			ID:        "BlueprintsError",
+			Msg:       "400 Bad Request: The browser (or proxy) sent a request that this server could not understand: " + err.Error(),
+			ErrorCode: common.ErrorInvalidRequest,
 		}
 		statusResponseError(writer, http.StatusBadRequest, errors)
 		return
@@ -1329,8 +1611,9 @@ func (api *API) blueprintsWorkspaceHandler(writer http.ResponseWriter, request *
 	err = api.store.PushBlueprintToWorkspace(blueprint)
 	if err != nil {
 		errors := responseError{
-			ID:  "BlueprintsError",
-			Msg: err.Error(),
+			ID:        "BlueprintsError",
+			Msg:       err.Error(),
+			ErrorCode: blueprintErrorCode(err),
 		}
 		statusResponseError(writer, http.StatusBadRequest, errors)
 		return
@@ -1344,30 +1627,58 @@ func (api *API) blueprintUndoHandler(writer http.ResponseWriter, request *http.R
 		return
 	}
 
-	name := params.ByName("blueprint")
-	commit := params.ByName("commit")
-	bpChange, err := api.store.GetBlueprintChange(name, commit)
+	_, err := api.store.RevertBlueprint(params.ByName("blueprint"), params.ByName("commit"))
 	if err != nil {
 		errors := responseError{
-			ID:  "BlueprintsError",
-			Msg: err.Error(),
+			ID:        "BlueprintsError",
+			Msg:       err.Error(),
+			ErrorCode: blueprintErrorCode(err),
 		}
 		statusResponseError(writer, http.StatusBadRequest, errors)
 		return
 	}
+	statusResponseOK(writer)
+}
+
+// blueprintRevertHandler is like blueprintUndoHandler, but tells clients
+// which commit recorded the revert and the resulting version of the
+// blueprint.
+func (api *API) blueprintRevertHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	type reply struct {
+		Status  bool   `json:"status"`
+		Commit  string `json:"commit"`
+		Version string `json:"version"`
+	}
 
-	bp := bpChange.Blueprint
-	commitMsg := name + ".toml reverted to commit " + commit
-	err = api.store.PushBlueprint(bp, commitMsg)
+	name := params.ByName("blueprint")
+	commit, err := api.store.RevertBlueprint(name, params.ByName("commit"))
 	if err != nil {
 		errors := responseError{
-			ID:  "BlueprintsError",
-			Msg: err.Error(),
+			ID:        "BlueprintsError",
+			Msg:       err.Error(),
+			ErrorCode: blueprintErrorCode(err),
 		}
 		statusResponseError(writer, http.StatusBadRequest, errors)
 		return
 	}
-	statusResponseOK(writer)
+
+	bp := api.store.GetBlueprintCommitted(name)
+	if bp == nil {
+		// deleted again in the meantime
+		errors := responseError{
+			ID:  "UnknownBlueprint",
+			Msg: fmt.Sprintf("%s: blueprint not found", name),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	err = json.NewEncoder(writer).Encode(reply{true, commit, bp.Version})
+	common.PanicOnError(err)
 }
 
 func (api *API) blueprintDeleteHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
@@ -1377,8 +1688,9 @@ func (api *API) blueprintDeleteHandler(writer http.ResponseWriter, request *http
 
 	if err := api.store.DeleteBlueprint(params.ByName("blueprint")); err != nil {
 		errors := responseError{
-			ID:  "BlueprintsError",
-			Msg: err.Error(),
+			ID:        "BlueprintsError",
+			Msg:       err.Error(),
+			ErrorCode: blueprintErrorCode(err),
 		}
 		statusResponseError(writer, http.StatusBadRequest, errors)
 		return
@@ -1393,8 +1705,9 @@ func (api *API) blueprintDeleteWorkspaceHandler(writer http.ResponseWriter, requ
 
 	if err := api.store.DeleteBlueprintFromWorkspace(params.ByName("blueprint")); err != nil {
 		errors := responseError{
-			ID:  "BlueprintsError",
-			Msg: err.Error(),
+			ID:        "BlueprintsError",
+			Msg:       err.Error(),
+			ErrorCode: blueprintErrorCode(err),
 		}
 		statusResponseError(writer, http.StatusBadRequest, errors)
 		return
@@ -1411,8 +1724,9 @@ func (api *API) blueprintsTagHandler(writer http.ResponseWriter, request *http.R
 	err := api.store.TagBlueprint(params.ByName("blueprint"))
 	if err != nil {
 		errors := responseError{
-			ID:  "BlueprintsError",
-			Msg: err.Error(),
+			ID:        "BlueprintsError",
+			Msg:       err.Error(),
+			ErrorCode: blueprintErrorCode(err),
 		}
 		statusResponseError(writer, http.StatusBadRequest, errors)
 		return
@@ -1422,6 +1736,33 @@ func (api *API) blueprintsTagHandler(writer http.ResponseWriter, request *http.R
 
 // Schedule new compose by first translating the appropriate blueprint into a pipeline and then
 // pushing it into the channel for waiting builds.
+// Parameters of a compose request, apart from the blueprint.
+type composeParameters struct {
+	ComposeType string         `json:"compose_type"`
+	Size        uint64         `json:"size"`
+	Branch      string         `json:"branch"`
+	Upload      *uploadRequest `json:"upload"`
+	Priority    string         `json:"priority"`
+	Checkpoints []string       `json:"checkpoints,omitempty"`
+
+	// API v1 only, instead of ComposeType
+	ComposeTypes []string `json:"compose_types,omitempty"`
+
+	// API v1 only, instead of Upload for composes of several image types,
+	// the upload of the images of each of the ComposeTypes
+	Uploads map[string]*uploadRequest `json:"uploads,omitempty"`
+
+	// API v1 only, the ref and parent of ostree commits
+	OSTree *distro.OSTreeImageOptions `json:"ostree,omitempty"`
+
+	// API v1 only, the architecture to build for instead of composer's
+	Arch string `json:"arch,omitempty"`
+
+	// API v1 only, a compose whose packages are reused instead of
+	// resolving the blueprint's packages again, see pinned.go
+	PackagesFrom *uuid.UUID `json:"packages_from,omitempty"`
+}
+
 func (api *API) composeHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
 	if !verifyRequestVersion(writer, params, 0) {
 		return
@@ -1429,15 +1770,8 @@ func (api *API) composeHandler(writer http.ResponseWriter, request *http.Request
 
 	// https://weldr.io/lorax/pylorax.api.html#pylorax.api.v0.v0_compose_start
 	type ComposeRequest struct {
-		BlueprintName string         `json:"blueprint_name"`
-		ComposeType   string         `json:"compose_type"`
-		Size          uint64         `json:"size"`
-		Branch        string         `json:"branch"`
-		Upload        *uploadRequest `json:"upload"`
-	}
-	type ComposeReply struct {
-		BuildID uuid.UUID `json:"build_id"`
-		Status  bool      `json:"status"`
+		BlueprintName string `json:"blueprint_name"`
+		composeParameters
 	}
 
 	contentType := request.Header["Content-Type"]
@@ -1462,177 +1796,737 @@ func (api *API) composeHandler(writer http.ResponseWriter, request *http.Request
 		return
 	}
 
-	imageType, err := api.arch.GetImageType(cr.ComposeType)
-	if err != nil {
+	bp := api.store.GetBlueprintCommitted(cr.BlueprintName)
+	if bp == nil {
 		errors := responseError{
-			ID:  "UnknownComposeType",
-			Msg: fmt.Sprintf("Unknown compose type for architecture: %s", cr.ComposeType),
+			ID:  "UnknownBlueprint",
+			Msg: fmt.Sprintf("Unknown blueprint name: %s", cr.BlueprintName),
 		}
 		statusResponseError(writer, http.StatusBadRequest, errors)
 		return
 	}
 
-	composeID := uuid.New()
+	api.startCompose(writer, request, params, bp, cr.composeParameters)
+}
 
-	var targets []*target.Target
-	if isRequestVersionAtLeast(params, 1) && cr.Upload != nil {
-		t := uploadRequestToTarget(*cr.Upload, imageType)
-		targets = append(targets, t)
+// composeBlueprintHandler starts a compose of a blueprint that is sent along
+// with the request instead of being taken from the store. This is meant for
+// one-off builds, for example from CI. The blueprint is only saved (to the
+// workspace) when `store` is set.
+func (api *API) composeBlueprintHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
 	}
 
-	targets = append(targets, target.NewLocalTarget(
-		&target.LocalTargetOptions{
-			ComposeId:    composeID,
-			ImageBuildId: 0,
-			Filename:     imageType.Filename(),
-		},
-	))
+	type ComposeBlueprintRequest struct {
+		Blueprint string `json:"blueprint"`
+		Store     bool   `json:"store"`
+		composeParameters
+	}
 
-	bp := api.store.GetBlueprintCommitted(cr.BlueprintName)
-	if bp == nil {
+	contentType := request.Header["Content-Type"]
+	if len(contentType) != 1 || contentType[0] != "application/json" {
 		errors := responseError{
-			ID:  "UnknownBlueprint",
-			Msg: fmt.Sprintf("Unknown blueprint name: %s", cr.BlueprintName),
+			ID:  "MissingPost",
+			Msg: "request must be json",
 		}
 		statusResponseError(writer, http.StatusBadRequest, errors)
 		return
 	}
 
-	packages, buildPackages, err := api.depsolveBlueprint(bp, imageType)
+	var cr ComposeBlueprintRequest
+	err := json.NewDecoder(request.Body).Decode(&cr)
 	if err != nil {
 		errors := responseError{
-			ID:  "DepsolveError",
-			Msg: err.Error(),
+			ID:  "BadCompose",
+			Msg: fmt.Sprintf("invalid request: %v", err),
 		}
-		statusResponseError(writer, http.StatusInternalServerError, errors)
+		statusResponseError(writer, http.StatusBadRequest, errors)
 		return
 	}
 
-	// Check for test parameter
-	q, err := url.ParseQuery(request.URL.RawQuery)
+	bp, err := parseBlueprint(cr.Blueprint)
 	if err != nil {
 		errors := responseError{
-			ID:  "InvalidChars",
-			Msg: fmt.Sprintf("invalid query string: %v", err),
+			ID:        "BlueprintsError",
+			Msg:       fmt.Sprintf("invalid blueprint: %v", err),
+			ErrorCode: common.ErrorInvalidRequest,
 		}
 		statusResponseError(writer, http.StatusBadRequest, errors)
 		return
 	}
 
-	size := imageType.Size(cr.Size)
-	manifest, err := imageType.Manifest(bp.Customizations, api.allRepositories(), packages, buildPackages, size)
+	if cr.Store {
+		err = api.store.PushBlueprintToWorkspace(*bp)
+		if err != nil {
+			errors := responseError{
+				ID:        "BlueprintsError",
+				Msg:       err.Error(),
+				ErrorCode: blueprintErrorCode(err),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+	}
+
+	api.startCompose(writer, request, params, bp, cr.composeParameters)
+}
+
+// parseBlueprint parses the TOML blueprint `data`, which must have a name.
+func parseBlueprint(data string) (*blueprint.Blueprint, error) {
+	var bp blueprint.Blueprint
+	_, err := toml.Decode(data, &bp)
 	if err != nil {
+		return nil, err
+	}
+	if bp.Name == "" {
+		return nil, errors_package.New("blueprint has no name")
+	}
+	err = bp.Initialize()
+	if err != nil {
+		return nil, err
+	}
+	return &bp, nil
+}
+
+// startCompose creates a compose of `bp` and writes the response.
+func (api *API) startCompose(writer http.ResponseWriter, request *http.Request, params httprouter.Params, bp *blueprint.Blueprint, cp composeParameters) {
+	type ComposeReply struct {
+		BuildID  uuid.UUID         `json:"build_id"`
+		Status   bool              `json:"status"`
+		Warnings []compose.Warning `json:"warnings,omitempty"`
+	}
+
+	arch, imageTypes, buildTypes, payloads, ok := api.composeImageTypes(writer, params, cp)
+	if !ok {
+		return
+	}
+
+	// Only privileged clients may jump the queue
+	priority := jobqueue.PriorityNormal
+	switch cp.Priority {
+	case "", "normal":
+	case "high":
+		if !isPrivileged(request) {
+			errors := responseError{
+				ID:  "PermissionDenied",
+				Msg: "only privileged clients may request high priority composes",
+			}
+			statusResponseError(writer, http.StatusForbidden, errors)
+			return
+		}
+		priority = jobqueue.PriorityHigh
+	default:
 		errors := responseError{
-			ID:  "ManifestCreationFailed",
-			Msg: fmt.Sprintf("failed to create osbuild manifest: %v", err),
+			ID:  "BadCompose",
+			Msg: fmt.Sprintf("Unknown priority: %s", cp.Priority),
 		}
 		statusResponseError(writer, http.StatusBadRequest, errors)
 		return
 	}
 
-	testMode := q.Get("test")
-	if testMode == "1" {
-		// Create a failed compose
-		err = api.store.PushTestCompose(composeID, manifest, imageType, bp, size, targets, false)
-	} else if testMode == "2" {
-		// Create a successful compose
-		err = api.store.PushTestCompose(composeID, manifest, imageType, bp, size, targets, true)
-	} else {
-		var jobId uuid.UUID
-
-		jobId, err = api.workers.Enqueue(manifest, targets)
-		if err == nil {
-			err = api.store.PushCompose(composeID, manifest, imageType, bp, size, targets, jobId)
+	var totalSize uint64
+	for i, imageType := range buildTypes {
+		size := imageType.Size(cp.Size)
+		if i < len(imageTypes) && !api.admit(writer, request, bp, arch, imageType, size) {
+			return
 		}
+		totalSize += size
 	}
 
-	// TODO: we should probably do some kind of blueprint validation in future
-	// for now, let's just 500 and bail out
+	if !api.checkDiskQuota(writer, totalSize) {
+		return
+	}
+
+	if !api.checkQuotas(writer, request, totalSize) {
+		return
+	}
+
+	// Check for test parameter
+	q, err := url.ParseQuery(request.URL.RawQuery)
 	if err != nil {
-		log.Println("error when pushing new compose: ", err.Error())
 		errors := responseError{
-			ID:  "ComposePushErrored",
-			Msg: err.Error(),
+			ID:  "InvalidChars",
+			Msg: fmt.Sprintf("invalid query string: %v", err),
 		}
-		statusResponseError(writer, http.StatusInternalServerError, errors)
+		statusResponseError(writer, http.StatusBadRequest, errors)
 		return
 	}
 
-	err = json.NewEncoder(writer).Encode(ComposeReply{
-		BuildID: composeID,
-		Status:  true,
-	})
-	common.PanicOnError(err)
-}
-
-func (api *API) composeDeleteHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
-	if !verifyRequestVersion(writer, params, 0) {
+	pinned, ok := api.pinnedCompose(writer, params, cp.PackagesFrom)
+	if !ok {
 		return
 	}
 
-	type composeDeleteStatus struct {
-		UUID   uuid.UUID `json:"uuid"`
-		Status bool      `json:"status"`
+	composeID := uuid.New()
+	repos := api.composeRepos(arch, pinned)
+	builds, warnings, checksums, ok := api.composeManifests(writer, params, bp, cp, arch, repos, pinned, buildTypes, payloads, len(imageTypes), composeID)
+	if !ok {
+		return
 	}
 
-	type composeDeleteError struct {
-		ID  string `json:"id"`
-		Msg string `json:"msg"`
+	err = api.queueCompose(request, composeID, bp, arch, builds, len(imageTypes), warnings, repos, checksums, priority, q.Get("test"))
+	if err != nil {
+		composePushError(writer, err)
+		return
 	}
 
-	uuidsParam := params.ByName("uuids")
+	err = json.NewEncoder(writer).Encode(ComposeReply{
+		BuildID:  composeID,
+		Status:   true,
+		Warnings: warnings,
+	})
+	common.PanicOnError(err)
+}
 
-	results := []composeDeleteStatus{}
-	errors := []composeDeleteError{}
-	uuidStrings := strings.Split(uuidsParam, ",")
-	for _, uuidString := range uuidStrings {
-		id, err := uuid.Parse(uuidString)
-		if err != nil {
-			errors = append(errors, composeDeleteError{
-				"UnknownUUID",
-				fmt.Sprintf("%s is not a valid uuid", uuidString),
-			})
-			continue
+// queueCompose queues the image builds of the new compose `composeID` of
+// `bp` and records it in the store, or records a failed (1) or successful
+// (2) compose if `testMode` is set. The first `requested` image builds are
+// the ones that were asked for. If anything fails, the jobs that were queued
+// already are canceled and the compose is removed again, so that no compose
+// is left with only some of its image builds.
+func (api *API) queueCompose(request *http.Request, composeID uuid.UUID, bp *blueprint.Blueprint, arch distro.Arch, builds []composeImageBuild, requested int, warnings []compose.Warning, repos []rpmmd.RepoConfig, checksums map[string]string, priority int, testMode string) error {
+	owner := requestOwner(request)
+	var totalSize uint64
+	for _, build := range builds {
+		totalSize += build.size
+	}
+
+	// Payloads are queued before the installers that depend on them, which
+	// is the reverse order of the image builds
+	var err error
+	var queued []uuid.UUID
+	pushed := false
+	jobIds := make([]uuid.UUID, len(builds))
+	if testMode != "1" && testMode != "2" {
+		for i := len(builds) - 1; i >= 0; i-- {
+			var dependencies []uuid.UUID
+			if builds[i].payload >= 0 {
+				dependencies = []uuid.UUID{jobIds[builds[i].payload]}
+			}
+			jobIds[i], err = api.workers.EnqueueForTenant(api.store.Tenant(), api.distro.Name(), arch.Name(), builds[i].manifest, builds[i].targets, dependencies, priority)
+			if err != nil {
+				break
+			}
+			queued = append(queued, jobIds[i])
 		}
+	}
 
-		compose, exists := api.store.GetCompose(id)
-		if !exists {
-			errors = append(errors, composeDeleteError{
-				"UnknownUUID",
-				fmt.Sprintf("compose %s doesn't exist", id),
-			})
-			continue
+	for i, build := range builds {
+		if err != nil {
+			break
 		}
 
-		state, _, _, _ := api.getComposeState(compose)
-		if state != common.CFinished && state != common.CFailed {
-			errors = append(errors, composeDeleteError{
-				"BuildInWrongState",
-				fmt.Sprintf("Compose %s is not in FINISHED or FAILED.", id),
-			})
-			continue
-		}
+		if testMode == "1" || testMode == "2" {
+			// Create a failed (1) or successful (2) compose
+			if i == 0 {
+				err = api.store.PushTestCompose(composeID, build.manifest, build.imageType, bp, build.size, build.targets, warnings, testMode == "2")
+			} else {
+				_, err = api.store.AddTestImageBuild(composeID, build.manifest, build.imageType, build.size, build.targets, testMode == "2")
+			}
+			pushed = pushed || (i == 0 && err == nil)
+		} else {
+			if i == 0 {
+				// This checks the quotas again, in case another compose
+				// was pushed since the handler checked them
+				err = api.store.PushComposeFor(owner, totalSize, composeID, build.manifest, build.imageType, bp, build.size, build.targets, warnings, jobIds[i])
+				pushed = err == nil
+			} else {
+				_, err = api.store.AddImageBuild(composeID, build.manifest, build.imageType, build.size, build.targets, jobIds[i])
+			}
 
-		err = api.store.DeleteCompose(id)
-		if err != nil {
-			errors = append(errors, composeDeleteError{
-				"ComposeError",
-				fmt.Sprintf("%s: %s", id, err.Error()),
-			})
-			continue
+			var scanJobId uuid.UUID
+			if err == nil {
+				scanJobId, err = api.workers.EnqueueScan(jobIds[i], composeID, i)
+			}
+			if err == nil && scanJobId != uuid.Nil {
+				queued = append(queued, scanJobId)
+				err = api.store.SetImageBuildScanJob(composeID, i, scanJobId)
+			}
+
+			var signJobId uuid.UUID
+			if err == nil {
+				signJobId, err = api.workers.EnqueueSigning(jobIds[i], composeID, i)
+			}
+			if err == nil && signJobId != uuid.Nil {
+				queued = append(queued, signJobId)
+				err = api.store.SetImageBuildSignJob(composeID, i, signJobId)
+			}
 		}
 
-		results = append(results, composeDeleteStatus{id, true})
+		if err == nil {
+			err = api.store.SetImageBuildPackages(composeID, i, build.packages, build.buildPackages)
+		}
 	}
 
-	reply := struct {
-		UUIDs  []composeDeleteStatus `json:"uuids"`
-		Errors []composeDeleteError  `json:"errors"`
-	}{results, errors}
+	// TODO: we should probably do some kind of blueprint validation in future
+	// for now, let's just 500 and bail out
+	if err == nil {
+		err = api.store.SetComposeRepositories(composeID, composeRepositories(repos, checksums))
+	}
 
-	err := json.NewEncoder(writer).Encode(reply)
-	common.PanicOnError(err)
-}
+	// PushTestCompose doesn't record owners, as test composes never run
+	if (testMode == "1" || testMode == "2") && err == nil && owner != "" {
+		err = api.store.SetComposeOwner(composeID, owner)
+	}
+
+	if err != nil {
+		api.unqueueCompose(composeID, queued, pushed)
+		return err
+	}
+
+	err = api.store.SourcesUsedInCompose(repoNames(repos))
+	if err != nil {
+		log.Printf("cannot record source stats: %v", err)
+	}
+
+	var imageTypeNames []string
+	for _, build := range builds[:requested] {
+		imageTypeNames = append(imageTypeNames, build.imageType.Name())
+	}
+	events.Emit(events.ComposeQueued, fmt.Sprintf("Compose %s of blueprint %s queued", composeID, bp.Name),
+		"COMPOSE_ID", composeID.String(),
+		"BLUEPRINT", bp.Name,
+		"BLUEPRINT_VERSION", bp.Version,
+		"IMAGE_TYPE", strings.Join(imageTypeNames, ","))
+
+	return nil
+}
+
+// unqueueCompose undoes queueCompose(), by canceling the `queued` jobs and
+// removing the compose `composeID` from the store if it was `pushed` there
+// already. Jobs are canceled in the reverse order, because jobs only depend
+// on jobs that were queued before them. Jobs which a worker has taken in the
+// meantime cannot be canceled; composer rejects their images, because the
+// compose doesn't exist.
+func (api *API) unqueueCompose(composeID uuid.UUID, queued []uuid.UUID, pushed bool) {
+	for i := len(queued) - 1; i >= 0; i-- {
+		err := api.workers.CancelJob(queued[i])
+		if err != nil {
+			log.Printf("cannot cancel job %s of compose %s: %v", queued[i], composeID, err)
+		}
+	}
+
+	if pushed {
+		err := api.store.DeleteCompose(composeID)
+		if err != nil {
+			log.Printf("cannot remove compose %s: %v", composeID, err)
+		}
+	}
+}
+
+// A composeImageBuild is an image build of a compose before it is queued.
+type composeImageBuild struct {
+	imageType     distro.ImageType
+	size          uint64
+	targets       []*target.Target
+	packages      []rpmmd.PackageSpec
+	buildPackages []rpmmd.PackageSpec
+	manifest      *osbuild.Manifest
+
+	// The image build of the payload of installers, -1 for other images
+	payload int
+}
+
+// composeImageTypes returns the architecture and the image types that `cp`
+// requests, as well as the image types to build and the image builds of
+// their payloads. It writes an error response and returns false if the
+// request is invalid.
+func (api *API) composeImageTypes(writer http.ResponseWriter, params httprouter.Params, cp composeParameters) (arch distro.Arch, imageTypes, buildTypes []distro.ImageType, payloads []int, ok bool) {
+	// API v1 allows building several image types from the blueprint in a
+	// single compose, which has one image build for each of them
+	composeTypes := []string{cp.ComposeType}
+	if isRequestVersionAtLeast(params, 1) && len(cp.ComposeTypes) > 0 {
+		if cp.ComposeType != "" {
+			errors := responseError{
+				ID:  "BadCompose",
+				Msg: "compose_type and compose_types cannot be used together",
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return nil, nil, nil, nil, false
+		}
+		composeTypes = cp.ComposeTypes
+	}
+
+	if len(composeTypes) > 1 && cp.Upload != nil {
+		errors := responseError{
+			ID:  "BadCompose",
+			Msg: "upload cannot be used for composes of several image types, use uploads instead",
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return nil, nil, nil, nil, false
+	}
+
+	if isRequestVersionAtLeast(params, 1) {
+		for composeType := range cp.Uploads {
+			requested := false
+			for _, t := range composeTypes {
+				requested = requested || t == composeType
+			}
+			if !requested {
+				errors := responseError{
+					ID:  "BadCompose",
+					Msg: fmt.Sprintf("upload for compose type %s, which is not part of compose_types", composeType),
+				}
+				statusResponseError(writer, http.StatusBadRequest, errors)
+				return nil, nil, nil, nil, false
+			}
+		}
+	}
+
+	arch, ok = api.composeArch(writer, params, cp.Arch)
+	if !ok {
+		return nil, nil, nil, nil, false
+	}
+
+	for i, composeType := range composeTypes {
+		for _, other := range composeTypes[:i] {
+			if other == composeType {
+				errors := responseError{
+					ID:  "BadCompose",
+					Msg: fmt.Sprintf("Duplicate compose type: %s", composeType),
+				}
+				statusResponseError(writer, http.StatusBadRequest, errors)
+				return nil, nil, nil, nil, false
+			}
+		}
+
+		imageType, err := arch.GetImageType(composeType)
+		if err != nil {
+			errors := responseError{
+				ID:  "UnknownComposeType",
+				Msg: fmt.Sprintf("Unknown compose type for architecture: %s", composeType),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return nil, nil, nil, nil, false
+		}
+		imageTypes = append(imageTypes, imageType)
+	}
+
+	if !checkCheckpoints(writer, params, cp.Checkpoints) {
+		return nil, nil, nil, nil, false
+	}
+
+	if !checkOSTree(writer, params, cp.OSTree) {
+		return nil, nil, nil, nil, false
+	}
+
+	// Installers embed the image of another image type, which is built by an
+	// additional image build that the installer's job depends on. Those image
+	// builds come after the requested ones.
+	buildTypes = append([]distro.ImageType{}, imageTypes...)
+	payloads = make([]int, len(imageTypes))
+	for i, imageType := range imageTypes {
+		payloads[i] = -1
+		installer, ok := imageType.(distro.InstallerImageType)
+		if !ok {
+			continue
+		}
+
+		payloadType, err := arch.GetImageType(installer.PayloadImageType())
+		if err != nil {
+			errors := responseError{
+				ID:  "UnknownComposeType",
+				Msg: fmt.Sprintf("Unknown payload type of %s: %s", imageType.Name(), installer.PayloadImageType()),
+			}
+			statusResponseError(writer, http.StatusInternalServerError, errors)
+			return nil, nil, nil, nil, false
+		}
+		payloads[i] = len(buildTypes)
+		buildTypes = append(buildTypes, payloadType)
+		payloads = append(payloads, -1)
+	}
+
+	return arch, imageTypes, buildTypes, payloads, true
+}
+
+// composeManifests depsolves `bp` for each of `buildTypes`, or takes the
+// packages of `pinned` if it isn't nil, and creates the manifests of the
+// image builds of the compose `composeID`. Uploads are only added to the
+// first `requested` image builds, which are the ones that were asked for.
+// It writes an error response and returns false if that fails. Everything
+// that can fail because of the request is checked for all image builds
+// before any of them is queued.
+func (api *API) composeManifests(writer http.ResponseWriter, params httprouter.Params, bp *blueprint.Blueprint, cp composeParameters, arch distro.Arch, repos []rpmmd.RepoConfig, pinned *compose.Compose, buildTypes []distro.ImageType, payloads []int, requested int, composeID uuid.UUID) ([]composeImageBuild, []compose.Warning, map[string]string, bool) {
+	var builds []composeImageBuild
+	var warnings []compose.Warning
+	if pinned != nil {
+		warnings = pinnedWarnings(bp, pinned)
+	}
+	checksums := make(map[string]string)
+	for i, imageType := range buildTypes {
+		build := composeImageBuild{imageType: imageType, size: imageType.Size(cp.Size), payload: payloads[i]}
+
+		if isRequestVersionAtLeast(params, 1) && i < requested {
+			upload := cp.Upload
+			if cp.Uploads[imageType.Name()] != nil {
+				upload = cp.Uploads[imageType.Name()]
+			}
+			if upload != nil {
+				build.targets = append(build.targets, uploadRequestToTarget(*upload, imageType.Filename()))
+			}
+		}
+
+		build.targets = append(build.targets, target.NewLocalTarget(
+			&target.LocalTargetOptions{
+				ComposeId:    composeID,
+				ImageBuildId: i,
+				Filename:     imageType.Filename(),
+				Checkpoints:  cp.Checkpoints,
+			},
+		))
+
+		// The blueprint is applied to the payload, not to the installer
+		buildBp := bp
+		customizations := bp.Customizations
+		if build.payload >= 0 {
+			buildBp = &blueprint.Blueprint{Name: bp.Name}
+			customizations = nil
+		}
+
+		var repoChecksums map[string]string
+		var err error
+		if pinned != nil {
+			build.packages, build.buildPackages, repoChecksums, err = pinnedPackages(pinned, i, imageType, arch)
+			if err != nil {
+				errors := responseError{
+					ID:  "BadCompose",
+					Msg: fmt.Sprintf("Cannot reuse the packages of compose %s: %v", cp.PackagesFrom, err),
+				}
+				statusResponseError(writer, http.StatusBadRequest, errors)
+				return nil, nil, nil, false
+			}
+		} else {
+			build.packages, build.buildPackages, repoChecksums, err = api.depsolveBlueprintForArch(buildBp, arch, imageType)
+		}
+		if err != nil {
+			errors := responseError{
+				ID:  "DepsolveError",
+				Msg: err.Error(),
+			}
+			statusResponseError(writer, http.StatusInternalServerError, errors)
+			return nil, nil, nil, false
+		}
+		for id, checksum := range repoChecksums {
+			checksums[id] = checksum
+		}
+
+		options := distro.ImageOptions{Size: build.size}
+		if cp.OSTree != nil {
+			options.OSTree = *cp.OSTree
+		}
+		build.manifest, err = imageType.Manifest(customizations, repos, build.packages, build.buildPackages, options)
+		if err != nil {
+			errors := responseError{
+				ID:  "ManifestCreationFailed",
+				Msg: fmt.Sprintf("failed to create osbuild manifest: %v", err),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return nil, nil, nil, false
+		}
+
+		for _, w := range composeWarnings(buildBp, build.packages, build.targets) {
+			if !containsWarning(warnings, w) {
+				warnings = append(warnings, w)
+			}
+		}
+
+		builds = append(builds, build)
+	}
+
+	return builds, warnings, checksums, true
+}
+
+// admit asks the admission controller whether a compose may be started and
+// writes an error response if it may not. Every decision is logged.
+func (api *API) admit(writer http.ResponseWriter, request *http.Request, bp *blueprint.Blueprint, arch distro.Arch, imageType distro.ImageType, size uint64) bool {
+	if api.admission == nil {
+		return true
+	}
+
+	req := &admission.Request{
+		Blueprint: bp,
+		Distro:    api.distro.Name(),
+		Arch:      arch.Name(),
+		ImageType: imageType.Name(),
+		Size:      size,
+		Client:    request.RemoteAddr,
+	}
+	if identity := auth.IdentityFromContext(request.Context()); identity != nil {
+		req.Client = identity.Name
+	}
+	for _, repo := range api.systemRepositories(arch) {
+		url := repo.BaseURL
+		if url == "" {
+			url = repo.Metalink
+		}
+		if url == "" {
+			url = repo.MirrorList
+		}
+		req.Repositories = append(req.Repositories, admission.Repository{Name: repo.Id, URL: url, System: true})
+	}
+	for _, source := range api.store.GetAllSources() {
+		req.Repositories = append(req.Repositories, admission.Repository{Name: source.Name, URL: source.URL, System: source.System})
+	}
+
+	admitted, reasons := admission.Check(api.admission, req)
+	if admitted {
+		return true
+	}
+
+	errors := responseError{
+		ID:  "ComposeDenied",
+		Msg: fmt.Sprintf("compose denied: %s", reasons),
+	}
+	statusResponseError(writer, http.StatusForbidden, errors)
+	return false
+}
+
+// composePublishHandler adds a finished compose to the image gallery, which
+// lists and serves its image without authentication.
+func (api *API) composePublishHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	uuidString := params.ByName("uuid")
+	id, err := uuid.Parse(uuidString)
+	if err != nil {
+		errors := responseError{
+			ID:  "UnknownUUID",
+			Msg: fmt.Sprintf("%s is not a valid build uuid", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	composeInfo, exists := api.store.GetCompose(id)
+	if !exists {
+		errors := responseError{
+			ID:  "UnknownUUID",
+			Msg: fmt.Sprintf("Compose %s doesn't exist", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	state, _, _, _ := api.getComposeState(composeInfo)
+	if state != common.CFinished {
+		errors := responseError{
+			ID:  "BuildInWrongState",
+			Msg: fmt.Sprintf("Build %s is in wrong state: %s", uuidString, state.ToString()),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	err = api.store.PublishCompose(id)
+	if err != nil {
+		errors := responseError{
+			ID:  "BuildMissingFile",
+			Msg: fmt.Sprintf("Cannot publish build %s: %v", uuidString, err),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	statusResponseOK(writer)
+}
+
+func (api *API) composeUnpublishHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	uuidString := params.ByName("uuid")
+	id, err := uuid.Parse(uuidString)
+	if err != nil {
+		errors := responseError{
+			ID:  "UnknownUUID",
+			Msg: fmt.Sprintf("%s is not a valid build uuid", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	err = api.store.UnpublishCompose(id)
+	if err != nil {
+		errors := responseError{
+			ID:  "UnknownUUID",
+			Msg: fmt.Sprintf("Compose %s doesn't exist", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	statusResponseOK(writer)
+}
+
+func (api *API) composeDeleteHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 0) {
+		return
+	}
+
+	type composeDeleteStatus struct {
+		UUID   uuid.UUID `json:"uuid"`
+		Status bool      `json:"status"`
+	}
+
+	uuidsParam := params.ByName("uuids")
+
+	results := []composeDeleteStatus{}
+	errors := []responseError{}
+	uuidStrings := strings.Split(uuidsParam, ",")
+	for _, uuidString := range uuidStrings {
+		id, err := uuid.Parse(uuidString)
+		if err != nil {
+			errors = append(errors, responseError{
+				ID:  "UnknownUUID",
+				Msg: fmt.Sprintf("%s is not a valid uuid", uuidString),
+			})
+			continue
+		}
+
+		compose, exists := api.store.GetCompose(id)
+		if !exists {
+			errors = append(errors, responseError{
+				ID:  "UnknownUUID",
+				Msg: fmt.Sprintf("compose %s doesn't exist", id),
+			})
+			continue
+		}
+
+		state, _, _, _ := api.getComposeState(compose)
+		if state != common.CFinished && state != common.CFailed {
+			errors = append(errors, responseError{
+				ID:  "BuildInWrongState",
+				Msg: fmt.Sprintf("Compose %s is not in FINISHED or FAILED.", id),
+			})
+			continue
+		}
+
+		err = api.store.DeleteCompose(id)
+		if err != nil {
+			errors = append(errors, responseError{
+				ID:  "ComposeError",
+				Msg: fmt.Sprintf("%s: %s", id, err.Error()),
+			})
+			continue
+		}
+
+		events.Emit(events.ComposeDeleted, fmt.Sprintf("Compose %s deleted", id), "COMPOSE_ID", id.String())
+
+		results = append(results, composeDeleteStatus{id, true})
+	}
+
+	reply := struct {
+		UUIDs  []composeDeleteStatus `json:"uuids"`
+		Errors []responseError       `json:"errors"`
+	}{results, setErrorCodes(errors, http.StatusOK)}
+
+	err := json.NewEncoder(writer).Encode(reply)
+	common.PanicOnError(err)
+}
 
 func (api *API) composeTypesHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
 	if !verifyRequestVersion(writer, params, 0) {
@@ -1672,9 +2566,17 @@ func (api *API) composeQueueHandler(writer http.ResponseWriter, request *http.Re
 		state, queued, started, finished := api.getComposeState(compose)
 		switch state {
 		case common.CWaiting:
-			reply.New = append(reply.New, composeToComposeEntry(id, compose, common.CWaiting, queued, started, finished, includeUploads))
+			entry := composeToComposeEntry(id, compose, common.CWaiting, queued, started, finished, includeUploads)
+			if includeUploads {
+				entry.Progress = api.workers.ComposeProgress(compose)
+			}
+			reply.New = append(reply.New, entry)
 		case common.CRunning:
-			reply.Run = append(reply.Run, composeToComposeEntry(id, compose, common.CRunning, queued, started, finished, includeUploads))
+			entry := composeToComposeEntry(id, compose, common.CRunning, queued, started, finished, includeUploads)
+			if includeUploads {
+				entry.Progress = api.workers.ComposeProgress(compose)
+			}
+			reply.Run = append(reply.Run, entry)
 		}
 	}
 
@@ -1682,6 +2584,94 @@ func (api *API) composeQueueHandler(writer http.ResponseWriter, request *http.Re
 	common.PanicOnError(err)
 }
 
+// composeQueueJobsHandler lists the jobs which have not finished yet, with
+// the composes they belong to and the positions of pending jobs. Unlike
+// compose/queue, it also shows jobs that don't build images, like scans and
+// uploads. Tenants other than the default tenant only see the jobs of their
+// own composes.
+func (api *API) composeQueueJobsHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	type queuedJobEntry struct {
+		ID         uuid.UUID              `json:"id"`
+		Type       string                 `json:"type"`
+		Status     common.ImageBuildState `json:"status"`
+		Position   int                    `json:"position,omitempty"`
+		ComposeID  *uuid.UUID             `json:"compose_id,omitempty"`
+		JobCreated float64                `json:"job_created,omitempty"`
+		JobStarted float64                `json:"job_started,omitempty"`
+		WaitTime   float64                `json:"wait_time"`
+		Worker     string                 `json:"worker,omitempty"`
+	}
+
+	reply := struct {
+		Depth int              `json:"depth"`
+		Jobs  []queuedJobEntry `json:"jobs"`
+	}{0, []queuedJobEntry{}}
+
+	jobs, err := api.workers.QueuedJobs()
+	if err != nil {
+		errors := responseError{
+			ID:  "ComposeError",
+			Msg: err.Error(),
+		}
+		statusResponseError(writer, http.StatusInternalServerError, errors)
+		return
+	}
+
+	composeIDs := make(map[uuid.UUID]uuid.UUID)
+	for id, compose := range api.store.GetAllComposes() {
+		for _, ib := range compose.ImageBuilds {
+			for _, jobId := range []uuid.UUID{ib.JobId, ib.ScanJobId, ib.SignJobId, ib.UploadJobId} {
+				if jobId != uuid.Nil {
+					composeIDs[jobId] = id
+				}
+			}
+		}
+	}
+
+	now := time.Now()
+	for _, job := range jobs {
+		entry := queuedJobEntry{
+			ID:       job.Id,
+			Type:     job.Type,
+			Status:   common.IBWaiting,
+			Position: job.Position,
+			Worker:   job.Worker,
+		}
+		if composeID, exists := composeIDs[job.Id]; exists {
+			entry.ComposeID = &composeID
+		} else if api.store.Tenant() != "" {
+			// tenants only see the jobs of their own composes
+			continue
+		}
+
+		waitedUntil := now
+		if job.Status == jobqueue.JobRunning {
+			entry.Status = common.IBRunning
+			waitedUntil = job.StartedAt
+		} else {
+			reply.Depth++
+		}
+		if !job.QueuedAt.IsZero() {
+			entry.JobCreated = float64(job.QueuedAt.UnixNano()) / 1000000000
+			if waitedUntil.After(job.QueuedAt) {
+				entry.WaitTime = waitedUntil.Sub(job.QueuedAt).Seconds()
+			}
+		}
+		if !job.StartedAt.IsZero() {
+			entry.JobStarted = float64(job.StartedAt.UnixNano()) / 1000000000
+		}
+
+		reply.Jobs = append(reply.Jobs, entry)
+	}
+
+	err = json.NewEncoder(writer).Encode(reply)
+	common.PanicOnError(err)
+}
+
 func (api *API) composeStatusHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
 	// TODO: lorax has some params: /api/v0/compose/status/<uuids>[?blueprint=<blueprint_name>&status=<compose_status>&type=<compose_type>]
 	if !verifyRequestVersion(writer, params, 0) {
@@ -1741,7 +2731,7 @@ func (api *API) composeStatusHandler(writer http.ResponseWriter, request *http.R
 			continue
 		} else if filterStatus != "" && state.ToString() != filterStatus {
 			continue
-		} else if filterImageTypeExists && compose.ImageBuilds[0].ImageType != filterImageType {
+		} else if filterImageTypeExists && !hasImageType(compose, filterImageType) {
 			continue
 		}
 		filteredUUIDs = append(filteredUUIDs, id)
@@ -1752,7 +2742,11 @@ func (api *API) composeStatusHandler(writer http.ResponseWriter, request *http.R
 	for _, id := range filteredUUIDs {
 		if compose, exists := composes[id]; exists {
 			state, queued, started, finished := api.getComposeState(compose)
-			reply.UUIDs = append(reply.UUIDs, composeToComposeEntry(id, compose, state, queued, started, finished, includeUploads))
+			entry := composeToComposeEntry(id, compose, state, queued, started, finished, includeUploads)
+			if includeUploads {
+				entry.Progress = api.workers.ComposeProgress(compose)
+			}
+			reply.UUIDs = append(reply.UUIDs, entry)
 		}
 	}
 	sortComposeEntries(reply.UUIDs)
@@ -1777,7 +2771,7 @@ func (api *API) composeInfoHandler(writer http.ResponseWriter, request *http.Req
 		return
 	}
 
-	compose, exists := api.store.GetCompose(id)
+	composeInfo, exists := api.store.GetCompose(id)
 
 	if !exists {
 		errors := responseError{
@@ -1802,22 +2796,81 @@ func (api *API) composeInfoHandler(writer http.ResponseWriter, request *http.Req
 		QueueStatus string               `json:"queue_status"`
 		ImageSize   uint64               `json:"image_size"`
 		Uploads     []uploadResponse     `json:"uploads,omitempty"`
+		Warnings    []compose.Warning    `json:"warnings,omitempty"`
+		Publication *compose.Publication `json:"publication,omitempty"`
+
+		InventoryExport *compose.InventoryExport `json:"inventory_export,omitempty"`
+		Scan            *worker.ScanJobResult    `json:"scan,omitempty"`
+		Signature       *worker.SignJobResult    `json:"signature,omitempty"`
+		Promotions      []compose.Promotion      `json:"promotions,omitempty"`
+		Registration    *compose.Registration    `json:"registration,omitempty"`
+		Conversion      *compose.Conversion      `json:"conversion,omitempty"`
+		ImageBuilds     []imageBuildInfo         `json:"image_builds,omitempty"`
+		RebuildOf       *uuid.UUID               `json:"rebuild_of,omitempty"`
 	}
 
 	reply.ID = id
-	reply.Blueprint = compose.Blueprint
+	reply.Blueprint = composeInfo.Blueprint
 	reply.Deps = Dependencies{
 		Packages: make([]map[string]interface{}, 0),
 	}
 	// Weldr API assumes only one image build per compose, that's why only the
-	// 1st build is considered
-	state, _, _, _ := api.getComposeState(compose)
-	reply.ComposeType, _ = compose.ImageBuilds[0].ImageType.ToCompatString()
+	// 1st build is considered. API v1 lists all of them in `image_builds`
+	// for composes of several image types.
+	state, _, _, _ := api.getComposeState(composeInfo)
+	reply.ComposeType, _ = composeInfo.ImageBuilds[0].ImageType.ToCompatString()
 	reply.QueueStatus = state.ToString()
-	reply.ImageSize = compose.ImageBuilds[0].Size
+	reply.ImageSize = composeInfo.ImageBuilds[0].Size
+	reply.Warnings = composeInfo.Warnings
 
 	if isRequestVersionAtLeast(params, 1) {
-		reply.Uploads = targetsToUploadResponses(compose.ImageBuilds[0].Targets)
+		reply.Uploads = targetsToUploadResponses(composeInfo.ImageBuilds[0].Targets)
+		reply.Publication = composeInfo.Publication
+		reply.InventoryExport = composeInfo.InventoryExport
+		reply.Promotions = composeInfo.Promotions
+		reply.Registration = composeInfo.Registration
+		reply.Conversion = composeInfo.Conversion
+		if composeInfo.RebuildOf != uuid.Nil {
+			reply.RebuildOf = &composeInfo.RebuildOf
+		}
+
+		if len(composeInfo.ImageBuilds) > 1 {
+			for i, ib := range composeInfo.ImageBuilds {
+				ibState, _, _, _ := api.workers.ImageBuildState(composeInfo, i)
+				composeType, _ := ib.ImageType.ToCompatString()
+				reply.ImageBuilds = append(reply.ImageBuilds, imageBuildInfo{
+					ID:          i,
+					ComposeType: composeType,
+					QueueStatus: ibState.ToString(),
+					ImageSize:   ib.Size,
+					Checksum:    ib.Checksum,
+				})
+			}
+		}
+
+		if scanJobId := composeInfo.ImageBuilds[0].ScanJobId; scanJobId != uuid.Nil {
+			reply.Scan, err = api.workers.ScanResult(scanJobId)
+			if err != nil {
+				errors := responseError{
+					ID:  "ComposeError",
+					Msg: fmt.Sprintf("cannot get scan result: %v", err),
+				}
+				statusResponseError(writer, http.StatusInternalServerError, errors)
+				return
+			}
+		}
+
+		if signJobId := composeInfo.ImageBuilds[0].SignJobId; signJobId != uuid.Nil {
+			reply.Signature, err = api.workers.SignResult(signJobId)
+			if err != nil {
+				errors := responseError{
+					ID:  "ComposeError",
+					Msg: fmt.Sprintf("cannot get signing result: %v", err),
+				}
+				statusResponseError(writer, http.StatusInternalServerError, errors)
+				return
+			}
+		}
 	}
 
 	err = json.NewEncoder(writer).Encode(reply)
@@ -1829,38 +2882,265 @@ func (api *API) composeImageHandler(writer http.ResponseWriter, request *http.Re
 		return
 	}
 
+	image, ok := api.finishedImage(writer, request, params)
+	if !ok {
+		return
+	}
+
+	// API v1 can download images compressed with any supported method
+	compression := ""
+	if isRequestVersionAtLeast(params, 1) {
+		compression = request.URL.Query().Get("compression")
+	}
+	if compression != "" && !common.IsCompression(compression) {
+		errors := responseError{
+			ID:  "UnknownCompression",
+			Msg: fmt.Sprintf("Unknown compression: %s", compression),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+	if compression != "" && !common.CompressionAvailable(compression) {
+		errors := responseError{
+			ID:  "CompressionUnavailable",
+			Msg: fmt.Sprintf("Compression %s is not available on this server", compression),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	reader, _, err := api.store.GetImageBuildImage(image.composeID, image.imageBuildID)
+
+	// TODO: this might return misleading error
+	if err != nil {
+		errors := responseError{
+			ID:  "BuildMissingFile",
+			Msg: fmt.Sprintf("Build %s is missing file %s!", image.composeID, image.name),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+	defer reader.Close()
+
+	writer.Header().Set("Vary", "Accept-Encoding")
+
+	// Compressed images are downloaded as compressed files, while images
+	// sent with a content encoding are decompressed by the client
+	if compression != "" {
+		filename := image.filename() + common.CompressionExtension(compression)
+		writer.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+		writer.Header().Set("Content-Type", common.CompressionMIMEType(compression))
+		api.sendCompressed(writer, reader, compression, image)
+		return
+	}
+
+	writer.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": image.filename()}))
+	writer.Header().Set("Content-Type", image.mime)
+
+	if encoding := acceptedEncoding(request); encoding != "" {
+		writer.Header().Set("Content-Encoding", encoding)
+		api.sendCompressed(writer, reader, encoding, image)
+		return
+	}
+
+	// Images of composes that were signed carry their checksum, which
+	// also identifies them for caches
+	if checksum, err := api.store.GetImageBuildChecksum(image.composeID, image.imageBuildID); err == nil {
+		if digest, err := hex.DecodeString(strings.TrimPrefix(checksum, "sha256:")); err == nil {
+			writer.Header().Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(digest))
+			writer.Header().Set("ETag", `"`+checksum+`"`)
+		}
+	}
+
+	// ServeContent answers conditional and range requests, so that
+	// interrupted downloads can be resumed
+	http.ServeContent(writer, request, image.filename(), image.finished, reader)
+}
+
+// acceptedEncoding returns the compression that the client prefers for the
+// content encoding of an image, or "" if it accepts none or requests a
+// range. Ranges refer to the uncompressed image, so that resuming works.
+// Compressions whose tool isn't installed are skipped.
+func acceptedEncoding(request *http.Request) string {
+	if request.Header.Get("Range") != "" {
+		return ""
+	}
+
+	accepted := make(map[string]bool)
+	for _, value := range request.Header["Accept-Encoding"] {
+		for _, coding := range strings.Split(value, ",") {
+			parts := strings.Split(coding, ";")
+			name := strings.ToLower(strings.TrimSpace(parts[0]))
+			q := 1.0
+			for _, param := range parts[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					q, _ = strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				}
+			}
+			accepted[name] = q > 0
+		}
+	}
+
+	for _, encoding := range []string{common.CompressionZstd, common.CompressionGzip} {
+		if accepted[encoding] && common.CompressionAvailable(encoding) {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// sendCompressed sends the image in `reader` compressed with `compression`,
+// while compressing it. The compressed size isn't known in advance, so the
+// response has no length and doesn't support ranges.
+func (api *API) sendCompressed(writer http.ResponseWriter, reader io.Reader, compression string, image composeImage) {
+	compressor, err := common.NewCompressor(writer, compression)
+	if err != nil {
+		writer.Header().Del("Content-Encoding")
+		errors := responseError{
+			ID:  "ComposeError",
+			Msg: fmt.Sprintf("Cannot compress image of build %s: %v", image.composeID, err),
+		}
+		statusResponseError(writer, http.StatusInternalServerError, errors)
+		return
+	}
+
+	_, err = io.Copy(compressor, reader)
+	if cerr := compressor.Close(); err == nil {
+		err = cerr
+	}
+
+	// The response has started, so clients notice errors by the
+	// compressed data ending early
+	if err != nil && api.logger != nil {
+		api.logger.Printf("cannot send image of compose %s: %v", image.composeID, err)
+	}
+}
+
+// composeImageChecksumHandler returns the checksum of a signed image in the
+// format of sha256sum, so that the downloaded image can be checked with
+// `sha256sum -c`.
+func (api *API) composeImageChecksumHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 0) {
+		return
+	}
+
+	image, ok := api.finishedImage(writer, request, params)
+	if !ok {
+		return
+	}
+
+	checksum, err := api.store.GetImageBuildChecksum(image.composeID, image.imageBuildID)
+	if err != nil || !strings.HasPrefix(checksum, "sha256:") {
+		errors := responseError{
+			ID:  "BuildMissingFile",
+			Msg: fmt.Sprintf("Image of build %s was not signed", image.composeID),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	writer.Header().Set("Content-Disposition", "attachment; filename="+image.filename()+".sha256")
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(writer, "%s  %s\n", strings.TrimPrefix(checksum, "sha256:"), image.filename())
+}
+
+// composeImageSignatureHandler returns the detached signature of a signed
+// image.
+func (api *API) composeImageSignatureHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 0) {
+		return
+	}
+
+	image, ok := api.finishedImage(writer, request, params)
+	if !ok {
+		return
+	}
+
+	signature, err := api.store.GetImageBuildSignature(image.composeID, image.imageBuildID)
+	if err != nil {
+		errors := responseError{
+			ID:  "BuildMissingFile",
+			Msg: fmt.Sprintf("Image of build %s was not signed", image.composeID),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	writer.Header().Set("Content-Disposition", "attachment; filename="+image.filename()+".sig")
+	writer.Header().Set("Content-Type", "application/octet-stream")
+	writer.Header().Set("Content-Length", fmt.Sprintf("%d", len(signature)))
+
+	_, err = writer.Write(signature)
+	common.PanicOnError(err)
+}
+
+// A composeImage is the image of a finished image build.
+type composeImage struct {
+	composeID    uuid.UUID
+	imageBuildID int
+	name         string
+	mime         string
+	finished     time.Time
+}
+
+// filename returns the name under which the image is downloaded.
+func (image composeImage) filename() string {
+	return image.composeID.String() + "-" + image.name
+}
+
+// finishedImage returns the image of the compose in `params`. API v1 can
+// select the image build in the `image_build` query parameter. It writes an
+// error and returns false if the image build doesn't exist or hasn't
+// finished.
+func (api *API) finishedImage(writer http.ResponseWriter, request *http.Request, params httprouter.Params) (composeImage, bool) {
 	uuidString := params.ByName("uuid")
-	uuid, err := uuid.Parse(uuidString)
+	id, err := uuid.Parse(uuidString)
 	if err != nil {
 		errors := responseError{
 			ID:  "UnknownUUID",
 			Msg: fmt.Sprintf("%s is not a valid build uuid", uuidString),
 		}
 		statusResponseError(writer, http.StatusBadRequest, errors)
-		return
+		return composeImage{}, false
 	}
 
-	compose, exists := api.store.GetCompose(uuid)
+	compose, exists := api.store.GetCompose(id)
 	if !exists {
 		errors := responseError{
 			ID:  "UnknownUUID",
 			Msg: fmt.Sprintf("Compose %s doesn't exist", uuidString),
 		}
 		statusResponseError(writer, http.StatusBadRequest, errors)
-		return
+		return composeImage{}, false
 	}
 
-	state, _, _, _ := api.getComposeState(compose)
+	// API v1 can download the images of all image builds of composes of
+	// several image types
+	imageBuildID := 0
+	if isRequestVersionAtLeast(params, 1) && request.URL.Query().Get("image_build") != "" {
+		imageBuildID, err = strconv.Atoi(request.URL.Query().Get("image_build"))
+		if err != nil || imageBuildID < 0 || imageBuildID >= len(compose.ImageBuilds) {
+			errors := responseError{
+				ID:  "BadCompose",
+				Msg: fmt.Sprintf("Compose %s has no image build %s", uuidString, request.URL.Query().Get("image_build")),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return composeImage{}, false
+		}
+	}
+
+	state, _, _, finished := api.workers.ImageBuildState(compose, imageBuildID)
 	if state != common.CFinished {
 		errors := responseError{
 			ID:  "BuildInWrongState",
 			Msg: fmt.Sprintf("Build %s is in wrong state: %s", uuidString, state.ToString()),
 		}
 		statusResponseError(writer, http.StatusBadRequest, errors)
-		return
+		return composeImage{}, false
 	}
 
-	imageBuild := compose.ImageBuilds[0]
+	imageBuild := compose.ImageBuilds[imageBuildID]
 	imageType, _ := imageBuild.ImageType.ToCompatString()
 	imageTypeStruct, err := api.arch.GetImageType(imageType)
 	if err != nil {
@@ -1870,29 +3150,16 @@ func (api *API) composeImageHandler(writer http.ResponseWriter, request *http.Re
 				uuidString, imageBuild.ImageType, api.distro.Name(), api.arch.Name()),
 		}
 		statusResponseError(writer, http.StatusInternalServerError, errors)
-		return
-	}
-	imageName := imageTypeStruct.Filename()
-	imageMime := imageTypeStruct.MIMEType()
-
-	reader, fileSize, err := api.store.GetImageBuildImage(uuid, 0)
-
-	// TODO: this might return misleading error
-	if err != nil {
-		errors := responseError{
-			ID:  "BuildMissingFile",
-			Msg: fmt.Sprintf("Build %s is missing file %s!", uuidString, imageName),
-		}
-		statusResponseError(writer, http.StatusBadRequest, errors)
-		return
+		return composeImage{}, false
 	}
 
-	writer.Header().Set("Content-Disposition", "attachment; filename="+uuid.String()+"-"+imageName)
-	writer.Header().Set("Content-Type", imageMime)
-	writer.Header().Set("Content-Length", fmt.Sprintf("%d", fileSize))
-
-	_, err = io.Copy(writer, reader)
-	common.PanicOnError(err)
+	return composeImage{
+		composeID:    id,
+		imageBuildID: imageBuildID,
+		name:         imageTypeStruct.Filename(),
+		mime:         imageTypeStruct.MIMEType(),
+		finished:     finished,
+	}, true
 }
 
 func (api *API) composeLogsHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
@@ -2100,7 +3367,9 @@ func (api *API) composeFailedHandler(writer http.ResponseWriter, request *http.R
 }
 
 func (api *API) fetchPackageList() (rpmmd.PackageList, error) {
-	packages, _, err := api.rpmmd.FetchMetadata(api.allRepositories(), api.distro.ModulePlatformID(), api.arch.Name())
+	repos := api.allRepositories()
+	packages, _, err := api.rpmmd.FetchMetadata(repos, api.distro.ModulePlatformID(), api.arch.Name())
+	api.recordSourceFetch(repos, err)
 	return packages, err
 }
 
@@ -2115,17 +3384,46 @@ func getPkgNameGlob(pkg blueprint.Package) string {
 	return pkg.Name
 }
 
+// composeRepositories returns the repositories to record for a compose whose
+// packages were resolved from `repos`, with the metadata `checksums` that
+// depsolving returned.
+func composeRepositories(repos []rpmmd.RepoConfig, checksums map[string]string) []compose.Repository {
+	result := make([]compose.Repository, 0, len(repos))
+	for _, repo := range repos {
+		result = append(result, compose.Repository{RepoConfig: repo, Checksum: checksums[repo.Id]})
+	}
+	return result
+}
+
 // Returns all configured repositories (base + sources) as rpmmd.RepoConfig
 func (api *API) allRepositories() []rpmmd.RepoConfig {
-	repos := append([]rpmmd.RepoConfig{}, api.repos...)
-	for _, source := range api.store.GetAllSources() {
-		repos = append(repos, source.RepoConfig())
+	return api.archRepositories(api.arch)
+}
+
+// withKernel returns a copy of `packages` in which "kernel" is replaced by
+// `kernel`, to install another kernel package than the image type's default.
+func withKernel(packages []string, kernel string) []string {
+	result := make([]string, len(packages))
+	for i, pkg := range packages {
+		if pkg == "kernel" {
+			pkg = kernel
+		}
+		result[i] = pkg
 	}
-	return repos
+	return result
 }
 
-func (api *API) depsolveBlueprint(bp *blueprint.Blueprint, imageType distro.ImageType) ([]rpmmd.PackageSpec, []rpmmd.PackageSpec, error) {
-	repos := api.allRepositories()
+// depsolveBlueprint returns the packages of `bp` and, if `imageType` is not
+// nil, the packages needed to build it. It also returns the checksums of the
+// metadata of the repositories, by repository id.
+func (api *API) depsolveBlueprint(bp *blueprint.Blueprint, imageType distro.ImageType) ([]rpmmd.PackageSpec, []rpmmd.PackageSpec, map[string]string, error) {
+	return api.depsolveBlueprintForArch(bp, api.arch, imageType)
+}
+
+// depsolveBlueprintForArch is like depsolveBlueprint, but resolves the
+// packages for `arch`, to which `imageType` must belong.
+func (api *API) depsolveBlueprintForArch(bp *blueprint.Blueprint, arch distro.Arch, imageType distro.ImageType) ([]rpmmd.PackageSpec, []rpmmd.PackageSpec, map[string]string, error) {
+	repos := api.archRepositories(arch)
 	var specs []string = []string{}
 	for _, pkg := range bp.Packages {
 		specs = append(specs, getPkgNameGlob(pkg))
@@ -2138,25 +3436,26 @@ func (api *API) depsolveBlueprint(bp *blueprint.Blueprint, imageType distro.Imag
 		// When the output type is known, include the base packages in the depsolve
 		// transaction.
 		packages, excludePackages := imageType.BasePackages()
-		specs = append(specs, packages...)
+		specs = append(specs, withKernel(packages, bp.Customizations.GetKernelName())...)
 		excludeSpecs = append(excludePackages, excludeSpecs...)
 	}
 
-	packages, _, err := api.rpmmd.Depsolve(specs, excludeSpecs, repos, api.distro.ModulePlatformID(), api.arch.Name())
+	packages, checksums, err := api.rpmmd.Depsolve(specs, excludeSpecs, bp.GetEnabledModules(), repos, api.distro.ModulePlatformID(), arch.Name())
+	api.recordSourceFetch(repos, err)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	buildPackages := []rpmmd.PackageSpec{}
 	if imageType != nil {
 		buildSpecs := imageType.BuildPackages()
-		buildPackages, _, err = api.rpmmd.Depsolve(buildSpecs, nil, repos, api.distro.ModulePlatformID(), api.arch.Name())
+		buildPackages, _, err = api.rpmmd.Depsolve(buildSpecs, nil, nil, repos, api.distro.ModulePlatformID(), arch.Name())
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 	}
 
-	return packages, buildPackages, err
+	return packages, buildPackages, checksums, err
 }
 
 func (api *API) uploadsScheduleHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
@@ -2,29 +2,56 @@ package weldr
 
 import (
 	"archive/tar"
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"io"
+	"io/ioutil"
 	"math/rand"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/osbuild/osbuild-composer/internal/common"
 	"github.com/osbuild/osbuild-composer/internal/compose"
 	"github.com/osbuild/osbuild-composer/internal/target"
+	"github.com/osbuild/osbuild-composer/internal/upload"
 
+	"github.com/osbuild/osbuild-composer/internal/admission"
+	"github.com/osbuild/osbuild-composer/internal/audit"
+	"github.com/osbuild/osbuild-composer/internal/auth"
 	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/config"
 	test_distro "github.com/osbuild/osbuild-composer/internal/distro/fedoratest"
+	"github.com/osbuild/osbuild-composer/internal/events"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue/testjobqueue"
 	rpmmd_mock "github.com/osbuild/osbuild-composer/internal/mocks/rpmmd"
+	"github.com/osbuild/osbuild-composer/internal/osbuild"
+	"github.com/osbuild/osbuild-composer/internal/quota"
 	"github.com/osbuild/osbuild-composer/internal/rpmmd"
 	"github.com/osbuild/osbuild-composer/internal/store"
 	"github.com/osbuild/osbuild-composer/internal/test"
+	"github.com/osbuild/osbuild-composer/internal/worker"
 
 	"github.com/BurntSushi/toml"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
 )
 
@@ -51,15 +78,15 @@ func TestBasic(t *testing.T) {
 
 		{"/api/v0/projects/source/list", http.StatusOK, `{"sources":["test-id"]}`},
 
-		{"/api/v0/projects/source/info", http.StatusNotFound, `{"errors":[{"code":404,"id":"HTTPError","msg":"Not Found"}],"status":false}`},
-		{"/api/v0/projects/source/info/", http.StatusNotFound, `{"errors":[{"code":404,"id":"HTTPError","msg":"Not Found"}],"status":false}`},
-		{"/api/v0/projects/source/info/foo", http.StatusOK, `{"errors":[{"id":"UnknownSource","msg":"foo is not a valid source"}],"sources":{}}`},
+		{"/api/v0/projects/source/info", http.StatusNotFound, `{"errors":[{"code":404,"id":"HTTPError","error_code":"NOT_FOUND","msg":"Not Found"}],"status":false}`},
+		{"/api/v0/projects/source/info/", http.StatusNotFound, `{"errors":[{"code":404,"id":"HTTPError","error_code":"NOT_FOUND","msg":"Not Found"}],"status":false}`},
+		{"/api/v0/projects/source/info/foo", http.StatusOK, `{"errors":[{"id":"UnknownSource","error_code":"SOURCE_NOT_FOUND","msg":"foo is not a valid source"}],"sources":{}}`},
 		{"/api/v0/projects/source/info/test-id", http.StatusOK, `{"sources":{"test-id":{"name":"test-id","type":"yum-baseurl","url":"http://example.com/test/os/x86_64","check_gpg":true,"check_ssl":true,"system":true}},"errors":[]}`},
 		{"/api/v0/projects/source/info/*", http.StatusOK, `{"sources":{"test-id":{"name":"test-id","type":"yum-baseurl","url":"http://example.com/test/os/x86_64","check_gpg":true,"check_ssl":true,"system":true}},"errors":[]}`},
 
 		{"/api/v0/blueprints/list", http.StatusOK, `{"total":1,"offset":0,"limit":1,"blueprints":["test"]}`},
-		{"/api/v0/blueprints/info/", http.StatusNotFound, `{"errors":[{"code":404,"id":"HTTPError","msg":"Not Found"}],"status":false}`},
-		{"/api/v0/blueprints/info/foo", http.StatusOK, `{"blueprints":[],"changes":[],"errors":[{"id":"UnknownBlueprint","msg":"foo: "}]}`},
+		{"/api/v0/blueprints/info/", http.StatusNotFound, `{"errors":[{"code":404,"id":"HTTPError","error_code":"NOT_FOUND","msg":"Not Found"}],"status":false}`},
+		{"/api/v0/blueprints/info/foo", http.StatusOK, `{"blueprints":[],"changes":[],"errors":[{"id":"UnknownBlueprint","error_code":"BLUEPRINT_NOT_FOUND","msg":"foo: "}]}`},
 	}
 
 	for _, c := range cases {
@@ -78,8 +105,8 @@ func TestBlueprintsNew(t *testing.T) {
 	}{
 		{"POST", "/api/v0/blueprints/new", `{"name":"test","description":"Test","packages":[],"version":""}`, http.StatusOK, `{"status":true}`},
 		{"POST", "/api/v0/blueprints/new", `{"name":"test","description":"Test","packages":[{"name":"httpd","version":"2.4.*"}],"version":"0.0.0"}`, http.StatusOK, `{"status":true}`},
-		{"POST", "/api/v0/blueprints/new", `{"name":"test","description":"Test","packages:}`, http.StatusBadRequest, `{"status":false,"errors":[{"id":"BlueprintsError","msg":"400 Bad Request: The browser (or proxy) sent a request that this server could not understand: unexpected EOF"}]}`},
-		{"POST", "/api/v0/blueprints/new", ``, http.StatusBadRequest, `{"status":false,"errors":[{"id":"BlueprintsError","msg":"Missing blueprint"}]}`},
+		{"POST", "/api/v0/blueprints/new", `{"name":"test","description":"Test","packages:}`, http.StatusBadRequest, `{"status":false,"errors":[{"id":"BlueprintsError","error_code":"INVALID_REQUEST","msg":"400 Bad Request: The browser (or proxy) sent a request that this server could not understand: unexpected EOF"}]}`},
+		{"POST", "/api/v0/blueprints/new", ``, http.StatusBadRequest, `{"status":false,"errors":[{"id":"BlueprintsError","error_code":"INVALID_REQUEST","msg":"Missing blueprint"}]}`},
 	}
 
 	for _, c := range cases {
@@ -151,8 +178,8 @@ func TestBlueprintsWorkspaceJSON(t *testing.T) {
 		ExpectedJSON   string
 	}{
 		{"POST", "/api/v0/blueprints/workspace", `{"name":"test","description":"Test","packages":[{"name":"systemd","version":"123"}],"version":"0.0.0"}`, http.StatusOK, `{"status":true}`},
-		{"POST", "/api/v0/blueprints/workspace", `{"name":"test","description":"Test","packages:}`, http.StatusBadRequest, `{"status":false,"errors":[{"id":"BlueprintsError","msg":"400 Bad Request: The browser (or proxy) sent a request that this server could not understand: unexpected EOF"}]}`},
-		{"POST", "/api/v0/blueprints/workspace", ``, http.StatusBadRequest, `{"status":false,"errors":[{"id":"BlueprintsError","msg":"Missing blueprint"}]}`},
+		{"POST", "/api/v0/blueprints/workspace", `{"name":"test","description":"Test","packages:}`, http.StatusBadRequest, `{"status":false,"errors":[{"id":"BlueprintsError","error_code":"INVALID_REQUEST","msg":"400 Bad Request: The browser (or proxy) sent a request that this server could not understand: unexpected EOF"}]}`},
+		{"POST", "/api/v0/blueprints/workspace", ``, http.StatusBadRequest, `{"status":false,"errors":[{"id":"BlueprintsError","error_code":"INVALID_REQUEST","msg":"Missing blueprint"}]}`},
 	}
 
 	for _, c := range cases {
@@ -227,7 +254,7 @@ func TestBlueprintsInfo(t *testing.T) {
 		"changes":[{"name":"test1","changed":false}], "errors":[]}`},
 		{"GET", "/api/v0/blueprints/info/test2", ``, http.StatusOK, `{"blueprints":[{"name":"test2","description":"Test","modules":[],"packages":[{"name":"systemd","version":"123"}],"groups":[],"version":"0.0.0"}],
 		"changes":[{"name":"test2","changed":true}], "errors":[]}`},
-		{"GET", "/api/v0/blueprints/info/test3-non", ``, http.StatusOK, `{"blueprints":[],"changes":[],"errors":[{"id":"UnknownBlueprint","msg":"test3-non: "}]}`},
+		{"GET", "/api/v0/blueprints/info/test3-non", ``, http.StatusOK, `{"blueprints":[],"changes":[],"errors":[{"id":"UnknownBlueprint","error_code":"BLUEPRINT_NOT_FOUND","msg":"test3-non: "}]}`},
 	}
 
 	for _, c := range cases {
@@ -342,6 +369,19 @@ func TestBlueprintsFreeze(t *testing.T) {
 	}
 }
 
+func TestBlueprintsErrorCodes(t *testing.T) {
+	api, _ := createWeldrAPI(rpmmd_mock.NonExistingPackage)
+	test.SendHTTP(api, false, "POST", "/api/v0/blueprints/new", `{"name":"test","description":"Test","packages":[{"name":"fash","version":"*"}],"version":"0.0.0"}`)
+
+	test.TestRoute(t, api, false, "POST", "/api/v0/blueprints/undo/test/FFFF", ``, http.StatusBadRequest, `{"status":false,"errors":[{"id":"BlueprintsError","error_code":"COMMIT_NOT_FOUND","msg":"Unknown commit"}]}`)
+	test.TestRoute(t, api, false, "POST", "/api/v0/blueprints/undo/octopus/FFFF", ``, http.StatusBadRequest, `{"status":false,"errors":[{"id":"BlueprintsError","error_code":"BLUEPRINT_NOT_FOUND","msg":"Unknown blueprint"}]}`)
+	test.TestRoute(t, api, false, "POST", "/api/v0/blueprints/tag/octopus", ``, http.StatusBadRequest, `{"status":false,"errors":[{"id":"BlueprintsError","error_code":"BLUEPRINT_NOT_FOUND","msg":"Unknown blueprint"}]}`)
+
+	// errors of single blueprints in successful responses have codes, too
+	test.TestRoute(t, api, false, "GET", "/api/v0/blueprints/freeze/test", ``, http.StatusOK, `{"blueprints":[],"errors":[{"id":"BlueprintsError","error_code":"DEPSOLVE_FAILED"}]}`, "msg")
+	test.TestRoute(t, api, false, "GET", "/api/v0/blueprints/freeze/octopus", ``, http.StatusOK, `{"blueprints":[],"errors":[{"id":"UnknownBlueprint","error_code":"BLUEPRINT_NOT_FOUND","msg":"octopus: blueprint_not_found"}]}`)
+}
+
 func TestBlueprintsDiff(t *testing.T) {
 	var cases = []struct {
 		Method         string
@@ -371,7 +411,7 @@ func TestBlueprintsDelete(t *testing.T) {
 		ExpectedJSON   string
 	}{
 		{"DELETE", "/api/v0/blueprints/delete/test", ``, http.StatusOK, `{"status":true}`},
-		{"DELETE", "/api/v0/blueprints/delete/test3-non", ``, http.StatusBadRequest, `{"status":false,"errors":[{"id":"BlueprintsError","msg":"Unknown blueprint: test3-non"}]}`},
+		{"DELETE", "/api/v0/blueprints/delete/test3-non", ``, http.StatusBadRequest, `{"status":false,"errors":[{"id":"BlueprintsError","error_code":"BLUEPRINT_NOT_FOUND","msg":"Unknown blueprint: test3-non"}]}`},
 	}
 
 	for _, c := range cases {
@@ -389,7 +429,7 @@ func TestBlueprintsChanges(t *testing.T) {
 	ignoreFields := []string{"commit", "timestamp"}
 
 	test.SendHTTP(api, true, "POST", "/api/v0/blueprints/new", `{"name":"`+id+`","description":"Test","packages":[{"name":"httpd","version":"2.4.*"}],"version":"0.0.0"}`)
-	test.TestRoute(t, api, true, "GET", "/api/v0/blueprints/changes/failing"+id, ``, http.StatusOK, `{"blueprints":[],"errors":[{"id":"UnknownBlueprint","msg":"failing`+id+`"}],"limit":20,"offset":0}`, ignoreFields...)
+	test.TestRoute(t, api, true, "GET", "/api/v0/blueprints/changes/failing"+id, ``, http.StatusOK, `{"blueprints":[],"errors":[{"id":"UnknownBlueprint","error_code":"BLUEPRINT_NOT_FOUND","msg":"failing`+id+`"}],"limit":20,"offset":0}`, ignoreFields...)
 	test.TestRoute(t, api, true, "GET", "/api/v0/blueprints/changes/"+id, ``, http.StatusOK, `{"blueprints":[{"changes":[{"commit":"","message":"Recipe `+id+`, version 0.0.0 saved.","revision":null,"timestamp":""}],"name":"`+id+`","total":1}],"errors":[],"limit":20,"offset":0}`, ignoreFields...)
 	test.SendHTTP(api, true, "DELETE", "/api/v0/blueprints/delete/"+id, ``)
 	test.SendHTTP(api, true, "POST", "/api/v0/blueprints/new", `{"name":"`+id+`","description":"Test","packages":[{"name":"httpd","version":"2.4.*"}],"version":"0.0.0"}`)
@@ -420,8 +460,8 @@ func TestBlueprintsDepsolve(t *testing.T) {
 		ExpectedJSON   string
 	}{
 		{rpmmd_mock.BaseFixture, http.StatusOK, `{"blueprints":[{"blueprint":{"name":"test","description":"Test","version":"0.0.1","packages":[{"name":"dep-package1","version":"*"}],"groups":[],"modules":[{"name":"dep-package3","version":"*"}]},"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]}],"errors":[]}`},
-		{rpmmd_mock.NonExistingPackage, http.StatusBadRequest, `{"status":false,"errors":[{"id":"BlueprintsError","msg":"test: DNF error occured: MarkingErrors: Error occurred when marking packages for installation: Problems in request:\nmissing packages: fash"}]}`},
-		{rpmmd_mock.BadDepsolve, http.StatusBadRequest, `{"status":false,"errors":[{"id":"BlueprintsError","msg":"test: DNF error occured: DepsolveError: There was a problem depsolving ['go2rpm']: \n Problem: conflicting requests\n  - nothing provides askalono-cli needed by go2rpm-1-4.fc31.noarch"}]}`},
+		{rpmmd_mock.NonExistingPackage, http.StatusBadRequest, `{"status":false,"errors":[{"id":"BlueprintsError","error_code":"DEPSOLVE_FAILED","msg":"test: DNF error occured: MarkingErrors: Error occurred when marking packages for installation: Problems in request:\nmissing packages: fash"}]}`},
+		{rpmmd_mock.BadDepsolve, http.StatusBadRequest, `{"status":false,"errors":[{"id":"BlueprintsError","error_code":"DEPSOLVE_FAILED","msg":"test: DNF error occured: DepsolveError: There was a problem depsolving ['go2rpm']: \n Problem: conflicting requests\n  - nothing provides askalono-cli needed by go2rpm-1-4.fc31.noarch"}]}`},
 	}
 
 	for _, c := range cases {
@@ -432,7 +472,26 @@ func TestBlueprintsDepsolve(t *testing.T) {
 	}
 }
 
+func TestBlueprintsSize(t *testing.T) {
+	api, _ := createWeldrAPI(rpmmd_mock.BaseFixture)
+	test.SendHTTP(api, false, "POST", "/api/v0/blueprints/new", `{"name":"test","description":"Test","packages":[{"name":"dep-package1","version":"*"}],"version":"0.0.0"}`)
+
+	test.TestRoute(t, api, false, "GET", "/api/v1/blueprints/size/test?type=qcow2", ``, http.StatusOK, `{"blueprint":"test","compose_type":"qcow2","minimum_size":0,"default_size":0}`)
+	test.TestRoute(t, api, false, "GET", "/api/v1/blueprints/size/test?type=foo", ``, http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownComposeType","error_code":"UNKNOWN_IMAGE_TYPE","msg":"Unknown compose type for architecture: foo"}]}`)
+	test.TestRoute(t, api, false, "GET", "/api/v1/blueprints/size/octopus?type=qcow2", ``, http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownBlueprint","error_code":"BLUEPRINT_NOT_FOUND","msg":"octopus: blueprint not found"}]}`)
+	test.TestRoute(t, api, false, "GET", "/api/v0/blueprints/size/test?type=qcow2", ``, http.StatusNotFound, `{"status":false,"errors":[{"code":404,"id":"HTTPError","error_code":"NOT_FOUND","msg":"Not Found"}]}`)
+}
+
 func TestCompose(t *testing.T) {
+	// the depsolved packages of the fixture
+	expectedPackages := []rpmmd.PackageSpec{
+		{Name: "dep-package3", Epoch: 7, Version: "3.0.3", Release: "1.fc30", Arch: "x86_64"},
+		{Name: "dep-package1", Version: "1.33", Release: "2.fc30", Arch: "x86_64"},
+		{Name: "dep-package2", Version: "2.9", Release: "1.fc30", Arch: "x86_64"},
+	}
+	expectedRepositories := []compose.Repository{
+		{RepoConfig: rpmmd.RepoConfig{Id: "test-id", BaseURL: "http://example.com/test/os/x86_64"}},
+	}
 	expectedComposeLocal := &compose.Compose{
 		Blueprint: &blueprint.Blueprint{
 			Name:           "test",
@@ -444,8 +503,10 @@ func TestCompose(t *testing.T) {
 		},
 		ImageBuilds: []compose.ImageBuild{
 			{
-				QueueStatus: common.IBWaiting,
-				ImageType:   common.Qcow2Generic,
+				QueueStatus:   common.IBWaiting,
+				ImageType:     common.Qcow2Generic,
+				Packages:      expectedPackages,
+				BuildPackages: expectedPackages,
 				Targets: []*target.Target{
 					{
 						// skip Uuid and Created fields - they are ignored
@@ -457,6 +518,7 @@ func TestCompose(t *testing.T) {
 				},
 			},
 		},
+		Repositories: expectedRepositories,
 	}
 	expectedComposeLocalAndAws := &compose.Compose{
 		Blueprint: &blueprint.Blueprint{
@@ -469,8 +531,10 @@ func TestCompose(t *testing.T) {
 		},
 		ImageBuilds: []compose.ImageBuild{
 			{
-				QueueStatus: common.IBWaiting,
-				ImageType:   common.Qcow2Generic,
+				QueueStatus:   common.IBWaiting,
+				ImageType:     common.Qcow2Generic,
+				Packages:      expectedPackages,
+				BuildPackages: expectedPackages,
 				Targets: []*target.Target{
 					{
 						Name:      "org.osbuild.aws",
@@ -495,6 +559,7 @@ func TestCompose(t *testing.T) {
 				},
 			},
 		},
+		Repositories: expectedRepositories,
 	}
 
 	var cases = []struct {
@@ -507,9 +572,12 @@ func TestCompose(t *testing.T) {
 		ExpectedCompose *compose.Compose
 		IgnoreFields    []string
 	}{
-		{true, "POST", "/api/v0/compose", `{"blueprint_name": "http-server","compose_type": "qcow2","branch": "master"}`, http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownBlueprint","msg":"Unknown blueprint name: http-server"}]}`, nil, []string{"build_id"}},
+		{true, "POST", "/api/v0/compose", `{"blueprint_name": "http-server","compose_type": "qcow2","branch": "master"}`, http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownBlueprint","error_code":"BLUEPRINT_NOT_FOUND","msg":"Unknown blueprint name: http-server"}]}`, nil, []string{"build_id"}},
 		{false, "POST", "/api/v0/compose", `{"blueprint_name": "test","compose_type": "qcow2","branch": "master"}`, http.StatusOK, `{"status": true}`, expectedComposeLocal, []string{"build_id"}},
 		{false, "POST", "/api/v1/compose", `{"blueprint_name": "test","compose_type":"qcow2","branch":"master","upload":{"image_name":"test_upload","provider":"aws","settings":{"region":"frankfurt","accessKeyID":"accesskey","secretAccessKey":"secretkey","bucket":"clay","key":"imagekey"}}}`, http.StatusOK, `{"status": true}`, expectedComposeLocalAndAws, []string{"build_id"}},
+		{false, "POST", "/api/v0/compose", `{"blueprint_name": "test","compose_type": "qcow2","branch": "master","priority": "normal"}`, http.StatusOK, `{"status": true}`, expectedComposeLocal, []string{"build_id"}},
+		{false, "POST", "/api/v0/compose", `{"blueprint_name": "test","compose_type": "qcow2","branch": "master","priority": "high"}`, http.StatusForbidden, `{"status":false,"errors":[{"id":"PermissionDenied","error_code":"FORBIDDEN","msg":"only privileged clients may request high priority composes"}]}`, nil, []string{"build_id"}},
+		{false, "POST", "/api/v0/compose", `{"blueprint_name": "test","compose_type": "qcow2","branch": "master","priority": "urgent"}`, http.StatusBadRequest, `{"status":false,"errors":[{"id":"BadCompose","error_code":"INVALID_REQUEST","msg":"Unknown priority: urgent"}]}`, nil, []string{"build_id"}},
 	}
 
 	for _, c := range cases {
@@ -552,9 +620,9 @@ func TestComposeDelete(t *testing.T) {
 	}{
 		{"/api/v0/compose/delete/30000000-0000-0000-0000-000000000002", `{"uuids":[{"uuid":"30000000-0000-0000-0000-000000000002","status":true}],"errors":[]}`, []string{"30000000-0000-0000-0000-000000000000", "30000000-0000-0000-0000-000000000001", "30000000-0000-0000-0000-000000000003"}},
 		{"/api/v0/compose/delete/30000000-0000-0000-0000-000000000002,30000000-0000-0000-0000-000000000003", `{"uuids":[{"uuid":"30000000-0000-0000-0000-000000000002","status":true},{"uuid":"30000000-0000-0000-0000-000000000003","status":true}],"errors":[]}`, []string{"30000000-0000-0000-0000-000000000000", "30000000-0000-0000-0000-000000000001"}},
-		{"/api/v0/compose/delete/30000000-0000-0000-0000-000000000003,30000000-0000-0000-0000-000000000000", `{"uuids":[{"uuid":"30000000-0000-0000-0000-000000000003","status":true}],"errors":[{"id":"BuildInWrongState","msg":"Compose 30000000-0000-0000-0000-000000000000 is not in FINISHED or FAILED."}]}`, []string{"30000000-0000-0000-0000-000000000000", "30000000-0000-0000-0000-000000000001", "30000000-0000-0000-0000-000000000002"}},
-		{"/api/v0/compose/delete/30000000-0000-0000-0000-000000000003,30000000-0000-0000-0000", `{"uuids":[{"uuid":"30000000-0000-0000-0000-000000000003","status":true}],"errors":[{"id":"UnknownUUID","msg":"30000000-0000-0000-0000 is not a valid uuid"}]}`, []string{"30000000-0000-0000-0000-000000000000", "30000000-0000-0000-0000-000000000001", "30000000-0000-0000-0000-000000000002"}},
-		{"/api/v0/compose/delete/30000000-0000-0000-0000-000000000003,42000000-0000-0000-0000-000000000000", `{"uuids":[{"uuid":"30000000-0000-0000-0000-000000000003","status":true}],"errors":[{"id":"UnknownUUID","msg":"compose 42000000-0000-0000-0000-000000000000 doesn't exist"}]}`, []string{"30000000-0000-0000-0000-000000000000", "30000000-0000-0000-0000-000000000001", "30000000-0000-0000-0000-000000000002"}},
+		{"/api/v0/compose/delete/30000000-0000-0000-0000-000000000003,30000000-0000-0000-0000-000000000000", `{"uuids":[{"uuid":"30000000-0000-0000-0000-000000000003","status":true}],"errors":[{"id":"BuildInWrongState","error_code":"COMPOSE_WRONG_STATE","msg":"Compose 30000000-0000-0000-0000-000000000000 is not in FINISHED or FAILED."}]}`, []string{"30000000-0000-0000-0000-000000000000", "30000000-0000-0000-0000-000000000001", "30000000-0000-0000-0000-000000000002"}},
+		{"/api/v0/compose/delete/30000000-0000-0000-0000-000000000003,30000000-0000-0000-0000", `{"uuids":[{"uuid":"30000000-0000-0000-0000-000000000003","status":true}],"errors":[{"id":"UnknownUUID","error_code":"COMPOSE_NOT_FOUND","msg":"30000000-0000-0000-0000 is not a valid uuid"}]}`, []string{"30000000-0000-0000-0000-000000000000", "30000000-0000-0000-0000-000000000001", "30000000-0000-0000-0000-000000000002"}},
+		{"/api/v0/compose/delete/30000000-0000-0000-0000-000000000003,42000000-0000-0000-0000-000000000000", `{"uuids":[{"uuid":"30000000-0000-0000-0000-000000000003","status":true}],"errors":[{"id":"UnknownUUID","error_code":"COMPOSE_NOT_FOUND","msg":"compose 42000000-0000-0000-0000-000000000000 doesn't exist"}]}`, []string{"30000000-0000-0000-0000-000000000000", "30000000-0000-0000-0000-000000000001", "30000000-0000-0000-0000-000000000002"}},
 	}
 
 	for _, c := range cases {
@@ -585,7 +653,7 @@ func TestComposeStatus(t *testing.T) {
 		{rpmmd_mock.BaseFixture, "GET", "/api/v0/compose/status/*?name=test", ``, http.StatusOK, `{"uuids":[{"id":"30000000-0000-0000-0000-000000000000","blueprint":"test","version":"0.0.0","compose_type":"qcow2","image_size":0,"queue_status":"WAITING","job_created":1574857140},{"id":"30000000-0000-0000-0000-000000000001","blueprint":"test","version":"0.0.0","compose_type":"qcow2","image_size":0,"queue_status":"RUNNING","job_created":1574857140,"job_started":1574857140},{"id":"30000000-0000-0000-0000-000000000002","blueprint":"test","version":"0.0.0","compose_type":"qcow2","image_size":0,"queue_status":"FINISHED","job_created":1574857140,"job_started":1574857140,"job_finished":1574857140},{"id":"30000000-0000-0000-0000-000000000003","blueprint":"test","version":"0.0.0","compose_type":"qcow2","image_size":0,"queue_status":"FAILED","job_created":1574857140,"job_started":1574857140,"job_finished":1574857140}]}`},
 		{rpmmd_mock.BaseFixture, "GET", "/api/v0/compose/status/*?status=FINISHED", ``, http.StatusOK, `{"uuids":[{"id":"30000000-0000-0000-0000-000000000002","blueprint":"test","version":"0.0.0","compose_type":"qcow2","image_size":0,"queue_status":"FINISHED","job_created":1574857140,"job_started":1574857140,"job_finished":1574857140}]}`},
 		{rpmmd_mock.BaseFixture, "GET", "/api/v0/compose/status/*?type=qcow2", ``, http.StatusOK, `{"uuids":[{"id":"30000000-0000-0000-0000-000000000000","blueprint":"test","version":"0.0.0","compose_type":"qcow2","image_size":0,"queue_status":"WAITING","job_created":1574857140},{"id":"30000000-0000-0000-0000-000000000001","blueprint":"test","version":"0.0.0","compose_type":"qcow2","image_size":0,"queue_status":"RUNNING","job_created":1574857140,"job_started":1574857140},{"id":"30000000-0000-0000-0000-000000000002","blueprint":"test","version":"0.0.0","compose_type":"qcow2","image_size":0,"queue_status":"FINISHED","job_created":1574857140,"job_started":1574857140,"job_finished":1574857140},{"id":"30000000-0000-0000-0000-000000000003","blueprint":"test","version":"0.0.0","compose_type":"qcow2","image_size":0,"queue_status":"FAILED","job_created":1574857140,"job_started":1574857140,"job_finished":1574857140}]}`},
-		{rpmmd_mock.BaseFixture, "GET", "/api/v1/compose/status/30000000-0000-0000-0000-000000000000", ``, http.StatusOK, `{"uuids":[{"id":"30000000-0000-0000-0000-000000000000","blueprint":"test","version":"0.0.0","compose_type":"qcow2","image_size":0,"queue_status":"WAITING","job_created":1574857140,"uploads":[{"uuid":"10000000-0000-0000-0000-000000000000","status":"WAITING","provider_name":"aws","image_name":"awsimage","creation_time":1574857140,"settings":{"region":"frankfurt","accessKeyID":"accesskey","secretAccessKey":"secretkey","bucket":"clay","key":"imagekey"}}]}]}`},
+		{rpmmd_mock.BaseFixture, "GET", "/api/v1/compose/status/30000000-0000-0000-0000-000000000000", ``, http.StatusOK, `{"uuids":[{"id":"30000000-0000-0000-0000-000000000000","blueprint":"test","version":"0.0.0","compose_type":"qcow2","image_size":0,"queue_status":"WAITING","job_created":1574857140,"progress":{"percent":0,"phases":[{"phase":"build","state":"WAITING","percent":0,"weight":100}]},"uploads":[{"uuid":"10000000-0000-0000-0000-000000000000","status":"WAITING","provider_name":"aws","image_name":"awsimage","creation_time":1574857140,"settings":{"region":"frankfurt","accessKeyID":"accesskey","secretAccessKey":"secretkey","bucket":"clay","key":"imagekey"}}]}]}`},
 	}
 
 	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
@@ -598,6 +666,41 @@ func TestComposeStatus(t *testing.T) {
 	}
 }
 
+func TestComposeStatusChecksum(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	dir, err := ioutil.TempDir("", "weldr-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// checksums are recorded when images are stored
+	fixture := rpmmd_mock.NoComposesFixture()
+	arch, err := test_distro.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+	s := store.New(&dir)
+	api := New(rpmmd_mock.NewRPMMDMock(fixture), arch, test_distro.New(), nil, nil, s, fixture.Workers)
+
+	id := uuid.New()
+	targets := []*target.Target{
+		target.NewLocalTarget(&target.LocalTargetOptions{Filename: imageType.Filename()}),
+	}
+	err = s.PushTestCompose(id, nil, imageType, &blueprint.Blueprint{Name: "test", Version: "0.0.1"}, 0, targets, nil, true)
+	require.NoError(t, err)
+
+	sum := sha256.Sum256([]byte("image"))
+	checksum := "sha256:" + hex.EncodeToString(sum[:])
+	err = s.AddImageToImageUpload(id, 0, strings.NewReader("image"), checksum)
+	require.NoError(t, err)
+
+	test.TestRoute(t, api, false, "GET", "/api/v0/compose/status/"+id.String(), ``, http.StatusOK,
+		`{"uuids":[{"id":"`+id.String()+`","blueprint":"test","version":"0.0.1","compose_type":"qcow2","image_size":0,"queue_status":"FINISHED","checksum":"`+checksum+`"}]}`,
+		"job_created", "job_started", "job_finished")
+}
+
 func TestComposeInfo(t *testing.T) {
 	var cases = []struct {
 		Fixture        rpmmd_mock.FixtureGenerator
@@ -609,8 +712,8 @@ func TestComposeInfo(t *testing.T) {
 	}{
 		{rpmmd_mock.BaseFixture, "GET", "/api/v0/compose/info/30000000-0000-0000-0000-000000000000", ``, http.StatusOK, `{"id":"30000000-0000-0000-0000-000000000000","config":"","blueprint":{"name":"test","description":"","version":"0.0.0","packages":[],"modules":[],"groups":[]},"commit":"","deps":{"packages":[]},"compose_type":"qcow2","queue_status":"WAITING","image_size":0}`},
 		{rpmmd_mock.BaseFixture, "GET", "/api/v1/compose/info/30000000-0000-0000-0000-000000000000", ``, http.StatusOK, `{"id":"30000000-0000-0000-0000-000000000000","config":"","blueprint":{"name":"test","description":"","version":"0.0.0","packages":[],"modules":[],"groups":[]},"commit":"","deps":{"packages":[]},"compose_type":"qcow2","queue_status":"WAITING","image_size":0,"uploads":[{"uuid":"10000000-0000-0000-0000-000000000000","status":"WAITING","provider_name":"aws","image_name":"awsimage","creation_time":1574857140,"settings":{"region":"frankfurt","accessKeyID":"accesskey","secretAccessKey":"secretkey","bucket":"clay","key":"imagekey"}}]}`},
-		{rpmmd_mock.BaseFixture, "GET", "/api/v1/compose/info/30000000-0000-0000-0000", ``, http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownUUID","msg":"30000000-0000-0000-0000 is not a valid build uuid"}]}`},
-		{rpmmd_mock.BaseFixture, "GET", "/api/v1/compose/info/42000000-0000-0000-0000-000000000000", ``, http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownUUID","msg":"42000000-0000-0000-0000-000000000000 is not a valid build uuid"}]}`},
+		{rpmmd_mock.BaseFixture, "GET", "/api/v1/compose/info/30000000-0000-0000-0000", ``, http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownUUID","error_code":"COMPOSE_NOT_FOUND","msg":"30000000-0000-0000-0000 is not a valid build uuid"}]}`},
+		{rpmmd_mock.BaseFixture, "GET", "/api/v1/compose/info/42000000-0000-0000-0000-000000000000", ``, http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownUUID","error_code":"COMPOSE_NOT_FOUND","msg":"42000000-0000-0000-0000-000000000000 is not a valid build uuid"}]}`},
 	}
 
 	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
@@ -664,9 +767,9 @@ func TestComposeLogs(t *testing.T) {
 		Path         string
 		ExpectedJSON string
 	}{
-		{"/api/v1/compose/logs/30000000-0000-0000-0000", `{"status":false,"errors":[{"id":"UnknownUUID","msg":"30000000-0000-0000-0000 is not a valid build uuid"}]}`},
-		{"/api/v1/compose/logs/42000000-0000-0000-0000-000000000000", `{"status":false,"errors":[{"id":"UnknownUUID","msg":"Compose 42000000-0000-0000-0000-000000000000 doesn't exist"}]}`},
-		{"/api/v1/compose/logs/30000000-0000-0000-0000-000000000000", `{"status":false,"errors":[{"id":"BuildInWrongState","msg":"Build 30000000-0000-0000-0000-000000000000 not in FINISHED or FAILED state."}]}`},
+		{"/api/v1/compose/logs/30000000-0000-0000-0000", `{"status":false,"errors":[{"id":"UnknownUUID","error_code":"COMPOSE_NOT_FOUND","msg":"30000000-0000-0000-0000 is not a valid build uuid"}]}`},
+		{"/api/v1/compose/logs/42000000-0000-0000-0000-000000000000", `{"status":false,"errors":[{"id":"UnknownUUID","error_code":"COMPOSE_NOT_FOUND","msg":"Compose 42000000-0000-0000-0000-000000000000 doesn't exist"}]}`},
+		{"/api/v1/compose/logs/30000000-0000-0000-0000-000000000000", `{"status":false,"errors":[{"id":"BuildInWrongState","error_code":"COMPOSE_WRONG_STATE","msg":"Build 30000000-0000-0000-0000-000000000000 not in FINISHED or FAILED state."}]}`},
 	}
 
 	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
@@ -687,12 +790,12 @@ func TestComposeLog(t *testing.T) {
 		ExpectedStatus   int
 		ExpectedResponse string
 	}{
-		{rpmmd_mock.BaseFixture, "GET", "/api/v0/compose/log/30000000-0000-0000-0000-000000000000", http.StatusOK, `{"status":false,"errors":[{"id":"BuildInWrongState","msg":"Build 30000000-0000-0000-0000-000000000000 has not started yet. No logs to view."}]}` + "\n"},
+		{rpmmd_mock.BaseFixture, "GET", "/api/v0/compose/log/30000000-0000-0000-0000-000000000000", http.StatusOK, `{"status":false,"errors":[{"id":"BuildInWrongState","msg":"Build 30000000-0000-0000-0000-000000000000 has not started yet. No logs to view.","error_code":"COMPOSE_WRONG_STATE"}]}` + "\n"},
 		{rpmmd_mock.BaseFixture, "GET", "/api/v0/compose/log/30000000-0000-0000-0000-000000000001", http.StatusOK, `Running...` + "\n"},
 		{rpmmd_mock.BaseFixture, "GET", "/api/v0/compose/log/30000000-0000-0000-0000-000000000002", http.StatusOK, `The compose result is empty.` + "\n"},
 		{rpmmd_mock.BaseFixture, "GET", "/api/v1/compose/log/30000000-0000-0000-0000-000000000002", http.StatusOK, `The compose result is empty.` + "\n"},
-		{rpmmd_mock.BaseFixture, "GET", "/api/v1/compose/log/30000000-0000-0000-0000", http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownUUID","msg":"30000000-0000-0000-0000 is not a valid build uuid"}]}` + "\n"},
-		{rpmmd_mock.BaseFixture, "GET", "/api/v1/compose/log/42000000-0000-0000-0000-000000000000", http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownUUID","msg":"Compose 42000000-0000-0000-0000-000000000000 doesn't exist"}]}` + "\n"},
+		{rpmmd_mock.BaseFixture, "GET", "/api/v1/compose/log/30000000-0000-0000-0000", http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownUUID","msg":"30000000-0000-0000-0000 is not a valid build uuid","error_code":"COMPOSE_NOT_FOUND"}]}` + "\n"},
+		{rpmmd_mock.BaseFixture, "GET", "/api/v1/compose/log/42000000-0000-0000-0000-000000000000", http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownUUID","msg":"Compose 42000000-0000-0000-0000-000000000000 doesn't exist","error_code":"COMPOSE_NOT_FOUND"}]}` + "\n"},
 	}
 
 	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
@@ -715,7 +818,7 @@ func TestComposeQueue(t *testing.T) {
 		ExpectedJSON   string
 	}{
 		{rpmmd_mock.BaseFixture, "GET", "/api/v0/compose/queue", ``, http.StatusOK, `{"new":[{"blueprint":"test","version":"0.0.0","compose_type":"qcow2","image_size":0,"queue_status":"WAITING"}],"run":[{"blueprint":"test","version":"0.0.0","compose_type":"qcow2","image_size":0,"queue_status":"RUNNING"}]}`},
-		{rpmmd_mock.BaseFixture, "GET", "/api/v1/compose/queue", ``, http.StatusOK, `{"new":[{"blueprint":"test","version":"0.0.0","compose_type":"qcow2","image_size":0,"queue_status":"WAITING","uploads":[{"uuid":"10000000-0000-0000-0000-000000000000","status":"WAITING","provider_name":"aws","image_name":"awsimage","creation_time":1574857140,"settings":{"region":"frankfurt","accessKeyID":"accesskey","secretAccessKey":"secretkey","bucket":"clay","key":"imagekey"}}]}],"run":[{"blueprint":"test","version":"0.0.0","compose_type":"qcow2","image_size":0,"queue_status":"RUNNING"}]}`},
+		{rpmmd_mock.BaseFixture, "GET", "/api/v1/compose/queue", ``, http.StatusOK, `{"new":[{"blueprint":"test","version":"0.0.0","compose_type":"qcow2","image_size":0,"queue_status":"WAITING","progress":{"percent":0,"phases":[{"phase":"build","state":"WAITING","percent":0,"weight":100}]},"uploads":[{"uuid":"10000000-0000-0000-0000-000000000000","status":"WAITING","provider_name":"aws","image_name":"awsimage","creation_time":1574857140,"settings":{"region":"frankfurt","accessKeyID":"accesskey","secretAccessKey":"secretkey","bucket":"clay","key":"imagekey"}}]}],"run":[{"blueprint":"test","version":"0.0.0","compose_type":"qcow2","image_size":0,"queue_status":"RUNNING","progress":{"percent":95,"phases":[{"phase":"build","state":"RUNNING","percent":95,"weight":100}]}}]}`},
 		{rpmmd_mock.NoComposesFixture, "GET", "/api/v0/compose/queue", ``, http.StatusOK, `{"new":[],"run":[]}`},
 	}
 
@@ -729,6 +832,45 @@ func TestComposeQueue(t *testing.T) {
 	}
 }
 
+func TestComposeQueueJobs(t *testing.T) {
+	api, s := createWeldrAPI(rpmmd_mock.BaseFixture)
+
+	first, err := api.workers.Enqueue("fedora-30", "x86_64", &osbuild.Manifest{}, nil, nil, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+	second, err := api.workers.Enqueue("fedora-30", "x86_64", &osbuild.Manifest{}, nil, nil, jobqueue.PriorityHigh)
+	require.NoError(t, err)
+	composeID := uuid.New()
+	s.Composes[composeID] = compose.Compose{ImageBuilds: []compose.ImageBuild{{JobId: first}}}
+
+	test.TestRoute(t, api, false, "GET", "/api/v0/compose/queue/jobs", ``, http.StatusNotFound, `{"errors":[{"code":404,"id":"HTTPError","error_code":"NOT_FOUND","msg":"Not Found"}],"status":false}`)
+
+	resp := test.SendHTTP(api, false, "GET", "/api/v1/compose/queue/jobs", ``)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var reply struct {
+		Depth int `json:"depth"`
+		Jobs  []struct {
+			ID        uuid.UUID  `json:"id"`
+			Type      string     `json:"type"`
+			Status    string     `json:"status"`
+			Position  int        `json:"position"`
+			ComposeID *uuid.UUID `json:"compose_id"`
+		} `json:"jobs"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&reply)
+	require.NoError(t, err)
+
+	require.Equal(t, 2, reply.Depth)
+	require.Len(t, reply.Jobs, 2)
+	require.Equal(t, second, reply.Jobs[0].ID)
+	require.Equal(t, 1, reply.Jobs[0].Position)
+	require.Nil(t, reply.Jobs[0].ComposeID)
+	require.Equal(t, first, reply.Jobs[1].ID)
+	require.Equal(t, 2, reply.Jobs[1].Position)
+	require.Equal(t, "osbuild:x86_64/fedora-30", reply.Jobs[1].Type)
+	require.Equal(t, "WAITING", reply.Jobs[1].Status)
+	require.Equal(t, &composeID, reply.Jobs[1].ComposeID)
+}
+
 func TestComposeFinished(t *testing.T) {
 	var cases = []struct {
 		Fixture        rpmmd_mock.FixtureGenerator
@@ -785,9 +927,9 @@ func TestSourcesNew(t *testing.T) {
 		ExpectedStatus int
 		ExpectedJSON   string
 	}{
-		{"POST", "/api/v0/projects/source/new", ``, http.StatusBadRequest, `{"errors": [{"id": "ProjectsError","msg": "Missing source"}],"status":false}`},
+		{"POST", "/api/v0/projects/source/new", ``, http.StatusBadRequest, `{"errors": [{"id": "ProjectsError","error_code":"INVALID_REQUEST","msg": "Missing source"}],"status":false}`},
 		// Bad JSON, missing quote after name
-		{"POST", "/api/v0/projects/source/new", `{"name: "fish","url": "https://download.opensuse.org/repositories/shells:/fish:/release:/3/Fedora_29/","type": "yum-baseurl","check_ssl": false,"check_gpg": false}`, http.StatusBadRequest, `{"errors": [{"id": "ProjectsError","msg": "Problem parsing POST body: invalid character 'f' after object key"}],"status":false}`},
+		{"POST", "/api/v0/projects/source/new", `{"name: "fish","url": "https://download.opensuse.org/repositories/shells:/fish:/release:/3/Fedora_29/","type": "yum-baseurl","check_ssl": false,"check_gpg": false}`, http.StatusBadRequest, `{"errors": [{"id": "ProjectsError","error_code":"INVALID_REQUEST","msg": "Problem parsing POST body: invalid character 'f' after object key"}],"status":false}`},
 		{"POST", "/api/v0/projects/source/new", `{"name": "fish","url": "https://download.opensuse.org/repositories/shells:/fish:/release:/3/Fedora_29/","type": "yum-baseurl","check_ssl": false,"check_gpg": false}`, http.StatusOK, `{"status":true}`},
 	}
 
@@ -819,6 +961,138 @@ check_gpg = false
 	test.SendHTTP(api, true, "DELETE", "/api/v0/projects/source/delete/fish", ``)
 }
 
+func TestSourcesNewProxy(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, s := createWeldrAPI(rpmmd_mock.BaseFixture)
+
+	test.TestRoute(t, api, false, "POST", "/api/v0/projects/source/new",
+		`{"name":"corp","url":"https://repo.corp.example.com/","type":"yum-baseurl","check_ssl":true,"check_gpg":true,"proxy":"proxy.corp:3128"}`,
+		http.StatusBadRequest, `{"errors":[{"id":"ProjectsError","error_code":"INVALID_REQUEST","msg":"Problem parsing POST body: 'proxy' must be a URL: proxy.corp:3128"}],"status":false}`)
+	test.TestRoute(t, api, false, "POST", "/api/v0/projects/source/new",
+		`{"name":"corp","url":"https://repo.corp.example.com/","type":"yum-baseurl","check_ssl":true,"check_gpg":true,"sslcacert":"corp-ca.pem"}`,
+		http.StatusBadRequest, `{"errors":[{"id":"ProjectsError","error_code":"INVALID_REQUEST","msg":"Problem parsing POST body: 'sslcacert' must be an absolute path: corp-ca.pem"}],"status":false}`)
+	test.TestRoute(t, api, false, "POST", "/api/v0/projects/source/new",
+		`{"name":"corp","url":"https://repo.corp.example.com/","type":"yum-baseurl","check_ssl":true,"check_gpg":true,"sslclientcert":"/etc/pki/corp/client.pem"}`,
+		http.StatusBadRequest, `{"errors":[{"id":"ProjectsError","error_code":"INVALID_REQUEST","msg":"Problem parsing POST body: 'sslclientcert' and 'sslclientkey' must be given together"}],"status":false}`)
+
+	test.TestRoute(t, api, false, "POST", "/api/v0/projects/source/new",
+		`{"name":"corp","url":"https://repo.corp.example.com/","type":"yum-baseurl","check_ssl":true,"check_gpg":true,"proxy":"http://proxy.corp:3128","sslcacert":"/etc/pki/corp/ca.pem","sslclientcert":"/etc/pki/corp/client.pem","sslclientkey":"/etc/pki/corp/client.key"}`,
+		http.StatusOK, `{"status":true}`)
+	test.TestRoute(t, api, false, "GET", "/api/v0/projects/source/info/corp", ``, http.StatusOK,
+		`{"sources":{"corp":{"name":"corp","type":"yum-baseurl","url":"https://repo.corp.example.com/","check_gpg":true,"check_ssl":true,"system":false,"proxy":"http://proxy.corp:3128","sslcacert":"/etc/pki/corp/ca.pem","sslclientcert":"/etc/pki/corp/client.pem","sslclientkey":"/etc/pki/corp/client.key"}},"errors":[]}`)
+
+	source := s.GetSource("corp")
+	require.NotNil(t, source)
+	require.Equal(t, rpmmd.RepoConfig{
+		Id:            "corp",
+		BaseURL:       "https://repo.corp.example.com/",
+		Proxy:         "http://proxy.corp:3128",
+		SSLCACert:     "/etc/pki/corp/ca.pem",
+		SSLClientCert: "/etc/pki/corp/client.pem",
+		SSLClientKey:  "/etc/pki/corp/client.key",
+	}, source.RepoConfig())
+}
+
+func TestSourcesNewMirrors(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, s := createWeldrAPI(rpmmd_mock.BaseFixture)
+
+	test.TestRoute(t, api, false, "POST", "/api/v0/projects/source/new",
+		`{"name":"corp","url":"https://mirrors.corp.example.com/corp.repo","type":"yum-mirrorlist","check_ssl":true,"check_gpg":true,"mirrors":["https://repo2.corp.example.com/"]}`,
+		http.StatusBadRequest, `{"errors":[{"id":"ProjectsError","error_code":"INVALID_REQUEST","msg":"Problem parsing POST body: 'mirrors' are only supported by yum-baseurl sources"}],"status":false}`)
+	test.TestRoute(t, api, false, "POST", "/api/v0/projects/source/new",
+		`{"name":"corp","url":"https://repo.corp.example.com/","type":"yum-baseurl","check_ssl":true,"check_gpg":true,"mirrors":["repo2.corp.example.com"]}`,
+		http.StatusBadRequest, `{"errors":[{"id":"ProjectsError","error_code":"INVALID_REQUEST","msg":"Problem parsing POST body: 'mirrors' must be URLs: repo2.corp.example.com"}],"status":false}`)
+	test.TestRoute(t, api, false, "POST", "/api/v0/projects/source/new",
+		`{"name":"corp","url":"https://repo.corp.example.com/","type":"yum-baseurl","check_ssl":true,"check_gpg":true,"priority":100}`,
+		http.StatusBadRequest, `{"errors":[{"id":"ProjectsError","error_code":"INVALID_REQUEST","msg":"Problem parsing POST body: 'priority' must be between 1 and 99: 100"}],"status":false}`)
+
+	test.TestRoute(t, api, false, "POST", "/api/v0/projects/source/new",
+		`{"name":"corp","url":"https://repo.corp.example.com/","type":"yum-baseurl","check_ssl":true,"check_gpg":true,"mirrors":["https://repo2.corp.example.com/"],"priority":10,"cost":500}`,
+		http.StatusOK, `{"status":true}`)
+	test.TestRoute(t, api, false, "GET", "/api/v0/projects/source/info/corp", ``, http.StatusOK,
+		`{"sources":{"corp":{"name":"corp","type":"yum-baseurl","url":"https://repo.corp.example.com/","check_gpg":true,"check_ssl":true,"system":false,"mirrors":["https://repo2.corp.example.com/"],"priority":10,"cost":500}},"errors":[]}`)
+
+	source := s.GetSource("corp")
+	require.NotNil(t, source)
+	require.Equal(t, rpmmd.RepoConfig{
+		Id:       "corp",
+		BaseURL:  "https://repo.corp.example.com/",
+		Mirrors:  []string{"https://repo2.corp.example.com/"},
+		Priority: 10,
+		Cost:     500,
+	}, source.RepoConfig())
+}
+
+func TestSourcesGPGKeys(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	key := "-----BEGIN PGP PUBLIC KEY BLOCK-----\n\nmQINBFturGcBEAC\n-----END PGP PUBLIC KEY BLOCK-----\n"
+	otherKey := "-----BEGIN PGP PUBLIC KEY BLOCK-----\n\nmQINBFturGcBEAD\n-----END PGP PUBLIC KEY BLOCK-----\n"
+
+	keyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/RPM-GPG-KEY" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(otherKey))
+	}))
+	defer keyServer.Close()
+
+	api, s := createWeldrAPI(rpmmd_mock.BaseFixture)
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/projects/source/gpgkeys/new", `{"name":"corp","key":"not a key"}`, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"ProjectsError","error_code":"INVALID_REQUEST","msg":"gpg key corp is not an armored public key"}]}`)
+	test.TestRoute(t, api, false, "POST", "/api/v1/projects/source/gpgkeys/new", `{"name":"corp"}`, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"ProjectsError","error_code":"INVALID_REQUEST","msg":"Problem parsing POST body: exactly one of 'key' and 'url' must be given"}]}`)
+
+	body, err := json.Marshal(map[string]string{"name": "corp", "key": key})
+	require.NoError(t, err)
+	test.TestRoute(t, api, false, "POST", "/api/v1/projects/source/gpgkeys/new", string(body), http.StatusOK, `{"status":true,"name":"corp"}`)
+
+	// sources refer to stored keys by name, or bring their own
+	otherName := store.GPGKeyName(otherKey)
+	test.TestRoute(t, api, false, "POST", "/api/v0/projects/source/new",
+		`{"name":"corp","url":"https://repo.corp.example.com/","type":"yum-baseurl","check_ssl":true,"check_gpg":true,"gpgkeys":["unknown"]}`,
+		http.StatusBadRequest, `{"status":false,"errors":[{"id":"ProjectsError","error_code":"INVALID_REQUEST","msg":"Problem with the source's gpg keys: unknown gpg key: unknown"}]}`)
+	test.TestRoute(t, api, false, "POST", "/api/v0/projects/source/new",
+		`{"name":"corp","url":"https://repo.corp.example.com/","type":"yum-baseurl","check_ssl":true,"check_gpg":true,"gpgkeys":["corp"],"gpgkey_urls":["`+keyServer.URL+`/RPM-GPG-KEY"]}`,
+		http.StatusOK, `{"status":true}`)
+	test.TestRoute(t, api, false, "GET", "/api/v0/projects/source/info/corp", ``, http.StatusOK,
+		`{"sources":{"corp":{"name":"corp","type":"yum-baseurl","url":"https://repo.corp.example.com/","check_gpg":true,"check_ssl":true,"system":false,"gpgkeys":["corp","`+otherName+`"],"gpgkey_urls":["`+keyServer.URL+`/RPM-GPG-KEY"]}},"errors":[]}`)
+	require.Equal(t, []string{key, otherKey}, s.SourceRepoConfig(*s.GetSource("corp")).GPGKeys)
+
+	// keys that cannot be fetched are skipped
+	test.TestRoute(t, api, false, "POST", "/api/v0/projects/source/new",
+		`{"name":"other","url":"https://repo.other.example.com/","type":"yum-baseurl","check_ssl":true,"check_gpg":true,"gpgkey_urls":["`+keyServer.URL+`/missing"]}`,
+		http.StatusOK, `{"status":true}`)
+	require.Empty(t, s.GetSource("other").GPGKeys)
+
+	test.TestRoute(t, api, false, "GET", "/api/v1/projects/source/gpgkeys/list", ``, http.StatusOK,
+		`{"gpgkeys":[{"name":"corp","used_by":["corp"]},{"name":"`+otherName+`","used_by":["corp"]}]}`)
+
+	reply, err := json.Marshal(map[string]interface{}{"name": "corp", "key": key, "used_by": []string{"corp"}})
+	require.NoError(t, err)
+	test.TestRoute(t, api, false, "GET", "/api/v1/projects/source/gpgkeys/info/corp", ``, http.StatusOK, string(reply))
+	test.TestRoute(t, api, false, "GET", "/api/v1/projects/source/gpgkeys/info/unknown", ``, http.StatusNotFound,
+		`{"status":false,"errors":[{"id":"UnknownGPGKey","error_code":"NOT_FOUND","msg":"unknown is not a valid gpg key"}]}`)
+
+	test.TestRoute(t, api, false, "DELETE", "/api/v1/projects/source/gpgkeys/delete/corp", ``, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"ProjectsError","error_code":"INVALID_REQUEST","msg":"gpg key corp is used by: corp"}]}`)
+	test.TestRoute(t, api, false, "DELETE", "/api/v0/projects/source/delete/corp", ``, http.StatusOK, `{"status":true}`)
+	test.TestRoute(t, api, false, "DELETE", "/api/v1/projects/source/gpgkeys/delete/corp", ``, http.StatusOK, `{"status":true}`)
+	test.TestRoute(t, api, false, "DELETE", "/api/v1/projects/source/gpgkeys/delete/corp", ``, http.StatusNotFound,
+		`{"status":false,"errors":[{"id":"UnknownGPGKey","error_code":"NOT_FOUND","msg":"corp is not a valid gpg key"}]}`)
+}
+
 // Empty TOML, and invalid TOML should return an error
 func TestSourcesNewWrongToml(t *testing.T) {
 	sources := []string{``, `
@@ -849,7 +1123,7 @@ func TestSourcesInfo(t *testing.T) {
 	test.SendHTTP(api, true, "POST", "/api/v0/projects/source/new", sourceStr)
 	test.TestRoute(t, api, true, "GET", "/api/v0/projects/source/info/fish", ``, 200, `{"sources":{"fish":`+sourceStr+`},"errors":[]}`)
 	test.TestRoute(t, api, true, "GET", "/api/v0/projects/source/info/fish?format=json", ``, 200, `{"sources":{"fish":`+sourceStr+`},"errors":[]}`)
-	test.TestRoute(t, api, true, "GET", "/api/v0/projects/source/info/fish?format=son", ``, 400, `{"status":false,"errors":[{"id":"InvalidChars","msg":"invalid format parameter: son"}]}`)
+	test.TestRoute(t, api, true, "GET", "/api/v0/projects/source/info/fish?format=son", ``, 400, `{"status":false,"errors":[{"id":"InvalidChars","error_code":"INVALID_REQUEST","msg":"invalid format parameter: son"}]}`)
 }
 
 func TestSourcesInfoToml(t *testing.T) {
@@ -886,7 +1160,7 @@ func TestSourcesDelete(t *testing.T) {
 		ExpectedStatus int
 		ExpectedJSON   string
 	}{
-		{"DELETE", "/api/v0/projects/source/delete/", ``, http.StatusNotFound, `{"status":false,"errors":[{"code":404,"id":"HTTPError","msg":"Not Found"}]}`},
+		{"DELETE", "/api/v0/projects/source/delete/", ``, http.StatusNotFound, `{"status":false,"errors":[{"code":404,"id":"HTTPError","error_code":"NOT_FOUND","msg":"Not Found"}]}`},
 		{"DELETE", "/api/v0/projects/source/delete/fish", ``, http.StatusOK, `{"status":true}`},
 	}
 
@@ -905,9 +1179,9 @@ func TestProjectsDepsolve(t *testing.T) {
 		ExpectedStatus int
 		ExpectedJSON   string
 	}{
-		{rpmmd_mock.NonExistingPackage, "/api/v0/projects/depsolve/fash", http.StatusBadRequest, `{"status":false,"errors":[{"id":"PROJECTS_ERROR","msg":"BadRequest: DNF error occured: MarkingErrors: Error occurred when marking packages for installation: Problems in request:\nmissing packages: fash"}]}`},
+		{rpmmd_mock.NonExistingPackage, "/api/v0/projects/depsolve/fash", http.StatusBadRequest, `{"status":false,"errors":[{"id":"PROJECTS_ERROR","error_code":"INVALID_REQUEST","msg":"BadRequest: DNF error occured: MarkingErrors: Error occurred when marking packages for installation: Problems in request:\nmissing packages: fash"}]}`},
 		{rpmmd_mock.BaseFixture, "/api/v0/projects/depsolve/fish", http.StatusOK, `{"projects":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]}`},
-		{rpmmd_mock.BadDepsolve, "/api/v0/projects/depsolve/go2rpm", http.StatusBadRequest, `{"status":false,"errors":[{"id":"PROJECTS_ERROR","msg":"BadRequest: DNF error occured: DepsolveError: There was a problem depsolving ['go2rpm']: \n Problem: conflicting requests\n  - nothing provides askalono-cli needed by go2rpm-1-4.fc31.noarch"}]}`},
+		{rpmmd_mock.BadDepsolve, "/api/v0/projects/depsolve/go2rpm", http.StatusBadRequest, `{"status":false,"errors":[{"id":"PROJECTS_ERROR","error_code":"INVALID_REQUEST","msg":"BadRequest: DNF error occured: DepsolveError: There was a problem depsolving ['go2rpm']: \n Problem: conflicting requests\n  - nothing provides askalono-cli needed by go2rpm-1-4.fc31.noarch"}]}`},
 	}
 
 	for _, c := range cases {
@@ -923,12 +1197,12 @@ func TestProjectsInfo(t *testing.T) {
 		ExpectedStatus int
 		ExpectedJSON   string
 	}{
-		{rpmmd_mock.BaseFixture, "/api/v0/projects/info", http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownProject","msg":"No packages specified."}]}`},
-		{rpmmd_mock.BaseFixture, "/api/v0/projects/info/", http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownProject","msg":"No packages specified."}]}`},
-		{rpmmd_mock.BaseFixture, "/api/v0/projects/info/nonexistingpkg", http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownProject","msg":"No packages have been found."}]}`},
+		{rpmmd_mock.BaseFixture, "/api/v0/projects/info", http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownProject","error_code":"PACKAGE_NOT_FOUND","msg":"No packages specified."}]}`},
+		{rpmmd_mock.BaseFixture, "/api/v0/projects/info/", http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownProject","error_code":"PACKAGE_NOT_FOUND","msg":"No packages specified."}]}`},
+		{rpmmd_mock.BaseFixture, "/api/v0/projects/info/nonexistingpkg", http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownProject","error_code":"PACKAGE_NOT_FOUND","msg":"No packages have been found."}]}`},
 		{rpmmd_mock.BaseFixture, "/api/v0/projects/info/*", http.StatusOK, `{"projects":[{"name":"package0","summary":"pkg0 sum","description":"pkg0 desc","homepage":"https://pkg0.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-01-03T15:04:05","epoch":0,"release":"0.fc30","source":{"license":"MIT","version":"0.1"}},{"arch":"x86_64","build_time":"2006-01-02T15:04:05","epoch":0,"release":"0.fc30","source":{"license":"MIT","version":"0.0"}}]},{"name":"package1","summary":"pkg1 sum","description":"pkg1 desc","homepage":"https://pkg1.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-02-02T15:04:05","epoch":0,"release":"1.fc30","source":{"license":"MIT","version":"1.0"}},{"arch":"x86_64","build_time":"2006-02-03T15:04:05","epoch":0,"release":"1.fc30","source":{"license":"MIT","version":"1.1"}}]},{"name":"package10","summary":"pkg10 sum","description":"pkg10 desc","homepage":"https://pkg10.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-11-02T15:04:05","epoch":0,"release":"10.fc30","source":{"license":"MIT","version":"10.0"}},{"arch":"x86_64","build_time":"2006-11-03T15:04:05","epoch":0,"release":"10.fc30","source":{"license":"MIT","version":"10.1"}}]},{"name":"package11","summary":"pkg11 sum","description":"pkg11 desc","homepage":"https://pkg11.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-12-03T15:04:05","epoch":0,"release":"11.fc30","source":{"license":"MIT","version":"11.1"}},{"arch":"x86_64","build_time":"2006-12-02T15:04:05","epoch":0,"release":"11.fc30","source":{"license":"MIT","version":"11.0"}}]},{"name":"package12","summary":"pkg12 sum","description":"pkg12 desc","homepage":"https://pkg12.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-01-02T15:04:05","epoch":0,"release":"12.fc30","source":{"license":"MIT","version":"12.0"}},{"arch":"x86_64","build_time":"2007-01-03T15:04:05","epoch":0,"release":"12.fc30","source":{"license":"MIT","version":"12.1"}}]},{"name":"package13","summary":"pkg13 sum","description":"pkg13 desc","homepage":"https://pkg13.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-02-02T15:04:05","epoch":0,"release":"13.fc30","source":{"license":"MIT","version":"13.0"}},{"arch":"x86_64","build_time":"2007-02-03T15:04:05","epoch":0,"release":"13.fc30","source":{"license":"MIT","version":"13.1"}}]},{"name":"package14","summary":"pkg14 sum","description":"pkg14 desc","homepage":"https://pkg14.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-03-03T15:04:05","epoch":0,"release":"14.fc30","source":{"license":"MIT","version":"14.1"}},{"arch":"x86_64","build_time":"2007-03-02T15:04:05","epoch":0,"release":"14.fc30","source":{"license":"MIT","version":"14.0"}}]},{"name":"package15","summary":"pkg15 sum","description":"pkg15 desc","homepage":"https://pkg15.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-04-03T15:04:05","epoch":0,"release":"15.fc30","source":{"license":"MIT","version":"15.1"}},{"arch":"x86_64","build_time":"2007-04-02T15:04:05","epoch":0,"release":"15.fc30","source":{"license":"MIT","version":"15.0"}}]},{"name":"package16","summary":"pkg16 sum","description":"pkg16 desc","homepage":"https://pkg16.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-05-02T15:04:05","epoch":0,"release":"16.fc30","source":{"license":"MIT","version":"16.0"}},{"arch":"x86_64","build_time":"2007-05-03T15:04:05","epoch":0,"release":"16.fc30","source":{"license":"MIT","version":"16.1"}}]},{"name":"package17","summary":"pkg17 sum","description":"pkg17 desc","homepage":"https://pkg17.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-06-03T15:04:05","epoch":0,"release":"17.fc30","source":{"license":"MIT","version":"17.1"}},{"arch":"x86_64","build_time":"2007-06-02T15:04:05","epoch":0,"release":"17.fc30","source":{"license":"MIT","version":"17.0"}}]},{"name":"package18","summary":"pkg18 sum","description":"pkg18 desc","homepage":"https://pkg18.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-07-02T15:04:05","epoch":0,"release":"18.fc30","source":{"license":"MIT","version":"18.0"}},{"arch":"x86_64","build_time":"2007-07-03T15:04:05","epoch":0,"release":"18.fc30","source":{"license":"MIT","version":"18.1"}}]},{"name":"package19","summary":"pkg19 sum","description":"pkg19 desc","homepage":"https://pkg19.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-08-03T15:04:05","epoch":0,"release":"19.fc30","source":{"license":"MIT","version":"19.1"}},{"arch":"x86_64","build_time":"2007-08-02T15:04:05","epoch":0,"release":"19.fc30","source":{"license":"MIT","version":"19.0"}}]},{"name":"package2","summary":"pkg2 sum","description":"pkg2 desc","homepage":"https://pkg2.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-03-02T15:04:05","epoch":0,"release":"2.fc30","source":{"license":"MIT","version":"2.0"}},{"arch":"x86_64","build_time":"2006-03-03T15:04:05","epoch":0,"release":"2.fc30","source":{"license":"MIT","version":"2.1"}}]},{"name":"package20","summary":"pkg20 sum","description":"pkg20 desc","homepage":"https://pkg20.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-09-03T15:04:05","epoch":0,"release":"20.fc30","source":{"license":"MIT","version":"20.1"}},{"arch":"x86_64","build_time":"2007-09-02T15:04:05","epoch":0,"release":"20.fc30","source":{"license":"MIT","version":"20.0"}}]},{"name":"package21","summary":"pkg21 sum","description":"pkg21 desc","homepage":"https://pkg21.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-10-02T15:04:05","epoch":0,"release":"21.fc30","source":{"license":"MIT","version":"21.0"}},{"arch":"x86_64","build_time":"2007-10-03T15:04:05","epoch":0,"release":"21.fc30","source":{"license":"MIT","version":"21.1"}}]},{"name":"package3","summary":"pkg3 sum","description":"pkg3 desc","homepage":"https://pkg3.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-04-03T15:04:05","epoch":0,"release":"3.fc30","source":{"license":"MIT","version":"3.1"}},{"arch":"x86_64","build_time":"2006-04-02T15:04:05","epoch":0,"release":"3.fc30","source":{"license":"MIT","version":"3.0"}}]},{"name":"package4","summary":"pkg4 sum","description":"pkg4 desc","homepage":"https://pkg4.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-05-03T15:04:05","epoch":0,"release":"4.fc30","source":{"license":"MIT","version":"4.1"}},{"arch":"x86_64","build_time":"2006-05-02T15:04:05","epoch":0,"release":"4.fc30","source":{"license":"MIT","version":"4.0"}}]},{"name":"package5","summary":"pkg5 sum","description":"pkg5 desc","homepage":"https://pkg5.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-06-03T15:04:05","epoch":0,"release":"5.fc30","source":{"license":"MIT","version":"5.1"}},{"arch":"x86_64","build_time":"2006-06-02T15:04:05","epoch":0,"release":"5.fc30","source":{"license":"MIT","version":"5.0"}}]},{"name":"package6","summary":"pkg6 sum","description":"pkg6 desc","homepage":"https://pkg6.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-07-02T15:04:05","epoch":0,"release":"6.fc30","source":{"license":"MIT","version":"6.0"}},{"arch":"x86_64","build_time":"2006-07-03T15:04:05","epoch":0,"release":"6.fc30","source":{"license":"MIT","version":"6.1"}}]},{"name":"package7","summary":"pkg7 sum","description":"pkg7 desc","homepage":"https://pkg7.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-08-02T15:04:05","epoch":0,"release":"7.fc30","source":{"license":"MIT","version":"7.0"}},{"arch":"x86_64","build_time":"2006-08-03T15:04:05","epoch":0,"release":"7.fc30","source":{"license":"MIT","version":"7.1"}}]},{"name":"package8","summary":"pkg8 sum","description":"pkg8 desc","homepage":"https://pkg8.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-09-03T15:04:05","epoch":0,"release":"8.fc30","source":{"license":"MIT","version":"8.1"}},{"arch":"x86_64","build_time":"2006-09-02T15:04:05","epoch":0,"release":"8.fc30","source":{"license":"MIT","version":"8.0"}}]},{"name":"package9","summary":"pkg9 sum","description":"pkg9 desc","homepage":"https://pkg9.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-10-02T15:04:05","epoch":0,"release":"9.fc30","source":{"license":"MIT","version":"9.0"}},{"arch":"x86_64","build_time":"2006-10-03T15:04:05","epoch":0,"release":"9.fc30","source":{"license":"MIT","version":"9.1"}}]}]}`},
 		{rpmmd_mock.BaseFixture, "/api/v0/projects/info/package2*,package16", http.StatusOK, `{"projects":[{"name":"package16","summary":"pkg16 sum","description":"pkg16 desc","homepage":"https://pkg16.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-05-02T15:04:05","epoch":0,"release":"16.fc30","source":{"license":"MIT","version":"16.0"}},{"arch":"x86_64","build_time":"2007-05-03T15:04:05","epoch":0,"release":"16.fc30","source":{"license":"MIT","version":"16.1"}}]},{"name":"package2","summary":"pkg2 sum","description":"pkg2 desc","homepage":"https://pkg2.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-03-02T15:04:05","epoch":0,"release":"2.fc30","source":{"license":"MIT","version":"2.0"}},{"arch":"x86_64","build_time":"2006-03-03T15:04:05","epoch":0,"release":"2.fc30","source":{"license":"MIT","version":"2.1"}}]},{"name":"package20","summary":"pkg20 sum","description":"pkg20 desc","homepage":"https://pkg20.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-09-03T15:04:05","epoch":0,"release":"20.fc30","source":{"license":"MIT","version":"20.1"}},{"arch":"x86_64","build_time":"2007-09-02T15:04:05","epoch":0,"release":"20.fc30","source":{"license":"MIT","version":"20.0"}}]},{"name":"package21","summary":"pkg21 sum","description":"pkg21 desc","homepage":"https://pkg21.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-10-02T15:04:05","epoch":0,"release":"21.fc30","source":{"license":"MIT","version":"21.0"}},{"arch":"x86_64","build_time":"2007-10-03T15:04:05","epoch":0,"release":"21.fc30","source":{"license":"MIT","version":"21.1"}}]}]}`},
-		{rpmmd_mock.BadFetch, "/api/v0/projects/info/package2*,package16", http.StatusBadRequest, `{"status":false,"errors":[{"id":"ModulesError","msg":"msg: DNF error occured: FetchError: There was a problem when fetching packages."}]}`},
+		{rpmmd_mock.BadFetch, "/api/v0/projects/info/package2*,package16", http.StatusBadRequest, `{"status":false,"errors":[{"id":"ModulesError","error_code":"INVALID_REQUEST","msg":"msg: DNF error occured: FetchError: There was a problem when fetching packages."}]}`},
 	}
 
 	for _, c := range cases {
@@ -944,13 +1218,13 @@ func TestModulesInfo(t *testing.T) {
 		ExpectedStatus int
 		ExpectedJSON   string
 	}{
-		{rpmmd_mock.BaseFixture, "/api/v0/modules/info", http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownModule","msg":"No packages specified."}]}`},
-		{rpmmd_mock.BaseFixture, "/api/v0/modules/info/", http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownModule","msg":"No packages specified."}]}`},
-		{rpmmd_mock.BaseFixture, "/api/v0/modules/info/nonexistingpkg", http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownModule","msg":"No packages have been found."}]}`},
-		{rpmmd_mock.BadDepsolve, "/api/v0/modules/info/package1", http.StatusBadRequest, `{"status":false,"errors":[{"id":"ModulesError","msg":"Cannot depsolve package package1: DNF error occured: DepsolveError: There was a problem depsolving ['go2rpm']: \n Problem: conflicting requests\n  - nothing provides askalono-cli needed by go2rpm-1-4.fc31.noarch"}]}`},
+		{rpmmd_mock.BaseFixture, "/api/v0/modules/info", http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownModule","error_code":"PACKAGE_NOT_FOUND","msg":"No packages specified."}]}`},
+		{rpmmd_mock.BaseFixture, "/api/v0/modules/info/", http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownModule","error_code":"PACKAGE_NOT_FOUND","msg":"No packages specified."}]}`},
+		{rpmmd_mock.BaseFixture, "/api/v0/modules/info/nonexistingpkg", http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownModule","error_code":"PACKAGE_NOT_FOUND","msg":"No packages have been found."}]}`},
+		{rpmmd_mock.BadDepsolve, "/api/v0/modules/info/package1", http.StatusBadRequest, `{"status":false,"errors":[{"id":"ModulesError","error_code":"INVALID_REQUEST","msg":"Cannot depsolve package package1: DNF error occured: DepsolveError: There was a problem depsolving ['go2rpm']: \n Problem: conflicting requests\n  - nothing provides askalono-cli needed by go2rpm-1-4.fc31.noarch"}]}`},
 		{rpmmd_mock.BaseFixture, "/api/v0/modules/info/package2*,package16", http.StatusOK, `{"modules":[{"name":"package16","summary":"pkg16 sum","description":"pkg16 desc","homepage":"https://pkg16.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-05-02T15:04:05","epoch":0,"release":"16.fc30","source":{"license":"MIT","version":"16.0"}},{"arch":"x86_64","build_time":"2007-05-03T15:04:05","epoch":0,"release":"16.fc30","source":{"license":"MIT","version":"16.1"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]},{"name":"package2","summary":"pkg2 sum","description":"pkg2 desc","homepage":"https://pkg2.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-03-02T15:04:05","epoch":0,"release":"2.fc30","source":{"license":"MIT","version":"2.0"}},{"arch":"x86_64","build_time":"2006-03-03T15:04:05","epoch":0,"release":"2.fc30","source":{"license":"MIT","version":"2.1"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]},{"name":"package20","summary":"pkg20 sum","description":"pkg20 desc","homepage":"https://pkg20.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-09-03T15:04:05","epoch":0,"release":"20.fc30","source":{"license":"MIT","version":"20.1"}},{"arch":"x86_64","build_time":"2007-09-02T15:04:05","epoch":0,"release":"20.fc30","source":{"license":"MIT","version":"20.0"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]},{"name":"package21","summary":"pkg21 sum","description":"pkg21 desc","homepage":"https://pkg21.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-10-02T15:04:05","epoch":0,"release":"21.fc30","source":{"license":"MIT","version":"21.0"}},{"arch":"x86_64","build_time":"2007-10-03T15:04:05","epoch":0,"release":"21.fc30","source":{"license":"MIT","version":"21.1"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]}]}`},
 		{rpmmd_mock.BaseFixture, "/api/v0/modules/info/*", http.StatusOK, `{"modules":[{"name":"package0","summary":"pkg0 sum","description":"pkg0 desc","homepage":"https://pkg0.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-01-03T15:04:05","epoch":0,"release":"0.fc30","source":{"license":"MIT","version":"0.1"}},{"arch":"x86_64","build_time":"2006-01-02T15:04:05","epoch":0,"release":"0.fc30","source":{"license":"MIT","version":"0.0"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]},{"name":"package1","summary":"pkg1 sum","description":"pkg1 desc","homepage":"https://pkg1.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-02-02T15:04:05","epoch":0,"release":"1.fc30","source":{"license":"MIT","version":"1.0"}},{"arch":"x86_64","build_time":"2006-02-03T15:04:05","epoch":0,"release":"1.fc30","source":{"license":"MIT","version":"1.1"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]},{"name":"package10","summary":"pkg10 sum","description":"pkg10 desc","homepage":"https://pkg10.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-11-02T15:04:05","epoch":0,"release":"10.fc30","source":{"license":"MIT","version":"10.0"}},{"arch":"x86_64","build_time":"2006-11-03T15:04:05","epoch":0,"release":"10.fc30","source":{"license":"MIT","version":"10.1"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]},{"name":"package11","summary":"pkg11 sum","description":"pkg11 desc","homepage":"https://pkg11.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-12-03T15:04:05","epoch":0,"release":"11.fc30","source":{"license":"MIT","version":"11.1"}},{"arch":"x86_64","build_time":"2006-12-02T15:04:05","epoch":0,"release":"11.fc30","source":{"license":"MIT","version":"11.0"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]},{"name":"package12","summary":"pkg12 sum","description":"pkg12 desc","homepage":"https://pkg12.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-01-02T15:04:05","epoch":0,"release":"12.fc30","source":{"license":"MIT","version":"12.0"}},{"arch":"x86_64","build_time":"2007-01-03T15:04:05","epoch":0,"release":"12.fc30","source":{"license":"MIT","version":"12.1"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]},{"name":"package13","summary":"pkg13 sum","description":"pkg13 desc","homepage":"https://pkg13.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-02-02T15:04:05","epoch":0,"release":"13.fc30","source":{"license":"MIT","version":"13.0"}},{"arch":"x86_64","build_time":"2007-02-03T15:04:05","epoch":0,"release":"13.fc30","source":{"license":"MIT","version":"13.1"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]},{"name":"package14","summary":"pkg14 sum","description":"pkg14 desc","homepage":"https://pkg14.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-03-03T15:04:05","epoch":0,"release":"14.fc30","source":{"license":"MIT","version":"14.1"}},{"arch":"x86_64","build_time":"2007-03-02T15:04:05","epoch":0,"release":"14.fc30","source":{"license":"MIT","version":"14.0"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]},{"name":"package15","summary":"pkg15 sum","description":"pkg15 desc","homepage":"https://pkg15.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-04-03T15:04:05","epoch":0,"release":"15.fc30","source":{"license":"MIT","version":"15.1"}},{"arch":"x86_64","build_time":"2007-04-02T15:04:05","epoch":0,"release":"15.fc30","source":{"license":"MIT","version":"15.0"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]},{"name":"package16","summary":"pkg16 sum","description":"pkg16 desc","homepage":"https://pkg16.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-05-02T15:04:05","epoch":0,"release":"16.fc30","source":{"license":"MIT","version":"16.0"}},{"arch":"x86_64","build_time":"2007-05-03T15:04:05","epoch":0,"release":"16.fc30","source":{"license":"MIT","version":"16.1"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]},{"name":"package17","summary":"pkg17 sum","description":"pkg17 desc","homepage":"https://pkg17.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-06-03T15:04:05","epoch":0,"release":"17.fc30","source":{"license":"MIT","version":"17.1"}},{"arch":"x86_64","build_time":"2007-06-02T15:04:05","epoch":0,"release":"17.fc30","source":{"license":"MIT","version":"17.0"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]},{"name":"package18","summary":"pkg18 sum","description":"pkg18 desc","homepage":"https://pkg18.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-07-02T15:04:05","epoch":0,"release":"18.fc30","source":{"license":"MIT","version":"18.0"}},{"arch":"x86_64","build_time":"2007-07-03T15:04:05","epoch":0,"release":"18.fc30","source":{"license":"MIT","version":"18.1"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]},{"name":"package19","summary":"pkg19 sum","description":"pkg19 desc","homepage":"https://pkg19.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-08-03T15:04:05","epoch":0,"release":"19.fc30","source":{"license":"MIT","version":"19.1"}},{"arch":"x86_64","build_time":"2007-08-02T15:04:05","epoch":0,"release":"19.fc30","source":{"license":"MIT","version":"19.0"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]},{"name":"package2","summary":"pkg2 sum","description":"pkg2 desc","homepage":"https://pkg2.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-03-02T15:04:05","epoch":0,"release":"2.fc30","source":{"license":"MIT","version":"2.0"}},{"arch":"x86_64","build_time":"2006-03-03T15:04:05","epoch":0,"release":"2.fc30","source":{"license":"MIT","version":"2.1"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]},{"name":"package20","summary":"pkg20 sum","description":"pkg20 desc","homepage":"https://pkg20.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-09-03T15:04:05","epoch":0,"release":"20.fc30","source":{"license":"MIT","version":"20.1"}},{"arch":"x86_64","build_time":"2007-09-02T15:04:05","epoch":0,"release":"20.fc30","source":{"license":"MIT","version":"20.0"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]},{"name":"package21","summary":"pkg21 sum","description":"pkg21 desc","homepage":"https://pkg21.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-10-02T15:04:05","epoch":0,"release":"21.fc30","source":{"license":"MIT","version":"21.0"}},{"arch":"x86_64","build_time":"2007-10-03T15:04:05","epoch":0,"release":"21.fc30","source":{"license":"MIT","version":"21.1"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]},{"name":"package3","summary":"pkg3 sum","description":"pkg3 desc","homepage":"https://pkg3.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-04-03T15:04:05","epoch":0,"release":"3.fc30","source":{"license":"MIT","version":"3.1"}},{"arch":"x86_64","build_time":"2006-04-02T15:04:05","epoch":0,"release":"3.fc30","source":{"license":"MIT","version":"3.0"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]},{"name":"package4","summary":"pkg4 sum","description":"pkg4 desc","homepage":"https://pkg4.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-05-03T15:04:05","epoch":0,"release":"4.fc30","source":{"license":"MIT","version":"4.1"}},{"arch":"x86_64","build_time":"2006-05-02T15:04:05","epoch":0,"release":"4.fc30","source":{"license":"MIT","version":"4.0"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]},{"name":"package5","summary":"pkg5 sum","description":"pkg5 desc","homepage":"https://pkg5.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-06-03T15:04:05","epoch":0,"release":"5.fc30","source":{"license":"MIT","version":"5.1"}},{"arch":"x86_64","build_time":"2006-06-02T15:04:05","epoch":0,"release":"5.fc30","source":{"license":"MIT","version":"5.0"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]},{"name":"package6","summary":"pkg6 sum","description":"pkg6 desc","homepage":"https://pkg6.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-07-02T15:04:05","epoch":0,"release":"6.fc30","source":{"license":"MIT","version":"6.0"}},{"arch":"x86_64","build_time":"2006-07-03T15:04:05","epoch":0,"release":"6.fc30","source":{"license":"MIT","version":"6.1"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]},{"name":"package7","summary":"pkg7 sum","description":"pkg7 desc","homepage":"https://pkg7.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-08-02T15:04:05","epoch":0,"release":"7.fc30","source":{"license":"MIT","version":"7.0"}},{"arch":"x86_64","build_time":"2006-08-03T15:04:05","epoch":0,"release":"7.fc30","source":{"license":"MIT","version":"7.1"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]},{"name":"package8","summary":"pkg8 sum","description":"pkg8 desc","homepage":"https://pkg8.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-09-03T15:04:05","epoch":0,"release":"8.fc30","source":{"license":"MIT","version":"8.1"}},{"arch":"x86_64","build_time":"2006-09-02T15:04:05","epoch":0,"release":"8.fc30","source":{"license":"MIT","version":"8.0"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]},{"name":"package9","summary":"pkg9 sum","description":"pkg9 desc","homepage":"https://pkg9.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-10-02T15:04:05","epoch":0,"release":"9.fc30","source":{"license":"MIT","version":"9.0"}},{"arch":"x86_64","build_time":"2006-10-03T15:04:05","epoch":0,"release":"9.fc30","source":{"license":"MIT","version":"9.1"}}],"dependencies":[{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"},{"name":"dep-package1","epoch":0,"version":"1.33","release":"2.fc30","arch":"x86_64"},{"name":"dep-package2","epoch":0,"version":"2.9","release":"1.fc30","arch":"x86_64"}]}]}`},
-		{rpmmd_mock.BadFetch, "/api/v0/modules/info/package2*,package16", http.StatusBadRequest, `{"status":false,"errors":[{"id":"ModulesError","msg":"msg: DNF error occured: FetchError: There was a problem when fetching packages."}]}`},
+		{rpmmd_mock.BadFetch, "/api/v0/modules/info/package2*,package16", http.StatusBadRequest, `{"status":false,"errors":[{"id":"ModulesError","error_code":"INVALID_REQUEST","msg":"msg: DNF error occured: FetchError: There was a problem when fetching packages."}]}`},
 	}
 
 	for _, c := range cases {
@@ -968,7 +1242,7 @@ func TestProjectsList(t *testing.T) {
 	}{
 		{rpmmd_mock.BaseFixture, "/api/v0/projects/list", http.StatusOK, `{"total":22,"offset":0,"limit":20,"projects":[{"name":"package0","summary":"pkg0 sum","description":"pkg0 desc","homepage":"https://pkg0.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-01-03T15:04:05","epoch":0,"release":"0.fc30","source":{"license":"MIT","version":"0.1"}},{"arch":"x86_64","build_time":"2006-01-02T15:04:05","epoch":0,"release":"0.fc30","source":{"license":"MIT","version":"0.0"}}]},{"name":"package1","summary":"pkg1 sum","description":"pkg1 desc","homepage":"https://pkg1.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-02-02T15:04:05","epoch":0,"release":"1.fc30","source":{"license":"MIT","version":"1.0"}},{"arch":"x86_64","build_time":"2006-02-03T15:04:05","epoch":0,"release":"1.fc30","source":{"license":"MIT","version":"1.1"}}]},{"name":"package10","summary":"pkg10 sum","description":"pkg10 desc","homepage":"https://pkg10.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-11-02T15:04:05","epoch":0,"release":"10.fc30","source":{"license":"MIT","version":"10.0"}},{"arch":"x86_64","build_time":"2006-11-03T15:04:05","epoch":0,"release":"10.fc30","source":{"license":"MIT","version":"10.1"}}]},{"name":"package11","summary":"pkg11 sum","description":"pkg11 desc","homepage":"https://pkg11.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-12-03T15:04:05","epoch":0,"release":"11.fc30","source":{"license":"MIT","version":"11.1"}},{"arch":"x86_64","build_time":"2006-12-02T15:04:05","epoch":0,"release":"11.fc30","source":{"license":"MIT","version":"11.0"}}]},{"name":"package12","summary":"pkg12 sum","description":"pkg12 desc","homepage":"https://pkg12.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-01-02T15:04:05","epoch":0,"release":"12.fc30","source":{"license":"MIT","version":"12.0"}},{"arch":"x86_64","build_time":"2007-01-03T15:04:05","epoch":0,"release":"12.fc30","source":{"license":"MIT","version":"12.1"}}]},{"name":"package13","summary":"pkg13 sum","description":"pkg13 desc","homepage":"https://pkg13.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-02-02T15:04:05","epoch":0,"release":"13.fc30","source":{"license":"MIT","version":"13.0"}},{"arch":"x86_64","build_time":"2007-02-03T15:04:05","epoch":0,"release":"13.fc30","source":{"license":"MIT","version":"13.1"}}]},{"name":"package14","summary":"pkg14 sum","description":"pkg14 desc","homepage":"https://pkg14.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-03-03T15:04:05","epoch":0,"release":"14.fc30","source":{"license":"MIT","version":"14.1"}},{"arch":"x86_64","build_time":"2007-03-02T15:04:05","epoch":0,"release":"14.fc30","source":{"license":"MIT","version":"14.0"}}]},{"name":"package15","summary":"pkg15 sum","description":"pkg15 desc","homepage":"https://pkg15.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-04-03T15:04:05","epoch":0,"release":"15.fc30","source":{"license":"MIT","version":"15.1"}},{"arch":"x86_64","build_time":"2007-04-02T15:04:05","epoch":0,"release":"15.fc30","source":{"license":"MIT","version":"15.0"}}]},{"name":"package16","summary":"pkg16 sum","description":"pkg16 desc","homepage":"https://pkg16.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-05-02T15:04:05","epoch":0,"release":"16.fc30","source":{"license":"MIT","version":"16.0"}},{"arch":"x86_64","build_time":"2007-05-03T15:04:05","epoch":0,"release":"16.fc30","source":{"license":"MIT","version":"16.1"}}]},{"name":"package17","summary":"pkg17 sum","description":"pkg17 desc","homepage":"https://pkg17.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-06-03T15:04:05","epoch":0,"release":"17.fc30","source":{"license":"MIT","version":"17.1"}},{"arch":"x86_64","build_time":"2007-06-02T15:04:05","epoch":0,"release":"17.fc30","source":{"license":"MIT","version":"17.0"}}]},{"name":"package18","summary":"pkg18 sum","description":"pkg18 desc","homepage":"https://pkg18.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-07-02T15:04:05","epoch":0,"release":"18.fc30","source":{"license":"MIT","version":"18.0"}},{"arch":"x86_64","build_time":"2007-07-03T15:04:05","epoch":0,"release":"18.fc30","source":{"license":"MIT","version":"18.1"}}]},{"name":"package19","summary":"pkg19 sum","description":"pkg19 desc","homepage":"https://pkg19.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-08-03T15:04:05","epoch":0,"release":"19.fc30","source":{"license":"MIT","version":"19.1"}},{"arch":"x86_64","build_time":"2007-08-02T15:04:05","epoch":0,"release":"19.fc30","source":{"license":"MIT","version":"19.0"}}]},{"name":"package2","summary":"pkg2 sum","description":"pkg2 desc","homepage":"https://pkg2.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-03-02T15:04:05","epoch":0,"release":"2.fc30","source":{"license":"MIT","version":"2.0"}},{"arch":"x86_64","build_time":"2006-03-03T15:04:05","epoch":0,"release":"2.fc30","source":{"license":"MIT","version":"2.1"}}]},{"name":"package20","summary":"pkg20 sum","description":"pkg20 desc","homepage":"https://pkg20.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-09-03T15:04:05","epoch":0,"release":"20.fc30","source":{"license":"MIT","version":"20.1"}},{"arch":"x86_64","build_time":"2007-09-02T15:04:05","epoch":0,"release":"20.fc30","source":{"license":"MIT","version":"20.0"}}]},{"name":"package21","summary":"pkg21 sum","description":"pkg21 desc","homepage":"https://pkg21.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-10-02T15:04:05","epoch":0,"release":"21.fc30","source":{"license":"MIT","version":"21.0"}},{"arch":"x86_64","build_time":"2007-10-03T15:04:05","epoch":0,"release":"21.fc30","source":{"license":"MIT","version":"21.1"}}]},{"name":"package3","summary":"pkg3 sum","description":"pkg3 desc","homepage":"https://pkg3.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-04-03T15:04:05","epoch":0,"release":"3.fc30","source":{"license":"MIT","version":"3.1"}},{"arch":"x86_64","build_time":"2006-04-02T15:04:05","epoch":0,"release":"3.fc30","source":{"license":"MIT","version":"3.0"}}]},{"name":"package4","summary":"pkg4 sum","description":"pkg4 desc","homepage":"https://pkg4.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-05-03T15:04:05","epoch":0,"release":"4.fc30","source":{"license":"MIT","version":"4.1"}},{"arch":"x86_64","build_time":"2006-05-02T15:04:05","epoch":0,"release":"4.fc30","source":{"license":"MIT","version":"4.0"}}]},{"name":"package5","summary":"pkg5 sum","description":"pkg5 desc","homepage":"https://pkg5.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-06-03T15:04:05","epoch":0,"release":"5.fc30","source":{"license":"MIT","version":"5.1"}},{"arch":"x86_64","build_time":"2006-06-02T15:04:05","epoch":0,"release":"5.fc30","source":{"license":"MIT","version":"5.0"}}]},{"name":"package6","summary":"pkg6 sum","description":"pkg6 desc","homepage":"https://pkg6.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-07-02T15:04:05","epoch":0,"release":"6.fc30","source":{"license":"MIT","version":"6.0"}},{"arch":"x86_64","build_time":"2006-07-03T15:04:05","epoch":0,"release":"6.fc30","source":{"license":"MIT","version":"6.1"}}]},{"name":"package7","summary":"pkg7 sum","description":"pkg7 desc","homepage":"https://pkg7.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-08-02T15:04:05","epoch":0,"release":"7.fc30","source":{"license":"MIT","version":"7.0"}},{"arch":"x86_64","build_time":"2006-08-03T15:04:05","epoch":0,"release":"7.fc30","source":{"license":"MIT","version":"7.1"}}]}]}`},
 		{rpmmd_mock.BaseFixture, "/api/v0/projects/list/", http.StatusOK, `{"total":22,"offset":0,"limit":20,"projects":[{"name":"package0","summary":"pkg0 sum","description":"pkg0 desc","homepage":"https://pkg0.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-01-03T15:04:05","epoch":0,"release":"0.fc30","source":{"license":"MIT","version":"0.1"}},{"arch":"x86_64","build_time":"2006-01-02T15:04:05","epoch":0,"release":"0.fc30","source":{"license":"MIT","version":"0.0"}}]},{"name":"package1","summary":"pkg1 sum","description":"pkg1 desc","homepage":"https://pkg1.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-02-02T15:04:05","epoch":0,"release":"1.fc30","source":{"license":"MIT","version":"1.0"}},{"arch":"x86_64","build_time":"2006-02-03T15:04:05","epoch":0,"release":"1.fc30","source":{"license":"MIT","version":"1.1"}}]},{"name":"package10","summary":"pkg10 sum","description":"pkg10 desc","homepage":"https://pkg10.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-11-02T15:04:05","epoch":0,"release":"10.fc30","source":{"license":"MIT","version":"10.0"}},{"arch":"x86_64","build_time":"2006-11-03T15:04:05","epoch":0,"release":"10.fc30","source":{"license":"MIT","version":"10.1"}}]},{"name":"package11","summary":"pkg11 sum","description":"pkg11 desc","homepage":"https://pkg11.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-12-03T15:04:05","epoch":0,"release":"11.fc30","source":{"license":"MIT","version":"11.1"}},{"arch":"x86_64","build_time":"2006-12-02T15:04:05","epoch":0,"release":"11.fc30","source":{"license":"MIT","version":"11.0"}}]},{"name":"package12","summary":"pkg12 sum","description":"pkg12 desc","homepage":"https://pkg12.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-01-02T15:04:05","epoch":0,"release":"12.fc30","source":{"license":"MIT","version":"12.0"}},{"arch":"x86_64","build_time":"2007-01-03T15:04:05","epoch":0,"release":"12.fc30","source":{"license":"MIT","version":"12.1"}}]},{"name":"package13","summary":"pkg13 sum","description":"pkg13 desc","homepage":"https://pkg13.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-02-02T15:04:05","epoch":0,"release":"13.fc30","source":{"license":"MIT","version":"13.0"}},{"arch":"x86_64","build_time":"2007-02-03T15:04:05","epoch":0,"release":"13.fc30","source":{"license":"MIT","version":"13.1"}}]},{"name":"package14","summary":"pkg14 sum","description":"pkg14 desc","homepage":"https://pkg14.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-03-03T15:04:05","epoch":0,"release":"14.fc30","source":{"license":"MIT","version":"14.1"}},{"arch":"x86_64","build_time":"2007-03-02T15:04:05","epoch":0,"release":"14.fc30","source":{"license":"MIT","version":"14.0"}}]},{"name":"package15","summary":"pkg15 sum","description":"pkg15 desc","homepage":"https://pkg15.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-04-03T15:04:05","epoch":0,"release":"15.fc30","source":{"license":"MIT","version":"15.1"}},{"arch":"x86_64","build_time":"2007-04-02T15:04:05","epoch":0,"release":"15.fc30","source":{"license":"MIT","version":"15.0"}}]},{"name":"package16","summary":"pkg16 sum","description":"pkg16 desc","homepage":"https://pkg16.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-05-02T15:04:05","epoch":0,"release":"16.fc30","source":{"license":"MIT","version":"16.0"}},{"arch":"x86_64","build_time":"2007-05-03T15:04:05","epoch":0,"release":"16.fc30","source":{"license":"MIT","version":"16.1"}}]},{"name":"package17","summary":"pkg17 sum","description":"pkg17 desc","homepage":"https://pkg17.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-06-03T15:04:05","epoch":0,"release":"17.fc30","source":{"license":"MIT","version":"17.1"}},{"arch":"x86_64","build_time":"2007-06-02T15:04:05","epoch":0,"release":"17.fc30","source":{"license":"MIT","version":"17.0"}}]},{"name":"package18","summary":"pkg18 sum","description":"pkg18 desc","homepage":"https://pkg18.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-07-02T15:04:05","epoch":0,"release":"18.fc30","source":{"license":"MIT","version":"18.0"}},{"arch":"x86_64","build_time":"2007-07-03T15:04:05","epoch":0,"release":"18.fc30","source":{"license":"MIT","version":"18.1"}}]},{"name":"package19","summary":"pkg19 sum","description":"pkg19 desc","homepage":"https://pkg19.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-08-03T15:04:05","epoch":0,"release":"19.fc30","source":{"license":"MIT","version":"19.1"}},{"arch":"x86_64","build_time":"2007-08-02T15:04:05","epoch":0,"release":"19.fc30","source":{"license":"MIT","version":"19.0"}}]},{"name":"package2","summary":"pkg2 sum","description":"pkg2 desc","homepage":"https://pkg2.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-03-02T15:04:05","epoch":0,"release":"2.fc30","source":{"license":"MIT","version":"2.0"}},{"arch":"x86_64","build_time":"2006-03-03T15:04:05","epoch":0,"release":"2.fc30","source":{"license":"MIT","version":"2.1"}}]},{"name":"package20","summary":"pkg20 sum","description":"pkg20 desc","homepage":"https://pkg20.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-09-03T15:04:05","epoch":0,"release":"20.fc30","source":{"license":"MIT","version":"20.1"}},{"arch":"x86_64","build_time":"2007-09-02T15:04:05","epoch":0,"release":"20.fc30","source":{"license":"MIT","version":"20.0"}}]},{"name":"package21","summary":"pkg21 sum","description":"pkg21 desc","homepage":"https://pkg21.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-10-02T15:04:05","epoch":0,"release":"21.fc30","source":{"license":"MIT","version":"21.0"}},{"arch":"x86_64","build_time":"2007-10-03T15:04:05","epoch":0,"release":"21.fc30","source":{"license":"MIT","version":"21.1"}}]},{"name":"package3","summary":"pkg3 sum","description":"pkg3 desc","homepage":"https://pkg3.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-04-03T15:04:05","epoch":0,"release":"3.fc30","source":{"license":"MIT","version":"3.1"}},{"arch":"x86_64","build_time":"2006-04-02T15:04:05","epoch":0,"release":"3.fc30","source":{"license":"MIT","version":"3.0"}}]},{"name":"package4","summary":"pkg4 sum","description":"pkg4 desc","homepage":"https://pkg4.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-05-03T15:04:05","epoch":0,"release":"4.fc30","source":{"license":"MIT","version":"4.1"}},{"arch":"x86_64","build_time":"2006-05-02T15:04:05","epoch":0,"release":"4.fc30","source":{"license":"MIT","version":"4.0"}}]},{"name":"package5","summary":"pkg5 sum","description":"pkg5 desc","homepage":"https://pkg5.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-06-03T15:04:05","epoch":0,"release":"5.fc30","source":{"license":"MIT","version":"5.1"}},{"arch":"x86_64","build_time":"2006-06-02T15:04:05","epoch":0,"release":"5.fc30","source":{"license":"MIT","version":"5.0"}}]},{"name":"package6","summary":"pkg6 sum","description":"pkg6 desc","homepage":"https://pkg6.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-07-02T15:04:05","epoch":0,"release":"6.fc30","source":{"license":"MIT","version":"6.0"}},{"arch":"x86_64","build_time":"2006-07-03T15:04:05","epoch":0,"release":"6.fc30","source":{"license":"MIT","version":"6.1"}}]},{"name":"package7","summary":"pkg7 sum","description":"pkg7 desc","homepage":"https://pkg7.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-08-02T15:04:05","epoch":0,"release":"7.fc30","source":{"license":"MIT","version":"7.0"}},{"arch":"x86_64","build_time":"2006-08-03T15:04:05","epoch":0,"release":"7.fc30","source":{"license":"MIT","version":"7.1"}}]}]}`},
-		{rpmmd_mock.BadFetch, "/api/v0/projects/list/", http.StatusBadRequest, `{"status":false,"errors":[{"id":"ProjectsError","msg":"msg: DNF error occured: FetchError: There was a problem when fetching packages."}]}`},
+		{rpmmd_mock.BadFetch, "/api/v0/projects/list/", http.StatusBadRequest, `{"status":false,"errors":[{"id":"ProjectsError","error_code":"INVALID_REQUEST","msg":"msg: DNF error occured: FetchError: There was a problem when fetching packages."}]}`},
 		{rpmmd_mock.BaseFixture, "/api/v0/projects/list?offset=1&limit=1", http.StatusOK, `{"total":22,"offset":1,"limit":1,"projects":[{"name":"package1","summary":"pkg1 sum","description":"pkg1 desc","homepage":"https://pkg1.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-02-02T15:04:05","epoch":0,"release":"1.fc30","source":{"license":"MIT","version":"1.0"}},{"arch":"x86_64","build_time":"2006-02-03T15:04:05","epoch":0,"release":"1.fc30","source":{"license":"MIT","version":"1.1"}}]}]}`},
 	}
 
@@ -987,9 +1261,9 @@ func TestModulesList(t *testing.T) {
 	}{
 		{rpmmd_mock.BaseFixture, "/api/v0/modules/list", http.StatusOK, `{"total":22,"offset":0,"limit":20,"modules":[{"name":"package0","group_type":"rpm"},{"name":"package1","group_type":"rpm"},{"name":"package10","group_type":"rpm"},{"name":"package11","group_type":"rpm"},{"name":"package12","group_type":"rpm"},{"name":"package13","group_type":"rpm"},{"name":"package14","group_type":"rpm"},{"name":"package15","group_type":"rpm"},{"name":"package16","group_type":"rpm"},{"name":"package17","group_type":"rpm"},{"name":"package18","group_type":"rpm"},{"name":"package19","group_type":"rpm"},{"name":"package2","group_type":"rpm"},{"name":"package20","group_type":"rpm"},{"name":"package21","group_type":"rpm"},{"name":"package3","group_type":"rpm"},{"name":"package4","group_type":"rpm"},{"name":"package5","group_type":"rpm"},{"name":"package6","group_type":"rpm"},{"name":"package7","group_type":"rpm"}]}`},
 		{rpmmd_mock.BaseFixture, "/api/v0/modules/list/", http.StatusOK, `{"total":22,"offset":0,"limit":20,"modules":[{"name":"package0","group_type":"rpm"},{"name":"package1","group_type":"rpm"},{"name":"package10","group_type":"rpm"},{"name":"package11","group_type":"rpm"},{"name":"package12","group_type":"rpm"},{"name":"package13","group_type":"rpm"},{"name":"package14","group_type":"rpm"},{"name":"package15","group_type":"rpm"},{"name":"package16","group_type":"rpm"},{"name":"package17","group_type":"rpm"},{"name":"package18","group_type":"rpm"},{"name":"package19","group_type":"rpm"},{"name":"package2","group_type":"rpm"},{"name":"package20","group_type":"rpm"},{"name":"package21","group_type":"rpm"},{"name":"package3","group_type":"rpm"},{"name":"package4","group_type":"rpm"},{"name":"package5","group_type":"rpm"},{"name":"package6","group_type":"rpm"},{"name":"package7","group_type":"rpm"}]}`},
-		{rpmmd_mock.BaseFixture, "/api/v0/modules/list/nonexistingpkg", http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownModule","msg":"No packages have been found."}]}`},
+		{rpmmd_mock.BaseFixture, "/api/v0/modules/list/nonexistingpkg", http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownModule","error_code":"PACKAGE_NOT_FOUND","msg":"No packages have been found."}]}`},
 		{rpmmd_mock.BaseFixture, "/api/v0/modules/list/package2*,package16", http.StatusOK, `{"total":4,"offset":0,"limit":20,"modules":[{"name":"package16","group_type":"rpm"},{"name":"package2","group_type":"rpm"},{"name":"package20","group_type":"rpm"},{"name":"package21","group_type":"rpm"}]}`},
-		{rpmmd_mock.BadFetch, "/api/v0/modules/list/package2*,package16", http.StatusBadRequest, `{"status":false,"errors":[{"id":"ModulesError","msg":"msg: DNF error occured: FetchError: There was a problem when fetching packages."}]}`},
+		{rpmmd_mock.BadFetch, "/api/v0/modules/list/package2*,package16", http.StatusBadRequest, `{"status":false,"errors":[{"id":"ModulesError","error_code":"INVALID_REQUEST","msg":"msg: DNF error occured: FetchError: There was a problem when fetching packages."}]}`},
 		{rpmmd_mock.BaseFixture, "/api/v0/modules/list/package2*,package16?offset=1&limit=1", http.StatusOK, `{"total":4,"offset":1,"limit":1,"modules":[{"name":"package2","group_type":"rpm"}]}`},
 		{rpmmd_mock.BaseFixture, "/api/v0/modules/list/*", http.StatusOK, `{"total":22,"offset":0,"limit":20,"modules":[{"name":"package0","group_type":"rpm"},{"name":"package1","group_type":"rpm"},{"name":"package10","group_type":"rpm"},{"name":"package11","group_type":"rpm"},{"name":"package12","group_type":"rpm"},{"name":"package13","group_type":"rpm"},{"name":"package14","group_type":"rpm"},{"name":"package15","group_type":"rpm"},{"name":"package16","group_type":"rpm"},{"name":"package17","group_type":"rpm"},{"name":"package18","group_type":"rpm"},{"name":"package19","group_type":"rpm"},{"name":"package2","group_type":"rpm"},{"name":"package20","group_type":"rpm"},{"name":"package21","group_type":"rpm"},{"name":"package3","group_type":"rpm"},{"name":"package4","group_type":"rpm"},{"name":"package5","group_type":"rpm"},{"name":"package6","group_type":"rpm"},{"name":"package7","group_type":"rpm"}]}`},
 	}
@@ -999,3 +1273,2336 @@ func TestModulesList(t *testing.T) {
 		test.TestRoute(t, api, true, "GET", c.Path, ``, c.ExpectedStatus, c.ExpectedJSON)
 	}
 }
+
+func TestComposeWarnings(t *testing.T) {
+	bp := &blueprint.Blueprint{Name: "test"}
+	require.Empty(t, composeWarnings(bp, nil, nil))
+
+	bp.Customizations = &blueprint.Customizations{
+		SSHKey: []blueprint.SSHKeyCustomization{{User: "root", Key: "ssh-rsa AAAA"}},
+	}
+	packages := make([]rpmmd.PackageSpec, maxRecommendedPackages+1)
+	targets := []*target.Target{
+		target.NewAWSTarget(&target.AWSTargetOptions{Region: "eu-central-1"}),
+		target.NewAWSTarget(&target.AWSTargetOptions{Region: "af-south-1"}),
+	}
+
+	var ids []string
+	for _, w := range composeWarnings(bp, packages, targets) {
+		ids = append(ids, w.ID)
+	}
+	require.Equal(t, []string{"DeprecatedCustomization", "OversizedPackageSet", "SlowTargetRegion"}, ids)
+}
+
+func TestComposePriority(t *testing.T) {
+	api, s := createWeldrAPI(rpmmd_mock.NoComposesFixture)
+
+	body := `{"blueprint_name": "test","compose_type": "qcow2","branch": "master","priority": "high"}`
+	req := httptest.NewRequest("POST", "/api/v0/compose", bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = "pid=1,uid=0,gid=0"
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Len(t, s.Composes, 1)
+
+	req.RemoteAddr = "pid=1,uid=1000,gid=1000"
+	require.False(t, isPrivileged(req))
+	req.RemoteAddr = "192.0.2.1:1234"
+	require.False(t, isPrivileged(req))
+}
+
+func TestConfig(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, _ := createWeldrAPI(rpmmd_mock.BaseFixture)
+	effective := config.New()
+	effective.Set("env.STATE_DIRECTORY", "/var/lib/osbuild-composer", config.SourceEnvironment)
+	effective.SetFile("notify-email", "/etc/osbuild-composer/email.toml", map[string]string{"password": "hunter2"})
+	api.SetEffectiveConfig(effective)
+
+	test.TestRoute(t, api, false, "GET", "/api/v1/config", ``, http.StatusForbidden,
+		`{"status":false,"errors":[{"id":"PermissionDenied","error_code":"FORBIDDEN","msg":"only privileged clients may read the configuration"}]}`)
+
+	req := httptest.NewRequest("GET", "/api/v1/config", nil)
+	req.RemoteAddr = "pid=1,uid=0,gid=0"
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.JSONEq(t, `{"settings":[
+		{"name":"env.STATE_DIRECTORY","value":"/var/lib/osbuild-composer","source":"environment"},
+		{"name":"notify-email","value":{"password":"<redacted>"},"source":"file","path":"/etc/osbuild-composer/email.toml"}
+	]}`, resp.Body.String())
+}
+
+func TestBlueprintsWorkspaceList(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, s := createWeldrAPI(rpmmd_mock.BaseFixture)
+	api.SetWorkspaceTTL(48 * time.Hour)
+
+	test.TestRoute(t, api, false, "GET", "/api/v1/blueprints/workspace", ``, http.StatusForbidden,
+		`{"status":false,"errors":[{"id":"PermissionDenied","error_code":"FORBIDDEN","msg":"only privileged clients may list the workspace"}]}`)
+
+	require.NoError(t, s.PushBlueprintToWorkspace(blueprint.Blueprint{Name: "wip", Version: "0.0.1"}))
+
+	req := httptest.NewRequest("GET", "/api/v1/blueprints/workspace", nil)
+	req.RemoteAddr = "pid=1,uid=0,gid=0"
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var reply struct {
+		Workspace []struct {
+			Name    string    `json:"name"`
+			Updated time.Time `json:"updated"`
+			Expires time.Time `json:"expires"`
+		} `json:"workspace"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&reply))
+	require.Len(t, reply.Workspace, 1)
+	require.Equal(t, "wip", reply.Workspace[0].Name)
+	require.Equal(t, 48*time.Hour, reply.Workspace[0].Expires.Sub(reply.Workspace[0].Updated))
+}
+
+func TestComposeAdmission(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, s := createWeldrAPI(rpmmd_mock.NoComposesFixture)
+	api.SetAdmission(&admission.Rules{MaxImageSize: 1024})
+
+	test.TestRoute(t, api, false, "POST", "/api/v0/compose", `{"blueprint_name": "test","compose_type": "qcow2","branch": "master","size": 4096}`,
+		http.StatusForbidden, `{"status":false,"errors":[{"id":"ComposeDenied","error_code":"FORBIDDEN","msg":"compose denied: image size 4096 exceeds the maximum of 1024 bytes"}]}`)
+	require.Len(t, s.Composes, 0)
+
+	api.SetAdmission(&admission.Rules{ApprovedRepositories: []string{"http://example.com/other"}})
+	test.TestRoute(t, api, false, "POST", "/api/v0/compose", `{"blueprint_name": "test","compose_type": "qcow2","branch": "master"}`,
+		http.StatusOK, `{"status": true}`, "build_id")
+	require.Len(t, s.Composes, 1)
+}
+
+func TestComposeBlueprint(t *testing.T) {
+	var cases = []struct {
+		Path           string
+		Body           string
+		ExpectedStatus int
+		ExpectedJSON   string
+		InWorkspace    bool
+	}{
+		{"/api/v0/compose/blueprint", `{"blueprint":"name = \"ci\"","compose_type":"qcow2"}`, http.StatusNotFound, `{"status":false,"errors":[{"code":404,"id":"HTTPError","error_code":"NOT_FOUND","msg":"Not Found"}]}`, false},
+		{"/api/v1/compose/blueprint", `{"blueprint":"name = ","compose_type":"qcow2"}`, http.StatusBadRequest, `{"status":false,"errors":[{"id":"BlueprintsError","error_code":"INVALID_REQUEST"}]}`, false},
+		{"/api/v1/compose/blueprint", `{"blueprint":"description = \"no name\"","compose_type":"qcow2"}`, http.StatusBadRequest, `{"status":false,"errors":[{"id":"BlueprintsError","error_code":"INVALID_REQUEST","msg":"invalid blueprint: blueprint has no name"}]}`, false},
+		{"/api/v1/compose/blueprint", `{"blueprint":"name = \"ci\"\nversion = \"latest\"","compose_type":"qcow2"}`, http.StatusBadRequest, `{"status":false,"errors":[{"id":"BlueprintsError","error_code":"INVALID_REQUEST"}]}`, false},
+		{"/api/v1/compose/blueprint", `{"blueprint":"name = \"ci\"","compose_type":"unknown"}`, http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownComposeType","error_code":"UNKNOWN_IMAGE_TYPE","msg":"Unknown compose type for architecture: unknown"}]}`, false},
+		{"/api/v1/compose/blueprint", `{"blueprint":"name = \"ci\"","compose_type":"qcow2"}`, http.StatusOK, `{"status":true}`, false},
+		{"/api/v1/compose/blueprint", `{"blueprint":"name = \"ci\"","compose_type":"qcow2","store":true}`, http.StatusOK, `{"status":true}`, true},
+	}
+
+	for _, c := range cases {
+		api, s := createWeldrAPI(rpmmd_mock.NoComposesFixture)
+		test.TestRoute(t, api, false, "POST", c.Path, c.Body, c.ExpectedStatus, c.ExpectedJSON, "build_id", "msg")
+
+		_, inWorkspace := s.Workspace["ci"]
+		require.Equalf(t, c.InWorkspace, inWorkspace, "%s: %s", c.Path, c.Body)
+		require.NotContainsf(t, s.Blueprints, "ci", "%s: %s", c.Path, c.Body)
+
+		if c.ExpectedStatus == http.StatusOK {
+			require.Len(t, s.Composes, 1)
+			for _, compose := range s.Composes {
+				require.Equal(t, "ci", compose.Blueprint.Name)
+				require.Equal(t, "0.0.0", compose.Blueprint.Version)
+			}
+		}
+	}
+}
+
+func TestComposePublish(t *testing.T) {
+	var cases = []struct {
+		Path           string
+		ExpectedStatus int
+		ExpectedJSON   string
+	}{
+		{"/api/v0/compose/publish/30000000-0000-0000-0000-000000000002", http.StatusNotFound, `{"status":false,"errors":[{"code":404,"id":"HTTPError","error_code":"NOT_FOUND","msg":"Not Found"}]}`},
+		{"/api/v1/compose/publish/invalid", http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownUUID","error_code":"COMPOSE_NOT_FOUND","msg":"invalid is not a valid build uuid"}]}`},
+		{"/api/v1/compose/publish/42000000-0000-0000-0000-000000000000", http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownUUID","error_code":"COMPOSE_NOT_FOUND","msg":"Compose 42000000-0000-0000-0000-000000000000 doesn't exist"}]}`},
+		{"/api/v1/compose/publish/30000000-0000-0000-0000-000000000003", http.StatusBadRequest, `{"status":false,"errors":[{"id":"BuildInWrongState","error_code":"COMPOSE_WRONG_STATE","msg":"Build 30000000-0000-0000-0000-000000000003 is in wrong state: FAILED"}]}`},
+		// the fixture's store doesn't keep images
+		{"/api/v1/compose/publish/30000000-0000-0000-0000-000000000002", http.StatusBadRequest, `{"status":false,"errors":[{"id":"BuildMissingFile","error_code":"ARTIFACT_NOT_FOUND","msg":"Cannot publish build 30000000-0000-0000-0000-000000000002: images are not stored"}]}`},
+		{"/api/v1/compose/unpublish/30000000-0000-0000-0000-000000000002", http.StatusOK, `{"status":true}`},
+		{"/api/v1/compose/unpublish/42000000-0000-0000-0000-000000000000", http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownUUID","error_code":"COMPOSE_NOT_FOUND","msg":"Compose 42000000-0000-0000-0000-000000000000 doesn't exist"}]}`},
+	}
+
+	for _, c := range cases {
+		api, _ := createWeldrAPI(rpmmd_mock.BaseFixture)
+		test.TestRoute(t, api, false, "POST", c.Path, ``, c.ExpectedStatus, c.ExpectedJSON)
+	}
+}
+
+func TestComposePromote(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	dir, err := ioutil.TempDir("", "weldr-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	stagesPath := filepath.Join(dir, "stages.json")
+	err = ioutil.WriteFile(stagesPath, []byte(`{"stages":[
+		{"name":"staging","uploads":[{"provider":"aws","image_name":"staging-image","settings":{"region":"eu-central-1","bucket":"staging"}}]},
+		{"name":"prod","after":"staging","uploads":[{"provider":"aws","image_name":"prod-image","settings":{"region":"eu-central-1","bucket":"prod"}}]}
+	]}`), 0600)
+	require.NoError(t, err)
+
+	stages, err := LoadPromotionStages(stagesPath)
+	require.NoError(t, err)
+
+	api, s := createWeldrAPI(rpmmd_mock.BaseFixture)
+	api.SetPromotionStages(stages)
+
+	// each case depends on the previous ones
+	var cases = []struct {
+		Path           string
+		ExpectedStatus int
+		ExpectedJSON   string
+	}{
+		{"/api/v0/compose/promote/30000000-0000-0000-0000-000000000002/staging", http.StatusNotFound, `{"status":false,"errors":[{"code":404,"id":"HTTPError","error_code":"NOT_FOUND","msg":"Not Found"}]}`},
+		{"/api/v1/compose/promote/30000000-0000-0000-0000-000000000002/dev", http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownPromotionStage","error_code":"INVALID_REQUEST","msg":"Unknown promotion stage: dev"}]}`},
+		{"/api/v1/compose/promote/30000000-0000-0000-0000-000000000003/staging", http.StatusBadRequest, `{"status":false,"errors":[{"id":"BuildInWrongState","error_code":"COMPOSE_WRONG_STATE","msg":"Build 30000000-0000-0000-0000-000000000003 is in wrong state: FAILED"}]}`},
+		{"/api/v1/compose/promote/30000000-0000-0000-0000-000000000002/prod", http.StatusBadRequest, `{"status":false,"errors":[{"id":"BuildInWrongState","error_code":"COMPOSE_WRONG_STATE","msg":"Build 30000000-0000-0000-0000-000000000002 must be promoted to staging before prod"}]}`},
+		{"/api/v1/compose/promote/30000000-0000-0000-0000-000000000002/staging", http.StatusOK, `{"status":true}`},
+		{"/api/v1/compose/promote/30000000-0000-0000-0000-000000000002/staging", http.StatusBadRequest, `{"status":false,"errors":[{"id":"BuildInWrongState","error_code":"COMPOSE_WRONG_STATE","msg":"Build 30000000-0000-0000-0000-000000000002 is already promoted to staging"}]}`},
+	}
+
+	for _, c := range cases {
+		test.TestRoute(t, api, false, "POST", c.Path, ``, c.ExpectedStatus, c.ExpectedJSON)
+	}
+
+	id := uuid.MustParse("30000000-0000-0000-0000-000000000002")
+	c, _ := s.GetCompose(id)
+	require.Len(t, c.Promotions, 1)
+	require.Equal(t, "staging", c.Promotions[0].Stage)
+	require.Equal(t, common.IBWaiting, c.Promotions[0].Status)
+
+	// prod is allowed once the promotion to staging finished
+	require.NoError(t, s.FinishPromotion(id, c.Promotions[0].JobId, nil))
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose/promote/30000000-0000-0000-0000-000000000002/prod", ``, http.StatusOK, `{"status":true}`)
+}
+
+func TestProvidersValidate(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, _ := createWeldrAPI(rpmmd_mock.BaseFixture)
+	api.validateUpload = func(t *target.Target) []upload.Diagnostic {
+		if t.Options.(*target.AWSTargetOptions).Bucket == "missing" {
+			return []upload.Diagnostic{{Field: "bucket", Message: "cannot access bucket missing", Hint: "The bucket does not exist."}}
+		}
+		return nil
+	}
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/upload/providers/validate",
+		`{"provider":"aws","settings":{"region":"eu-central-1","accessKeyID":"id","secretAccessKey":"key","bucket":"images"}}`,
+		http.StatusOK, `{"valid":true,"diagnostics":[]}`)
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/upload/providers/validate",
+		`{"provider":"aws","settings":{"region":"eu-central-1","accessKeyID":"id","secretAccessKey":"key","bucket":"missing"}}`,
+		http.StatusOK, `{"valid":false,"diagnostics":[{"field":"bucket","message":"cannot access bucket missing","hint":"The bucket does not exist."}]}`)
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/upload/providers/validate",
+		`{"provider":"gcp","settings":{}}`,
+		http.StatusBadRequest, `{"status":false,"errors":[{"id":"BadUpload","error_code":"INVALID_REQUEST","msg":"invalid upload settings: unexpected provider name"}]}`)
+
+	test.TestRoute(t, api, false, "POST", "/api/v0/upload/providers/validate", `{}`, http.StatusNotFound, "*")
+}
+
+func TestComposeDiskQuota(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, _ := createWeldrAPI(rpmmd_mock.NoComposesFixture)
+
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/disk-usage", ``, http.StatusOK, `{"total":0,"composes":[]}`)
+
+	// the test distro's image size is the requested size
+	api.SetDiskQuota(4096)
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose",
+		`{"blueprint_name":"test","compose_type":"qcow2","branch":"master","size":8192}`,
+		http.StatusInsufficientStorage,
+		`{"status":false,"errors":[{"id":"DiskQuotaExceeded","error_code":"QUOTA_EXCEEDED","msg":"compose outputs use 0 of 4096 bytes, which leaves no room for an image of 8192 bytes; delete old composes first"}]}`)
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose",
+		`{"blueprint_name":"test","compose_type":"qcow2","branch":"master","size":4096}`,
+		http.StatusOK, `*`)
+
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/disk-usage", ``, http.StatusOK, `{"total":0,"quota":4096,"composes":[{"size":0}]}`, "id")
+}
+
+func TestComposeExportImport(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	src, _ := createWeldrAPI(rpmmd_mock.BaseFixture)
+	dst, dstStore := createWeldrAPI(rpmmd_mock.NoComposesFixture)
+
+	test.TestRoute(t, src, false, "GET", "/api/v1/compose/export/30000000-0000-0000-0000-000000000002", ``, http.StatusForbidden,
+		`{"status":false,"errors":[{"id":"PermissionDenied","error_code":"FORBIDDEN","msg":"only privileged clients may export composes"}]}`)
+
+	req := httptest.NewRequest("GET", "/api/v1/compose/export/30000000-0000-0000-0000-000000000001", nil)
+	req.RemoteAddr = "pid=1,uid=0,gid=0"
+	resp := httptest.NewRecorder()
+	src.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+
+	req = httptest.NewRequest("GET", "/api/v1/compose/export/30000000-0000-0000-0000-000000000002", nil)
+	req.RemoteAddr = "pid=1,uid=0,gid=0"
+	resp = httptest.NewRecorder()
+	src.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "application/x-tar", resp.Header().Get("Content-Type"))
+	archive := resp.Body.Bytes()
+
+	req = httptest.NewRequest("POST", "/api/v1/compose/import", bytes.NewReader(archive))
+	req.Header.Set("Content-Type", "application/x-tar")
+	resp = httptest.NewRecorder()
+	dst.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusForbidden, resp.Code)
+
+	req = httptest.NewRequest("POST", "/api/v1/compose/import", bytes.NewReader(archive))
+	req.Header.Set("Content-Type", "application/x-tar")
+	req.RemoteAddr = "pid=1,uid=0,gid=0"
+	resp = httptest.NewRecorder()
+	dst.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.JSONEq(t, `{"status":true,"build_id":"30000000-0000-0000-0000-000000000002"}`, resp.Body.String())
+
+	c, exists := dstStore.GetCompose(uuid.MustParse("30000000-0000-0000-0000-000000000002"))
+	require.True(t, exists)
+	state, _, _, _ := dst.getComposeState(c)
+	require.Equal(t, common.CFinished, state)
+
+	// importing it again fails
+	req = httptest.NewRequest("POST", "/api/v1/compose/import", bytes.NewReader(archive))
+	req.Header.Set("Content-Type", "application/x-tar")
+	req.RemoteAddr = "pid=1,uid=0,gid=0"
+	resp = httptest.NewRecorder()
+	dst.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestComposeRegister(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, s := createWeldrAPI(rpmmd_mock.NoComposesFixture)
+
+	register := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/compose/register", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "pid=1,uid=0,gid=0"
+		resp := httptest.NewRecorder()
+		api.ServeHTTP(resp, req)
+		return resp
+	}
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose/register", `{}`, http.StatusForbidden,
+		`{"status":false,"errors":[{"id":"PermissionDenied","error_code":"FORBIDDEN","msg":"only privileged clients may register images"}]}`)
+
+	resp := register(`{"compose_type":"qcow2","blueprint":{"name":"web"},"provider":"aws"}`)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	require.Contains(t, resp.Body.String(), `"id":"BadRegistration"`)
+
+	resp = register(`{"compose_type":"floppy","blueprint":{"name":"web"},"provider":"aws","image_id":"ami-0123"}`)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	require.Contains(t, resp.Body.String(), `"id":"UnknownComposeType"`)
+
+	resp = register(`{"compose_type":"qcow2","blueprint":{"name":"web"},"provider":"file","url":"http://example.com/disk.qcow2"}`)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = register(`{"compose_type":"qcow2","blueprint":{"name":"web","version":"1.2.0"},"provider":"aws","image_id":"ami-0123","region":"us-east-1","metadata":{"built-by":"packer"}}`)
+	require.Equal(t, http.StatusOK, resp.Code)
+	var reply struct {
+		Status bool      `json:"status"`
+		ID     uuid.UUID `json:"build_id"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &reply))
+	require.True(t, reply.Status)
+
+	c, exists := s.GetCompose(reply.ID)
+	require.True(t, exists)
+	state, _, _, _ := api.getComposeState(c)
+	require.Equal(t, common.CFinished, state)
+
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/info/"+reply.ID.String(), ``, http.StatusOK,
+		`{"id":"`+reply.ID.String()+`","config":"","blueprint":{"name":"web","description":"","version":"1.2.0","packages":null,"modules":null,"groups":null},"commit":"","deps":{"packages":[]},"compose_type":"qcow2","queue_status":"FINISHED","image_size":0,`+
+			`"registration":{"provider":"aws","image_id":"ami-0123","region":"us-east-1","metadata":{"built-by":"packer"}}}`, "registered_at")
+
+	// there is no image to promote
+	api.SetPromotionStages([]PromotionStage{{Name: "prod"}})
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose/promote/"+reply.ID.String()+"/prod", ``, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"BuildMissingFile","error_code":"ARTIFACT_NOT_FOUND","msg":"Build `+reply.ID.String()+` was registered without its image and cannot be promoted"}]}`)
+}
+
+func TestComposeConvert(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	dir, err := ioutil.TempDir("", "weldr-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// conversions need a store which keeps images
+	fixture := rpmmd_mock.NoComposesFixture()
+	arch, err := test_distro.New().GetArch("x86_64")
+	require.NoError(t, err)
+	s := store.New(&dir)
+	api := New(rpmmd_mock.NewRPMMDMock(fixture), arch, test_distro.New(), nil, nil, s, fixture.Workers)
+
+	convert := func(metadata, image string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		w := multipart.NewWriter(&body)
+		part, err := w.CreateFormField("metadata")
+		require.NoError(t, err)
+		_, err = part.Write([]byte(metadata))
+		require.NoError(t, err)
+		part, err = w.CreateFormFile("image", "disk.qcow2")
+		require.NoError(t, err)
+		_, err = part.Write([]byte(image))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		req := httptest.NewRequest("POST", "/api/v1/compose/convert", &body)
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		req.RemoteAddr = "pid=1,uid=0,gid=0"
+		resp := httptest.NewRecorder()
+		api.ServeHTTP(resp, req)
+		return resp
+	}
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose/convert", `{}`, http.StatusForbidden,
+		`{"status":false,"errors":[{"id":"PermissionDenied","error_code":"FORBIDDEN","msg":"only privileged clients may convert images"}]}`)
+
+	resp := convert(`{"compose_type":"floppy"}`, "qcow2")
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	require.Contains(t, resp.Body.String(), `"id":"UnknownComposeType"`)
+
+	resp = convert(`{"name":"legacy-app","compose_type":"qcow2","upload":{"provider":"aws","image_name":"legacy-app","settings":{"region":"us-east-1","bucket":"images"}}}`, "qcow2")
+	require.Equal(t, http.StatusOK, resp.Code)
+	var reply struct {
+		Status bool      `json:"status"`
+		ID     uuid.UUID `json:"build_id"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &reply))
+	require.True(t, reply.Status)
+
+	c, exists := s.GetCompose(reply.ID)
+	require.True(t, exists)
+	require.Equal(t, "legacy-app", c.Blueprint.Name)
+	require.Equal(t, "raw", c.Conversion.Format)
+	require.Equal(t, uint64(5), c.Conversion.InputSize)
+	require.NotEqual(t, uuid.Nil, c.ImageBuilds[0].JobId)
+	require.NotEqual(t, uuid.Nil, c.ImageBuilds[0].UploadJobId)
+	require.Len(t, c.ImageBuilds[0].Targets, 2)
+
+	state, _, _, _ := api.getComposeState(c)
+	require.Equal(t, common.CWaiting, state)
+
+	input, _, err := s.GetConversionInput(reply.ID)
+	require.NoError(t, err)
+	defer input.Close()
+	data, err := ioutil.ReadAll(input)
+	require.NoError(t, err)
+	require.Equal(t, "qcow2", string(data))
+}
+
+func TestComposeImageSignature(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	dir, err := ioutil.TempDir("", "weldr-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// signatures are stored next to images
+	fixture := rpmmd_mock.NoComposesFixture()
+	arch, err := test_distro.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+	s := store.New(&dir)
+	api := New(rpmmd_mock.NewRPMMDMock(fixture), arch, test_distro.New(), nil, nil, s, fixture.Workers)
+
+	id := uuid.New()
+	targets := []*target.Target{
+		target.NewLocalTarget(&target.LocalTargetOptions{Filename: imageType.Filename()}),
+	}
+	err = s.PushTestCompose(id, nil, imageType, &blueprint.Blueprint{Name: "test"}, 0, targets, nil, true)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(dir, "outputs", id.String(), "0", imageType.Filename()), []byte("image"), 0600)
+	require.NoError(t, err)
+
+	test.TestRoute(t, api, false, "GET", "/api/v0/compose/image/"+id.String()+"/signature", ``, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"BuildMissingFile","error_code":"ARTIFACT_NOT_FOUND","msg":"Image of build `+id.String()+` was not signed"}]}`)
+
+	resp := test.SendHTTP(api, false, "GET", "/api/v0/compose/image/"+id.String(), ``)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, resp.Header.Get("Digest"))
+
+	sum := sha256.Sum256([]byte("image"))
+	err = s.SetImageBuildSignature(id, 0, "sha256:"+hex.EncodeToString(sum[:]), []byte("signature"))
+	require.NoError(t, err)
+
+	resp = test.SendHTTP(api, false, "GET", "/api/v0/compose/image/"+id.String(), ``)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "sha-256="+base64.StdEncoding.EncodeToString(sum[:]), resp.Header.Get("Digest"))
+	etag := resp.Header.Get("ETag")
+	require.Equal(t, `"sha256:`+hex.EncodeToString(sum[:])+`"`, etag)
+
+	// downloads can be resumed, and cached by the checksum
+	request := httptest.NewRequest("GET", "/api/v0/compose/image/"+id.String(), nil)
+	request.Header.Set("Range", "bytes=2-")
+	recorder := httptest.NewRecorder()
+	api.ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusPartialContent, recorder.Code)
+	require.Equal(t, "bytes 2-4/5", recorder.Header().Get("Content-Range"))
+	require.Equal(t, "age", recorder.Body.String())
+
+	request = httptest.NewRequest("GET", "/api/v0/compose/image/"+id.String(), nil)
+	request.Header.Set("If-None-Match", etag)
+	recorder = httptest.NewRecorder()
+	api.ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusNotModified, recorder.Code)
+
+	filename := id.String() + "-" + imageType.Filename()
+	require.Equal(t, "attachment; filename="+filename, resp.Header.Get("Content-Disposition"))
+	resp = test.SendHTTP(api, false, "GET", "/api/v0/compose/image/"+id.String()+"/checksum", ``)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, hex.EncodeToString(sum[:])+"  "+filename+"\n", string(body))
+
+	resp = test.SendHTTP(api, false, "GET", "/api/v1/compose/image/"+id.String()+"/signature?image_build=0", ``)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "attachment; filename="+filename+".sig", resp.Header.Get("Content-Disposition"))
+	body, err = ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "signature", string(body))
+}
+
+func TestComposeImageCompression(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	dir, err := ioutil.TempDir("", "weldr-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fixture := rpmmd_mock.NoComposesFixture()
+	arch, err := test_distro.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+	s := store.New(&dir)
+	api := New(rpmmd_mock.NewRPMMDMock(fixture), arch, test_distro.New(), nil, nil, s, fixture.Workers)
+
+	id := uuid.New()
+	targets := []*target.Target{
+		target.NewLocalTarget(&target.LocalTargetOptions{Filename: imageType.Filename()}),
+	}
+	err = s.PushTestCompose(id, nil, imageType, &blueprint.Blueprint{Name: "test"}, 0, targets, nil, true)
+	require.NoError(t, err)
+	image := bytes.Repeat([]byte("image"), 1000)
+	err = ioutil.WriteFile(filepath.Join(dir, "outputs", id.String(), "0", imageType.Filename()), image, 0600)
+	require.NoError(t, err)
+	filename := id.String() + "-" + imageType.Filename()
+
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/image/"+id.String()+"?compression=lzma", ``, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"UnknownCompression","error_code":"INVALID_REQUEST","msg":"Unknown compression: lzma"}]}`)
+
+	// compressed images are downloaded as compressed files
+	resp := test.SendHTTP(api, false, "GET", "/api/v1/compose/image/"+id.String()+"?compression=gzip", ``)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "attachment; filename="+filename+".gz", resp.Header.Get("Content-Disposition"))
+	require.Equal(t, "application/gzip", resp.Header.Get("Content-Type"))
+	require.Empty(t, resp.Header.Get("Content-Encoding"))
+	decompressor, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(decompressor)
+	require.NoError(t, err)
+	require.Equal(t, image, body)
+
+	// or with a content encoding that clients accept
+	request := httptest.NewRequest("GET", "/api/v0/compose/image/"+id.String(), nil)
+	request.Header.Set("Accept-Encoding", "zstd;q=0, gzip")
+	recorder := httptest.NewRecorder()
+	api.ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+	require.Equal(t, "attachment; filename="+filename, recorder.Header().Get("Content-Disposition"))
+	require.True(t, recorder.Body.Len() < len(image))
+	decompressor, err = gzip.NewReader(recorder.Body)
+	require.NoError(t, err)
+	body, err = ioutil.ReadAll(decompressor)
+	require.NoError(t, err)
+	require.Equal(t, image, body)
+
+	// but not when resuming downloads
+	request.Header.Set("Range", "bytes=5-9")
+	recorder = httptest.NewRecorder()
+	api.ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusPartialContent, recorder.Code)
+	require.Empty(t, recorder.Header().Get("Content-Encoding"))
+	require.Equal(t, "image", recorder.Body.String())
+
+	// compressions whose tools aren't installed are not used
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	require.NoError(t, os.Setenv("PATH", dir))
+
+	request = httptest.NewRequest("GET", "/api/v0/compose/image/"+id.String(), nil)
+	request.Header.Set("Accept-Encoding", "zstd, gzip")
+	recorder = httptest.NewRecorder()
+	api.ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+
+	request.Header.Set("Accept-Encoding", "zstd")
+	recorder = httptest.NewRecorder()
+	api.ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Empty(t, recorder.Header().Get("Content-Encoding"))
+	require.Equal(t, image, recorder.Body.Bytes())
+
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/image/"+id.String()+"?compression=zstd", ``, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"CompressionUnavailable","error_code":"INVALID_REQUEST","msg":"Compression zstd is not available on this server"}]}`)
+}
+
+func TestComposeLogFollow(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, _ := createWeldrAPI(rpmmd_mock.BaseFixture)
+
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/log/30000000-0000-0000-0000-000000000002/follow", ``, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"BuildInWrongState","error_code":"COMPOSE_WRONG_STATE","msg":"Build 30000000-0000-0000-0000-000000000002 is not waiting or running. Use /compose/log to view its log."}]}`)
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/log/30000000-0000-0000-0000-000000000005/follow", ``, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"UnknownUUID","error_code":"COMPOSE_NOT_FOUND","msg":"Compose 30000000-0000-0000-0000-000000000005 doesn't exist"}]}`)
+}
+
+func TestComposeCheckpoints(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, s := createWeldrAPI(rpmmd_mock.NoComposesFixture)
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose",
+		`{"blueprint_name":"test","compose_type":"qcow2","branch":"master","checkpoints":["image"]}`, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"BadCompose","error_code":"INVALID_REQUEST","msg":"Unknown checkpoint: image"}]}`)
+	test.TestRoute(t, api, false, "POST", "/api/v0/compose",
+		`{"blueprint_name":"test","compose_type":"qcow2","branch":"master","checkpoints":["tree"]}`, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"BadCompose","error_code":"INVALID_REQUEST","msg":"checkpoints require API version 1"}]}`)
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose?test=2",
+		`{"blueprint_name":"test","compose_type":"qcow2","branch":"master","checkpoints":["tree"]}`, http.StatusOK, `*`)
+
+	var id uuid.UUID
+	for composeID, c := range s.GetAllComposes() {
+		id = composeID
+		require.Equal(t, []string{"tree"}, c.ImageBuilds[0].GetLocalTargetOptions().Checkpoints)
+	}
+
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/checkpoint/"+id.String()+"/tree", ``, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"BuildMissingFile","error_code":"ARTIFACT_NOT_FOUND","msg":"Build `+id.String()+` has no checkpoint tree: checkpoint tree was not exported"}]}`)
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/checkpoint/"+id.String()+"/image", ``, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"BuildMissingFile","error_code":"ARTIFACT_NOT_FOUND","msg":"Build `+id.String()+` has no checkpoint image: checkpoint image was not requested"}]}`)
+}
+
+func TestComposeOSTree(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, s := createWeldrAPI(rpmmd_mock.NoComposesFixture)
+
+	test.TestRoute(t, api, false, "POST", "/api/v0/compose",
+		`{"blueprint_name":"test","compose_type":"qcow2","branch":"master","ostree":{"ref":"example/iot"}}`, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"BadCompose","error_code":"INVALID_REQUEST","msg":"ostree parameters require API version 1"}]}`)
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose",
+		`{"blueprint_name":"test","compose_type":"qcow2","branch":"master","ostree":{"ref":"/example/iot"}}`, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"InvalidChars","error_code":"INVALID_REQUEST","msg":"Invalid ostree ref: /example/iot"}]}`)
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose",
+		`{"blueprint_name":"test","compose_type":"qcow2","branch":"master","ostree":{"ref":"example/iot","parent":"master"}}`, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"InvalidChars","error_code":"INVALID_REQUEST","msg":"Invalid ostree parent commit: master"}]}`)
+	require.Empty(t, s.GetAllComposes())
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose?test=2",
+		`{"blueprint_name":"test","compose_type":"qcow2","branch":"master","ostree":{"ref":"example/iot","parent":"8d2cb4ee5d4d0c27c41bc1d2ed9b6a0a24c6a5a31f7a3bd7a6c6a2c5e9b7f001"}}`, http.StatusOK, `*`)
+	require.Len(t, s.GetAllComposes(), 1)
+}
+
+func TestComposeMetadata(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, s := createWeldrAPI(rpmmd_mock.NoComposesFixture)
+	s.PushSource(store.SourceConfig{Name: "extra", Type: "yum-baseurl", URL: "http://example.com/extra", CheckSSL: true})
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose?test=2",
+		`{"blueprint_name":"test","compose_type":"qcow2","branch":"master"}`, http.StatusOK, `*`)
+	var id uuid.UUID
+	for composeID := range s.GetAllComposes() {
+		id = composeID
+	}
+
+	// the repositories are kept after a source is deleted
+	s.DeleteSource("extra")
+
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/metadata/"+id.String(), ``, http.StatusOK,
+		`{"id":"`+id.String()+`","blueprint":{"name":"test","description":"","version":"0.0.0","packages":[],"modules":[],"groups":[]},`+
+			`"repositories":[{"id":"test-id","baseurl":"http://example.com/test/os/x86_64","ignoressl":false},{"id":"extra","baseurl":"http://example.com/extra","ignoressl":false}]}`)
+
+	test.TestRoute(t, api, false, "GET", "/api/v0/compose/metadata/"+id.String(), ``, http.StatusNotFound, `*`)
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/metadata/"+uuid.New().String(), ``, http.StatusBadRequest, `*`)
+}
+
+func TestComposeMultipleImageTypes(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, s := createWeldrAPI(rpmmd_mock.NoComposesFixture)
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose",
+		`{"blueprint_name":"test","compose_type":"qcow2","compose_types":["qcow2","openstack"],"branch":"master"}`, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"BadCompose","error_code":"INVALID_REQUEST","msg":"compose_type and compose_types cannot be used together"}]}`)
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose",
+		`{"blueprint_name":"test","compose_types":["qcow2","qcow2"],"branch":"master"}`, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"BadCompose","error_code":"INVALID_REQUEST","msg":"Duplicate compose type: qcow2"}]}`)
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose",
+		`{"blueprint_name":"test","compose_types":["qcow2","vhd"],"branch":"master"}`, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"UnknownComposeType","error_code":"UNKNOWN_IMAGE_TYPE","msg":"Unknown compose type for architecture: vhd"}]}`)
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose",
+		`{"blueprint_name":"test","compose_types":["qcow2","openstack"],"branch":"master","upload":{"image_name":"test","provider":"aws","settings":{}}}`, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"BadCompose","error_code":"INVALID_REQUEST","msg":"upload cannot be used for composes of several image types, use uploads instead"}]}`)
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose",
+		`{"blueprint_name":"test","compose_types":["qcow2","openstack"],"branch":"master","uploads":{"vhd":{"image_name":"test","provider":"aws","settings":{}}}}`, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"BadCompose","error_code":"INVALID_REQUEST","msg":"upload for compose type vhd, which is not part of compose_types"}]}`)
+	require.Empty(t, s.GetAllComposes())
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose?test=2",
+		`{"blueprint_name":"test","compose_types":["qcow2","openstack"],"branch":"master"}`, http.StatusOK, `*`)
+
+	var id uuid.UUID
+	for composeID, c := range s.GetAllComposes() {
+		id = composeID
+		require.Len(t, c.ImageBuilds, 2)
+		require.Equal(t, common.Qcow2Generic, c.ImageBuilds[0].ImageType)
+		require.Equal(t, common.OpenStack, c.ImageBuilds[1].ImageType)
+		require.Equal(t, 1, c.ImageBuilds[1].GetLocalTargetOptions().ImageBuildId)
+	}
+
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/status/"+id.String(), ``, http.StatusOK,
+		`{"uuids":[{"id":"`+id.String()+`","blueprint":"test","version":"0.0.0","compose_type":"qcow2","compose_types":["qcow2","OpenStack"],"image_size":0,"queue_status":"FINISHED"}]}`,
+		"job_created", "job_started", "job_finished", "progress")
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/info/"+id.String(), ``, http.StatusOK,
+		`{"id":"`+id.String()+`","config":"","blueprint":{"name":"test","description":"","version":"0.0.0","packages":[],"modules":[],"groups":[]},"commit":"","deps":{"packages":[]},"compose_type":"qcow2","queue_status":"FINISHED","image_size":0,"image_builds":[{"id":0,"compose_type":"qcow2","queue_status":"FINISHED","image_size":0},{"id":1,"compose_type":"openstack","queue_status":"FINISHED","image_size":0}]}`)
+
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/image/"+id.String()+"?image_build=2", ``, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"BadCompose","error_code":"INVALID_REQUEST","msg":"Compose `+id.String()+` has no image build 2"}]}`)
+}
+
+func TestComposeMultipleImageTypesUploads(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, s := createWeldrAPI(rpmmd_mock.NoComposesFixture)
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose?test=2",
+		`{"blueprint_name":"test","compose_types":["qcow2","openstack"],"branch":"master","uploads":{"openstack":{"image_name":"test","provider":"aws","settings":{"region":"frankfurt","bucket":"clay"}}}}`, http.StatusOK, `*`)
+
+	composes := s.GetAllComposes()
+	require.Len(t, composes, 1)
+	for _, c := range composes {
+		require.Len(t, c.ImageBuilds, 2)
+		require.Len(t, c.ImageBuilds[0].Targets, 1)
+		require.Equal(t, "org.osbuild.local", c.ImageBuilds[0].Targets[0].Name)
+		require.Len(t, c.ImageBuilds[1].Targets, 2)
+		require.Equal(t, "org.osbuild.aws", c.ImageBuilds[1].Targets[0].Name)
+		require.Equal(t, "test", c.ImageBuilds[1].Targets[0].ImageName)
+	}
+}
+
+// failingJobQueue fails all calls of Enqueue() after the first `enqueues`.
+type failingJobQueue struct {
+	jobqueue.Canceler
+	enqueues int
+}
+
+func (q *failingJobQueue) Enqueue(jobType string, args interface{}, dependencies []uuid.UUID, priority int) (uuid.UUID, error) {
+	if q.enqueues == 0 {
+		return uuid.Nil, errors.New("the job queue is full")
+	}
+	q.enqueues--
+	return q.Canceler.Enqueue(jobType, args, dependencies, priority)
+}
+
+func TestComposeMultipleImageTypesRollback(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	// Both image builds are queued, but queueing the scan of the first
+	// one fails after the compose was added to the store
+	queue := &failingJobQueue{testjobqueue.New(), 2}
+	api, s := createWeldrAPI(func() rpmmd_mock.Fixture {
+		fixture := rpmmd_mock.NoComposesFixture()
+		fixture.Workers = worker.NewServer(nil, queue, nil, "")
+		fixture.Workers.EnableScans()
+		return fixture
+	})
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose",
+		`{"blueprint_name":"test","compose_types":["qcow2","openstack"],"branch":"master"}`, http.StatusInternalServerError,
+		`{"status":false,"errors":[{"id":"ComposePushErrored","error_code":"INTERNAL_ERROR","msg":"the job queue is full"}]}`)
+
+	require.Equal(t, 0, queue.enqueues)
+	require.Empty(t, s.GetAllComposes())
+	_, total, err := queue.ListJobs(jobqueue.JobFilter{}, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, 0, total)
+}
+
+func TestSourceStats(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, _ := createWeldrAPI(rpmmd_mock.NoComposesFixture)
+
+	test.TestRoute(t, api, false, "GET", "/api/v1/projects/source/info/test-id", ``, http.StatusOK,
+		`{"sources":{"test-id":{"name":"test-id","type":"yum-baseurl","url":"http://example.com/test/os/x86_64","check_gpg":true,"check_ssl":true,"system":true}},"stats":{"test-id":{"composes":0,"consecutive_failures":0,"last_fetched":"0001-01-01T00:00:00Z","last_failed":"0001-01-01T00:00:00Z"}},"errors":[]}`)
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose",
+		`{"blueprint_name":"test","compose_type":"qcow2","branch":"master"}`, http.StatusOK, `*`)
+	test.TestRoute(t, api, false, "GET", "/api/v1/projects/source/info/test-id", ``, http.StatusOK,
+		`{"sources":{"test-id":{"name":"test-id","type":"yum-baseurl","url":"http://example.com/test/os/x86_64","check_gpg":true,"check_ssl":true,"system":true}},"stats":{"test-id":{"composes":1,"consecutive_failures":0,"last_failed":"0001-01-01T00:00:00Z"}},"errors":[]}`, "last_fetched")
+
+	// v0 doesn't return stats
+	test.TestRoute(t, api, false, "GET", "/api/v0/projects/source/info/test-id", ``, http.StatusOK,
+		`{"sources":{"test-id":{"name":"test-id","type":"yum-baseurl","url":"http://example.com/test/os/x86_64","check_gpg":true,"check_ssl":true,"system":true}},"errors":[]}`)
+
+	api, _ = createWeldrAPI(rpmmd_mock.BadFetch)
+	test.TestRoute(t, api, false, "GET", "/api/v0/modules/list", ``, http.StatusBadRequest, `*`)
+	test.TestRoute(t, api, false, "GET", "/api/v1/projects/source/info/test-id", ``, http.StatusOK,
+		`{"sources":{"test-id":{"name":"test-id","type":"yum-baseurl","url":"http://example.com/test/os/x86_64","check_gpg":true,"check_ssl":true,"system":true}},"stats":{"test-id":{"composes":0,"consecutive_failures":1,"last_fetched":"0001-01-01T00:00:00Z","last_error":"There was a problem when fetching packages."}},"errors":[]}`, "last_failed")
+}
+
+func TestComposeUsing(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, _ := createWeldrAPI(rpmmd_mock.NoComposesFixture)
+
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/using/dep-package3", ``, http.StatusOK, `{"composes":[]}`)
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose",
+		`{"blueprint_name":"test","compose_type":"qcow2","branch":"master"}`, http.StatusOK, `*`)
+
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/using/dep-package3?version=3.0.3", ``, http.StatusOK,
+		`{"composes":[{"blueprint":"test","version":"0.0.0","compose_type":"qcow2","queue_status":"WAITING","package":{"name":"dep-package3","epoch":7,"version":"3.0.3","release":"1.fc30","arch":"x86_64"}}]}`, "id")
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/using/dep-package3?version=3.0.4", ``, http.StatusOK, `{"composes":[]}`)
+	test.TestRoute(t, api, false, "GET", "/api/v0/compose/using/dep-package3", ``, http.StatusNotFound, `*`)
+}
+
+func TestComposeDiff(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, s := createWeldrAPI(rpmmd_mock.NoComposesFixture)
+	arch, err := test_distro.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+
+	hostname := "octopus"
+	from := uuid.MustParse("30000000-0000-0000-0000-000000000001")
+	to := uuid.MustParse("30000000-0000-0000-0000-000000000002")
+	err = s.PushCompose(from, nil, imageType, &blueprint.Blueprint{Name: "test"}, 0, nil, nil, uuid.New())
+	require.NoError(t, err)
+	err = s.PushCompose(to, nil, imageType, &blueprint.Blueprint{Name: "test", Customizations: &blueprint.Customizations{Hostname: &hostname}}, 0, nil, nil, uuid.New())
+	require.NoError(t, err)
+	require.NoError(t, s.SetImageBuildPackages(from, 0, []rpmmd.PackageSpec{
+		{Name: "bash", Version: "5.0.11", Release: "1.fc32", Arch: "x86_64"},
+		{Name: "openssl", Version: "1.1.1g", Release: "1.fc32", Arch: "x86_64"},
+		{Name: "tar", Version: "1.32", Release: "4.fc32", Arch: "x86_64"},
+		{Name: "vim", Version: "8.2", Release: "1.fc32", Arch: "x86_64"},
+	}, nil))
+	require.NoError(t, s.SetImageBuildPackages(to, 0, []rpmmd.PackageSpec{
+		{Name: "bash", Version: "5.0.11", Release: "1.fc32", Arch: "x86_64", Checksum: "sha256:aaaa"},
+		{Name: "openssl", Version: "1.1.1g", Release: "10.fc32", Arch: "x86_64"},
+		{Name: "tar", Version: "1.32", Release: "3.fc32", Arch: "x86_64"},
+		{Name: "zsh", Version: "5.8", Release: "2.fc32", Arch: "x86_64"},
+	}, nil))
+
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/diff/"+from.String()+"/"+to.String(), ``, http.StatusOK,
+		`{"from":"30000000-0000-0000-0000-000000000001","to":"30000000-0000-0000-0000-000000000002","packages":{`+
+			`"added":[{"name":"zsh","epoch":0,"version":"5.8","release":"2.fc32","arch":"x86_64"}],`+
+			`"removed":[{"name":"vim","epoch":0,"version":"8.2","release":"1.fc32","arch":"x86_64"}],`+
+			`"upgraded":[{"old":{"name":"openssl","epoch":0,"version":"1.1.1g","release":"1.fc32","arch":"x86_64"},"new":{"name":"openssl","epoch":0,"version":"1.1.1g","release":"10.fc32","arch":"x86_64"}}],`+
+			`"downgraded":[{"old":{"name":"tar","epoch":0,"version":"1.32","release":"4.fc32","arch":"x86_64"},"new":{"name":"tar","epoch":0,"version":"1.32","release":"3.fc32","arch":"x86_64"}}]},`+
+			`"customizations":[{"name":"hostname","old":null,"new":"octopus"}]}`)
+
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/diff/"+from.String()+"/"+from.String(), ``, http.StatusOK,
+		`{"from":"30000000-0000-0000-0000-000000000001","to":"30000000-0000-0000-0000-000000000001","packages":{"added":[],"removed":[],"upgraded":[],"downgraded":[]},"customizations":[]}`)
+
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/diff/"+from.String()+"/"+uuid.New().String(), ``, http.StatusBadRequest, `*`)
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/diff/"+from.String()+"/octopus", ``, http.StatusBadRequest, `*`)
+	test.TestRoute(t, api, false, "GET", "/api/v0/compose/diff/"+from.String()+"/"+to.String(), ``, http.StatusNotFound, `*`)
+}
+
+func TestBlueprintsValidate(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, _ := createWeldrAPI(rpmmd_mock.BaseFixture)
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/blueprints/validate?compose_type=qcow2&depsolve=true",
+		`{"name":"test","version":"0.0.1","packages":[{"name":"dep-package1","version":"*"}]}`, http.StatusOK,
+		`{"valid":true,"errors":[],"warnings":[]}`)
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/blueprints/validate",
+		`{"name":"test","version":"1","packages":[{"name":"tmux"},{"name":"tmux"}],"customizations":{"sshkey":[{"user":"root","key":"ssh-rsa AAAA"}]},"colour":"blue"}`, http.StatusOK,
+		`{"valid":false,"errors":[{"field":"version","message":"must use Semantic Versioning: 1 is not in dotted-tri format"},{"field":"packages[1].name","message":"tmux is listed more than once, also in packages[0]"}],`+
+			`"warnings":[{"id":"UnknownField","msg":"unknown field colour is ignored"},{"id":"DeprecatedCustomization","msg":"the sshkey customization is deprecated, use the key field of the user customization instead"}]}`)
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/blueprints/validate",
+		`{"name":"test","enabled_modules":[{"name":"nodejs","stream":"14"},{"name":"nodejs","stream":"12"}]}`, http.StatusOK,
+		`{"valid":false,"errors":[{"field":"enabled_modules[1].name","message":"only one stream of module nodejs can be enabled, also in enabled_modules[0]"}],"warnings":[]}`)
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/blueprints/validate", `{"name":`, http.StatusOK,
+		`{"valid":false,"errors":[{"message":"invalid JSON: unexpected end of JSON input"}],"warnings":[]}`)
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/blueprints/validate?compose_type=octopus", `{"name":"test"}`, http.StatusBadRequest, `*`)
+	test.TestRoute(t, api, false, "POST", "/api/v0/blueprints/validate", `{"name":"test"}`, http.StatusNotFound, `*`)
+
+	req := httptest.NewRequest("POST", "/api/v1/blueprints/validate", bytes.NewReader([]byte("name = \"test\"\ncolour = \"blue\"\n")))
+	req.Header.Set("Content-Type", "text/x-toml")
+	recorder := httptest.NewRecorder()
+	api.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.JSONEq(t, `{"valid":true,"errors":[],"warnings":[{"id":"UnknownField","msg":"unknown field colour is ignored"}]}`, recorder.Body.String())
+
+	api, _ = createWeldrAPI(rpmmd_mock.BadDepsolve)
+	test.TestRoute(t, api, false, "POST", "/api/v1/blueprints/validate?depsolve=true", `{"name":"test"}`, http.StatusOK,
+		`{"valid":false,"errors":[{"field":"packages","message":"DNF error occured: DepsolveError: There was a problem depsolving ['go2rpm']: \n Problem: conflicting requests\n  - nothing provides askalono-cli needed by go2rpm-1-4.fc31.noarch"}],"warnings":[]}`)
+}
+
+func TestUploadsHealth(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, s := createWeldrAPI(rpmmd_mock.NoComposesFixture)
+	test.TestRoute(t, api, false, "GET", "/api/v1/upload/health", ``, http.StatusOK, `{"targets":[]}`)
+
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, s.UploadFinished("org.osbuild.azure", "account/images", now, 2*time.Minute, ""))
+	require.NoError(t, s.UploadFinished("org.osbuild.aws", "AKIA@us-east-1/images", now, 3*time.Minute, ""))
+	require.NoError(t, s.UploadFinished("org.osbuild.aws", "AKIA@us-east-1/images", now.Add(time.Hour), time.Minute, "AccessDenied"))
+
+	test.TestRoute(t, api, false, "GET", "/api/v1/upload/health", ``, http.StatusOK, `{"targets":[`+
+		`{"provider":"aws","profile":"AKIA@us-east-1/images","healthy":false,"uploads":2,"failures":1,"success_rate":0.5,"average_duration":120,"last_succeeded":"2020-06-01T12:00:00Z","last_failed":"2020-06-01T13:00:00Z","last_error":"AccessDenied"},`+
+		`{"provider":"azure","profile":"account/images","healthy":true,"uploads":1,"failures":0,"success_rate":1,"average_duration":120,"last_succeeded":"2020-06-01T12:00:00Z","last_failed":"0001-01-01T00:00:00Z"}]}`)
+	test.TestRoute(t, api, false, "GET", "/api/v0/upload/health", ``, http.StatusNotFound, `*`)
+}
+
+func TestNightly(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, s := createWeldrAPI(rpmmd_mock.NoComposesFixture)
+	api.SetPromotionStages([]PromotionStage{
+		{Name: "staging", Uploads: []uploadRequest{{Provider: "aws", ImageName: "image", Settings: &awsUploadSettings{Region: "eu-central-1", Bucket: "staging"}}}},
+		{Name: "prod", After: "staging", Uploads: []uploadRequest{{Provider: "aws", ImageName: "image", Settings: &awsUploadSettings{Region: "eu-central-1", Bucket: "prod"}}}},
+	})
+
+	require.Error(t, api.SetNightly(&NightlyConfig{At: "02:30", Blueprints: []string{"test"}, ComposeType: "foo"}))
+	require.Error(t, api.SetNightly(&NightlyConfig{At: "02:30", Blueprints: []string{"test"}, ComposeType: "qcow2", PublishStage: "prod"}))
+	require.NoError(t, api.SetNightly(&NightlyConfig{At: "02:30", Blueprints: []string{"test", "missing"}, ComposeType: "qcow2", PublishStage: "staging"}))
+
+	var tested []uuid.UUID
+	api.testNightlyImage = func(id uuid.UUID) error {
+		tested = append(tested, id)
+		return nil
+	}
+
+	day := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	// not due yet
+	require.NoError(t, api.RunNightly(day.Add(time.Hour)))
+	require.Empty(t, s.GetNightlyRuns())
+
+	require.NoError(t, api.RunNightly(day.Add(3*time.Hour)))
+	runs := s.GetNightlyRuns()
+	require.Len(t, runs, 1)
+	require.Equal(t, map[string]string{"base": "sha256:f34848ca92665c342abd5816c9e3eda0e82180671195362bcd0080544a3bc2ac"}, runs[0].Snapshot)
+	require.Len(t, runs[0].Builds, 2)
+	require.Equal(t, store.NightlyBuilding, runs[0].Builds[0].State)
+	require.Equal(t, store.NightlyFailed, runs[0].Builds[1].State)
+	require.Equal(t, "missing: blueprint not found", runs[0].Builds[1].Error)
+
+	// the build is still running
+	require.NoError(t, api.RunNightly(day.Add(4*time.Hour)))
+	require.Len(t, s.GetNightlyRuns(), 1)
+	require.Empty(t, tested)
+
+	id := runs[0].Builds[0].ComposeID
+	c, exists := s.GetCompose(id)
+	require.True(t, exists)
+	test.TestRoute(t, api.workers, false, "POST", "/job-queue/v1/jobs", `{"arches":["x86_64"]}`, http.StatusCreated, `*`)
+	test.TestRoute(t, api.workers, false, "PATCH", "/job-queue/v1/jobs/"+c.ImageBuilds[0].JobId.String(), `{"status":"FINISHED","result":{"success":true}}`, http.StatusOK, `*`)
+
+	require.NoError(t, api.RunNightly(day.Add(5*time.Hour)))
+	runs = s.GetNightlyRuns()
+	require.Len(t, runs, 1)
+	require.Equal(t, []uuid.UUID{id}, tested)
+	require.Equal(t, store.NightlyPublished, runs[0].Builds[0].State)
+	require.Equal(t, day.Add(5*time.Hour), runs[0].Finished)
+
+	c, _ = s.GetCompose(id)
+	require.Len(t, c.Promotions, 1)
+	require.Equal(t, "staging", c.Promotions[0].Stage)
+
+	// only one run per day
+	require.NoError(t, api.RunNightly(day.Add(6*time.Hour)))
+	require.NoError(t, api.RunNightly(day.Add(26*time.Hour)))
+	require.Len(t, s.GetNightlyRuns(), 1)
+	require.NoError(t, api.RunNightly(day.Add(27*time.Hour)))
+	require.Len(t, s.GetNightlyRuns(), 2)
+
+	test.TestRoute(t, api, false, "GET", "/api/v0/nightly", ``, http.StatusNotFound, `*`)
+
+	resp := test.SendHTTP(api, false, "GET", "/api/v1/nightly", ``)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var reply struct {
+		Config NightlyConfig      `json:"config"`
+		Runs   []store.NightlyRun `json:"runs"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&reply))
+	require.Equal(t, "staging", reply.Config.PublishStage)
+	require.Len(t, reply.Runs, 2)
+	require.Equal(t, runs[0].ID, reply.Runs[1].ID)
+}
+
+func TestProjectsSearch(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, _ := createWeldrAPI(rpmmd_mock.BaseFixture)
+
+	test.TestRoute(t, api, false, "GET", "/api/v1/projects/search?name=package1*&limit=2", ``, http.StatusOK, `{"total":11,"offset":0,"limit":2,"projects":[`+
+		`{"name":"package1","summary":"pkg1 sum","description":"pkg1 desc","homepage":"https://pkg1.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-02-02T15:04:05","epoch":0,"release":"1.fc30","source":{"license":"MIT","version":"1.0"}},{"arch":"x86_64","build_time":"2006-02-03T15:04:05","epoch":0,"release":"1.fc30","source":{"license":"MIT","version":"1.1"}}]},`+
+		`{"name":"package10","summary":"pkg10 sum","description":"pkg10 desc","homepage":"https://pkg10.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-11-02T15:04:05","epoch":0,"release":"10.fc30","source":{"license":"MIT","version":"10.0"}},{"arch":"x86_64","build_time":"2006-11-03T15:04:05","epoch":0,"release":"10.fc30","source":{"license":"MIT","version":"10.1"}}]}]}`)
+	test.TestRoute(t, api, false, "GET", "/api/v1/projects/search?summary=PKG12+SUM", ``, http.StatusOK, `{"total":1,"offset":0,"limit":20,"projects":[`+
+		`{"name":"package12","summary":"pkg12 sum","description":"pkg12 desc","homepage":"https://pkg12.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2007-01-02T15:04:05","epoch":0,"release":"12.fc30","source":{"license":"MIT","version":"12.0"}},{"arch":"x86_64","build_time":"2007-01-03T15:04:05","epoch":0,"release":"12.fc30","source":{"license":"MIT","version":"12.1"}}]}]}`)
+	test.TestRoute(t, api, false, "GET", "/api/v1/projects/search?summary=desc&offset=21", ``, http.StatusOK, `{"total":22,"offset":21,"limit":20,"projects":[`+
+		`{"name":"package9","summary":"pkg9 sum","description":"pkg9 desc","homepage":"https://pkg9.example.com","upstream_vcs":"","builds":[{"arch":"x86_64","build_time":"2006-10-02T15:04:05","epoch":0,"release":"9.fc30","source":{"license":"MIT","version":"9.0"}},{"arch":"x86_64","build_time":"2006-10-03T15:04:05","epoch":0,"release":"9.fc30","source":{"license":"MIT","version":"9.1"}}]}]}`)
+	test.TestRoute(t, api, false, "GET", "/api/v1/projects/search?repo=updates", ``, http.StatusOK, `{"total":0,"offset":0,"limit":20,"projects":[]}`)
+	test.TestRoute(t, api, false, "GET", "/api/v1/projects/search?name=[", ``, http.StatusBadRequest, `*`)
+	test.TestRoute(t, api, false, "GET", "/api/v1/projects/search?limit=-1", ``, http.StatusBadRequest, `*`)
+	test.TestRoute(t, api, false, "GET", "/api/v0/projects/search", ``, http.StatusNotFound, `*`)
+}
+
+func TestProjectsCacheInvalidate(t *testing.T) {
+	api, _ := createWeldrAPI(rpmmd_mock.BaseFixture)
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/projects/cache/invalidate", ``, http.StatusBadRequest, `{"status":false,"errors":[{"id":"ProjectsError","msg":"repository metadata is not cached","error_code":"INVALID_REQUEST"}]}`)
+
+	api.SetMetadataCache(rpmmd.NewCached(api.rpmmd, time.Hour))
+	test.TestRoute(t, api, false, "POST", "/api/v1/projects/cache/invalidate", ``, http.StatusOK, `{"status":true}`)
+	test.TestRoute(t, api, false, "POST", "/api/v1/projects/cache/invalidate?repo=test-id", ``, http.StatusOK, `{"status":true}`)
+	test.TestRoute(t, api, false, "POST", "/api/v0/projects/cache/invalidate", ``, http.StatusNotFound, `*`)
+}
+
+func TestSurfaces(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, _ := createWeldrAPI(rpmmd_mock.BaseFixture)
+
+	var cases = []struct {
+		Surfaces       []string
+		Method         string
+		Path           string
+		ExpectedStatus int
+	}{
+		{[]string{SurfaceWeldrV1}, "GET", "/api/status", http.StatusOK},
+		{[]string{SurfaceWeldrV1}, "GET", "/api/v1/blueprints/list", http.StatusOK},
+		{[]string{SurfaceWeldrV1}, "GET", "/api/v0/blueprints/list", http.StatusNotFound},
+		{[]string{SurfaceWeldrV1}, "GET", "/api/v1/config", http.StatusNotFound},
+		{[]string{SurfaceWeldrV1}, "GET", "/api/v1/blueprints/workspace", http.StatusNotFound},
+		{[]string{SurfaceWeldrV1}, "GET", "/metrics", http.StatusNotFound},
+		{[]string{SurfaceWeldrV0}, "GET", "/api/v0/blueprints/list", http.StatusOK},
+		{[]string{SurfaceAdmin}, "GET", "/api/v1/config", http.StatusForbidden},
+		{[]string{SurfaceAdmin}, "GET", "/api/v1/blueprints/workspace", http.StatusForbidden},
+		{[]string{SurfaceAdmin}, "GET", "/api/v1/compose/export/" + uuid.New().String(), http.StatusForbidden},
+		{[]string{SurfaceAdmin}, "GET", "/api/v1/blueprints/list", http.StatusNotFound},
+		{[]string{SurfaceMetrics}, "GET", "/metrics", http.StatusOK},
+		{[]string{SurfaceMetrics}, "GET", "/api/status", http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		resp := test.SendHTTP(surfaceHandler{api, c.Surfaces}, false, c.Method, c.Path, ``)
+		require.Equalf(t, c.ExpectedStatus, resp.StatusCode, "%s %s on %v", c.Method, c.Path, c.Surfaces)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, _ := createWeldrAPI(rpmmd_mock.BaseFixture)
+	resp := test.SendHTTP(api, false, "GET", "/metrics", ``)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), `osbuild_composer_composes{state="FINISHED"} 1`)
+	require.Contains(t, string(body), `osbuild_composer_composes{state="RUNNING"} 1`)
+}
+
+func TestSocketsConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weldr-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "sockets.toml")
+	socketPath := filepath.Join(dir, "admin.socket")
+	err = ioutil.WriteFile(configPath, []byte(`
+[[socket]]
+path = "`+socketPath+`"
+mode = "0600"
+surfaces = ["admin"]
+`), 0600)
+	require.NoError(t, err)
+
+	config, err := LoadSocketsConfig(configPath, false)
+	require.NoError(t, err)
+	require.Len(t, config.Sockets, 1)
+
+	listener, err := config.Sockets[0].Listen()
+	require.NoError(t, err)
+	info, err := os.Stat(socketPath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	listener.Close()
+
+	for _, invalid := range []string{
+		`[[socket]]
+path = "relative.socket"
+surfaces = ["admin"]`,
+		`[[socket]]
+path = "/run/test.socket"
+surfaces = ["frontend"]`,
+		`[[socket]]
+path = "/run/test.socket"
+mode = "0999"
+surfaces = ["admin"]`,
+		`[[socket]]
+path = "/run/test.socket"
+surfaces = []`,
+	} {
+		err = ioutil.WriteFile(configPath, []byte(invalid), 0600)
+		require.NoError(t, err)
+		_, err = LoadSocketsConfig(configPath, false)
+		require.Errorf(t, err, "config should be invalid: %s", invalid)
+	}
+}
+
+func TestTLSSocket(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	dir, err := ioutil.TempDir("", "weldr-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca, caKey := test.WriteCertificate(t, dir, "ca", nil, nil)
+	test.WriteCertificate(t, dir, "composer", ca, caKey)
+	test.WriteCertificate(t, dir, "client", ca, caKey)
+
+	configPath := filepath.Join(dir, "sockets.toml")
+	err = ioutil.WriteFile(configPath, []byte(`
+[[tls]]
+address = "127.0.0.1:0"
+cert = "`+filepath.Join(dir, "composer-crt.pem")+`"
+key = "`+filepath.Join(dir, "composer-key.pem")+`"
+ca = "`+filepath.Join(dir, "ca-crt.pem")+`"
+surfaces = ["weldr-v1"]
+`), 0600)
+	require.NoError(t, err)
+
+	config, err := LoadSocketsConfig(configPath, false)
+	require.NoError(t, err)
+	require.Len(t, config.TLS, 1)
+
+	listener, err := config.TLS[0].Listen()
+	require.NoError(t, err)
+	defer listener.Close()
+
+	api, _ := createWeldrAPI(rpmmd_mock.BaseFixture)
+	go func() {
+		_ = api.ServeSurfaces(listener, config.TLS[0].Surfaces)
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	clientCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client-crt.pem"), filepath.Join(dir, "client-key.pem"))
+	require.NoError(t, err)
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{clientCert}},
+		},
+	}
+	url := "https://" + listener.Addr().String()
+
+	resp, err := client.Get(url + "/api/v1/blueprints/list")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// only the configured surfaces are served
+	resp, err = client.Get(url + "/api/v0/blueprints/list")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// clients without certificate are rejected
+	client = &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots},
+		},
+	}
+	_, err = client.Get(url + "/api/v1/blueprints/list")
+	require.Error(t, err)
+
+	for _, invalid := range []string{
+		`[[tls]]
+cert = "composer-crt.pem"
+key = "composer-key.pem"
+surfaces = ["weldr-v1"]`,
+		`[[tls]]
+address = ":8443"
+cert = "composer-crt.pem"
+surfaces = ["weldr-v1"]`,
+		`[[tls]]
+address = ":8443"
+cert = "composer-crt.pem"
+key = "composer-key.pem"
+surfaces = ["frontend"]`,
+	} {
+		err = ioutil.WriteFile(configPath, []byte(invalid), 0600)
+		require.NoError(t, err)
+		_, err = LoadSocketsConfig(configPath, true)
+		require.Errorf(t, err, "config should be invalid: %s", invalid)
+	}
+
+	// without a ca, clients must authenticate with a token
+	err = ioutil.WriteFile(configPath, []byte(`[[tls]]
+address = ":8443"
+cert = "composer-crt.pem"
+key = "composer-key.pem"
+surfaces = ["weldr-v1"]`), 0600)
+	require.NoError(t, err)
+	_, err = LoadSocketsConfig(configPath, false)
+	require.Error(t, err)
+	_, err = LoadSocketsConfig(configPath, true)
+	require.NoError(t, err)
+}
+
+func TestComposeArch(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, s := createWeldrAPI(rpmmd_mock.NoComposesFixture)
+
+	test.TestRoute(t, api, false, "POST", "/api/v0/compose",
+		`{"blueprint_name":"test","compose_type":"qcow2","branch":"master","arch":"aarch64"}`, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"BadCompose","error_code":"INVALID_REQUEST","msg":"composes for other architectures require API version 1"}]}`)
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose",
+		`{"blueprint_name":"test","compose_type":"qcow2","branch":"master","arch":"s390x"}`, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"UnknownArchitecture","error_code":"UNKNOWN_ARCH","msg":"Unknown architecture for fedora-30: s390x"}]}`)
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose",
+		`{"blueprint_name":"test","compose_type":"qcow2","branch":"master","arch":"aarch64"}`, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"UnknownArchitecture","error_code":"UNKNOWN_ARCH","msg":"No repositories are configured for architecture aarch64"}]}`)
+
+	api.SetArchRepositories(map[string][]rpmmd.RepoConfig{
+		"aarch64": {{Id: "test-id", BaseURL: "http://example.com/test/os/aarch64"}},
+	})
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose",
+		`{"blueprint_name":"test","compose_type":"qcow2","branch":"master","arch":"aarch64"}`, http.StatusServiceUnavailable,
+		`{"status":false,"errors":[{"id":"NoWorkers","error_code":"NO_WORKERS","msg":"No worker for architecture aarch64 is registered"}]}`)
+	require.Empty(t, s.GetAllComposes())
+
+	// workers register when they ask for jobs (testjobqueue fails instead
+	// of waiting for jobs)
+	server := httptest.NewServer(api.workers)
+	defer server.Close()
+	client := worker.NewClient(strings.TrimPrefix(server.URL, "http://"), nil)
+	_, err := client.AddJob([]string{"aarch64"})
+	require.Error(t, err)
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose",
+		`{"blueprint_name":"test","compose_type":"qcow2","branch":"master","arch":"aarch64"}`, http.StatusOK, `*`)
+	require.Len(t, s.GetAllComposes(), 1)
+
+	job, err := client.AddJob([]string{"aarch64"})
+	require.NoError(t, err)
+	require.Equal(t, "aarch64", job.Arch)
+}
+
+func TestWebhooks(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, s := createWeldrAPI(rpmmd_mock.BaseFixture)
+
+	test.TestRoute(t, api, false, "GET", "/api/v0/webhooks", ``, http.StatusNotFound,
+		`{"status":false,"errors":[{"code":404,"id":"HTTPError","error_code":"NOT_FOUND","msg":"Not Found"}]}`)
+	test.TestRoute(t, api, false, "GET", "/api/v1/webhooks", ``, http.StatusOK, `{"webhooks":[]}`)
+
+	var cases = []struct {
+		Body           string
+		ExpectedStatus int
+		ExpectedJSON   string
+	}{
+		{`{}`, http.StatusBadRequest, `{"status":false,"errors":[{"id":"BadWebhook","error_code":"INVALID_REQUEST","msg":"Problem parsing POST body: 'url' is required"}]}`},
+		{`{"url":"ftp://ci.example.com/"}`, http.StatusBadRequest, `{"status":false,"errors":[{"id":"BadWebhook","error_code":"INVALID_REQUEST","msg":"Problem parsing POST body: 'ftp://ci.example.com/' is not an http or https URL"}]}`},
+		{`{"url":"https://ci.example.com/","events":["compose.exploded"]}`, http.StatusBadRequest, `{"status":false,"errors":[{"id":"BadWebhook","error_code":"INVALID_REQUEST","msg":"Problem parsing POST body: unknown event 'compose.exploded'"}]}`},
+		{`{"url":"https://ci.example.com/","compose_id":"30000000-0000-0000-0000-000000000999"}`, http.StatusBadRequest, `{"status":false,"errors":[{"id":"UnknownUUID","error_code":"COMPOSE_NOT_FOUND","msg":"Compose 30000000-0000-0000-0000-000000000999 doesn't exist"}]}`},
+		{`{"url":"https://ci.example.com/","secret":"octopus","events":["compose.failed"],"compose_id":"30000000-0000-0000-0000-000000000002"}`, http.StatusOK, `{"status":true}`},
+	}
+
+	for _, c := range cases {
+		test.TestRoute(t, api, false, "POST", "/api/v1/webhooks/new", c.Body, c.ExpectedStatus, c.ExpectedJSON, "id")
+	}
+
+	hooks := s.GetWebhooks()
+	require.Len(t, hooks, 1)
+	require.Equal(t, "octopus", hooks[0].Secret)
+
+	// secrets are never listed
+	test.TestRoute(t, api, false, "GET", "/api/v1/webhooks", ``, http.StatusOK,
+		`{"webhooks":[{"id":"`+hooks[0].ID.String()+`","url":"https://ci.example.com/","signed":true,"events":["compose.failed"],"compose_id":"30000000-0000-0000-0000-000000000002"}]}`, "created")
+
+	test.TestRoute(t, api, false, "DELETE", "/api/v1/webhooks/delete/"+uuid.New().String(), ``, http.StatusNotFound, `{"status":false}`, "errors")
+	test.TestRoute(t, api, false, "DELETE", "/api/v1/webhooks/delete/"+hooks[0].ID.String(), ``, http.StatusOK, `{"status":true}`)
+	require.Empty(t, s.GetWebhooks())
+}
+
+func TestEvents(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, s := createWeldrAPI(rpmmd_mock.BaseFixture)
+	test.TestRoute(t, api, false, "GET", "/api/v1/events", ``, http.StatusNotFound,
+		`{"status":false,"errors":[{"id":"EventsUnavailable","error_code":"NOT_FOUND","msg":"Events cannot be streamed from this server"}]}`)
+
+	broadcaster := events.NewBroadcaster()
+	api.SetEventBroadcaster(broadcaster)
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/v1/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() []string {
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			require.NoError(t, err)
+			if line == "\n" {
+				return lines
+			}
+			lines = append(lines, strings.TrimSuffix(line, "\n"))
+		}
+	}
+	require.Equal(t, []string{": connected"}, readEvent())
+
+	// events not about composes or jobs, and about composes of other APIs,
+	// are not streamed
+	require.NoError(t, broadcaster.Emit(events.Event{Type: events.AdmissionAllowed, Fields: map[string]string{}}))
+	require.NoError(t, broadcaster.Emit(events.Event{Type: events.ComposeQueued, Fields: map[string]string{"COMPOSE_ID": uuid.New().String()}}))
+
+	require.NoError(t, broadcaster.Emit(events.Event{
+		Type:    events.ComposeQueued,
+		Message: "Compose queued",
+		Fields:  map[string]string{"COMPOSE_ID": "30000000-0000-0000-0000-000000000000"},
+	}))
+	lines := readEvent()
+	require.Len(t, lines, 3)
+	require.Equal(t, "id: 1", lines[0])
+	require.Equal(t, "event: compose-queued", lines[1])
+
+	var data struct {
+		Message string            `json:"message"`
+		Fields  map[string]string `json:"fields"`
+		Compose struct {
+			ID          uuid.UUID `json:"id"`
+			QueueStatus string    `json:"queue_status"`
+		} `json:"compose"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &data))
+	require.Equal(t, "Compose queued", data.Message)
+	require.Equal(t, uuid.MustParse("30000000-0000-0000-0000-000000000000"), data.Compose.ID)
+	require.Equal(t, "WAITING", data.Compose.QueueStatus)
+
+	require.NoError(t, broadcaster.Emit(events.Event{Type: events.ComposeDeleted, Fields: map[string]string{"COMPOSE_ID": uuid.New().String()}}))
+	lines = readEvent()
+	require.Equal(t, []string{"id: 2", "event: compose-deleted"}, lines[:2])
+
+	// events about jobs of unknown composes are not streamed, as they
+	// might belong to other tenants
+	require.NoError(t, broadcaster.Emit(events.Event{Type: events.JobFinished, Fields: map[string]string{"JOB_ID": uuid.New().String()}}))
+
+	arch, err := test_distro.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+	id, jobID := uuid.New(), uuid.New()
+	require.NoError(t, s.PushCompose(id, nil, imageType, &blueprint.Blueprint{Name: "test"}, 0, nil, nil, jobID))
+	require.NoError(t, broadcaster.Emit(events.Event{Type: events.JobAssigned, Fields: map[string]string{"JOB_ID": jobID.String()}}))
+	lines = readEvent()
+	require.Equal(t, []string{"id: 3", "event: job-assigned"}, lines[:2])
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &data))
+	require.Equal(t, id, data.Compose.ID)
+}
+
+func TestRebuild(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	dir, err := ioutil.TempDir("", "weldr-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "rebuild.toml")
+	writeConfig := func(config string) {
+		require.NoError(t, ioutil.WriteFile(configPath, []byte(config), 0600))
+	}
+
+	writeConfig(`debounce = "soon"
+[[blueprints]]
+name = "test"
+compose_types = ["qcow2"]
+on_commit = true`)
+	_, err = LoadRebuildConfig(configPath)
+	require.Error(t, err)
+
+	// blueprints must opt in to a kind of change
+	writeConfig(`[[blueprints]]
+name = "test"
+compose_types = ["qcow2"]`)
+	_, err = LoadRebuildConfig(configPath)
+	require.Error(t, err)
+
+	writeConfig(`[[blueprints]]
+name = "test"
+compose_types = ["qcow2"]
+on_commit = true
+on_repository_change = true`)
+	config, err := LoadRebuildConfig(configPath)
+	require.NoError(t, err)
+	require.Equal(t, 5*time.Minute, config.debounce)
+	require.Equal(t, time.Hour, config.repositoryInterval)
+
+	api, s := createWeldrAPI(rpmmd_mock.NoComposesFixture)
+	require.Error(t, api.SetRebuild(&RebuildConfig{Blueprints: []RebuildBlueprint{{Name: "test", ComposeTypes: []string{"foo"}, OnCommit: true}}}))
+	require.NoError(t, api.SetRebuild(config))
+
+	commit := func() {
+		bp, _ := s.GetBlueprint("test")
+		require.NoError(t, s.PushBlueprint(*bp, "change test"))
+	}
+	composes := func() int {
+		return len(s.GetAllComposes())
+	}
+
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	// the current state isn't rebuilt
+	require.NoError(t, api.RunRebuilds(start))
+	require.NoError(t, api.RunRebuilds(start.Add(10*time.Minute)))
+	require.Equal(t, 0, composes())
+
+	// commits are rebuilt once there were none for a while
+	commit()
+	require.NoError(t, api.RunRebuilds(start.Add(11*time.Minute)))
+	commit()
+	require.NoError(t, api.RunRebuilds(start.Add(14*time.Minute)))
+	require.NoError(t, api.RunRebuilds(start.Add(18*time.Minute)))
+	require.Equal(t, 0, composes())
+	require.NoError(t, api.RunRebuilds(start.Add(19*time.Minute)))
+	require.Equal(t, 1, composes())
+
+	rebuild := s.GetRebuilds()["test"]
+	require.Equal(t, "blueprint committed", rebuild.Reason)
+	require.Len(t, rebuild.Composes, 1)
+	require.Empty(t, rebuild.Error)
+
+	require.NoError(t, api.RunRebuilds(start.Add(30*time.Minute)))
+	require.Equal(t, 1, composes())
+
+	// pretend the last rebuild was against other repositories than the
+	// ones seen since
+	rebuild.BuiltSnapshot = map[string]string{"base": "sha256:old"}
+	require.NoError(t, s.SetRebuild("test", rebuild))
+	require.NoError(t, api.RunRebuilds(start.Add(40*time.Minute)))
+	require.Equal(t, 2, composes())
+	require.Equal(t, "repositories changed", s.GetRebuilds()["test"].Reason)
+
+	resp := test.SendHTTP(api, false, "GET", "/api/v1/rebuilds", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var reply struct {
+		Config   RebuildConfig `json:"config"`
+		Rebuilds map[string]struct {
+			Reason   string      `json:"reason"`
+			Composes []uuid.UUID `json:"composes"`
+			Pending  string      `json:"pending"`
+		} `json:"rebuilds"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&reply))
+	require.Len(t, reply.Config.Blueprints, 1)
+	require.Equal(t, "repositories changed", reply.Rebuilds["test"].Reason)
+	require.Empty(t, reply.Rebuilds["test"].Pending)
+
+	// rebuilds are forgotten along with their blueprint
+	require.NoError(t, s.DeleteBlueprint("test"))
+	require.Empty(t, s.GetRebuilds())
+}
+
+func TestAuthentication(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, _ := createWeldrAPI(rpmmd_mock.BaseFixture)
+
+	hash := func(token string) string {
+		sum := sha256.Sum256([]byte(token))
+		return hex.EncodeToString(sum[:])
+	}
+	config := auth.Config{
+		Tokens: []auth.TokenConfig{
+			{Name: "dashboard", TokenSHA256: hash("r"), Role: auth.RoleReader},
+			{Name: "ci", TokenSHA256: hash("b"), Role: auth.RoleBuilder},
+			{Name: "ops", TokenSHA256: hash("a"), Role: auth.RoleAdmin},
+		},
+	}
+	api.SetAuthenticator(config.Authenticator())
+
+	send := func(token, method, path, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		request.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		api.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	resp := send("", "GET", "/api/v1/blueprints/list", "")
+	require.Equal(t, http.StatusUnauthorized, resp.Code)
+	require.Equal(t, `Bearer realm="osbuild-composer"`, resp.Header().Get("WWW-Authenticate"))
+	require.JSONEq(t, `{"status":false,"errors":[{"id":"Unauthorized","error_code":"UNAUTHORIZED","msg":"Authentication failed: no bearer token in request"}]}`, resp.Body.String())
+
+	resp = send("guess", "GET", "/api/v1/blueprints/list", "")
+	require.Equal(t, http.StatusUnauthorized, resp.Code)
+	require.Contains(t, resp.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+
+	var cases = []struct {
+		Method, Path, Body string
+		Role               auth.Role
+	}{
+		{"GET", "/api/status", "", auth.RoleReader},
+		{"GET", "/api/v0/compose/queue", "", auth.RoleReader},
+		{"POST", "/api/v1/blueprints/new", `{"name":"octopus","version":"0.0.1"}`, auth.RoleBuilder},
+		{"POST", "/api/v1/compose", `{"blueprint_name":"octopus","compose_type":"qcow2","branch":"master"}`, auth.RoleBuilder},
+		{"POST", "/api/v0/projects/source/new", `{"name":"fish","url":"https://example.com/fish","type":"yum-baseurl"}`, auth.RoleAdmin},
+		{"DELETE", "/api/v0/projects/source/delete/fish", "", auth.RoleAdmin},
+		{"DELETE", "/api/v1/blueprints/delete/octopus", "", auth.RoleAdmin},
+		{"GET", "/api/v1/export", "", auth.RoleAdmin},
+	}
+
+	tokens := map[auth.Role]string{auth.RoleReader: "r", auth.RoleBuilder: "b", auth.RoleAdmin: "a"}
+	for _, c := range cases {
+		for role, token := range tokens {
+			if role.Includes(c.Role) {
+				continue
+			}
+			resp := send(token, c.Method, c.Path, c.Body)
+			require.Equalf(t, http.StatusForbidden, resp.Code, "%s %s as %s", c.Method, c.Path, role)
+		}
+		resp := send(tokens[c.Role], c.Method, c.Path, c.Body)
+		require.Equalf(t, http.StatusOK, resp.Code, "%s %s as %s: %s", c.Method, c.Path, c.Role, resp.Body.String())
+	}
+}
+
+func TestTenants(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, s := createWeldrAPI(rpmmd_mock.BaseFixture)
+
+	hash := func(token string) string {
+		sum := sha256.Sum256([]byte(token))
+		return hex.EncodeToString(sum[:])
+	}
+	config := auth.Config{
+		Tokens: []auth.TokenConfig{
+			{Name: "ops", TokenSHA256: hash("default"), Role: auth.RoleAdmin},
+			{Name: "web", TokenSHA256: hash("web"), Role: auth.RoleBuilder, Tenant: "web-team"},
+			{Name: "db", TokenSHA256: hash("db"), Role: auth.RoleBuilder, Tenant: "db-team"},
+			{Name: "bad", TokenSHA256: hash("bad"), Role: auth.RoleBuilder, Tenant: "Bad Team"},
+		},
+	}
+	api.SetAuthenticator(config.Authenticator())
+
+	send := func(token, method, path, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		api.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	// without tenants, clients of a tenant are turned away
+	resp := send("web", "GET", "/api/v1/blueprints/list", "")
+	require.Equal(t, http.StatusForbidden, resp.Code)
+
+	tenants, err := store.NewTenants(s, nil)
+	require.NoError(t, err)
+	api.SetTenants(tenants)
+
+	resp = send("web", "POST", "/api/v1/blueprints/new", `{"name":"octopus","version":"0.0.1"}`)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+	resp = send("web", "GET", "/api/v1/blueprints/list", "")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.JSONEq(t, `{"total":1,"offset":0,"limit":1,"blueprints":["octopus"]}`, resp.Body.String())
+
+	resp = send("db", "GET", "/api/v1/blueprints/list", "")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.JSONEq(t, `{"total":0,"offset":0,"limit":0,"blueprints":[]}`, resp.Body.String())
+
+	resp = send("default", "GET", "/api/v1/blueprints/list", "")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.NotContains(t, resp.Body.String(), "octopus")
+
+	resp = send("bad", "GET", "/api/v1/blueprints/list", "")
+	require.Equal(t, http.StatusForbidden, resp.Code)
+
+	webStore, err := tenants.Get("web-team")
+	require.NoError(t, err)
+	require.NotNil(t, webStore.GetBlueprintCommitted("octopus"))
+	require.Nil(t, s.GetBlueprintCommitted("octopus"))
+
+	// tenants only see the queued jobs of their own composes
+	webJob, err := api.workers.Enqueue("fedora-30", "x86_64", &osbuild.Manifest{}, nil, nil, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+	webStore.Composes[uuid.New()] = compose.Compose{ImageBuilds: []compose.ImageBuild{{JobId: webJob}}}
+	_, err = api.workers.Enqueue("fedora-30", "x86_64", &osbuild.Manifest{}, nil, nil, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+
+	resp = send("web", "GET", "/api/v1/compose/queue/jobs", "")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Contains(t, resp.Body.String(), webJob.String())
+	require.Contains(t, resp.Body.String(), `"depth":1`)
+
+	resp = send("db", "GET", "/api/v1/compose/queue/jobs", "")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.JSONEq(t, `{"depth":0,"jobs":[]}`, resp.Body.String())
+
+	resp = send("default", "GET", "/api/v1/compose/queue/jobs", "")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Contains(t, resp.Body.String(), `"depth":2`)
+}
+
+func TestQuotas(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, s := createWeldrAPI(rpmmd_mock.NoComposesFixture)
+
+	hash := func(token string) string {
+		sum := sha256.Sum256([]byte(token))
+		return hex.EncodeToString(sum[:])
+	}
+	config := auth.Config{
+		Tokens: []auth.TokenConfig{
+			{Name: "ci", TokenSHA256: hash("b"), Role: auth.RoleBuilder},
+			{Name: "ops", TokenSHA256: hash("a"), Role: auth.RoleAdmin},
+		},
+	}
+	api.SetAuthenticator(config.Authenticator())
+	api.SetQuotas(&quota.Config{
+		Tenant: quota.Limits{MaxConcurrentComposes: 2},
+		User:   quota.Limits{MaxConcurrentComposes: 1},
+	})
+
+	send := func(token, method, path, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		api.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	compose := `{"blueprint_name":"test","compose_type":"qcow2","branch":"master"}`
+
+	resp := send("b", "POST", "/api/v1/compose", compose)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+	resp = send("b", "POST", "/api/v1/compose", compose)
+	require.Equal(t, http.StatusTooManyRequests, resp.Code)
+	require.JSONEq(t, `{"status":false,"errors":[{"id":"QuotaExceeded","error_code":"QUOTA_EXCEEDED","msg":"Quota exceeded: user ci uses 1 of 1 concurrent_composes","quota":{"scope":"user","name":"ci","limit":"concurrent_composes","used":1,"max":1}}]}`, resp.Body.String())
+
+	resp = send("a", "POST", "/api/v1/compose", compose)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+	resp = send("a", "POST", "/api/v1/compose", compose)
+	require.Equal(t, http.StatusTooManyRequests, resp.Code)
+	require.Contains(t, resp.Body.String(), "the default tenant uses 2 of 2 concurrent_composes")
+
+	// composes that are queued without a request count against the quotas
+	bp, _ := s.GetBlueprint("test")
+	_, err := api.queueLocalCompose(bp, "qcow2")
+	require.IsType(t, &quota.ExceededError{}, err)
+	require.Len(t, s.GetAllComposes(), 2)
+
+	owners := []string{}
+	for _, c := range s.GetAllComposes() {
+		if c.Owner != "" {
+			owners = append(owners, c.Owner)
+		}
+	}
+	require.ElementsMatch(t, []string{"ci", "ops"}, owners)
+
+	resp = send("b", "GET", "/api/v1/quota", "")
+	require.Equal(t, http.StatusForbidden, resp.Code)
+
+	resp = send("a", "GET", "/api/v1/quota", "")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.JSONEq(t, `{
+		"tenants": [{
+			"name": "",
+			"usage": {"concurrent_composes": 2, "composes_per_day": 2, "artifact_bytes": 0},
+			"limits": {"max_concurrent_composes": 2},
+			"users": [
+				{"name": "ci", "usage": {"concurrent_composes": 1, "composes_per_day": 1, "artifact_bytes": 0}, "limits": {"max_concurrent_composes": 1}},
+				{"name": "ops", "usage": {"concurrent_composes": 1, "composes_per_day": 1, "artifact_bytes": 0}, "limits": {"max_concurrent_composes": 1}}
+			]
+		}]
+	}`, resp.Body.String())
+}
+
+func TestComposeTiming(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, _ := createWeldrAPI(rpmmd_mock.BaseFixture)
+
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/timing/30000000-0000-0000-0000-000000000001", ``, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"BuildInWrongState","error_code":"COMPOSE_WRONG_STATE","msg":"Build 30000000-0000-0000-0000-000000000001 not in FINISHED or FAILED state."}]}`)
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/timing/30000000-0000-0000-0000-000000000005", ``, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"UnknownUUID","error_code":"COMPOSE_NOT_FOUND","msg":"Compose 30000000-0000-0000-0000-000000000005 doesn't exist"}]}`)
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/timing/30000000-0000-0000-0000-000000000002?sort=name", ``, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"InvalidChars","error_code":"INVALID_REQUEST","msg":"Cannot sort by 'name', only by 'duration'"}]}`)
+
+	// composes without a result have no stages
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/timing/30000000-0000-0000-0000-000000000002", ``, http.StatusOK,
+		`{"uuid":"30000000-0000-0000-0000-000000000002","image_builds":[{"id":0,"duration":0,"stages":[]}]}`)
+
+	// composes with jobs have the result that the worker reported
+	api, s := createWeldrAPI(rpmmd_mock.NoComposesFixture)
+	server := httptest.NewServer(api.workers)
+	defer server.Close()
+	client := worker.NewClient(strings.TrimPrefix(server.URL, "http://"), nil)
+	_, err := client.AddJob([]string{common.CurrentArch()})
+	require.Error(t, err)
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose",
+		`{"blueprint_name":"test","compose_type":"qcow2","branch":"master"}`, http.StatusOK, `*`)
+	var id uuid.UUID
+	for composeID := range s.GetAllComposes() {
+		id = composeID
+	}
+
+	job, err := client.AddJob([]string{common.CurrentArch()})
+	require.NoError(t, err)
+
+	var result common.ComposeResult
+	err = json.Unmarshal([]byte(`{
+		"build": {"stages": [{"name": "org.osbuild.rpm", "success": true, "duration": 30000000000}], "success": true},
+		"stages": [
+			{"name": "org.osbuild.rpm", "success": true, "duration": 60000000000},
+			{"name": "org.osbuild.selinux", "success": false, "output": "selinux failed\n", "duration": 10000000000}
+		],
+		"success": false
+	}`), &result)
+	require.NoError(t, err)
+	err = client.UpdateJob(job, common.IBFailed, &result, nil)
+	require.NoError(t, err)
+
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/timing/"+id.String(), ``, http.StatusOK,
+		`{"uuid":"`+id.String()+`","image_builds":[{"id":0,"duration":100,"stages":[`+
+			`{"pipeline":"build","name":"org.osbuild.rpm","success":true,"duration":30,"percent":30},`+
+			`{"pipeline":"tree","name":"org.osbuild.rpm","success":true,"duration":60,"percent":60},`+
+			`{"pipeline":"tree","name":"org.osbuild.selinux","success":false,"duration":10,"percent":10,"output":"selinux failed\n"}]}]}`)
+	test.TestRoute(t, api, false, "GET", "/api/v1/compose/timing/"+id.String()+"?sort=duration", ``, http.StatusOK,
+		`{"uuid":"`+id.String()+`","image_builds":[{"id":0,"duration":100,"stages":[`+
+			`{"pipeline":"tree","name":"org.osbuild.rpm","success":true,"duration":60,"percent":60},`+
+			`{"pipeline":"build","name":"org.osbuild.rpm","success":true,"duration":30,"percent":30},`+
+			`{"pipeline":"tree","name":"org.osbuild.selinux","success":false,"duration":10,"percent":10,"output":"selinux failed\n"}]}]}`)
+}
+
+func TestComposeManifest(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, s := createWeldrAPI(rpmmd_mock.NoComposesFixture)
+
+	test.TestRoute(t, api, false, "POST", "/api/v0/compose/manifest",
+		`{"blueprint_name":"test","compose_type":"qcow2"}`, http.StatusNotFound, `*`)
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose/manifest",
+		`{"blueprint_name":"test","blueprint":"name = \"test\"","compose_type":"qcow2"}`, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"BadCompose","error_code":"INVALID_REQUEST","msg":"blueprint_name and blueprint cannot be used together"}]}`)
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose/manifest",
+		`{"blueprint_name":"missing","compose_type":"qcow2"}`, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"UnknownBlueprint","error_code":"BLUEPRINT_NOT_FOUND","msg":"Unknown blueprint name: missing"}]}`)
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose/manifest",
+		`{"blueprint_name":"test","compose_type":"floppy"}`, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"UnknownComposeType","error_code":"UNKNOWN_IMAGE_TYPE","msg":"Unknown compose type for architecture: floppy"}]}`)
+
+	type manifestReply struct {
+		Blueprint   string `json:"blueprint"`
+		ImageBuilds []struct {
+			ComposeType string              `json:"compose_type"`
+			Arch        string              `json:"arch"`
+			Manifest    *osbuild.Manifest   `json:"manifest"`
+			Packages    []rpmmd.PackageSpec `json:"packages"`
+		} `json:"image_builds"`
+		Repositories []struct {
+			ID string `json:"id"`
+		} `json:"repositories"`
+	}
+
+	for _, body := range []string{
+		`{"blueprint_name":"test","compose_type":"qcow2"}`,
+		`{"blueprint":"name = \"test\"\n[[packages]]\nname = \"dep-package1\"\n","compose_type":"qcow2"}`,
+	} {
+		resp := test.SendHTTP(api, false, "POST", "/api/v1/compose/manifest", body)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var reply manifestReply
+		err := json.NewDecoder(resp.Body).Decode(&reply)
+		require.NoError(t, err)
+
+		require.Equal(t, "test", reply.Blueprint)
+		require.Len(t, reply.ImageBuilds, 1)
+		require.Equal(t, "qcow2", reply.ImageBuilds[0].ComposeType)
+		require.Equal(t, "x86_64", reply.ImageBuilds[0].Arch)
+		require.NotNil(t, reply.ImageBuilds[0].Manifest)
+		require.NotEmpty(t, reply.ImageBuilds[0].Packages)
+		require.NotEmpty(t, reply.Repositories)
+	}
+
+	// nothing is queued
+	require.Empty(t, s.GetAllComposes())
+}
+
+func TestComposePackagesFrom(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, s := createWeldrAPI(rpmmd_mock.NoComposesFixture)
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose?test=2",
+		`{"blueprint_name":"test","compose_type":"qcow2","branch":"master"}`, http.StatusOK, `*`)
+	var id uuid.UUID
+	var pinned compose.Compose
+	for composeID, c := range s.GetAllComposes() {
+		id, pinned = composeID, c
+	}
+	require.NotEmpty(t, pinned.ImageBuilds[0].Packages)
+	require.NotEmpty(t, pinned.ImageBuilds[0].BuildPackages)
+
+	test.TestRoute(t, api, false, "POST", "/api/v0/compose",
+		`{"blueprint_name":"test","compose_type":"qcow2","branch":"master","packages_from":"`+id.String()+`"}`, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"BadCompose","error_code":"INVALID_REQUEST","msg":"packages_from requires API version 1"}]}`)
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose",
+		`{"blueprint_name":"test","compose_type":"qcow2","branch":"master","packages_from":"30000000-0000-0000-0000-000000000005"}`, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"UnknownUUID","error_code":"COMPOSE_NOT_FOUND","msg":"Compose 30000000-0000-0000-0000-000000000005 doesn't exist"}]}`)
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose",
+		`{"blueprint_name":"test","compose_type":"openstack","branch":"master","packages_from":"`+id.String()+`"}`, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"BadCompose","error_code":"INVALID_REQUEST","msg":"Cannot reuse the packages of compose `+id.String()+`: no packages of a openstack image were recorded"}]}`)
+
+	// the manifest pins the same packages, and the new compose records them
+	resp := test.SendHTTP(api, false, "POST", "/api/v1/compose/manifest",
+		`{"blueprint_name":"test","compose_type":"qcow2","packages_from":"`+id.String()+`"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var reply struct {
+		ImageBuilds []struct {
+			Manifest *osbuild.Manifest `json:"manifest"`
+		} `json:"image_builds"`
+		Warnings []compose.Warning `json:"warnings"`
+	}
+	err := json.NewDecoder(resp.Body).Decode(&reply)
+	require.NoError(t, err)
+	require.Equal(t, pinned.ImageBuilds[0].Manifest, reply.ImageBuilds[0].Manifest)
+	require.Empty(t, reply.Warnings)
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose?test=2",
+		`{"blueprint_name":"test","compose_type":"qcow2","branch":"master","packages_from":"`+id.String()+`"}`, http.StatusOK, `*`)
+	require.Len(t, s.GetAllComposes(), 2)
+	for composeID, c := range s.GetAllComposes() {
+		if composeID != id {
+			require.Equal(t, pinned.ImageBuilds[0].Packages, c.ImageBuilds[0].Packages)
+			require.Equal(t, pinned.ImageBuilds[0].BuildPackages, c.ImageBuilds[0].BuildPackages)
+			require.Equal(t, pinned.Repositories, c.Repositories)
+		}
+	}
+
+	// changes to the blueprint's packages are not applied
+	resp = test.SendHTTP(api, false, "POST", "/api/v1/compose/manifest",
+		`{"blueprint":"name = \"other\"","compose_type":"qcow2","packages_from":"`+id.String()+`"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	err = json.NewDecoder(resp.Body).Decode(&reply)
+	require.NoError(t, err)
+	require.Len(t, reply.Warnings, 1)
+	require.Equal(t, "PinnedPackages", reply.Warnings[0].ID)
+}
+
+func TestComposeRebuild(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, s := createWeldrAPI(rpmmd_mock.BaseFixture)
+
+	test.TestRoute(t, api, false, "POST", "/api/v0/compose/rebuild/30000000-0000-0000-0000-000000000002", ``, http.StatusNotFound, "*")
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose/rebuild/30000000-0000-0000-0000-000000000005", ``, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"UnknownUUID","error_code":"COMPOSE_NOT_FOUND","msg":"Compose 30000000-0000-0000-0000-000000000005 doesn't exist"}]}`)
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose/rebuild/30000000-0000-0000-0000-000000000000", ``, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"BuildInWrongState","error_code":"COMPOSE_WRONG_STATE","msg":"Build 30000000-0000-0000-0000-000000000000 not in FINISHED or FAILED state."}]}`)
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose/rebuild/30000000-0000-0000-0000-000000000002", ``, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"BadCompose","error_code":"INVALID_REQUEST","msg":"Compose 30000000-0000-0000-0000-000000000002 wasn't built by composer and cannot be rebuilt"}]}`)
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose/rebuild/30000000-0000-0000-0000-000000000002?depsolve=maybe", ``, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"InvalidChars","error_code":"INVALID_REQUEST","msg":"Invalid value for depsolve: maybe"}]}`)
+
+	test.TestRoute(t, api, false, "POST", "/api/v1/compose?test=1",
+		`{"blueprint_name":"test","compose_type":"qcow2","branch":"master"}`, http.StatusOK, `*`)
+	var id uuid.UUID
+	var failed compose.Compose
+	for composeID, c := range s.GetAllComposes() {
+		if len(c.ImageBuilds[0].Packages) > 0 {
+			id, failed = composeID, c
+		}
+	}
+
+	rebuild := func(query string) compose.Compose {
+		resp := test.SendHTTP(api, false, "POST", "/api/v1/compose/rebuild/"+id.String()+query, ``)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var reply struct {
+			BuildID   uuid.UUID `json:"build_id"`
+			RebuildOf uuid.UUID `json:"rebuild_of"`
+		}
+		err := json.NewDecoder(resp.Body).Decode(&reply)
+		require.NoError(t, err)
+		require.Equal(t, id, reply.RebuildOf)
+
+		c, exists := s.GetCompose(reply.BuildID)
+		require.True(t, exists)
+		require.Equal(t, id, c.RebuildOf)
+		require.Equal(t, failed.Blueprint, c.Blueprint)
+		require.Equal(t, reply.BuildID, c.ImageBuilds[0].GetLocalTargetOptions().ComposeId)
+		return c
+	}
+
+	// the same manifest is queued again
+	c := rebuild("")
+	require.Equal(t, failed.ImageBuilds[0].Manifest, c.ImageBuilds[0].Manifest)
+	require.Equal(t, failed.ImageBuilds[0].Packages, c.ImageBuilds[0].Packages)
+	require.Equal(t, failed.Repositories, c.Repositories)
+	require.NotEqual(t, uuid.Nil, c.ImageBuilds[0].JobId)
+
+	// the blueprint is resolved again
+	c = rebuild("?depsolve=true&test=2")
+	require.Equal(t, failed.ImageBuilds[0].ImageType, c.ImageBuilds[0].ImageType)
+	require.NotEmpty(t, c.ImageBuilds[0].Packages)
+	require.Equal(t, common.IBFinished, c.ImageBuilds[0].QueueStatus)
+}
+
+func TestExportImport(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	src, srcStore := createWeldrAPI(rpmmd_mock.BaseFixture)
+	dst, dstStore := createWeldrAPI(rpmmd_mock.NoComposesFixture)
+
+	test.TestRoute(t, src, false, "POST", "/api/v0/blueprints/new",
+		`{"name":"exported","description":"","version":"0.0.1","packages":[{"name":"tmux","version":"*"}]}`, http.StatusOK, `{"status":true}`)
+	test.TestRoute(t, src, false, "POST", "/api/v0/projects/source/new",
+		`{"name":"extra","url":"https://example.com/extra/","type":"yum-baseurl","check_ssl":true,"check_gpg":false}`, http.StatusOK, `{"status":true}`)
+
+	test.TestRoute(t, src, false, "GET", "/api/v0/export", ``, http.StatusNotFound, `*`)
+	test.TestRoute(t, src, false, "GET", "/api/v1/export?format=yaml", ``, http.StatusBadRequest,
+		`{"status":false,"errors":[{"id":"InvalidChars","error_code":"INVALID_REQUEST","msg":"invalid format parameter: yaml"}]}`)
+
+	export := func(format string) []byte {
+		resp := test.SendHTTP(src, false, "GET", "/api/v1/export?format="+format, ``)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "application/x-tar", resp.Header.Get("Content-Type"))
+		archive, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		var names []string
+		tr := tar.NewReader(bytes.NewReader(archive))
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			names = append(names, header.Name)
+		}
+		require.Contains(t, names, "blueprints/exported."+format)
+		require.Contains(t, names, "sources/extra."+format)
+		return archive
+	}
+
+	importArchive := func(archive []byte, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/import"+query, bytes.NewReader(archive))
+		req.Header.Set("Content-Type", "application/x-tar")
+		resp := httptest.NewRecorder()
+		dst.ServeHTTP(resp, req)
+		return resp
+	}
+
+	for _, format := range []string{"toml", "json"} {
+		archive := export(format)
+		resp := importArchive(archive, "")
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+		bp := dstStore.GetBlueprintCommitted("exported")
+		require.NotNil(t, bp)
+		require.Equal(t, srcStore.GetBlueprintCommitted("exported"), bp)
+		require.Equal(t, srcStore.GetSource("extra"), dstStore.GetSource("extra"))
+
+		// importing it again changes nothing
+		resp = importArchive(archive, "")
+		require.Equal(t, http.StatusOK, resp.Code)
+		var reply struct {
+			Blueprints struct {
+				Imported  []string `json:"imported"`
+				Unchanged []string `json:"unchanged"`
+			} `json:"blueprints"`
+			Sources struct {
+				Unchanged []string `json:"unchanged"`
+			} `json:"sources"`
+		}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &reply))
+		require.Empty(t, reply.Blueprints.Imported)
+		require.Contains(t, reply.Blueprints.Unchanged, "exported")
+		require.Equal(t, []string{"extra"}, reply.Sources.Unchanged)
+	}
+
+	// conflicts fail the import, unless they are skipped or replaced
+	test.TestRoute(t, dst, false, "POST", "/api/v0/blueprints/new",
+		`{"name":"exported","description":"changed","version":"0.0.2"}`, http.StatusOK, `{"status":true}`)
+	archive := export("toml")
+
+	resp := importArchive(archive, "?conflict=merge")
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = importArchive(archive, "")
+	require.Equal(t, http.StatusConflict, resp.Code)
+	require.JSONEq(t, `{"status":false,"errors":[{"id":"ImportConflict","error_code":"IMPORT_CONFLICT","msg":"blueprint exported already exists"}]}`, resp.Body.String())
+
+	resp = importArchive(archive, "?conflict=skip")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "changed", dstStore.GetBlueprintCommitted("exported").Description)
+
+	resp = importArchive(archive, "?conflict=replace")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "", dstStore.GetBlueprintCommitted("exported").Description)
+	require.Equal(t, []string{"tmux"}, dstStore.GetBlueprintCommitted("exported").GetPackages())
+
+	resp = importArchive([]byte("not a tar archive"), "")
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// nothing is imported when a part of the archive cannot be
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"blueprints/partial.toml": `name = "partial"`,
+		"sources/good.toml":       `name = "good"` + "\n" + `type = "yum-baseurl"` + "\n" + `url = "https://example.com/good/"`,
+		"sources/bad.toml":        `name = "bad"` + "\n" + `type = "yum-baseurl"` + "\n" + `url = "https://example.com/bad/"` + "\n" + `gpgkeys = ["unknown"]`,
+	} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	resp = importArchive(buf.Bytes(), "")
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	require.Contains(t, resp.Body.String(), "unknown gpg key")
+	require.Nil(t, dstStore.GetBlueprintCommitted("partial"))
+	require.Nil(t, dstStore.GetSource("good"))
+
+	// only admins may import and export, because sources can contain
+	// paths of client certificates and replace other sources
+	require.Equal(t, auth.RoleAdmin, requiredRole(httptest.NewRequest("POST", "/api/v1/import", nil)))
+	require.Equal(t, auth.RoleAdmin, requiredRole(httptest.NewRequest("GET", "/api/v1/export", nil)))
+	require.Equal(t, SurfaceAdmin, requestSurface(httptest.NewRequest("POST", "/api/v1/import", nil)))
+}
+
+func TestGitSync(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "weldr-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "gitsync.toml")
+	writeConfig := func(config string) {
+		require.NoError(t, ioutil.WriteFile(configPath, []byte(config), 0600))
+	}
+
+	writeConfig(`branch = "master"`)
+	_, err = LoadGitSyncConfig(configPath)
+	require.Error(t, err)
+
+	writeConfig(`url = "` + dir + `/repo"
+directory = "../etc"`)
+	_, err = LoadGitSyncConfig(configPath)
+	require.Error(t, err)
+
+	writeConfig(`url = "` + dir + `/repo"
+directory = "blueprints"
+prune = true`)
+	config, err := LoadGitSyncConfig(configPath)
+	require.NoError(t, err)
+	require.Equal(t, "master", config.Branch)
+	require.Equal(t, 5*time.Minute, config.interval)
+
+	repo := filepath.Join(dir, "repo")
+	git := func(args ...string) string {
+		out, err := runGit(repo, append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		require.NoError(t, err)
+		return out
+	}
+	commit := func(files map[string]string) string {
+		for name, content := range files {
+			p := filepath.Join(repo, "blueprints", name)
+			if content == "" {
+				require.NoError(t, os.Remove(p))
+			} else {
+				require.NoError(t, ioutil.WriteFile(p, []byte(content), 0600))
+			}
+		}
+		git("add", "-A")
+		git("commit", "-q", "-m", "change")
+		return git("rev-parse", "HEAD")
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(repo, "blueprints"), 0700))
+	git("init", "-q")
+	git("symbolic-ref", "HEAD", "refs/heads/master")
+
+	head := commit(map[string]string{
+		"web.toml": "name = \"web\"\nversion = \"1.0.0\"\n\n[[packages]]\nname = \"httpd\"\nversion = \"*\"\n",
+		"db.toml":  "name = \"db\"\nversion = \"0.1.0\"\n",
+	})
+
+	api, s := createWeldrAPI(rpmmd_mock.NoComposesFixture)
+	api.SetGitSync(config)
+
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, api.RunGitSync(start))
+
+	bp := s.GetBlueprintCommitted("web")
+	require.NotNil(t, bp)
+	require.Equal(t, "1.0.0", bp.Version)
+	require.Equal(t, []string{"httpd"}, bp.GetPackages())
+	changes := s.GetBlueprintChanges("web")
+	require.Equal(t, "Synced from git commit "+head, changes[len(changes)-1].Message)
+	require.NotNil(t, s.GetBlueprintCommitted("db"))
+
+	state := s.GetGitSync()
+	require.Equal(t, head, state.Commit)
+	require.Equal(t, []string{"db", "web"}, state.Blueprints)
+	require.Empty(t, state.Error)
+
+	// nothing is checked before the interval passed, and unchanged
+	// blueprints are not committed again
+	require.NoError(t, api.RunGitSync(start.Add(time.Minute)))
+	require.NoError(t, api.RunGitSync(start.Add(5*time.Minute)))
+	require.Len(t, s.GetBlueprintChanges("web"), len(changes))
+
+	// changes through the API are reverted
+	bp.Description = "changed"
+	require.NoError(t, s.PushBlueprint(*bp, "change web"))
+	require.NoError(t, api.RunGitSync(start.Add(10*time.Minute)))
+	require.Equal(t, "", s.GetBlueprintCommitted("web").Description)
+	require.NoError(t, api.RunGitSync(start.Add(15*time.Minute)))
+	require.Len(t, s.GetBlueprintChanges("web"), len(changes)+2)
+
+	// invalid blueprints keep everything as it was
+	commit(map[string]string{"broken.toml": "name = "})
+	require.Error(t, api.RunGitSync(start.Add(20*time.Minute)))
+	require.NotEmpty(t, s.GetGitSync().Error)
+	require.Equal(t, head, s.GetGitSync().Commit)
+
+	// removed blueprints are pruned
+	head = commit(map[string]string{"broken.toml": "", "db.toml": ""})
+	require.NoError(t, api.RunGitSync(start.Add(25*time.Minute)))
+	require.Nil(t, s.GetBlueprintCommitted("db"))
+	require.NotNil(t, s.GetBlueprintCommitted("web"))
+	require.Equal(t, head, s.GetGitSync().Commit)
+	require.Empty(t, s.GetGitSync().Error)
+
+	resp := test.SendHTTP(api, false, "GET", "/api/v1/gitsync", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var reply struct {
+		Config GitSyncConfig `json:"config"`
+		State  struct {
+			Commit     string   `json:"commit"`
+			Blueprints []string `json:"blueprints"`
+		} `json:"state"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&reply))
+	require.Equal(t, "blueprints", reply.Config.Directory)
+	require.Equal(t, head, reply.State.Commit)
+	require.Equal(t, []string{"web"}, reply.State.Blueprints)
+}
+
+func TestAuditLog(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	dir, err := ioutil.TempDir("", "weldr-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	api, _ := createWeldrAPI(rpmmd_mock.BaseFixture)
+
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, httptest.NewRequest("GET", "/api/v1/audit", nil))
+	require.Equal(t, http.StatusNotFound, resp.Code)
+
+	log, err := audit.Open(filepath.Join(dir, "audit.log"))
+	require.NoError(t, err)
+	defer log.Close()
+	api.SetAuditLog(log)
+
+	body := `{"name":"test","description":"Test","packages":[],"modules":[],"groups":[]}`
+	req := httptest.NewRequest("POST", "/api/v1/blueprints/new", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	api.ServeHTTP(httptest.NewRecorder(), req)
+	api.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/blueprints/list", nil))
+	api.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/v1/blueprints/delete/test", nil))
+	api.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/v1/blueprints/delete/missing", nil))
+
+	var reply struct {
+		Entries []audit.Entry `json:"entries"`
+		Total   int           `json:"total"`
+	}
+	resp = httptest.NewRecorder()
+	api.ServeHTTP(resp, httptest.NewRequest("GET", "/api/v1/audit", nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&reply))
+	require.Equal(t, 3, reply.Total)
+	require.Equal(t, "DELETE /api/v1/blueprints/delete/missing", reply.Entries[0].Action)
+	require.Equal(t, http.StatusBadRequest, reply.Entries[0].Status)
+	require.Equal(t, "POST /api/v1/blueprints/new", reply.Entries[2].Action)
+	require.Equal(t, http.StatusOK, reply.Entries[2].Status)
+	require.Equal(t, "192.0.2.1:1234", reply.Entries[2].Actor)
+	require.NotEmpty(t, reply.Entries[2].PayloadHash)
+
+	resp = httptest.NewRecorder()
+	api.ServeHTTP(resp, httptest.NewRequest("GET", "/api/v1/audit?action=POST&limit=1", nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&reply))
+	require.Equal(t, 1, reply.Total)
+	require.Len(t, reply.Entries, 1)
+
+	test.TestRoute(t, api, true, "GET", "/api/v1/audit?since=yesterday", ``, http.StatusBadRequest, `{"status":false,"errors":[{"error_code":"INVALID_REQUEST","id":"InvalidChars","msg":"Invalid value for since, must be RFC 3339: yesterday"}]}`)
+}
+
+func TestDrain(t *testing.T) {
+	if len(os.Getenv("OSBUILD_COMPOSER_TEST_EXTERNAL")) > 0 {
+		t.Skip("This test is for internal testing only")
+	}
+
+	api, _ := createWeldrAPI(rpmmd_mock.BaseFixture)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = api.workers.Drain(ctx)
+
+	resp := test.SendHTTP(api, true, "POST", "/api/v1/compose", `{"blueprint_name": "test","compose_type": "qcow2","branch": "master"}`)
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.Equal(t, "60", resp.Header.Get("Retry-After"))
+	test.TestRoute(t, api, true, "POST", "/api/v1/compose/rebuild/30000000-0000-0000-0000-000000000002", ``, http.StatusServiceUnavailable, `{"status":false,"errors":[{"id":"ShuttingDown","error_code":"SHUTTING_DOWN","msg":"Composer is shutting down and doesn't accept new composes, try again later"}]}`)
+
+	// everything else is still served
+	test.TestRoute(t, api, true, "GET", "/api/v1/compose/queue", ``, http.StatusOK, `*`)
+	test.TestRoute(t, api, true, "POST", "/api/v1/blueprints/new", `{"name":"drain","description":"","packages":[],"modules":[],"groups":[]}`, http.StatusOK, `{"status":true}`)
+
+	require.NoError(t, api.Shutdown(context.Background()))
+}
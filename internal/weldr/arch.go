@@ -0,0 +1,86 @@
+package weldr
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/distro"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+)
+
+// SetArchRepositories sets the repositories of each architecture of the
+// distro. Composes for other architectures than composer's own can only be
+// requested for architectures which have repositories.
+func (api *API) SetArchRepositories(repos map[string][]rpmmd.RepoConfig) {
+	api.archRepos = repos
+}
+
+// systemRepositories returns the repositories of the distro for `arch`.
+func (api *API) systemRepositories(arch distro.Arch) []rpmmd.RepoConfig {
+	if arch.Name() == api.arch.Name() {
+		return api.repos
+	}
+	return api.archRepos[arch.Name()]
+}
+
+// archRepositories is like allRepositories, but returns the repositories
+// for `arch`. Sources are used for all architectures.
+func (api *API) archRepositories(arch distro.Arch) []rpmmd.RepoConfig {
+	repos := append([]rpmmd.RepoConfig{}, api.systemRepositories(arch)...)
+	for _, source := range api.store.GetAllSources() {
+		repos = append(repos, api.store.SourceRepoConfig(source))
+	}
+	return repos
+}
+
+// composeArch returns the architecture that a compose was requested for,
+// which is composer's own if `name` is empty. It writes an error response and
+// returns false if composes cannot be built for that architecture, because
+// the distro doesn't support it, there are no repositories for it, or no
+// worker is registered for it.
+func (api *API) composeArch(writer http.ResponseWriter, params httprouter.Params, name string) (distro.Arch, bool) {
+	if name == "" || name == api.arch.Name() {
+		return api.arch, true
+	}
+
+	if !isRequestVersionAtLeast(params, 1) {
+		errors := responseError{
+			ID:  "BadCompose",
+			Msg: "composes for other architectures require API version 1",
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return nil, false
+	}
+
+	arch, err := api.distro.GetArch(name)
+	if err != nil {
+		errors := responseError{
+			ID:  "UnknownArchitecture",
+			Msg: fmt.Sprintf("Unknown architecture for %s: %s", api.distro.Name(), name),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return nil, false
+	}
+
+	if len(api.archRepos[name]) == 0 {
+		errors := responseError{
+			ID:  "UnknownArchitecture",
+			Msg: fmt.Sprintf("No repositories are configured for architecture %s", name),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return nil, false
+	}
+
+	if !api.workers.HasWorkers(name) {
+		errors := responseError{
+			ID:  "NoWorkers",
+			Msg: fmt.Sprintf("No worker for architecture %s is registered", name),
+		}
+		statusResponseError(writer, http.StatusServiceUnavailable, errors)
+		return nil, false
+	}
+
+	return arch, true
+}
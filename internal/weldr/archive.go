@@ -0,0 +1,128 @@
+package weldr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/store"
+)
+
+// composeExportHandler sends a tar archive of a finished or failed compose,
+// which contains everything needed to import it on another host. Only
+// privileged clients may export composes, because archives include the
+// credentials of upload targets.
+func (api *API) composeExportHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	if !isPrivileged(request) {
+		errors := responseError{
+			ID:  "PermissionDenied",
+			Msg: "only privileged clients may export composes",
+		}
+		statusResponseError(writer, http.StatusForbidden, errors)
+		return
+	}
+
+	uuidString := params.ByName("uuid")
+	id, err := uuid.Parse(uuidString)
+	if err != nil {
+		errors := responseError{
+			ID:  "UnknownUUID",
+			Msg: fmt.Sprintf("%s is not a valid build uuid", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	compose, exists := api.store.GetCompose(id)
+	if !exists {
+		errors := responseError{
+			ID:  "UnknownUUID",
+			Msg: fmt.Sprintf("Compose %s doesn't exist", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	state, _, _, _ := api.getComposeState(compose)
+	if state != common.CFinished && state != common.CFailed {
+		errors := responseError{
+			ID:  "BuildInWrongState",
+			Msg: fmt.Sprintf("Build %s is in wrong state: %s", uuidString, state.ToString()),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	writer.Header().Set("Content-Disposition", "attachment; filename="+id.String()+".tar")
+	writer.Header().Set("Content-Type", "application/x-tar")
+
+	// The response has already started, so errors can only be logged. The
+	// client notices them, because the archive is truncated.
+	err = api.store.ExportCompose(id, writer, api.workers.ComposeState)
+	if err != nil && api.logger != nil {
+		api.logger.Printf("cannot export compose %s: %v", id, err)
+	}
+}
+
+// composeImportHandler adds a compose from an archive sent by
+// composeExportHandler of another host. Only privileged clients may import
+// composes, because archives are written to disk as they are.
+func (api *API) composeImportHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	if !isPrivileged(request) {
+		errors := responseError{
+			ID:  "PermissionDenied",
+			Msg: "only privileged clients may import composes",
+		}
+		statusResponseError(writer, http.StatusForbidden, errors)
+		return
+	}
+
+	contentType := request.Header["Content-Type"]
+	if len(contentType) != 1 || contentType[0] != "application/x-tar" {
+		errors := responseError{
+			ID:  "MissingPost",
+			Msg: "compose archive must be application/x-tar",
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	id, err := api.store.ImportCompose(request.Body)
+	if err != nil {
+		if _, ok := err.(*store.InvalidRequestError); ok {
+			errors := responseError{
+				ID:  "BadCompose",
+				Msg: fmt.Sprintf("cannot import compose: %v", err),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+
+		errors := responseError{
+			ID:  "ComposeError",
+			Msg: fmt.Sprintf("cannot import compose: %v", err),
+		}
+		statusResponseError(writer, http.StatusInternalServerError, errors)
+		return
+	}
+
+	type reply struct {
+		Status bool      `json:"status"`
+		ID     uuid.UUID `json:"build_id"`
+	}
+
+	err = json.NewEncoder(writer).Encode(reply{true, id})
+	common.PanicOnError(err)
+}
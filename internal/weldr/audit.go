@@ -0,0 +1,106 @@
+package weldr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/audit"
+	"github.com/osbuild/osbuild-composer/internal/auth"
+	"github.com/osbuild/osbuild-composer/internal/common"
+)
+
+// SetAuditLog records all requests that can change state in `log`, and
+// serves it on /audit. Tenants cannot query it, because it contains the
+// actions of all tenants.
+func (api *API) SetAuditLog(log *audit.Log) {
+	api.auditLog = log
+}
+
+// auditActor returns who sent `request`: the name of the authenticated
+// client, or else its address, which contains the uid of clients on unix
+// sockets.
+func auditActor(request *http.Request) string {
+	if identity := auth.IdentityFromContext(request.Context()); identity != nil {
+		return identity.Name
+	}
+	return request.RemoteAddr
+}
+
+// auditHandler returns the entries of the audit log, newest first. They can
+// be filtered by actor, by a prefix of the action (e.g., "DELETE" or
+// "POST /api/v1/blueprints"), and by time with since and until in RFC 3339.
+func (api *API) auditHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	if api.auditLog == nil {
+		errors := responseError{
+			ID:  "AuditUnavailable",
+			Msg: "No audit log is kept by this server",
+		}
+		statusResponseError(writer, http.StatusNotFound, errors)
+		return
+	}
+
+	query := request.URL.Query()
+	filter := audit.Filter{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+	}
+	for _, t := range []struct {
+		name  string
+		value *time.Time
+	}{
+		{"since", &filter.Since},
+		{"until", &filter.Until},
+	} {
+		if query.Get(t.name) == "" {
+			continue
+		}
+		var err error
+		*t.value, err = time.Parse(time.RFC3339, query.Get(t.name))
+		if err != nil {
+			errors := responseError{
+				ID:  "InvalidChars",
+				Msg: fmt.Sprintf("Invalid value for %s, must be RFC 3339: %s", t.name, query.Get(t.name)),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+	}
+
+	offset, limit, err := parseOffsetAndLimit(query)
+	if err != nil {
+		errors := responseError{
+			ID:  "BadLimitOrOffset",
+			Msg: fmt.Sprintf("BadRequest: %s", err.Error()),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	entries, total, err := api.auditLog.Query(filter, int(offset), int(limit))
+	if err != nil {
+		errors := responseError{
+			ID:  "AuditError",
+			Msg: err.Error(),
+		}
+		statusResponseError(writer, http.StatusInternalServerError, errors)
+		return
+	}
+
+	reply := struct {
+		Entries []audit.Entry `json:"entries"`
+		Offset  uint          `json:"offset"`
+		Limit   uint          `json:"limit"`
+		Total   int           `json:"total"`
+	}{entries, offset, limit, total}
+
+	err = json.NewEncoder(writer).Encode(reply)
+	common.PanicOnError(err)
+}
@@ -0,0 +1,105 @@
+package weldr
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/osbuild/osbuild-composer/internal/auth"
+)
+
+// Routes below /api/v:version which only admins may use, in addition to the
+// routes of the admin surface. Paths ending in a slash match all paths they
+// are a prefix of.
+var adminOnlyRoutes = []struct {
+	method, path string
+}{
+	{"POST", "/projects/source/"},
+	{"DELETE", "/projects/source/"},
+	{"POST", "/projects/cache/invalidate"},
+	{"POST", "/upload/providers/save"},
+	{"DELETE", "/upload/providers/delete/"},
+	{"DELETE", "/blueprints/delete/"},
+	{"DELETE", "/compose/delete/"},
+	{"DELETE", "/upload/delete/"},
+	{"GET", "/audit"},
+	{"GET", "/export"},
+	{"POST", "/import"},
+}
+
+// SetAuthenticator requires clients which don't connect over a unix socket
+// to authenticate. Clients on unix sockets are restricted by the socket's
+// permissions instead.
+func (api *API) SetAuthenticator(authenticator *auth.Authenticator) {
+	api.authenticator = authenticator
+}
+
+// requiredRole returns the role a client needs for `request`: readers may
+// look at everything but the admin surface, builders may also change
+// blueprints and start composes, and only admins may manage sources and
+// delete blueprints, composes, and uploads.
+func requiredRole(request *http.Request) auth.Role {
+	if requestSurface(request) == SurfaceAdmin {
+		return auth.RoleAdmin
+	}
+
+	path := request.URL.Path
+	for _, prefix := range []string{"/api/v0", "/api/v1"} {
+		path = strings.TrimPrefix(path, prefix)
+	}
+	for _, route := range adminOnlyRoutes {
+		if request.Method != route.method {
+			continue
+		}
+		if path == route.path || (strings.HasSuffix(route.path, "/") && strings.HasPrefix(path, route.path)) {
+			return auth.RoleAdmin
+		}
+	}
+
+	if request.Method == "GET" || request.Method == "HEAD" {
+		return auth.RoleReader
+	}
+	return auth.RoleBuilder
+}
+
+// isUnixSocket returns true if `request` was received on a unix socket.
+func isUnixSocket(request *http.Request) bool {
+	addr, ok := request.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
+
+// authorize authenticates the client sending `request` and checks that it
+// may make it. It writes an error response if it may not. Otherwise, it
+// returns the request carrying the client's identity, if there is one.
+func (api *API) authorize(writer http.ResponseWriter, request *http.Request) (*http.Request, bool) {
+	if api.authenticator == nil || isUnixSocket(request) {
+		return request, true
+	}
+
+	identity, err := api.authenticator.Authenticate(request)
+	if err != nil {
+		challenge := `Bearer realm="osbuild-composer"`
+		if err != auth.ErrNoCredentials {
+			challenge += `, error="invalid_token"`
+		}
+		writer.Header().Set("WWW-Authenticate", challenge)
+		errors := responseError{
+			ID:  "Unauthorized",
+			Msg: "Authentication failed: " + err.Error(),
+		}
+		statusResponseError(writer, http.StatusUnauthorized, errors)
+		return nil, false
+	}
+
+	role := requiredRole(request)
+	if !identity.Role.Includes(role) {
+		errors := responseError{
+			ID:  "PermissionDenied",
+			Msg: "This request requires the " + string(role) + " role, but " + identity.Name + " is a " + string(identity.Role),
+		}
+		statusResponseError(writer, http.StatusForbidden, errors)
+		return nil, false
+	}
+
+	return request.WithContext(auth.WithIdentity(request.Context(), identity)), true
+}
@@ -0,0 +1,50 @@
+package weldr
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+)
+
+// SetMetadataCache sets the cache of repository metadata in front of the
+// API's RPMMD, so that it can be invalidated through the API.
+func (api *API) SetMetadataCache(cache *rpmmd.Cache) {
+	api.metadataCache = cache
+}
+
+// projectsCacheInvalidateHandler forgets the cached metadata of the
+// repository given by the "repo" query parameter, or of all repositories.
+// Use it when a repository changed and composes must not wait until the
+// cache notices:
+//
+//	POST /api/v1/projects/cache/invalidate?repo=updates
+func (api *API) projectsCacheInvalidateHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	if api.metadataCache == nil {
+		errors := responseError{
+			ID:  "ProjectsError",
+			Msg: "repository metadata is not cached",
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	q, err := url.ParseQuery(request.URL.RawQuery)
+	if err != nil {
+		errors := responseError{
+			ID:  "InvalidChars",
+			Msg: "invalid query string",
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	api.metadataCache.Invalidate(q.Get("repo"))
+	statusResponseOK(writer)
+}
@@ -0,0 +1,103 @@
+package weldr
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/osbuild"
+)
+
+// checkCheckpoints returns true if all requested checkpoints exist. Otherwise,
+// it writes an error response and returns false. Checkpoints can only be
+// requested with API version 1.
+func checkCheckpoints(writer http.ResponseWriter, params httprouter.Params, checkpoints []string) bool {
+	if len(checkpoints) == 0 {
+		return true
+	}
+
+	if !isRequestVersionAtLeast(params, 1) {
+		errors := responseError{
+			ID:  "BadCompose",
+			Msg: "checkpoints require API version 1",
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return false
+	}
+
+	for _, name := range checkpoints {
+		if !osbuild.IsCheckpoint(name) {
+			errors := responseError{
+				ID:  "BadCompose",
+				Msg: fmt.Sprintf("Unknown checkpoint: %s", name),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return false
+		}
+	}
+
+	return true
+}
+
+// composeCheckpointHandler downloads a checkpoint that was requested when
+// starting a compose. Checkpoints are exported for failed composes, too,
+// because they are meant for finding out why a compose failed.
+func (api *API) composeCheckpointHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	uuidString := params.ByName("uuid")
+	id, err := uuid.Parse(uuidString)
+	if err != nil {
+		errors := responseError{
+			ID:  "UnknownUUID",
+			Msg: fmt.Sprintf("%s is not a valid build uuid", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	compose, exists := api.store.GetCompose(id)
+	if !exists {
+		errors := responseError{
+			ID:  "UnknownUUID",
+			Msg: fmt.Sprintf("Compose %s doesn't exist", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	state, _, _, _ := api.getComposeState(compose)
+	if state != common.CFinished && state != common.CFailed {
+		errors := responseError{
+			ID:  "BuildInWrongState",
+			Msg: fmt.Sprintf("Build %s is in wrong state: %s", uuidString, state.ToString()),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	name := params.ByName("name")
+	reader, size, err := api.store.GetImageBuildCheckpoint(id, 0, name)
+	if err != nil {
+		errors := responseError{
+			ID:  "BuildMissingFile",
+			Msg: fmt.Sprintf("Build %s has no checkpoint %s: %v", uuidString, name, err),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+	defer reader.Close()
+
+	writer.Header().Set("Content-Disposition", "attachment; filename="+id.String()+"-"+osbuild.CheckpointFilename(name))
+	writer.Header().Set("Content-Type", "application/x-tar")
+	writer.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+
+	_, err = io.Copy(writer, reader)
+	common.PanicOnError(err)
+}
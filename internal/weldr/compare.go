@@ -0,0 +1,219 @@
+package weldr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+)
+
+type packageChange struct {
+	Old rpmmd.PackageSpec `json:"old"`
+	New rpmmd.PackageSpec `json:"new"`
+}
+
+type packageDiff struct {
+	Added      []rpmmd.PackageSpec `json:"added"`
+	Removed    []rpmmd.PackageSpec `json:"removed"`
+	Upgraded   []packageChange     `json:"upgraded"`
+	Downgraded []packageChange     `json:"downgraded"`
+}
+
+type customizationChange struct {
+	Name string          `json:"name"`
+	Old  json.RawMessage `json:"old"`
+	New  json.RawMessage `json:"new"`
+}
+
+// composeDiffHandler reports what changed between the images of two
+// composes: which packages were added, removed, upgraded, or downgraded, and
+// which customizations of their blueprints differ.
+//
+//	GET /api/v1/compose/diff/<from-uuid>/<to-uuid>
+func (api *API) composeDiffHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	type reply struct {
+		From           uuid.UUID             `json:"from"`
+		To             uuid.UUID             `json:"to"`
+		Packages       packageDiff           `json:"packages"`
+		Customizations []customizationChange `json:"customizations"`
+	}
+
+	var ids [2]uuid.UUID
+	var packages [2][]rpmmd.PackageSpec
+	var customizations [2]*blueprint.Customizations
+	for i, uuidString := range []string{params.ByName("from"), params.ByName("to")} {
+		id, err := uuid.Parse(uuidString)
+		if err != nil {
+			errors := responseError{
+				ID:  "UnknownUUID",
+				Msg: fmt.Sprintf("%s is not a valid build uuid", uuidString),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+
+		compose, exists := api.store.GetCompose(id)
+		if !exists {
+			errors := responseError{
+				ID:  "UnknownUUID",
+				Msg: fmt.Sprintf("Compose %s doesn't exist", uuidString),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+
+		packages[i], err = api.store.GetImageBuildPackages(id, 0)
+		if err != nil {
+			errors := responseError{
+				ID:  "UnknownUUID",
+				Msg: fmt.Sprintf("Compose %s has no image build", uuidString),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+
+		ids[i] = id
+		if compose.Blueprint != nil {
+			customizations[i] = compose.Blueprint.Customizations
+		}
+	}
+
+	err := json.NewEncoder(writer).Encode(reply{
+		From:           ids[0],
+		To:             ids[1],
+		Packages:       diffPackages(packages[0], packages[1]),
+		Customizations: diffCustomizations(customizations[0], customizations[1]),
+	})
+	common.PanicOnError(err)
+}
+
+// diffPackages compares two package sets. Packages are matched by name and
+// architecture, so that multilib packages are treated separately. All lists
+// are sorted by package name.
+func diffPackages(from, to []rpmmd.PackageSpec) packageDiff {
+	key := func(p rpmmd.PackageSpec) string {
+		return p.Name + "." + p.Arch
+	}
+
+	old := make(map[string]rpmmd.PackageSpec)
+	for _, p := range from {
+		old[key(p)] = p
+	}
+
+	diff := packageDiff{
+		Added:      []rpmmd.PackageSpec{},
+		Removed:    []rpmmd.PackageSpec{},
+		Upgraded:   []packageChange{},
+		Downgraded: []packageChange{},
+	}
+
+	for _, p := range to {
+		o, found := old[key(p)]
+		if !found {
+			diff.Added = append(diff.Added, evra(p))
+			continue
+		}
+		delete(old, key(p))
+
+		c := rpmmd.CompareEVR(o, p)
+		if c < 0 {
+			diff.Upgraded = append(diff.Upgraded, packageChange{evra(o), evra(p)})
+		} else if c > 0 {
+			diff.Downgraded = append(diff.Downgraded, packageChange{evra(o), evra(p)})
+		}
+	}
+
+	for _, p := range old {
+		diff.Removed = append(diff.Removed, evra(p))
+	}
+
+	sortPackages := func(packages []rpmmd.PackageSpec) {
+		sort.Slice(packages, func(i, j int) bool {
+			return key(packages[i]) < key(packages[j])
+		})
+	}
+	sortChanges := func(changes []packageChange) {
+		sort.Slice(changes, func(i, j int) bool {
+			return key(changes[i].New) < key(changes[j].New)
+		})
+	}
+	sortPackages(diff.Added)
+	sortPackages(diff.Removed)
+	sortChanges(diff.Upgraded)
+	sortChanges(diff.Downgraded)
+
+	return diff
+}
+
+// evra strips everything but the name, epoch, version, release, and
+// architecture from a package, because where it was downloaded from is
+// irrelevant for comparing images.
+func evra(p rpmmd.PackageSpec) rpmmd.PackageSpec {
+	return rpmmd.PackageSpec{
+		Name:    p.Name,
+		Epoch:   p.Epoch,
+		Version: p.Version,
+		Release: p.Release,
+		Arch:    p.Arch,
+	}
+}
+
+// diffCustomizations compares the top-level sections of two sets of
+// customizations, such as "hostname" or "user", in their JSON
+// representation. Sections which are missing on one side are null.
+func diffCustomizations(from, to *blueprint.Customizations) []customizationChange {
+	sections := func(c *blueprint.Customizations) map[string]json.RawMessage {
+		m := make(map[string]json.RawMessage)
+		if c == nil {
+			return m
+		}
+		data, err := json.Marshal(c)
+		common.PanicOnError(err)
+		err = json.Unmarshal(data, &m)
+		common.PanicOnError(err)
+		return m
+	}
+
+	before := sections(from)
+	after := sections(to)
+
+	var names []string
+	for name := range before {
+		names = append(names, name)
+	}
+	for name := range after {
+		if _, exists := before[name]; !exists {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changes := []customizationChange{}
+	for _, name := range names {
+		o, n := before[name], after[name]
+		if bytes.Equal(o, n) {
+			continue
+		}
+		if o == nil {
+			o = json.RawMessage("null")
+		}
+		if n == nil {
+			n = json.RawMessage("null")
+		}
+		changes = append(changes, customizationChange{name, o, n})
+	}
+
+	return changes
+}
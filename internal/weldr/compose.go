@@ -7,6 +7,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/osbuild/osbuild-composer/internal/common"
 	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/worker"
 )
 
 type ComposeEntry struct {
@@ -20,6 +21,18 @@ type ComposeEntry struct {
 	JobStarted  float64                `json:"job_started,omitempty"`
 	JobFinished float64                `json:"job_finished,omitempty"`
 	Uploads     []uploadResponse       `json:"uploads,omitempty"`
+
+	// The checksum of the image, as "sha256:<hex>", for clients to verify
+	// downloads. Only set for finished composes whose image is stored by
+	// composer.
+	Checksum string `json:"checksum,omitempty"`
+
+	// Only set in API v1
+	Progress *worker.ComposeProgress `json:"progress,omitempty"`
+
+	// The image types of all image builds, only set in API v1 for
+	// composes of several image types
+	ComposeTypes []common.ImageType `json:"compose_types,omitempty"`
 }
 
 func composeToComposeEntry(id uuid.UUID, compose compose.Compose, state common.ComposeState, queued, started, finished time.Time, includeUploads bool) *ComposeEntry {
@@ -32,6 +45,12 @@ func composeToComposeEntry(id uuid.UUID, compose compose.Compose, state common.C
 
 	if includeUploads {
 		composeEntry.Uploads = targetsToUploadResponses(compose.ImageBuilds[0].Targets)
+
+		if len(compose.ImageBuilds) > 1 {
+			for _, ib := range compose.ImageBuilds {
+				composeEntry.ComposeTypes = append(composeEntry.ComposeTypes, ib.ImageType)
+			}
+		}
 	}
 
 	switch state {
@@ -47,6 +66,7 @@ func composeToComposeEntry(id uuid.UUID, compose compose.Compose, state common.C
 	case common.CFinished:
 		composeEntry.QueueStatus = common.IBFinished
 		composeEntry.ImageSize = compose.ImageBuilds[0].Size
+		composeEntry.Checksum = compose.ImageBuilds[0].Checksum
 		composeEntry.JobCreated = float64(queued.UnixNano()) / 1000000000
 		composeEntry.JobStarted = float64(started.UnixNano()) / 1000000000
 		composeEntry.JobFinished = float64(finished.UnixNano()) / 1000000000
@@ -63,6 +83,25 @@ func composeToComposeEntry(id uuid.UUID, compose compose.Compose, state common.C
 	return &composeEntry
 }
 
+// imageBuildInfo is an image build in the reply of compose/info.
+type imageBuildInfo struct {
+	ID          int    `json:"id"`
+	ComposeType string `json:"compose_type"`
+	QueueStatus string `json:"queue_status"`
+	ImageSize   uint64 `json:"image_size"`
+	Checksum    string `json:"checksum,omitempty"`
+}
+
+// hasImageType returns true if any image build of `c` builds `imageType`.
+func hasImageType(c compose.Compose, imageType common.ImageType) bool {
+	for _, ib := range c.ImageBuilds {
+		if ib.ImageType == imageType {
+			return true
+		}
+	}
+	return false
+}
+
 func sortComposeEntries(entries []*ComposeEntry) {
 	sort.Slice(entries, func(i, j int) bool {
 		return entries[i].ID.String() < entries[j].ID.String()
@@ -0,0 +1,45 @@
+package weldr
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/config"
+)
+
+// SetEffectiveConfig sets the configuration reported by /api/v1/config.
+func (api *API) SetEffectiveConfig(effective *config.Effective) {
+	api.effectiveConfig = effective
+}
+
+// configHandler returns the effective configuration of osbuild-composer,
+// with secrets redacted. Only privileged clients may read it.
+func (api *API) configHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	if !isPrivileged(request) {
+		errors := responseError{
+			ID:  "PermissionDenied",
+			Msg: "only privileged clients may read the configuration",
+		}
+		statusResponseError(writer, http.StatusForbidden, errors)
+		return
+	}
+
+	type reply struct {
+		Settings []config.Setting `json:"settings"`
+	}
+
+	settings := []config.Setting{}
+	if api.effectiveConfig != nil {
+		settings = api.effectiveConfig.Settings()
+	}
+
+	err := json.NewEncoder(writer).Encode(reply{settings})
+	common.PanicOnError(err)
+}
@@ -0,0 +1,165 @@
+package weldr
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/target"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+// convertRequest describes how an uploaded image is converted. `Name` takes
+// the place of the blueprint name in listings of composes.
+type convertRequest struct {
+	Name        string         `json:"name"`
+	ComposeType string         `json:"compose_type"`
+	Upload      *uploadRequest `json:"upload"`
+}
+
+// composeConvertHandler starts a compose which converts an uploaded disk
+// image (in any format qemu-img reads) to the format of a compose type and
+// optionally uploads the result, without building anything from a blueprint.
+// The request is multipart/form-data, with a "metadata" part followed by the
+// "image" part:
+//
+//	{"name": "legacy-app", "compose_type": "vhd",
+//	 "upload": {"provider": "azure", "image_name": "legacy-app", "settings": {...}}}
+//
+// Workers convert the image and upload it (see worker/convert.go). The
+// converted image, the compose's status and the status of the upload are
+// available like those of other composes. Like imports, conversions are
+// restricted to privileged clients.
+func (api *API) composeConvertHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	if !isPrivileged(request) {
+		errors := responseError{
+			ID:  "PermissionDenied",
+			Msg: "only privileged clients may convert images",
+		}
+		statusResponseError(writer, http.StatusForbidden, errors)
+		return
+	}
+
+	var cr convertRequest
+	input, err := readImageParts(request, &cr)
+	if err != nil {
+		errors := responseError{
+			ID:  "BadConversion",
+			Msg: fmt.Sprintf("invalid conversion: %v", err),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	imageType, exists := common.ImageTypeFromCompatString(cr.ComposeType)
+	it, err := api.arch.GetImageType(cr.ComposeType)
+	if !exists || err != nil {
+		errors := responseError{
+			ID:  "UnknownComposeType",
+			Msg: fmt.Sprintf("Unknown compose type for architecture: %s", cr.ComposeType),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	format, ok := worker.ConversionFormat(it.Filename())
+	if !ok {
+		errors := responseError{
+			ID:  "BadConversion",
+			Msg: fmt.Sprintf("images cannot be converted to compose type %s", cr.ComposeType),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	if cr.Name == "" {
+		cr.Name = "conversion"
+	}
+
+	if request.ContentLength > 0 && !api.checkDiskQuota(writer, uint64(request.ContentLength)) {
+		return
+	}
+
+	id := uuid.New()
+	now := time.Now()
+
+	targets := []*target.Target{
+		target.NewLocalTarget(&target.LocalTargetOptions{
+			ComposeId: id,
+			Filename:  it.Filename(),
+		}),
+	}
+	if cr.Upload != nil {
+		targets = append(targets, uploadRequestToTarget(*cr.Upload, it.Filename()))
+	}
+
+	c := compose.Compose{
+		Blueprint: &blueprint.Blueprint{Name: cr.Name},
+		ImageBuilds: []compose.ImageBuild{{
+			ImageType:   imageType,
+			Targets:     targets,
+			JobCreated:  now,
+			QueueStatus: common.IBWaiting,
+		}},
+		Conversion: &compose.Conversion{
+			Format:      format,
+			RequestedAt: now,
+		},
+	}
+
+	err = api.store.PushConversion(id, c, input)
+	if err != nil {
+		switch err.(type) {
+		case *store.InvalidRequestError:
+			errors := responseError{
+				ID:  "BadConversion",
+				Msg: fmt.Sprintf("cannot convert image: %v", err),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+		default:
+			errors := responseError{
+				ID:  "ComposeError",
+				Msg: fmt.Sprintf("cannot convert image: %v", err),
+			}
+			statusResponseError(writer, http.StatusInternalServerError, errors)
+		}
+		return
+	}
+
+	convertJobId, uploadJobId, err := api.workers.EnqueueConversion(id, format, it.Filename(), targets)
+	if err == nil {
+		err = api.store.SetConversionJobs(id, convertJobId, uploadJobId)
+	}
+	if err != nil {
+		if derr := api.store.DeleteCompose(id); derr != nil {
+			log.Printf("cannot delete conversion %s: %v", id, derr)
+		}
+		errors := responseError{
+			ID:  "ComposeError",
+			Msg: fmt.Sprintf("cannot convert image: %v", err),
+		}
+		statusResponseError(writer, http.StatusInternalServerError, errors)
+		return
+	}
+
+	type reply struct {
+		Status bool      `json:"status"`
+		ID     uuid.UUID `json:"build_id"`
+	}
+
+	err = json.NewEncoder(writer).Encode(reply{true, id})
+	common.PanicOnError(err)
+}
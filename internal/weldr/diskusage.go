@@ -0,0 +1,88 @@
+package weldr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+)
+
+// SetDiskQuota sets how many bytes the outputs of all composes, including
+// images that are still being uploaded, may take up. New composes are refused
+// when they could exceed it. 0 means no quota.
+func (api *API) SetDiskQuota(quota int64) {
+	api.diskQuota = quota
+}
+
+// checkDiskQuota returns true if a compose of an image of `size` bytes fits
+// into the disk quota. Otherwise, it writes an error response and returns
+// false. The image size is an upper bound of what the compose's outputs take
+// up, because images might be compressed or sparse.
+func (api *API) checkDiskQuota(writer http.ResponseWriter, size uint64) bool {
+	if api.diskQuota <= 0 {
+		return true
+	}
+
+	_, total := api.store.DiskUsage()
+	total += api.workers.PartialUploadsSize()
+	if total+int64(size) <= api.diskQuota {
+		return true
+	}
+
+	errors := responseError{
+		ID:  "DiskQuotaExceeded",
+		Msg: fmt.Sprintf("compose outputs use %d of %d bytes, which leaves no room for an image of %d bytes; delete old composes first", total, api.diskQuota, size),
+	}
+	statusResponseError(writer, http.StatusInsufficientStorage, errors)
+	return false
+}
+
+// composeDiskUsageHandler reports how much disk space the outputs of each
+// compose take up, largest first.
+func (api *API) composeDiskUsageHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	type composeUsage struct {
+		ID   uuid.UUID `json:"id"`
+		Size int64     `json:"size"`
+	}
+
+	type reply struct {
+		Total    int64          `json:"total"`
+		Quota    int64          `json:"quota,omitempty"`
+		Uploads  int64          `json:"uploads,omitempty"`
+		Composes []composeUsage `json:"composes"`
+	}
+
+	usage, total := api.store.DiskUsage()
+
+	// Images which are still being uploaded don't belong to a compose yet
+	uploads := api.workers.PartialUploadsSize()
+	total += uploads
+
+	composes := []composeUsage{}
+	for id, size := range usage {
+		composes = append(composes, composeUsage{id, size})
+	}
+	sort.Slice(composes, func(i, j int) bool {
+		if composes[i].Size != composes[j].Size {
+			return composes[i].Size > composes[j].Size
+		}
+		return composes[i].ID.String() < composes[j].ID.String()
+	})
+
+	err := json.NewEncoder(writer).Encode(reply{
+		Total:    total,
+		Quota:    api.diskQuota,
+		Uploads:  uploads,
+		Composes: composes,
+	})
+	common.PanicOnError(err)
+}
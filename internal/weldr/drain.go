@@ -0,0 +1,94 @@
+package weldr
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Routes which queue jobs, and are rejected while composer drains before
+// shutting down (see worker.Server.Drain). Paths are relative to
+// /api/v{0,1}, and those ending in a slash include all paths below.
+var queueingRoutes = []struct {
+	method, path string
+}{
+	{"POST", "/compose"},
+	{"POST", "/compose/blueprint"},
+	{"POST", "/compose/rebuild/"},
+	{"POST", "/compose/convert"},
+	{"POST", "/compose/promote/"},
+}
+
+// isQueueing returns true if `request` queues jobs.
+func isQueueing(request *http.Request) bool {
+	path := request.URL.Path
+	if !strings.HasPrefix(path, "/api/v0/") && !strings.HasPrefix(path, "/api/v1/") {
+		return false
+	}
+	path = path[len("/api/v0"):]
+
+	for _, route := range queueingRoutes {
+		if request.Method != route.method {
+			continue
+		}
+		if path == route.path || (strings.HasSuffix(route.path, "/") && strings.HasPrefix(path, route.path)) {
+			return true
+		}
+	}
+	return false
+}
+
+// rejectWhileDraining answers requests that queue jobs with 503 while
+// composer drains, and returns false for them.
+func (api *API) rejectWhileDraining(writer http.ResponseWriter, request *http.Request) bool {
+	if !api.workers.Draining() || !isQueueing(request) {
+		return true
+	}
+
+	writer.Header().Set("Retry-After", "60")
+	errors := responseError{
+		ID:  "ShuttingDown",
+		Msg: "Composer is shutting down and doesn't accept new composes, try again later",
+	}
+	statusResponseError(writer, http.StatusServiceUnavailable, errors)
+	return false
+}
+
+// httpServers are the servers that Serve() and ServeSurfaces() started, so
+// that they can be shut down. `closing` is closed when they are, to end
+// requests that never end by themselves, like event streams.
+type httpServers struct {
+	mu      sync.Mutex
+	servers []*http.Server
+	closing chan struct{}
+}
+
+func (s *httpServers) add(server *http.Server) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.servers = append(s.servers, server)
+}
+
+// Shutdown stops serving on the listeners passed to Serve() and
+// ServeSurfaces(). It waits until the requests being served are done, or
+// until `ctx` is done. Event streams are ended.
+func (api *API) Shutdown(ctx context.Context) error {
+	api.servers.mu.Lock()
+	defer api.servers.mu.Unlock()
+
+	select {
+	case <-api.servers.closing:
+	default:
+		close(api.servers.closing)
+	}
+
+	var err error
+	for _, server := range api.servers.servers {
+		if serr := server.Shutdown(ctx); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	return err
+}
@@ -0,0 +1,178 @@
+package weldr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/events"
+)
+
+// How often a comment is sent on idle event streams, so that proxies don't
+// close them.
+const eventStreamKeepalive = 30 * time.Second
+
+// SetEventBroadcaster sets where the events streamed by /events come from.
+// Without it, the route is not available.
+func (api *API) SetEventBroadcaster(broadcaster *events.Broadcaster) {
+	api.eventBroadcaster = broadcaster
+}
+
+// streamedEvent is the data of an event in the stream. It contains the
+// compose the event is about in its current state, if there is one.
+type streamedEvent struct {
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields"`
+	Compose *ComposeEntry     `json:"compose,omitempty"`
+}
+
+// eventsHandler streams state changes of composes and jobs as server-sent
+// events, so that clients don't need to poll /compose/queue:
+//
+//	id: 1
+//	event: job-finished
+//	data: {"message":"Job ... finished","fields":{"JOB_ID":"..."},"compose":{...}}
+//
+// Events are named like composer's events in the journal. Only events that
+// happen after connecting are sent; clients should fetch the current state
+// after the stream is established, which is signaled by a comment.
+func (api *API) eventsHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	flusher, ok := writer.(http.Flusher)
+	if api.eventBroadcaster == nil || !ok {
+		errors := responseError{
+			ID:  "EventsUnavailable",
+			Msg: "Events cannot be streamed from this server",
+		}
+		statusResponseError(writer, http.StatusNotFound, errors)
+		return
+	}
+
+	stream, unsubscribe := api.eventBroadcaster.Subscribe()
+	defer unsubscribe()
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.WriteHeader(http.StatusOK)
+	_, err := fmt.Fprint(writer, ": connected\n\n")
+	if err != nil {
+		return
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventStreamKeepalive)
+	defer keepalive.Stop()
+
+	seq := 0
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+
+		case <-api.servers.closing:
+			// composer is shutting down, clients reconnect
+			return
+
+		case <-keepalive.C:
+			_, err := fmt.Fprint(writer, ": keepalive\n\n")
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case event, ok := <-stream:
+			if !ok {
+				// fell behind, clients reconnect
+				return
+			}
+
+			data, relevant := api.streamedEvent(event)
+			if !relevant {
+				continue
+			}
+			body, err := json.Marshal(data)
+			common.PanicOnError(err)
+
+			seq++
+			_, err = fmt.Fprintf(writer, "id: %d\nevent: %s\ndata: %s\n\n", seq, event.Type, body)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// streamedEvent returns what is streamed about `event`, and false if the
+// event is not about a state change of a compose or job.
+func (api *API) streamedEvent(event events.Event) (streamedEvent, bool) {
+	data := streamedEvent{
+		Message: event.Message,
+		Fields:  event.Fields,
+	}
+
+	switch event.Type {
+	case events.ComposeQueued, events.ImageUploaded, events.ImageUploadFailed:
+		id, err := uuid.Parse(event.Fields["COMPOSE_ID"])
+		if err != nil {
+			return data, false
+		}
+		data.Compose = api.streamedCompose(id)
+		if data.Compose == nil {
+			// composes of other APIs
+			return data, false
+		}
+
+	case events.ComposeDeleted:
+
+	case events.JobAssigned, events.JobFinished, events.JobFailed:
+		jobID, err := uuid.Parse(event.Fields["JOB_ID"])
+		if err != nil {
+			return data, false
+		}
+		id, compose, exists := api.store.GetComposeOfJob(jobID)
+		if !exists {
+			// jobs of other APIs and tenants
+			return data, false
+		}
+		data.Compose = api.composeEntry(id, compose)
+
+	default:
+		return data, false
+	}
+
+	// Tenants only learn about their own composes, which rules out
+	// composes that were deleted
+	if data.Compose == nil && api.store.Tenant() != "" {
+		return data, false
+	}
+
+	return data, true
+}
+
+// streamedCompose returns compose `id` as in /compose/status, or nil if it
+// doesn't exist.
+func (api *API) streamedCompose(id uuid.UUID) *ComposeEntry {
+	compose, exists := api.store.GetCompose(id)
+	if !exists {
+		return nil
+	}
+	return api.composeEntry(id, compose)
+}
+
+// composeEntry returns `compose` as in /compose/status.
+func (api *API) composeEntry(id uuid.UUID, compose compose.Compose) *ComposeEntry {
+	state, queued, started, finished := api.getComposeState(compose)
+	entry := composeToComposeEntry(id, compose, state, queued, started, finished, true)
+	entry.Progress = api.workers.ComposeProgress(compose)
+	return entry
+}
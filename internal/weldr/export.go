@@ -0,0 +1,416 @@
+package weldr
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/store"
+)
+
+// Blueprints and sources are exported to a tar archive, which can be
+// imported into another composer, or kept in git and imported to seed a new
+// one. Each blueprint is blueprints/<name>.toml and each source is
+// sources/<name>.toml, or .json for archives in JSON. Only the committed
+// version of each blueprint is exported, without its history. The gpg keys
+// of sources are inlined, so they are stored under the names of inline keys
+// when they are imported.
+
+// Strategies for importing blueprints and sources that already exist with
+// different contents
+const (
+	importConflictFail    = "fail"
+	importConflictSkip    = "skip"
+	importConflictReplace = "replace"
+)
+
+// exportHandler sends a tar archive of all blueprints and sources, in TOML
+// or in JSON with ?format=json.
+func (api *API) exportHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	q, err := url.ParseQuery(request.URL.RawQuery)
+	if err != nil {
+		errors := responseError{
+			ID:  "InvalidChars",
+			Msg: fmt.Sprintf("invalid query string: %v", err),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	format := q.Get("format")
+	if format == "" {
+		format = "toml"
+	}
+	if format != "toml" && format != "json" {
+		errors := responseError{
+			ID:  "InvalidChars",
+			Msg: fmt.Sprintf("invalid format parameter: %s", format),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	type entry struct {
+		name  string
+		value interface{}
+	}
+
+	var entries []entry
+	names := api.store.ListBlueprints()
+	sort.Strings(names)
+	for _, name := range names {
+		if bp := api.store.GetBlueprintCommitted(name); bp != nil {
+			entries = append(entries, entry{path.Join("blueprints", name+"."+format), bp})
+		}
+	}
+	for _, name := range api.store.ListSources() {
+		if source := api.store.GetSource(name); source != nil {
+			entries = append(entries, entry{path.Join("sources", name+"."+format), api.exportSource(*source)})
+		}
+	}
+
+	writer.Header().Set("Content-Type", "application/x-tar")
+	writer.Header().Set("Content-Disposition", "attachment; filename=composer-export.tar")
+
+	now := time.Now()
+	tw := tar.NewWriter(writer)
+	for _, e := range entries {
+		var buf bytes.Buffer
+		if format == "json" {
+			encoder := json.NewEncoder(&buf)
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(e.value)
+		} else {
+			encoder := toml.NewEncoder(&buf)
+			encoder.Indent = ""
+			err = encoder.Encode(e.value)
+		}
+		common.PanicOnError(err)
+
+		err = tw.WriteHeader(&tar.Header{
+			Name:    e.name,
+			Mode:    0644,
+			Size:    int64(buf.Len()),
+			ModTime: now,
+		})
+		if err == nil {
+			_, err = buf.WriteTo(tw)
+		}
+		if err != nil {
+			// The response has started, there is no way to report this
+			return
+		}
+	}
+	_ = tw.Close()
+}
+
+// exportSource returns `source` in the format that /projects/source/new
+// accepts, with its gpg keys inlined.
+func (api *API) exportSource(source store.SourceConfig) SourceConfigV0 {
+	exported := SourceConfigV0{
+		Name:          source.Name,
+		Type:          source.Type,
+		URL:           source.URL,
+		CheckGPG:      source.CheckGPG,
+		CheckSSL:      source.CheckSSL,
+		Proxy:         source.Proxy,
+		GPGUrls:       source.GPGKeyURLs,
+		SSLCACert:     source.SSLCACert,
+		SSLClientCert: source.SSLClientCert,
+		SSLClientKey:  source.SSLClientKey,
+		Mirrors:       source.Mirrors,
+		Priority:      source.Priority,
+		Cost:          source.Cost,
+	}
+	for _, name := range source.GPGKeys {
+		if key, exists := api.store.GetGPGKey(name); exists {
+			exported.GPGKeys = append(exported.GPGKeys, key)
+		}
+	}
+	return exported
+}
+
+// importHandler imports blueprints and sources from an archive that
+// exportHandler sent. Blueprints and sources which exist with different
+// contents are conflicts, which fail the import by default. ?conflict=skip
+// keeps the existing ones and ?conflict=replace replaces them. The archive
+// is checked completely before anything is imported, and then either all of
+// it or nothing is imported.
+func (api *API) importHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	contentType := request.Header["Content-Type"]
+	if len(contentType) != 1 || contentType[0] != "application/x-tar" {
+		errors := responseError{
+			ID:  "MissingPost",
+			Msg: "archive must be application/x-tar",
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	q, err := url.ParseQuery(request.URL.RawQuery)
+	if err != nil {
+		errors := responseError{
+			ID:  "InvalidChars",
+			Msg: fmt.Sprintf("invalid query string: %v", err),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	conflict := q.Get("conflict")
+	if conflict == "" {
+		conflict = importConflictFail
+	}
+	if conflict != importConflictFail && conflict != importConflictSkip && conflict != importConflictReplace {
+		errors := responseError{
+			ID:  "InvalidChars",
+			Msg: fmt.Sprintf("invalid conflict parameter: %s, must be fail, skip, or replace", conflict),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	blueprints, sources, err := readImportArchive(request.Body)
+	if err == nil {
+		err = api.validateImportSources(sources)
+	}
+	if err != nil {
+		errors := responseError{
+			ID:  "BadImport",
+			Msg: fmt.Sprintf("cannot import archive: %v", err),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	type importResult struct {
+		Imported  []string `json:"imported"`
+		Unchanged []string `json:"unchanged"`
+		Skipped   []string `json:"skipped"`
+	}
+	blueprintResult := importResult{[]string{}, []string{}, []string{}}
+	sourceResult := importResult{[]string{}, []string{}, []string{}}
+
+	var importBlueprints []blueprint.Blueprint
+	var importSources []SourceConfigV0
+	var conflicts []responseError
+	for _, bp := range blueprints {
+		existing := api.store.GetBlueprintCommitted(bp.Name)
+		switch {
+		case existing == nil:
+		case sameJSON(existing, bp):
+			blueprintResult.Unchanged = append(blueprintResult.Unchanged, bp.Name)
+			continue
+		case conflict == importConflictSkip:
+			blueprintResult.Skipped = append(blueprintResult.Skipped, bp.Name)
+			continue
+		case conflict == importConflictFail:
+			conflicts = append(conflicts, responseError{
+				ID:  "ImportConflict",
+				Msg: fmt.Sprintf("blueprint %s already exists", bp.Name),
+			})
+			continue
+		}
+		importBlueprints = append(importBlueprints, bp)
+		blueprintResult.Imported = append(blueprintResult.Imported, bp.Name)
+	}
+	for _, source := range sources {
+		existing := api.store.GetSource(source.Name)
+		switch {
+		case existing == nil:
+		case sameJSON(api.exportSource(*existing), source):
+			sourceResult.Unchanged = append(sourceResult.Unchanged, source.Name)
+			continue
+		case conflict == importConflictSkip:
+			sourceResult.Skipped = append(sourceResult.Skipped, source.Name)
+			continue
+		case conflict == importConflictFail:
+			conflicts = append(conflicts, responseError{
+				ID:  "ImportConflict",
+				Msg: fmt.Sprintf("source %s already exists", source.Name),
+			})
+			continue
+		}
+		importSources = append(importSources, source)
+		sourceResult.Imported = append(sourceResult.Imported, source.Name)
+	}
+	if len(conflicts) > 0 {
+		statusResponseError(writer, http.StatusConflict, conflicts...)
+		return
+	}
+
+	var sourceConfigs []store.SourceConfig
+	gpgKeys := make(map[string]string)
+	for _, source := range importSources {
+		// Keys are inlined, their URLs only need to be recorded
+		urls := source.GPGUrls
+		source.GPGUrls = nil
+		sourceConfig := source.SourceConfig()
+		sourceConfig.GPGKeyURLs = urls
+		var keys map[string]string
+		sourceConfig.GPGKeys, keys, err = api.sourceKeys(&source)
+		if err != nil {
+			errors := responseError{
+				ID:  "BadImport",
+				Msg: fmt.Sprintf("cannot import archive: source %s: %v", source.Name, err),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+		for name, key := range keys {
+			gpgKeys[name] = key
+		}
+		sourceConfigs = append(sourceConfigs, sourceConfig)
+	}
+
+	err = api.store.Import(sourceConfigs, importBlueprints, gpgKeys, func(bp blueprint.Blueprint) string {
+		return "Recipe " + bp.Name + ", version " + bp.Version + " imported."
+	})
+	if err != nil {
+		errors := responseError{
+			ID:        "BlueprintsError",
+			Msg:       fmt.Sprintf("import failed: %v", err),
+			ErrorCode: common.ErrorInternal,
+		}
+		statusResponseError(writer, http.StatusInternalServerError, errors)
+		return
+	}
+
+	err = json.NewEncoder(writer).Encode(struct {
+		Status     bool         `json:"status"`
+		Blueprints importResult `json:"blueprints"`
+		Sources    importResult `json:"sources"`
+	}{true, blueprintResult, sourceResult})
+	common.PanicOnError(err)
+}
+
+// readImportArchive returns the blueprints and sources of the archive that
+// `r` reads. Directories and files other than blueprints and sources are
+// ignored.
+func readImportArchive(r io.Reader) ([]blueprint.Blueprint, []SourceConfigV0, error) {
+	var blueprints []blueprint.Blueprint
+	var sources []SourceConfigV0
+	seen := make(map[string]bool)
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid archive: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := strings.TrimPrefix(path.Clean(header.Name), "./")
+		kind := strings.SplitN(name, "/", 2)[0]
+		if kind != "blueprints" && kind != "sources" {
+			continue
+		}
+
+		var decode func(interface{}) error
+		switch path.Ext(name) {
+		case ".toml":
+			decode = func(v interface{}) error {
+				_, err := toml.DecodeReader(tr, v)
+				return err
+			}
+		case ".json":
+			decode = json.NewDecoder(tr).Decode
+		default:
+			return nil, nil, fmt.Errorf("%s is neither toml nor json", name)
+		}
+
+		if kind == "blueprints" {
+			var bp blueprint.Blueprint
+			err = decode(&bp)
+			if err == nil && bp.Name == "" {
+				err = fmt.Errorf("blueprint has no name")
+			}
+			if err == nil {
+				err = bp.Initialize()
+			}
+			if err == nil && seen["blueprint "+bp.Name] {
+				err = fmt.Errorf("blueprint %s is in the archive twice", bp.Name)
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", name, err)
+			}
+			seen["blueprint "+bp.Name] = true
+			blueprints = append(blueprints, bp)
+		} else {
+			var source SourceConfigV0
+			err = decode(&source)
+			if err == nil && seen["source "+source.Name] {
+				err = fmt.Errorf("source %s is in the archive twice", source.Name)
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", name, err)
+			}
+			seen["source "+source.Name] = true
+			sources = append(sources, source)
+		}
+	}
+
+	return blueprints, sources, nil
+}
+
+// validateImportSources checks `sources` like /projects/source/new does.
+// Sources cannot replace the system's repositories.
+func (api *API) validateImportSources(sources []SourceConfigV0) error {
+	for i := range sources {
+		source := &sources[i]
+		if len(source.Name) == 0 {
+			return fmt.Errorf("source has no name")
+		}
+		if len(source.Type) == 0 {
+			return fmt.Errorf("source %s has no type", source.Name)
+		}
+		for _, repo := range api.repos {
+			if repo.Id == source.Name {
+				return fmt.Errorf("source %s is a system source", source.Name)
+			}
+		}
+		err := validateSourceTLS(source)
+		if err == nil {
+			err = validateSourceMirrors(source)
+		}
+		if err != nil {
+			return fmt.Errorf("source %s: %v", source.Name, err)
+		}
+	}
+	return nil
+}
+
+// sameJSON returns whether `a` and `b` encode to the same JSON.
+func sameJSON(a, b interface{}) bool {
+	aJSON, err := json.Marshal(a)
+	common.PanicOnError(err)
+	bJSON, err := json.Marshal(b)
+	common.PanicOnError(err)
+	return bytes.Equal(aJSON, bJSON)
+}
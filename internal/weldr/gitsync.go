@@ -0,0 +1,267 @@
+package weldr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/store"
+)
+
+// GitSyncConfig configures synchronizing blueprints from a git repository,
+// which makes the repository their source of truth. It is usually loaded
+// from a TOML file:
+//
+//	url = "https://git.example.com/infra/blueprints.git"
+//	branch = "main"
+//	directory = "blueprints"
+//	interval = "5m"
+//	prune = true
+//
+// Every `interval` (default 5m), the blueprints in the *.toml files in
+// `directory` of `branch` (default the top level of master) are compared to
+// the committed ones, and those whose contents differ are committed with the
+// git commit as the change message. Blueprints that were changed through the
+// API are thus changed back. The repository is only cloned again when its
+// branch moved. Nothing is changed unless all files are valid blueprints.
+// With `prune`, blueprints which were removed from the repository are
+// deleted.
+//
+// This runs the git command, which must be installed.
+type GitSyncConfig struct {
+	URL       string `toml:"url" json:"url"`
+	Branch    string `toml:"branch" json:"branch"`
+	Directory string `toml:"directory" json:"directory,omitempty"`
+	Interval  string `toml:"interval" json:"interval"`
+	Prune     bool   `toml:"prune" json:"prune"`
+
+	// Parsed from the above
+	interval time.Duration
+}
+
+func LoadGitSyncConfig(path string) (*GitSyncConfig, error) {
+	config := GitSyncConfig{
+		Branch:   "master",
+		Interval: "5m",
+	}
+	_, err := toml.DecodeFile(path, &config)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load git sync configuration: %v", err)
+	}
+
+	if config.URL == "" {
+		return nil, fmt.Errorf("%s: url is missing", path)
+	}
+	if config.Branch == "" || strings.HasPrefix(config.Branch, "-") {
+		return nil, fmt.Errorf("%s: invalid branch: %s", path, config.Branch)
+	}
+	config.interval, err = time.ParseDuration(config.Interval)
+	if err != nil || config.interval <= 0 {
+		return nil, fmt.Errorf("%s: interval must be a duration like 5m: %s", path, config.Interval)
+	}
+	if config.Directory != "" {
+		dir := filepath.Clean(config.Directory)
+		if filepath.IsAbs(dir) || dir == ".." || strings.HasPrefix(dir, "../") {
+			return nil, fmt.Errorf("%s: directory must be within the repository: %s", path, config.Directory)
+		}
+	}
+
+	return &config, nil
+}
+
+// SetGitSync enables synchronizing blueprints from git, which is run by
+// RunGitSync.
+func (api *API) SetGitSync(config *GitSyncConfig) {
+	api.gitSync = config
+}
+
+// RunGitSync synchronizes the blueprints from git if the configured interval
+// passed since it last did. It is meant to be called periodically with the
+// current time.
+func (api *API) RunGitSync(now time.Time) error {
+	if api.gitSync == nil || now.Sub(api.gitSyncChecked) < api.gitSync.interval {
+		return nil
+	}
+	api.gitSyncChecked = now
+
+	state := store.GitSync{}
+	if last := api.store.GetGitSync(); last != nil {
+		state = *last
+	}
+	state.Checked = now
+
+	err := api.syncGit(&state, now)
+	if err != nil {
+		state.Error = err.Error()
+	} else {
+		state.Error = ""
+	}
+
+	serr := api.store.SetGitSync(state)
+	if err != nil {
+		return fmt.Errorf("cannot sync blueprints from git: %v", err)
+	}
+	return serr
+}
+
+// syncGit commits the blueprints of the configured repository which differ
+// from the committed ones, and records what it did in `state`.
+func (api *API) syncGit(state *store.GitSync, now time.Time) error {
+	config := api.gitSync
+
+	head, err := runGit("", "ls-remote", "--", config.URL, "refs/heads/"+config.Branch)
+	if err != nil {
+		return err
+	}
+	if head == "" {
+		return fmt.Errorf("branch %s doesn't exist", config.Branch)
+	}
+	head = strings.Fields(head)[0]
+
+	if head != state.Commit || api.gitSyncBlueprints == nil {
+		commit, blueprints, err := readGitBlueprints(config)
+		if err != nil {
+			return err
+		}
+		api.gitSyncBlueprints = blueprints
+		state.Commit = commit
+		state.Synced = now
+	}
+
+	names := make(map[string]bool)
+	for _, bp := range api.gitSyncBlueprints {
+		names[bp.Name] = true
+		// Committing a blueprint without changing its version bumps it, so
+		// versions are not compared
+		if committed := api.store.GetBlueprintCommitted(bp.Name); committed != nil {
+			committed.Version = bp.Version
+			if sameJSON(committed, bp) {
+				continue
+			}
+		}
+		err = api.store.PushBlueprint(bp, "Synced from git commit "+state.Commit)
+		if err != nil {
+			return fmt.Errorf("cannot commit blueprint %s: %v", bp.Name, err)
+		}
+		log.Printf("synced blueprint %s from git commit %s", bp.Name, state.Commit)
+	}
+
+	if config.Prune {
+		for _, name := range state.Blueprints {
+			if names[name] || api.store.GetBlueprintCommitted(name) == nil {
+				continue
+			}
+			err = api.store.DeleteBlueprint(name)
+			if err != nil {
+				return fmt.Errorf("cannot delete blueprint %s: %v", name, err)
+			}
+			log.Printf("deleted blueprint %s, which was removed from git", name)
+		}
+	}
+
+	state.Blueprints = make([]string, 0, len(names))
+	for name := range names {
+		state.Blueprints = append(state.Blueprints, name)
+	}
+	sort.Strings(state.Blueprints)
+
+	return nil
+}
+
+// readGitBlueprints clones the configured branch and returns its head commit
+// and the blueprints in it. All blueprints must be valid.
+func readGitBlueprints(config *GitSyncConfig) (string, []blueprint.Blueprint, error) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-gitsync-")
+	if err != nil {
+		return "", nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	_, err = runGit("", "clone", "--quiet", "--depth", "1", "--single-branch", "--branch", config.Branch, "--", config.URL, dir)
+	if err != nil {
+		return "", nil, err
+	}
+	commit, err := runGit(dir, "rev-parse", "HEAD")
+	if err != nil {
+		return "", nil, err
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, config.Directory))
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot read directory %s: %v", config.Directory, err)
+	}
+
+	var blueprints []blueprint.Blueprint
+	seen := make(map[string]string)
+	for _, file := range files {
+		if !file.Mode().IsRegular() || filepath.Ext(file.Name()) != ".toml" {
+			continue
+		}
+		name := path.Join(config.Directory, file.Name())
+
+		var bp blueprint.Blueprint
+		_, err = toml.DecodeFile(filepath.Join(dir, name), &bp)
+		if err == nil && bp.Name == "" {
+			err = fmt.Errorf("blueprint has no name")
+		}
+		if err == nil {
+			err = bp.Initialize()
+		}
+		if err == nil && seen[bp.Name] != "" {
+			err = fmt.Errorf("blueprint %s is also in %s", bp.Name, seen[bp.Name])
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("%s at commit %s: %v", name, commit, err)
+		}
+		seen[bp.Name] = name
+		blueprints = append(blueprints, bp)
+	}
+
+	return commit, blueprints, nil
+}
+
+// runGit runs git with `args` in `dir` and returns its output. Git must not
+// ask for credentials, because nobody could answer.
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitSyncHandler returns the configuration of synchronizing blueprints from
+// git and what it last did.
+func (api *API) gitSyncHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	type reply struct {
+		Config *GitSyncConfig `json:"config"`
+		State  *store.GitSync `json:"state"`
+	}
+
+	err := json.NewEncoder(writer).Encode(reply{api.gitSync, api.store.GetGitSync()})
+	common.PanicOnError(err)
+}
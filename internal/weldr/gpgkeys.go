@@ -0,0 +1,258 @@
+package weldr
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/store"
+)
+
+// GPG keys are larger than this only if something is wrong
+const maxGPGKeySize = 1 << 20
+
+// fetchGPGKey downloads the armored key at `keyURL`. Besides http and https,
+// file URLs are supported for keys on the host, as in dnf's gpgkey option.
+func fetchGPGKey(keyURL string) (string, error) {
+	u, err := url.Parse(keyURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid gpg key url %s: %v", keyURL, err)
+	}
+
+	var body io.ReadCloser
+	switch u.Scheme {
+	case "file":
+		body, err = os.Open(u.Path)
+		if err != nil {
+			return "", fmt.Errorf("cannot read gpg key %s: %v", keyURL, err)
+		}
+	case "http", "https":
+		client := &http.Client{Timeout: 30 * time.Second}
+		resp, err := client.Get(keyURL)
+		if err != nil {
+			return "", fmt.Errorf("cannot fetch gpg key %s: %v", keyURL, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return "", fmt.Errorf("cannot fetch gpg key %s: %s", keyURL, resp.Status)
+		}
+		body = resp.Body
+	default:
+		return "", fmt.Errorf("unsupported gpg key url: %s", keyURL)
+	}
+	defer body.Close()
+
+	key, err := ioutil.ReadAll(io.LimitReader(body, maxGPGKeySize))
+	if err != nil {
+		return "", fmt.Errorf("cannot read gpg key %s: %v", keyURL, err)
+	}
+	if !store.IsGPGKey(string(key)) {
+		return "", fmt.Errorf("%s is not an armored gpg public key", keyURL)
+	}
+
+	return string(key), nil
+}
+
+// resolveSourceKeys returns the names of the keys `source` is checked
+// against. Its "gpgkeys" are names of stored keys or inline armored keys,
+// and its "gpgkey_urls" point to keys that are fetched. Inline and fetched
+// keys are added to the key store under a name derived from their contents.
+// Keys that cannot be fetched are skipped.
+func (api *API) resolveSourceKeys(source *SourceConfigV0) ([]string, error) {
+	names, keys, err := api.sourceKeys(source)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, key := range keys {
+		err := api.store.AddGPGKey(name, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return names, nil
+}
+
+// sourceKeys returns the names of the keys `source` is checked with, like
+// resolveSourceKeys, and the keys which need to be stored for them, without
+// storing them.
+func (api *API) sourceKeys(source *SourceConfigV0) ([]string, map[string]string, error) {
+	var names []string
+	keys := make(map[string]string)
+
+	for _, key := range source.GPGKeys {
+		if store.IsGPGKey(key) {
+			name := store.GPGKeyName(key)
+			keys[name] = key
+			names = append(names, name)
+			continue
+		}
+		if _, exists := api.store.GetGPGKey(key); !exists {
+			return nil, nil, fmt.Errorf("unknown gpg key: %s", key)
+		}
+		names = append(names, key)
+	}
+
+	for _, keyURL := range source.GPGUrls {
+		key, err := fetchGPGKey(keyURL)
+		if err != nil {
+			// Like lorax, accept sources whose keys cannot be fetched
+			// (yet); their packages are installed without the key.
+			log.Printf("cannot add gpg key of source %s: %v", source.Name, err)
+			continue
+		}
+		name := store.GPGKeyName(key)
+		keys[name] = key
+		names = append(names, name)
+	}
+
+	return names, keys, nil
+}
+
+type gpgKeyInfo struct {
+	Name   string   `json:"name"`
+	Key    string   `json:"key,omitempty"`
+	UsedBy []string `json:"used_by"`
+}
+
+func (api *API) gpgKeyListHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	type reply struct {
+		GPGKeys []gpgKeyInfo `json:"gpgkeys"`
+	}
+
+	keys := []gpgKeyInfo{}
+	for _, name := range api.store.ListGPGKeys() {
+		keys = append(keys, gpgKeyInfo{Name: name, UsedBy: api.store.GPGKeyUsers(name)})
+	}
+
+	err := json.NewEncoder(writer).Encode(reply{keys})
+	common.PanicOnError(err)
+}
+
+func (api *API) gpgKeyInfoHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	name := params.ByName("name")
+	key, exists := api.store.GetGPGKey(name)
+	if !exists {
+		errors := responseError{
+			ID:  "UnknownGPGKey",
+			Msg: fmt.Sprintf("%s is not a valid gpg key", name),
+		}
+		statusResponseError(writer, http.StatusNotFound, errors)
+		return
+	}
+
+	err := json.NewEncoder(writer).Encode(gpgKeyInfo{name, key, api.store.GPGKeyUsers(name)})
+	common.PanicOnError(err)
+}
+
+// gpgKeyNewHandler adds a key to the key store, either inline or from a URL:
+//
+//	{"name": "corp", "key": "-----BEGIN PGP PUBLIC KEY BLOCK-----\n..."}
+//	{"name": "corp", "url": "https://repo.corp.example.com/RPM-GPG-KEY"}
+//
+// Without a name, the key is named after its contents. The reply contains
+// the name, which sources refer to the key by.
+func (api *API) gpgKeyNewHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	var body struct {
+		Name string `json:"name"`
+		Key  string `json:"key"`
+		URL  string `json:"url"`
+	}
+	err := json.NewDecoder(request.Body).Decode(&body)
+	if err == nil && (body.Key == "") == (body.URL == "") {
+		err = fmt.Errorf("exactly one of 'key' and 'url' must be given")
+	}
+	if err == nil && body.URL != "" {
+		body.Key, err = fetchGPGKey(body.URL)
+	}
+	if err != nil {
+		errors := responseError{
+			ID:  "ProjectsError",
+			Msg: "Problem parsing POST body: " + err.Error(),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	if body.Name == "" {
+		body.Name = store.GPGKeyName(body.Key)
+	}
+
+	err = api.store.AddGPGKey(body.Name, body.Key)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if _, ok := err.(*store.InvalidRequestError); ok {
+			status = http.StatusBadRequest
+		}
+		errors := responseError{
+			ID:  "ProjectsError",
+			Msg: err.Error(),
+		}
+		statusResponseError(writer, status, errors)
+		return
+	}
+
+	type reply struct {
+		Status bool   `json:"status"`
+		Name   string `json:"name"`
+	}
+
+	err = json.NewEncoder(writer).Encode(reply{true, body.Name})
+	common.PanicOnError(err)
+}
+
+func (api *API) gpgKeyDeleteHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	name := params.ByName("name")
+	err := api.store.DeleteGPGKey(name)
+	if err != nil {
+		switch err.(type) {
+		case *store.NotFoundError:
+			errors := responseError{
+				ID:  "UnknownGPGKey",
+				Msg: fmt.Sprintf("%s is not a valid gpg key", name),
+			}
+			statusResponseError(writer, http.StatusNotFound, errors)
+		case *store.InvalidRequestError:
+			errors := responseError{
+				ID:  "ProjectsError",
+				Msg: err.Error(),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+		default:
+			errors := responseError{
+				ID:  "ProjectsError",
+				Msg: err.Error(),
+			}
+			statusResponseError(writer, http.StatusInternalServerError, errors)
+		}
+		return
+	}
+
+	statusResponseOK(writer)
+}
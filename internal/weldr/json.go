@@ -111,16 +111,36 @@ type SourceConfigV0 struct {
 	System   bool     `json:"system" toml:"system"`
 	Proxy    string   `json:"proxy" toml:"proxy"`
 	GPGUrls  []string `json:"gpgkey_urls" toml:"gpgkey_urls"`
+
+	SSLCACert     string `json:"sslcacert,omitempty" toml:"sslcacert,omitempty"`
+	SSLClientCert string `json:"sslclientcert,omitempty" toml:"sslclientcert,omitempty"`
+	SSLClientKey  string `json:"sslclientkey,omitempty" toml:"sslclientkey,omitempty"`
+
+	// Names of stored keys or inline armored keys
+	GPGKeys []string `json:"gpgkeys,omitempty" toml:"gpgkeys,omitempty"`
+
+	// URLs of mirrors of yum-baseurl sources, and dnf's priority and cost
+	Mirrors  []string `json:"mirrors,omitempty" toml:"mirrors,omitempty"`
+	Priority int      `json:"priority,omitempty" toml:"priority,omitempty"`
+	Cost     int      `json:"cost,omitempty" toml:"cost,omitempty"`
 }
 
 // SourceConfig returns a SourceConfig struct populated with the supported variables
-// The store does not support proxy and gpgkey_urls
+// Keys must be resolved into names of stored keys separately
 func (s *SourceConfigV0) SourceConfig() (ssc store.SourceConfig) {
 	ssc.Name = s.Name
 	ssc.Type = s.Type
 	ssc.URL = s.URL
 	ssc.CheckGPG = s.CheckGPG
 	ssc.CheckSSL = s.CheckSSL
+	ssc.Proxy = s.Proxy
+	ssc.SSLCACert = s.SSLCACert
+	ssc.SSLClientCert = s.SSLClientCert
+	ssc.SSLClientKey = s.SSLClientKey
+	ssc.GPGKeyURLs = s.GPGUrls
+	ssc.Mirrors = s.Mirrors
+	ssc.Priority = s.Priority
+	ssc.Cost = s.Cost
 
 	return ssc
 }
@@ -182,6 +202,7 @@ type ComposeEntryV0 struct {
 	JobStarted  float64                `json:"job_started,omitempty"`
 	JobFinished float64                `json:"job_finished,omitempty"`
 	Uploads     []uploadResponse       `json:"uploads,omitempty"`
+	Checksum    string                 `json:"checksum,omitempty"`
 }
 
 type ComposeFinishedResponseV0 struct {
@@ -0,0 +1,155 @@
+package weldr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/osbuild"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+)
+
+// composeManifestHandler returns the manifests that a compose would build,
+// without queueing it. It takes the same parameters as /compose, and either
+// the name of a blueprint or a blueprint in TOML, like /compose/blueprint.
+// Admission and quotas are not checked, because nothing is built.
+func (api *API) composeManifestHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	type ManifestRequest struct {
+		BlueprintName string `json:"blueprint_name"`
+		Blueprint     string `json:"blueprint"`
+		composeParameters
+	}
+
+	contentType := request.Header["Content-Type"]
+	if len(contentType) != 1 || contentType[0] != "application/json" {
+		errors := responseError{
+			ID:  "MissingPost",
+			Msg: "request must be json",
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	var mr ManifestRequest
+	err := json.NewDecoder(request.Body).Decode(&mr)
+	if err != nil {
+		errors := responseError{
+			ID:  "BadCompose",
+			Msg: fmt.Sprintf("invalid request: %v", err),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	var bp *blueprint.Blueprint
+	switch {
+	case mr.BlueprintName != "" && mr.Blueprint != "":
+		errors := responseError{
+			ID:  "BadCompose",
+			Msg: "blueprint_name and blueprint cannot be used together",
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+
+	case mr.Blueprint != "":
+		bp, err = parseBlueprint(mr.Blueprint)
+		if err != nil {
+			errors := responseError{
+				ID:        "BlueprintsError",
+				Msg:       fmt.Sprintf("invalid blueprint: %v", err),
+				ErrorCode: common.ErrorInvalidRequest,
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+
+	default:
+		bp = api.store.GetBlueprintCommitted(mr.BlueprintName)
+		if bp == nil {
+			errors := responseError{
+				ID:  "UnknownBlueprint",
+				Msg: fmt.Sprintf("Unknown blueprint name: %s", mr.BlueprintName),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+	}
+
+	arch, imageTypes, buildTypes, payloads, ok := api.composeImageTypes(writer, params, mr.composeParameters)
+	if !ok {
+		return
+	}
+
+	pinned, ok := api.pinnedCompose(writer, params, mr.PackagesFrom)
+	if !ok {
+		return
+	}
+
+	repos := api.composeRepos(arch, pinned)
+	builds, warnings, checksums, ok := api.composeManifests(writer, params, bp, mr.composeParameters, arch, repos, pinned, buildTypes, payloads, len(imageTypes), uuid.Nil)
+	if !ok {
+		return
+	}
+
+	type imageBuildEntry struct {
+		ID            int                 `json:"id"`
+		ComposeType   string              `json:"compose_type"`
+		Arch          string              `json:"arch"`
+		Size          uint64              `json:"size"`
+		Payload       *int                `json:"payload,omitempty"`
+		Manifest      *osbuild.Manifest   `json:"manifest"`
+		Packages      []rpmmd.PackageSpec `json:"packages"`
+		BuildPackages []rpmmd.PackageSpec `json:"build_packages"`
+	}
+
+	type repositoryEntry struct {
+		ID       string `json:"id"`
+		Checksum string `json:"checksum,omitempty"`
+	}
+
+	reply := struct {
+		Blueprint    string            `json:"blueprint"`
+		ImageBuilds  []imageBuildEntry `json:"image_builds"`
+		Repositories []repositoryEntry `json:"repositories"`
+		Warnings     []compose.Warning `json:"warnings,omitempty"`
+	}{
+		Blueprint:    bp.Name,
+		ImageBuilds:  []imageBuildEntry{},
+		Repositories: []repositoryEntry{},
+		Warnings:     warnings,
+	}
+
+	for i, build := range builds {
+		entry := imageBuildEntry{
+			ID:            i,
+			ComposeType:   build.imageType.Name(),
+			Arch:          arch.Name(),
+			Size:          build.size,
+			Manifest:      build.manifest,
+			Packages:      build.packages,
+			BuildPackages: build.buildPackages,
+		}
+		if build.payload >= 0 {
+			payload := build.payload
+			entry.Payload = &payload
+		}
+		reply.ImageBuilds = append(reply.ImageBuilds, entry)
+	}
+
+	for _, repo := range repos {
+		reply.Repositories = append(reply.Repositories, repositoryEntry{repo.Id, checksums[repo.Id]})
+	}
+
+	err = json.NewEncoder(writer).Encode(reply)
+	common.PanicOnError(err)
+}
@@ -0,0 +1,63 @@
+package weldr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+)
+
+// composeMetadataHandler returns what a compose was built from: its
+// blueprint and the repositories its packages were resolved from, as they
+// were when it was started. Sources might have been changed or deleted since.
+func (api *API) composeMetadataHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	uuidString := params.ByName("uuid")
+	id, err := uuid.Parse(uuidString)
+	if err != nil {
+		errors := responseError{
+			ID:  "UnknownUUID",
+			Msg: fmt.Sprintf("%s is not a valid build uuid", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	c, exists := api.store.GetCompose(id)
+	if !exists {
+		errors := responseError{
+			ID:  "UnknownUUID",
+			Msg: fmt.Sprintf("Compose %s doesn't exist", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	type reply struct {
+		ID           uuid.UUID            `json:"id"`
+		Blueprint    *blueprint.Blueprint `json:"blueprint"`
+		Repositories []compose.Repository `json:"repositories"`
+	}
+
+	// Composes from before repositories were recorded have none
+	repos := c.Repositories
+	if repos == nil {
+		repos = []compose.Repository{}
+	}
+
+	err = json.NewEncoder(writer).Encode(reply{
+		ID:           id,
+		Blueprint:    c.Blueprint,
+		Repositories: repos,
+	})
+	common.PanicOnError(err)
+}
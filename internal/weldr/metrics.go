@@ -0,0 +1,65 @@
+package weldr
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+// metricsHandler reports the number of composes in each state and of the
+// jobs in the queue in Prometheus' text format, for monitoring systems to
+// scrape.
+func (api *API) metricsHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	composes := map[string]int{}
+	for _, state := range []common.ComposeState{common.CWaiting, common.CRunning, common.CFinished, common.CFailed} {
+		composes[state.ToString()] = 0
+	}
+	for _, c := range api.store.GetAllComposes() {
+		state, _, _, _ := api.getComposeState(c)
+		composes[state.ToString()]++
+	}
+
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(writer, "# HELP osbuild_composer_composes Number of composes by state.")
+	fmt.Fprintln(writer, "# TYPE osbuild_composer_composes gauge")
+	writeMetric(writer, "osbuild_composer_composes", "state", composes)
+
+	jobs, err := api.workers.QueuedJobs()
+	if err == worker.ErrQueueNotInspectable {
+		return
+	} else if err != nil {
+		if api.logger != nil {
+			api.logger.Printf("cannot list queued jobs: %v", err)
+		}
+		return
+	}
+
+	queued := map[string]int{"pending": 0, "running": 0}
+	for _, j := range jobs {
+		queued[j.Status.String()]++
+	}
+
+	fmt.Fprintln(writer, "# HELP osbuild_composer_queued_jobs Number of jobs in the queue by status.")
+	fmt.Fprintln(writer, "# TYPE osbuild_composer_queued_jobs gauge")
+	writeMetric(writer, "osbuild_composer_queued_jobs", "status", queued)
+}
+
+// writeMetric writes one sample of metric `name` per value, labeled with
+// `label`, sorted by value.
+func writeMetric(writer http.ResponseWriter, name, label string, values map[string]int) {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Fprintf(writer, "%s{%s=%q} %d\n", name, label, k, values[k])
+	}
+}
@@ -0,0 +1,366 @@
+package weldr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/distro"
+	"github.com/osbuild/osbuild-composer/internal/events"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/target"
+)
+
+// NightlyConfig configures the nightly rebuild pipeline. It is usually
+// loaded from a TOML file:
+//
+//	at = "02:30"
+//	blueprints = ["base", "webserver"]
+//	compose_type = "qcow2"
+//	test_command = ["/usr/libexec/test-image", "--boot"]
+//	publish_stage = "prod"
+//
+// Every day at `at` (local time), the metadata of all repositories is
+// snapshotted and the blueprints are depsolved against it, so that all of
+// them are built from the same set of packages. The path of each image is
+// appended to `test_command`, which must exit successfully for the image to
+// pass. Passing images are promoted to `publish_stage`, if it is set.
+type NightlyConfig struct {
+	At           string   `toml:"at" json:"at"`
+	Blueprints   []string `toml:"blueprints" json:"blueprints"`
+	ComposeType  string   `toml:"compose_type" json:"compose_type"`
+	TestCommand  []string `toml:"test_command" json:"test_command,omitempty"`
+	PublishStage string   `toml:"publish_stage" json:"publish_stage,omitempty"`
+}
+
+func LoadNightlyConfig(path string) (*NightlyConfig, error) {
+	var config NightlyConfig
+	_, err := toml.DecodeFile(path, &config)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load nightly configuration: %v", err)
+	}
+
+	_, err = time.Parse("15:04", config.At)
+	if err != nil {
+		return nil, fmt.Errorf("%s: at must be a time of day like 02:30: %s", path, config.At)
+	}
+	if len(config.Blueprints) == 0 {
+		return nil, fmt.Errorf("%s: no blueprints to rebuild", path)
+	}
+	if config.ComposeType == "" {
+		return nil, fmt.Errorf("%s: compose_type is missing", path)
+	}
+
+	return &config, nil
+}
+
+// SetNightly enables the nightly rebuild pipeline, which is run by
+// RunNightly. Promotion stages must be set before.
+func (api *API) SetNightly(config *NightlyConfig) error {
+	_, err := api.arch.GetImageType(config.ComposeType)
+	if err != nil {
+		return fmt.Errorf("unknown compose type for architecture: %s", config.ComposeType)
+	}
+
+	if config.PublishStage != "" {
+		stage, exists := api.promotionStages[config.PublishStage]
+		if !exists {
+			return fmt.Errorf("unknown promotion stage: %s", config.PublishStage)
+		}
+		if stage.After != "" {
+			return fmt.Errorf("nightly builds cannot be published to %s, which comes after %s", stage.Name, stage.After)
+		}
+	}
+
+	api.nightly = config
+	return nil
+}
+
+// RunNightly advances the nightly rebuild pipeline: it checks on the builds
+// of the current run and tests and publishes those that finished. When the
+// previous run is done and the configured time of day has passed, it starts
+// the next run. It is meant to be called periodically with the current time.
+func (api *API) RunNightly(now time.Time) error {
+	if api.nightly == nil {
+		return nil
+	}
+
+	runs := api.store.GetNightlyRuns()
+	if len(runs) > 0 {
+		last := runs[len(runs)-1]
+		if !last.Done() {
+			err := api.advanceNightlyRun(&last, now)
+			if err != nil || !last.Done() {
+				return err
+			}
+		}
+
+		if !last.Started.Before(api.nightlyDue(now)) {
+			return nil
+		}
+	}
+
+	if now.Before(api.nightlyDue(now)) {
+		return nil
+	}
+
+	return api.startNightlyRun(now)
+}
+
+// nightlyDue returns when the run of the day of `now` is due.
+func (api *API) nightlyDue(now time.Time) time.Time {
+	at, _ := time.Parse("15:04", api.nightly.At)
+	return time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+}
+
+func (api *API) startNightlyRun(now time.Time) error {
+	run := store.NightlyRun{
+		ID:      uuid.New(),
+		Started: now,
+	}
+
+	repos := api.allRepositories()
+	_, checksums, err := api.rpmmd.FetchMetadata(repos, api.distro.ModulePlatformID(), api.arch.Name())
+	api.recordSourceFetch(repos, err)
+	if err != nil {
+		return fmt.Errorf("cannot snapshot repositories: %v", err)
+	}
+	run.Snapshot = checksums
+
+	for _, name := range api.nightly.Blueprints {
+		build := store.NightlyBuild{
+			Blueprint: name,
+			State:     store.NightlyBuilding,
+		}
+
+		build.ComposeID, build.BlueprintVersion, err = api.startNightlyCompose(name)
+		if err != nil {
+			build.State = store.NightlyFailed
+			build.Error = err.Error()
+		}
+
+		run.Builds = append(run.Builds, build)
+	}
+
+	if run.Done() {
+		run.Finished = now
+	}
+
+	log.Printf("started nightly run %s", run.ID)
+
+	return api.store.AddNightlyRun(run)
+}
+
+// startNightlyCompose queues a compose of blueprint `name` and returns its
+// id and the version of the blueprint.
+func (api *API) startNightlyCompose(name string) (uuid.UUID, string, error) {
+	bp, _ := api.store.GetBlueprint(name)
+	if bp == nil {
+		return uuid.Nil, "", fmt.Errorf("%s: blueprint not found", name)
+	}
+
+	composeID, err := api.queueLocalCompose(bp, api.nightly.ComposeType)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+
+	return composeID, bp.Version, nil
+}
+
+// queueLocalCompose queues a compose of `bp` whose image is kept by composer
+// and returns its id. Composes that are queued without a request, like the
+// nightly ones, use this.
+func (api *API) queueLocalCompose(bp *blueprint.Blueprint, composeType string) (uuid.UUID, error) {
+	imageType, err := api.arch.GetImageType(composeType)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	size := imageType.Size(0)
+	err = api.store.CheckAdmission("", size)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	composeID := uuid.New()
+	targets := []*target.Target{
+		target.NewLocalTarget(&target.LocalTargetOptions{
+			ComposeId:    composeID,
+			ImageBuildId: 0,
+			Filename:     imageType.Filename(),
+		}),
+	}
+
+	// This uses the metadata that was cached when checking the
+	// repositories for a nightly run or a rebuild, unless a repository
+	// changed in the meantime. The manifest pins the resolved packages.
+	repos := api.allRepositories()
+	packages, buildPackages, checksums, err := api.depsolveBlueprint(bp, imageType)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("cannot depsolve: %v", err)
+	}
+
+	manifest, err := imageType.Manifest(bp.Customizations, repos, packages, buildPackages, distro.ImageOptions{Size: size})
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to create osbuild manifest: %v", err)
+	}
+
+	warnings := composeWarnings(bp, packages, targets)
+
+	jobId, err := api.workers.Enqueue(api.distro.Name(), api.arch.Name(), manifest, targets, nil, jobqueue.PriorityNormal)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	err = api.store.PushCompose(composeID, manifest, imageType, bp, size, targets, warnings, jobId)
+	pushed := err == nil
+	if err == nil {
+		err = api.store.SetImageBuildPackages(composeID, 0, packages, buildPackages)
+	}
+	if err == nil {
+		err = api.store.SetComposeRepositories(composeID, composeRepositories(repos, checksums))
+	}
+	if err != nil {
+		api.unqueueCompose(composeID, []uuid.UUID{jobId}, pushed)
+		return uuid.Nil, err
+	}
+
+	events.Emit(events.ComposeQueued, fmt.Sprintf("Compose %s of blueprint %s queued", composeID, bp.Name),
+		"COMPOSE_ID", composeID.String(),
+		"BLUEPRINT", bp.Name,
+		"BLUEPRINT_VERSION", bp.Version,
+		"IMAGE_TYPE", imageType.Name())
+
+	return composeID, nil
+}
+
+// advanceNightlyRun tests and publishes the builds of `run` which finished
+// since it was last advanced.
+func (api *API) advanceNightlyRun(run *store.NightlyRun, now time.Time) error {
+	for i := range run.Builds {
+		build := &run.Builds[i]
+		if build.State != store.NightlyBuilding {
+			continue
+		}
+
+		c, exists := api.store.GetCompose(build.ComposeID)
+		if !exists {
+			build.State = store.NightlyFailed
+			build.Error = "compose was deleted"
+			continue
+		}
+
+		state, _, _, _ := api.getComposeState(c)
+		switch state {
+		case common.CFinished:
+		case common.CFailed:
+			build.State = store.NightlyFailed
+			build.Error = "compose failed"
+			continue
+		default:
+			continue
+		}
+
+		err := api.testNightlyImage(build.ComposeID)
+		if err != nil {
+			build.State = store.NightlyFailed
+			build.Error = fmt.Sprintf("image test failed: %v", err)
+			continue
+		}
+
+		build.State = store.NightlyPassed
+		if api.nightly.PublishStage == "" {
+			continue
+		}
+
+		stage := api.promotionStages[api.nightly.PublishStage]
+		compatName, _ := c.ImageBuilds[0].ImageType.ToCompatString()
+		imageType, err := api.arch.GetImageType(compatName)
+		if err == nil {
+			err = api.enqueuePromotion(build.ComposeID, stage, imageType.Filename())
+		}
+		if err != nil {
+			build.Error = fmt.Sprintf("cannot publish: %v", err)
+			continue
+		}
+		build.State = store.NightlyPublished
+	}
+
+	if run.Done() {
+		run.Finished = now
+		log.Printf("nightly run %s finished", run.ID)
+	}
+
+	return api.store.UpdateNightlyRun(*run)
+}
+
+// runNightlyTest runs the configured test command on the image of compose
+// `id`. Images pass when there is no test command.
+func (api *API) runNightlyTest(id uuid.UUID) error {
+	if len(api.nightly.TestCommand) == 0 {
+		return nil
+	}
+
+	image, _, err := api.store.GetImageBuildImage(id, 0)
+	if err != nil {
+		return err
+	}
+	defer image.Close()
+
+	f, ok := image.(*os.File)
+	if !ok {
+		return errors.New("image is not stored in a file")
+	}
+
+	command := api.nightly.TestCommand
+	args := append(append([]string{}, command[1:]...), f.Name())
+	cmd := exec.Command(command[0], args...)
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(output.String()))
+	}
+
+	return nil
+}
+
+// nightlyHandler returns the configuration of the nightly rebuild pipeline
+// and its recent runs, newest first.
+func (api *API) nightlyHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	type reply struct {
+		Config *NightlyConfig     `json:"config"`
+		Runs   []store.NightlyRun `json:"runs"`
+	}
+
+	runs := api.store.GetNightlyRuns()
+	for i, j := 0, len(runs)-1; i < j; i, j = i+1, j-1 {
+		runs[i], runs[j] = runs[j], runs[i]
+	}
+
+	err := json.NewEncoder(writer).Encode(reply{
+		Config: api.nightly,
+		Runs:   runs,
+	})
+	common.PanicOnError(err)
+}
@@ -0,0 +1,55 @@
+package weldr
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/distro"
+)
+
+// Refs are paths of names, like "fedora/32/x86_64/iot"
+var ostreeRefRegex = regexp.MustCompile(`^(?:[\w\d][-._\w\d]*\/)*[\w\d][-._\w\d]*$`)
+
+// Commits are identified by their SHA-256 checksum
+var ostreeChecksumRegex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// checkOSTree writes an error response and returns false unless the ostree
+// parameters of a compose request are valid. They are only used by image
+// types which produce ostree commits, and are ignored by all others.
+func checkOSTree(writer http.ResponseWriter, params httprouter.Params, options *distro.OSTreeImageOptions) bool {
+	if options == nil {
+		return true
+	}
+
+	if !isRequestVersionAtLeast(params, 1) {
+		errors := responseError{
+			ID:  "BadCompose",
+			Msg: "ostree parameters require API version 1",
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return false
+	}
+
+	if options.Ref != "" && !ostreeRefRegex.MatchString(options.Ref) {
+		errors := responseError{
+			ID:  "InvalidChars",
+			Msg: fmt.Sprintf("Invalid ostree ref: %s", options.Ref),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return false
+	}
+
+	if options.Parent != "" && !ostreeChecksumRegex.MatchString(options.Parent) {
+		errors := responseError{
+			ID:  "InvalidChars",
+			Msg: fmt.Sprintf("Invalid ostree parent commit: %s", options.Parent),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return false
+	}
+
+	return true
+}
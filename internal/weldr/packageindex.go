@@ -0,0 +1,76 @@
+package weldr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+)
+
+// composeUsingHandler lists all composes whose image contains a package,
+// optionally restricted to a version, release, or architecture with query
+// parameters of the same names. It answers questions like "which images ship
+// openssl-1.1.1g?":
+//
+//	GET /api/v1/compose/using/openssl?version=1.1.1g
+func (api *API) composeUsingHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	type composeUsing struct {
+		ID               uuid.UUID         `json:"id"`
+		Blueprint        string            `json:"blueprint"`
+		BlueprintVersion string            `json:"version"`
+		ImageType        string            `json:"compose_type"`
+		QueueStatus      string            `json:"queue_status"`
+		Package          rpmmd.PackageSpec `json:"package"`
+	}
+
+	type reply struct {
+		Composes []composeUsing `json:"composes"`
+	}
+
+	q, err := url.ParseQuery(request.URL.RawQuery)
+	if err != nil {
+		errors := responseError{
+			ID:  "InvalidChars",
+			Msg: fmt.Sprintf("invalid query string: %v", err),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	uses := api.store.FindPackageUses(params.ByName("package"), q.Get("version"), q.Get("release"), q.Get("arch"))
+
+	composes := []composeUsing{}
+	for _, use := range uses {
+		c, exists := api.store.GetCompose(use.ComposeID)
+		if !exists {
+			continue
+		}
+
+		entry := composeUsing{
+			ID:      use.ComposeID,
+			Package: use.Package,
+		}
+		if c.Blueprint != nil {
+			entry.Blueprint = c.Blueprint.Name
+			entry.BlueprintVersion = c.Blueprint.Version
+		}
+		entry.ImageType, _ = c.ImageBuilds[use.ImageBuildID].ImageType.ToCompatString()
+		state, _, _, _ := api.getComposeState(c)
+		entry.QueueStatus = state.ToString()
+
+		composes = append(composes, entry)
+	}
+
+	err = json.NewEncoder(writer).Encode(reply{composes})
+	common.PanicOnError(err)
+}
@@ -0,0 +1,71 @@
+package weldr
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// peerCredListener wraps a unix socket listener and records the credentials of
+// the peer of each accepted connection in its remote address, which the http
+// server passes on in `request.RemoteAddr`.
+type peerCredListener struct {
+	net.Listener
+}
+
+type peerCredConn struct {
+	net.Conn
+	addr peerCredAddr
+}
+
+type peerCredAddr struct {
+	cred *syscall.Ucred
+}
+
+func (l peerCredListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return conn, nil
+	}
+
+	rawConn, err := unixConn.SyscallConn()
+	if err != nil {
+		return conn, nil
+	}
+
+	var cred *syscall.Ucred
+	err = rawConn.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || cred == nil {
+		return conn, nil
+	}
+
+	return &peerCredConn{conn, peerCredAddr{cred}}, nil
+}
+
+func (c *peerCredConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func (a peerCredAddr) Network() string {
+	return "unix"
+}
+
+func (a peerCredAddr) String() string {
+	return fmt.Sprintf("pid=%d,uid=%d,gid=%d", a.cred.Pid, a.cred.Uid, a.cred.Gid)
+}
+
+// isPrivileged returns true if the client sending `request` runs as root.
+// Clients connecting over anything but a unix socket are never privileged.
+func isPrivileged(request *http.Request) bool {
+	var pid, uid, gid int
+	_, err := fmt.Sscanf(request.RemoteAddr, "pid=%d,uid=%d,gid=%d", &pid, &uid, &gid)
+	return err == nil && uid == 0
+}
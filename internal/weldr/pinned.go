@@ -0,0 +1,121 @@
+package weldr
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/distro"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+)
+
+// Composes can reuse the packages that an earlier compose resolved instead
+// of resolving the blueprint's packages again ("packages_from"). They also
+// use the repositories that the earlier compose recorded, so that the
+// manifests pin the same packages from the same places, and the image can
+// be rebuilt long after the repositories moved on, as long as they still
+// carry the packages.
+
+// pinnedCompose returns the compose whose packages are reused, or nil if
+// there is none. It writes an error response and returns false if it
+// doesn't exist.
+func (api *API) pinnedCompose(writer http.ResponseWriter, params httprouter.Params, id *uuid.UUID) (*compose.Compose, bool) {
+	if id == nil {
+		return nil, true
+	}
+
+	if !isRequestVersionAtLeast(params, 1) {
+		errors := responseError{
+			ID:  "BadCompose",
+			Msg: "packages_from requires API version 1",
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return nil, false
+	}
+
+	c, exists := api.store.GetCompose(*id)
+	if !exists {
+		errors := responseError{
+			ID:  "UnknownUUID",
+			Msg: fmt.Sprintf("Compose %s doesn't exist", id),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return nil, false
+	}
+
+	return &c, true
+}
+
+// composeRepos returns the repositories of a compose for `arch`, which are
+// those that `pinned` recorded if its packages are reused.
+func (api *API) composeRepos(arch distro.Arch, pinned *compose.Compose) []rpmmd.RepoConfig {
+	if pinned == nil || len(pinned.Repositories) == 0 {
+		return api.archRepositories(arch)
+	}
+
+	repos := make([]rpmmd.RepoConfig, 0, len(pinned.Repositories))
+	for _, repo := range pinned.Repositories {
+		repos = append(repos, repo.RepoConfig)
+	}
+	return repos
+}
+
+// pinnedPackages returns the packages of the image and the build root that
+// `pinned` resolved for image build `imageBuildID` of type `imageType`, and
+// the checksums of the repositories' metadata they were resolved from.
+// Image builds of the same type are used when the builds of `pinned` are
+// laid out differently.
+func pinnedPackages(pinned *compose.Compose, imageBuildID int, imageType distro.ImageType, arch distro.Arch) ([]rpmmd.PackageSpec, []rpmmd.PackageSpec, map[string]string, error) {
+	hasType := func(ib compose.ImageBuild) bool {
+		name, exists := ib.ImageType.ToCompatString()
+		return exists && name == imageType.Name() && len(ib.Packages) > 0 && len(ib.BuildPackages) > 0
+	}
+
+	var ib *compose.ImageBuild
+	if imageBuildID < len(pinned.ImageBuilds) && hasType(pinned.ImageBuilds[imageBuildID]) {
+		ib = &pinned.ImageBuilds[imageBuildID]
+	} else {
+		for i := range pinned.ImageBuilds {
+			if hasType(pinned.ImageBuilds[i]) {
+				ib = &pinned.ImageBuilds[i]
+				break
+			}
+		}
+	}
+	if ib == nil {
+		return nil, nil, nil, fmt.Errorf("no packages of a %s image were recorded", imageType.Name())
+	}
+
+	for _, packages := range [][]rpmmd.PackageSpec{ib.Packages, ib.BuildPackages} {
+		for _, p := range packages {
+			if p.Arch != "" && p.Arch != "noarch" && p.Arch != arch.Name() {
+				return nil, nil, nil, fmt.Errorf("packages were resolved for another architecture than %s", arch.Name())
+			}
+		}
+	}
+
+	checksums := make(map[string]string)
+	for _, repo := range pinned.Repositories {
+		if repo.Checksum != "" {
+			checksums[repo.Id] = repo.Checksum
+		}
+	}
+
+	return ib.Packages, ib.BuildPackages, checksums, nil
+}
+
+// pinnedWarnings returns warnings about composes of `bp` that reuse the
+// packages of `pinned`.
+func pinnedWarnings(bp *blueprint.Blueprint, pinned *compose.Compose) []compose.Warning {
+	if pinned.Blueprint == nil || pinned.Blueprint.Name != bp.Name || pinned.Blueprint.Version != bp.Version {
+		return []compose.Warning{{
+			ID:  "PinnedPackages",
+			Msg: "packages are reused from a compose of another blueprint or blueprint version, changes to the blueprint's packages are not applied",
+		}}
+	}
+	return nil
+}
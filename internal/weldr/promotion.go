@@ -0,0 +1,226 @@
+package weldr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/target"
+)
+
+// A PromotionStage is a set of upload targets to which finished composes can
+// be promoted. A stage may require that composes were successfully promoted
+// to another stage first, which makes it possible to model workflows like
+// dev → staging → prod.
+type PromotionStage struct {
+	Name    string          `json:"name"`
+	After   string          `json:"after,omitempty"`
+	Uploads []uploadRequest `json:"uploads"`
+}
+
+// LoadPromotionStages reads promotion stages from a JSON file of the form
+//
+//	{
+//	  "stages": [
+//	    { "name": "staging", "uploads": [ ... ] },
+//	    { "name": "prod", "after": "staging", "uploads": [ ... ] }
+//	  ]
+//	}
+//
+// Uploads have the same format as the "upload" field of compose requests.
+func LoadPromotionStages(path string) ([]PromotionStage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var config struct {
+		Stages []PromotionStage `json:"stages"`
+	}
+	err = json.NewDecoder(f).Decode(&config)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %v", path, err)
+	}
+
+	names := make(map[string]bool)
+	for _, stage := range config.Stages {
+		if stage.Name == "" {
+			return nil, fmt.Errorf("%s: promotion stage without name", path)
+		}
+		if names[stage.Name] {
+			return nil, fmt.Errorf("%s: duplicate promotion stage %s", path, stage.Name)
+		}
+		if len(stage.Uploads) == 0 {
+			return nil, fmt.Errorf("%s: promotion stage %s has no uploads", path, stage.Name)
+		}
+		names[stage.Name] = true
+	}
+
+	for _, stage := range config.Stages {
+		if stage.After != "" && !names[stage.After] {
+			return nil, fmt.Errorf("%s: promotion stage %s comes after unknown stage %s", path, stage.Name, stage.After)
+		}
+	}
+
+	return config.Stages, nil
+}
+
+// SetPromotionStages sets the stages to which composes can be promoted. There
+// are none by default.
+func (api *API) SetPromotionStages(stages []PromotionStage) {
+	api.promotionStages = make(map[string]PromotionStage)
+	for _, stage := range stages {
+		api.promotionStages[stage.Name] = stage
+	}
+}
+
+// promotedTo returns true if compose `c` was successfully promoted to `stage`.
+func promotedTo(c compose.Compose, stage string) bool {
+	for _, p := range c.Promotions {
+		if p.Stage == stage && p.Status == common.IBFinished {
+			return true
+		}
+	}
+	return false
+}
+
+// promotionPending returns true if a promotion of compose `c` to `stage` has
+// not finished yet.
+func promotionPending(c compose.Compose, stage string) bool {
+	for _, p := range c.Promotions {
+		if p.Stage == stage && p.Status == common.IBWaiting {
+			return true
+		}
+	}
+	return false
+}
+
+// composePromoteHandler uploads the image of a finished compose to the
+// targets of a promotion stage. Each compose can only be promoted once to
+// each stage, but failed promotions can be retried.
+func (api *API) composePromoteHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	uuidString := params.ByName("uuid")
+	id, err := uuid.Parse(uuidString)
+	if err != nil {
+		errors := responseError{
+			ID:  "UnknownUUID",
+			Msg: fmt.Sprintf("%s is not a valid build uuid", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	stageName := params.ByName("stage")
+	stage, exists := api.promotionStages[stageName]
+	if !exists {
+		errors := responseError{
+			ID:  "UnknownPromotionStage",
+			Msg: fmt.Sprintf("Unknown promotion stage: %s", stageName),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	composeInfo, exists := api.store.GetCompose(id)
+	if !exists {
+		errors := responseError{
+			ID:  "UnknownUUID",
+			Msg: fmt.Sprintf("Compose %s doesn't exist", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	state, _, _, _ := api.getComposeState(composeInfo)
+	if state != common.CFinished {
+		errors := responseError{
+			ID:  "BuildInWrongState",
+			Msg: fmt.Sprintf("Build %s is in wrong state: %s", uuidString, state.ToString()),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	if composeInfo.Registration != nil && composeInfo.ImageBuilds[0].GetLocalTargetOptions() == nil {
+		errors := responseError{
+			ID:  "BuildMissingFile",
+			Msg: fmt.Sprintf("Build %s was registered without its image and cannot be promoted", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	if stage.After != "" && !promotedTo(composeInfo, stage.After) {
+		errors := responseError{
+			ID:  "BuildInWrongState",
+			Msg: fmt.Sprintf("Build %s must be promoted to %s before %s", uuidString, stage.After, stage.Name),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	if promotedTo(composeInfo, stage.Name) || promotionPending(composeInfo, stage.Name) {
+		errors := responseError{
+			ID:  "BuildInWrongState",
+			Msg: fmt.Sprintf("Build %s is already promoted to %s", uuidString, stage.Name),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	compatName, _ := composeInfo.ImageBuilds[0].ImageType.ToCompatString()
+	imageType, err := api.arch.GetImageType(compatName)
+	if err != nil {
+		errors := responseError{
+			ID:  "UnknownComposeType",
+			Msg: fmt.Sprintf("Unknown compose type for architecture: %s", compatName),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	err = api.enqueuePromotion(id, stage, imageType.Filename())
+	if err != nil {
+		errors := responseError{
+			ID:  "ComposeError",
+			Msg: fmt.Sprintf("Cannot promote build %s: %v", uuidString, err),
+		}
+		statusResponseError(writer, http.StatusInternalServerError, errors)
+		return
+	}
+
+	statusResponseOK(writer)
+}
+
+// enqueuePromotion uploads the image of compose `id`, which is called
+// `filename`, to the targets of `stage`.
+func (api *API) enqueuePromotion(id uuid.UUID, stage PromotionStage, filename string) error {
+	var targets []*target.Target
+	for _, u := range stage.Uploads {
+		targets = append(targets, uploadRequestToTarget(u, filename))
+	}
+
+	jobId, err := api.workers.EnqueuePromotion(id, 0, stage.Name, targets)
+	if err != nil {
+		return err
+	}
+
+	return api.store.AddPromotion(id, compose.Promotion{
+		Stage:       stage.Name,
+		JobId:       jobId,
+		Status:      common.IBWaiting,
+		RequestedAt: time.Now(),
+	})
+}
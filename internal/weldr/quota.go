@@ -0,0 +1,148 @@
+package weldr
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/auth"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/quota"
+	"github.com/osbuild/osbuild-composer/internal/store"
+)
+
+// SetQuotas limits the composes of each tenant and user. Without quotas,
+// only the disk quota (see SetDiskQuota) applies. The quotas are enforced by
+// the stores of all tenants (see SetTenants), so that they also apply to
+// composes which are started through other APIs.
+func (api *API) SetQuotas(config *quota.Config) {
+	api.quotas = config
+
+	check := config.AdmissionCheck(func(c compose.Compose) common.ComposeState {
+		state, _, _, _ := api.getComposeState(c)
+		return state
+	})
+	if api.tenants != nil {
+		api.tenants.SetAdmissionCheck(check)
+	} else {
+		api.store.SetAdmissionCheck(check)
+	}
+}
+
+// quotaUsage returns what the composes in `s` use of the quotas, in total and
+// by the user who requested them.
+func (api *API) quotaUsage(s *store.Store) (quota.Usage, map[string]quota.Usage) {
+	sizes, _ := s.DiskUsage()
+	state := func(c compose.Compose) common.ComposeState {
+		state, _, _, _ := api.getComposeState(c)
+		return state
+	}
+	return quota.ComposeUsage(s.GetAllComposes(), state, func(id uuid.UUID) int64 { return sizes[id] })
+}
+
+// requestOwner returns the name of the client sending `request`, which is
+// recorded as the owner of its composes, or "" if it didn't authenticate.
+func requestOwner(request *http.Request) string {
+	if identity := auth.IdentityFromContext(request.Context()); identity != nil {
+		return identity.Name
+	}
+	return ""
+}
+
+// checkQuotas returns true if the client sending `request` may start a
+// compose whose outputs take up to `size` bytes. Otherwise, it writes an
+// error response which describes the exceeded quota and returns false. The
+// store checks the quotas again when the compose is pushed, because other
+// composes might have been started in the meantime (see queueCompose).
+func (api *API) checkQuotas(writer http.ResponseWriter, request *http.Request, size uint64) bool {
+	err := api.store.CheckAdmission(requestOwner(request), size)
+	if err == nil {
+		return true
+	}
+	composePushError(writer, err)
+	return false
+}
+
+// composePushError writes the error response for `err`, which was returned
+// when queuing a compose. Exceeded quotas are described in the response.
+func composePushError(writer http.ResponseWriter, err error) {
+	exceeded, ok := err.(*quota.ExceededError)
+	if !ok {
+		log.Println("error when pushing new compose: ", err.Error())
+		errors := responseError{
+			ID:  "ComposePushErrored",
+			Msg: err.Error(),
+		}
+		statusResponseError(writer, http.StatusInternalServerError, errors)
+		return
+	}
+
+	status := http.StatusTooManyRequests
+	if exceeded.Limit == "artifact_bytes" {
+		status = http.StatusInsufficientStorage
+	}
+	errors := responseError{
+		ID:    "QuotaExceeded",
+		Msg:   "Quota exceeded: " + err.Error(),
+		Quota: exceeded,
+	}
+	statusResponseError(writer, status, errors)
+}
+
+// quotaHandler reports the quotas and what each tenant and user uses of them.
+// Tenants other than the default tenant only see their own usage.
+func (api *API) quotaHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	type userEntry struct {
+		Name   string       `json:"name"`
+		Usage  quota.Usage  `json:"usage"`
+		Limits quota.Limits `json:"limits"`
+	}
+
+	type tenantEntry struct {
+		Name   string       `json:"name"`
+		Usage  quota.Usage  `json:"usage"`
+		Limits quota.Limits `json:"limits"`
+		Users  []userEntry  `json:"users"`
+	}
+
+	stores := map[string]*store.Store{api.store.Tenant(): api.store}
+	if api.tenants != nil {
+		stores = api.tenants.All()
+	}
+
+	config := api.quotas
+	if config == nil {
+		config = &quota.Config{}
+	}
+
+	tenants := []tenantEntry{}
+	for tenant, s := range stores {
+		total, users := api.quotaUsage(s)
+		entry := tenantEntry{
+			Name:   tenant,
+			Usage:  total,
+			Limits: config.TenantLimits(tenant),
+			Users:  []userEntry{},
+		}
+		for user, usage := range users {
+			entry.Users = append(entry.Users, userEntry{user, usage, config.UserLimits(user)})
+		}
+		sort.Slice(entry.Users, func(i, j int) bool { return entry.Users[i].Name < entry.Users[j].Name })
+		tenants = append(tenants, entry)
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].Name < tenants[j].Name })
+
+	err := json.NewEncoder(writer).Encode(struct {
+		Tenants []tenantEntry `json:"tenants"`
+	}{tenants})
+	common.PanicOnError(err)
+}
@@ -0,0 +1,508 @@
+package weldr
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/distro"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/target"
+)
+
+// RebuildConfig configures automatic rebuilds of blueprints. It is usually
+// loaded from a TOML file:
+//
+//	debounce = "10m"
+//	repository_interval = "1h"
+//
+//	[[blueprints]]
+//	name = "webserver"
+//	compose_types = ["qcow2", "ami"]
+//	on_commit = true
+//	on_repository_change = true
+//
+// Blueprints opt in to being rebuilt when a new version of them is committed
+// (`on_commit`), when the metadata of a repository changed
+// (`on_repository_change`), or both. Repositories are checked every
+// `repository_interval` (default 1h). A rebuild only starts once there were
+// no further changes for `debounce` (default 5m), so that a series of
+// commits leads to a single rebuild. It queues a compose of each of
+// `compose_types`.
+type RebuildConfig struct {
+	Debounce           string             `toml:"debounce" json:"debounce,omitempty"`
+	RepositoryInterval string             `toml:"repository_interval" json:"repository_interval,omitempty"`
+	Blueprints         []RebuildBlueprint `toml:"blueprints" json:"blueprints"`
+
+	// Parsed from the above
+	debounce           time.Duration
+	repositoryInterval time.Duration
+}
+
+type RebuildBlueprint struct {
+	Name               string   `toml:"name" json:"name"`
+	ComposeTypes       []string `toml:"compose_types" json:"compose_types"`
+	OnCommit           bool     `toml:"on_commit" json:"on_commit"`
+	OnRepositoryChange bool     `toml:"on_repository_change" json:"on_repository_change"`
+}
+
+func LoadRebuildConfig(path string) (*RebuildConfig, error) {
+	config := RebuildConfig{
+		Debounce:           "5m",
+		RepositoryInterval: "1h",
+	}
+	_, err := toml.DecodeFile(path, &config)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load rebuild configuration: %v", err)
+	}
+
+	config.debounce, err = time.ParseDuration(config.Debounce)
+	if err != nil || config.debounce < 0 {
+		return nil, fmt.Errorf("%s: debounce must be a duration like 10m: %s", path, config.Debounce)
+	}
+	config.repositoryInterval, err = time.ParseDuration(config.RepositoryInterval)
+	if err != nil || config.repositoryInterval <= 0 {
+		return nil, fmt.Errorf("%s: repository_interval must be a duration like 1h: %s", path, config.RepositoryInterval)
+	}
+
+	if len(config.Blueprints) == 0 {
+		return nil, fmt.Errorf("%s: no blueprints to rebuild", path)
+	}
+	names := make(map[string]bool)
+	for _, bp := range config.Blueprints {
+		if bp.Name == "" {
+			return nil, fmt.Errorf("%s: blueprint without name", path)
+		}
+		if names[bp.Name] {
+			return nil, fmt.Errorf("%s: duplicate blueprint %s", path, bp.Name)
+		}
+		if len(bp.ComposeTypes) == 0 {
+			return nil, fmt.Errorf("%s: blueprint %s has no compose_types", path, bp.Name)
+		}
+		if !bp.OnCommit && !bp.OnRepositoryChange {
+			return nil, fmt.Errorf("%s: blueprint %s is rebuilt neither on_commit nor on_repository_change", path, bp.Name)
+		}
+		names[bp.Name] = true
+	}
+
+	return &config, nil
+}
+
+// SetRebuild enables automatic rebuilds, which are run by RunRebuilds.
+func (api *API) SetRebuild(config *RebuildConfig) error {
+	for _, bp := range config.Blueprints {
+		for _, composeType := range bp.ComposeTypes {
+			_, err := api.arch.GetImageType(composeType)
+			if err != nil {
+				return fmt.Errorf("unknown compose type for architecture: %s", composeType)
+			}
+		}
+	}
+
+	api.rebuild = config
+	return nil
+}
+
+// RunRebuilds looks for changes of the configured blueprints and of the
+// repositories, and rebuilds blueprints whose changes settled. It is meant
+// to be called periodically with the current time.
+func (api *API) RunRebuilds(now time.Time) error {
+	if api.rebuild == nil {
+		return nil
+	}
+
+	// Checksums of the repositories' metadata, if they were checked
+	var snapshot map[string]string
+	if now.Sub(api.rebuildReposChecked) >= api.rebuild.repositoryInterval {
+		repos := api.allRepositories()
+		checksums, err := api.rpmmd.FetchChecksums(repos, api.distro.ModulePlatformID(), api.arch.Name())
+		api.recordSourceFetch(repos, err)
+		if err != nil {
+			return fmt.Errorf("cannot check repositories: %v", err)
+		}
+		snapshot = checksums
+		api.rebuildReposChecked = now
+	}
+
+	rebuilds := api.store.GetRebuilds()
+	for _, config := range api.rebuild.Blueprints {
+		bp := api.store.GetBlueprintCommitted(config.Name)
+		if bp == nil {
+			continue
+		}
+
+		rebuild, exists := rebuilds[config.Name]
+		version, seenSnapshot := rebuild.SeenVersion, rebuild.SeenSnapshot
+		if config.OnCommit {
+			version = bp.Version
+		}
+		if config.OnRepositoryChange && snapshot != nil {
+			seenSnapshot = snapshot
+		}
+
+		// Changes are looked for from when rebuilds were enabled, which is
+		// also when a blueprint opts in to another kind of change
+		changed := !exists
+		if rebuild.BuiltVersion == "" && version != "" {
+			rebuild.BuiltVersion = version
+			changed = true
+		}
+		if rebuild.BuiltSnapshot == nil && seenSnapshot != nil {
+			rebuild.BuiltSnapshot = seenSnapshot
+			changed = true
+		}
+
+		if version != rebuild.SeenVersion || !reflect.DeepEqual(seenSnapshot, rebuild.SeenSnapshot) {
+			rebuild.SeenVersion = version
+			rebuild.SeenSnapshot = seenSnapshot
+			rebuild.Seen = now
+		} else if rebuildReason(rebuild) != "" && now.Sub(rebuild.Seen) >= api.rebuild.debounce {
+			api.startRebuild(bp, config, &rebuild, now)
+		} else if !changed {
+			continue
+		}
+
+		err := api.store.SetRebuild(config.Name, rebuild)
+		if err != nil {
+			if _, ok := err.(*store.NotFoundError); ok {
+				continue
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rebuildReason returns why `rebuild` is due, or "" if it isn't.
+func rebuildReason(rebuild store.Rebuild) string {
+	var reasons []string
+	if rebuild.SeenVersion != rebuild.BuiltVersion {
+		reasons = append(reasons, "blueprint committed")
+	}
+	if !reflect.DeepEqual(rebuild.SeenSnapshot, rebuild.BuiltSnapshot) {
+		reasons = append(reasons, "repositories changed")
+	}
+	return strings.Join(reasons, ", ")
+}
+
+// startRebuild queues a compose of `bp` for each of the compose types of
+// `config` and records them in `rebuild`.
+func (api *API) startRebuild(bp *blueprint.Blueprint, config RebuildBlueprint, rebuild *store.Rebuild, now time.Time) {
+	rebuild.Reason = rebuildReason(*rebuild)
+	rebuild.BuiltVersion = rebuild.SeenVersion
+	rebuild.BuiltSnapshot = rebuild.SeenSnapshot
+	rebuild.Built = now
+	rebuild.Composes = nil
+	rebuild.Error = ""
+
+	for _, composeType := range config.ComposeTypes {
+		composeID, err := api.queueLocalCompose(bp, composeType)
+		if err != nil {
+			rebuild.Error = fmt.Sprintf("%s: %v", composeType, err)
+			break
+		}
+		rebuild.Composes = append(rebuild.Composes, composeID)
+	}
+
+	log.Printf("rebuilding blueprint %s (%s): %v", bp.Name, rebuild.Reason, rebuild.Composes)
+}
+
+// rebuildsHandler returns the configuration of automatic rebuilds and what
+// they last did, by blueprint.
+func (api *API) rebuildsHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	type rebuildInfo struct {
+		Seen     time.Time   `json:"seen"`
+		Built    time.Time   `json:"built,omitempty"`
+		Reason   string      `json:"reason,omitempty"`
+		Composes []uuid.UUID `json:"composes,omitempty"`
+		Error    string      `json:"error,omitempty"`
+		Pending  string      `json:"pending,omitempty"`
+	}
+
+	type reply struct {
+		Config   *RebuildConfig         `json:"config"`
+		Rebuilds map[string]rebuildInfo `json:"rebuilds"`
+	}
+
+	rebuilds := make(map[string]rebuildInfo)
+	for name, rebuild := range api.store.GetRebuilds() {
+		rebuilds[name] = rebuildInfo{
+			Seen:     rebuild.Seen,
+			Built:    rebuild.Built,
+			Reason:   rebuild.Reason,
+			Composes: rebuild.Composes,
+			Error:    rebuild.Error,
+			Pending:  rebuildReason(rebuild),
+		}
+	}
+
+	err := json.NewEncoder(writer).Encode(reply{api.rebuild, rebuilds})
+	common.PanicOnError(err)
+}
+
+// composeRebuildHandler starts a compose that builds the images of an earlier
+// compose again, for retrying failed composes or rebuilding an image on
+// demand. The new compose builds the manifests of the earlier one, with the
+// same packages from the same repositories, unless ?depsolve=true asks to
+// resolve the earlier compose's blueprint again. Uploads are repeated. The
+// ref and parent of ostree commits aren't recorded, so commits whose
+// packages are resolved again get the default ref.
+func (api *API) composeRebuildHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	type ComposeReply struct {
+		BuildID   uuid.UUID         `json:"build_id"`
+		Status    bool              `json:"status"`
+		RebuildOf uuid.UUID         `json:"rebuild_of"`
+		Warnings  []compose.Warning `json:"warnings,omitempty"`
+	}
+
+	q, err := url.ParseQuery(request.URL.RawQuery)
+	if err != nil {
+		errors := responseError{
+			ID:  "InvalidChars",
+			Msg: fmt.Sprintf("invalid query string: %v", err),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	depsolve := false
+	if value := q.Get("depsolve"); value != "" {
+		depsolve, err = strconv.ParseBool(value)
+		if err != nil {
+			errors := responseError{
+				ID:  "InvalidChars",
+				Msg: fmt.Sprintf("Invalid value for depsolve: %s", value),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+	}
+
+	uuidString := params.ByName("uuid")
+	id, err := uuid.Parse(uuidString)
+	if err != nil {
+		errors := responseError{
+			ID:  "UnknownUUID",
+			Msg: fmt.Sprintf("%s is not a valid build uuid", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	c, exists := api.store.GetCompose(id)
+	if !exists {
+		errors := responseError{
+			ID:  "UnknownUUID",
+			Msg: fmt.Sprintf("Compose %s doesn't exist", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	state, _, _, _ := api.getComposeState(c)
+	if state != common.CFinished && state != common.CFailed {
+		errors := responseError{
+			ID:  "BuildInWrongState",
+			Msg: fmt.Sprintf("Build %s not in FINISHED or FAILED state.", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	if c.Blueprint == nil || c.Registration != nil || c.Conversion != nil || c.ImageBuilds[0].Manifest == nil {
+		errors := responseError{
+			ID:  "BadCompose",
+			Msg: fmt.Sprintf("Compose %s wasn't built by composer and cannot be rebuilt", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	cp := composeParameters{Arch: composeArchName(c, api.arch.Name())}
+	arch, ok := api.composeArch(writer, params, cp.Arch)
+	if !ok {
+		return
+	}
+
+	cp.ComposeTypes, err = rebuildComposeTypes(arch, c)
+	if err != nil {
+		errors := responseError{
+			ID:  "BadCompose",
+			Msg: fmt.Sprintf("Compose %s cannot be rebuilt: %v", uuidString, err),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	arch, imageTypes, buildTypes, payloads, ok := api.composeImageTypes(writer, params, cp)
+	if !ok {
+		return
+	}
+
+	composeID := uuid.New()
+	var builds []composeImageBuild
+	var warnings []compose.Warning
+	var repos []rpmmd.RepoConfig
+	var checksums map[string]string
+	if depsolve {
+		cp.Size = c.ImageBuilds[0].Size
+		repos = api.archRepositories(arch)
+		builds, warnings, checksums, ok = api.composeManifests(writer, params, c.Blueprint, cp, arch, repos, nil, buildTypes, payloads, len(imageTypes), composeID)
+		if !ok {
+			return
+		}
+		for i := range imageTypes {
+			builds[i].targets = append(rebuildUploads(c.ImageBuilds[i]), builds[i].targets...)
+		}
+	} else {
+		repos = api.composeRepos(arch, &c)
+		checksums = make(map[string]string)
+		for _, repo := range c.Repositories {
+			checksums[repo.Id] = repo.Checksum
+		}
+		warnings = c.Warnings
+		for i, imageType := range buildTypes {
+			ib := c.ImageBuilds[i]
+			if name, _ := ib.ImageType.ToCompatString(); name != imageType.Name() {
+				errors := responseError{
+					ID:  "BadCompose",
+					Msg: fmt.Sprintf("Compose %s cannot be rebuilt: image build %d isn't a %s image", uuidString, i, imageType.Name()),
+				}
+				statusResponseError(writer, http.StatusBadRequest, errors)
+				return
+			}
+			var checkpoints []string
+			if options := ib.GetLocalTargetOptions(); options != nil {
+				checkpoints = options.Checkpoints
+			}
+			builds = append(builds, composeImageBuild{
+				imageType: imageType,
+				size:      ib.Size,
+				targets: append(rebuildUploads(ib), target.NewLocalTarget(
+					&target.LocalTargetOptions{
+						ComposeId:    composeID,
+						ImageBuildId: i,
+						Filename:     imageType.Filename(),
+						Checkpoints:  checkpoints,
+					},
+				)),
+				packages:      ib.Packages,
+				buildPackages: ib.BuildPackages,
+				manifest:      ib.Manifest,
+				payload:       payloads[i],
+			})
+		}
+	}
+
+	var totalSize uint64
+	for i, build := range builds {
+		if i < len(imageTypes) && !api.admit(writer, request, c.Blueprint, arch, build.imageType, build.size) {
+			return
+		}
+		totalSize += build.size
+	}
+
+	if !api.checkDiskQuota(writer, totalSize) {
+		return
+	}
+
+	if !api.checkQuotas(writer, request, totalSize) {
+		return
+	}
+
+	err = api.queueCompose(request, composeID, c.Blueprint, arch, builds, len(imageTypes), warnings, repos, checksums, jobqueue.PriorityNormal, q.Get("test"))
+	if err == nil {
+		err = api.store.SetComposeRebuildOf(composeID, id)
+	}
+	if err != nil {
+		composePushError(writer, err)
+		return
+	}
+
+	err = json.NewEncoder(writer).Encode(ComposeReply{
+		BuildID:   composeID,
+		Status:    true,
+		RebuildOf: id,
+		Warnings:  warnings,
+	})
+	common.PanicOnError(err)
+}
+
+// composeArchName returns the architecture that the packages of `c` were
+// resolved for, or `fallback` if none of them is specific to one.
+func composeArchName(c compose.Compose, fallback string) string {
+	for _, ib := range c.ImageBuilds {
+		for _, p := range ib.Packages {
+			if p.Arch != "" && p.Arch != "noarch" {
+				return p.Arch
+			}
+		}
+	}
+	return fallback
+}
+
+// rebuildComposeTypes returns the image types that `c` was requested for,
+// which are followed by the payloads of its installers.
+func rebuildComposeTypes(arch distro.Arch, c compose.Compose) ([]string, error) {
+	var composeTypes []string
+	payloads := 0
+	for _, ib := range c.ImageBuilds {
+		if len(composeTypes)+payloads == len(c.ImageBuilds) {
+			break
+		}
+
+		name, exists := ib.ImageType.ToCompatString()
+		if !exists {
+			return nil, fmt.Errorf("unknown image type")
+		}
+		imageType, err := arch.GetImageType(name)
+		if err != nil {
+			return nil, fmt.Errorf("unknown image type for architecture %s: %s", arch.Name(), name)
+		}
+		if _, ok := imageType.(distro.InstallerImageType); ok {
+			payloads++
+		}
+		composeTypes = append(composeTypes, name)
+	}
+	return composeTypes, nil
+}
+
+// rebuildUploads returns new, waiting copies of the upload targets of `ib`.
+func rebuildUploads(ib compose.ImageBuild) []*target.Target {
+	var targets []*target.Target
+	for _, t := range ib.Targets {
+		if _, ok := t.Options.(*target.LocalTargetOptions); ok {
+			continue
+		}
+		upload := *t
+		upload.Uuid = uuid.New()
+		upload.Created = time.Now()
+		upload.Status = common.IBWaiting
+		targets = append(targets, &upload)
+	}
+	return targets
+}
@@ -0,0 +1,246 @@
+package weldr
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/target"
+)
+
+// registerRequest describes an image that was built elsewhere. Images in the
+// cloud are identified by `ImageID` (e.g., an AMI ID) or `URL` (e.g., of a
+// VHD). Uploaded images have the provider "file".
+type registerRequest struct {
+	ComposeType string `json:"compose_type"`
+	Blueprint   struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"blueprint"`
+	Provider string              `json:"provider"`
+	ImageID  string              `json:"image_id"`
+	Region   string              `json:"region"`
+	URL      string              `json:"url"`
+	Metadata map[string]string   `json:"metadata"`
+	Packages []rpmmd.PackageSpec `json:"packages"`
+	BuiltAt  time.Time           `json:"built_at"`
+}
+
+// composeRegisterHandler adds a finished compose for an image that composer
+// didn't build, so that it takes part in retention, inventory, promotion,
+// and comparisons. Images in the cloud are registered with a JSON request:
+//
+//	{"compose_type": "ami", "blueprint": {"name": "web", "version": "1.2.0"},
+//	 "provider": "aws", "image_id": "ami-0123", "region": "us-east-1",
+//	 "metadata": {"built-by": "packer"}}
+//
+// Images are uploaded with a multipart/form-data request, whose "metadata"
+// part has the same format (with the provider "file") and comes before the
+// "image" part. Only uploaded images can be promoted, because composer
+// needs the image to upload it again. Like imports, registrations are
+// restricted to privileged clients.
+func (api *API) composeRegisterHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	if !isPrivileged(request) {
+		errors := responseError{
+			ID:  "PermissionDenied",
+			Msg: "only privileged clients may register images",
+		}
+		statusResponseError(writer, http.StatusForbidden, errors)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "application/json" && mediaType != "multipart/form-data") {
+		errors := responseError{
+			ID:  "MissingPost",
+			Msg: "registration must be application/json or multipart/form-data",
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	var rr registerRequest
+	var image io.Reader
+
+	if mediaType == "application/json" {
+		err = json.NewDecoder(request.Body).Decode(&rr)
+		if err != nil {
+			errors := responseError{
+				ID:  "BadRegistration",
+				Msg: fmt.Sprintf("invalid registration: %v", err),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+		if rr.Provider == "file" {
+			errors := responseError{
+				ID:  "BadRegistration",
+				Msg: "images of provider \"file\" must be uploaded with multipart/form-data",
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+	} else {
+		image, err = readImageParts(request, &rr)
+		if err != nil {
+			errors := responseError{
+				ID:  "BadRegistration",
+				Msg: fmt.Sprintf("invalid registration: %v", err),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+		if rr.Provider == "" {
+			rr.Provider = "file"
+		}
+		if rr.Provider != "file" {
+			errors := responseError{
+				ID:  "BadRegistration",
+				Msg: "uploaded images must have the provider \"file\"",
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+	}
+
+	imageType, exists := common.ImageTypeFromCompatString(rr.ComposeType)
+	if !exists {
+		errors := responseError{
+			ID:  "UnknownComposeType",
+			Msg: fmt.Sprintf("Unknown compose type: %s", rr.ComposeType),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	if rr.Blueprint.Name == "" || rr.Provider == "" || (image == nil && rr.ImageID == "" && rr.URL == "") {
+		errors := responseError{
+			ID:  "BadRegistration",
+			Msg: "a registration needs a blueprint name, a provider, and an image id or url",
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	id := uuid.New()
+	now := time.Now()
+	if rr.BuiltAt.IsZero() {
+		rr.BuiltAt = now
+	}
+
+	ib := compose.ImageBuild{
+		ImageType:   imageType,
+		Targets:     []*target.Target{},
+		JobCreated:  rr.BuiltAt,
+		JobStarted:  rr.BuiltAt,
+		JobFinished: rr.BuiltAt,
+		Packages:    rr.Packages,
+		QueueStatus: common.IBFinished,
+	}
+
+	if image != nil {
+		it, err := api.arch.GetImageType(rr.ComposeType)
+		if err != nil {
+			errors := responseError{
+				ID:  "UnknownComposeType",
+				Msg: fmt.Sprintf("Unknown compose type for architecture: %s", rr.ComposeType),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+
+		if request.ContentLength > 0 && !api.checkDiskQuota(writer, uint64(request.ContentLength)) {
+			return
+		}
+
+		local := target.NewLocalTarget(&target.LocalTargetOptions{
+			ComposeId: id,
+			Filename:  it.Filename(),
+		})
+		local.Status = common.IBFinished
+		ib.Targets = append(ib.Targets, local)
+	}
+
+	c := compose.Compose{
+		Blueprint: &blueprint.Blueprint{
+			Name:    rr.Blueprint.Name,
+			Version: rr.Blueprint.Version,
+		},
+		ImageBuilds: []compose.ImageBuild{ib},
+		Registration: &compose.Registration{
+			Provider:     rr.Provider,
+			ImageID:      rr.ImageID,
+			Region:       rr.Region,
+			URL:          rr.URL,
+			Metadata:     rr.Metadata,
+			RegisteredAt: now,
+		},
+	}
+
+	err = api.store.RegisterCompose(id, c, image)
+	if err != nil {
+		switch err.(type) {
+		case *store.InvalidRequestError, *store.NoLocalTargetError:
+			errors := responseError{
+				ID:  "BadRegistration",
+				Msg: fmt.Sprintf("cannot register image: %v", err),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+		default:
+			errors := responseError{
+				ID:  "ComposeError",
+				Msg: fmt.Sprintf("cannot register image: %v", err),
+			}
+			statusResponseError(writer, http.StatusInternalServerError, errors)
+		}
+		return
+	}
+
+	type reply struct {
+		Status bool      `json:"status"`
+		ID     uuid.UUID `json:"build_id"`
+	}
+
+	err = json.NewEncoder(writer).Encode(reply{true, id})
+	common.PanicOnError(err)
+}
+
+// readImageParts decodes the "metadata" part of a multipart request into
+// `metadata` and returns the "image" part, which is read while it is stored.
+func readImageParts(request *http.Request, metadata interface{}) (io.Reader, error) {
+	reader, err := request.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	part, err := reader.NextPart()
+	if err != nil || part.FormName() != "metadata" {
+		return nil, fmt.Errorf("the first part must be \"metadata\"")
+	}
+	err = json.NewDecoder(part).Decode(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	part, err = reader.NextPart()
+	if err != nil || part.FormName() != "image" {
+		return nil, fmt.Errorf("the second part must be \"image\"")
+	}
+
+	return part, nil
+}
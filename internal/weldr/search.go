@@ -0,0 +1,133 @@
+package weldr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+)
+
+// projectsSearchHandler searches the available packages. The query
+// parameters narrow down the results:
+//
+//	name     glob matching the package name, e.g. "python3-*"
+//	summary  text contained in the summary or description, ignoring case
+//	repo     only consider packages from this repository
+//
+// Results have the same format as projects/info, with a build for each
+// version in each repository, oldest first. They are paginated with offset
+// and limit:
+//
+//	GET /api/v1/projects/search?name=*ssl*&summary=toolkit&repo=updates
+func (api *API) projectsSearchHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	type reply struct {
+		Total    uint                `json:"total"`
+		Offset   uint                `json:"offset"`
+		Limit    uint                `json:"limit"`
+		Projects []rpmmd.PackageInfo `json:"projects"`
+	}
+
+	q, err := url.ParseQuery(request.URL.RawQuery)
+	if err != nil {
+		errors := responseError{
+			ID:  "InvalidChars",
+			Msg: fmt.Sprintf("invalid query string: %v", err),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	offset, limit, err := parseOffsetAndLimit(q)
+	if err != nil {
+		errors := responseError{
+			ID:  "BadLimitOrOffset",
+			Msg: fmt.Sprintf("BadRequest: %s", err.Error()),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	availablePackages, err := api.fetchPackageList()
+	if err != nil {
+		errors := responseError{
+			ID:  "ProjectsError",
+			Msg: fmt.Sprintf("msg: %s", err.Error()),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	name := q.Get("name")
+	if name == "" {
+		name = "*"
+	}
+	packages, err := availablePackages.Search(name)
+	if err != nil {
+		errors := responseError{
+			ID:  "ProjectsError",
+			Msg: fmt.Sprintf("Wrong glob pattern: %s", err.Error()),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	summary := strings.ToLower(q.Get("summary"))
+	repo := q.Get("repo")
+
+	var found rpmmd.PackageList
+	for _, pkg := range packages {
+		if repo != "" && pkg.RepoID != repo {
+			continue
+		}
+		if summary != "" &&
+			!strings.Contains(strings.ToLower(pkg.Summary), summary) &&
+			!strings.Contains(strings.ToLower(pkg.Description), summary) {
+			continue
+		}
+		found = append(found, pkg)
+	}
+
+	// Builds are listed oldest first
+	sort.SliceStable(found, func(i, j int) bool {
+		a, b := found[i], found[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		evrA := rpmmd.PackageSpec{Epoch: a.Epoch, Version: a.Version, Release: a.Release}
+		evrB := rpmmd.PackageSpec{Epoch: b.Epoch, Version: b.Version, Release: b.Release}
+		if c := rpmmd.CompareEVR(evrA, evrB); c != 0 {
+			return c < 0
+		}
+		return a.RepoID < b.RepoID
+	})
+
+	packageInfos := found.ToPackageInfos()
+
+	total := uint(len(packageInfos))
+	start := min(offset, total)
+	n := min(limit, total-start)
+
+	projects := make([]rpmmd.PackageInfo, n)
+	for i := uint(0); i < n; i++ {
+		projects[i] = packageInfos[start+i]
+	}
+
+	err = json.NewEncoder(writer).Encode(reply{
+		Total:    total,
+		Offset:   offset,
+		Limit:    limit,
+		Projects: projects,
+	})
+	common.PanicOnError(err)
+}
@@ -0,0 +1,88 @@
+package weldr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/distro"
+)
+
+// estimateImageSize returns the smallest size an image of `imageType` built
+// from `bp` is estimated to need, based on the installed size of its
+// packages and its filesystem customizations.
+func (api *API) estimateImageSize(bp *blueprint.Blueprint, imageType distro.ImageType) (uint64, error) {
+	packages, buildPackages, _, err := api.depsolveBlueprint(bp, imageType)
+	if err != nil {
+		return 0, err
+	}
+
+	// Use the smallest size the image type accepts, so that only the
+	// customizations grow the image
+	manifest, err := imageType.Manifest(bp.Customizations, api.allRepositories(), packages, buildPackages, distro.ImageOptions{Size: imageType.Size(1)})
+	if err != nil {
+		return 0, err
+	}
+
+	return distro.MinimumImageSize(manifest, packages), nil
+}
+
+// blueprintsSizeHandler estimates how large an image of the type given in
+// the `type` query parameter must at least be for a blueprint, so that users
+// can pick a size before starting a compose.
+func (api *API) blueprintsSizeHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	type reply struct {
+		Blueprint   string `json:"blueprint"`
+		ComposeType string `json:"compose_type"`
+		MinimumSize uint64 `json:"minimum_size"`
+		DefaultSize uint64 `json:"default_size"`
+	}
+
+	name := params.ByName("blueprint")
+	bp, _ := api.store.GetBlueprint(name)
+	if bp == nil {
+		errors := responseError{
+			ID:  "UnknownBlueprint",
+			Msg: fmt.Sprintf("%s: blueprint not found", name),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	composeType := request.URL.Query().Get("type")
+	imageType, err := api.arch.GetImageType(composeType)
+	if err != nil {
+		errors := responseError{
+			ID:  "UnknownComposeType",
+			Msg: fmt.Sprintf("Unknown compose type for architecture: %s", composeType),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	size, err := api.estimateImageSize(bp, imageType)
+	if err != nil {
+		errors := responseError{
+			ID:  "SizeEstimationFailed",
+			Msg: fmt.Sprintf("cannot estimate image size: %v", err),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	err = json.NewEncoder(writer).Encode(reply{
+		Blueprint:   bp.Name,
+		ComposeType: imageType.Name(),
+		MinimumSize: size,
+		DefaultSize: imageType.Size(0),
+	})
+	common.PanicOnError(err)
+}
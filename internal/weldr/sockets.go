@@ -0,0 +1,343 @@
+package weldr
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Surfaces of the API. Besides the socket systemd passes to composer, which
+// serves all of them, the API can be served on unix sockets which only serve
+// some of them, so that each surface can be restricted by the permissions of
+// its socket.
+const (
+	SurfaceWeldrV0 = "weldr-v0"
+	SurfaceWeldrV1 = "weldr-v1"
+	SurfaceAdmin   = "admin"
+	SurfaceMetrics = "metrics"
+)
+
+var surfaces = []string{SurfaceWeldrV0, SurfaceWeldrV1, SurfaceAdmin, SurfaceMetrics}
+
+// Routes of the admin surface, below /api/v:version. They are only useful to
+// privileged clients (see isPrivileged). Paths ending in a slash match all
+// paths they are a prefix of.
+var adminRoutes = []struct {
+	method, path string
+}{
+	{"GET", "/config"},
+	{"GET", "/quota"},
+	{"GET", "/blueprints/workspace"},
+	{"GET", "/compose/export/"},
+	{"POST", "/compose/import"},
+	{"POST", "/compose/register"},
+	{"POST", "/compose/convert"},
+	{"GET", "/audit"},
+	{"GET", "/export"},
+	{"POST", "/import"},
+}
+
+// requestSurface returns the surface that `request` belongs to, or "" if it
+// belongs to all surfaces of the Weldr API, like /api/status.
+func requestSurface(request *http.Request) string {
+	path := request.URL.Path
+	if path == "/metrics" {
+		return SurfaceMetrics
+	}
+
+	var surface string
+	switch {
+	case strings.HasPrefix(path, "/api/v0/"):
+		surface = SurfaceWeldrV0
+		path = strings.TrimPrefix(path, "/api/v0")
+	case strings.HasPrefix(path, "/api/v1/"):
+		surface = SurfaceWeldrV1
+		path = strings.TrimPrefix(path, "/api/v1")
+	default:
+		return ""
+	}
+
+	for _, route := range adminRoutes {
+		if request.Method != route.method {
+			continue
+		}
+		if path == route.path || (strings.HasSuffix(route.path, "/") && strings.HasPrefix(path, route.path)) {
+			return SurfaceAdmin
+		}
+	}
+
+	return surface
+}
+
+// surfaceHandler passes requests to the API if they belong to any of
+// `surfaces`, and answers all others with 404.
+type surfaceHandler struct {
+	api      *API
+	surfaces []string
+}
+
+func (h surfaceHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	surface := requestSurface(request)
+	served := false
+	for _, s := range h.surfaces {
+		if s == surface || (surface == "" && s != SurfaceMetrics) {
+			served = true
+			break
+		}
+	}
+
+	if !served {
+		writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+		notFoundHandler(writer, request)
+		return
+	}
+
+	h.api.ServeHTTP(writer, request)
+}
+
+// ServeSurfaces is like Serve, but only serves requests that belong to any
+// of `surfaces`.
+func (api *API) ServeSurfaces(listener net.Listener, surfaces []string) error {
+	server := &http.Server{Handler: surfaceHandler{api, surfaces}}
+	api.servers.add(server)
+
+	err := server.Serve(peerCredListener{listener})
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+// SocketsConfig configures the unix sockets on which composer serves some
+// surfaces of the API, and the TCP addresses on which it serves them to
+// remote clients over TLS. It is usually loaded from a TOML file:
+//
+//	[[socket]]
+//	path = "/run/osbuild-composer/admin.socket"
+//	mode = "0600"
+//	surfaces = ["admin"]
+//
+//	[[socket]]
+//	path = "/run/osbuild-composer/metrics.socket"
+//	mode = "0660"
+//	group = "monitoring"
+//	surfaces = ["metrics"]
+//
+//	[[tls]]
+//	address = ":8443"
+//	cert = "/etc/osbuild-composer/api-crt.pem"
+//	key = "/etc/osbuild-composer/api-key.pem"
+//	ca = "/etc/osbuild-composer/api-ca.pem"
+//	surfaces = ["weldr-v0", "weldr-v1"]
+//
+// `mode` defaults to 0660. The socket belongs to `group`, if it is set.
+//
+// With `ca`, remote clients must authenticate with a certificate that it
+// signed. Remote clients are never privileged, so the admin surface is of
+// little use to them. They can also be required to send a token, see
+// SetAuthenticator. TLS sockets need at least one of the two.
+type SocketsConfig struct {
+	Sockets []SocketConfig    `toml:"socket" json:"sockets"`
+	TLS     []TLSSocketConfig `toml:"tls" json:"tls,omitempty"`
+}
+
+type SocketConfig struct {
+	Path     string   `toml:"path" json:"path"`
+	Mode     string   `toml:"mode" json:"mode,omitempty"`
+	Group    string   `toml:"group" json:"group,omitempty"`
+	Surfaces []string `toml:"surfaces" json:"surfaces"`
+}
+
+// LoadSocketsConfig loads the configuration at `path`. Clients of TLS
+// sockets must authenticate, so TLS sockets without `ca` are only allowed if
+// `authenticated` is set, i.e., if the API requires tokens.
+func LoadSocketsConfig(path string, authenticated bool) (*SocketsConfig, error) {
+	var config SocketsConfig
+	_, err := toml.DecodeFile(path, &config)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load socket configuration: %v", err)
+	}
+
+	paths := make(map[string]bool)
+	for _, socket := range config.Sockets {
+		if !filepath.IsAbs(socket.Path) {
+			return nil, fmt.Errorf("%s: socket path must be absolute: %s", path, socket.Path)
+		}
+		if paths[socket.Path] {
+			return nil, fmt.Errorf("%s: socket %s is configured twice", path, socket.Path)
+		}
+		paths[socket.Path] = true
+
+		_, err = socket.mode()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		if len(socket.Surfaces) == 0 {
+			return nil, fmt.Errorf("%s: socket %s serves no surfaces", path, socket.Path)
+		}
+		for _, surface := range socket.Surfaces {
+			if !isSurface(surface) {
+				return nil, fmt.Errorf("%s: unknown surface %s, must be one of %s", path, surface, strings.Join(surfaces, ", "))
+			}
+		}
+	}
+
+	addresses := make(map[string]bool)
+	for _, socket := range config.TLS {
+		if socket.Address == "" {
+			return nil, fmt.Errorf("%s: tls socket without address", path)
+		}
+		if addresses[socket.Address] {
+			return nil, fmt.Errorf("%s: tls socket %s is configured twice", path, socket.Address)
+		}
+		addresses[socket.Address] = true
+
+		if socket.CertFile == "" || socket.KeyFile == "" {
+			return nil, fmt.Errorf("%s: tls socket %s needs a cert and a key", path, socket.Address)
+		}
+
+		if socket.CACertFile == "" && !authenticated {
+			return nil, fmt.Errorf("%s: tls socket %s would serve the API to anyone; set a ca or configure authentication with -auth", path, socket.Address)
+		}
+
+		if len(socket.Surfaces) == 0 {
+			return nil, fmt.Errorf("%s: tls socket %s serves no surfaces", path, socket.Address)
+		}
+		for _, surface := range socket.Surfaces {
+			if !isSurface(surface) {
+				return nil, fmt.Errorf("%s: unknown surface %s, must be one of %s", path, surface, strings.Join(surfaces, ", "))
+			}
+		}
+	}
+
+	return &config, nil
+}
+
+func isSurface(name string) bool {
+	for _, s := range surfaces {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *SocketConfig) mode() (os.FileMode, error) {
+	if c.Mode == "" {
+		return 0660, nil
+	}
+
+	mode, err := strconv.ParseUint(c.Mode, 8, 32)
+	if err != nil || mode&^0777 != 0 {
+		return 0, fmt.Errorf("invalid mode of socket %s: %s", c.Path, c.Mode)
+	}
+
+	return os.FileMode(mode), nil
+}
+
+// Listen creates the socket and sets its mode and group. A socket left over
+// from a previous run is replaced.
+//
+// Until its mode is set, the socket has the permissions of the process's
+// umask. Composer usually runs as root with a umask of 022, which doesn't
+// allow others to connect in the meantime.
+func (c *SocketConfig) Listen() (net.Listener, error) {
+	mode, err := c.mode()
+	if err != nil {
+		return nil, err
+	}
+
+	gid := -1
+	if c.Group != "" {
+		group, err := user.LookupGroup(c.Group)
+		if err != nil {
+			return nil, err
+		}
+		gid, err = strconv.Atoi(group.Gid)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if info, err := os.Lstat(c.Path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		err = os.Remove(c.Path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", c.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chown(c.Path, -1, gid)
+	if err == nil {
+		err = os.Chmod(c.Path, mode)
+	}
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("cannot set permissions of socket %s: %v", c.Path, err)
+	}
+
+	return listener, nil
+}
+
+type TLSSocketConfig struct {
+	Address    string   `toml:"address" json:"address"`
+	CertFile   string   `toml:"cert" json:"cert"`
+	KeyFile    string   `toml:"key" json:"key"`
+	CACertFile string   `toml:"ca" json:"ca,omitempty"`
+	Surfaces   []string `toml:"surfaces" json:"surfaces"`
+}
+
+// ServerConfig returns the TLS configuration of the socket. It requires
+// clients to present a certificate signed by the CA, if one is configured.
+func (c *TLSSocketConfig) ServerConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.CACertFile != "" {
+		caCertPEM, err := ioutil.ReadFile(c.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(caCertPEM) {
+			return nil, fmt.Errorf("%s contains no certificate", c.CACertFile)
+		}
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+		conf.ClientCAs = roots
+	}
+
+	return conf, nil
+}
+
+// Listen listens on the socket's address for TLS connections.
+func (c *TLSSocketConfig) Listen() (net.Listener, error) {
+	conf, err := c.ServerConfig()
+	if err != nil {
+		return nil, fmt.Errorf("cannot load TLS configuration of socket %s: %v", c.Address, err)
+	}
+
+	return tls.Listen("tcp", c.Address, conf)
+}
@@ -0,0 +1,51 @@
+package weldr
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+)
+
+func repoNames(repos []rpmmd.RepoConfig) []string {
+	names := make([]string, 0, len(repos))
+	for _, repo := range repos {
+		names = append(names, repo.Id)
+	}
+	return names
+}
+
+// recordSourceFetch updates the stats of `repos` after dnf-json used them,
+// returning `err`. Only errors while setting up repositories or fetching
+// metadata count as failures, because other errors occur after all metadata
+// was fetched. dnf-json names the repository that
+// failed in the error message; all of `repos` are blamed if it doesn't.
+func (api *API) recordSourceFetch(repos []rpmmd.RepoConfig, err error) {
+	names := repoNames(repos)
+	now := time.Now()
+
+	var serr error
+	if err == nil {
+		serr = api.store.SourcesFetched(names, now)
+	} else if dnfErr, ok := err.(*rpmmd.DNFError); ok {
+		if dnfErr.Kind != "RepoError" && dnfErr.Kind != "FetchError" {
+			serr = api.store.SourcesFetched(names, now)
+		} else {
+			var failed []string
+			for _, name := range names {
+				if strings.Contains(dnfErr.Reason, name) {
+					failed = append(failed, name)
+				}
+			}
+			if len(failed) == 0 {
+				failed = names
+			}
+			serr = api.store.SourcesFetchFailed(failed, now, dnfErr.Reason)
+		}
+	}
+
+	if serr != nil {
+		log.Printf("cannot record source stats: %v", serr)
+	}
+}
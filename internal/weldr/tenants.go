@@ -0,0 +1,85 @@
+package weldr
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/osbuild/osbuild-composer/internal/auth"
+	"github.com/osbuild/osbuild-composer/internal/store"
+)
+
+// tenantAPIs are the APIs serving the tenants other than the default one,
+// by tenant. They are created when a tenant is first used.
+type tenantAPIs struct {
+	mu   sync.Mutex
+	apis map[string]*API
+}
+
+// SetTenants serves clients whose identity belongs to a tenant (see
+// auth.Identity) from the tenant's store, so that they only see and change
+// the blueprints, sources, and composes of their tenant. All other clients,
+// including those connecting over unix sockets, use the default tenant's
+// store, which is the store the API was created with.
+func (api *API) SetTenants(tenants *store.Tenants) {
+	api.tenants = tenants
+	api.tenantAPIs = &tenantAPIs{apis: make(map[string]*API)}
+}
+
+// tenantAPI returns the API that serves `request`, which is `api` itself for
+// the default tenant. It writes an error response and returns nil if the
+// request cannot be served.
+func (api *API) tenantAPI(writer http.ResponseWriter, request *http.Request) *API {
+	identity := auth.IdentityFromContext(request.Context())
+	if identity == nil || identity.Tenant == "" {
+		return api
+	}
+
+	tenantAPI, err := api.forTenant(identity.Tenant)
+	if err != nil {
+		errors := responseError{
+			ID:  "PermissionDenied",
+			Msg: fmt.Sprintf("%s cannot use tenant %s: %v", identity.Name, identity.Tenant, err),
+		}
+		statusResponseError(writer, http.StatusForbidden, errors)
+		return nil
+	}
+
+	return tenantAPI
+}
+
+// forTenant returns the API serving `tenant`. It is a copy of `api` that
+// uses the tenant's store. Nightly pipelines and automatic rebuilds are only
+// run for the default tenant.
+func (api *API) forTenant(tenant string) (*API, error) {
+	if api.tenants == nil {
+		return nil, fmt.Errorf("tenants are not enabled")
+	}
+
+	api.tenantAPIs.mu.Lock()
+	defer api.tenantAPIs.mu.Unlock()
+
+	tenantAPI, exists := api.tenantAPIs.apis[tenant]
+	if exists {
+		return tenantAPI, nil
+	}
+
+	s, err := api.tenants.Get(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	clone := *api
+	tenantAPI = &clone
+	tenantAPI.store = s
+	tenantAPI.tenants = nil
+	tenantAPI.tenantAPIs = nil
+	tenantAPI.nightly = nil
+	tenantAPI.rebuild = nil
+	tenantAPI.auditLog = nil
+	tenantAPI.testNightlyImage = tenantAPI.runNightlyTest
+	tenantAPI.setupRouter()
+
+	api.tenantAPIs.apis[tenant] = tenantAPI
+	return tenantAPI, nil
+}
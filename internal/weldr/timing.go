@@ -0,0 +1,154 @@
+package weldr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+)
+
+// imageBuildResult returns the osbuild result of image build `imageBuildID`
+// of `c`, or nil if it has none.
+func (api *API) imageBuildResult(id uuid.UUID, c compose.Compose, imageBuildID int) (*common.ComposeResult, error) {
+	ib := c.ImageBuilds[imageBuildID]
+	if ib.JobId != uuid.Nil {
+		_, result, err := api.workers.JobResult(ib.JobId)
+		return result, err
+	}
+
+	// Image builds from before the job queue keep their result in the
+	// store
+	reader, err := api.store.GetImageBuildResult(id, imageBuildID)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var result common.ComposeResult
+	err = json.NewDecoder(reader).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// composeTimingHandler reports how long each osbuild stage of a finished
+// compose took and which share of the build time that was. Stages are
+// listed in the order they ran, or the slowest first with ?sort=duration.
+func (api *API) composeTimingHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	sortBy := request.URL.Query().Get("sort")
+	if sortBy != "" && sortBy != "duration" {
+		errors := responseError{
+			ID:  "InvalidChars",
+			Msg: fmt.Sprintf("Cannot sort by '%s', only by 'duration'", sortBy),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	uuidString := params.ByName("uuid")
+	id, err := uuid.Parse(uuidString)
+	if err != nil {
+		errors := responseError{
+			ID:  "UnknownUUID",
+			Msg: fmt.Sprintf("%s is not a valid build uuid", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	c, exists := api.store.GetCompose(id)
+	if !exists {
+		errors := responseError{
+			ID:  "UnknownUUID",
+			Msg: fmt.Sprintf("Compose %s doesn't exist", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	state, _, _, _ := api.getComposeState(c)
+	if state != common.CFinished && state != common.CFailed {
+		errors := responseError{
+			ID:  "BuildInWrongState",
+			Msg: fmt.Sprintf("Build %s not in FINISHED or FAILED state.", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	type stageEntry struct {
+		Pipeline string  `json:"pipeline"`
+		Name     string  `json:"name"`
+		Success  bool    `json:"success"`
+		Duration float64 `json:"duration"`
+		Percent  int     `json:"percent"`
+		Output   string  `json:"output,omitempty"`
+	}
+
+	type imageBuildEntry struct {
+		ID       int          `json:"id"`
+		Duration float64      `json:"duration"`
+		Stages   []stageEntry `json:"stages"`
+	}
+
+	imageBuilds := []imageBuildEntry{}
+	for i := range c.ImageBuilds {
+		result, err := api.imageBuildResult(id, c, i)
+		if err != nil {
+			errors := responseError{
+				ID:  "ComposeError",
+				Msg: fmt.Sprintf("Reading the result of compose %s failed", uuidString),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+
+		entry := imageBuildEntry{ID: i, Stages: []stageEntry{}}
+		if result == nil {
+			imageBuilds = append(imageBuilds, entry)
+			continue
+		}
+
+		stages := result.StageResults()
+		for _, s := range stages {
+			entry.Duration += s.Duration.Seconds()
+		}
+		for _, s := range stages {
+			stage := stageEntry{
+				Pipeline: s.Pipeline,
+				Name:     s.Name,
+				Success:  s.Success,
+				Duration: s.Duration.Seconds(),
+				Output:   s.Output,
+			}
+			if entry.Duration > 0 {
+				stage.Percent = int(stage.Duration / entry.Duration * 100)
+			}
+			entry.Stages = append(entry.Stages, stage)
+		}
+		if sortBy == "duration" {
+			sort.SliceStable(entry.Stages, func(i, j int) bool {
+				return entry.Stages[i].Duration > entry.Stages[j].Duration
+			})
+		}
+
+		imageBuilds = append(imageBuilds, entry)
+	}
+
+	err = json.NewEncoder(writer).Encode(struct {
+		UUID        uuid.UUID         `json:"uuid"`
+		ImageBuilds []imageBuildEntry `json:"image_builds"`
+	}{id, imageBuilds})
+	common.PanicOnError(err)
+}
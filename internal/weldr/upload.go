@@ -3,13 +3,16 @@ package weldr
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/osbuild/osbuild-composer/internal/common"
-	"github.com/osbuild/osbuild-composer/internal/distro"
 
 	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
 	"github.com/osbuild/osbuild-composer/internal/target"
+	"github.com/osbuild/osbuild-composer/internal/upload"
 )
 
 type uploadResponse struct {
@@ -43,16 +46,31 @@ type azureUploadSettings struct {
 
 func (azureUploadSettings) isUploadSettings() {}
 
+type vmwareUploadSettings struct {
+	Host       string `json:"host"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	Datacenter string `json:"datacenter"`
+	Datastore  string `json:"datastore"`
+}
+
+func (vmwareUploadSettings) isUploadSettings() {}
+
 type uploadRequest struct {
 	Provider  string         `json:"provider"`
 	ImageName string         `json:"image_name"`
 	Settings  uploadSettings `json:"settings"`
+
+	// Where the destination is located, for providers whose settings
+	// don't say. Builds are preferably run by workers in this region.
+	Region string `json:"region,omitempty"`
 }
 
 type rawUploadRequest struct {
 	Provider  string          `json:"provider"`
 	ImageName string          `json:"image_name"`
 	Settings  json.RawMessage `json:"settings"`
+	Region    string          `json:"region,omitempty"`
 }
 
 func (u *uploadRequest) UnmarshalJSON(data []byte) error {
@@ -68,6 +86,8 @@ func (u *uploadRequest) UnmarshalJSON(data []byte) error {
 		settings = new(azureUploadSettings)
 	case "aws":
 		settings = new(awsUploadSettings)
+	case "vmware":
+		settings = new(vmwareUploadSettings)
 	default:
 		return errors.New("unexpected provider name")
 	}
@@ -79,6 +99,7 @@ func (u *uploadRequest) UnmarshalJSON(data []byte) error {
 	u.Provider = rawUploadRequest.Provider
 	u.ImageName = rawUploadRequest.ImageName
 	u.Settings = settings
+	u.Region = rawUploadRequest.Region
 
 	return err
 }
@@ -112,25 +133,36 @@ func targetsToUploadResponses(targets []*target.Target) []uploadResponse {
 				Container:        options.Container,
 			}
 			uploads = append(uploads, upload)
+		case *target.VMWareTargetOptions:
+			upload.ProviderName = "vmware"
+			upload.Settings = &vmwareUploadSettings{
+				Host:       options.Host,
+				Username:   options.Username,
+				Password:   options.Password,
+				Datacenter: options.Datacenter,
+				Datastore:  options.Datastore,
+			}
+			uploads = append(uploads, upload)
 		}
 	}
 
 	return uploads
 }
 
-func uploadRequestToTarget(u uploadRequest, imageType distro.ImageType) *target.Target {
+func uploadRequestToTarget(u uploadRequest, filename string) *target.Target {
 	var t target.Target
 
 	t.Uuid = uuid.New()
 	t.ImageName = u.ImageName
 	t.Status = common.IBWaiting
 	t.Created = time.Now()
+	t.Region = u.Region
 
 	switch options := u.Settings.(type) {
 	case *awsUploadSettings:
 		t.Name = "org.osbuild.aws"
 		t.Options = &target.AWSTargetOptions{
-			Filename:        imageType.Filename(),
+			Filename:        filename,
 			Region:          options.Region,
 			AccessKeyID:     options.AccessKeyID,
 			SecretAccessKey: options.SecretAccessKey,
@@ -140,12 +172,69 @@ func uploadRequestToTarget(u uploadRequest, imageType distro.ImageType) *target.
 	case *azureUploadSettings:
 		t.Name = "org.osbuild.azure"
 		t.Options = &target.AzureTargetOptions{
-			Filename:         imageType.Filename(),
+			Filename:         filename,
 			StorageAccount:   options.StorageAccount,
 			StorageAccessKey: options.StorageAccessKey,
 			Container:        options.Container,
 		}
+	case *vmwareUploadSettings:
+		t.Name = "org.osbuild.vmware"
+		t.Options = &target.VMWareTargetOptions{
+			Filename:   filename,
+			Host:       options.Host,
+			Username:   options.Username,
+			Password:   options.Password,
+			Datacenter: options.Datacenter,
+			Datastore:  options.Datastore,
+		}
 	}
 
 	return &t
 }
+
+// providersValidateHandler checks whether the settings of an upload would
+// work, without starting a compose. The request has the same format as the
+// "upload" field of compose requests. Problems are not reported as errors,
+// but as a list of diagnostics in a successful response.
+func (api *API) providersValidateHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	contentType := request.Header["Content-Type"]
+	if len(contentType) != 1 || contentType[0] != "application/json" {
+		errors := responseError{
+			ID:  "MissingPost",
+			Msg: "request must be json",
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	var u uploadRequest
+	err := json.NewDecoder(request.Body).Decode(&u)
+	if err != nil {
+		errors := responseError{
+			ID:  "BadUpload",
+			Msg: fmt.Sprintf("invalid upload settings: %v", err),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	type reply struct {
+		Valid       bool                `json:"valid"`
+		Diagnostics []upload.Diagnostic `json:"diagnostics"`
+	}
+
+	diagnostics := api.validateUpload(uploadRequestToTarget(u, ""))
+	if diagnostics == nil {
+		diagnostics = []upload.Diagnostic{}
+	}
+
+	err = json.NewEncoder(writer).Encode(reply{
+		Valid:       len(diagnostics) == 0,
+		Diagnostics: diagnostics,
+	})
+	common.PanicOnError(err)
+}
@@ -0,0 +1,66 @@
+package weldr
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+)
+
+// uploadsHealthHandler summarizes how uploads to each target went, to help
+// noticing broken credentials. Targets are identified by their provider and
+// a profile naming the account and destination. A target is healthy when its
+// latest upload succeeded.
+func (api *API) uploadsHealthHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	type targetHealth struct {
+		Provider        string    `json:"provider"`
+		Profile         string    `json:"profile"`
+		Healthy         bool      `json:"healthy"`
+		Uploads         int       `json:"uploads"`
+		Failures        int       `json:"failures"`
+		SuccessRate     float64   `json:"success_rate"`
+		AverageDuration float64   `json:"average_duration"`
+		LastSucceeded   time.Time `json:"last_succeeded"`
+		LastFailed      time.Time `json:"last_failed"`
+		LastError       string    `json:"last_error,omitempty"`
+	}
+
+	type reply struct {
+		Targets []targetHealth `json:"targets"`
+	}
+
+	targets := []targetHealth{}
+	for _, st := range api.store.GetUploadStats() {
+		targets = append(targets, targetHealth{
+			Provider:        strings.TrimPrefix(st.Target, "org.osbuild."),
+			Profile:         st.Profile,
+			Healthy:         !st.LastSucceeded.Before(st.LastFailed),
+			Uploads:         st.Uploads,
+			Failures:        st.Failures,
+			SuccessRate:     st.SuccessRate(),
+			AverageDuration: st.AverageDuration().Seconds(),
+			LastSucceeded:   st.LastSucceeded,
+			LastFailed:      st.LastFailed,
+			LastError:       st.LastError,
+		})
+	}
+
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].Provider == targets[j].Provider {
+			return targets[i].Profile < targets[j].Profile
+		}
+		return targets[i].Provider < targets[j].Provider
+	})
+
+	err := json.NewEncoder(writer).Encode(reply{targets})
+	common.PanicOnError(err)
+}
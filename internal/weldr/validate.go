@@ -0,0 +1,167 @@
+package weldr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/distro"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+)
+
+// blueprintsValidateHandler checks a blueprint without saving it. It accepts
+// the same formats as blueprintsNewHandler. Problems with the blueprint are
+// not reported as errors, but as a list of errors and warnings in a
+// successful response. Two query parameters enable more expensive checks:
+//
+//	compose_type=<type>  check customizations against the image type
+//	depsolve=true        check that all packages can be resolved
+func (api *API) blueprintsValidateHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	type reply struct {
+		Valid    bool                        `json:"valid"`
+		Errors   []blueprint.ValidationError `json:"errors"`
+		Warnings []compose.Warning           `json:"warnings"`
+	}
+
+	q, err := url.ParseQuery(request.URL.RawQuery)
+	if err != nil {
+		errors := responseError{
+			ID:  "InvalidChars",
+			Msg: fmt.Sprintf("invalid query string: %v", err),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	var imageType distro.ImageType
+	if composeType := q.Get("compose_type"); composeType != "" {
+		imageType, err = api.arch.GetImageType(composeType)
+		if err != nil {
+			errors := responseError{
+				ID:  "UnknownComposeType",
+				Msg: fmt.Sprintf("Unknown compose type for architecture: %s", composeType),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+	}
+
+	contentType := request.Header.Get("Content-Type")
+	if contentType != "application/json" && contentType != "text/x-toml" {
+		errors := responseError{
+			ID:        "BlueprintsError",
+			Msg:       "blueprint must be in json or toml format",
+			ErrorCode: common.ErrorInvalidRequest,
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		errors := responseError{
+			ID:        "BlueprintsError",
+			Msg:       fmt.Sprintf("cannot read blueprint: %v", err),
+			ErrorCode: common.ErrorInvalidRequest,
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	result := reply{
+		Errors:   []blueprint.ValidationError{},
+		Warnings: []compose.Warning{},
+	}
+
+	bp, unknownFields, err := decodeBlueprint(contentType, body)
+	if err != nil {
+		result.Errors = append(result.Errors, blueprint.ValidationError{Message: err.Error()})
+		err = json.NewEncoder(writer).Encode(result)
+		common.PanicOnError(err)
+		return
+	}
+
+	for _, field := range unknownFields {
+		result.Warnings = append(result.Warnings, compose.Warning{
+			ID:  "UnknownField",
+			Msg: fmt.Sprintf("unknown field %s is ignored", field),
+		})
+	}
+
+	result.Errors = append(result.Errors, bp.Validate()...)
+
+	// Distributions and image types reject some customizations when
+	// generating manifests. Generating one without any packages is cheap.
+	if imageType != nil && len(result.Errors) == 0 {
+		_, err = imageType.Manifest(bp.Customizations, api.allRepositories(), nil, nil, distro.ImageOptions{})
+		if err != nil {
+			result.Errors = append(result.Errors, blueprint.ValidationError{
+				Field:   "customizations",
+				Message: fmt.Sprintf("not supported by %s images: %v", imageType.Name(), err),
+			})
+		}
+	}
+
+	var packages []rpmmd.PackageSpec
+	if q.Get("depsolve") == "true" && len(result.Errors) == 0 {
+		packages, _, _, err = api.depsolveBlueprint(bp, imageType)
+		if err != nil {
+			result.Errors = append(result.Errors, blueprint.ValidationError{
+				Field:   "packages",
+				Message: err.Error(),
+			})
+		}
+	}
+
+	result.Warnings = append(result.Warnings, composeWarnings(bp, packages, nil)...)
+	result.Valid = len(result.Errors) == 0
+
+	err = json.NewEncoder(writer).Encode(result)
+	common.PanicOnError(err)
+}
+
+// decodeBlueprint parses a blueprint in the format given by `contentType`
+// and returns it together with the names of fields it did not recognize.
+func decodeBlueprint(contentType string, data []byte) (*blueprint.Blueprint, []string, error) {
+	var bp blueprint.Blueprint
+	var unknownFields []string
+
+	if contentType == "text/x-toml" {
+		md, err := toml.Decode(string(data), &bp)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid TOML: %v", err)
+		}
+		for _, key := range md.Undecoded() {
+			unknownFields = append(unknownFields, key.String())
+		}
+	} else {
+		err := json.Unmarshal(data, &bp)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+
+		// encoding/json stops at the first unknown field
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&blueprint.Blueprint{}); err != nil {
+			field := strings.TrimPrefix(err.Error(), "json: unknown field ")
+			unknownFields = append(unknownFields, strings.Trim(field, `"`))
+		}
+	}
+
+	return &bp, unknownFields, nil
+}
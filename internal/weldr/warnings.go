@@ -0,0 +1,64 @@
+package weldr
+
+import (
+	"fmt"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+	"github.com/osbuild/osbuild-composer/internal/target"
+)
+
+// Package sets larger than this take unusually long to download and
+// install, and often indicate that a blueprint pulls in more than intended.
+const maxRecommendedPackages = 2000
+
+// AWS regions which are disabled by default. Uploads to them fail unless the
+// account opted in, and tend to be slow even when it did.
+var awsOptInRegions = map[string]bool{
+	"af-south-1":     true,
+	"ap-east-1":      true,
+	"ap-southeast-3": true,
+	"eu-south-1":     true,
+	"me-south-1":     true,
+}
+
+func containsWarning(warnings []compose.Warning, warning compose.Warning) bool {
+	for _, w := range warnings {
+		if w == warning {
+			return true
+		}
+	}
+	return false
+}
+
+// composeWarnings returns a list of problems with a compose request which
+// are not severe enough to reject it.
+func composeWarnings(bp *blueprint.Blueprint, packages []rpmmd.PackageSpec, targets []*target.Target) []compose.Warning {
+	var warnings []compose.Warning
+
+	if bp.Customizations != nil && len(bp.Customizations.SSHKey) > 0 {
+		warnings = append(warnings, compose.Warning{
+			ID:  "DeprecatedCustomization",
+			Msg: "the sshkey customization is deprecated, use the key field of the user customization instead",
+		})
+	}
+
+	if len(packages) > maxRecommendedPackages {
+		warnings = append(warnings, compose.Warning{
+			ID:  "OversizedPackageSet",
+			Msg: fmt.Sprintf("blueprint %s depends on %d packages, more than the recommended maximum of %d", bp.Name, len(packages), maxRecommendedPackages),
+		})
+	}
+
+	for _, t := range targets {
+		if options, ok := t.Options.(*target.AWSTargetOptions); ok && awsOptInRegions[options.Region] {
+			warnings = append(warnings, compose.Warning{
+				ID:  "SlowTargetRegion",
+				Msg: fmt.Sprintf("AWS region %s is an opt-in region, uploads to it may be slow or fail if it is not enabled", options.Region),
+			})
+		}
+	}
+
+	return warnings
+}
@@ -0,0 +1,186 @@
+package weldr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/notify"
+	"github.com/osbuild/osbuild-composer/internal/store"
+)
+
+// webhookInfo is a webhook as it is listed. Secrets are never returned.
+type webhookInfo struct {
+	ID        uuid.UUID `json:"id"`
+	URL       string    `json:"url"`
+	Signed    bool      `json:"signed"`
+	Events    []string  `json:"events"`
+	ComposeID string    `json:"compose_id,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+func (api *API) webhooksListHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	type reply struct {
+		Webhooks []webhookInfo `json:"webhooks"`
+	}
+
+	hooks := []webhookInfo{}
+	for _, hook := range api.store.GetWebhooks() {
+		info := webhookInfo{
+			ID:      hook.ID,
+			URL:     hook.URL,
+			Signed:  hook.Secret != "",
+			Events:  hook.Events,
+			Created: hook.Created,
+		}
+		if len(info.Events) == 0 {
+			info.Events = notify.WebhookEvents
+		}
+		if hook.ComposeID != uuid.Nil {
+			info.ComposeID = hook.ComposeID.String()
+		}
+		hooks = append(hooks, info)
+	}
+
+	err := json.NewEncoder(writer).Encode(reply{hooks})
+	common.PanicOnError(err)
+}
+
+// webhooksNewHandler registers a webhook, which is called for state
+// transitions of all composes or, with "compose_id", of a single one:
+//
+//	{
+//	  "url": "https://ci.example.com/hooks/composer",
+//	  "secret": "...",
+//	  "events": ["compose.finished", "compose.failed"],
+//	  "compose_id": "..."
+//	}
+//
+// Without "events", the webhook is called for all of them. With a "secret",
+// callbacks are signed with it. The reply contains the webhook's id.
+func (api *API) webhooksNewHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	var body struct {
+		URL       string   `json:"url"`
+		Secret    string   `json:"secret"`
+		Events    []string `json:"events"`
+		ComposeID string   `json:"compose_id"`
+	}
+	err := json.NewDecoder(request.Body).Decode(&body)
+	if err == nil {
+		err = validateWebhookURL(body.URL)
+	}
+	if err == nil {
+		for _, event := range body.Events {
+			if !notify.IsWebhookEvent(event) {
+				err = fmt.Errorf("unknown event '%s'", event)
+				break
+			}
+		}
+	}
+	if err != nil {
+		errors := responseError{
+			ID:  "BadWebhook",
+			Msg: "Problem parsing POST body: " + err.Error(),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	hook := store.Webhook{
+		ID:      uuid.New(),
+		URL:     body.URL,
+		Secret:  body.Secret,
+		Events:  body.Events,
+		Created: time.Now(),
+	}
+
+	if body.ComposeID != "" {
+		hook.ComposeID, err = uuid.Parse(body.ComposeID)
+		if err != nil {
+			errors := responseError{
+				ID:  "UnknownUUID",
+				Msg: fmt.Sprintf("%s is not a valid build uuid", body.ComposeID),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+	}
+
+	err = api.store.AddWebhook(hook)
+	if err != nil {
+		if _, ok := err.(*store.NotFoundError); ok {
+			errors := responseError{
+				ID:  "UnknownUUID",
+				Msg: fmt.Sprintf("Compose %s doesn't exist", body.ComposeID),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+		errors := responseError{
+			ID:  "BadWebhook",
+			Msg: err.Error(),
+		}
+		statusResponseError(writer, http.StatusInternalServerError, errors)
+		return
+	}
+
+	type reply struct {
+		Status bool      `json:"status"`
+		ID     uuid.UUID `json:"id"`
+	}
+
+	err = json.NewEncoder(writer).Encode(reply{true, hook.ID})
+	common.PanicOnError(err)
+}
+
+func (api *API) webhooksDeleteHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	idString := params.ByName("id")
+	id, err := uuid.Parse(idString)
+	if err == nil {
+		err = api.store.DeleteWebhook(id)
+	}
+	if err != nil {
+		errors := responseError{
+			ID:  "UnknownWebhook",
+			Msg: fmt.Sprintf("%s is not a valid webhook", idString),
+		}
+		statusResponseError(writer, http.StatusNotFound, errors)
+		return
+	}
+
+	statusResponseOK(writer)
+}
+
+func validateWebhookURL(rawURL string) error {
+	if rawURL == "" {
+		return fmt.Errorf("'url' is required")
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("'%s' is not an http or https URL", rawURL)
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package weldr
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+)
+
+// SetWorkspaceTTL sets how long workspace copies of blueprints are kept
+// after they were last changed. It only affects what is reported by the
+// API; copies are removed by store.SweepWorkspace. 0 means forever.
+func (api *API) SetWorkspaceTTL(ttl time.Duration) {
+	api.workspaceTTL = ttl
+}
+
+// blueprintsWorkspaceListHandler lists all workspace copies of blueprints,
+// with the time they were last changed and when they expire. Only privileged
+// clients may list them, because the list includes blueprints of all users.
+func (api *API) blueprintsWorkspaceListHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	if !isPrivileged(request) {
+		errors := responseError{
+			ID:  "PermissionDenied",
+			Msg: "only privileged clients may list the workspace",
+		}
+		statusResponseError(writer, http.StatusForbidden, errors)
+		return
+	}
+
+	type workspaceEntry struct {
+		Name    string     `json:"name"`
+		Updated *time.Time `json:"updated,omitempty"`
+		Expires *time.Time `json:"expires,omitempty"`
+	}
+
+	type reply struct {
+		Workspace []workspaceEntry `json:"workspace"`
+	}
+
+	entries := []workspaceEntry{}
+	for name, info := range api.store.GetWorkspaceInfo() {
+		entry := workspaceEntry{Name: name}
+		if !info.Updated.IsZero() {
+			updated := info.Updated
+			entry.Updated = &updated
+			if api.workspaceTTL > 0 {
+				expires := updated.Add(api.workspaceTTL)
+				entry.Expires = &expires
+			}
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	err := json.NewEncoder(writer).Encode(reply{entries})
+	common.PanicOnError(err)
+}
@@ -0,0 +1,59 @@
+package worker
+
+import (
+	"time"
+)
+
+// Workers register for the architectures they advertise when they ask for
+// jobs. Composes for architectures which no worker is registered for would
+// wait forever, so composer rejects them instead (see HasWorkers()).
+
+// Workers don't ask for jobs while they run one, which can take a while. They
+// stay registered for this long after they last asked for a job.
+const workerRegistration = time.Hour
+
+type archWorkers struct {
+	waiting  int       // workers of the architecture that currently wait for a job
+	lastSeen time.Time // when a worker of the architecture last asked for a job
+}
+
+// registerWorker records that a worker for `arches` waits for a job. It
+// returns a function to call once it stops waiting.
+func (s *Server) registerWorker(arches []string) func() {
+	s.archesMutex.Lock()
+	defer s.archesMutex.Unlock()
+
+	now := time.Now()
+	var registered []*archWorkers
+	for _, arch := range arches {
+		a := s.arches[arch]
+		if a == nil {
+			a = &archWorkers{}
+			s.arches[arch] = a
+		}
+		a.waiting += 1
+		a.lastSeen = now
+		registered = append(registered, a)
+	}
+
+	return func() {
+		s.archesMutex.Lock()
+		defer s.archesMutex.Unlock()
+
+		now := time.Now()
+		for _, a := range registered {
+			a.waiting -= 1
+			a.lastSeen = now
+		}
+	}
+}
+
+// HasWorkers returns true if a worker for `arch` is registered, i.e., if one
+// waits for a job or asked for one recently.
+func (s *Server) HasWorkers(arch string) bool {
+	s.archesMutex.Lock()
+	defer s.archesMutex.Unlock()
+
+	a, exists := s.arches[arch]
+	return exists && (a.waiting > 0 || time.Since(a.lastSeen) < workerRegistration)
+}
@@ -0,0 +1,64 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/logging"
+	"github.com/osbuild/osbuild-composer/internal/osbuild"
+)
+
+type WriteCheckpointFunc func(composeID uuid.UUID, imageBuildID int, name string, reader io.Reader) error
+
+// SetCheckpointWriter sets the function which stores checkpoints that
+// workers export in addition to images (see osbuild.IsCheckpoint()).
+// Checkpoints are discarded when it isn't set.
+func (s *Server) SetCheckpointWriter(checkpointWriter WriteCheckpointFunc) {
+	s.checkpointWriter = checkpointWriter
+}
+
+func (s *Server) addJobCheckpointHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	id, imageBuildId, ok := parseImageParams(writer, params)
+	if !ok {
+		return
+	}
+
+	name := params.ByName("name")
+	if !osbuild.IsCheckpoint(name) {
+		jsonErrorf(writer, common.ErrorInvalidRequest, "unknown checkpoint: %s", name)
+		return
+	}
+
+	var err error
+	if s.checkpointWriter == nil {
+		_, err = io.Copy(ioutil.Discard, request.Body)
+	} else {
+		err = s.checkpointWriter(id, imageBuildId, name, request.Body)
+	}
+
+	logger := logging.FromContext(request.Context())
+	if err != nil {
+		logger.Error("uploading checkpoint failed", "compose_id", id, "image_build_id", imageBuildId, "checkpoint", name, "error", err)
+		jsonErrorf(writer, common.ErrorInternal, "%v", err)
+		return
+	}
+
+	logger.Info("checkpoint uploaded", "compose_id", id, "image_build_id", imageBuildId, "checkpoint", name)
+}
+
+// UploadCheckpoint uploads checkpoint `name` of an image build.
+func (c *Client) UploadCheckpoint(composeId uuid.UUID, imageBuildId int, name string, reader io.Reader) error {
+	err := c.require(CapabilityCheckpoints, "take checkpoints")
+	if err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("/job-queue/v1/jobs/%s/builds/%d/checkpoints/%s", composeId, imageBuildId, name)
+	return c.uploadImageAtOnce(path, reader, "")
+}
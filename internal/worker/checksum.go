@@ -0,0 +1,112 @@
+package worker
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// Workers send the SHA-256 digest of the images they upload, so that images
+// which were corrupted on the way are rejected instead of being handed to
+// users. Uploads carry it in a `Digest: sha-256=<base64>` header (RFC 3230),
+// offers for pulling in the request. Within composer, checksums are written
+// as "sha256:<hex>".
+
+const checksumPrefix = "sha256:"
+
+// imageChecksum returns the checksum of the image read from `seeker`, which
+// is rewound afterwards.
+func imageChecksum(seeker io.ReadSeeker) (string, error) {
+	_, err := seeker.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	_, err = io.Copy(h, seeker)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = seeker.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	return checksumPrefix + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// digestHeader returns the value of the Digest header for `checksum`.
+func digestHeader(checksum string) string {
+	sum, err := hex.DecodeString(strings.TrimPrefix(checksum, checksumPrefix))
+	if err != nil {
+		panic(err)
+	}
+	return "sha-256=" + base64.StdEncoding.EncodeToString(sum)
+}
+
+// parseDigestHeader returns the checksum in a Digest header, or "" if it
+// contains no SHA-256 digest.
+func parseDigestHeader(header string) (string, error) {
+	for _, digest := range strings.Split(header, ",") {
+		digest = strings.TrimSpace(digest)
+		eq := strings.IndexByte(digest, '=')
+		if eq < 0 || !strings.EqualFold(digest[:eq], "sha-256") {
+			continue
+		}
+
+		sum, err := base64.StdEncoding.DecodeString(digest[eq+1:])
+		if err != nil || len(sum) != sha256.Size {
+			return "", fmt.Errorf("malformed sha-256 digest: %s", digest[eq+1:])
+		}
+		return checksumPrefix + hex.EncodeToString(sum), nil
+	}
+
+	return "", nil
+}
+
+// validChecksum returns whether `checksum` is a SHA-256 checksum.
+func validChecksum(checksum string) bool {
+	sum, err := hex.DecodeString(strings.TrimPrefix(checksum, checksumPrefix))
+	return strings.HasPrefix(checksum, checksumPrefix) && err == nil && len(sum) == sha256.Size
+}
+
+// checksumMismatchError is returned when an uploaded image doesn't match the
+// checksum that was sent along with it.
+type checksumMismatchError struct {
+	expected string
+	actual   string
+}
+
+func (e *checksumMismatchError) Error() string {
+	return fmt.Sprintf("image has checksum %s, but %s was expected", e.actual, e.expected)
+}
+
+// checksumReader passes through an image and fails with a
+// checksumMismatchError instead of io.EOF at its end, if the image doesn't
+// match `expected`.
+type checksumReader struct {
+	reader   io.Reader
+	hash     hash.Hash
+	expected string
+}
+
+func newChecksumReader(reader io.Reader, expected string) *checksumReader {
+	return &checksumReader{reader, sha256.New(), expected}
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF {
+		actual := checksumPrefix + hex.EncodeToString(r.hash.Sum(nil))
+		if actual != r.expected {
+			return n, &checksumMismatchError{r.expected, actual}
+		}
+	}
+	return n, err
+}
@@ -8,26 +8,62 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
+	"golang.org/x/net/websocket"
 
 	"github.com/osbuild/osbuild-composer/internal/common"
 	"github.com/osbuild/osbuild-composer/internal/osbuild"
 	"github.com/osbuild/osbuild-composer/internal/target"
 )
 
+// A Client speaks composer's worker API. Workers take jobs with it, report
+// how they are doing, and upload the images they built. Requests are retried
+// while composer cannot be reached (see retry.go), can be signed (see
+// signature.go), and only use what composer supports (see info.go).
 type Client struct {
 	client   *http.Client
 	scheme   string
 	hostname string
+	region   string
+	distros  []string
+
+	// Whether the client takes conversion jobs, see convert.go
+	conversions bool
+
+	// Opens a new connection to the server, for websockets
+	dial func() (net.Conn, error)
+
+	backoff    Backoff
+	signingKey []byte
+
+	// What composer supports, see info.go
+	infoMutex sync.Mutex
+	info      *ServerInfo
 }
 
 type Job struct {
 	Id       uuid.UUID
+	Distro   string
+	Arch     string
 	Manifest *osbuild.Manifest
 	Targets  []*target.Target
+
+	// Set for conversion jobs, which have no manifest
+	Conversion *Conversion
+
+	// The results of the jobs this job depends on
+	Inputs []JobInput
+
+	// When building the image started and finished, according to the
+	// worker's clock. Workers set them before calling UpdateJob().
+	Started  time.Time
+	Finished time.Time
 }
 
 func NewClient(address string, conf *tls.Config) *Client {
@@ -45,7 +81,20 @@ func NewClient(address string, conf *tls.Config) *Client {
 		scheme = "http"
 	}
 
-	return &Client{client, scheme, address}
+	dial := func() (net.Conn, error) {
+		if conf != nil {
+			return tls.Dial("tcp", address, conf)
+		}
+		return net.Dial("tcp", address)
+	}
+
+	return &Client{
+		client:   client,
+		scheme:   scheme,
+		hostname: address,
+		dial:     dial,
+		backoff:  DefaultBackoff,
+	}
 }
 
 func NewClientUnix(path string) *Client {
@@ -57,55 +106,161 @@ func NewClientUnix(path string) *Client {
 		},
 	}
 
-	return &Client{client, "http", "localhost"}
+	dial := func() (net.Conn, error) {
+		return net.Dial("unix", path)
+	}
+
+	return &Client{
+		client:   client,
+		scheme:   "http",
+		hostname: "localhost",
+		dial:     dial,
+		backoff:  DefaultBackoff,
+	}
+}
+
+// SetRegion sets the region the worker runs in. Composer prefers to hand
+// jobs that upload to a region to workers in the same region.
+func (c *Client) SetRegion(region string) {
+	c.region = region
 }
 
-func (c *Client) AddJob() (*Job, error) {
+// SetDistros sets the distros the worker can build images of. Composer only
+// hands jobs of these distros to the worker. The worker takes jobs of all
+// distros if `distros` is empty, which is the default.
+func (c *Client) SetDistros(distros []string) {
+	c.distros = distros
+}
+
+// AddJob waits for a job for any of `arches`, or a conversion if they are
+// enabled, and returns it.
+func (c *Client) AddJob(arches []string) (*Job, error) {
+	_, err := c.Info()
+	if err != nil {
+		return nil, err
+	}
+
 	var b bytes.Buffer
-	err := json.NewEncoder(&b).Encode(addJobRequest{})
+	err = json.NewEncoder(&b).Encode(addJobRequest{Arches: arches, Region: c.region, Distros: c.distros, Conversions: c.conversions, JobVersion: JobVersion})
 	if err != nil {
 		panic(err)
 	}
-	response, err := c.client.Post(c.createURL("/job-queue/v1/jobs"), "application/json", &b)
-	if err != nil {
-		return nil, err
+
+	var response *http.Response
+	for {
+		response, err = c.request("POST", "/job-queue/v1/jobs", b.Bytes())
+		if err != nil {
+			return nil, err
+		}
+
+		// Composer asks to wait again when the jobs this worker may
+		// take could have changed
+		if response.StatusCode != http.StatusNoContent {
+			break
+		}
+		response.Body.Close()
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusCreated {
-		var er errorResponse
+		var er common.APIError
 		_ = json.NewDecoder(response.Body).Decode(&er)
 		return nil, fmt.Errorf("couldn't create job, got %d: %s", response.StatusCode, er.Message)
 	}
 
-	var jr addJobResponse
-	err = json.NewDecoder(response.Body).Decode(&jr)
+	body, err := ioutil.ReadAll(response.Body)
 	if err != nil {
 		return nil, err
 	}
 
+	return parseJob(body)
+}
+
+// parseJob parses a job that composer sent, encoded as addJobResponse.
+func parseJob(body []byte) (*Job, error) {
+	// Read the id first, so that jobs which cannot be read can be failed
+	var header struct {
+		Id      uuid.UUID `json:"id"`
+		Version int       `json:"version"`
+	}
+	err := json.Unmarshal(body, &header)
+	if err != nil {
+		return nil, err
+	}
+	if header.Version > JobVersion {
+		return nil, &JobError{&Job{Id: header.Id}, &UnsupportedVersionError{header.Version}}
+	}
+
+	var jr addJobResponse
+	err = json.Unmarshal(body, &jr)
+	if err != nil {
+		return nil, &JobError{&Job{Id: header.Id}, err}
+	}
+
 	return &Job{
-		jr.Id,
-		jr.Manifest,
-		jr.Targets,
+		Id:       jr.Id,
+		Distro:   jr.Distro,
+		Arch:     jr.Arch,
+		Manifest: jr.Manifest,
+		Targets:  jr.Targets,
+
+		Conversion: jr.Conversion,
+		Inputs:     jr.Inputs,
 	}, nil
 }
 
-func (c *Client) UpdateJob(job *Job, status common.ImageBuildState, result *common.ComposeResult) error {
-	var b bytes.Buffer
-	err := json.NewEncoder(&b).Encode(&updateJobRequest{status, result})
+func (c *Client) UpdateJob(job *Job, status common.ImageBuildState, result *common.ComposeResult, targetResults []TargetResult) error {
+	return c.updateJob(job, &updateJobRequest{
+		Status:        status,
+		Result:        result,
+		TargetResults: targetResults,
+		Time:          time.Now(),
+		BuildStarted:  job.Started,
+		BuildFinished: job.Finished,
+	})
+}
+
+// UpdateJobProgress tells composer how far the worker is with the running
+// job `job`.
+func (c *Client) UpdateJobProgress(job *Job, progress *JobProgress) error {
+	err := c.require(CapabilityProgress, "take the progress of jobs")
 	if err != nil {
-		panic(err)
+		return err
 	}
-	urlPath := fmt.Sprintf("/job-queue/v1/jobs/%s", job.Id)
-	url := c.createURL(urlPath)
-	req, err := http.NewRequest("PATCH", url, &b)
+
+	return c.updateJob(job, &updateJobRequest{
+		Status:   common.IBRunning,
+		Time:     time.Now(),
+		Progress: progress,
+	})
+}
+
+// FailJob fails `job`, which the worker cannot run, because of `message`.
+func (c *Client) FailJob(job *Job, message string) error {
+	return c.updateJob(job, &updateJobRequest{
+		Status: common.IBFailed,
+		Result: &common.ComposeResult{},
+		Time:   time.Now(),
+		Error:  message,
+	})
+}
+
+// updateJob sends `request` for `job`, in the version that composer
+// supports.
+func (c *Client) updateJob(job *Job, request *updateJobRequest) error {
+	version, err := c.JobVersion()
 	if err != nil {
 		return err
 	}
+	request.Version = version
 
-	req.Header.Set("Content-Type", "application/json")
-	response, err := c.client.Do(req)
+	var b bytes.Buffer
+	err = json.NewEncoder(&b).Encode(request)
+	if err != nil {
+		panic(err)
+	}
+
+	response, err := c.request("PATCH", fmt.Sprintf("/job-queue/v1/jobs/%s", job.Id), b.Bytes())
 	if err != nil {
 		return err
 	}
@@ -118,12 +273,296 @@ func (c *Client) UpdateJob(job *Job, status common.ImageBuildState, result *comm
 	return nil
 }
 
+// Heartbeat tells composer that `job` is still running. It returns how far
+// the worker's clock is behind composer's.
+func (c *Client) Heartbeat(job *Job) (time.Duration, error) {
+	err := c.require(CapabilityHeartbeats, "take heartbeats")
+	if err != nil {
+		return 0, err
+	}
+
+	var b bytes.Buffer
+	err = json.NewEncoder(&b).Encode(heartbeatRequest{Time: time.Now()})
+	if err != nil {
+		panic(err)
+	}
+	response, err := c.request("POST", fmt.Sprintf("/job-queue/v1/jobs/%s/heartbeat", job.Id), b.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		var er common.APIError
+		_ = json.NewDecoder(response.Body).Decode(&er)
+		return 0, fmt.Errorf("couldn't send heartbeat, got %d: %s", response.StatusCode, er.Message)
+	}
+
+	var hr heartbeatResponse
+	err = json.NewDecoder(response.Body).Decode(&hr)
+	if err != nil {
+		return 0, err
+	}
+
+	return hr.Offset, nil
+}
+
+const (
+	// Size of the chunks in which images are uploaded
+	uploadChunkSize = 64 * 1024 * 1024
+
+	// How often a chunk is retried before an upload is aborted
+	uploadAttempts = 5
+)
+
+// UploadImage uploads the image in `reader`. Images from an io.ReadSeeker
+// (like *os.File) are uploaded in chunks, resuming where the server left off
+// when a chunk fails. Other readers, and all images sent to composers which
+// cannot take chunks, are uploaded in one request.
 func (c *Client) UploadImage(composeId uuid.UUID, imageBuildId int, reader io.Reader) error {
+	path := fmt.Sprintf("/job-queue/v1/jobs/%s/builds/%d/image", composeId, imageBuildId)
+
+	info, err := c.Info()
+	if err != nil {
+		return err
+	}
+
+	seeker, ok := reader.(io.ReadSeeker)
+	if !ok || !info.Supports(CapabilityChunkedUploads) {
+		return c.uploadImageAtOnce(path, reader, "")
+	}
+
+	checksum, err := imageChecksum(seeker)
+	if err != nil {
+		return err
+	}
+
+	size, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	// an empty image doesn't fit into a content range
+	if size == 0 {
+		return c.uploadImageAtOnce(path, seeker, checksum)
+	}
+
+	offset, err := c.uploadOffset(path)
+	if err != nil {
+		return err
+	}
+
+	attempts := 0
+	for {
+		if offset > size {
+			return fmt.Errorf("server received %d bytes of an image of %d bytes", offset, size)
+		}
+
+		complete, next, err := c.uploadImageChunk(path, seeker, offset, size, checksum)
+		if err == nil {
+			if complete {
+				return nil
+			}
+			offset = next
+			attempts = 0
+			continue
+		}
+
+		// The server rejected the image itself, retrying won't help
+		if _, ok := err.(*common.APIError); ok {
+			return fmt.Errorf("error uploading image: %v", err)
+		}
+
+		attempts++
+		if attempts >= uploadAttempts {
+			return fmt.Errorf("error uploading image: %v", err)
+		}
+		time.Sleep(time.Duration(attempts) * time.Second)
+
+		// Ask the server how much it received, because a failed
+		// chunk might have been partially written.
+		next, err = c.uploadOffset(path)
+		if err == nil {
+			offset = next
+		}
+	}
+}
+
+// uploadImageAtOnce uploads the image read from `reader` to `path`, along
+// with its checksum, unless it is "". It isn't retried, because `reader`
+// cannot be read again.
+func (c *Client) uploadImageAtOnce(path string, reader io.Reader, checksum string) error {
+	req, err := http.NewRequest("POST", c.createURL(path), reader)
+	if err != nil {
+		return err
+	}
 	// content type doesn't really matter
-	url := c.createURL(fmt.Sprintf("/job-queue/v1/jobs/%s/builds/%d/image", composeId, imageBuildId))
-	_, err := c.client.Post(url, "application/octet-stream", reader)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if checksum != "" {
+		req.Header.Set("Digest", digestHeader(checksum))
+	}
+	c.sign(req, nil)
+
+	response, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		var er common.APIError
+		_ = json.NewDecoder(response.Body).Decode(&er)
+		return fmt.Errorf("couldn't upload image, got %d: %s", response.StatusCode, er.Message)
+	}
+
+	return nil
+}
+
+func (c *Client) uploadOffset(path string) (int64, error) {
+	response, err := c.request("GET", path, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		var er common.APIError
+		_ = json.NewDecoder(response.Body).Decode(&er)
+		return 0, fmt.Errorf("couldn't get upload status, got %d: %s", response.StatusCode, er.Message)
+	}
+
+	var status uploadStatusResponse
+	err = json.NewDecoder(response.Body).Decode(&status)
+	if err != nil {
+		return 0, err
+	}
+
+	return status.Offset, nil
+}
+
+// uploadImageChunk uploads the chunk of `seeker` starting at `offset`. It
+// returns whether the upload is complete and the offset of the next chunk.
+// The checksum of the whole image is sent with every chunk.
+func (c *Client) uploadImageChunk(path string, seeker io.ReadSeeker, offset, size int64, checksum string) (bool, int64, error) {
+	length := size - offset
+	if length > uploadChunkSize {
+		length = uploadChunkSize
+	}
+
+	_, err := seeker.Seek(offset, io.SeekStart)
+	if err != nil {
+		return false, 0, err
+	}
+
+	req, err := http.NewRequest("PUT", c.createURL(path), io.LimitReader(seeker, length))
+	if err != nil {
+		return false, 0, err
+	}
+	req.ContentLength = length
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size))
+	req.Header.Set("Digest", digestHeader(checksum))
+	c.sign(req, nil)
+
+	response, err := c.client.Do(req)
+	if err != nil {
+		return false, 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		var er common.APIError
+		_ = json.NewDecoder(response.Body).Decode(&er)
+		if er.Code == common.ErrorImageTooLarge || er.Code == common.ErrorImageFormatMismatch || er.Code == common.ErrorImageChecksumMismatch {
+			return false, 0, &er
+		}
+		return false, 0, fmt.Errorf("couldn't upload chunk, got %d: %s", response.StatusCode, er.Message)
+	}
+
+	var status uploadStatusResponse
+	err = json.NewDecoder(response.Body).Decode(&status)
+	if err != nil {
+		return false, 0, err
+	}
+
+	return status.Complete, status.Offset, nil
+}
+
+// StreamJobLog opens a stream to which the log of the running job `job` can
+// be written. Clients of the Weldr API can follow the log while the job is
+// running. Writing to the stream never fails, but the log is lost when the
+// connection breaks.
+func (c *Client) StreamJobLog(job *Job) (io.WriteCloser, error) {
+	err := c.require(CapabilityLogStream, "take the logs of jobs")
+	if err != nil {
+		return nil, err
+	}
+
+	ws, err := c.openWebsocket(fmt.Sprintf("/job-queue/v1/jobs/%s/log", job.Id))
+	if err != nil {
+		return nil, fmt.Errorf("cannot open log stream: %v", err)
+	}
+
+	return &jobLogWriter{ws: ws}, nil
+}
+
+// openWebsocket opens a websocket connection to `path` on the server.
+func (c *Client) openWebsocket(path string) (*websocket.Conn, error) {
+	scheme := "ws"
+	if c.scheme == "https" {
+		scheme = "wss"
+	}
+
+	config, err := websocket.NewConfig(fmt.Sprintf("%s://%s%s", scheme, c.hostname, path), c.createURL("/"))
+	if err != nil {
+		return nil, err
+	}
+
+	var ws *websocket.Conn
+	err = c.retry(true, func() error {
+		conn, err := c.dial()
+		if err != nil {
+			return err
+		}
+
+		c.signHeader(config.Header, "GET", config.Location.RequestURI(), emptyPayload)
+		ws, err = websocket.NewClient(config, conn)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		return nil
+	})
+
+	return ws, err
+}
+
+// request sends a request with `method` to `path`, with `body` as JSON
+// unless it is nil. It is retried while composer cannot be reached.
+func (c *Client) request(method, path string, body []byte) (*http.Response, error) {
+	var response *http.Response
+	err := c.retry(idempotentMethod(method), func() error {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequest(method, c.createURL(path), reader)
+		if err != nil {
+			return err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		c.sign(req, body)
+
+		response, err = c.client.Do(req)
+		if err != nil {
+			return err
+		}
+		return checkAvailable(response)
+	})
 
-	return err
+	return response, err
 }
 
 func (c *Client) createURL(path string) string {
@@ -0,0 +1,142 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/logging"
+)
+
+// Workers and composer don't necessarily agree on the time. Workers send
+// their current time with heartbeats and job updates, which composer uses to
+// estimate how far a worker's clock is off. Timestamps that workers report
+// are shifted by that offset before they are stored, so that all stored
+// timestamps are in composer's time.
+
+// Offsets larger than this are logged as warnings, because they are most
+// likely caused by a misconfigured clock and not by network latency.
+const ClockSkewThreshold = 30 * time.Second
+
+// clockSkew returns the offset that was last reported with a heartbeat for
+// job `id`, or 0 if there was none.
+func (s *Server) clockSkew(id uuid.UUID) time.Duration {
+	s.skewsMutex.Lock()
+	defer s.skewsMutex.Unlock()
+
+	return s.skews[id]
+}
+
+func (s *Server) forgetClockSkew(id uuid.UUID) {
+	s.skewsMutex.Lock()
+	defer s.skewsMutex.Unlock()
+
+	delete(s.skews, id)
+}
+
+// checkClockSkew returns the offset between composer's clock and the clock
+// of the worker running job `id`, which sent `workerTime` just now. The
+// offset is added to the worker's timestamps to convert them to composer's
+// time. It includes the latency of the request, which is negligible for
+// detecting misconfigured clocks.
+func checkClockSkew(logger *logging.Logger, id uuid.UUID, workerTime time.Time) time.Duration {
+	offset := time.Since(workerTime)
+	if offset > ClockSkewThreshold || offset < -ClockSkewThreshold {
+		logger.Warning("worker clock is skewed", "job_id", id, "offset", offset)
+	}
+	return offset
+}
+
+// normalizeBuildTimes converts `started` and `finished`, which were reported
+// by a worker with a clock that is `skew` behind composer's, to composer's
+// time. The results are clamped to lie between `earliest` and `latest`, so
+// that they never precede the job being handed to the worker nor end up in
+// the future, and `finished` never precedes `started`. Zero times are left
+// untouched.
+func normalizeBuildTimes(started, finished time.Time, skew time.Duration, earliest, latest time.Time) (time.Time, time.Time) {
+	clamp := func(t, min time.Time) time.Time {
+		if t.IsZero() {
+			return t
+		}
+		t = t.Add(skew)
+		if t.Before(min) {
+			t = min
+		}
+		if t.After(latest) {
+			t = latest
+		}
+		return t
+	}
+
+	started = clamp(started, earliest)
+	if started.IsZero() {
+		finished = clamp(finished, earliest)
+	} else {
+		finished = clamp(finished, started)
+	}
+
+	return started, finished
+}
+
+func (s *Server) heartbeatHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	contentType := request.Header["Content-Type"]
+	if len(contentType) != 1 || contentType[0] != "application/json" {
+		jsonErrorf(writer, common.ErrorUnsupportedMediaType, "request must contain application/json data")
+		return
+	}
+
+	id, err := uuid.Parse(params.ByName("job_id"))
+	if err != nil {
+		jsonErrorf(writer, common.ErrorInvalidRequest, "cannot parse job id: %v", err)
+		return
+	}
+
+	var body heartbeatRequest
+	err = json.NewDecoder(request.Body).Decode(&body)
+	if err != nil {
+		jsonErrorf(writer, common.ErrorInvalidRequest, "cannot parse request body: %v", err)
+		return
+	}
+
+	offset, err := s.recordHeartbeat(request.Context(), id, body.Time)
+	if err != nil {
+		switch err {
+		case jobqueue.ErrNotExist:
+			jsonErrorf(writer, common.ErrorJobNotFound, "job does not exist: %s", id)
+		case jobqueue.ErrNotRunning:
+			jsonErrorf(writer, common.ErrorJobNotRunning, "job is not running: %s", id)
+		default:
+			jsonErrorf(writer, common.ErrorInternal, "%v", err)
+		}
+		return
+	}
+
+	_ = json.NewEncoder(writer).Encode(heartbeatResponse{Offset: offset})
+}
+
+// recordHeartbeat remembers the clock offset of the worker running job `id`,
+// which sent its current time `workerTime` with a heartbeat, and returns it.
+// It returns jobqueue.ErrNotRunning if the job isn't running.
+func (s *Server) recordHeartbeat(ctx context.Context, id uuid.UUID, workerTime time.Time) (time.Duration, error) {
+	status, _, _, _, err := s.jobs.JobStatus(id, &json.RawMessage{})
+	if err != nil {
+		return 0, err
+	}
+	if status != jobqueue.JobRunning {
+		return 0, jobqueue.ErrNotRunning
+	}
+
+	offset := checkClockSkew(logging.FromContext(ctx), id, workerTime)
+
+	s.skewsMutex.Lock()
+	s.skews[id] = offset
+	s.skewsMutex.Unlock()
+
+	return offset, nil
+}
@@ -0,0 +1,180 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/logging"
+	"github.com/osbuild/osbuild-composer/internal/target"
+)
+
+// Workers can also convert images that were uploaded to composer, instead
+// of building them from a blueprint. A conversion is a chain of two jobs: the
+// first one converts the uploaded image with qemu-img and uploads the result
+// back to composer, like the image of a build. The second one downloads the
+// converted image and uploads it to the conversion's cloud targets. Splitting
+// them keeps the converted image, even when uploading it fails.
+
+// ConvertJobType is the job type of conversions. Only workers which advertise
+// that they can convert images (see Client.EnableConversions()) take them.
+const ConvertJobType = "convert"
+
+// Inputs of conversion jobs
+const (
+	// The image that was uploaded to composer
+	ConversionInputUpload = "upload"
+
+	// The image of the compose, i.e., the converted image
+	ConversionInputImage = "image"
+)
+
+// ConversionInputFunc opens the input `input` of the conversion of compose
+// `composeID` and returns its size.
+type ConversionInputFunc func(composeID uuid.UUID, input string) (io.ReadCloser, int64, error)
+
+// SetConversionInput sets the function which opens the images that workers
+// download for conversions. Conversion jobs fail when it isn't set.
+func (s *Server) SetConversionInput(conversionInput ConversionInputFunc) {
+	s.conversionInput = conversionInput
+}
+
+// Output formats of qemu-img by file name extension
+var conversionFormats = map[string]string{
+	".qcow2": "qcow2",
+	".vhd":   "vpc",
+	".vhdx":  "vhdx",
+	".vmdk":  "vmdk",
+	".raw":   "raw",
+	".img":   "raw",
+}
+
+// ConversionFormat returns the output format of qemu-img for images named
+// `filename`, or false if images of that kind cannot be converted to.
+func ConversionFormat(filename string) (string, bool) {
+	format, exists := conversionFormats[filepath.Ext(filename)]
+	return format, exists
+}
+
+// EnqueueConversion adds the jobs which convert the image uploaded for
+// compose `composeID` to `format` (see ConversionFormat()) and send it to
+// `targets`. The first job sends the converted image, named `filename`, to
+// the local targets. The second job depends on it and uploads the converted
+// image to all other targets. It is only added if there are any and its id is
+// uuid.Nil otherwise.
+func (s *Server) EnqueueConversion(composeID uuid.UUID, format, filename string, targets []*target.Target) (uuid.UUID, uuid.UUID, error) {
+	if s.Draining() {
+		return uuid.Nil, uuid.Nil, ErrDraining
+	}
+
+	var local, remote []*target.Target
+	for _, t := range targets {
+		if _, ok := t.Options.(*target.LocalTargetOptions); ok {
+			local = append(local, t)
+		} else {
+			remote = append(remote, t)
+		}
+	}
+
+	convertJobId, err := s.jobs.Enqueue(ConvertJobType, OSBuildJob{
+		Version: JobVersion,
+		Conversion: &Conversion{
+			ComposeID: composeID,
+			Input:     ConversionInputUpload,
+			Format:    format,
+			Filename:  filename,
+		},
+		Targets: local,
+	}, nil, jobqueue.PriorityNormal)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+
+	if len(remote) == 0 {
+		return convertJobId, uuid.Nil, nil
+	}
+
+	uploadJobId, err := s.jobs.Enqueue(ConvertJobType, OSBuildJob{
+		Version: JobVersion,
+		Conversion: &Conversion{
+			ComposeID: composeID,
+			Input:     ConversionInputImage,
+			Filename:  filename,
+		},
+		Targets: remote,
+	}, []uuid.UUID{convertJobId}, jobqueue.PriorityNormal)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+
+	return convertJobId, uploadJobId, nil
+}
+
+func (s *Server) conversionInputHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	id, err := uuid.Parse(params.ByName("compose_id"))
+	if err != nil {
+		jsonErrorf(writer, common.ErrorInvalidRequest, "cannot parse compose id: %v", err)
+		return
+	}
+
+	input := params.ByName("input")
+	if input != ConversionInputUpload && input != ConversionInputImage {
+		jsonErrorf(writer, common.ErrorNotFound, "unknown conversion input: %s", input)
+		return
+	}
+
+	if s.conversionInput == nil {
+		jsonErrorf(writer, common.ErrorNotFound, "conversions are not supported")
+		return
+	}
+
+	reader, size, err := s.conversionInput(id, input)
+	if err != nil {
+		jsonErrorf(writer, common.ErrorNotFound, "cannot open %s of compose %s: %v", input, id, err)
+		return
+	}
+	defer reader.Close()
+
+	writer.Header().Set("Content-Type", "application/octet-stream")
+	writer.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	_, err = io.Copy(writer, reader)
+	if err != nil {
+		logging.FromContext(request.Context()).Warning("sending conversion input failed", "compose_id", id, "input", input, "error", err)
+	}
+}
+
+// EnableConversions makes the client ask for conversion jobs in addition to
+// osbuild jobs. Only enable them on workers that have qemu-img.
+func (c *Client) EnableConversions() {
+	c.conversions = true
+}
+
+// DownloadConversionInput opens the image that conversion job `job` converts
+// or uploads.
+func (c *Client) DownloadConversionInput(job *Job) (io.ReadCloser, error) {
+	if job.Conversion == nil {
+		return nil, fmt.Errorf("job %s is not a conversion", job.Id)
+	}
+
+	response, err := c.request("GET", fmt.Sprintf("/job-queue/v1/composes/%s/inputs/%s", job.Conversion.ComposeID, job.Conversion.Input), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		defer response.Body.Close()
+		var er common.APIError
+		_ = json.NewDecoder(response.Body).Decode(&er)
+		return nil, fmt.Errorf("couldn't download conversion input, got %d: %s", response.StatusCode, er.Message)
+	}
+
+	return response.Body, nil
+}
@@ -0,0 +1,47 @@
+package worker
+
+import (
+	"sort"
+)
+
+// Each osbuild job has a job type for its architecture and distro (see
+// osbuildJobType()). Workers which can only build images of some distros,
+// because they only have osbuild runners for those, advertise them when they
+// ask for jobs and only get jobs of these distros. Other workers get jobs of
+// all distros that composer knows: the ones passed to SetDistros() and the
+// ones of the jobs it queued since it was started.
+
+// SetDistros sets the names of the distros composer builds images of, so that
+// jobs which were queued before composer was restarted are handed to workers
+// which don't advertise distros.
+func (s *Server) SetDistros(names []string) {
+	for _, name := range names {
+		s.addDistro(name)
+	}
+}
+
+func (s *Server) addDistro(name string) {
+	s.distrosMutex.Lock()
+	defer s.distrosMutex.Unlock()
+
+	s.distros[name] = true
+}
+
+// jobDistros returns the distros of the jobs that a worker which advertises
+// `distros` may take. The empty string stands for jobs which were queued
+// before jobs had a distro in their type.
+func (s *Server) jobDistros(distros []string) []string {
+	if len(distros) > 0 {
+		return distros
+	}
+
+	s.distrosMutex.Lock()
+	defer s.distrosMutex.Unlock()
+
+	names := []string{""}
+	for name := range s.distros {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,79 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrDraining is returned when image builds are queued after Drain() was
+// called.
+var ErrDraining = errors.New("composer is shutting down and doesn't queue new image builds")
+
+// How often Drain() checks whether workers are still running jobs
+const drainPollInterval = time.Second
+
+// Drain prepares composer for shutting down: workers aren't given jobs
+// anymore and no new image builds are queued, but workers can finish the
+// jobs they are running. It waits until they did, or until `ctx` is done.
+//
+// Jobs that are still running when composer exits aren't lost, because the
+// job queue is written to disk on every change. Workers report their result
+// when composer is back, and jobs that weren't taken yet are run then.
+func (s *Server) Drain(ctx context.Context) error {
+	s.drainOnce.Do(func() { close(s.draining) })
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.jobWorkersMutex.Lock()
+		running := len(s.jobWorkers)
+		s.jobWorkersMutex.Unlock()
+
+		if running == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d jobs are still running", running)
+		case <-ticker.C:
+		}
+	}
+}
+
+// Draining returns true after Drain() was called.
+func (s *Server) Draining() bool {
+	select {
+	case <-s.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// Shutdown stops serving workers on the listeners passed to Serve() and
+// ServeTLS(). It waits until the requests being served are done, or until
+// `ctx` is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.serversMutex.Lock()
+	defer s.serversMutex.Unlock()
+
+	var err error
+	for _, server := range s.servers {
+		if serr := server.Shutdown(ctx); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+func (s *Server) addHTTPServer(server *http.Server) {
+	s.serversMutex.Lock()
+	defer s.serversMutex.Unlock()
+
+	s.servers = append(s.servers, server)
+}
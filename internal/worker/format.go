@@ -0,0 +1,129 @@
+package worker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+// ImageFilenameFunc returns the file name of the image of an image build, or
+// "" if it has no image that is uploaded to composer.
+type ImageFilenameFunc func(composeID uuid.UUID, imageBuildID int) (string, error)
+
+// SetImageFormatCheck sets the function which returns the file name of an
+// image. Uploaded images must match the format that the file name's
+// extension indicates, so that a broken or mixed-up image fails its compose
+// instead of being handed to users. Images are not checked when it isn't
+// set.
+func (s *Server) SetImageFormatCheck(imageFilename ImageFilenameFunc) {
+	s.imageFilename = imageFilename
+}
+
+// An imageFormat is recognized by a magic byte sequence at a fixed offset.
+// Negative offsets count from the end of the image.
+type imageFormat struct {
+	name   string
+	offset int64
+	magic  []byte
+}
+
+// Formats of images by file name extension. Raw images (.img, .raw) have no
+// magic and are not checked.
+var imageFormats = map[string]imageFormat{
+	".qcow2": {"qcow2", 0, []byte("QFI\xfb")},
+	".vhd":   {"vhd", -512, []byte("conectix")},
+	".vhdx":  {"vhdx", 0, []byte("vhdxfile")},
+	".vmdk":  {"vmdk", 0, []byte("KDMV")},
+	".iso":   {"ISO 9660", 32769, []byte("CD001")},
+	".tar":   {"tar", 257, []byte("ustar")},
+	".xz":    {"xz", 0, []byte("\xfd7zXZ\x00")},
+}
+
+// formatMismatchError is returned when an uploaded image doesn't match the
+// format of its file name.
+type formatMismatchError struct {
+	filename string
+	format   string
+}
+
+func (e *formatMismatchError) Error() string {
+	return fmt.Sprintf("image is not in %s format, as its name %s indicates", e.format, e.filename)
+}
+
+// formatReader passes through an image and fails with a formatMismatchError
+// as soon as it is clear that the image isn't in the expected format. That
+// is either when the bytes at the magic's offset were read, or at the end of
+// the image for magics that are relative to it.
+type formatReader struct {
+	reader   io.Reader
+	filename string
+	format   imageFormat
+
+	// The first bytes of the image up to the end of the magic, or its
+	// last bytes for magics that are relative to the end
+	buf     []byte
+	checked bool
+}
+
+// checkImageFormat returns `reader` wrapped in a formatReader if there is a
+// format check for the image of an image build, and `reader` otherwise.
+func (s *Server) checkImageFormat(composeID uuid.UUID, imageBuildID int, reader io.Reader) (io.Reader, error) {
+	if s.imageFilename == nil {
+		return reader, nil
+	}
+
+	filename, err := s.imageFilename(composeID, imageBuildID)
+	if err != nil {
+		return nil, err
+	}
+
+	format, exists := imageFormats[filepath.Ext(filename)]
+	if !exists {
+		return reader, nil
+	}
+
+	return &formatReader{reader: reader, filename: filename, format: format}, nil
+}
+
+func (r *formatReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if r.checked {
+		return n, err
+	}
+
+	if r.format.offset >= 0 {
+		end := r.format.offset + int64(len(r.format.magic))
+		missing := end - int64(len(r.buf))
+		if missing > int64(n) {
+			missing = int64(n)
+		}
+		r.buf = append(r.buf, p[:missing]...)
+		if int64(len(r.buf)) == end {
+			r.checked = true
+			if !bytes.Equal(r.buf[r.format.offset:], r.format.magic) {
+				return n, r.mismatch()
+			}
+		}
+	} else {
+		r.buf = append(r.buf, p[:n]...)
+		if excess := int64(len(r.buf)) + r.format.offset; excess > 0 {
+			r.buf = r.buf[excess:]
+		}
+	}
+
+	if err == io.EOF {
+		r.checked = true
+		if r.format.offset >= 0 || int64(len(r.buf)) < -r.format.offset || !bytes.HasPrefix(r.buf, r.format.magic) {
+			return n, r.mismatch()
+		}
+	}
+
+	return n, err
+}
+
+func (r *formatReader) mismatch() error {
+	return &formatMismatchError{r.filename, r.format.name}
+}
@@ -0,0 +1,202 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+)
+
+// Composer and workers are upgraded independently, so each side has to
+// know what the other one supports. Composer describes itself at infoPath,
+// which clients ask for before they take their first job. Features that
+// were added to the worker API after it was first released are optional,
+// and clients only use those that composer lists as capabilities. Where
+// there is an older way to do the same, like uploading images at once
+// instead of in chunks, clients fall back to it. Otherwise they fail with
+// an IncompatibleError, which says what is missing.
+const infoPath = "/job-queue/v1/info"
+
+// The version of the worker API which this package implements, i.e., of the
+// paths below /job-queue/v1.
+const APIVersion = 1
+
+// Capabilities are optional features of the worker API.
+const (
+	// Workers can send heartbeats (see clockskew.go)
+	CapabilityHeartbeats = "heartbeats"
+	// Workers can report the progress of running jobs (see progress.go)
+	CapabilityProgress = "progress"
+	// Workers can stream the logs of running jobs (see logstream.go)
+	CapabilityLogStream = "log-stream"
+	// Workers can upload images in chunks and resume uploads (see
+	// upload.go)
+	CapabilityChunkedUploads = "chunked-uploads"
+	// Composer can pull images that workers offer (see pull.go)
+	CapabilityImagePull = "image-pull"
+	// Workers can upload checkpoints of image builds (see checkpoint.go)
+	CapabilityCheckpoints = "checkpoints"
+	// Workers can take only jobs of some distros (see distros.go)
+	CapabilityDistros = "distros"
+)
+
+// capabilities are the capabilities of this version of composer.
+var capabilities = []string{
+	CapabilityHeartbeats,
+	CapabilityProgress,
+	CapabilityLogStream,
+	CapabilityChunkedUploads,
+	CapabilityImagePull,
+	CapabilityCheckpoints,
+	CapabilityDistros,
+}
+
+// ServerInfo says what composer supports.
+type ServerInfo struct {
+	// Versions of the worker API that composer serves
+	APIVersions []int `json:"api_versions"`
+
+	// The oldest and the newest versions of job arguments and results
+	// that composer supports, see version.go
+	MinJobVersion int `json:"min_job_version"`
+	JobVersion    int `json:"job_version"`
+
+	Capabilities []string `json:"capabilities"`
+
+	// Whether requests must be signed, see signature.go
+	SignedRequests bool `json:"signed_requests"`
+}
+
+// legacyServerInfo describes composers which don't serve their info yet.
+// They may support some of the capabilities, but clients cannot know which.
+var legacyServerInfo = ServerInfo{
+	APIVersions:   []int{1},
+	MinJobVersion: 1,
+	JobVersion:    1,
+}
+
+// Supports returns true if composer has `capability`.
+func (info *ServerInfo) Supports(capability string) bool {
+	for _, c := range info.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// An IncompatibleError is returned by clients when composer doesn't support
+// what the worker needs, or the other way around.
+type IncompatibleError struct {
+	Reason string
+}
+
+func (e *IncompatibleError) Error() string {
+	return "composer and this worker are incompatible: " + e.Reason
+}
+
+func (s *Server) infoHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	_ = json.NewEncoder(writer).Encode(ServerInfo{
+		APIVersions:    []int{APIVersion},
+		MinJobVersion:  1,
+		JobVersion:     JobVersion,
+		Capabilities:   capabilities,
+		SignedRequests: s.signingKey != nil,
+	})
+}
+
+// Info returns what composer supports. It is asked for once per client. It
+// returns an IncompatibleError if the client cannot work with composer at
+// all.
+func (c *Client) Info() (*ServerInfo, error) {
+	c.infoMutex.Lock()
+	defer c.infoMutex.Unlock()
+
+	if c.info == nil {
+		info, err := c.fetchInfo()
+		if err != nil {
+			return nil, err
+		}
+		c.info = info
+	}
+
+	return c.info, c.checkInfo(c.info)
+}
+
+func (c *Client) fetchInfo() (*ServerInfo, error) {
+	response, err := c.request("GET", infoPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		var info ServerInfo
+		err = json.NewDecoder(response.Body).Decode(&info)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse composer's info: %v", err)
+		}
+		return &info, nil
+	case http.StatusNotFound:
+		info := legacyServerInfo
+		return &info, nil
+	default:
+		var er common.APIError
+		_ = json.NewDecoder(response.Body).Decode(&er)
+		return nil, fmt.Errorf("couldn't get composer's info, got %d: %s", response.StatusCode, er.Message)
+	}
+}
+
+// checkInfo returns an IncompatibleError if the client cannot work with
+// composer described by `info`.
+func (c *Client) checkInfo(info *ServerInfo) error {
+	served := false
+	for _, v := range info.APIVersions {
+		served = served || v == APIVersion
+	}
+	if !served {
+		return &IncompatibleError{fmt.Sprintf("composer serves versions %v of the worker API, but this worker only speaks version %d", info.APIVersions, APIVersion)}
+	}
+
+	if info.MinJobVersion > JobVersion {
+		return &IncompatibleError{fmt.Sprintf("composer needs workers which support version %d of jobs, but this worker only supports versions up to %d; upgrade the worker", info.MinJobVersion, JobVersion)}
+	}
+
+	if info.SignedRequests && c.signingKey == nil {
+		return &IncompatibleError{"composer only accepts signed requests, but this worker has no signing key"}
+	}
+
+	return nil
+}
+
+// require returns an IncompatibleError if composer doesn't have
+// `capability`, saying that the worker cannot do `what` because of that.
+func (c *Client) require(capability, what string) error {
+	info, err := c.Info()
+	if err != nil {
+		return err
+	}
+	if !info.Supports(capability) {
+		return &IncompatibleError{fmt.Sprintf("composer cannot %s (it doesn't have capability %q); upgrade composer", what, capability)}
+	}
+	return nil
+}
+
+// JobVersion returns the newest version of job results that both the client
+// and composer support.
+func (c *Client) JobVersion() (int, error) {
+	info, err := c.Info()
+	if err != nil {
+		return 0, err
+	}
+
+	version := info.JobVersion
+	if version > JobVersion || version < 1 {
+		version = JobVersion
+	}
+	return version, nil
+}
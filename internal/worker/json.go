@@ -1,6 +1,8 @@
 package worker
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 
 	"github.com/osbuild/osbuild-composer/internal/common"
@@ -13,35 +15,213 @@ import (
 //
 
 type OSBuildJob struct {
+	// See version.go
+	Version int `json:"version,omitempty"`
+
+	Distro   string            `json:"distro,omitempty"`
+	Arch     string            `json:"arch,omitempty"`
 	Manifest *osbuild.Manifest `json:"manifest"`
 	Targets  []*target.Target  `json:"targets,omitempty"`
+
+	// Set for jobs of ConvertJobType, which have no manifest
+	Conversion *Conversion `json:"conversion,omitempty"`
+
+	// The jobs this job depends on. Their results are passed to the
+	// worker as the job's inputs, in this order.
+	Dependencies []uuid.UUID `json:"dependencies,omitempty"`
+
+	// The tenant whose compose the job belongs to, empty for the default
+	// tenant
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// A Conversion converts input `Input` of a compose (see convert.go) to the
+// qemu-img output format `Format`, and sends the result to the job's targets
+// as `Filename`. The input is sent as it is when `Format` is empty.
+type Conversion struct {
+	ComposeID uuid.UUID `json:"compose_id"`
+	Input     string    `json:"input"`
+	Format    string    `json:"format,omitempty"`
+	Filename  string    `json:"filename"`
 }
 
 type OSBuildJobResult struct {
+	// See version.go
+	Version int `json:"version,omitempty"`
+
 	OSBuildOutput *common.ComposeResult `json:"osbuild_output,omitempty"`
+	TargetResults []TargetResult        `json:"target_results,omitempty"`
+
+	// When the worker started and finished building the image, in
+	// composer's time (see clockskew.go)
+	BuildStarted  time.Time `json:"build_started"`
+	BuildFinished time.Time `json:"build_finished"`
+
+	// Set when the job could not be run, for example because the worker
+	// could not read it
+	Error string `json:"error,omitempty"`
+}
+
+// A JobInput is the result of a job that another job depends on.
+type JobInput struct {
+	JobId  uuid.UUID        `json:"job_id"`
+	Result OSBuildJobResult `json:"result"`
+}
+
+// A TargetResult describes how uploading the image of a job to one of its
+// (non-local) targets went.
+type TargetResult struct {
+	// Name and profile of the target, see target.Target.Profile()
+	Name    string `json:"name"`
+	Profile string `json:"profile,omitempty"`
+
+	Duration time.Duration `json:"duration"`
+
+	// Set when the upload failed
+	Error string `json:"error,omitempty"`
+}
+
+// A ScanJob scans the image of a finished image build for vulnerabilities.
+type ScanJob struct {
+	ComposeID    uuid.UUID `json:"compose_id"`
+	ImageBuildID int       `json:"image_build_id"`
+}
+
+type ScanJobResult struct {
+	Scanner  string        `json:"scanner"`
+	Findings []ScanFinding `json:"findings"`
+
+	// Whether any finding is severe enough to fail the compose
+	PolicyViolated bool `json:"policy_violated"`
+
+	// Set when the image could not be scanned
+	Error string `json:"error,omitempty"`
+}
+
+type ScanFinding struct {
+	ID       string `json:"id"`
+	Package  string `json:"package,omitempty"`
+	Severity string `json:"severity"`
+	Title    string `json:"title,omitempty"`
+}
+
+// A SignJob computes the checksum of the image of a finished image build and
+// signs it.
+type SignJob struct {
+	ComposeID    uuid.UUID `json:"compose_id"`
+	ImageBuildID int       `json:"image_build_id"`
+}
+
+type SignJobResult struct {
+	Signer string `json:"signer"`
+
+	// The checksum of the image, as "sha256:<hex>"
+	Checksum string `json:"checksum,omitempty"`
+
+	// Set when the image could not be signed
+	Error string `json:"error,omitempty"`
+}
+
+// A PromoteJob uploads the image of a finished image build to the targets of
+// a promotion stage.
+type PromoteJob struct {
+	ComposeID    uuid.UUID        `json:"compose_id"`
+	ImageBuildID int              `json:"image_build_id"`
+	Stage        string           `json:"stage"`
+	Targets      []*target.Target `json:"targets"`
+}
+
+type PromoteJobResult struct {
+	// Set when uploading to any of the targets failed
+	Error string `json:"error,omitempty"`
 }
 
 //
 // JSON-serializable types for the HTTP API
 //
 
-type errorResponse struct {
-	Message string `json:"message"`
-}
-
 type addJobRequest struct {
+	Arches      []string `json:"arches"`
+	Region      string   `json:"region,omitempty"`
+	Conversions bool     `json:"conversions,omitempty"`
+
+	// The distros the worker can build images of, all if it is empty
+	Distros []string `json:"distros,omitempty"`
+
+	// The newest version of jobs the worker supports, 1 if it is not set
+	JobVersion int `json:"job_version,omitempty"`
 }
 
 type addJobResponse struct {
 	Id       uuid.UUID         `json:"id"`
+	Version  int               `json:"version,omitempty"`
+	Distro   string            `json:"distro,omitempty"`
+	Arch     string            `json:"arch,omitempty"`
 	Manifest *osbuild.Manifest `json:"manifest"`
 	Targets  []*target.Target  `json:"targets,omitempty"`
+
+	Conversion *Conversion `json:"conversion,omitempty"`
+	Inputs     []JobInput  `json:"inputs,omitempty"`
+}
+
+// JobProgress is how far a worker is with a running job.
+type JobProgress struct {
+	// The osbuild stage that is running, like "org.osbuild.rpm"
+	Stage string `json:"stage,omitempty"`
+
+	// What the worker is doing, for users, like "installing packages
+	// (43/212)"
+	Message string `json:"message,omitempty"`
+
+	Percent int `json:"percent"`
 }
 
 type updateJobRequest struct {
-	Status common.ImageBuildState `json:"status"`
-	Result *common.ComposeResult  `json:"result"`
+	Status        common.ImageBuildState `json:"status"`
+	Result        *common.ComposeResult  `json:"result"`
+	TargetResults []TargetResult         `json:"target_results,omitempty"`
+
+	// The worker's current time and when it started and finished
+	// building, according to its clock
+	Time          time.Time `json:"time"`
+	BuildStarted  time.Time `json:"build_started"`
+	BuildFinished time.Time `json:"build_finished"`
+
+	// The version of the result, 1 if it is not set, and why the job
+	// could not be run
+	Version int    `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+
+	// Set instead of the result while the job is running
+	Progress *JobProgress `json:"progress,omitempty"`
 }
 
 type updateJobResponse struct {
 }
+
+type heartbeatRequest struct {
+	// The worker's current time
+	Time time.Time `json:"time"`
+}
+
+type heartbeatResponse struct {
+	// What to add to the worker's time to get composer's time
+	Offset time.Duration `json:"offset"`
+}
+
+type pullImageRequest struct {
+	// Where and with which bearer token composer can download the image
+	URL   string `json:"url"`
+	Token string `json:"token"`
+
+	Size int64 `json:"size"`
+
+	// The checksum of the image, as "sha256:<hex>", omitted by older
+	// workers
+	Checksum string `json:"checksum,omitempty"`
+}
+
+type uploadStatusResponse struct {
+	Offset   int64 `json:"offset"`
+	Complete bool  `json:"complete,omitempty"`
+}
@@ -0,0 +1,148 @@
+package worker
+
+import (
+	"sort"
+	"time"
+
+	"github.com/osbuild/osbuild-composer/internal/target"
+)
+
+// Builds can be routed to workers close to where their images are uploaded
+// to, which cuts upload time and egress costs. Workers label themselves with
+// a region and each job takes the region of its first target that has one
+// (see target.Target.UploadRegion()).
+//
+// A job is reserved for workers of its region while that region is served:
+// while one of its workers waits for a job, or has asked for one within the
+// locality wait. Jobs of regions which are not served are handed to any
+// worker. Regions are only known after one of their workers asked for a job,
+// which means that jobs reserved before composer was restarted wait until a
+// worker of their region comes back.
+
+// DefaultLocalityWait is how long jobs are reserved for the workers of a
+// region after the last of them asked for a job.
+const DefaultLocalityWait = 10 * time.Minute
+
+// Workers which aren't allowed to take the jobs of some regions ask again
+// this often, so that they notice when these regions aren't served anymore.
+const localityRepoll = time.Minute
+
+type region struct {
+	waiting  int       // workers of the region that currently wait for a job
+	lastSeen time.Time // when a worker of the region last asked for a job
+}
+
+// SetLocalityWait sets how long jobs are reserved for the workers of a
+// region after the last of them asked for a job. It is DefaultLocalityWait
+// by default.
+func (s *Server) SetLocalityWait(wait time.Duration) {
+	s.regionsMutex.Lock()
+	defer s.regionsMutex.Unlock()
+
+	s.localityWait = wait
+}
+
+// regionJobType returns the job type of osbuild jobs for `arch` and `distro`
+// which are reserved for workers in `region`.
+func regionJobType(arch, distro, region string) string {
+	return osbuildJobType(arch, distro) + "@" + region
+}
+
+// jobRegion returns the region of the first of `targets` that has one.
+func jobRegion(targets []*target.Target) string {
+	for _, t := range targets {
+		if r := t.UploadRegion(); r != "" {
+			return r
+		}
+	}
+	return ""
+}
+
+// served returns true if workers of region `name` can be expected to take
+// its jobs. The caller must hold regionsMutex.
+func (s *Server) served(name string, now time.Time) bool {
+	r, exists := s.regions[name]
+	return exists && (r.waiting > 0 || now.Sub(r.lastSeen) < s.localityWait)
+}
+
+// jobTypeForTargets returns the job type of an osbuild job for `arch` and
+// `distro` that uploads to `targets`.
+func (s *Server) jobTypeForTargets(arch, distro string, targets []*target.Target) string {
+	s.regionsMutex.Lock()
+	defer s.regionsMutex.Unlock()
+
+	if name := jobRegion(targets); name != "" && s.served(name, time.Now()) {
+		return regionJobType(arch, distro, name)
+	}
+
+	return osbuildJobType(arch, distro)
+}
+
+// workerWaiting records that a worker in region `name` waits for a job for
+// any of `arches` and `distros` (all distros if it is empty). It returns the
+// job types the worker may take, whether it must ask again after
+// localityRepoll, and a function to call once it stops waiting. `name` is
+// empty for workers without a region.
+func (s *Server) workerWaiting(name string, arches, distros []string) ([]string, bool, func()) {
+	jobDistros := s.jobDistros(distros)
+
+	s.regionsMutex.Lock()
+	defer s.regionsMutex.Unlock()
+
+	now := time.Now()
+
+	// Jobs of type "osbuild" were enqueued before jobs were tagged with an
+	// architecture. Hand them to any worker which takes jobs of all
+	// distros, as before.
+	var jobTypes []string
+	if len(distros) == 0 {
+		jobTypes = append(jobTypes, "osbuild")
+	}
+	for _, arch := range arches {
+		for _, distro := range jobDistros {
+			jobTypes = append(jobTypes, osbuildJobType(arch, distro))
+		}
+	}
+
+	var regions []string
+	repoll := false
+	for other := range s.regions {
+		if other == name || !s.served(other, now) {
+			regions = append(regions, other)
+		} else {
+			repoll = true
+		}
+	}
+	if name != "" && s.regions[name] == nil {
+		regions = append(regions, name)
+	}
+	sort.Strings(regions)
+
+	for _, r := range regions {
+		for _, arch := range arches {
+			for _, distro := range jobDistros {
+				jobTypes = append(jobTypes, regionJobType(arch, distro, r))
+			}
+		}
+	}
+
+	if name == "" {
+		return jobTypes, repoll, func() {}
+	}
+
+	r := s.regions[name]
+	if r == nil {
+		r = &region{}
+		s.regions[name] = r
+	}
+	r.waiting += 1
+	r.lastSeen = now
+
+	return jobTypes, repoll, func() {
+		s.regionsMutex.Lock()
+		defer s.regionsMutex.Unlock()
+
+		r.waiting -= 1
+		r.lastSeen = time.Now()
+	}
+}
@@ -0,0 +1,244 @@
+package worker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/net/websocket"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/logging"
+)
+
+// The maximum number of bytes of log lines kept for each running job. When a
+// job logs more, the oldest lines are dropped.
+const maxJobLogSize = 1024 * 1024
+
+// A jobLog buffers the most recent log lines of a running job, so that
+// clients can attach to it at any time.
+type jobLog struct {
+	mu      sync.Mutex
+	lines   []string
+	size    int
+	first   int // sequence number of lines[0]
+	closed  bool
+	changed chan struct{}
+}
+
+func newJobLog() *jobLog {
+	return &jobLog{changed: make(chan struct{})}
+}
+
+func (l *jobLog) append(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return
+	}
+
+	l.lines = append(l.lines, line)
+	l.size += len(line)
+	for l.size > maxJobLogSize && len(l.lines) > 1 {
+		l.size -= len(l.lines[0])
+		l.lines = l.lines[1:]
+		l.first += 1
+	}
+
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+func (l *jobLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.closed {
+		l.closed = true
+		close(l.changed)
+	}
+}
+
+// read returns all lines starting with sequence number `from` (or the oldest
+// one that is still kept), the sequence number of the next line, and a
+// channel that is closed when more lines are added. It returns a nil channel
+// if the log is closed.
+func (l *jobLog) read(from int) ([]string, int, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if from < l.first {
+		from = l.first
+	}
+	next := l.first + len(l.lines)
+	lines := append([]string{}, l.lines[from-l.first:]...)
+
+	if l.closed {
+		return lines, next, nil
+	}
+	return lines, next, l.changed
+}
+
+// jobLog returns the log of job `id`, creating it if it doesn't exist yet.
+func (s *Server) jobLog(id uuid.UUID) *jobLog {
+	s.logsMutex.Lock()
+	defer s.logsMutex.Unlock()
+
+	l, exists := s.logs[id]
+	if !exists {
+		l = newJobLog()
+		s.logs[id] = l
+	}
+	return l
+}
+
+// closeJobLog closes the log of job `id` and forgets about it. Clients
+// following it receive all remaining lines and are then disconnected.
+func (s *Server) closeJobLog(id uuid.UUID) {
+	s.logsMutex.Lock()
+	defer s.logsMutex.Unlock()
+
+	if l, exists := s.logs[id]; exists {
+		l.close()
+		delete(s.logs, id)
+	}
+}
+
+// FollowJobLog calls `send` with every log line of the running job `id`,
+// starting with the ones that are still buffered, until the job finishes or
+// `ctx` is canceled.
+func (s *Server) FollowJobLog(ctx context.Context, id uuid.UUID, send func(line string) error) error {
+	status, _, _, _, err := s.jobs.JobStatus(id, &json.RawMessage{})
+	if err != nil {
+		return err
+	}
+	if status == jobqueue.JobFinished {
+		return jobqueue.ErrNotRunning
+	}
+
+	l := s.jobLog(id)
+	next := 0
+	for {
+		var lines []string
+		var changed <-chan struct{}
+		lines, next, changed = l.read(next)
+
+		for _, line := range lines {
+			err := send(line)
+			if err != nil {
+				return err
+			}
+		}
+
+		if changed == nil {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// jobLogHandler accepts a websocket connection from a worker, which streams
+// the log of a running job. Messages may contain any part of the log; they
+// are split into lines here.
+func (s *Server) jobLogHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	id, err := uuid.Parse(params.ByName("job_id"))
+	if err != nil {
+		jsonErrorf(writer, common.ErrorInvalidRequest, "cannot parse job id: %v", err)
+		return
+	}
+
+	status, _, _, _, err := s.jobs.JobStatus(id, &json.RawMessage{})
+	if err != nil {
+		jsonErrorf(writer, common.ErrorJobNotFound, "job does not exist: %s", id)
+		return
+	}
+	if status != jobqueue.JobRunning {
+		jsonErrorf(writer, common.ErrorJobNotRunning, "job is not running: %s", id)
+		return
+	}
+
+	logger := logging.FromContext(request.Context())
+	l := s.jobLog(id)
+
+	websocket.Server{
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+
+			logger.Debug("job log stream started", "job_id", id)
+			scanner := bufio.NewScanner(ws)
+			scanner.Buffer(make([]byte, 64*1024), maxJobLogSize)
+			for scanner.Scan() {
+				l.append(scanner.Text())
+			}
+			if err := scanner.Err(); err != nil {
+				logger.Warning("job log stream failed", "job_id", id, "error", err)
+			}
+			logger.Debug("job log stream ended", "job_id", id)
+		},
+	}.ServeHTTP(writer, request)
+}
+
+// ServeJobLog sends the log of the running job `id` to the websocket client
+// of `request`, one line per text message, until the job finishes or the
+// client goes away.
+func (s *Server) ServeJobLog(writer http.ResponseWriter, request *http.Request, id uuid.UUID) {
+	websocket.Server{
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+
+			ctx, cancel := context.WithCancel(request.Context())
+			defer cancel()
+
+			// Clients don't send anything. Reading detects when they
+			// go away.
+			go func() {
+				_, _ = io.Copy(ioutil.Discard, ws)
+				cancel()
+			}()
+
+			_ = s.FollowJobLog(ctx, id, func(line string) error {
+				return websocket.Message.Send(ws, line)
+			})
+		},
+	}.ServeHTTP(writer, request)
+}
+
+// jobLogWriter sends everything written to it to the log stream of a job.
+// Sending is best-effort: after the first error, writes are discarded, so
+// that a broken log stream never fails a build.
+type jobLogWriter struct {
+	mu     sync.Mutex
+	ws     *websocket.Conn
+	failed bool
+}
+
+func (w *jobLogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.failed {
+		err := websocket.Message.Send(w.ws, p)
+		if err != nil {
+			w.failed = true
+		}
+	}
+
+	return len(p), nil
+}
+
+func (w *jobLogWriter) Close() error {
+	return w.ws.Close()
+}
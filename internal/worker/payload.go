@@ -0,0 +1,88 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/logging"
+)
+
+// Installers embed the image of another job, their payload. The installer's
+// job depends on that job, and the worker building the installer downloads
+// the payload from composer before running osbuild.
+
+// PayloadFunc opens the image that job `jobID` built and returns its size.
+type PayloadFunc func(jobID uuid.UUID) (io.ReadCloser, int64, error)
+
+// SetPayloadSource sets the function which opens the images that workers
+// download as payloads. Jobs which embed a payload fail when it isn't set.
+func (s *Server) SetPayloadSource(payload PayloadFunc) {
+	s.payload = payload
+}
+
+func (s *Server) jobPayloadHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	id, err := uuid.Parse(params.ByName("job_id"))
+	if err != nil {
+		jsonErrorf(writer, common.ErrorInvalidRequest, "cannot parse job id: %v", err)
+		return
+	}
+
+	var result OSBuildJobResult
+	status, _, _, _, err := s.jobs.JobStatus(id, &result)
+	if err == jobqueue.ErrNotExist {
+		jsonErrorf(writer, common.ErrorNotFound, "job does not exist: %s", id)
+		return
+	} else if err != nil {
+		jsonErrorf(writer, common.ErrorInternal, "cannot get status of job %s: %v", id, err)
+		return
+	}
+	if status != jobqueue.JobFinished {
+		jsonErrorf(writer, common.ErrorNotFound, "job %s has not finished", id)
+		return
+	}
+
+	if s.payload == nil {
+		jsonErrorf(writer, common.ErrorNotFound, "payloads are not supported")
+		return
+	}
+
+	reader, size, err := s.payload(id)
+	if err != nil {
+		jsonErrorf(writer, common.ErrorNotFound, "cannot open image of job %s: %v", id, err)
+		return
+	}
+	defer reader.Close()
+
+	writer.Header().Set("Content-Type", "application/octet-stream")
+	writer.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	_, err = io.Copy(writer, reader)
+	if err != nil {
+		logging.FromContext(request.Context()).Warning("sending payload failed", "job_id", id, "error", err)
+	}
+}
+
+// DownloadPayload opens the image that job `jobID` built, which the job being
+// run depends on.
+func (c *Client) DownloadPayload(jobID uuid.UUID) (io.ReadCloser, error) {
+	response, err := c.request("GET", fmt.Sprintf("/job-queue/v1/jobs/%s/payload", jobID), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		defer response.Body.Close()
+		var er common.APIError
+		_ = json.NewDecoder(response.Body).Decode(&er)
+		return nil, fmt.Errorf("couldn't download payload, got %d: %s", response.StatusCode, er.Message)
+	}
+
+	return response.Body, nil
+}
@@ -0,0 +1,302 @@
+package worker
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+)
+
+// A compose runs as a chain of jobs, which are its phases. The progress of a
+// compose is the progress of its phases, weighted by how long each of them
+// takes. Phases that are done weigh as much as they took, all others as long
+// as that phase took on average recently. The progress of a running phase is
+// what the worker running it reported last or, if it didn't report any,
+// estimated from how long it has been running.
+
+// Phases of a compose
+const (
+	PhaseBuild   = "build"
+	PhaseScan    = "scan"
+	PhaseSign    = "sign"
+	PhaseConvert = "convert"
+	PhaseUpload  = "upload"
+)
+
+// Expected durations of phases for which no job has finished yet
+var defaultPhaseDurations = map[string]time.Duration{
+	PhaseBuild:   10 * time.Minute,
+	PhaseScan:    2 * time.Minute,
+	PhaseSign:    time.Minute,
+	PhaseConvert: 5 * time.Minute,
+	PhaseUpload:  5 * time.Minute,
+}
+
+// The weight of the latest duration in the moving average of a phase
+const phaseDurationSmoothing = 0.2
+
+// A running phase never reports more than this, because it might take
+// longer than expected.
+const maxRunningProgress = 0.95
+
+type PhaseProgress struct {
+	// The image build that the phase belongs to, omitted for the first
+	ImageBuild int `json:"image_build,omitempty"`
+
+	Phase   string `json:"phase"`
+	State   string `json:"state"`
+	Percent int    `json:"percent"`
+
+	// What the worker running the phase reported last, see JobProgress
+	Stage   string `json:"stage,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	// The share of the phase in the progress of the compose, in percent
+	Weight int `json:"weight"`
+}
+
+type ComposeProgress struct {
+	Percent int             `json:"percent"`
+	Phases  []PhaseProgress `json:"phases"`
+}
+
+// RecordPhaseDuration adds `d` to the durations of `phase`, from which the
+// expected duration of that phase in other composes is estimated. Jobs that
+// composer runs itself, like scans, must be recorded with this. Durations of
+// jobs that workers run are recorded when the workers report them.
+func (s *Server) RecordPhaseDuration(phase string, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+
+	s.phasesMutex.Lock()
+	defer s.phasesMutex.Unlock()
+
+	average, exists := s.phaseDurations[phase]
+	if !exists {
+		s.phaseDurations[phase] = d
+		return
+	}
+	s.phaseDurations[phase] = average + time.Duration(phaseDurationSmoothing*float64(d-average))
+}
+
+func (s *Server) expectedPhaseDuration(phase string) time.Duration {
+	s.phasesMutex.Lock()
+	defer s.phasesMutex.Unlock()
+
+	if d, exists := s.phaseDurations[phase]; exists {
+		return d
+	}
+	return defaultPhaseDurations[phase]
+}
+
+// setJobPhase remembers the phase of a job that a worker is running, so that
+// its duration can be recorded when it is finished.
+func (s *Server) setJobPhase(id uuid.UUID, job *OSBuildJob) {
+	phase := PhaseBuild
+	if job.Conversion != nil {
+		if job.Conversion.Input == ConversionInputImage {
+			phase = PhaseUpload
+		} else {
+			phase = PhaseConvert
+		}
+	}
+
+	s.phasesMutex.Lock()
+	defer s.phasesMutex.Unlock()
+
+	s.jobPhases[id] = phase
+}
+
+func (s *Server) forgetJobPhase(id uuid.UUID) string {
+	s.phasesMutex.Lock()
+	defer s.phasesMutex.Unlock()
+
+	phase := s.jobPhases[id]
+	delete(s.jobPhases, id)
+	return phase
+}
+
+var errInvalidProgress = errors.New("progress must be between 0 and 100 percent")
+
+// setJobProgress records the progress that the worker running job `id`
+// reported in the job queue.
+func (s *Server) setJobProgress(id uuid.UUID, progress *JobProgress) error {
+	if progress.Percent < 0 || progress.Percent > 100 {
+		return errInvalidProgress
+	}
+	return s.jobs.SetJobProgress(id, progress)
+}
+
+// reportedProgress returns the progress that the worker running job `id`
+// reported last, or nil if it didn't report any.
+func (s *Server) reportedProgress(id uuid.UUID) *JobProgress {
+	var progress *JobProgress
+	err := s.jobs.JobProgress(id, &progress)
+	if err != nil {
+		return nil
+	}
+	return progress
+}
+
+// A composePhase is a job of an image build.
+type composePhase struct {
+	job               uuid.UUID
+	imageBuild        int
+	name              string
+	state             common.ComposeState
+	started, finished time.Time
+}
+
+// imageBuildPhases returns the phases of an image build, in the order they
+// run.
+func (s *Server) imageBuildPhases(c compose.Compose, imageBuildID int) []composePhase {
+	ib := c.ImageBuilds[imageBuildID]
+
+	if ib.JobId == uuid.Nil {
+		// Composes from before the job queue only know their state
+		p := composePhase{imageBuild: imageBuildID, name: PhaseBuild, started: ib.JobStarted, finished: ib.JobFinished}
+		switch ib.QueueStatus {
+		case common.IBWaiting:
+			p.state = common.CWaiting
+		case common.IBRunning:
+			p.state = common.CRunning
+		case common.IBFinished:
+			p.state = common.CFinished
+		case common.IBFailed:
+			p.state = common.CFailed
+		}
+		return []composePhase{p}
+	}
+
+	var phases []composePhase
+
+	first := PhaseBuild
+	if c.Conversion != nil {
+		first = PhaseConvert
+	}
+	p := composePhase{job: ib.JobId, imageBuild: imageBuildID, name: first}
+	p.state, _, p.started, p.finished, _ = s.JobStatus(ib.JobId)
+	phases = append(phases, p)
+
+	if ib.ScanJobId != uuid.Nil {
+		p := composePhase{imageBuild: imageBuildID, name: PhaseScan}
+		var status jobqueue.JobStatus
+		var scan ScanJobResult
+		status, _, p.started, p.finished, _ = s.jobs.JobStatus(ib.ScanJobId, &scan)
+		switch {
+		case status == jobqueue.JobPending:
+			p.state = common.CWaiting
+		case status == jobqueue.JobRunning:
+			p.state = common.CRunning
+		case scan.PolicyViolated:
+			p.state = common.CFailed
+		default:
+			p.state = common.CFinished
+		}
+		phases = append(phases, p)
+	}
+
+	if ib.SignJobId != uuid.Nil {
+		p := composePhase{imageBuild: imageBuildID, name: PhaseSign}
+		var status jobqueue.JobStatus
+		var sign SignJobResult
+		status, _, p.started, p.finished, _ = s.jobs.JobStatus(ib.SignJobId, &sign)
+		switch {
+		case status == jobqueue.JobPending:
+			p.state = common.CWaiting
+		case status == jobqueue.JobRunning:
+			p.state = common.CRunning
+		case sign.Error != "":
+			p.state = common.CFailed
+		default:
+			p.state = common.CFinished
+		}
+		phases = append(phases, p)
+	}
+
+	if ib.UploadJobId != uuid.Nil {
+		p := composePhase{job: ib.UploadJobId, imageBuild: imageBuildID, name: PhaseUpload}
+		p.state, _, p.started, p.finished, _ = s.JobStatus(ib.UploadJobId)
+		phases = append(phases, p)
+	}
+
+	return phases
+}
+
+// ComposeProgress returns the progress of all image builds of `c`, whose
+// phases are listed one image build after another. Image builds run at the
+// same time, but they share the workers, so they are weighted as if they ran
+// one after another.
+func (s *Server) ComposeProgress(c compose.Compose) *ComposeProgress {
+	if len(c.ImageBuilds) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+
+	var phases []composePhase
+	for i := range c.ImageBuilds {
+		phases = append(phases, s.imageBuildPhases(c, i)...)
+	}
+
+	progress := &ComposeProgress{}
+	var total time.Duration
+	var done float64
+	weights := make([]time.Duration, len(phases))
+	fractions := make([]float64, len(phases))
+	reported := make([]*JobProgress, len(phases))
+	for i, p := range phases {
+		expected := s.expectedPhaseDuration(p.name)
+
+		switch p.state {
+		case common.CFinished, common.CFailed:
+			fractions[i] = 1
+			if !p.started.IsZero() && p.finished.After(p.started) {
+				expected = p.finished.Sub(p.started)
+			}
+		case common.CRunning:
+			reported[i] = s.reportedProgress(p.job)
+			if reported[i] != nil {
+				fractions[i] = float64(reported[i].Percent) / 100
+			} else if !p.started.IsZero() && expected > 0 {
+				fractions[i] = float64(now.Sub(p.started)) / float64(expected)
+			}
+			if fractions[i] < 0 {
+				fractions[i] = 0
+			} else if fractions[i] > maxRunningProgress {
+				fractions[i] = maxRunningProgress
+			}
+		}
+
+		// Phases without any duration would not count at all
+		if expected <= 0 {
+			expected = time.Second
+		}
+		weights[i] = expected
+		total += expected
+		done += fractions[i] * float64(expected)
+	}
+
+	for i, p := range phases {
+		phase := PhaseProgress{
+			ImageBuild: p.imageBuild,
+			Phase:      p.name,
+			State:      p.state.ToString(),
+			Percent:    int(fractions[i] * 100),
+			Weight:     int(float64(weights[i]) / float64(total) * 100),
+		}
+		if reported[i] != nil {
+			phase.Stage = reported[i].Stage
+			phase.Message = reported[i].Message
+		}
+		progress.Phases = append(progress.Phases, phase)
+	}
+	progress.Percent = int(done / float64(total) * 100)
+
+	return progress
+}
@@ -0,0 +1,315 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/logging"
+)
+
+// Workers which cannot send large request bodies to composer, for example
+// because a proxy in between limits them, can offer images for composer to
+// pull instead. The worker serves the image from a short-lived HTTP server,
+// which only answers requests carrying a random token, and POSTs its URL to
+// composer. Composer downloads the image into the same partial upload file
+// that chunked uploads use, resuming with range requests when a connection
+// breaks, and replies once the image was passed on to the image writer.
+
+// How often composer tries to download the rest of an image before giving up
+const pullAttempts = 5
+
+// SetPullRateLimit limits how many bytes per second composer downloads when
+// pulling an image, so that pulls don't saturate its network. Pulls are not
+// limited when it is 0.
+func (s *Server) SetPullRateLimit(bytesPerSecond int64) {
+	s.pullRate = bytesPerSecond
+}
+
+// throttledReader reads from `reader` at no more than `rate` bytes per
+// second on average.
+type throttledReader struct {
+	reader io.Reader
+	rate   int64
+
+	start time.Time
+	read  int64
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if r.start.IsZero() {
+		r.start = time.Now()
+	}
+
+	// Read at most a second's worth at once, so that reading stays smooth
+	if int64(len(p)) > r.rate {
+		p = p[:r.rate]
+	}
+
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+
+	expected := time.Duration(float64(r.read) / float64(r.rate) * float64(time.Second))
+	if wait := expected - time.Since(r.start); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	return n, err
+}
+
+func (s *Server) pullJobImageHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	contentType := request.Header["Content-Type"]
+	if len(contentType) != 1 || contentType[0] != "application/json" {
+		jsonErrorf(writer, common.ErrorUnsupportedMediaType, "request must contain application/json data")
+		return
+	}
+
+	id, imageBuildId, ok := parseImageParams(writer, params)
+	if !ok {
+		return
+	}
+
+	var body pullImageRequest
+	err := json.NewDecoder(request.Body).Decode(&body)
+	if err != nil {
+		jsonErrorf(writer, common.ErrorInvalidRequest, "cannot parse request body: %v", err)
+		return
+	}
+
+	u, err := url.Parse(body.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		jsonErrorf(writer, common.ErrorInvalidRequest, "image url must be an http or https url: %s", body.URL)
+		return
+	}
+	if body.Size < 0 {
+		jsonErrorf(writer, common.ErrorInvalidRequest, "image size must not be negative")
+		return
+	}
+	if body.Checksum != "" && !validChecksum(body.Checksum) {
+		jsonErrorf(writer, common.ErrorInvalidRequest, "image checksum must be sha256:<hex>: %s", body.Checksum)
+		return
+	}
+
+	maxSize, err := s.maxImageSize(id, imageBuildId)
+	if err != nil {
+		jsonErrorf(writer, common.ErrorInternal, "%v", err)
+		return
+	}
+	if maxSize != sizeUnknown && body.Size > maxSize {
+		jsonErrorf(writer, common.ErrorImageTooLarge, "image must not be larger than %d bytes", maxSize)
+		return
+	}
+
+	name := partialUploadName(id, imageBuildId)
+	unlock := s.lockUpload(name)
+	defer unlock()
+
+	file, err := os.OpenFile(filepath.Join(s.uploadDir, name), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		jsonErrorf(writer, common.ErrorInternal, "%v", err)
+		return
+	}
+	defer file.Close()
+
+	logger := logging.FromContext(request.Context())
+
+	err = s.pullImage(request.Context(), &body, file)
+	if err != nil {
+		logger.Error("pulling image failed", "compose_id", id, "image_build_id", imageBuildId, "url", body.URL, "error", err)
+		jsonErrorf(writer, common.ErrorImagePullFailed, "cannot pull image: %v", err)
+		return
+	}
+
+	s.completeUpload(writer, logger, id, imageBuildId, file, body.Size, body.Checksum, workerIdentity(request))
+}
+
+// pullImage downloads the image that a worker offered into `file`, keeping
+// what `file` already contains from earlier attempts.
+func (s *Server) pullImage(ctx context.Context, offer *pullImageRequest, file *os.File) error {
+	attempts := 0
+	for {
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+
+		offset := info.Size()
+		if offset > offer.Size {
+			// Left over from a different image, start over
+			offset = 0
+		}
+		if offset == offer.Size {
+			return file.Truncate(offset)
+		}
+
+		retry, err := s.pullImageRange(ctx, offer, file, offset)
+		if err == nil {
+			attempts = 0
+			continue
+		}
+
+		attempts++
+		if !retry || attempts >= pullAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempts) * time.Second):
+		}
+	}
+}
+
+// pullImageRange downloads the offered image from `offset` to its end into
+// `file`. It returns whether trying again might help when it fails.
+func (s *Server) pullImageRange(ctx context.Context, offer *pullImageRequest, file *os.File, offset int64) (bool, error) {
+	request, err := http.NewRequest("GET", offer.URL, nil)
+	if err != nil {
+		return false, err
+	}
+	request = request.WithContext(ctx)
+	request.Header.Set("Authorization", "Bearer "+offer.Token)
+	if offset > 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		// The worker ignored the range
+		offset = 0
+	case http.StatusPartialContent:
+		first, _, total, err := parseContentRange(response.Header.Get("Content-Range"))
+		if err != nil {
+			return true, err
+		}
+		if first != offset || total != offer.Size {
+			return false, fmt.Errorf("worker sent bytes from %d of %d, but expected bytes from %d of %d", first, total, offset, offer.Size)
+		}
+	default:
+		retry := response.StatusCode >= http.StatusInternalServerError
+		return retry, fmt.Errorf("worker responded with %s", response.Status)
+	}
+
+	err = file.Truncate(offset)
+	if err == nil {
+		_, err = file.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var body io.Reader = response.Body
+	if s.pullRate > 0 {
+		body = &throttledReader{reader: body, rate: s.pullRate}
+	}
+
+	length := offer.Size - offset
+	n, err := io.CopyN(file, body, length)
+	if err != nil {
+		return true, fmt.Errorf("received only %d of %d bytes: %v", n, length, err)
+	}
+
+	return false, nil
+}
+
+// OfferImage serves the image in `file` on `listener` until composer pulled
+// it. Composer reaches the listener at `baseURL`, which defaults to
+// "http://" followed by the listener's address. The listener is closed when
+// OfferImage returns.
+func (c *Client) OfferImage(composeId uuid.UUID, imageBuildId int, file io.ReadSeeker, listener net.Listener, baseURL string) error {
+	defer listener.Close()
+
+	err := c.require(CapabilityImagePull, "pull images")
+	if err != nil {
+		return err
+	}
+
+	checksum, err := imageChecksum(file)
+	if err != nil {
+		return err
+	}
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	tokenBytes := make([]byte, 32)
+	_, err = rand.Read(tokenBytes)
+	if err != nil {
+		return err
+	}
+	token := hex.EncodeToString(tokenBytes)
+
+	// Composer only pulls one range at a time, but the file's offset
+	// must not be shared between requests
+	var mutex sync.Mutex
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if subtle.ConstantTimeCompare([]byte(request.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+				http.Error(writer, "invalid token", http.StatusForbidden)
+				return
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			http.ServeContent(writer, request, "", time.Time{}, file)
+		}),
+	}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Close()
+
+	if baseURL == "" {
+		baseURL = "http://" + listener.Addr().String()
+	}
+
+	var b bytes.Buffer
+	err = json.NewEncoder(&b).Encode(pullImageRequest{
+		URL:      baseURL + "/image",
+		Token:    token,
+		Size:     size,
+		Checksum: checksum,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	response, err := c.request("POST", fmt.Sprintf("/job-queue/v1/jobs/%s/builds/%d/image/pull", composeId, imageBuildId), b.Bytes())
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		var er common.APIError
+		_ = json.NewDecoder(response.Body).Decode(&er)
+		return fmt.Errorf("composer couldn't pull image, got %d: %s", response.StatusCode, er.Message)
+	}
+
+	return nil
+}
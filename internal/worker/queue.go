@@ -0,0 +1,73 @@
+package worker
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+)
+
+// ErrQueueNotInspectable is returned by QueuedJobs() when the job queue
+// doesn't implement jobqueue.Inspector.
+var ErrQueueNotInspectable = errors.New("the job queue cannot list its jobs")
+
+// ErrQueueNotCancelable is returned by CancelJob() when the job queue
+// doesn't implement jobqueue.Canceler.
+var ErrQueueNotCancelable = errors.New("the job queue cannot cancel jobs")
+
+// A QueuedJob is a job which has not finished yet.
+type QueuedJob struct {
+	jobqueue.QueuedJob
+
+	// The address of the worker which runs the job. It is empty for
+	// pending jobs and for jobs that composer runs itself, like scans.
+	Worker string `json:"worker,omitempty"`
+}
+
+// QueuedJobs returns the jobs which are pending or running, in the order of
+// jobqueue.Inspector.QueuedJobs().
+func (s *Server) QueuedJobs() ([]QueuedJob, error) {
+	inspector, ok := s.jobs.(jobqueue.Inspector)
+	if !ok {
+		return nil, ErrQueueNotInspectable
+	}
+
+	jobs, err := inspector.QueuedJobs()
+	if err != nil {
+		return nil, err
+	}
+
+	s.jobWorkersMutex.Lock()
+	defer s.jobWorkersMutex.Unlock()
+
+	queued := make([]QueuedJob, 0, len(jobs))
+	for _, j := range jobs {
+		queued = append(queued, QueuedJob{j, s.jobWorkers[j.Id]})
+	}
+	return queued, nil
+}
+
+// CancelJob removes job `id` before it has been dequeued, as
+// jobqueue.Canceler.CancelJob() does.
+func (s *Server) CancelJob(id uuid.UUID) error {
+	canceler, ok := s.jobs.(jobqueue.Canceler)
+	if !ok {
+		return ErrQueueNotCancelable
+	}
+	return canceler.CancelJob(id)
+}
+
+func (s *Server) setJobWorker(id uuid.UUID, worker string) {
+	s.jobWorkersMutex.Lock()
+	defer s.jobWorkersMutex.Unlock()
+
+	s.jobWorkers[id] = worker
+}
+
+func (s *Server) forgetJobWorker(id uuid.UUID) {
+	s.jobWorkersMutex.Lock()
+	defer s.jobWorkersMutex.Unlock()
+
+	delete(s.jobWorkers, id)
+}
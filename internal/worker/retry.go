@@ -0,0 +1,134 @@
+package worker
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Clients retry requests which fail because composer cannot be reached or
+// is temporarily unavailable, e.g., while it restarts. Requests which
+// composer answered with an error are not retried, and neither are requests
+// which failed for reasons that don't go away by waiting, like a rejected
+// certificate.
+//
+// Requests which change state, like taking a job, are only retried when
+// composer cannot have handled them: when it couldn't be connected to or
+// said that it is unavailable. When the connection breaks after such a
+// request was sent, composer might have handled it already, and sending it
+// again could take a second job or finish a job twice.
+
+// Backoff configures how a Client retries requests. The delay between
+// attempts starts at Initial and doubles with every attempt up to Max. A
+// random part of the delay is skipped, so that workers don't all retry at
+// the same time after composer restarted.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+
+	// Requests are given up after retrying for this long. Zero means they
+	// are retried forever, and a negative duration that they are never
+	// retried.
+	Timeout time.Duration
+}
+
+// DefaultBackoff is the backoff of new clients.
+var DefaultBackoff = Backoff{
+	Initial: time.Second,
+	Max:     time.Minute,
+	Timeout: 10 * time.Minute,
+}
+
+// SetBackoff sets how the client retries requests.
+func (c *Client) SetBackoff(backoff Backoff) {
+	c.backoff = backoff
+}
+
+// retry calls `attempt` until it succeeds, fails with an error that isn't
+// worth retrying, or the client's backoff timeout passed. `idempotent` says
+// whether `attempt` may be repeated after composer might have handled it.
+func (c *Client) retry(idempotent bool, attempt func() error) error {
+	start := time.Now()
+	delay := c.backoff.Initial
+
+	for {
+		err := attempt()
+		if err == nil || !retryable(err, idempotent) || c.backoff.Timeout < 0 {
+			return err
+		}
+		if c.backoff.Timeout > 0 && time.Since(start)+delay > c.backoff.Timeout {
+			return err
+		}
+
+		time.Sleep(delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1)))
+
+		delay *= 2
+		if delay > c.backoff.Max {
+			delay = c.backoff.Max
+		}
+	}
+}
+
+// unavailableError is returned by requests which composer answered with a
+// status that means it will be back soon.
+type unavailableError struct {
+	status int
+}
+
+func (e *unavailableError) Error() string {
+	return "composer is unavailable: " + http.StatusText(e.status)
+}
+
+// checkAvailable returns an unavailableError if `response` says that
+// composer is temporarily unavailable, which includes composer draining
+// before shutting down.
+func checkAvailable(response *http.Response) error {
+	switch response.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		response.Body.Close()
+		return &unavailableError{response.StatusCode}
+	}
+	return nil
+}
+
+// idempotentMethod returns true if requests with `method` don't change
+// anything when they are sent twice.
+func idempotentMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// retryable returns true if `err` is likely to go away when the request is
+// sent again a little later: composer isn't listening, or said that it is
+// unavailable. Idempotent requests are also retried when composer closed the
+// connection. Proxies answer with a bad gateway or gateway timeout also after
+// composer handled a request, which is why only idempotent requests are
+// retried after those.
+func retryable(err error, idempotent bool) bool {
+	if uerr, ok := err.(*url.Error); ok {
+		err = uerr.Err
+	}
+
+	switch err := err.(type) {
+	case *unavailableError:
+		return idempotent || err.status == http.StatusServiceUnavailable
+	case *net.OpError:
+		if err.Op == "dial" {
+			return true
+		}
+		if serr, ok := err.Err.(*os.SyscallError); ok && idempotent {
+			return serr.Err == syscall.ECONNRESET || serr.Err == syscall.EPIPE
+		}
+		return false
+	}
+
+	return idempotent && (err == io.EOF || err == io.ErrUnexpectedEOF)
+}
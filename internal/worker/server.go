@@ -1,39 +1,152 @@
 package worker
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
 	"net"
 	"net/http"
-	"strconv"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
 
+	"github.com/osbuild/osbuild-composer/internal/audit"
 	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/events"
 	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/logging"
 	"github.com/osbuild/osbuild-composer/internal/osbuild"
 	"github.com/osbuild/osbuild-composer/internal/target"
 )
 
 type Server struct {
-	logger      *log.Logger
+	logger      *logging.Logger
 	jobs        jobqueue.JobQueue
 	router      *httprouter.Router
 	imageWriter WriteImageFunc
+	uploadDir   string
+
+	checkpointWriter WriteCheckpointFunc
+	uploadsRecorder  RecordUploadsFunc
+	uploaderRecorder RecordUploaderFunc
+	finisherRecorder RecordFinisherFunc
+	imageSize        ImageSizeFunc
+	imageFilename    ImageFilenameFunc
+	pullRate         int64
+	conversionInput  ConversionInputFunc
+	payload          PayloadFunc
+
+	uploadsMutex sync.Mutex
+	uploads      map[string]*sync.Mutex
+
+	// Logs of running jobs, see logstream.go
+	logsMutex sync.Mutex
+	logs      map[uuid.UUID]*jobLog
+
+	// Clock offsets of the workers running jobs, see clockskew.go
+	skewsMutex sync.Mutex
+	skews      map[uuid.UUID]time.Duration
+
+	// Phases of running jobs and how long phases took, see progress.go
+	phasesMutex    sync.Mutex
+	jobPhases      map[uuid.UUID]string
+	phaseDurations map[string]time.Duration
+
+	// Workers running jobs, see queue.go
+	jobWorkersMutex sync.Mutex
+	jobWorkers      map[uuid.UUID]string
+
+	// Regions of the workers, see locality.go
+	regionsMutex sync.Mutex
+	regions      map[string]*region
+	localityWait time.Duration
+
+	// Architectures that workers registered for, see arches.go
+	archesMutex sync.Mutex
+	arches      map[string]*archWorkers
+
+	// Distros that jobs are queued for, see distros.go
+	distrosMutex sync.Mutex
+	distros      map[string]bool
+
+	scans   bool
+	signing bool
+
+	auditLog *audit.Log
+
+	// Key that requests must be signed with, and the nonces of the signed
+	// requests that were accepted, see signature.go
+	signingKey   []byte
+	noncesMutex  sync.Mutex
+	nonces       map[string]time.Time
+	noncesPruned time.Time
+
+	// See drain.go
+	drainOnce    sync.Once
+	draining     chan struct{}
+	serversMutex sync.Mutex
+	servers      []*http.Server
 }
 
-type WriteImageFunc func(composeID uuid.UUID, imageBuildID int, reader io.Reader) error
+// WriteImageFunc stores the image of an image build. `checksum` is the
+// checksum of the image that the worker sent along with it, which was
+// verified while the image was read, or "" if it didn't send any.
+type WriteImageFunc func(composeID uuid.UUID, imageBuildID int, reader io.Reader, checksum string) error
+
+// RecordUploadsFunc records the outcome of the uploads of job `jobID`.
+type RecordUploadsFunc func(jobID uuid.UUID, results []TargetResult, when time.Time) error
+
+// RecordUploaderFunc records which worker uploaded the image of an image
+// build.
+type RecordUploaderFunc func(composeID uuid.UUID, imageBuildID int, uploader compose.WorkerIdentity) error
+
+// RecordFinisherFunc records which worker finished job `jobID`.
+type RecordFinisherFunc func(jobID uuid.UUID, finisher compose.WorkerIdentity) error
+
+// NewServer creates a server for the worker API. Images that workers upload
+// in chunks are kept in `uploadDir` until they are complete. The system's
+// temporary directory is used if `uploadDir` is empty. The default logger is
+// used if `logger` is nil.
+func NewServer(logger *logging.Logger, jobs jobqueue.JobQueue, imageWriter WriteImageFunc, uploadDir string) *Server {
+	if uploadDir == "" {
+		uploadDir = os.TempDir()
+	}
+
+	if logger == nil {
+		logger = logging.Default()
+	}
 
-func NewServer(logger *log.Logger, jobs jobqueue.JobQueue, imageWriter WriteImageFunc) *Server {
 	s := &Server{
 		logger:      logger,
 		jobs:        jobs,
 		imageWriter: imageWriter,
+		uploadDir:   uploadDir,
+		uploads:     make(map[string]*sync.Mutex),
+		logs:        make(map[uuid.UUID]*jobLog),
+		skews:       make(map[uuid.UUID]time.Duration),
+
+		jobPhases:      make(map[uuid.UUID]string),
+		phaseDurations: make(map[string]time.Duration),
+		jobWorkers:     make(map[uuid.UUID]string),
+
+		regions:      make(map[string]*region),
+		localityWait: DefaultLocalityWait,
+
+		arches: make(map[string]*archWorkers),
+
+		distros: make(map[string]bool),
+
+		nonces: make(map[string]time.Time),
+
+		draining: make(chan struct{}),
 	}
 
 	s.router = httprouter.New()
@@ -42,15 +155,63 @@ func NewServer(logger *log.Logger, jobs jobqueue.JobQueue, imageWriter WriteImag
 	s.router.MethodNotAllowed = http.HandlerFunc(methodNotAllowedHandler)
 	s.router.NotFound = http.HandlerFunc(notFoundHandler)
 
+	s.router.GET(infoPath, s.infoHandler)
 	s.router.POST("/job-queue/v1/jobs", s.addJobHandler)
 	s.router.PATCH("/job-queue/v1/jobs/:job_id", s.updateJobHandler)
+	s.router.GET("/job-queue/v1/jobs/:job_id/log", s.jobLogHandler)
+	s.router.POST("/job-queue/v1/jobs/:job_id/heartbeat", s.heartbeatHandler)
 	s.router.POST("/job-queue/v1/jobs/:job_id/builds/:build_id/image", s.addJobImageHandler)
+	s.router.GET("/job-queue/v1/jobs/:job_id/builds/:build_id/image", s.jobImageUploadStatusHandler)
+	s.router.PUT("/job-queue/v1/jobs/:job_id/builds/:build_id/image", s.addJobImageChunkHandler)
+	s.router.POST("/job-queue/v1/jobs/:job_id/builds/:build_id/image/pull", s.pullJobImageHandler)
+	s.router.POST("/job-queue/v1/jobs/:job_id/builds/:build_id/checkpoints/:name", s.addJobCheckpointHandler)
+	s.router.GET("/job-queue/v1/jobs/:job_id/payload", s.jobPayloadHandler)
+	s.router.GET("/job-queue/v1/composes/:compose_id/inputs/:input", s.conversionInputHandler)
 
 	return s
 }
 
+// SetUploadsRecorder sets the function which is called with the outcome of
+// each upload that workers report when they finish a job.
+func (s *Server) SetUploadsRecorder(uploadsRecorder RecordUploadsFunc) {
+	s.uploadsRecorder = uploadsRecorder
+}
+
+// SetWorkerRecorders sets the functions which are called with the identity
+// of the worker that uploaded an image or finished a job, so that the origin
+// of images can be traced back to the workers that built them.
+func (s *Server) SetWorkerRecorders(uploaderRecorder RecordUploaderFunc, finisherRecorder RecordFinisherFunc) {
+	s.uploaderRecorder = uploaderRecorder
+	s.finisherRecorder = finisherRecorder
+}
+
+// SetAuditLog records in `log` when workers take jobs and change their
+// status. Other requests, like heartbeats and image uploads, are not
+// recorded.
+func (s *Server) SetAuditLog(log *audit.Log) {
+	s.auditLog = log
+}
+
+// isJobTransition returns true if `request` takes a job or changes its
+// status.
+func isJobTransition(request *http.Request) bool {
+	return (request.Method == http.MethodPost && request.URL.Path == "/job-queue/v1/jobs") ||
+		(request.Method == http.MethodPatch && strings.HasPrefix(request.URL.Path, "/job-queue/v1/jobs/"))
+}
+
+// auditActor returns the subject of the worker's certificate, or its address
+// if it didn't present one.
+func auditActor(request *http.Request) string {
+	identity := workerIdentity(request)
+	if identity.Fingerprint == "" {
+		return request.RemoteAddr
+	}
+	return identity.Subject
+}
+
 func (s *Server) Serve(listener net.Listener) error {
-	server := http.Server{Handler: s}
+	server := &http.Server{Handler: s}
+	s.addHTTPServer(server)
 
 	err := server.Serve(listener)
 	if err != nil && err != http.ErrServerClosed {
@@ -60,22 +221,360 @@ func (s *Server) Serve(listener net.Listener) error {
 	return nil
 }
 
-func (s *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	if s.logger != nil {
-		log.Println(request.Method, request.URL.Path)
+// statusRecorder remembers the status code of a response, for logging.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Hijack allows handlers to take over the connection, which is needed for
+// websockets.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection cannot be hijacked")
 	}
+	r.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
 
+// ServeHTTP logs every request with an id, which is also sent back to the
+// client in the X-Request-Id header. Handlers log with the logger in the
+// request's context, so that their records carry the same id.
+func (s *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	requestID := logging.RequestID(request)
+	logger := s.logger.With("request_id", requestID)
+	request = request.WithContext(logging.NewContext(request.Context(), logger))
+
+	writer.Header().Set(logging.RequestIDHeader, requestID)
 	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
-	s.router.ServeHTTP(writer, request)
+
+	start := time.Now()
+	recorder := &statusRecorder{writer, http.StatusOK}
+	var signatureErr *common.APIError
+	// Workers learn from the info whether they need to sign requests
+	if s.signingKey != nil && request.URL.Path != infoPath {
+		signatureErr = s.verifySignature(request)
+	}
+	switch {
+	case signatureErr != nil:
+		signatureErr.WriteJSON(recorder)
+	case s.auditLog != nil && isJobTransition(request):
+		audit.Handler(s.auditLog, s.router, auditActor).ServeHTTP(recorder, request)
+	default:
+		s.router.ServeHTTP(recorder, request)
+	}
+
+	fields := []interface{}{
+		"method", request.Method,
+		"path", request.URL.Path,
+		"remote", request.RemoteAddr,
+		"status", recorder.status,
+		"duration", time.Since(start),
+	}
+	if recorder.status >= http.StatusInternalServerError {
+		logger.Error("request failed", fields...)
+	} else {
+		logger.Debug("request", fields...)
+	}
 }
 
-func (s *Server) Enqueue(manifest *osbuild.Manifest, targets []*target.Target) (uuid.UUID, error) {
+// Enqueue adds an osbuild job for `manifest`, which was created for `distro`
+// and `arch`. Workers use the distro to pick a matching osbuild, and only
+// receive jobs for architectures they can build. Jobs with a higher
+// `priority` are handed to workers first (see jobqueue.PriorityNormal and
+// PriorityHigh). Workers close to `targets` are preferred (see
+// locality.go).
+//
+// The job isn't run before the jobs in `dependencies` have finished, and the
+// worker receives their results as the job's inputs. This allows building
+// images from the output of other images, e.g., an installer that embeds an
+// ostree commit.
+//
+// No jobs are queued after Drain() was called, ErrDraining is returned
+// instead.
+func (s *Server) Enqueue(distro, arch string, manifest *osbuild.Manifest, targets []*target.Target, dependencies []uuid.UUID, priority int) (uuid.UUID, error) {
+	return s.EnqueueForTenant("", distro, arch, manifest, targets, dependencies, priority)
+}
+
+// EnqueueForTenant is like Enqueue, but for a compose of `tenant`.
+func (s *Server) EnqueueForTenant(tenant, distro, arch string, manifest *osbuild.Manifest, targets []*target.Target, dependencies []uuid.UUID, priority int) (uuid.UUID, error) {
+	if s.Draining() {
+		return uuid.Nil, ErrDraining
+	}
+
 	job := OSBuildJob{
-		Manifest: manifest,
-		Targets:  targets,
+		Version:      JobVersion,
+		Distro:       distro,
+		Arch:         arch,
+		Manifest:     manifest,
+		Targets:      targets,
+		Dependencies: dependencies,
+		Tenant:       tenant,
+	}
+
+	if distro != "" {
+		s.addDistro(distro)
+	}
+
+	return s.jobs.Enqueue(s.jobTypeForTargets(arch, distro, targets), job, dependencies, priority)
+}
+
+// jobInputs returns the results of the jobs that `job` depends on, which
+// have all finished when it is dequeued.
+func (s *Server) jobInputs(job *OSBuildJob) ([]JobInput, error) {
+	var inputs []JobInput
+	for _, id := range job.Dependencies {
+		var result OSBuildJobResult
+		status, _, _, _, err := s.jobs.JobStatus(id, &result)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get result of dependency %s: %v", id, err)
+		}
+		if status != jobqueue.JobFinished {
+			return nil, fmt.Errorf("dependency %s has not finished", id)
+		}
+		inputs = append(inputs, JobInput{JobId: id, Result: result})
+	}
+	return inputs, nil
+}
+
+// osbuildJobType returns the job type of osbuild jobs for `arch` and
+// `distro`. Each architecture and distro has its own type, so that the job
+// queue only hands jobs to workers which can build them (see distros.go).
+func osbuildJobType(arch, distro string) string {
+	if distro == "" {
+		return "osbuild:" + arch
+	}
+	return "osbuild:" + arch + "/" + distro
+}
+
+// ScanJobType is the job type of vulnerability scans. Scans are not run by
+// workers, but by a scanner that dequeues them from the same job queue.
+const ScanJobType = "scan"
+
+// EnableScans makes EnqueueScan() add scan jobs. Only enable scans when
+// something dequeues jobs of ScanJobType, or composes never finish.
+func (s *Server) EnableScans() {
+	s.scans = true
+}
+
+// EnqueueScan adds a job which scans the image of an image build after its
+// osbuild job `buildJobId` is done. It returns uuid.Nil if scans are not
+// enabled.
+func (s *Server) EnqueueScan(buildJobId, composeID uuid.UUID, imageBuildID int) (uuid.UUID, error) {
+	if !s.scans {
+		return uuid.Nil, nil
+	}
+
+	job := ScanJob{
+		ComposeID:    composeID,
+		ImageBuildID: imageBuildID,
+	}
+
+	return s.jobs.Enqueue(ScanJobType, job, []uuid.UUID{buildJobId}, jobqueue.PriorityNormal)
+}
+
+// SignJobType is the job type of image signing. Like scans, signing jobs are
+// run by a signer that dequeues them from the same job queue.
+const SignJobType = "sign"
+
+// EnableSigning makes EnqueueSigning() add signing jobs. Only enable signing
+// when something dequeues jobs of SignJobType, or composes never finish.
+func (s *Server) EnableSigning() {
+	s.signing = true
+}
+
+// EnqueueSigning adds a job which signs the image of an image build after
+// its osbuild job `buildJobId` is done. It returns uuid.Nil if signing is not
+// enabled.
+func (s *Server) EnqueueSigning(buildJobId, composeID uuid.UUID, imageBuildID int) (uuid.UUID, error) {
+	if !s.signing {
+		return uuid.Nil, nil
+	}
+
+	job := SignJob{
+		ComposeID:    composeID,
+		ImageBuildID: imageBuildID,
+	}
+
+	return s.jobs.Enqueue(SignJobType, job, []uuid.UUID{buildJobId}, jobqueue.PriorityNormal)
+}
+
+// PromoteJobType is the job type of promotions. Like scans, they are not run
+// by workers, because the image they upload is stored by composer.
+const PromoteJobType = "promote"
+
+// EnqueuePromotion adds a job which uploads the image of a finished image
+// build to `targets`, which belong to promotion stage `stage`.
+func (s *Server) EnqueuePromotion(composeID uuid.UUID, imageBuildID int, stage string, targets []*target.Target) (uuid.UUID, error) {
+	if s.Draining() {
+		return uuid.Nil, ErrDraining
+	}
+
+	job := PromoteJob{
+		ComposeID:    composeID,
+		ImageBuildID: imageBuildID,
+		Stage:        stage,
+		Targets:      targets,
+	}
+
+	return s.jobs.Enqueue(PromoteJobType, job, nil, jobqueue.PriorityNormal)
+}
+
+// ScanResult returns the result of scan job `id`, or nil if it hasn't
+// finished yet.
+func (s *Server) ScanResult(id uuid.UUID) (*ScanJobResult, error) {
+	var result ScanJobResult
+	status, _, _, _, err := s.jobs.JobStatus(id, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != jobqueue.JobFinished {
+		return nil, nil
+	}
+
+	return &result, nil
+}
+
+// SignResult returns the result of signing job `id`, or nil if it hasn't
+// finished yet.
+func (s *Server) SignResult(id uuid.UUID) (*SignJobResult, error) {
+	var result SignJobResult
+	status, _, _, _, err := s.jobs.JobStatus(id, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != jobqueue.JobFinished {
+		return nil, nil
+	}
+
+	return &result, nil
+}
+
+// ComposeState returns the state of a compose, which is aggregated from the
+// states of its image builds: it is waiting until any of them started,
+// running until all of them are done, and failed if any of them failed. It
+// was queued when its first image build was queued and started when its
+// first image build started, and it finished when its last image build
+// finished.
+func (s *Server) ComposeState(c compose.Compose) (state common.ComposeState, queued, started, finished time.Time) {
+	if len(c.ImageBuilds) == 0 {
+		return
+	}
+
+	var waiting, running, failed, done int
+	for i := range c.ImageBuilds {
+		ibState, ibQueued, ibStarted, ibFinished := s.ImageBuildState(c, i)
+		switch ibState {
+		case common.CWaiting:
+			waiting++
+		case common.CRunning:
+			running++
+		case common.CFailed:
+			failed++
+			done++
+		case common.CFinished:
+			done++
+		}
+
+		if queued.IsZero() || (!ibQueued.IsZero() && ibQueued.Before(queued)) {
+			queued = ibQueued
+		}
+		if started.IsZero() || (!ibStarted.IsZero() && ibStarted.Before(started)) {
+			started = ibStarted
+		}
+		if ibFinished.After(finished) {
+			finished = ibFinished
+		}
 	}
 
-	return s.jobs.Enqueue("osbuild", job, nil)
+	switch {
+	case done == len(c.ImageBuilds) && failed > 0:
+		state = common.CFailed
+	case done == len(c.ImageBuilds):
+		state = common.CFinished
+	case waiting == len(c.ImageBuilds):
+		state = common.CWaiting
+	default:
+		state = common.CRunning
+	}
+	if state != common.CFinished && state != common.CFailed {
+		finished = time.Time{}
+	}
+
+	return
+}
+
+// ImageBuildState returns the state of an image build, which is determined
+// by its jobs. An image build whose image is being scanned, signed, or
+// uploaded by a conversion's upload job is still running, and one whose scan
+// violated the policy, whose image could not be signed, or whose upload job
+// failed has failed.
+func (s *Server) ImageBuildState(c compose.Compose, imageBuildID int) (state common.ComposeState, queued, started, finished time.Time) {
+	ib := c.ImageBuilds[imageBuildID]
+
+	// backwards compatibility: composes that were around before splitting
+	// the job queue from the store still contain their valid status and
+	// times. Return those here as a fallback.
+	if ib.JobId == uuid.Nil {
+		switch ib.QueueStatus {
+		case common.IBWaiting:
+			state = common.CWaiting
+		case common.IBRunning:
+			state = common.CRunning
+		case common.IBFinished:
+			state = common.CFinished
+		case common.IBFailed:
+			state = common.CFailed
+		}
+		queued = ib.JobCreated
+		started = ib.JobStarted
+		finished = ib.JobFinished
+		return
+	}
+
+	state, queued, started, finished, _ = s.JobStatus(ib.JobId)
+
+	if state == common.CFinished && ib.ScanJobId != uuid.Nil {
+		var scanStatus jobqueue.JobStatus
+		var scan ScanJobResult
+		scanStatus, _, _, finished, _ = s.jobs.JobStatus(ib.ScanJobId, &scan)
+		if scanStatus != jobqueue.JobFinished {
+			state = common.CRunning
+		} else if scan.PolicyViolated {
+			state = common.CFailed
+		}
+	}
+
+	if state == common.CFinished && ib.SignJobId != uuid.Nil {
+		var signStatus jobqueue.JobStatus
+		var sign SignJobResult
+		signStatus, _, _, finished, _ = s.jobs.JobStatus(ib.SignJobId, &sign)
+		if signStatus != jobqueue.JobFinished {
+			state = common.CRunning
+		} else if sign.Error != "" {
+			state = common.CFailed
+		}
+	}
+
+	if state == common.CFinished && ib.UploadJobId != uuid.Nil {
+		var uploadState common.ComposeState
+		uploadState, _, _, finished, _ = s.JobStatus(ib.UploadJobId)
+		if uploadState == common.CWaiting {
+			state = common.CRunning
+		} else {
+			state = uploadState
+		}
+	}
+
+	return
 }
 
 func (s *Server) JobStatus(id uuid.UUID) (state common.ComposeState, queued, started, finished time.Time, err error) {
@@ -102,118 +601,324 @@ func (s *Server) JobResult(id uuid.UUID) (common.ComposeState, *common.ComposeRe
 }
 
 // jsonErrorf() is similar to http.Error(), but returns the message in a json
-// object with a "message" field.
-func jsonErrorf(writer http.ResponseWriter, code int, message string, args ...interface{}) {
-	writer.WriteHeader(code)
-
-	// ignore error, because we cannot do anything useful with it
-	_ = json.NewEncoder(writer).Encode(&errorResponse{
-		Message: fmt.Sprintf(message, args...),
-	})
+// object with "code" and "message" fields. The status code is derived from
+// `code`.
+func jsonErrorf(writer http.ResponseWriter, code common.APIErrorCode, message string, args ...interface{}) {
+	common.NewAPIError(code, message, args...).WriteJSON(writer)
 }
 
 func methodNotAllowedHandler(writer http.ResponseWriter, request *http.Request) {
-	jsonErrorf(writer, http.StatusMethodNotAllowed, "method not allowed")
+	jsonErrorf(writer, common.ErrorMethodNotAllowed, "method not allowed")
 }
 
 func notFoundHandler(writer http.ResponseWriter, request *http.Request) {
-	jsonErrorf(writer, http.StatusNotFound, "not found")
+	jsonErrorf(writer, common.ErrorNotFound, "not found")
 }
 
 func (s *Server) addJobHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
 	contentType := request.Header["Content-Type"]
 	if len(contentType) != 1 || contentType[0] != "application/json" {
-		jsonErrorf(writer, http.StatusUnsupportedMediaType, "request must contain application/json data")
+		jsonErrorf(writer, common.ErrorUnsupportedMediaType, "request must contain application/json data")
 		return
 	}
 
 	var body addJobRequest
 	err := json.NewDecoder(request.Body).Decode(&body)
 	if err != nil {
-		jsonErrorf(writer, http.StatusBadRequest, "%v", err)
+		jsonErrorf(writer, common.ErrorInvalidRequest, "%v", err)
 		return
 	}
 
-	var job OSBuildJob
-	id, err := s.jobs.Dequeue(request.Context(), []string{"osbuild"}, &job)
+	if len(body.Arches) == 0 {
+		jsonErrorf(writer, common.ErrorInvalidRequest, "workers must advertise at least one architecture")
+		return
+	}
+
+	response, err := s.assignJob(request.Context(), &body, request.RemoteAddr)
+	if err == errRetry {
+		// The worker asks again
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
 	if err != nil {
-		jsonErrorf(writer, http.StatusInternalServerError, "%v", err)
+		jsonErrorf(writer, common.ErrorInternal, "%v", err)
 		return
 	}
 
 	writer.WriteHeader(http.StatusCreated)
 	// FIXME: handle or comment this possible error
-	_ = json.NewEncoder(writer).Encode(addJobResponse{
+	_ = json.NewEncoder(writer).Encode(response)
+}
+
+// errRetry is returned by assignJob when the worker should ask for a job
+// again, with an updated list of job types or because the job it got could
+// not be run.
+var errRetry = errors.New("no job, ask again")
+
+// assignJob waits for a job that the worker at `workerAddr`, which asked for
+// it with `body`, can run, until `ctx` is done. The job is assigned to the
+// worker.
+func (s *Server) assignJob(ctx context.Context, body *addJobRequest, workerAddr string) (*addJobResponse, error) {
+	parent := ctx
+
+	// Workers ask again until composer is gone
+	if s.Draining() {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(drainPollInterval):
+			return nil, errRetry
+		}
+	}
+
+	jobTypes, repoll, done := s.workerWaiting(body.Region, body.Arches, body.Distros)
+	defer done()
+	defer s.registerWorker(body.Arches)()
+	if body.Conversions {
+		jobTypes = append(jobTypes, ConvertJobType)
+	}
+
+	if repoll {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, localityRepoll)
+		defer cancel()
+	}
+
+	// Stop waiting for a job when composer starts draining
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.draining:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var job OSBuildJob
+	id, err := s.jobs.Dequeue(ctx, jobTypes, &job)
+	if (err == context.DeadlineExceeded || err == context.Canceled) && parent.Err() == nil {
+		return nil, errRetry
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	workerVersion := body.JobVersion
+	if workerVersion == 0 {
+		workerVersion = 1
+	}
+	if required := job.requiredVersion(); required > workerVersion {
+		s.failJob(parent, id, fmt.Sprintf("the job needs a worker which supports version %d of jobs, but the worker at %s only supports version %d", required, workerAddr, workerVersion))
+		return nil, errRetry
+	}
+
+	inputs, err := s.jobInputs(&job)
+	if err != nil {
+		return nil, err
+	}
+	s.setJobPhase(id, &job)
+	s.setJobWorker(id, workerAddr)
+
+	logging.FromContext(parent).Info("job assigned", "job_id", id, "worker", workerAddr, "region", body.Region, "distro", job.Distro, "arch", job.Arch, "tenant", job.Tenant)
+	events.Emit(events.JobAssigned, fmt.Sprintf("Job %s assigned to worker %s", id, workerAddr),
+		"JOB_ID", id.String(),
+		"WORKER", workerAddr,
+		"DISTRO", job.Distro,
+		"ARCH", job.Arch)
+
+	return &addJobResponse{
 		Id:       id,
+		Version:  JobVersion,
+		Distro:   job.Distro,
+		Arch:     job.Arch,
 		Manifest: job.Manifest,
 		Targets:  job.Targets,
-	})
+
+		Conversion: job.Conversion,
+		Inputs:     inputs,
+	}, nil
 }
 
 func (s *Server) updateJobHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
 	contentType := request.Header["Content-Type"]
 	if len(contentType) != 1 || contentType[0] != "application/json" {
-		jsonErrorf(writer, http.StatusUnsupportedMediaType, "request must contain application/json data")
+		jsonErrorf(writer, common.ErrorUnsupportedMediaType, "request must contain application/json data")
 		return
 	}
 
 	id, err := uuid.Parse(params.ByName("job_id"))
 	if err != nil {
-		jsonErrorf(writer, http.StatusBadRequest, "cannot parse compose id: %v", err)
+		jsonErrorf(writer, common.ErrorInvalidRequest, "cannot parse compose id: %v", err)
 		return
 	}
 
 	var body updateJobRequest
 	err = json.NewDecoder(request.Body).Decode(&body)
 	if err != nil {
-		jsonErrorf(writer, http.StatusBadRequest, "cannot parse request body: %v", err)
+		jsonErrorf(writer, common.ErrorInvalidRequest, "cannot parse request body: %v", err)
+		return
+	}
+
+	if body.Version > JobVersion {
+		jsonErrorf(writer, common.ErrorInvalidRequest, "unsupported version %d of job results, only versions up to %d are supported", body.Version, JobVersion)
+		return
+	}
+
+	// Workers report the progress of running jobs by setting their
+	// status to running again
+	if body.Status == common.IBRunning {
+		if body.Progress == nil {
+			jsonErrorf(writer, common.ErrorInvalidRequest, "running jobs can only be updated with their progress")
+			return
+		}
+
+		err = s.setJobProgress(id, body.Progress)
+		if err != nil {
+			switch err {
+			case jobqueue.ErrNotExist:
+				jsonErrorf(writer, common.ErrorJobNotFound, "job does not exist: %s", id)
+			case jobqueue.ErrNotRunning:
+				jsonErrorf(writer, common.ErrorJobNotRunning, "job is not running: %s", id)
+			case errInvalidProgress:
+				jsonErrorf(writer, common.ErrorInvalidRequest, "%v", err)
+			default:
+				jsonErrorf(writer, common.ErrorInternal, "%v", err)
+			}
+			return
+		}
+
+		_ = json.NewEncoder(writer).Encode(updateJobResponse{})
 		return
 	}
 
-	// The jobqueue doesn't support setting the status before a job is
-	// finished. This branch should never be hit, because the worker
-	// doesn't attempt this. Change the API to remove this awkwardness.
 	if body.Status != common.IBFinished && body.Status != common.IBFailed {
-		jsonErrorf(writer, http.StatusBadRequest, "setting status of a job to waiting or running is not supported")
+		jsonErrorf(writer, common.ErrorInvalidRequest, "setting status of a job to waiting is not supported")
 		return
 	}
 
-	err = s.jobs.FinishJob(id, OSBuildJobResult{OSBuildOutput: body.Result})
+	logger := logging.FromContext(request.Context())
+
+	// Prefer the worker's current time over the offset of its last
+	// heartbeat, which might not have been sent at all.
+	skew := s.clockSkew(id)
+	if !body.Time.IsZero() {
+		skew = checkClockSkew(logger, id, body.Time)
+	}
+	defer s.forgetClockSkew(id)
+
+	// Errors are reported by FinishJob() below
+	_, _, jobStarted, _, _ := s.jobs.JobStatus(id, &json.RawMessage{})
+	buildStarted, buildFinished := normalizeBuildTimes(body.BuildStarted, body.BuildFinished, skew, jobStarted, time.Now())
+
+	err = s.jobs.FinishJob(id, OSBuildJobResult{
+		Version:       JobVersion,
+		OSBuildOutput: body.Result,
+		TargetResults: body.TargetResults,
+		BuildStarted:  buildStarted,
+		BuildFinished: buildFinished,
+		Error:         body.Error,
+	})
 	if err != nil {
 		switch err {
 		case jobqueue.ErrNotExist:
-			jsonErrorf(writer, http.StatusNotFound, "job does not exist: %s", id)
+			jsonErrorf(writer, common.ErrorJobNotFound, "job does not exist: %s", id)
 		case jobqueue.ErrNotRunning:
-			jsonErrorf(writer, http.StatusBadRequest, "job is not running: %s", id)
+			jsonErrorf(writer, common.ErrorJobNotRunning, "job is not running: %s", id)
 		default:
-			jsonErrorf(writer, http.StatusInternalServerError, "%v", err)
+			jsonErrorf(writer, common.ErrorInternal, "%v", err)
 		}
 		return
 	}
 
+	s.closeJobLog(id)
+
+	s.forgetJobWorker(id)
+	phase := s.forgetJobPhase(id)
+	if phase != "" && body.Status == common.IBFinished && !jobStarted.IsZero() {
+		s.RecordPhaseDuration(phase, time.Since(jobStarted))
+	}
+
+	if s.uploadsRecorder != nil && len(body.TargetResults) > 0 {
+		err = s.uploadsRecorder(id, body.TargetResults, time.Now())
+		if err != nil {
+			logger.Warning("cannot record uploads", "job_id", id, "error", err)
+		}
+	}
+
+	if s.finisherRecorder != nil {
+		err = s.finisherRecorder(id, workerIdentity(request))
+		if err != nil {
+			logger.Warning("cannot record worker", "job_id", id, "error", err)
+		}
+	}
+
+	logger.Info("job updated", "job_id", id, "status", body.Status.ToString())
+	if body.Status == common.IBFinished {
+		events.Emit(events.JobFinished, fmt.Sprintf("Job %s finished", id), "JOB_ID", id.String())
+	} else {
+		events.Emit(events.JobFailed, fmt.Sprintf("Job %s failed", id), "JOB_ID", id.String())
+	}
+
 	_ = json.NewEncoder(writer).Encode(updateJobResponse{})
 }
 
-func (s *Server) addJobImageHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
-	id, err := uuid.Parse(params.ByName("job_id"))
+// failJob fails job `id`, which a worker took but cannot run, because of
+// `message`.
+func (s *Server) failJob(ctx context.Context, id uuid.UUID, message string) {
+	logger := logging.FromContext(ctx)
+
+	err := s.jobs.FinishJob(id, OSBuildJobResult{
+		Version:       JobVersion,
+		OSBuildOutput: &common.ComposeResult{},
+		Error:         message,
+	})
 	if err != nil {
-		jsonErrorf(writer, http.StatusBadRequest, "cannot parse compose id: %v", err)
+		logger.Warning("cannot fail job", "job_id", id, "error", err)
 		return
 	}
 
-	imageBuildId, err := strconv.Atoi(params.ByName("build_id"))
+	logger.Info("job failed", "job_id", id, "error", message)
+	events.Emit(events.JobFailed, fmt.Sprintf("Job %s failed", id), "JOB_ID", id.String())
+}
+
+func (s *Server) addJobImageHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	id, imageBuildId, ok := parseImageParams(writer, params)
+	if !ok {
+		return
+	}
+
+	maxSize, err := s.maxImageSize(id, imageBuildId)
 	if err != nil {
-		jsonErrorf(writer, http.StatusBadRequest, "cannot parse image build id: %v", err)
+		jsonErrorf(writer, common.ErrorInternal, "%v", err)
 		return
 	}
 
-	if s.imageWriter == nil {
-		_, err = io.Copy(ioutil.Discard, request.Body)
-	} else {
-		err = s.imageWriter(id, imageBuildId, request.Body)
+	var body io.Reader = request.Body
+	if maxSize != sizeUnknown {
+		if request.ContentLength > maxSize {
+			jsonErrorf(writer, common.ErrorImageTooLarge, "image must not be larger than %d bytes", maxSize)
+			return
+		}
+		// The content length is unknown for chunked requests
+		body = &limitedReader{request.Body, maxSize}
 	}
+
+	checksum, err := parseDigestHeader(request.Header.Get("Digest"))
 	if err != nil {
-		jsonErrorf(writer, http.StatusInternalServerError, "%v", err)
+		jsonErrorf(writer, common.ErrorInvalidRequest, "%v", err)
+		return
+	}
+
+	err = s.writeImage(logging.FromContext(request.Context()), id, imageBuildId, body, checksum, workerIdentity(request))
+	if err == errImageTooLarge {
+		jsonErrorf(writer, common.ErrorImageTooLarge, "image must not be larger than %d bytes", maxSize)
+	} else if _, ok := err.(*formatMismatchError); ok {
+		jsonErrorf(writer, common.ErrorImageFormatMismatch, "%v", err)
+	} else if _, ok := err.(*checksumMismatchError); ok {
+		jsonErrorf(writer, common.ErrorImageChecksumMismatch, "%v", err)
+	} else if err != nil {
+		jsonErrorf(writer, common.ErrorInternal, "%v", err)
 	}
 }
 
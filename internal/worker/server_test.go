@@ -1,14 +1,39 @@
 package worker_test
 
 import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
 
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/distro"
 	"github.com/osbuild/osbuild-composer/internal/distro/fedoratest"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue/fsjobqueue"
 	"github.com/osbuild/osbuild-composer/internal/jobqueue/testjobqueue"
+	"github.com/osbuild/osbuild-composer/internal/logging"
+	"github.com/osbuild/osbuild-composer/internal/osbuild"
+	"github.com/osbuild/osbuild-composer/internal/target"
 	"github.com/osbuild/osbuild-composer/internal/test"
 	"github.com/osbuild/osbuild-composer/internal/worker"
 )
@@ -19,27 +44,49 @@ func TestErrors(t *testing.T) {
 		Path           string
 		Body           string
 		ExpectedStatus int
+		ExpectedCode   common.APIErrorCode
 	}{
 		// Bogus path
-		{"GET", "/foo", ``, http.StatusNotFound},
+		{"GET", "/foo", ``, http.StatusNotFound, common.ErrorNotFound},
 		// Create job with invalid body
-		{"POST", "/job-queue/v1/jobs", ``, http.StatusBadRequest},
+		{"POST", "/job-queue/v1/jobs", ``, http.StatusBadRequest, common.ErrorInvalidRequest},
+		// Create job without advertising an architecture
+		{"POST", "/job-queue/v1/jobs", `{}`, http.StatusBadRequest, common.ErrorInvalidRequest},
 		// Wrong method
-		{"GET", "/job-queue/v1/jobs", ``, http.StatusMethodNotAllowed},
+		{"GET", "/job-queue/v1/jobs", ``, http.StatusMethodNotAllowed, common.ErrorMethodNotAllowed},
 		// Update job with invalid ID
-		{"PATCH", "/job-queue/v1/jobs/foo", `{"status":"FINISHED"}`, http.StatusBadRequest},
+		{"PATCH", "/job-queue/v1/jobs/foo", `{"status":"FINISHED"}`, http.StatusBadRequest, common.ErrorInvalidRequest},
 		// Update job that does not exist, with invalid body
-		{"PATCH", "/job-queue/v1/jobs/aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa", ``, http.StatusBadRequest},
+		{"PATCH", "/job-queue/v1/jobs/aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa", ``, http.StatusBadRequest, common.ErrorInvalidRequest},
 		// Update job that does not exist
-		{"PATCH", "/job-queue/v1/jobs/aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa", `{"status":"FINISHED"}`, http.StatusNotFound},
+		{"PATCH", "/job-queue/v1/jobs/aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa", `{"status":"FINISHED"}`, http.StatusNotFound, common.ErrorJobNotFound},
 	}
 
 	for _, c := range cases {
-		server := worker.NewServer(nil, testjobqueue.New(), nil)
-		test.TestRoute(t, server, false, c.Method, c.Path, c.Body, c.ExpectedStatus, "{}", "message")
+		server := worker.NewServer(nil, testjobqueue.New(), nil, "")
+		test.TestRoute(t, server, false, c.Method, c.Path, c.Body, c.ExpectedStatus, `{"code":"`+string(c.ExpectedCode)+`"}`, "message")
 	}
 }
 
+func TestRequestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf, logging.FormatJSON, logging.LevelDebug)
+	server := worker.NewServer(logger, testjobqueue.New(), nil, "")
+
+	request := httptest.NewRequest("PATCH", "/job-queue/v1/jobs/foo", nil)
+	request.Header.Set(logging.RequestIDHeader, "test-request")
+	response := httptest.NewRecorder()
+	server.ServeHTTP(response, request)
+	require.Equal(t, "test-request", response.Header().Get(logging.RequestIDHeader))
+
+	var record map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &record)
+	require.NoError(t, err)
+	require.Equal(t, "test-request", record["request_id"])
+	require.Equal(t, "/job-queue/v1/jobs/foo", record["path"])
+	require.Equal(t, float64(http.StatusUnsupportedMediaType), record["status"])
+}
+
 func TestCreate(t *testing.T) {
 	distroStruct := fedoratest.New()
 	arch, err := distroStruct.GetArch("x86_64")
@@ -50,21 +97,25 @@ func TestCreate(t *testing.T) {
 	if err != nil {
 		t.Fatalf("error getting image type from arch")
 	}
-	server := worker.NewServer(nil, testjobqueue.New(), nil)
+	server := worker.NewServer(nil, testjobqueue.New(), nil, "")
 
-	manifest, err := imageType.Manifest(nil, nil, nil, nil, imageType.Size(0))
+	manifest, err := imageType.Manifest(nil, nil, nil, nil, distro.ImageOptions{Size: imageType.Size(0)})
 	if err != nil {
 		t.Fatalf("error creating osbuild manifest")
 	}
 
-	id, err := server.Enqueue(manifest, nil)
+	id, err := server.Enqueue(distroStruct.Name(), arch.Name(), manifest, nil, nil, jobqueue.PriorityNormal)
 	require.NoError(t, err)
 
-	test.TestRoute(t, server, false, "POST", "/job-queue/v1/jobs", `{}`, http.StatusCreated,
-		`{"id":"`+id.String()+`","manifest":{"sources":{},"pipeline":{}}}`, "created")
+	// workers only receive jobs for the architectures they advertise
+	test.TestRoute(t, server, false, "POST", "/job-queue/v1/jobs", `{"arches":["aarch64"]}`, http.StatusInternalServerError,
+		`{"code":"INTERNAL_ERROR"}`, "message")
+
+	test.TestRoute(t, server, false, "POST", "/job-queue/v1/jobs", `{"arches":["aarch64","x86_64"]}`, http.StatusCreated,
+		`{"id":"`+id.String()+`","version":2,"distro":"`+distroStruct.Name()+`","arch":"x86_64","manifest":{"sources":{},"pipeline":{}}}`, "created")
 }
 
-func testUpdateTransition(t *testing.T, from, to string, expectedStatus int) {
+func testUpdateTransition(t *testing.T, from, to string, expectedStatus int, expectedCode common.APIErrorCode) {
 	distroStruct := fedoratest.New()
 	arch, err := distroStruct.GetArch("x86_64")
 	if err != nil {
@@ -74,27 +125,32 @@ func testUpdateTransition(t *testing.T, from, to string, expectedStatus int) {
 	if err != nil {
 		t.Fatalf("error getting image type from arch")
 	}
-	server := worker.NewServer(nil, testjobqueue.New(), nil)
+	server := worker.NewServer(nil, testjobqueue.New(), nil, "")
 
 	id := uuid.Nil
 	if from != "VOID" {
-		manifest, err := imageType.Manifest(nil, nil, nil, nil, imageType.Size(0))
+		manifest, err := imageType.Manifest(nil, nil, nil, nil, distro.ImageOptions{Size: imageType.Size(0)})
 		if err != nil {
 			t.Fatalf("error creating osbuild manifest")
 		}
 
-		id, err = server.Enqueue(manifest, nil)
+		id, err = server.Enqueue(distroStruct.Name(), arch.Name(), manifest, nil, nil, jobqueue.PriorityNormal)
 		require.NoError(t, err)
 
 		if from != "WAITING" {
-			test.SendHTTP(server, false, "POST", "/job-queue/v1/jobs", `{}`)
+			test.SendHTTP(server, false, "POST", "/job-queue/v1/jobs", `{"arches":["x86_64"]}`)
 			if from != "RUNNING" {
 				test.SendHTTP(server, false, "PATCH", "/job-queue/v1/jobs/"+id.String(), `{"status":"`+from+`"}`)
 			}
 		}
 	}
 
-	test.TestRoute(t, server, false, "PATCH", "/job-queue/v1/jobs/"+id.String(), `{"status":"`+to+`"}`, expectedStatus, "{}", "message")
+	expectedJSON := "{}"
+	if expectedCode != "" {
+		expectedJSON = `{"code":"` + string(expectedCode) + `"}`
+	}
+
+	test.TestRoute(t, server, false, "PATCH", "/job-queue/v1/jobs/"+id.String(), `{"status":"`+to+`"}`, expectedStatus, expectedJSON, "message")
 }
 
 func TestUpdate(t *testing.T) {
@@ -102,31 +158,1219 @@ func TestUpdate(t *testing.T) {
 		From           string
 		To             string
 		ExpectedStatus int
+		ExpectedCode   common.APIErrorCode
 	}{
-		{"VOID", "WAITING", http.StatusBadRequest},
-		{"VOID", "RUNNING", http.StatusBadRequest},
-		{"VOID", "FINISHED", http.StatusNotFound},
-		{"VOID", "FAILED", http.StatusNotFound},
-		{"WAITING", "WAITING", http.StatusBadRequest},
-		{"WAITING", "RUNNING", http.StatusBadRequest},
-		{"WAITING", "FINISHED", http.StatusBadRequest},
-		{"WAITING", "FAILED", http.StatusBadRequest},
-		{"RUNNING", "WAITING", http.StatusBadRequest},
-		{"RUNNING", "RUNNING", http.StatusBadRequest},
-		{"RUNNING", "FINISHED", http.StatusOK},
-		{"RUNNING", "FAILED", http.StatusOK},
-		{"FINISHED", "WAITING", http.StatusBadRequest},
-		{"FINISHED", "RUNNING", http.StatusBadRequest},
-		{"FINISHED", "FINISHED", http.StatusBadRequest},
-		{"FINISHED", "FAILED", http.StatusBadRequest},
-		{"FAILED", "WAITING", http.StatusBadRequest},
-		{"FAILED", "RUNNING", http.StatusBadRequest},
-		{"FAILED", "FINISHED", http.StatusBadRequest},
-		{"FAILED", "FAILED", http.StatusBadRequest},
+		{"VOID", "WAITING", http.StatusBadRequest, common.ErrorInvalidRequest},
+		{"VOID", "RUNNING", http.StatusBadRequest, common.ErrorInvalidRequest},
+		{"VOID", "FINISHED", http.StatusNotFound, common.ErrorJobNotFound},
+		{"VOID", "FAILED", http.StatusNotFound, common.ErrorJobNotFound},
+		{"WAITING", "WAITING", http.StatusBadRequest, common.ErrorInvalidRequest},
+		{"WAITING", "RUNNING", http.StatusBadRequest, common.ErrorInvalidRequest},
+		{"WAITING", "FINISHED", http.StatusBadRequest, common.ErrorJobNotRunning},
+		{"WAITING", "FAILED", http.StatusBadRequest, common.ErrorJobNotRunning},
+		{"RUNNING", "WAITING", http.StatusBadRequest, common.ErrorInvalidRequest},
+		{"RUNNING", "RUNNING", http.StatusBadRequest, common.ErrorInvalidRequest},
+		{"RUNNING", "FINISHED", http.StatusOK, ""},
+		{"RUNNING", "FAILED", http.StatusOK, ""},
+		{"FINISHED", "WAITING", http.StatusBadRequest, common.ErrorInvalidRequest},
+		{"FINISHED", "RUNNING", http.StatusBadRequest, common.ErrorInvalidRequest},
+		{"FINISHED", "FINISHED", http.StatusBadRequest, common.ErrorJobNotRunning},
+		{"FINISHED", "FAILED", http.StatusBadRequest, common.ErrorJobNotRunning},
+		{"FAILED", "WAITING", http.StatusBadRequest, common.ErrorInvalidRequest},
+		{"FAILED", "RUNNING", http.StatusBadRequest, common.ErrorInvalidRequest},
+		{"FAILED", "FINISHED", http.StatusBadRequest, common.ErrorJobNotRunning},
+		{"FAILED", "FAILED", http.StatusBadRequest, common.ErrorJobNotRunning},
 	}
 
 	for _, c := range cases {
 		t.Log(c)
-		testUpdateTransition(t, c.From, c.To, c.ExpectedStatus)
+		testUpdateTransition(t, c.From, c.To, c.ExpectedStatus, c.ExpectedCode)
+	}
+}
+
+func TestChunkedImageUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var image bytes.Buffer
+	writeImage := func(composeID uuid.UUID, imageBuildID int, reader io.Reader, checksum string) error {
+		_, err := io.Copy(&image, reader)
+		return err
+	}
+	server := worker.NewServer(nil, testjobqueue.New(), writeImage, dir)
+
+	path := "/job-queue/v1/jobs/aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa/builds/0/image"
+
+	putChunk := func(contentRange, body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest("PUT", path, strings.NewReader(body))
+		req.Header.Set("Content-Range", contentRange)
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, req)
+
+		var reply map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&reply))
+		return resp.Code, reply
+	}
+
+	test.TestRoute(t, server, false, "GET", path, ``, http.StatusOK, `{"offset":0}`)
+
+	status, reply := putChunk("bytes 0-4/*", "octop")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(5), reply["offset"])
+	test.TestRoute(t, server, false, "GET", path, ``, http.StatusOK, `{"offset":5}`)
+
+	// chunks must not leave a gap
+	status, reply = putChunk("bytes 7-8/9", "us")
+	require.Equal(t, http.StatusConflict, status)
+	require.Equal(t, string(common.ErrorUploadOffsetMismatch), reply["code"])
+
+	// malformed content ranges
+	status, _ = putChunk("bytes 5-3/9", "us")
+	require.Equal(t, http.StatusBadRequest, status)
+	status, _ = putChunk("items 5-8/9", "us")
+	require.Equal(t, http.StatusBadRequest, status)
+
+	// the body is shorter than the range
+	status, _ = putChunk("bytes 5-8/9", "us")
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Empty(t, image.Bytes())
+
+	// overlapping chunks replace what was received before
+	status, reply = putChunk("bytes 3-8/9", "opuses")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(9), reply["offset"])
+	require.Equal(t, true, reply["complete"])
+	require.Equal(t, "octopuses", image.String())
+
+	test.TestRoute(t, server, false, "GET", path, ``, http.StatusOK, `{"offset":0}`)
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestImageChecksum(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var image bytes.Buffer
+	writeImage := func(composeID uuid.UUID, imageBuildID int, reader io.Reader, checksum string) error {
+		image.Reset()
+		_, err := io.Copy(&image, reader)
+		return err
+	}
+	server := worker.NewServer(nil, testjobqueue.New(), writeImage, dir)
+
+	path := "/job-queue/v1/jobs/aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa/builds/0/image"
+	sum := sha256.Sum256([]byte("octopuses"))
+	digest := "sha-256=" + base64.StdEncoding.EncodeToString(sum[:])
+
+	send := func(method, contentRange, digest, body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if contentRange != "" {
+			req.Header.Set("Content-Range", contentRange)
+		}
+		req.Header.Set("Digest", digest)
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, req)
+
+		var reply map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&reply)
+		return resp.Code, reply
+	}
+
+	status, _ := send("POST", "", "md5=AAAA, "+digest, "octopuses")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "octopuses", image.String())
+
+	status, reply := send("POST", "", digest, "clownfish")
+	require.Equal(t, http.StatusUnprocessableEntity, status)
+	require.Equal(t, string(common.ErrorImageChecksumMismatch), reply["code"])
+
+	status, _ = send("POST", "", "sha-256=octopus", "octopuses")
+	require.Equal(t, http.StatusBadRequest, status)
+
+	// a corrupted chunked upload starts over
+	status, _ = send("PUT", "bytes 0-3/9", digest, "octo")
+	require.Equal(t, http.StatusOK, status)
+	status, reply = send("PUT", "bytes 4-8/9", digest, "pusex")
+	require.Equal(t, http.StatusUnprocessableEntity, status)
+	require.Equal(t, string(common.ErrorImageChecksumMismatch), reply["code"])
+	test.TestRoute(t, server, false, "GET", path, ``, http.StatusOK, `{"offset":0}`)
+}
+
+func TestClientUploadImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var image bytes.Buffer
+	var imageChecksum string
+	writeImage := func(composeID uuid.UUID, imageBuildID int, reader io.Reader, checksum string) error {
+		imageChecksum = checksum
+		_, err := io.Copy(&image, reader)
+		return err
+	}
+	server := httptest.NewServer(worker.NewServer(nil, testjobqueue.New(), writeImage, dir))
+	defer server.Close()
+
+	client := worker.NewClient(strings.TrimPrefix(server.URL, "http://"), nil)
+
+	f, err := ioutil.TempFile(dir, "image-")
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteString("octopuses")
+	require.NoError(t, err)
+
+	err = client.UploadImage(uuid.New(), 0, f)
+	require.NoError(t, err)
+	require.Equal(t, "octopuses", image.String())
+	require.Equal(t, "sha256:"+sha256Hex("octopuses"), imageChecksum)
+
+	// images which can't be read twice are uploaded without checksum
+	image.Reset()
+	err = client.UploadImage(uuid.New(), 0, ioutil.NopCloser(strings.NewReader("clownfish")))
+	require.NoError(t, err)
+	require.Equal(t, "clownfish", image.String())
+	require.Empty(t, imageChecksum)
+}
+
+func TestClientOfferImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var image bytes.Buffer
+	var imageChecksum string
+	writeImage := func(composeID uuid.UUID, imageBuildID int, reader io.Reader, checksum string) error {
+		imageChecksum = checksum
+		_, err := io.Copy(&image, reader)
+		return err
+	}
+	workers := worker.NewServer(nil, testjobqueue.New(), writeImage, dir)
+	workers.SetPullRateLimit(1024 * 1024)
+	server := httptest.NewServer(workers)
+	defer server.Close()
+
+	client := worker.NewClient(strings.TrimPrefix(server.URL, "http://"), nil)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	err = client.OfferImage(uuid.New(), 0, strings.NewReader("octopuses"), listener, "")
+	require.NoError(t, err)
+	require.Equal(t, "octopuses", image.String())
+	require.Equal(t, "sha256:"+sha256Hex("octopuses"), imageChecksum)
+
+	// the image is not served anymore
+	_, err = http.Get("http://" + listener.Addr().String() + "/image")
+	require.Error(t, err)
+}
+
+func TestPullImageResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var image bytes.Buffer
+	writeImage := func(composeID uuid.UUID, imageBuildID int, reader io.Reader, checksum string) error {
+		_, err := io.Copy(&image, reader)
+		return err
+	}
+	server := worker.NewServer(nil, testjobqueue.New(), writeImage, dir)
+
+	// A worker whose connection breaks in the middle of the first response
+	var ranges []string
+	imageServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("Authorization") != "Bearer octopus" {
+			writer.WriteHeader(http.StatusForbidden)
+			return
+		}
+		ranges = append(ranges, request.Header.Get("Range"))
+		if len(ranges) == 1 {
+			writer.Header().Set("Content-Length", "10")
+			_, _ = writer.Write([]byte("octo"))
+			return
+		}
+		http.ServeContent(writer, request, "", time.Time{}, strings.NewReader("octopuses!"))
+	}))
+	defer imageServer.Close()
+
+	id := uuid.New()
+	path := "/job-queue/v1/jobs/" + id.String() + "/builds/0/image/pull"
+	test.TestRoute(t, server, false, "POST", path, `{"url":"`+imageServer.URL+`","token":"octopus","size":10}`, http.StatusOK, `{"offset":10,"complete":true}`)
+	require.Equal(t, "octopuses!", image.String())
+	require.Equal(t, []string{"", "bytes=4-"}, ranges)
+
+	// a wrong token is not retried
+	ranges = nil
+	test.TestRoute(t, server, false, "POST", path, `{"url":"`+imageServer.URL+`","token":"squid","size":10}`, http.StatusBadGateway, `{"code":"IMAGE_PULL_FAILED"}`, "message")
+	require.Empty(t, ranges)
+
+	test.TestRoute(t, server, false, "POST", path, `{"url":"file:///etc/passwd","token":"octopus","size":10}`, http.StatusBadRequest, `{"code":"INVALID_REQUEST"}`, "message")
+}
+
+func TestImageSizeLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var image bytes.Buffer
+	writeImage := func(composeID uuid.UUID, imageBuildID int, reader io.Reader, checksum string) error {
+		image.Reset()
+		_, err := io.Copy(&image, reader)
+		return err
+	}
+	server := worker.NewServer(nil, testjobqueue.New(), writeImage, dir)
+
+	// allows images of up to 11 bytes
+	server.SetImageSizeLimit(func(composeID uuid.UUID, imageBuildID int) (uint64, error) {
+		return 10, nil
+	})
+
+	path := "/job-queue/v1/jobs/aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa/builds/0/image"
+	upload := func(method, contentRange string, body io.Reader) int {
+		req := httptest.NewRequest(method, path, body)
+		if contentRange != "" {
+			req.Header.Set("Content-Range", contentRange)
+		}
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	require.Equal(t, http.StatusOK, upload("POST", "", strings.NewReader("octopuses")))
+	require.Equal(t, "octopuses", image.String())
+
+	require.Equal(t, http.StatusRequestEntityTooLarge, upload("POST", "", strings.NewReader("octopus inks")))
+
+	// the size of chunked requests is not known in advance
+	body := io.MultiReader(strings.NewReader("octopus"), strings.NewReader(" inks"))
+	require.Equal(t, http.StatusRequestEntityTooLarge, upload("POST", "", body))
+
+	require.Equal(t, http.StatusOK, upload("PUT", "bytes 0-4/*", strings.NewReader("octop")))
+	require.Equal(t, int64(5), server.PartialUploadsSize())
+	require.Equal(t, http.StatusRequestEntityTooLarge, upload("PUT", "bytes 5-11/*", strings.NewReader("us inks")))
+	require.Equal(t, http.StatusRequestEntityTooLarge, upload("PUT", "bytes 5-8/12", strings.NewReader("uses")))
+	require.Equal(t, http.StatusOK, upload("PUT", "bytes 5-8/9", strings.NewReader("uses")))
+	require.Equal(t, int64(0), server.PartialUploadsSize())
+}
+
+func TestImageFormatCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	server := worker.NewServer(nil, testjobqueue.New(), nil, dir)
+	filename := "disk.qcow2"
+	server.SetImageFormatCheck(func(composeID uuid.UUID, imageBuildID int) (string, error) {
+		return filename, nil
+	})
+
+	path := "/job-queue/v1/jobs/aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa/builds/0/image"
+	upload := func(method, contentRange, body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if contentRange != "" {
+			req.Header.Set("Content-Range", contentRange)
+		}
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, req)
+
+		var reply map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&reply)
+		return resp.Code, reply
+	}
+
+	status, _ := upload("POST", "", "QFI\xfb\x00\x00\x00\x03")
+	require.Equal(t, http.StatusOK, status)
+
+	status, reply := upload("POST", "", "octopuses")
+	require.Equal(t, http.StatusUnprocessableEntity, status)
+	require.Equal(t, string(common.ErrorImageFormatMismatch), reply["code"])
+
+	// too short to contain the magic
+	status, _ = upload("POST", "", "QFI")
+	require.Equal(t, http.StatusUnprocessableEntity, status)
+
+	// the footer of vhd images is at the end
+	filename = "disk.vhd"
+	footer := "conectix" + strings.Repeat("\x00", 504)
+	status, _ = upload("POST", "", strings.Repeat("\x00", 4096)+footer)
+	require.Equal(t, http.StatusOK, status)
+	status, _ = upload("POST", "", footer+strings.Repeat("\x00", 4096))
+	require.Equal(t, http.StatusUnprocessableEntity, status)
+
+	// raw images are not checked
+	filename = "disk.img"
+	status, _ = upload("POST", "", "octopuses")
+	require.Equal(t, http.StatusOK, status)
+
+	// chunked uploads start over when the complete image doesn't match
+	filename = "disk.qcow2"
+	status, _ = upload("PUT", "bytes 0-4/*", "octop")
+	require.Equal(t, http.StatusOK, status)
+	status, reply = upload("PUT", "bytes 5-8/9", "uses")
+	require.Equal(t, http.StatusUnprocessableEntity, status)
+	require.Equal(t, string(common.ErrorImageFormatMismatch), reply["code"])
+	test.TestRoute(t, server, false, "GET", path, ``, http.StatusOK, `{"offset":0}`)
+}
+
+func TestJobLogStreaming(t *testing.T) {
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+	manifest, err := imageType.Manifest(nil, nil, nil, nil, distro.ImageOptions{Size: imageType.Size(0)})
+	require.NoError(t, err)
+
+	workers := worker.NewServer(nil, testjobqueue.New(), nil, "")
+	server := httptest.NewServer(workers)
+	defer server.Close()
+	client := worker.NewClient(strings.TrimPrefix(server.URL, "http://"), nil)
+
+	id, err := workers.Enqueue("fedora-30", arch.Name(), manifest, nil, nil, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+
+	// attaching to a job that is not running yet waits for it
+	lines := make(chan string, 10)
+	followed := make(chan error, 1)
+	go func() {
+		followed <- workers.FollowJobLog(context.Background(), id, func(line string) error {
+			lines <- line
+			return nil
+		})
+	}()
+
+	job, err := client.AddJob([]string{arch.Name()})
+	require.NoError(t, err)
+	require.Equal(t, id, job.Id)
+
+	stream, err := client.StreamJobLog(job)
+	require.NoError(t, err)
+
+	// messages are split into lines on the server
+	_, err = stream.Write([]byte("Assembler org.osbuild.qemu: "))
+	require.NoError(t, err)
+	_, err = stream.Write([]byte("ok\nStage org.osbuild.rpm\n"))
+	require.NoError(t, err)
+	require.NoError(t, stream.Close())
+
+	require.Equal(t, "Assembler org.osbuild.qemu: ok", <-lines)
+	require.Equal(t, "Stage org.osbuild.rpm", <-lines)
+
+	// clients attaching later receive the buffered lines
+	follower := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		workers.ServeJobLog(writer, request, id)
+	}))
+	defer follower.Close()
+	ws, err := websocket.Dial(strings.Replace(follower.URL, "http://", "ws://", 1), "", follower.URL)
+	require.NoError(t, err)
+	defer ws.Close()
+
+	var line string
+	require.NoError(t, websocket.Message.Receive(ws, &line))
+	require.Equal(t, "Assembler org.osbuild.qemu: ok", line)
+	require.NoError(t, websocket.Message.Receive(ws, &line))
+	require.Equal(t, "Stage org.osbuild.rpm", line)
+
+	// finishing the job ends all streams
+	err = client.UpdateJob(job, common.IBFinished, &common.ComposeResult{Success: true}, nil)
+	require.NoError(t, err)
+	require.NoError(t, <-followed)
+	require.Equal(t, io.EOF, websocket.Message.Receive(ws, &line))
+
+	err = workers.FollowJobLog(context.Background(), id, func(string) error { return nil })
+	require.Equal(t, jobqueue.ErrNotRunning, err)
+}
+
+func TestClientUploadCheckpoint(t *testing.T) {
+	var checkpoint bytes.Buffer
+	workers := worker.NewServer(nil, testjobqueue.New(), nil, "")
+	workers.SetCheckpointWriter(func(composeID uuid.UUID, imageBuildID int, name string, reader io.Reader) error {
+		require.Equal(t, "tree", name)
+		_, err := io.Copy(&checkpoint, reader)
+		return err
+	})
+	server := httptest.NewServer(workers)
+	defer server.Close()
+	client := worker.NewClient(strings.TrimPrefix(server.URL, "http://"), nil)
+
+	err := client.UploadCheckpoint(uuid.New(), 0, "tree", strings.NewReader("tree"))
+	require.NoError(t, err)
+	require.Equal(t, "tree", checkpoint.String())
+
+	err = client.UploadCheckpoint(uuid.New(), 0, "image", strings.NewReader("image"))
+	require.Error(t, err)
+}
+
+func TestUploadsRecorder(t *testing.T) {
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+	manifest, err := imageType.Manifest(nil, nil, nil, nil, distro.ImageOptions{Size: imageType.Size(0)})
+	require.NoError(t, err)
+
+	var recorded []worker.TargetResult
+	workers := worker.NewServer(nil, testjobqueue.New(), nil, "")
+	workers.SetUploadsRecorder(func(jobID uuid.UUID, results []worker.TargetResult, when time.Time) error {
+		recorded = append(recorded, results...)
+		return nil
+	})
+	server := httptest.NewServer(workers)
+	defer server.Close()
+	client := worker.NewClient(strings.TrimPrefix(server.URL, "http://"), nil)
+
+	_, err = workers.Enqueue("fedora-30", arch.Name(), manifest, nil, nil, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+	job, err := client.AddJob([]string{arch.Name()})
+	require.NoError(t, err)
+
+	results := []worker.TargetResult{
+		{Name: "org.osbuild.aws", Profile: "AKIA@us-east-1/images", Duration: time.Minute},
+		{Name: "org.osbuild.azure", Profile: "account/images", Duration: time.Second, Error: "AuthenticationFailed"},
+	}
+	err = client.UpdateJob(job, common.IBFailed, &common.ComposeResult{Success: true}, results)
+	require.NoError(t, err)
+	require.Equal(t, results, recorded)
+}
+
+func TestClockSkew(t *testing.T) {
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+	manifest, err := imageType.Manifest(nil, nil, nil, nil, distro.ImageOptions{Size: imageType.Size(0)})
+	require.NoError(t, err)
+
+	// testjobqueue doesn't record when jobs started and finished
+	dir, err := ioutil.TempDir("", "worker-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	jobs, err := fsjobqueue.New(dir)
+	require.NoError(t, err)
+	server := worker.NewServer(nil, jobs, nil, "")
+
+	id, err := server.Enqueue("fedora-30", arch.Name(), manifest, nil, nil, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+
+	heartbeat := func(workerTime time.Time) *http.Response {
+		body, err := json.Marshal(map[string]time.Time{"time": workerTime})
+		require.NoError(t, err)
+		return test.SendHTTP(server, false, "POST", "/job-queue/v1/jobs/"+id.String()+"/heartbeat", string(body))
+	}
+
+	// only running jobs send heartbeats
+	response := heartbeat(time.Now())
+	require.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+	test.SendHTTP(server, false, "POST", "/job-queue/v1/jobs", `{"arches":["x86_64"]}`)
+
+	// the worker's clock is an hour ahead
+	response = heartbeat(time.Now().Add(time.Hour))
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	var offset struct {
+		Offset time.Duration `json:"offset"`
 	}
+	err = json.NewDecoder(response.Body).Decode(&offset)
+	require.NoError(t, err)
+	require.InDelta(t, float64(-time.Hour), float64(offset.Offset), float64(time.Minute))
+
+	// the build started before the job was handed out and finished in the
+	// future, according to composer's clock
+	workerNow := time.Now().Add(time.Hour)
+	body, err := json.Marshal(map[string]interface{}{
+		"status":         "FINISHED",
+		"result":         common.ComposeResult{Success: true},
+		"build_started":  workerNow.Add(-time.Hour - time.Minute),
+		"build_finished": workerNow.Add(time.Minute),
+	})
+	require.NoError(t, err)
+	response = test.SendHTTP(server, false, "PATCH", "/job-queue/v1/jobs/"+id.String(), string(body))
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	var result worker.OSBuildJobResult
+	_, _, started, finished, err := jobs.JobStatus(id, &result)
+	require.NoError(t, err)
+	require.False(t, result.BuildStarted.Before(started))
+	require.False(t, result.BuildFinished.After(finished))
+	require.False(t, result.BuildFinished.Before(result.BuildStarted))
+}
+
+func TestLocality(t *testing.T) {
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+	manifest, err := imageType.Manifest(nil, nil, nil, nil, distro.ImageOptions{Size: imageType.Size(0)})
+	require.NoError(t, err)
+
+	server := worker.NewServer(nil, testjobqueue.New(), nil, "")
+	targets := []*target.Target{target.NewAWSTarget(&target.AWSTargetOptions{Region: "us-east-1"})}
+
+	addJob := func(region string) (uuid.UUID, int) {
+		body := fmt.Sprintf(`{"arches":["x86_64"],"region":"%s"}`, region)
+		response := test.SendHTTP(server, false, "POST", "/job-queue/v1/jobs", body)
+		var job struct {
+			Id uuid.UUID `json:"id"`
+		}
+		_ = json.NewDecoder(response.Body).Decode(&job)
+		return job.Id, response.StatusCode
+	}
+
+	// a worker in us-east-1 asked for a job recently, so the job is
+	// reserved for it (testjobqueue fails instead of waiting for jobs)
+	_, status := addJob("us-east-1")
+	require.NotEqual(t, http.StatusCreated, status)
+	id, err := server.Enqueue("fedora-30", arch.Name(), manifest, targets, nil, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+
+	_, status = addJob("eu-west-1")
+	require.NotEqual(t, http.StatusCreated, status)
+	_, status = addJob("")
+	require.NotEqual(t, http.StatusCreated, status)
+	assigned, status := addJob("us-east-1")
+	require.Equal(t, http.StatusCreated, status)
+	require.Equal(t, id, assigned)
+
+	// without a worker in us-east-1, any worker takes it
+	server.SetLocalityWait(0)
+	id, err = server.Enqueue("fedora-30", arch.Name(), manifest, targets, nil, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+	assigned, status = addJob("eu-west-1")
+	require.Equal(t, http.StatusCreated, status)
+	require.Equal(t, id, assigned)
+}
+
+func TestDistros(t *testing.T) {
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+	manifest, err := imageType.Manifest(nil, nil, nil, nil, distro.ImageOptions{Size: imageType.Size(0)})
+	require.NoError(t, err)
+
+	jobs := testjobqueue.New()
+	workers := worker.NewServer(nil, jobs, nil, "")
+	server := httptest.NewServer(workers)
+	defer server.Close()
+	client := worker.NewClient(strings.TrimPrefix(server.URL, "http://"), nil)
+
+	fedora30, err := workers.Enqueue("fedora-30", arch.Name(), manifest, nil, nil, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+	fedora31, err := workers.Enqueue("fedora-31", arch.Name(), manifest, nil, nil, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+
+	// workers which advertise distros only get jobs of those
+	// (testjobqueue fails instead of waiting for jobs)
+	client.SetDistros([]string{"fedora-31", "fedora-32"})
+	job, err := client.AddJob([]string{arch.Name()})
+	require.NoError(t, err)
+	require.Equal(t, fedora31, job.Id)
+	require.Equal(t, "fedora-31", job.Distro)
+	_, err = client.AddJob([]string{arch.Name()})
+	require.Error(t, err)
+
+	// other workers get jobs of all distros
+	client.SetDistros(nil)
+	job, err = client.AddJob([]string{arch.Name()})
+	require.NoError(t, err)
+	require.Equal(t, fedora30, job.Id)
+
+	// after a restart, composer doesn't know the distros of jobs which are
+	// still queued until it is told
+	fedora30, err = workers.Enqueue("fedora-30", arch.Name(), manifest, nil, nil, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+	restarted := worker.NewServer(nil, jobs, nil, "")
+	response := test.SendHTTP(restarted, false, "POST", "/job-queue/v1/jobs", `{"arches":["x86_64"]}`)
+	require.NotEqual(t, http.StatusCreated, response.StatusCode)
+	restarted.SetDistros([]string{"fedora-30"})
+	response = test.SendHTTP(restarted, false, "POST", "/job-queue/v1/jobs", `{"arches":["x86_64"]}`)
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	var assigned struct {
+		Id uuid.UUID `json:"id"`
+	}
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&assigned))
+	require.Equal(t, fedora30, assigned.Id)
+}
+
+func TestConversion(t *testing.T) {
+	workers := worker.NewServer(nil, testjobqueue.New(), nil, "")
+	workers.SetConversionInput(func(composeID uuid.UUID, input string) (io.ReadCloser, int64, error) {
+		return ioutil.NopCloser(strings.NewReader(input)), int64(len(input)), nil
+	})
+	server := httptest.NewServer(workers)
+	defer server.Close()
+
+	composeID := uuid.New()
+	local := target.NewLocalTarget(&target.LocalTargetOptions{ComposeId: composeID, Filename: "disk.vhd"})
+	azure := target.NewAzureTarget(&target.AzureTargetOptions{Filename: "disk.vhd"})
+	convertJobId, uploadJobId, err := workers.EnqueueConversion(composeID, "vpc", "disk.vhd", []*target.Target{local, azure})
+	require.NoError(t, err)
+	require.NotEqual(t, uuid.Nil, uploadJobId)
+
+	c := compose.Compose{ImageBuilds: []compose.ImageBuild{{JobId: convertJobId, UploadJobId: uploadJobId}}}
+	state, _, _, _ := workers.ComposeState(c)
+	require.Equal(t, common.CWaiting, state)
+
+	// only workers which enabled conversions take them (testjobqueue fails
+	// instead of waiting for jobs)
+	client := worker.NewClient(strings.TrimPrefix(server.URL, "http://"), nil)
+	_, err = client.AddJob([]string{"x86_64"})
+	require.Error(t, err)
+
+	client.EnableConversions()
+	job, err := client.AddJob([]string{"x86_64"})
+	require.NoError(t, err)
+	require.Equal(t, convertJobId, job.Id)
+	require.Equal(t, &worker.Conversion{ComposeID: composeID, Input: worker.ConversionInputUpload, Format: "vpc", Filename: "disk.vhd"}, job.Conversion)
+	require.Len(t, job.Targets, 1)
+
+	input, err := client.DownloadConversionInput(job)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(input)
+	input.Close()
+	require.NoError(t, err)
+	require.Equal(t, worker.ConversionInputUpload, string(data))
+
+	// the upload job only runs after the image was converted
+	_, err = client.AddJob([]string{"x86_64"})
+	require.Error(t, err)
+	err = client.UpdateJob(job, common.IBFinished, &common.ComposeResult{Success: true}, nil)
+	require.NoError(t, err)
+	state, _, _, _ = workers.ComposeState(c)
+	require.Equal(t, common.CRunning, state)
+
+	job, err = client.AddJob([]string{"x86_64"})
+	require.NoError(t, err)
+	require.Equal(t, uploadJobId, job.Id)
+	require.Equal(t, worker.ConversionInputImage, job.Conversion.Input)
+	require.Equal(t, "", job.Conversion.Format)
+	require.Len(t, job.Targets, 1)
+	require.Equal(t, "org.osbuild.azure", job.Targets[0].Name)
+
+	err = client.UpdateJob(job, common.IBFailed, &common.ComposeResult{Success: false}, nil)
+	require.NoError(t, err)
+	state, _, _, _ = workers.ComposeState(c)
+	require.Equal(t, common.CFailed, state)
+}
+
+func TestComposeProgress(t *testing.T) {
+	workers := worker.NewServer(nil, testjobqueue.New(), nil, "")
+	workers.RecordPhaseDuration(worker.PhaseConvert, time.Minute)
+	workers.RecordPhaseDuration(worker.PhaseUpload, 3*time.Minute)
+	server := httptest.NewServer(workers)
+	defer server.Close()
+
+	composeID := uuid.New()
+	azure := target.NewAzureTarget(&target.AzureTargetOptions{Filename: "disk.vhd"})
+	convertJobId, uploadJobId, err := workers.EnqueueConversion(composeID, "vpc", "disk.vhd", []*target.Target{azure})
+	require.NoError(t, err)
+
+	c := compose.Compose{
+		ImageBuilds: []compose.ImageBuild{{JobId: convertJobId, UploadJobId: uploadJobId}},
+		Conversion:  &compose.Conversion{},
+	}
+	require.Equal(t, &worker.ComposeProgress{
+		Percent: 0,
+		Phases: []worker.PhaseProgress{
+			{Phase: worker.PhaseConvert, State: "WAITING", Percent: 0, Weight: 25},
+			{Phase: worker.PhaseUpload, State: "WAITING", Percent: 0, Weight: 75},
+		},
+	}, workers.ComposeProgress(c))
+
+	client := worker.NewClient(strings.TrimPrefix(server.URL, "http://"), nil)
+	client.EnableConversions()
+	job, err := client.AddJob([]string{"x86_64"})
+	require.NoError(t, err)
+	require.Equal(t, "RUNNING", workers.ComposeProgress(c).Phases[0].State)
+
+	err = client.UpdateJob(job, common.IBFinished, &common.ComposeResult{Success: true}, nil)
+	require.NoError(t, err)
+	progress := workers.ComposeProgress(c)
+	require.Equal(t, 100, progress.Phases[0].Percent)
+	require.Equal(t, "WAITING", progress.Phases[1].State)
+
+	job, err = client.AddJob([]string{"x86_64"})
+	require.NoError(t, err)
+	err = client.UpdateJob(job, common.IBFinished, &common.ComposeResult{Success: true}, nil)
+	require.NoError(t, err)
+	require.Equal(t, 100, workers.ComposeProgress(c).Percent)
+
+	// composes without jobs have no progress
+	require.Nil(t, workers.ComposeProgress(compose.Compose{}))
+}
+
+func TestJobProgress(t *testing.T) {
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+	manifest, err := imageType.Manifest(nil, nil, nil, nil, distro.ImageOptions{Size: imageType.Size(0)})
+	require.NoError(t, err)
+
+	workers := worker.NewServer(nil, testjobqueue.New(), nil, "")
+	server := httptest.NewServer(workers)
+	defer server.Close()
+	client := worker.NewClient(strings.TrimPrefix(server.URL, "http://"), nil)
+
+	id, err := workers.Enqueue("fedora-30", arch.Name(), manifest, nil, nil, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+	c := compose.Compose{ImageBuilds: []compose.ImageBuild{{JobId: id}}}
+
+	// only running jobs have progress
+	response := test.SendHTTP(workers, false, "PATCH", "/job-queue/v1/jobs/"+id.String(), `{"status":"RUNNING","progress":{"percent":10}}`)
+	require.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+	job, err := client.AddJob([]string{arch.Name()})
+	require.NoError(t, err)
+
+	progress := &worker.JobProgress{Stage: "org.osbuild.rpm", Message: "installing packages (43/212)", Percent: 30}
+	require.NoError(t, client.UpdateJobProgress(job, progress))
+	require.Equal(t, worker.PhaseProgress{
+		Phase:   worker.PhaseBuild,
+		State:   "RUNNING",
+		Percent: 30,
+		Stage:   "org.osbuild.rpm",
+		Message: "installing packages (43/212)",
+		Weight:  100,
+	}, workers.ComposeProgress(c).Phases[0])
+
+	response = test.SendHTTP(workers, false, "PATCH", "/job-queue/v1/jobs/"+id.String(), `{"status":"RUNNING","progress":{"percent":101}}`)
+	require.Equal(t, http.StatusBadRequest, response.StatusCode)
+	response = test.SendHTTP(workers, false, "PATCH", "/job-queue/v1/jobs/"+id.String(), `{"status":"RUNNING"}`)
+	require.Equal(t, http.StatusBadRequest, response.StatusCode)
+	require.Equal(t, 30, workers.ComposeProgress(c).Percent)
+
+	// finished jobs are done, whatever they reported
+	err = client.UpdateJob(job, common.IBFinished, &common.ComposeResult{Success: true}, nil)
+	require.NoError(t, err)
+	require.Equal(t, worker.PhaseProgress{Phase: worker.PhaseBuild, State: "FINISHED", Percent: 100, Weight: 100}, workers.ComposeProgress(c).Phases[0])
+	require.Error(t, client.UpdateJobProgress(job, progress))
+}
+
+func TestComposeStateOfImageBuilds(t *testing.T) {
+	distroStruct := fedoratest.New()
+	arch, err := distroStruct.GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+	manifest, err := imageType.Manifest(nil, nil, nil, nil, distro.ImageOptions{Size: imageType.Size(0)})
+	require.NoError(t, err)
+
+	workers := worker.NewServer(nil, testjobqueue.New(), nil, "")
+	server := httptest.NewServer(workers)
+	defer server.Close()
+	client := worker.NewClient(strings.TrimPrefix(server.URL, "http://"), nil)
+
+	first, err := workers.Enqueue(distroStruct.Name(), arch.Name(), manifest, nil, nil, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+	second, err := workers.Enqueue(distroStruct.Name(), arch.Name(), manifest, nil, nil, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+
+	c := compose.Compose{
+		ImageBuilds: []compose.ImageBuild{{JobId: first}, {Id: 1, JobId: second}},
+	}
+	state, _, _, _ := workers.ComposeState(c)
+	require.Equal(t, common.CWaiting, state)
+
+	// a compose is running until all of its image builds are done
+	job, err := client.AddJob([]string{"x86_64"})
+	require.NoError(t, err)
+	err = client.UpdateJob(job, common.IBFinished, &common.ComposeResult{Success: true}, nil)
+	require.NoError(t, err)
+	state, _, _, finished := workers.ComposeState(c)
+	require.Equal(t, common.CRunning, state)
+	require.True(t, finished.IsZero())
+
+	// and it fails if any of them failed
+	job, err = client.AddJob([]string{"x86_64"})
+	require.NoError(t, err)
+	err = client.UpdateJob(job, common.IBFailed, &common.ComposeResult{Success: false}, nil)
+	require.NoError(t, err)
+	state, _, _, _ = workers.ComposeState(c)
+	require.Equal(t, common.CFailed, state)
+	state, _, _, _ = workers.ImageBuildState(c, 0)
+	require.Equal(t, common.CFinished, state)
+}
+
+func TestJobDependencies(t *testing.T) {
+	workers := worker.NewServer(nil, testjobqueue.New(), nil, "")
+	server := httptest.NewServer(workers)
+	defer server.Close()
+	client := worker.NewClient(strings.TrimPrefix(server.URL, "http://"), nil)
+
+	first, err := workers.Enqueue("fedora-30", "x86_64", &osbuild.Manifest{}, nil, nil, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+	second, err := workers.Enqueue("fedora-30", "x86_64", &osbuild.Manifest{}, nil, []uuid.UUID{first}, jobqueue.PriorityHigh)
+	require.NoError(t, err)
+
+	// the dependent job waits, even though it has a higher priority
+	job, err := client.AddJob([]string{"x86_64"})
+	require.NoError(t, err)
+	require.Equal(t, first, job.Id)
+	require.Empty(t, job.Inputs)
+
+	err = client.UpdateJob(job, common.IBFinished, &common.ComposeResult{OutputID: "commit", Success: true}, nil)
+	require.NoError(t, err)
+
+	// and receives the result of the job it depends on
+	job, err = client.AddJob([]string{"x86_64"})
+	require.NoError(t, err)
+	require.Equal(t, second, job.Id)
+	require.Len(t, job.Inputs, 1)
+	require.Equal(t, first, job.Inputs[0].JobId)
+	require.Equal(t, "commit", job.Inputs[0].Result.OSBuildOutput.OutputID)
+}
+
+func TestPayload(t *testing.T) {
+	workers := worker.NewServer(nil, testjobqueue.New(), nil, "")
+	server := httptest.NewServer(workers)
+	defer server.Close()
+	client := worker.NewClient(strings.TrimPrefix(server.URL, "http://"), nil)
+
+	payload, err := workers.Enqueue("fedora-30", "x86_64", &osbuild.Manifest{}, nil, nil, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+	_, err = workers.Enqueue("fedora-30", "x86_64", &osbuild.Manifest{}, nil, []uuid.UUID{payload}, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+
+	// payloads are not supported without a source
+	job, err := client.AddJob([]string{"x86_64"})
+	require.NoError(t, err)
+	err = client.UpdateJob(job, common.IBFinished, &common.ComposeResult{Success: true}, nil)
+	require.NoError(t, err)
+	_, err = client.DownloadPayload(payload)
+	require.Error(t, err)
+
+	workers.SetPayloadSource(func(jobID uuid.UUID) (io.ReadCloser, int64, error) {
+		data := "image of " + jobID.String()
+		return ioutil.NopCloser(strings.NewReader(data)), int64(len(data)), nil
+	})
+
+	job, err = client.AddJob([]string{"x86_64"})
+	require.NoError(t, err)
+	require.Len(t, job.Inputs, 1)
+
+	reader, err := client.DownloadPayload(job.Inputs[0].JobId)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(reader)
+	reader.Close()
+	require.NoError(t, err)
+	require.Equal(t, "image of "+payload.String(), string(data))
+
+	// only finished jobs have images
+	_, err = client.DownloadPayload(job.Id)
+	require.Error(t, err)
+	_, err = client.DownloadPayload(uuid.New())
+	require.Error(t, err)
+}
+
+func TestHasWorkers(t *testing.T) {
+	workers := worker.NewServer(nil, testjobqueue.New(), nil, "")
+	server := httptest.NewServer(workers)
+	defer server.Close()
+	client := worker.NewClient(strings.TrimPrefix(server.URL, "http://"), nil)
+
+	require.False(t, workers.HasWorkers("aarch64"))
+
+	// workers register when they ask for jobs (testjobqueue fails instead
+	// of waiting for jobs)
+	_, err := client.AddJob([]string{"x86_64", "aarch64"})
+	require.Error(t, err)
+	require.True(t, workers.HasWorkers("x86_64"))
+	require.True(t, workers.HasWorkers("aarch64"))
+	require.False(t, workers.HasWorkers("ppc64le"))
+}
+
+func TestJobVersions(t *testing.T) {
+	jobs := testjobqueue.New()
+	workers := worker.NewServer(nil, jobs, nil, "")
+
+	first, err := workers.Enqueue("fedora-30", "x86_64", &osbuild.Manifest{}, nil, nil, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+	second, err := workers.Enqueue("fedora-30", "x86_64", &osbuild.Manifest{}, nil, []uuid.UUID{first}, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+
+	// workers which don't advertise a version can run jobs without
+	// dependencies
+	test.TestRoute(t, workers, false, "POST", "/job-queue/v1/jobs", `{"arches":["x86_64"]}`, http.StatusCreated,
+		`{"id":"`+first.String()+`","version":2}`, "distro", "arch", "manifest")
+	test.TestRoute(t, workers, false, "PATCH", "/job-queue/v1/jobs/"+first.String(), `{"status":"FINISHED","result":{"success":true}}`, http.StatusOK, `{}`)
+
+	// but jobs which depend on other jobs fail instead of being handed to
+	// them
+	response := test.SendHTTP(workers, false, "POST", "/job-queue/v1/jobs", `{"arches":["x86_64"]}`)
+	require.Equal(t, http.StatusNoContent, response.StatusCode)
+	var result worker.OSBuildJobResult
+	status, _, _, _, err := jobs.JobStatus(second, &result)
+	require.NoError(t, err)
+	require.Equal(t, jobqueue.JobFinished, status)
+	require.Contains(t, result.Error, "version 2")
+	state, _, _, _, err := workers.JobStatus(second)
+	require.NoError(t, err)
+	require.Equal(t, common.CFailed, state)
+
+	// results of newer versions are refused
+	third, err := workers.Enqueue("fedora-30", "x86_64", &osbuild.Manifest{}, nil, nil, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+	test.SendHTTP(workers, false, "POST", "/job-queue/v1/jobs", `{"arches":["x86_64"],"job_version":2}`)
+	test.TestRoute(t, workers, false, "PATCH", "/job-queue/v1/jobs/"+third.String(), `{"status":"FINISHED","result":{"success":true},"version":99}`, http.StatusBadRequest,
+		`{"code":"INVALID_REQUEST"}`, "message")
+}
+
+func TestJobVersionUpgrade(t *testing.T) {
+	var job worker.OSBuildJob
+	err := json.Unmarshal([]byte(`{"arch":"x86_64","manifest":null}`), &job)
+	require.NoError(t, err)
+	require.Equal(t, worker.JobVersion, job.Version)
+	require.Equal(t, "x86_64", job.Arch)
+
+	var result worker.OSBuildJobResult
+	err = json.Unmarshal([]byte(`{"osbuild_output":{"success":true}}`), &result)
+	require.NoError(t, err)
+	require.Equal(t, worker.JobVersion, result.Version)
+	require.True(t, result.OSBuildOutput.Success)
+
+	err = json.Unmarshal([]byte(`{"version":99,"manifest":null}`), &job)
+	require.IsType(t, &worker.UnsupportedVersionError{}, err)
+	err = json.Unmarshal([]byte(`{"version":99}`), &result)
+	require.IsType(t, &worker.UnsupportedVersionError{}, err)
+}
+
+func TestJobError(t *testing.T) {
+	id := uuid.New()
+	var update map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			require.Equal(t, "/job-queue/v1/info", r.URL.Path)
+			fmt.Fprintf(w, `{"api_versions":[1],"min_job_version":1,"job_version":%d}`, worker.JobVersion)
+		case "POST":
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"id":"%s","version":99,"manifest":{"future":true}}`, id)
+		case "PATCH":
+			require.Equal(t, "/job-queue/v1/jobs/"+id.String(), r.URL.Path)
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&update))
+		}
+	}))
+	defer server.Close()
+	client := worker.NewClient(strings.TrimPrefix(server.URL, "http://"), nil)
+
+	// workers fail jobs of newer versions, which they cannot read
+	_, err := client.AddJob([]string{"x86_64"})
+	jobErr, ok := err.(*worker.JobError)
+	require.True(t, ok)
+	require.Equal(t, id, jobErr.Job.Id)
+	require.IsType(t, &worker.UnsupportedVersionError{}, jobErr.Err)
+
+	err = client.FailJob(jobErr.Job, jobErr.Error())
+	require.NoError(t, err)
+	require.Equal(t, "FAILED", update["status"])
+	require.Equal(t, jobErr.Error(), update["error"])
+}
+
+func TestDrain(t *testing.T) {
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+	manifest, err := imageType.Manifest(nil, nil, nil, nil, distro.ImageOptions{Size: imageType.Size(0)})
+	require.NoError(t, err)
+
+	server := worker.NewServer(nil, testjobqueue.New(), nil, "")
+	running, err := server.Enqueue("fedora-30", arch.Name(), manifest, nil, nil, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+	_, err = server.Enqueue("fedora-30", arch.Name(), manifest, nil, nil, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+
+	response := test.SendHTTP(server, false, "POST", "/job-queue/v1/jobs", `{"arches":["x86_64"]}`)
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	require.False(t, server.Draining())
+
+	// the running job keeps Drain() waiting
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.EqualError(t, server.Drain(ctx), "1 jobs are still running")
+	require.True(t, server.Draining())
+
+	// the pending job isn't assigned anymore, and no jobs are queued
+	response = test.SendHTTP(server, false, "POST", "/job-queue/v1/jobs", `{"arches":["x86_64"]}`)
+	require.Equal(t, http.StatusNoContent, response.StatusCode)
+	_, err = server.Enqueue("fedora-30", arch.Name(), manifest, nil, nil, jobqueue.PriorityNormal)
+	require.Equal(t, worker.ErrDraining, err)
+
+	// but the running job can finish
+	response = test.SendHTTP(server, false, "PATCH", "/job-queue/v1/jobs/"+running.String(), `{"status":"FINISHED"}`)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.NoError(t, server.Drain(context.Background()))
+}
+
+func TestSignedRequests(t *testing.T) {
+	arch, err := fedoratest.New().GetArch("x86_64")
+	require.NoError(t, err)
+	imageType, err := arch.GetImageType("qcow2")
+	require.NoError(t, err)
+	manifest, err := imageType.Manifest(nil, nil, nil, nil, distro.ImageOptions{Size: imageType.Size(0)})
+	require.NoError(t, err)
+
+	key := []byte("0123456789abcdef")
+	workers := worker.NewServer(nil, testjobqueue.New(), nil, "")
+	workers.SetSigningKey(key)
+	server := httptest.NewServer(workers)
+	defer server.Close()
+
+	_, err = workers.Enqueue("fedora-30", arch.Name(), manifest, nil, nil, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+
+	// unsigned requests and requests signed with another key are rejected
+	response := test.SendHTTP(workers, false, "POST", "/job-queue/v1/jobs", `{"arches":["x86_64"]}`)
+	require.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	client := worker.NewClient(strings.TrimPrefix(server.URL, "http://"), nil)
+	client.SetSigningKey([]byte("fedcba9876543210"))
+	_, err = client.AddJob([]string{arch.Name()})
+	require.Error(t, err)
+
+	client.SetSigningKey(key)
+	job, err := client.AddJob([]string{arch.Name()})
+	require.NoError(t, err)
+	require.NoError(t, client.UpdateJob(job, common.IBFinished, &common.ComposeResult{}, nil))
+
+	// sends a request to take a job, signed with `nonce` and `content` as
+	// the hash of the body
+	send := func(nonce, content string) int {
+		request, err := http.NewRequest("POST", server.URL+"/job-queue/v1/jobs", strings.NewReader(`{"arches":["x86_64"]}`))
+		require.NoError(t, err)
+		request.Header.Set("Content-Type", "application/json")
+		date := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, key)
+		fmt.Fprintf(mac, "POST\n/job-queue/v1/jobs\n%s\n%s\n%s\n", date, nonce, content)
+		request.Header.Set("X-Composer-Date", date)
+		request.Header.Set("X-Composer-Nonce", nonce)
+		request.Header.Set("X-Composer-Content-Sha256", content)
+		request.Header.Set("X-Composer-Signature", hex.EncodeToString(mac.Sum(nil)))
+		response, err := http.DefaultClient.Do(request)
+		require.NoError(t, err)
+		response.Body.Close()
+		return response.StatusCode
+	}
+
+	// the body must match its signature
+	require.Equal(t, http.StatusUnauthorized, send("1", strings.Repeat("0", 64)))
+
+	// requests cannot be replayed
+	sum := sha256.Sum256([]byte(`{"arches":["x86_64"]}`))
+	require.NotEqual(t, http.StatusUnauthorized, send("2", hex.EncodeToString(sum[:])))
+	require.Equal(t, http.StatusUnauthorized, send("2", hex.EncodeToString(sum[:])))
+}
+
+func TestClientRetry(t *testing.T) {
+	workers := worker.NewServer(nil, testjobqueue.New(), nil, "")
+	unavailable := 2
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if unavailable > 0 {
+			unavailable--
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		workers.ServeHTTP(writer, request)
+	}))
+	defer server.Close()
+
+	client := worker.NewClient(strings.TrimPrefix(server.URL, "http://"), nil)
+	client.SetBackoff(worker.Backoff{Initial: time.Millisecond, Max: 2 * time.Millisecond})
+	version, err := client.JobVersion()
+	require.NoError(t, err)
+	require.Equal(t, worker.JobVersion, version)
+	require.Equal(t, 0, unavailable)
+
+	// requests which take jobs are not sent again when the connection
+	// broke, because composer might have handled them
+	attempts := 0
+	broken := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			workers.ServeHTTP(writer, request)
+			return
+		}
+		attempts++
+		conn, _, err := writer.(http.Hijacker).Hijack()
+		require.NoError(t, err)
+		conn.Close()
+	}))
+	defer broken.Close()
+	client = worker.NewClient(strings.TrimPrefix(broken.URL, "http://"), nil)
+	client.SetBackoff(worker.Backoff{Initial: time.Millisecond, Max: 2 * time.Millisecond, Timeout: 100 * time.Millisecond})
+	_, err = client.AddJob([]string{"x86_64"})
+	require.Error(t, err)
+	require.Equal(t, 1, attempts)
+
+	// but they are sent again when composer is unavailable
+	unavailable = 2
+	client = worker.NewClient(strings.TrimPrefix(server.URL, "http://"), nil)
+	client.SetBackoff(worker.Backoff{Initial: time.Millisecond, Max: 2 * time.Millisecond})
+	_, _ = client.AddJob([]string{"x86_64"})
+	require.Equal(t, 0, unavailable)
+
+	// requests are given up after the timeout
+	unavailable = 1000
+	client = worker.NewClient(strings.TrimPrefix(server.URL, "http://"), nil)
+	client.SetBackoff(worker.Backoff{Initial: time.Millisecond, Max: 2 * time.Millisecond, Timeout: 20 * time.Millisecond})
+	_, err = client.JobVersion()
+	require.Error(t, err)
+}
+
+func TestInfo(t *testing.T) {
+	workers := worker.NewServer(nil, testjobqueue.New(), nil, "")
+	test.TestRoute(t, workers, false, "GET", "/job-queue/v1/info", ``, http.StatusOK, fmt.Sprintf(`{"api_versions":[1],"min_job_version":1,"job_version":%d,"capabilities":["heartbeats","progress","log-stream","chunked-uploads","image-pull","checkpoints","distros"],"signed_requests":false}`, worker.JobVersion))
+
+	// the info tells workers that they need to sign requests
+	workers.SetSigningKey([]byte("0123456789abcdef"))
+	server := httptest.NewServer(workers)
+	defer server.Close()
+	client := worker.NewClient(strings.TrimPrefix(server.URL, "http://"), nil)
+	_, err := client.AddJob([]string{"x86_64"})
+	require.IsType(t, &worker.IncompatibleError{}, err)
+	require.Contains(t, err.Error(), "signing key")
+}
+
+func TestLegacyServerInfo(t *testing.T) {
+	uploadDir, err := ioutil.TempDir("", "worker-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(uploadDir)
+
+	// composers which don't serve their info only support version 1 of
+	// jobs, and none of the capabilities
+	var chunks int
+	var image []byte
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch {
+		case request.Method == "PUT":
+			chunks++
+		case request.Method == "POST" && strings.HasSuffix(request.URL.Path, "/image"):
+			image, _ = ioutil.ReadAll(request.Body)
+			return
+		}
+		http.NotFound(writer, request)
+	}))
+	defer server.Close()
+
+	client := worker.NewClient(strings.TrimPrefix(server.URL, "http://"), nil)
+	version, err := client.JobVersion()
+	require.NoError(t, err)
+	require.Equal(t, 1, version)
+
+	// images are uploaded at once instead of in chunks
+	file, err := ioutil.TempFile(uploadDir, "image")
+	require.NoError(t, err)
+	defer file.Close()
+	_, err = file.WriteString("image")
+	require.NoError(t, err)
+	_, err = file.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	require.NoError(t, client.UploadImage(uuid.New(), 0, file))
+	require.Equal(t, 0, chunks)
+	require.Equal(t, "image", string(image))
 }
@@ -0,0 +1,195 @@
+package worker
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+)
+
+// Composer and workers can share a key with which workers sign their
+// requests, for setups in which workers cannot present a certificate, or
+// to protect the job socket from other local users. Signed requests carry
+// these headers:
+//
+//	X-Composer-Date: the time the request was signed, in Unix seconds
+//	X-Composer-Nonce: a random hex string which is new for every request
+//	X-Composer-Content-Sha256: the hex sha256 of the body, or
+//	    UNSIGNED-PAYLOAD for images and checkpoints, which are too large to
+//	    be held in memory
+//	X-Composer-Signature: the hex HMAC-SHA256 of the method, the path with
+//	    the query, and the values of the other three headers, each followed
+//	    by a newline
+//
+// Composer rejects requests signed more than MaxSignatureAge ago or in the
+// future, because their signatures could have been captured. It remembers
+// the nonces of the requests it accepted until then, and rejects requests
+// whose nonce it has seen, so that captured requests cannot be replayed.
+const (
+	signatureDateHeader    = "X-Composer-Date"
+	signatureNonceHeader   = "X-Composer-Nonce"
+	signatureContentHeader = "X-Composer-Content-Sha256"
+	signatureHeader        = "X-Composer-Signature"
+
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+
+	// The content hash of requests without a body
+	emptyPayload = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// MaxSignatureAge is how far the time a request was signed at may be off.
+const MaxSignatureAge = 5 * time.Minute
+
+// Bodies of signed requests are read into memory to verify them, which is
+// why they must not be larger than this.
+const maxSignedBodySize = 16 * 1024 * 1024
+
+// ReadSigningKey reads the key with which worker requests are signed from
+// the file at `path`. Whitespace around it is ignored.
+func ReadSigningKey(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key := bytes.TrimSpace(data)
+	if len(key) < 16 {
+		return nil, fmt.Errorf("%s: the key must be at least 16 bytes long", path)
+	}
+	return key, nil
+}
+
+// SetSigningKey makes the client sign all requests with `key`.
+func (c *Client) SetSigningKey(key []byte) {
+	c.signingKey = key
+}
+
+// SetSigningKey makes the server reject requests which aren't signed with
+// `key`.
+func (s *Server) SetSigningKey(key []byte) {
+	s.signingKey = key
+}
+
+// sign adds the signature headers to `request`, whose body is `body`.
+// Requests without a body have a nil body in both. Requests whose body is
+// streamed, which are those with a body but nil `body`, are signed with
+// UNSIGNED-PAYLOAD.
+func (c *Client) sign(request *http.Request, body []byte) {
+	content := unsignedPayload
+	if request.Body == nil || body != nil {
+		sum := sha256.Sum256(body)
+		content = hex.EncodeToString(sum[:])
+	}
+	c.signHeader(request.Header, request.Method, request.URL.RequestURI(), content)
+}
+
+// signHeader adds the signature headers for a request with `method` to
+// `uri` to `header`.
+func (c *Client) signHeader(header http.Header, method, uri, content string) {
+	if c.signingKey == nil {
+		return
+	}
+
+	var b [16]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		panic(err)
+	}
+	nonce := hex.EncodeToString(b[:])
+
+	date := strconv.FormatInt(time.Now().Unix(), 10)
+	header.Set(signatureDateHeader, date)
+	header.Set(signatureNonceHeader, nonce)
+	header.Set(signatureContentHeader, content)
+	header.Set(signatureHeader, signature(c.signingKey, method, uri, date, nonce, content))
+}
+
+func signature(key []byte, method, uri, date, nonce, content string) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s\n", method, uri, date, nonce, content)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// useNonce records that a request signed at `signed` with `nonce` was
+// accepted. It returns false if a request with `nonce` was accepted before.
+// Nonces are forgotten once requests signed at the same time would be
+// rejected because of their age anyway.
+func (s *Server) useNonce(nonce string, signed time.Time) bool {
+	s.noncesMutex.Lock()
+	defer s.noncesMutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.noncesPruned) > time.Minute {
+		for n, t := range s.nonces {
+			if now.Sub(t) > MaxSignatureAge {
+				delete(s.nonces, n)
+			}
+		}
+		s.noncesPruned = now
+	}
+
+	if _, seen := s.nonces[nonce]; seen {
+		return false
+	}
+	s.nonces[nonce] = signed
+	return true
+}
+
+// verifySignature checks the signature of `request`, and returns an error
+// saying what's wrong with it if it isn't valid. Bodies which were signed are
+// read and replaced by a copy.
+func (s *Server) verifySignature(request *http.Request) *common.APIError {
+	date := request.Header.Get(signatureDateHeader)
+	nonce := request.Header.Get(signatureNonceHeader)
+	content := request.Header.Get(signatureContentHeader)
+	sig := request.Header.Get(signatureHeader)
+	if date == "" || nonce == "" || content == "" || sig == "" {
+		return common.NewAPIError(common.ErrorUnauthorized, "request is not signed")
+	}
+
+	seconds, err := strconv.ParseInt(date, 10, 64)
+	if err != nil {
+		return common.NewAPIError(common.ErrorUnauthorized, "invalid %s: %s", signatureDateHeader, date)
+	}
+	signed := time.Unix(seconds, 0)
+	if age := time.Since(signed); age > MaxSignatureAge || age < -MaxSignatureAge {
+		return common.NewAPIError(common.ErrorUnauthorized, "request was signed %v ago, check the worker's clock", age.Round(time.Second))
+	}
+
+	expected := signature(s.signingKey, request.Method, request.URL.RequestURI(), date, nonce, content)
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return common.NewAPIError(common.ErrorUnauthorized, "invalid signature")
+	}
+
+	if !s.useNonce(nonce, signed) {
+		return common.NewAPIError(common.ErrorUnauthorized, "request was replayed")
+	}
+
+	if content == unsignedPayload {
+		if !strings.HasSuffix(request.URL.Path, "/image") && !strings.Contains(request.URL.Path, "/checkpoints/") {
+			return common.NewAPIError(common.ErrorUnauthorized, "only images and checkpoints may be sent unsigned")
+		}
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, request.Body, maxSignedBodySize))
+	if err != nil {
+		return common.NewAPIError(common.ErrorInvalidRequest, "cannot read request body: %v", err)
+	}
+	sum := sha256.Sum256(body)
+	if hex.EncodeToString(sum[:]) != strings.ToLower(content) {
+		return common.NewAPIError(common.ErrorUnauthorized, "body doesn't match its signature")
+	}
+	request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return nil
+}
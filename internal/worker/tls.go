@@ -0,0 +1,101 @@
+package worker
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	"github.com/osbuild/osbuild-composer/internal/compose"
+)
+
+// TLSConfig contains the paths of the PEM files needed for mutually
+// authenticated connections between composer and workers. Both sides trust
+// certificates signed by the CA in `CACertFile`.
+type TLSConfig struct {
+	CACertFile string
+	CertFile   string
+	KeyFile    string
+}
+
+func (c *TLSConfig) load() (*x509.CertPool, tls.Certificate, error) {
+	caCertPEM, err := ioutil.ReadFile(c.CACertFile)
+	if err != nil {
+		return nil, tls.Certificate{}, err
+	}
+
+	roots := x509.NewCertPool()
+	ok := roots.AppendCertsFromPEM(caCertPEM)
+	if !ok {
+		return nil, tls.Certificate{}, errors.New("failed to append root certificate")
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, tls.Certificate{}, err
+	}
+
+	return roots, cert, nil
+}
+
+// ServerConfig returns a TLS configuration for the composer side, which
+// rejects workers that don't present a certificate signed by the CA.
+func (c *TLSConfig) ServerConfig() (*tls.Config, error) {
+	roots, cert, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    roots,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// ClientConfig returns a TLS configuration for workers, which authenticates
+// them with their certificate and verifies composer's certificate.
+func (c *TLSConfig) ClientConfig() (*tls.Config, error) {
+	roots, cert, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// ServeTLS is like Serve, but only accepts workers which authenticate with a
+// client certificate that `conf` trusts. `conf` usually comes from
+// TLSConfig.ServerConfig().
+func (s *Server) ServeTLS(listener net.Listener, conf *tls.Config) error {
+	if conf.ClientAuth != tls.RequireAndVerifyClientCert {
+		return errors.New("worker connections must be authenticated with client certificates")
+	}
+
+	return s.Serve(tls.NewListener(listener, conf))
+}
+
+// workerIdentity returns the identity of the worker that sent `request`,
+// which is taken from its client certificate. Only workers on the local
+// socket connect without one (see ServeTLS).
+func workerIdentity(request *http.Request) compose.WorkerIdentity {
+	if request.TLS == nil || len(request.TLS.PeerCertificates) == 0 {
+		return compose.WorkerIdentity{Subject: "local"}
+	}
+
+	cert := request.TLS.PeerCertificates[0]
+	fingerprint := sha256.Sum256(cert.Raw)
+	return compose.WorkerIdentity{
+		Subject:     cert.Subject.String(),
+		Fingerprint: "sha256:" + hex.EncodeToString(fingerprint[:]),
+	}
+}
@@ -0,0 +1,140 @@
+package worker_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue/testjobqueue"
+	"github.com/osbuild/osbuild-composer/internal/test"
+	"github.com/osbuild/osbuild-composer/internal/worker"
+)
+
+func tlsConfigFor(dir, ca, name string) *worker.TLSConfig {
+	return &worker.TLSConfig{
+		CACertFile: path.Join(dir, ca+"-crt.pem"),
+		CertFile:   path.Join(dir, name+"-crt.pem"),
+		KeyFile:    path.Join(dir, name+"-key.pem"),
+	}
+}
+
+func TestServeTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker-tls-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca, caKey := test.WriteCertificate(t, dir, "ca", nil, nil)
+	test.WriteCertificate(t, dir, "composer", ca, caKey)
+	test.WriteCertificate(t, dir, "worker", ca, caKey)
+	rogueCA, rogueKey := test.WriteCertificate(t, dir, "rogue-ca", nil, nil)
+	test.WriteCertificate(t, dir, "rogue", rogueCA, rogueKey)
+
+	serverConf, err := tlsConfigFor(dir, "ca", "composer").ServerConfig()
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	server := worker.NewServer(nil, testjobqueue.New(), nil, "")
+	go func() {
+		_ = server.ServeTLS(listener, serverConf)
+	}()
+
+	// the test job queue returns an error when there are no jobs, which
+	// is enough to know that the request got through
+	clientConf, err := tlsConfigFor(dir, "ca", "worker").ClientConfig()
+	require.NoError(t, err)
+	_, err = worker.NewClient(listener.Addr().String(), clientConf).AddJob([]string{"x86_64"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "got 500")
+
+	// a certificate from another CA is rejected
+	rogueConf, err := tlsConfigFor(dir, "ca", "rogue").ClientConfig()
+	require.NoError(t, err)
+	_, err = worker.NewClient(listener.Addr().String(), rogueConf).AddJob([]string{"x86_64"})
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "got 500")
+
+	// so is a client without certificate
+	rogueConf.Certificates = nil
+	_, err = worker.NewClient(listener.Addr().String(), rogueConf).AddJob([]string{"x86_64"})
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "got 500")
+
+	// servers must not accept unauthenticated workers
+	unauthenticated := serverConf.Clone()
+	unauthenticated.ClientAuth = 0
+	require.Error(t, server.ServeTLS(listener, unauthenticated))
+}
+
+func TestWorkerIdentity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker-tls-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca, caKey := test.WriteCertificate(t, dir, "ca", nil, nil)
+	test.WriteCertificate(t, dir, "composer", ca, caKey)
+	workerCert, _ := test.WriteCertificate(t, dir, "worker", ca, caKey)
+
+	serverConf, err := tlsConfigFor(dir, "ca", "composer").ServerConfig()
+	require.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	var uploader, finisher compose.WorkerIdentity
+	server := worker.NewServer(nil, testjobqueue.New(), nil, "")
+	server.SetWorkerRecorders(
+		func(composeID uuid.UUID, imageBuildID int, identity compose.WorkerIdentity) error {
+			uploader = identity
+			return nil
+		},
+		func(jobID uuid.UUID, identity compose.WorkerIdentity) error {
+			finisher = identity
+			return nil
+		})
+	go func() {
+		_ = server.ServeTLS(listener, serverConf)
+	}()
+
+	_, err = server.Enqueue("fedora-30", "x86_64", nil, nil, nil, jobqueue.PriorityNormal)
+	require.NoError(t, err)
+
+	clientConf, err := tlsConfigFor(dir, "ca", "worker").ClientConfig()
+	require.NoError(t, err)
+	client := worker.NewClient(listener.Addr().String(), clientConf)
+	job, err := client.AddJob([]string{"x86_64"})
+	require.NoError(t, err)
+	err = client.UploadImage(uuid.New(), 0, strings.NewReader("image"))
+	require.NoError(t, err)
+	err = client.UpdateJob(job, common.IBFinished, &common.ComposeResult{Success: true}, nil)
+	require.NoError(t, err)
+
+	fingerprint := sha256.Sum256(workerCert.Raw)
+	expected := compose.WorkerIdentity{
+		Subject:     "CN=worker",
+		Fingerprint: "sha256:" + hex.EncodeToString(fingerprint[:]),
+	}
+	require.Equal(t, expected, uploader)
+	require.Equal(t, expected, finisher)
+
+	// workers on the local socket don't authenticate
+	local := httptest.NewServer(server)
+	defer local.Close()
+	err = worker.NewClient(strings.TrimPrefix(local.URL, "http://"), nil).UploadImage(uuid.New(), 0, strings.NewReader("image"))
+	require.NoError(t, err)
+	require.Equal(t, compose.WorkerIdentity{Subject: "local"}, uploader)
+}
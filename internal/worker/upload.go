@@ -0,0 +1,366 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/common"
+	"github.com/osbuild/osbuild-composer/internal/compose"
+	"github.com/osbuild/osbuild-composer/internal/events"
+	"github.com/osbuild/osbuild-composer/internal/logging"
+)
+
+// Images can be uploaded in chunks, so that workers don't have to start over
+// when a connection breaks in the middle of a large upload.
+//
+// Each chunk is sent with PUT and a `Content-Range: bytes first-last/total`
+// header. `total` may be `*` for all but the last chunk. A chunk must start
+// at or before the end of what was received so far; GET returns that offset,
+// which is where a client resumes. The image is passed on to the image
+// writer when its last byte was received.
+
+// Images may be larger than the size that was declared for them, because
+// some formats add headers or footers or round up the size. This tolerance,
+// in percent of the declared size, is added to the maximum size of uploads.
+const imageSizeTolerance = 10
+
+// ImageSizeFunc returns the size that was declared for the image of an image
+// build, or 0 if it is not known.
+type ImageSizeFunc func(composeID uuid.UUID, imageBuildID int) (uint64, error)
+
+// SetImageSizeLimit sets the function which returns the declared size of
+// an image. Uploads of images which exceed it by more than the tolerance are
+// rejected. Uploads are not limited when it isn't set.
+func (s *Server) SetImageSizeLimit(imageSize ImageSizeFunc) {
+	s.imageSize = imageSize
+}
+
+// maxImageSize returns how many bytes the image of an image build may have,
+// or sizeUnknown if there is no limit.
+func (s *Server) maxImageSize(composeID uuid.UUID, imageBuildID int) (int64, error) {
+	if s.imageSize == nil {
+		return sizeUnknown, nil
+	}
+
+	size, err := s.imageSize(composeID, imageBuildID)
+	if err != nil {
+		return 0, err
+	}
+	if size == 0 {
+		return sizeUnknown, nil
+	}
+
+	return int64(size + size*imageSizeTolerance/100), nil
+}
+
+var errImageTooLarge = errors.New("image exceeds its declared size")
+
+// limitedReader reads from `reader` and fails with errImageTooLarge as soon
+// as more than `remaining` bytes are read.
+type limitedReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n, errImageTooLarge
+	}
+	return n, err
+}
+
+// PartialUploadsSize returns how many bytes of images that are uploaded in
+// chunks were received so far. They count towards the disk quota just as
+// complete images, because they will become complete images eventually.
+func (s *Server) PartialUploadsSize() int64 {
+	names, err := filepath.Glob(filepath.Join(s.uploadDir, "*.part"))
+	if err != nil {
+		return 0
+	}
+
+	var size int64
+	for _, name := range names {
+		info, err := os.Stat(name)
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+	}
+
+	return size
+}
+
+// sizeUnknown is the total size of a content range of the form
+// "bytes first-last/*".
+const sizeUnknown = -1
+
+func parseContentRange(header string) (first, last, total int64, err error) {
+	spec := strings.TrimPrefix(header, "bytes ")
+	if spec == header {
+		return 0, 0, 0, errors.New("only byte ranges are supported")
+	}
+
+	slash := strings.IndexByte(spec, '/')
+	dash := strings.IndexByte(spec, '-')
+	if slash < 0 || dash < 0 || dash > slash {
+		return 0, 0, 0, fmt.Errorf("malformed content range: %s", header)
+	}
+
+	first, err = strconv.ParseInt(spec[:dash], 10, 64)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("malformed content range: %s", header)
+	}
+
+	last, err = strconv.ParseInt(spec[dash+1:slash], 10, 64)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("malformed content range: %s", header)
+	}
+
+	if spec[slash+1:] == "*" {
+		total = sizeUnknown
+	} else {
+		total, err = strconv.ParseInt(spec[slash+1:], 10, 64)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("malformed content range: %s", header)
+		}
+	}
+
+	if first < 0 || last < first || (total != sizeUnknown && last >= total) {
+		return 0, 0, 0, fmt.Errorf("invalid content range: %s", header)
+	}
+
+	return first, last, total, nil
+}
+
+func partialUploadName(id uuid.UUID, imageBuildId int) string {
+	return fmt.Sprintf("%s-%d.part", id, imageBuildId)
+}
+
+// lockUpload serializes requests for the same upload. It returns a function
+// that releases the lock.
+func (s *Server) lockUpload(name string) func() {
+	s.uploadsMutex.Lock()
+	m, exists := s.uploads[name]
+	if !exists {
+		m = &sync.Mutex{}
+		s.uploads[name] = m
+	}
+	s.uploadsMutex.Unlock()
+
+	m.Lock()
+	return m.Unlock
+}
+
+// forgetUpload must only be called while holding the upload's lock.
+func (s *Server) forgetUpload(name string) {
+	s.uploadsMutex.Lock()
+	delete(s.uploads, name)
+	s.uploadsMutex.Unlock()
+}
+
+// writeImage passes the image of an image build to the image writer and
+// records that `uploader` uploaded it. The image must match `checksum`,
+// unless it is "".
+func (s *Server) writeImage(logger *logging.Logger, id uuid.UUID, imageBuildId int, reader io.Reader, checksum string, uploader compose.WorkerIdentity) error {
+	if checksum != "" {
+		reader = newChecksumReader(reader, checksum)
+	}
+
+	reader, err := s.checkImageFormat(id, imageBuildId, reader)
+	if err == nil {
+		if s.imageWriter == nil {
+			_, err = io.Copy(ioutil.Discard, reader)
+		} else {
+			err = s.imageWriter(id, imageBuildId, reader, checksum)
+		}
+	}
+
+	if err != nil {
+		logger.Error("uploading image failed", "compose_id", id, "image_build_id", imageBuildId, "error", err)
+		events.Emit(events.ImageUploadFailed, fmt.Sprintf("Uploading image of compose %s failed: %v", id, err),
+			"COMPOSE_ID", id.String(),
+			"IMAGE_BUILD_ID", strconv.Itoa(imageBuildId),
+			"ERROR", err.Error())
+	} else {
+		logger.Info("image uploaded", "compose_id", id, "image_build_id", imageBuildId, "worker", uploader.Subject)
+		events.Emit(events.ImageUploaded, fmt.Sprintf("Image of compose %s uploaded", id),
+			"COMPOSE_ID", id.String(),
+			"IMAGE_BUILD_ID", strconv.Itoa(imageBuildId))
+
+		if s.uploaderRecorder != nil {
+			recordErr := s.uploaderRecorder(id, imageBuildId, uploader)
+			if recordErr != nil {
+				logger.Warning("cannot record worker", "compose_id", id, "image_build_id", imageBuildId, "error", recordErr)
+			}
+		}
+	}
+
+	return err
+}
+
+func parseImageParams(writer http.ResponseWriter, params httprouter.Params) (uuid.UUID, int, bool) {
+	id, err := uuid.Parse(params.ByName("job_id"))
+	if err != nil {
+		jsonErrorf(writer, common.ErrorInvalidRequest, "cannot parse compose id: %v", err)
+		return uuid.Nil, 0, false
+	}
+
+	imageBuildId, err := strconv.Atoi(params.ByName("build_id"))
+	if err != nil {
+		jsonErrorf(writer, common.ErrorInvalidRequest, "cannot parse image build id: %v", err)
+		return uuid.Nil, 0, false
+	}
+
+	return id, imageBuildId, true
+}
+
+func (s *Server) jobImageUploadStatusHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	id, imageBuildId, ok := parseImageParams(writer, params)
+	if !ok {
+		return
+	}
+
+	name := partialUploadName(id, imageBuildId)
+	unlock := s.lockUpload(name)
+	defer unlock()
+
+	var offset int64
+	info, err := os.Stat(filepath.Join(s.uploadDir, name))
+	if err == nil {
+		offset = info.Size()
+	} else if !os.IsNotExist(err) {
+		jsonErrorf(writer, common.ErrorInternal, "%v", err)
+		return
+	}
+
+	_ = json.NewEncoder(writer).Encode(uploadStatusResponse{Offset: offset})
+}
+
+func (s *Server) addJobImageChunkHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	id, imageBuildId, ok := parseImageParams(writer, params)
+	if !ok {
+		return
+	}
+
+	first, last, total, err := parseContentRange(request.Header.Get("Content-Range"))
+	if err != nil {
+		jsonErrorf(writer, common.ErrorInvalidRequest, "%v", err)
+		return
+	}
+
+	// Only the digest sent with the last chunk counts
+	checksum, err := parseDigestHeader(request.Header.Get("Digest"))
+	if err != nil {
+		jsonErrorf(writer, common.ErrorInvalidRequest, "%v", err)
+		return
+	}
+
+	maxSize, err := s.maxImageSize(id, imageBuildId)
+	if err != nil {
+		jsonErrorf(writer, common.ErrorInternal, "%v", err)
+		return
+	}
+	if maxSize != sizeUnknown && (total > maxSize || last >= maxSize) {
+		jsonErrorf(writer, common.ErrorImageTooLarge, "image must not be larger than %d bytes", maxSize)
+		return
+	}
+
+	name := partialUploadName(id, imageBuildId)
+	unlock := s.lockUpload(name)
+	defer unlock()
+
+	partialPath := filepath.Join(s.uploadDir, name)
+	file, err := os.OpenFile(partialPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		jsonErrorf(writer, common.ErrorInternal, "%v", err)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		jsonErrorf(writer, common.ErrorInternal, "%v", err)
+		return
+	}
+
+	if first > info.Size() {
+		jsonErrorf(writer, common.ErrorUploadOffsetMismatch, "chunk starts at %d, but only %d bytes were received", first, info.Size())
+		return
+	}
+
+	// A chunk overlapping data that was already received replaces it. This
+	// happens when a client retries a chunk after the response to the
+	// previous attempt got lost.
+	err = file.Truncate(first)
+	if err == nil {
+		_, err = file.Seek(first, io.SeekStart)
+	}
+	if err != nil {
+		jsonErrorf(writer, common.ErrorInternal, "%v", err)
+		return
+	}
+
+	length := last - first + 1
+	n, err := io.CopyN(file, request.Body, length)
+	if err != nil {
+		jsonErrorf(writer, common.ErrorInvalidRequest, "received only %d of %d bytes: %v", n, length, err)
+		return
+	}
+
+	if total == sizeUnknown || last+1 < total {
+		_ = json.NewEncoder(writer).Encode(uploadStatusResponse{Offset: last + 1})
+		return
+	}
+
+	s.completeUpload(writer, logging.FromContext(request.Context()), id, imageBuildId, file, total, checksum, workerIdentity(request))
+}
+
+// completeUpload passes the complete image in the partial upload `file` to
+// the image writer, removes the file, and writes the response. It must only
+// be called while holding the upload's lock.
+func (s *Server) completeUpload(writer http.ResponseWriter, logger *logging.Logger, id uuid.UUID, imageBuildId int, file *os.File, size int64, checksum string, uploader compose.WorkerIdentity) {
+	name := partialUploadName(id, imageBuildId)
+	partialPath := filepath.Join(s.uploadDir, name)
+
+	_, err := file.Seek(0, io.SeekStart)
+	if err == nil {
+		err = s.writeImage(logger, id, imageBuildId, file, checksum, uploader)
+	}
+	if _, ok := err.(*formatMismatchError); ok {
+		// Start over when the client retries
+		_ = os.Remove(partialPath)
+		s.forgetUpload(name)
+		jsonErrorf(writer, common.ErrorImageFormatMismatch, "%v", err)
+		return
+	} else if _, ok := err.(*checksumMismatchError); ok {
+		_ = os.Remove(partialPath)
+		s.forgetUpload(name)
+		jsonErrorf(writer, common.ErrorImageChecksumMismatch, "%v", err)
+		return
+	} else if err != nil {
+		jsonErrorf(writer, common.ErrorInternal, "%v", err)
+		return
+	}
+
+	err = os.Remove(partialPath)
+	if err != nil {
+		jsonErrorf(writer, common.ErrorInternal, "%v", err)
+		return
+	}
+	s.forgetUpload(name)
+
+	_ = json.NewEncoder(writer).Encode(uploadStatusResponse{Offset: size, Complete: true})
+}
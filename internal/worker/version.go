@@ -0,0 +1,111 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Job arguments and results are versioned, so that composer and workers of
+// different versions keep working together while they are upgraded one after
+// another. Both sides accept all versions up to their own, and upgrade older
+// ones when they read them:
+//
+//	1: arguments and results from before they had a version
+//	2: jobs can depend on other jobs, whose results workers receive as
+//	   inputs, and results explain why a job could not be run
+//
+// Composer doesn't hand jobs to workers which don't support the version the
+// job needs (see OSBuildJob.requiredVersion()), but fails them, and workers
+// fail jobs they cannot read (see JobError), instead of leaving them running
+// forever.
+//
+// Workers report results in the newest version both sides support, which
+// they learn from composer's info (see info.go).
+const JobVersion = 2
+
+// An UnsupportedVersionError is returned when reading job arguments or
+// results of a newer version than JobVersion.
+type UnsupportedVersionError struct {
+	Version int
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("unsupported version %d, only versions up to %d are supported", e.Version, JobVersion)
+}
+
+func (job *OSBuildJob) UnmarshalJSON(data []byte) error {
+	type osbuildJob OSBuildJob
+	var j osbuildJob
+	err := json.Unmarshal(data, &j)
+	if err != nil {
+		return err
+	}
+	if j.Version > JobVersion {
+		return &UnsupportedVersionError{j.Version}
+	}
+
+	*job = OSBuildJob(j)
+	job.upgrade()
+	return nil
+}
+
+// upgrade converts `job` from its version to JobVersion.
+func (job *OSBuildJob) upgrade() {
+	if job.Version == 0 {
+		job.Version = 1
+	}
+
+	// Version 2 only added fields, which are empty in older jobs
+	if job.Version == 1 {
+		job.Version = 2
+	}
+}
+
+// requiredVersion returns the oldest version which can express `job`.
+// Workers which only support older versions would build it wrongly.
+func (job *OSBuildJob) requiredVersion() int {
+	if len(job.Dependencies) > 0 {
+		return 2
+	}
+	return 1
+}
+
+func (result *OSBuildJobResult) UnmarshalJSON(data []byte) error {
+	type osbuildJobResult OSBuildJobResult
+	var r osbuildJobResult
+	err := json.Unmarshal(data, &r)
+	if err != nil {
+		return err
+	}
+	if r.Version > JobVersion {
+		return &UnsupportedVersionError{r.Version}
+	}
+
+	*result = OSBuildJobResult(r)
+	result.upgrade()
+	return nil
+}
+
+// upgrade converts `result` from its version to JobVersion.
+func (result *OSBuildJobResult) upgrade() {
+	if result.Version == 0 {
+		result.Version = 1
+	}
+
+	// Version 2 only added fields, which are empty in older results
+	if result.Version == 1 {
+		result.Version = 2
+	}
+}
+
+// A JobError is returned by Client.AddJob() when the worker got a job which
+// it cannot read, because composer is newer than the worker. The worker
+// should fail the job with Client.FailJob().
+type JobError struct {
+	Job *Job
+	Err error
+}
+
+func (e *JobError) Error() string {
+	return fmt.Sprintf("cannot read job %s: %v", e.Job.Id, e.Err)
+}
@@ -76,6 +76,7 @@ export GOFLAGS=-mod=vendor
 
 %gobuild -o _bin/osbuild-composer %{goipath}/cmd/osbuild-composer
 %gobuild -o _bin/osbuild-worker %{goipath}/cmd/osbuild-worker
+%gobuild -o _bin/osbuild-jobqueue %{goipath}/cmd/osbuild-jobqueue
 
 
 %if %{with tests}
@@ -105,6 +106,7 @@ go test -c -tags=integration -ldflags="${TEST_LDFLAGS}" -o _bin/osbuild-image-te
 install -m 0755 -vd                                         %{buildroot}%{_libexecdir}/osbuild-composer
 install -m 0755 -vp _bin/osbuild-composer                   %{buildroot}%{_libexecdir}/osbuild-composer/
 install -m 0755 -vp _bin/osbuild-worker                     %{buildroot}%{_libexecdir}/osbuild-composer/
+install -m 0755 -vp _bin/osbuild-jobqueue                   %{buildroot}%{_libexecdir}/osbuild-composer/
 install -m 0755 -vp dnf-json                                %{buildroot}%{_libexecdir}/osbuild-composer/
 
 install -m 0755 -vd                                         %{buildroot}%{_datadir}/osbuild-composer/repositories
@@ -113,6 +115,12 @@ install -m 0644 -vp repositories/*                          %{buildroot}%{_datad
 install -m 0755 -vd                                         %{buildroot}%{_unitdir}
 install -m 0644 -vp distribution/*.{service,socket}         %{buildroot}%{_unitdir}/
 
+install -m 0755 -vd                                         %{buildroot}%{_prefix}/lib/systemd/catalog
+install -m 0644 -vp distribution/osbuild-composer.catalog   %{buildroot}%{_prefix}/lib/systemd/catalog/
+
+install -m 0755 -vd                                         %{buildroot}%{_datadir}/dbus-1/system.d
+install -m 0644 -vp distribution/org.osbuild.Composer1.conf %{buildroot}%{_datadir}/dbus-1/system.d/
+
 install -m 0755 -vd                                         %{buildroot}%{_sysusersdir}
 install -m 0644 -vp distribution/osbuild-composer.conf      %{buildroot}%{_sysusersdir}/
 
@@ -163,11 +171,17 @@ export GOPATH=$PWD/_build:%{gopath}
 %license LICENSE
 %doc README.md
 %{_libexecdir}/osbuild-composer/osbuild-composer
+%{_libexecdir}/osbuild-composer/osbuild-jobqueue
 %{_libexecdir}/osbuild-composer/dnf-json
 %{_datadir}/osbuild-composer/
 %{_unitdir}/osbuild-composer.service
 %{_unitdir}/osbuild-composer.socket
 %{_unitdir}/osbuild-remote-worker.socket
+%{_unitdir}/osbuild-replication.socket
+%{_unitdir}/osbuild-gallery.socket
+%{_unitdir}/osbuild-cloudapi.socket
+%{_prefix}/lib/systemd/catalog/osbuild-composer.catalog
+%{_datadir}/dbus-1/system.d/org.osbuild.Composer1.conf
 %{_sysusersdir}/osbuild-composer.conf
 
 %package rcm